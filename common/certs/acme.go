@@ -0,0 +1,276 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"golang.org/x/crypto/acme"
+)
+
+// ACMEConfig of certs issued by DNS-01 challenges, for servers not exposing port 80
+type ACMEConfig struct {
+	//names of the cert, wildcard like '*.example.com' allowed, empty disables
+	Domains []string
+	Email   string
+	//default Let's Encrypt
+	Directory string
+	//account key & issued certs are kept in the dir, default 'acme'
+	CacheDir string
+	DNS      DNSProviderConfig
+	//wait after TXT records presented before asking validation, default 60
+	PropagationSecs int
+	//renew when the cert expires in days, default 30
+	RenewDays int
+}
+
+func (conf *ACMEConfig) adjust() {
+	if len(conf.Directory) == 0 {
+		conf.Directory = acme.LetsEncryptURL
+	}
+	if len(conf.CacheDir) == 0 {
+		conf.CacheDir = "acme"
+	}
+	if conf.PropagationSecs <= 0 {
+		conf.PropagationSecs = 60
+	}
+	if conf.RenewDays <= 0 {
+		conf.RenewDays = 30
+	}
+}
+
+// Manager keep the cert of domains issued & renewed, tls listeners get the latest one by GetCertificate
+type Manager struct {
+	conf     ACMEConfig
+	provider DNSProvider
+	//*tls.Certificate
+	cert atomic.Value
+}
+
+func NewManager(conf ACMEConfig) (*Manager, error) {
+	if len(conf.Domains) == 0 {
+		return nil, errors.New("no 'Domains' to issue cert")
+	}
+	conf.adjust()
+	provider, err := newDNSProvider(&conf.DNS)
+	if nil != err {
+		return nil, err
+	}
+	if err = os.MkdirAll(conf.CacheDir, 0700); nil != err {
+		return nil, err
+	}
+	return &Manager{conf: conf, provider: provider}, nil
+}
+
+// GetCertificate is the callback of tls.Config, renewed certs are served without restarting listeners
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, ok := m.cert.Load().(*tls.Certificate); ok {
+		return cert, nil
+	}
+	return nil, errors.New("acme cert is not issued yet")
+}
+
+// Start load the cached cert or issue one in background, then renew it before expiring
+func (m *Manager) Start() {
+	if cert, err := m.loadCert(); nil == err {
+		m.cert.Store(cert)
+		logger.Notice("Loaded acme cert of %v expires at %v", m.conf.Domains, cert.Leaf.NotAfter)
+	}
+	go func() {
+		for {
+			next := 12 * time.Hour
+			if m.needRenew() {
+				if err := m.issue(); nil != err {
+					logger.Error("[ERROR]Failed to issue acme cert of %v with reason:%v", m.conf.Domains, err)
+					next = 10 * time.Minute
+				}
+			}
+			time.Sleep(next)
+		}
+	}()
+}
+
+func (m *Manager) needRenew() bool {
+	cert, ok := m.cert.Load().(*tls.Certificate)
+	if !ok {
+		return true
+	}
+	return time.Now().Add(time.Duration(m.conf.RenewDays) * 24 * time.Hour).After(cert.Leaf.NotAfter)
+}
+
+// cacheName is shared by the cert & key files of the domains
+func (m *Manager) cacheName() string {
+	domains := append([]string{}, m.conf.Domains...)
+	sort.Strings(domains)
+	return filepath.Join(m.conf.CacheDir, strings.Replace(strings.Join(domains, "+"), "*", "_", -1))
+}
+
+func (m *Manager) loadCert() (*tls.Certificate, error) {
+	name := m.cacheName()
+	cert, err := tls.LoadX509KeyPair(name+".crt", name+".key")
+	if nil != err {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); nil != err {
+		return nil, err
+	}
+	for _, domain := range m.conf.Domains {
+		if err = cert.Leaf.VerifyHostname(strings.Replace(domain, "*", "wildcard", 1)); nil != err {
+			//domains changed since issued
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+func writeKey(file string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if nil != err {
+		return err
+	}
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// accountKey load the account key, generated & saved on first issuing
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	file := filepath.Join(m.conf.CacheDir, "account.key")
+	if b, err := ioutil.ReadFile(file); nil == err {
+		block, _ := pem.Decode(b)
+		if nil == block {
+			return nil, fmt.Errorf("invalid account key:%s", file)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		return nil, err
+	}
+	return key, writeKey(file, key)
+}
+
+// challengeName is the TXT record name of the domain, a wildcard shares the name of its base domain
+func challengeName(domain string) string {
+	return "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+}
+
+func (m *Manager) issue() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	key, err := m.accountKey()
+	if nil != err {
+		return err
+	}
+	client := &acme.Client{Key: key, DirectoryURL: m.conf.Directory}
+	account := &acme.Account{}
+	if len(m.conf.Email) > 0 {
+		account.Contact = []string{"mailto:" + m.conf.Email}
+	}
+	if _, err = client.Register(ctx, account, acme.AcceptTOS); nil != err && err != acme.ErrAccountAlreadyExists {
+		return err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.conf.Domains...))
+	if nil != err {
+		return err
+	}
+	//values of each record name, challenges are accepted after all records presented
+	records := make(map[string][]string)
+	var challenges []*acme.Challenge
+	var authzURLs []string
+	for _, u := range order.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		if nil != err {
+			return err
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		var chal *acme.Challenge
+		for _, c := range z.Challenges {
+			if c.Type == "dns-01" {
+				chal = c
+				break
+			}
+		}
+		if nil == chal {
+			return fmt.Errorf("no dns-01 challenge for %s", z.Identifier.Value)
+		}
+		value, err := client.DNS01ChallengeRecord(chal.Token)
+		if nil != err {
+			return err
+		}
+		name := challengeName(z.Identifier.Value)
+		records[name] = append(records[name], value)
+		challenges = append(challenges, chal)
+		authzURLs = append(authzURLs, z.URI)
+	}
+	for name, values := range records {
+		if err = m.provider.Present(name, values); nil != err {
+			return fmt.Errorf("present TXT record %s failed:%v", name, err)
+		}
+		defer func(name string, values []string) {
+			if err := m.provider.CleanUp(name, values); nil != err {
+				logger.Error("[ERROR]Failed to clean up TXT record %s with reason:%v", name, err)
+			}
+		}(name, values)
+	}
+	if len(records) > 0 {
+		logger.Notice("Wait %ds for TXT records of %v propagated", m.conf.PropagationSecs, m.conf.Domains)
+		time.Sleep(time.Duration(m.conf.PropagationSecs) * time.Second)
+	}
+	for i, chal := range challenges {
+		if _, err = client.Accept(ctx, chal); nil != err {
+			return err
+		}
+		if _, err = client.WaitAuthorization(ctx, authzURLs[i]); nil != err {
+			return err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); nil != err {
+		return err
+	}
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.conf.Domains}, certKey)
+	if nil != err {
+		return err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if nil != err {
+		return err
+	}
+	cert := &tls.Certificate{Certificate: der, PrivateKey: certKey}
+	if cert.Leaf, err = x509.ParseCertificate(der[0]); nil != err {
+		return err
+	}
+	var chain []byte
+	for _, b := range der {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	name := m.cacheName()
+	if err = writeKey(name+".key", certKey); nil != err {
+		return err
+	}
+	if err = ioutil.WriteFile(name+".crt", chain, 0644); nil != err {
+		return err
+	}
+	m.cert.Store(cert)
+	logger.Notice("Issued acme cert of %v expires at %v", m.conf.Domains, cert.Leaf.NotAfter)
+	return nil
+}
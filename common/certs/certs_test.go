@@ -0,0 +1,44 @@
+package certs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChallengeName(t *testing.T) {
+	if challengeName("*.example.com") != "_acme-challenge.example.com" || challengeName("a.example.com") != "_acme-challenge.a.example.com" {
+		t.Errorf("unexpected challenge names")
+	}
+}
+
+func TestExecProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "dns.sh")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $2 $3\" >> "+out+"\n"), 0755)
+	p, err := newDNSProvider(&DNSProviderConfig{Type: "Exec", Command: script})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = p.Present("_acme-challenge.example.com", []string{"v1", "v2"}); nil != err {
+		t.Fatal(err)
+	}
+	if err = p.CleanUp("_acme-challenge.example.com", []string{"v1"}); nil != err {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(out)
+	expected := "present _acme-challenge.example.com v1\npresent _acme-challenge.example.com v2\ncleanup _acme-challenge.example.com v1\n"
+	if string(b) != expected {
+		t.Errorf("unexpected calls:%s", b)
+	}
+	if _, err = newDNSProvider(&DNSProviderConfig{Type: "unknown"}); nil == err || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("expect unsupported provider error, but got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package certs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+type cloudflareProvider struct {
+	token  string
+	zoneID string
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+type cloudflareResponse struct {
+	Success bool            `json:"success"`
+	Errors  json.RawMessage `json:"errors"`
+	Result  json.RawMessage `json:"result"`
+}
+
+func newCloudflareProvider(conf *DNSProviderConfig) (DNSProvider, error) {
+	if len(conf.Token) == 0 || len(conf.ZoneID) == 0 {
+		return nil, fmt.Errorf("'Token' & 'ZoneID' required by cloudflare dns provider")
+	}
+	return &cloudflareProvider{token: conf.Token, zoneID: conf.ZoneID}, nil
+}
+
+func (p *cloudflareProvider) call(method, path string, body interface{}, result interface{}) error {
+	var buf bytes.Buffer
+	if nil != body {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+path, &buf)
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := apiClient.Do(req)
+	if nil != err {
+		return err
+	}
+	defer res.Body.Close()
+	var cres cloudflareResponse
+	if err = json.NewDecoder(res.Body).Decode(&cres); nil != err {
+		return err
+	}
+	if !cres.Success {
+		return fmt.Errorf("cloudflare api error:%s", string(cres.Errors))
+	}
+	if nil != result {
+		return json.Unmarshal(cres.Result, result)
+	}
+	return nil
+}
+
+func (p *cloudflareProvider) Present(name string, values []string) error {
+	for _, v := range values {
+		r := &cloudflareRecord{Type: "TXT", Name: name, Content: v, TTL: 120}
+		if err := p.call("POST", "/zones/"+p.zoneID+"/dns_records", r, nil); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *cloudflareProvider) CleanUp(name string, values []string) error {
+	var records []cloudflareRecord
+	err := p.call("GET", "/zones/"+p.zoneID+"/dns_records?type=TXT&name="+url.QueryEscape(name), nil, &records)
+	if nil != err {
+		return err
+	}
+	for _, r := range records {
+		for _, v := range values {
+			if r.Content == v {
+				if err = p.call("DELETE", "/zones/"+p.zoneID+"/dns_records/"+r.ID, nil, nil); nil != err {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
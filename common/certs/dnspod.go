@@ -0,0 +1,132 @@
+package certs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const dnspodAPI = "https://dnsapi.cn/"
+
+type dnspodProvider struct {
+	token    string
+	domainID string
+	//name of the domain, records are created by sub domains of it
+	domain string
+}
+
+type dnspodStatus struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type dnspodRecord struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newDNSPodProvider(conf *DNSProviderConfig) (DNSProvider, error) {
+	if len(conf.Token) == 0 || len(conf.ZoneID) == 0 {
+		return nil, fmt.Errorf("'Token'(as 'ID,Token') & 'ZoneID'(domain id) required by dnspod dns provider")
+	}
+	return &dnspodProvider{token: conf.Token, domainID: conf.ZoneID}, nil
+}
+
+func (p *dnspodProvider) call(action string, params url.Values, result interface{}) error {
+	params.Set("login_token", p.token)
+	params.Set("format", "json")
+	params.Set("domain_id", p.domainID)
+	res, err := apiClient.PostForm(dnspodAPI+action, params)
+	if nil != err {
+		return err
+	}
+	defer res.Body.Close()
+	var body json.RawMessage
+	if err = json.NewDecoder(res.Body).Decode(&body); nil != err {
+		return err
+	}
+	var st struct {
+		Status dnspodStatus `json:"status"`
+	}
+	if err = json.Unmarshal(body, &st); nil != err {
+		return err
+	}
+	//'10' means no record of List
+	if st.Status.Code != "1" && !(action == "Record.List" && st.Status.Code == "10") {
+		return fmt.Errorf("dnspod api %s error:%s %s", action, st.Status.Code, st.Status.Message)
+	}
+	if nil != result {
+		return json.Unmarshal(body, result)
+	}
+	return nil
+}
+
+// subDomain return the name relative to the domain of 'ZoneID'
+func (p *dnspodProvider) subDomain(name string) (string, error) {
+	if len(p.domain) == 0 {
+		var info struct {
+			Domain struct {
+				Name string `json:"name"`
+			} `json:"domain"`
+		}
+		if err := p.call("Domain.Info", url.Values{}, &info); nil != err {
+			return "", err
+		}
+		p.domain = info.Domain.Name
+	}
+	name = strings.TrimSuffix(name, ".")
+	if !strings.HasSuffix(name, "."+p.domain) {
+		return "", fmt.Errorf("%s is not in dnspod domain:%s", name, p.domain)
+	}
+	return strings.TrimSuffix(name, "."+p.domain), nil
+}
+
+func (p *dnspodProvider) Present(name string, values []string) error {
+	sub, err := p.subDomain(name)
+	if nil != err {
+		return err
+	}
+	for _, v := range values {
+		params := url.Values{}
+		params.Set("sub_domain", sub)
+		params.Set("record_type", "TXT")
+		params.Set("record_line_id", "0")
+		params.Set("value", v)
+		params.Set("ttl", "600")
+		if err = p.call("Record.Create", params, nil); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *dnspodProvider) CleanUp(name string, values []string) error {
+	sub, err := p.subDomain(name)
+	if nil != err {
+		return err
+	}
+	params := url.Values{}
+	params.Set("sub_domain", sub)
+	params.Set("record_type", "TXT")
+	var list struct {
+		Records []dnspodRecord `json:"records"`
+	}
+	if err = p.call("Record.List", params, &list); nil != err {
+		return err
+	}
+	for _, r := range list.Records {
+		for _, v := range values {
+			if r.Value == v {
+				params := url.Values{}
+				params.Set("record_id", r.ID)
+				if err = p.call("Record.Remove", params, nil); nil != err {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package certs
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// DNSProvider publish TXT records of DNS-01 challenges, all values of a name are presented at once
+// since one name may be challenged by both a domain & its wildcard
+type DNSProvider interface {
+	Present(name string, values []string) error
+	CleanUp(name string, values []string) error
+}
+
+// DNSProviderConfig of the dns api, fields used depend on 'Type'
+type DNSProviderConfig struct {
+	//cloudflare/route53/dnspod/exec or a type registered by RegisterDNSProvider
+	Type string
+	//cloudflare api token, dnspod 'ID,Token'
+	Token string
+	//cloudflare zone id, route53 hosted zone id, dnspod domain id
+	ZoneID string
+	//route53 credentials, default from the environment
+	AccessKey string
+	SecretKey string
+	//exec: called as '<Command> present|cleanup <name> <value>' for each value
+	Command string
+	//options of registered providers
+	Options map[string]string
+}
+
+type DNSProviderCreator func(conf *DNSProviderConfig) (DNSProvider, error)
+
+var dnsProviderMutex sync.Mutex
+var dnsProviderTable = make(map[string]DNSProviderCreator)
+
+// RegisterDNSProvider add a provider of other dns services, selected by 'Type' of config
+func RegisterDNSProvider(name string, c DNSProviderCreator) {
+	dnsProviderMutex.Lock()
+	defer dnsProviderMutex.Unlock()
+	dnsProviderTable[strings.ToLower(name)] = c
+}
+
+func newDNSProvider(conf *DNSProviderConfig) (DNSProvider, error) {
+	dnsProviderMutex.Lock()
+	c, exist := dnsProviderTable[strings.ToLower(conf.Type)]
+	dnsProviderMutex.Unlock()
+	if !exist {
+		return nil, fmt.Errorf("Unsupported dns provider:%s", conf.Type)
+	}
+	return c(conf)
+}
+
+// execProvider delegate records to an external command, like scripts of other dns apis
+type execProvider struct {
+	command string
+}
+
+func (p *execProvider) run(action string, name string, values []string) error {
+	for _, v := range values {
+		out, err := exec.Command(p.command, action, name, v).CombinedOutput()
+		if nil != err {
+			return fmt.Errorf("%s %s %s failed:%v %s", p.command, action, name, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+func (p *execProvider) Present(name string, values []string) error {
+	return p.run("present", name, values)
+}
+
+func (p *execProvider) CleanUp(name string, values []string) error {
+	return p.run("cleanup", name, values)
+}
+
+func init() {
+	RegisterDNSProvider("exec", func(conf *DNSProviderConfig) (DNSProvider, error) {
+		if len(conf.Command) == 0 {
+			return nil, fmt.Errorf("'Command' required by exec dns provider")
+		}
+		return &execProvider{command: conf.Command}, nil
+	})
+	RegisterDNSProvider("cloudflare", newCloudflareProvider)
+	RegisterDNSProvider("route53", newRoute53Provider)
+	RegisterDNSProvider("dnspod", newDNSPodProvider)
+}
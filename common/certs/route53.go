@@ -0,0 +1,63 @@
+package certs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+type route53Provider struct {
+	svc    *route53.Route53
+	zoneID string
+}
+
+func newRoute53Provider(conf *DNSProviderConfig) (DNSProvider, error) {
+	if len(conf.ZoneID) == 0 {
+		return nil, fmt.Errorf("'ZoneID' required by route53 dns provider")
+	}
+	awsConf := &aws.Config{Region: aws.String("us-east-1")}
+	if len(conf.AccessKey) > 0 {
+		awsConf.Credentials = credentials.NewStaticCredentials(conf.AccessKey, conf.SecretKey, "")
+	}
+	sess, err := session.NewSession(awsConf)
+	if nil != err {
+		return nil, err
+	}
+	return &route53Provider{svc: route53.New(sess), zoneID: conf.ZoneID}, nil
+}
+
+// change apply the TXT record set of all values & wait it in sync on all route53 servers
+func (p *route53Provider) change(action string, name string, values []string) error {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	rs := &route53.ResourceRecordSet{
+		Name: aws.String(name),
+		Type: aws.String("TXT"),
+		TTL:  aws.Int64(60),
+	}
+	for _, v := range values {
+		rs.ResourceRecords = append(rs.ResourceRecords, &route53.ResourceRecord{Value: aws.String(strconv.Quote(v))})
+	}
+	out, err := p.svc.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(p.zoneID),
+		ChangeBatch:  &route53.ChangeBatch{Changes: []*route53.Change{{Action: aws.String(action), ResourceRecordSet: rs}}},
+	})
+	if nil != err {
+		return err
+	}
+	return p.svc.WaitUntilResourceRecordSetsChanged(&route53.GetChangeInput{Id: out.ChangeInfo.Id})
+}
+
+func (p *route53Provider) Present(name string, values []string) error {
+	return p.change("UPSERT", name, values)
+}
+
+func (p *route53Provider) CleanUp(name string, values []string) error {
+	return p.change("DELETE", name, values)
+}
@@ -0,0 +1,173 @@
+package channel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// AuditLogConfig of json lines recording every proxied stream for compliance & abuse investigation
+type AuditLogConfig struct {
+	//empty disables
+	Path string
+	//rotate the file once it's larger than the size, default '100M'
+	MaxSize string
+	//rotated files kept as 'Path.1' to 'Path.<MaxBackups>', default 5
+	MaxBackups int
+}
+
+// AuditRecord is a line of the audit log written when a stream closed
+type AuditRecord struct {
+	Time        string
+	User        string
+	Session     string
+	SourceIP    string
+	Network     string
+	Destination string
+	//bytes from client to destination & back
+	UpBytes     int64
+	DownBytes   int64
+	DurationMS  int64
+	CloseReason string
+}
+
+type auditWriter struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+var auditLog atomic.Value
+
+// SetAuditLogConfig open the audit log file of the config, the previous one is closed
+func SetAuditLogConfig(cfg AuditLogConfig) error {
+	var w *auditWriter
+	if len(cfg.Path) > 0 {
+		w = &auditWriter{path: cfg.Path, maxSize: 100 * 1024 * 1024, maxBackups: cfg.MaxBackups}
+		if len(cfg.MaxSize) > 0 {
+			v, err := helper.ToBytes(cfg.MaxSize)
+			if nil != err {
+				return fmt.Errorf("invalid audit log MaxSize:%s with reason:%v", cfg.MaxSize, err)
+			}
+			w.maxSize = int64(v)
+		}
+		if w.maxBackups <= 0 {
+			w.maxBackups = 5
+		}
+		if err := w.open(); nil != err {
+			return err
+		}
+	}
+	if old, ok := auditLog.Load().(*auditWriter); ok && nil != old {
+		old.close()
+	}
+	auditLog.Store(w)
+	return nil
+}
+
+func (w *auditWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if nil != err {
+		return err
+	}
+	fi, err := file.Stat()
+	if nil != err {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, fi.Size()
+	return nil
+}
+
+func (w *auditWriter) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if nil != w.file {
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+// rotate shift 'path.N-1' to 'path.N' & so on, the current file becomes 'path.1'
+func (w *auditWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxBackups))
+	for i := w.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	os.Rename(w.path, w.path+".1")
+	return w.open()
+}
+
+func (w *auditWriter) write(r *AuditRecord) {
+	line, _ := json.Marshal(r)
+	line = append(line, '\n')
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if nil == w.file {
+		return
+	}
+	if w.size > 0 && w.size+int64(len(line)) > w.maxSize {
+		if err := w.rotate(); nil != err {
+			logger.Error("[ERROR]Failed to rotate audit log:%s with reason:%v", w.path, err)
+			return
+		}
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if nil != err {
+		logger.Error("[ERROR]Failed to write audit log:%s with reason:%v", w.path, err)
+	}
+}
+
+// streamAudit collect the audit record of a stream, written when the stream is done
+type streamAudit struct {
+	start    time.Time
+	ctx      *sessionContext
+	creq     *mux.ConnectRequest
+	up, down int64
+	reason   string
+}
+
+func newStreamAudit(ctx *sessionContext, creq *mux.ConnectRequest) *streamAudit {
+	return &streamAudit{start: time.Now(), ctx: ctx, creq: creq, reason: "closed"}
+}
+
+// reject the stream & record the reason
+func (a *streamAudit) reject(stream mux.MuxStream, creq *mux.ConnectRequest, reason string) {
+	a.reason = "rejected:" + reason
+	rejectStream(stream, creq, reason)
+}
+
+func (a *streamAudit) emit() {
+	w, ok := auditLog.Load().(*auditWriter)
+	if !ok || nil == w {
+		return
+	}
+	r := &AuditRecord{
+		Time:        a.start.Format(time.RFC3339),
+		Session:     a.ctx.sessionID(),
+		SourceIP:    a.ctx.sourceIP(),
+		Network:     a.creq.Network,
+		Destination: a.creq.Addr,
+		UpBytes:     a.up,
+		DownBytes:   a.down,
+		DurationMS:  int64(time.Now().Sub(a.start) / time.Millisecond),
+		CloseReason: a.reason,
+	}
+	if nil != a.ctx.auth {
+		r.User = a.ctx.auth.User
+	}
+	w.write(r)
+}
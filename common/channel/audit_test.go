@@ -0,0 +1,56 @@
+package channel
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestAuditLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	if err = SetAuditLogConfig(AuditLogConfig{Path: path, MaxSize: "1K", MaxBackups: 2}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetAuditLogConfig(AuditLogConfig{})
+	ctx := newSessionContext(nil, &mux.AuthRequest{User: "alice", SessionID: "s1"})
+	for i := 0; i < 50; i++ {
+		a := newStreamAudit(ctx, &mux.ConnectRequest{Network: "tcp", Addr: "example.com:443"})
+		a.up, a.down = 100, 2000
+		a.emit()
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(name)
+		if nil != err || fi.Size() > 1024 {
+			t.Errorf("expect %s no larger than 1K, but got %v", name, err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 backups should be kept")
+	}
+	f, err := os.Open(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatalf("empty audit log")
+	}
+	var r AuditRecord
+	if err = json.Unmarshal(scanner.Bytes(), &r); nil != err {
+		t.Fatal(err)
+	}
+	if r.User != "alice" || r.Session != "s1" || r.Destination != "example.com:443" || r.UpBytes != 100 || r.DownBytes != 2000 || r.CloseReason != "closed" {
+		t.Errorf("unexpected audit record:%+v", r)
+	}
+}
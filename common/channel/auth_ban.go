@@ -0,0 +1,229 @@
+package channel
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/store"
+)
+
+// AuthBanConfig of banning source ips failed to auth too many times, to resist credential scanning
+type AuthBanConfig struct {
+	//ban ips failed 'MaxFailures' times within 'WindowSecs'(0 means default 600), 0 disables banning
+	MaxFailures int
+	WindowSecs  int
+	//0 means default 3600
+	BanSecs int
+	//ips, CIDRs or 'ipset:<name>' never banned, like the addresses of CDN edges
+	Exempt []string
+}
+
+func (conf *AuthBanConfig) window() time.Duration {
+	if conf.WindowSecs <= 0 {
+		return 600 * time.Second
+	}
+	return time.Duration(conf.WindowSecs) * time.Second
+}
+
+func (conf *AuthBanConfig) banDuration() time.Duration {
+	if conf.BanSecs <= 0 {
+		return 3600 * time.Second
+	}
+	return time.Duration(conf.BanSecs) * time.Second
+}
+
+// AuthBanInfo is the state of a banned ip
+type AuthBanInfo struct {
+	IP         string
+	Failures   int
+	RemainSecs int64
+}
+
+type authFailures struct {
+	first time.Time
+	count int
+}
+
+type authBan struct {
+	failures int
+	expire   time.Time
+}
+
+const authBanStoreBucket = "auth_ban"
+
+// persistedAuthBan is saved in the store, so that bans survive restarts
+type persistedAuthBan struct {
+	Failures int
+	Expire   int64
+}
+
+func saveAuthBan(ip string, ban *authBan) {
+	b, _ := json.Marshal(&persistedAuthBan{Failures: ban.failures, Expire: ban.expire.Unix()})
+	if err := store.Default().Put(authBanStoreBucket, ip, b); nil != err {
+		logger.Error("[ERROR]Failed to save ban of %s with reason:%v", ip, err)
+	}
+}
+
+func removeAuthBan(ip string) {
+	if err := store.Default().Delete(authBanStoreBucket, ip); nil != err {
+		logger.Error("[ERROR]Failed to remove ban of %s with reason:%v", ip, err)
+	}
+}
+
+// failures of ips are only tracked within the window, stale ones are pruned once too many ips tracked
+const maxTrackedAuthFailures = 10000
+
+type authBanList struct {
+	mutex    sync.Mutex
+	conf     AuthBanConfig
+	exempt   *helper.HostMatcher
+	failures map[string]*authFailures
+	bans     map[string]*authBan
+}
+
+var authBans = newAuthBanList()
+
+func newAuthBanList() *authBanList {
+	return &authBanList{
+		exempt:   helper.NewHostMatcher(nil),
+		failures: make(map[string]*authFailures),
+		bans:     make(map[string]*authBan),
+	}
+}
+
+// SetAuthBanConfig apply the config, ips banned already are kept until expired or unbanned
+func SetAuthBanConfig(cfg AuthBanConfig) {
+	authBans.mutex.Lock()
+	defer authBans.mutex.Unlock()
+	authBans.conf = cfg
+	authBans.exempt = helper.NewHostMatcher(cfg.Exempt)
+}
+
+func (l *authBanList) banned(ip string, now time.Time) bool {
+	if len(ip) == 0 {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ban, exist := l.bans[ip]
+	if !exist {
+		return false
+	}
+	if now.After(ban.expire) {
+		delete(l.bans, ip)
+		removeAuthBan(ip)
+		return false
+	}
+	return true
+}
+
+// onFailure count a failed auth of the ip, true is returned if the ip is banned by this failure
+func (l *authBanList) onFailure(ip string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(ip) == 0 || l.conf.MaxFailures <= 0 || l.exempt.Match(ip) {
+		return false
+	}
+	window := l.conf.window()
+	f, exist := l.failures[ip]
+	if !exist || now.Sub(f.first) > window {
+		if len(l.failures) >= maxTrackedAuthFailures {
+			l.prune(now)
+		}
+		f = &authFailures{first: now}
+		l.failures[ip] = f
+	}
+	f.count++
+	if f.count < l.conf.MaxFailures {
+		return false
+	}
+	delete(l.failures, ip)
+	ban := &authBan{failures: f.count, expire: now.Add(l.conf.banDuration())}
+	l.bans[ip] = ban
+	saveAuthBan(ip, ban)
+	logger.Notice("Ban %s for %v after %d auth failures within %v", ip, l.conf.banDuration(), f.count, window)
+	return true
+}
+
+func (l *authBanList) prune(now time.Time) {
+	window := l.conf.window()
+	for ip, f := range l.failures {
+		if now.Sub(f.first) > window {
+			delete(l.failures, ip)
+		}
+	}
+	for ip, ban := range l.bans {
+		if now.After(ban.expire) {
+			delete(l.bans, ip)
+			removeAuthBan(ip)
+		}
+	}
+}
+
+// LoadAuthBans restore the bans saved in the store, expired ones are removed from the store
+func LoadAuthBans() {
+	now := time.Now()
+	var expired []string
+	authBans.mutex.Lock()
+	defer authBans.mutex.Unlock()
+	err := store.Default().ForEach(authBanStoreBucket, func(ip string, value []byte) bool {
+		var ban persistedAuthBan
+		if nil != json.Unmarshal(value, &ban) || now.Unix() >= ban.Expire {
+			expired = append(expired, ip)
+			return true
+		}
+		authBans.bans[ip] = &authBan{failures: ban.Failures, expire: time.Unix(ban.Expire, 0)}
+		return true
+	})
+	if nil != err {
+		logger.Error("[ERROR]Failed to load auth bans with reason:%v", err)
+	}
+	for _, ip := range expired {
+		removeAuthBan(ip)
+	}
+}
+
+// onAuthFailure count a failed auth of the session's source ip, true is returned if the ip is banned
+func onAuthFailure(ctx *sessionContext) bool {
+	return authBans.onFailure(ctx.sourceIP(), time.Now())
+}
+
+// AuthBanned return true if the ip is banned for auth failures, for protocols authenticating out of mux sessions
+func AuthBanned(ip string) bool {
+	return authBans.banned(ip, time.Now())
+}
+
+// RecordAuthFailure count a failed auth of the ip out of mux sessions, true is returned if the ip is banned
+func RecordAuthFailure(ip string) bool {
+	return authBans.onFailure(ip, time.Now())
+}
+
+// ListAuthBans return ips banned for auth failures
+func ListAuthBans() []AuthBanInfo {
+	authBans.mutex.Lock()
+	defer authBans.mutex.Unlock()
+	now := time.Now()
+	authBans.prune(now)
+	infos := make([]AuthBanInfo, 0, len(authBans.bans))
+	for ip, ban := range authBans.bans {
+		infos = append(infos, AuthBanInfo{IP: ip, Failures: ban.failures, RemainSecs: int64(ban.expire.Sub(now) / time.Second)})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].RemainSecs > infos[j].RemainSecs
+	})
+	return infos
+}
+
+// UnbanIP lift the ban of the ip, false is returned if it's not banned
+func UnbanIP(ip string) bool {
+	authBans.mutex.Lock()
+	defer authBans.mutex.Unlock()
+	_, exist := authBans.bans[ip]
+	delete(authBans.bans, ip)
+	removeAuthBan(ip)
+	return exist
+}
@@ -0,0 +1,81 @@
+package channel
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/store"
+)
+
+func TestAuthBanList(t *testing.T) {
+	l := newAuthBanList()
+	l.conf = AuthBanConfig{MaxFailures: 3, WindowSecs: 60, BanSecs: 600}
+	now := time.Unix(1000, 0)
+	if l.onFailure("1.2.3.4", now) || l.onFailure("1.2.3.4", now.Add(time.Second)) {
+		t.Fatalf("ip should not be banned before 3 failures")
+	}
+	//failures out of the window are not counted
+	if l.onFailure("1.2.3.4", now.Add(2*time.Minute)) || l.banned("1.2.3.4", now.Add(2*time.Minute)) {
+		t.Fatalf("failures out of window should not ban the ip")
+	}
+	l.onFailure("1.2.3.4", now.Add(2*time.Minute))
+	if !l.onFailure("1.2.3.4", now.Add(2*time.Minute)) || !l.banned("1.2.3.4", now.Add(3*time.Minute)) {
+		t.Fatalf("3 failures within window should ban the ip")
+	}
+	if l.banned("1.2.3.5", now) {
+		t.Errorf("other ips should not be banned")
+	}
+	if l.banned("1.2.3.4", now.Add(20*time.Minute)) {
+		t.Errorf("ban should expire after 600 secs")
+	}
+
+	l.exempt = helper.NewHostMatcher([]string{"10.0.0.0/8"})
+	for i := 0; i < 5; i++ {
+		if l.onFailure("10.1.2.3", now) {
+			t.Fatalf("exempt ip should never be banned")
+		}
+	}
+}
+
+func TestListAuthBans(t *testing.T) {
+	SetAuthBanConfig(AuthBanConfig{MaxFailures: 1})
+	defer func() {
+		SetAuthBanConfig(AuthBanConfig{})
+		authBans.bans = make(map[string]*authBan)
+	}()
+	authBans.onFailure("1.2.3.4", time.Now())
+	bans := ListAuthBans()
+	if len(bans) != 1 || bans[0].IP != "1.2.3.4" || bans[0].Failures != 1 || bans[0].RemainSecs < 3590 {
+		t.Fatalf("unexpected bans:%+v", bans)
+	}
+	if !UnbanIP("1.2.3.4") || UnbanIP("1.2.3.4") || authBans.banned("1.2.3.4", time.Now()) {
+		t.Errorf("ip should be unbanned once")
+	}
+}
+
+func TestLoadAuthBans(t *testing.T) {
+	store.SetDefault(store.NewMemoryStore())
+	defer store.SetDefault(store.NewMemoryStore())
+	SetAuthBanConfig(AuthBanConfig{MaxFailures: 1})
+	defer func() {
+		SetAuthBanConfig(AuthBanConfig{})
+		authBans.bans = make(map[string]*authBan)
+	}()
+	authBans.onFailure("1.2.3.4", time.Now())
+	authBans.onFailure("1.2.3.5", time.Now())
+	b, _ := json.Marshal(&persistedAuthBan{Failures: 1, Expire: time.Now().Add(-time.Minute).Unix()})
+	store.Default().Put(authBanStoreBucket, "1.2.3.6", b)
+	UnbanIP("1.2.3.5")
+
+	//bans are restored after restarts
+	authBans.bans = make(map[string]*authBan)
+	LoadAuthBans()
+	if !authBans.banned("1.2.3.4", time.Now()) || authBans.banned("1.2.3.5", time.Now()) || authBans.banned("1.2.3.6", time.Now()) {
+		t.Fatalf("unexpected bans after load:%+v", ListAuthBans())
+	}
+	if _, err := store.Default().Get(authBanStoreBucket, "1.2.3.6"); err != store.ErrNotFound {
+		t.Errorf("expired ban should be removed from the store")
+	}
+}
@@ -0,0 +1,256 @@
+package channel
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAPAuthConfig verify users by simple bind with the DN of the user & the credential as password
+type LDAPAuthConfig struct {
+	//'ldap://host:389' or 'ldaps://host:636'
+	URL string
+	//DN template like 'uid=%s,ou=people,dc=example,dc=com', '%s' is replaced by the escaped user name
+	BindDN string
+	//skip verifying the cert of 'ldaps' servers
+	InsecureSkipVerify bool
+}
+
+const (
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+	ldapTagBindRequest           = 0x60
+	ldapTagBindResponse          = 0x61
+	ldapTagUnbindRequest         = 0x42
+	ldapTagSimpleAuth            = 0x80
+	berTagInteger                = 0x02
+	berTagOctetString            = 0x04
+	berTagEnumerated             = 0x0a
+	berTagSequence               = 0x30
+	maxLDAPResponseSize          = 64 * 1024
+	ldapBindMessageID            = 1
+	ldapUnbindMessageID          = 2
+)
+
+var errInvalidLDAPResponse = errors.New("invalid ldap response")
+
+type ldapAuthProvider struct {
+	conf    *AuthProviderConfig
+	addr    string
+	tls     bool
+	tlscfg  *tls.Config
+	timeout time.Duration
+}
+
+func newLDAPAuthProvider(conf *AuthProviderConfig) (AuthProvider, error) {
+	u, err := url.Parse(conf.LDAP.URL)
+	if nil != err {
+		return nil, err
+	}
+	if !strings.Contains(conf.LDAP.BindDN, "%s") {
+		return nil, fmt.Errorf("no '%%s' in ldap bind dn:%s", conf.LDAP.BindDN)
+	}
+	p := &ldapAuthProvider{conf: conf, addr: u.Host, timeout: conf.timeout()}
+	port := "389"
+	switch u.Scheme {
+	case "ldap":
+	case "ldaps":
+		p.tls = true
+		port = "636"
+		p.tlscfg = &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: conf.LDAP.InsecureSkipVerify}
+	default:
+		return nil, fmt.Errorf("invalid ldap url:%s", conf.LDAP.URL)
+	}
+	if len(u.Port()) == 0 {
+		p.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return p, nil
+}
+
+// escapeLDAPDN escape the value of a DN attribute as RFC 4514
+func escapeLDAPDN(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == 0:
+			b.WriteString("\\00")
+			continue
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0:
+			b.WriteByte('\\')
+		case c == '#' && i == 0:
+			b.WriteByte('\\')
+		case c == ' ' && (i == 0 || i == len(v)-1):
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func berLength(n int) []byte {
+	switch {
+	case n < 0x80:
+		return []byte{byte(n)}
+	case n < 0x100:
+		return []byte{0x81, byte(n)}
+	default:
+		return []byte{0x82, byte(n >> 8), byte(n)}
+	}
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	b := append([]byte{tag}, berLength(len(value))...)
+	return append(b, value...)
+}
+
+func berInt(tag byte, v int) []byte {
+	//only small non-negative values are encoded
+	if v < 0x80 {
+		return berTLV(tag, []byte{byte(v)})
+	}
+	return berTLV(tag, []byte{0, byte(v)})
+}
+
+func ldapBindRequest(dn, password string) []byte {
+	var op []byte
+	op = append(op, berInt(berTagInteger, 3)...)
+	op = append(op, berTLV(berTagOctetString, []byte(dn))...)
+	op = append(op, berTLV(ldapTagSimpleAuth, []byte(password))...)
+	msg := append(berInt(berTagInteger, ldapBindMessageID), berTLV(ldapTagBindRequest, op)...)
+	return berTLV(berTagSequence, msg)
+}
+
+func ldapUnbindRequest() []byte {
+	msg := append(berInt(berTagInteger, ldapUnbindMessageID), ldapTagUnbindRequest, 0)
+	return berTLV(berTagSequence, msg)
+}
+
+// readBERTLV read the tag & value of the element at the head of b, return the rest
+func readBERTLV(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errInvalidLDAPResponse
+	}
+	tag, n, pos := b[0], int(b[1]), 2
+	if n >= 0x80 {
+		lenBytes := n & 0x7f
+		if lenBytes == 0 || lenBytes > 3 || len(b) < pos+lenBytes {
+			return 0, nil, nil, errInvalidLDAPResponse
+		}
+		n = 0
+		for i := 0; i < lenBytes; i++ {
+			n = n<<8 | int(b[pos+i])
+		}
+		pos += lenBytes
+	}
+	if len(b) < pos+n {
+		return 0, nil, nil, errInvalidLDAPResponse
+	}
+	return tag, b[pos : pos+n], b[pos+n:], nil
+}
+
+func readLDAPMessage(r io.Reader) ([]byte, error) {
+	msg := make([]byte, 2)
+	if _, err := io.ReadFull(r, msg); nil != err {
+		return nil, err
+	}
+	n := int(msg[1])
+	if n >= 0x80 {
+		lenBytes := make([]byte, n&0x7f)
+		if len(lenBytes) == 0 || len(lenBytes) > 3 {
+			return nil, errInvalidLDAPResponse
+		}
+		if _, err := io.ReadFull(r, lenBytes); nil != err {
+			return nil, err
+		}
+		n = 0
+		for _, c := range lenBytes {
+			n = n<<8 | int(c)
+		}
+		msg = append(msg, lenBytes...)
+	}
+	if n > maxLDAPResponseSize {
+		return nil, errInvalidLDAPResponse
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); nil != err {
+		return nil, err
+	}
+	return append(msg, body...), nil
+}
+
+// parseLDAPBindResponse return the result code & diagnostic message of the bind response
+func parseLDAPBindResponse(b []byte) (int, string, error) {
+	tag, msg, _, err := readBERTLV(b)
+	if nil != err || tag != berTagSequence {
+		return 0, "", errInvalidLDAPResponse
+	}
+	tag, id, rest, err := readBERTLV(msg)
+	if nil != err || tag != berTagInteger || len(id) != 1 || id[0] != ldapBindMessageID {
+		return 0, "", errInvalidLDAPResponse
+	}
+	tag, op, _, err := readBERTLV(rest)
+	if nil != err || tag != ldapTagBindResponse {
+		return 0, "", errInvalidLDAPResponse
+	}
+	tag, code, rest, err := readBERTLV(op)
+	if nil != err || tag != berTagEnumerated || len(code) != 1 {
+		return 0, "", errInvalidLDAPResponse
+	}
+	diagnostic := ""
+	if _, _, rest, err = readBERTLV(rest); nil == err {
+		if _, v, _, err := readBERTLV(rest); nil == err {
+			diagnostic = string(v)
+		}
+	}
+	return int(code[0]), diagnostic, nil
+}
+
+func (p *ldapAuthProvider) Authenticate(user, credential string) (bool, time.Duration, error) {
+	//never bind with empty password, which is an unauthenticated bind accepted by most servers
+	if len(credential) == 0 {
+		return false, 0, nil
+	}
+	dialer := &net.Dialer{Timeout: p.timeout}
+	var conn net.Conn
+	var err error
+	if p.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.addr, p.tlscfg)
+	} else {
+		conn, err = dialer.Dial("tcp", p.addr)
+	}
+	if nil != err {
+		return false, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	dn := strings.Replace(p.conf.LDAP.BindDN, "%s", escapeLDAPDN(user), -1)
+	if _, err = conn.Write(ldapBindRequest(dn, credential)); nil != err {
+		return false, 0, err
+	}
+	res, err := readLDAPMessage(conn)
+	if nil != err {
+		return false, 0, err
+	}
+	code, diagnostic, err := parseLDAPBindResponse(res)
+	if nil != err {
+		return false, 0, err
+	}
+	conn.Write(ldapUnbindRequest())
+	switch code {
+	case ldapResultSuccess:
+		return true, 0, nil
+	case ldapResultInvalidCredentials:
+		return false, 0, nil
+	}
+	return false, 0, fmt.Errorf("ldap bind failed with code:%d %s", code, diagnostic)
+}
+
+func init() {
+	RegisterAuthProvider("ldap", newLDAPAuthProvider)
+}
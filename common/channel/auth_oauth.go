@@ -0,0 +1,106 @@
+package channel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuthAuthConfig verify the credential as an access token by RFC 7662 token introspection
+type OAuthAuthConfig struct {
+	IntrospectionURL string
+	//client credentials of the server allowed to introspect tokens, sent by basic auth
+	ClientID     string
+	ClientSecret string
+	//claim of the token which must equal the user in auth, default 'username'
+	UserClaim string
+	//scopes the token must be granted, empty allows any
+	Scopes []string
+}
+
+type oauthAuthProvider struct {
+	conf   *AuthProviderConfig
+	client *http.Client
+}
+
+func newOAuthAuthProvider(conf *AuthProviderConfig) (AuthProvider, error) {
+	u, err := url.Parse(conf.OAuth.IntrospectionURL)
+	if nil != err {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid introspection url:%s", conf.OAuth.IntrospectionURL)
+	}
+	return &oauthAuthProvider{conf: conf, client: &http.Client{Timeout: conf.timeout()}}, nil
+}
+
+// verifyIntrospection return true & the remaining lifetime if the introspected token is active for the user
+func verifyIntrospection(conf *OAuthAuthConfig, user string, token map[string]interface{}, now time.Time) (bool, time.Duration) {
+	if active, _ := token["active"].(bool); !active {
+		return false, 0
+	}
+	claim := conf.UserClaim
+	if len(claim) == 0 {
+		claim = "username"
+	}
+	if v, _ := token[claim].(string); v != user {
+		return false, 0
+	}
+	granted := make(map[string]bool)
+	scope, _ := token["scope"].(string)
+	for _, s := range strings.Fields(scope) {
+		granted[s] = true
+	}
+	for _, s := range conf.Scopes {
+		if !granted[s] {
+			return false, 0
+		}
+	}
+	var ttl time.Duration
+	if exp, ok := token["exp"].(float64); ok {
+		ttl = time.Unix(int64(exp), 0).Sub(now)
+		if ttl <= 0 {
+			return false, 0
+		}
+	}
+	return true, ttl
+}
+
+func (p *oauthAuthProvider) Authenticate(user, credential string) (bool, time.Duration, error) {
+	if len(credential) == 0 {
+		return false, 0, nil
+	}
+	form := url.Values{"token": {credential}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest("POST", p.conf.OAuth.IntrospectionURL, strings.NewReader(form.Encode()))
+	if nil != err {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(p.conf.OAuth.ClientID) > 0 {
+		req.SetBasicAuth(url.QueryEscape(p.conf.OAuth.ClientID), url.QueryEscape(p.conf.OAuth.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if nil != err {
+		return false, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return false, 0, fmt.Errorf("introspection failed with status:%d", res.StatusCode)
+	}
+	var token map[string]interface{}
+	if err = json.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(&token); nil != err {
+		return false, 0, errors.New("invalid introspection response")
+	}
+	ok, ttl := verifyIntrospection(&p.conf.OAuth, user, token, time.Now())
+	return ok, ttl, nil
+}
+
+func init() {
+	RegisterAuthProvider("oauth", newOAuthAuthProvider)
+}
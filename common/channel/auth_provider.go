@@ -0,0 +1,199 @@
+package channel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// AuthProvider verify the credential of the user in auth requests by an external backend, the ttl
+// caps how long the result could be cached, 0 means the cache setting
+type AuthProvider interface {
+	Authenticate(user, credential string) (ok bool, ttl time.Duration, err error)
+}
+
+// AuthProviderFactory create the provider by the config, providers are registered by type name
+type AuthProviderFactory func(conf *AuthProviderConfig) (AuthProvider, error)
+
+var authProviderFactories = make(map[string]AuthProviderFactory)
+
+func RegisterAuthProvider(name string, factory AuthProviderFactory) {
+	authProviderFactories[name] = factory
+}
+
+// AuthProviderConfig of verifying users by LDAP/RADIUS/OAuth token introspection instead of the static user list
+type AuthProviderConfig struct {
+	//'ldap', 'radius', 'oauth' or other registered providers, empty verifies users by the static user list only
+	Type string
+	//seconds verified credentials are cached, 0 means default 300, negative disables caching
+	CacheSecs int
+	//seconds rejected credentials are cached, 0 means default 30, negative disables
+	NegativeCacheSecs int
+	//ms timeout of each verification, 0 means default 5000
+	TimeoutMS int
+	LDAP      LDAPAuthConfig
+	RADIUS    RADIUSAuthConfig
+	OAuth     OAuthAuthConfig
+}
+
+func (conf *AuthProviderConfig) cacheTTL() time.Duration {
+	if conf.CacheSecs == 0 {
+		return 300 * time.Second
+	}
+	return time.Duration(conf.CacheSecs) * time.Second
+}
+
+func (conf *AuthProviderConfig) negativeCacheTTL() time.Duration {
+	if conf.NegativeCacheSecs == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(conf.NegativeCacheSecs) * time.Second
+}
+
+func (conf *AuthProviderConfig) timeout() time.Duration {
+	if conf.TimeoutMS <= 0 {
+		return 5000 * time.Millisecond
+	}
+	return time.Duration(conf.TimeoutMS) * time.Millisecond
+}
+
+// results are pruned once too many credentials cached
+const maxCachedAuthResults = 10000
+
+const maxCredentialSize = 8192
+
+type authResult struct {
+	ok     bool
+	expire time.Time
+}
+
+type authProviderState struct {
+	conf     AuthProviderConfig
+	provider AuthProvider
+	mutex    sync.Mutex
+	cache    map[string]*authResult
+}
+
+var authProviderValue atomic.Value
+
+func newAuthProviderState(cfg AuthProviderConfig) (*authProviderState, error) {
+	state := &authProviderState{conf: cfg, cache: make(map[string]*authResult)}
+	if len(cfg.Type) > 0 {
+		factory, exist := authProviderFactories[strings.ToLower(cfg.Type)]
+		if !exist {
+			return nil, fmt.Errorf("unknown auth provider:%s", cfg.Type)
+		}
+		provider, err := factory(&state.conf)
+		if nil != err {
+			return nil, fmt.Errorf("invalid auth provider:%s with reason:%v", cfg.Type, err)
+		}
+		state.provider = provider
+	}
+	return state, nil
+}
+
+// SetAuthProviderConfig create the provider of the config, cached results are dropped, the provider
+// is kept if the config is invalid
+func SetAuthProviderConfig(cfg AuthProviderConfig) error {
+	state, err := newAuthProviderState(cfg)
+	if nil != err {
+		return err
+	}
+	authProviderValue.Store(state)
+	return nil
+}
+
+func getAuthProvider() *authProviderState {
+	state, _ := authProviderValue.Load().(*authProviderState)
+	return state
+}
+
+func authProviderEnabled() bool {
+	state := getAuthProvider()
+	return nil != state && nil != state.provider
+}
+
+func authCacheKey(user, credential string) string {
+	h := sha256.New()
+	h.Write([]byte(user))
+	h.Write([]byte{0})
+	h.Write([]byte(credential))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *authProviderState) cached(key string, now time.Time) (*authResult, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res, exist := s.cache[key]
+	if !exist || now.After(res.expire) {
+		return nil, false
+	}
+	return res, true
+}
+
+func (s *authProviderState) store(key string, ok bool, ttl time.Duration, now time.Time) {
+	if ttl <= 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.cache) >= maxCachedAuthResults {
+		for k, res := range s.cache {
+			if now.After(res.expire) {
+				delete(s.cache, k)
+			}
+		}
+		if len(s.cache) >= maxCachedAuthResults {
+			s.cache = make(map[string]*authResult)
+		}
+	}
+	s.cache[key] = &authResult{ok: ok, expire: now.Add(ttl)}
+}
+
+func (s *authProviderState) verify(user, credential string, now time.Time) string {
+	key := authCacheKey(user, credential)
+	if res, exist := s.cached(key, now); exist {
+		if !res.ok {
+			return "invalid credential"
+		}
+		return ""
+	}
+	ok, ttl, err := s.provider.Authenticate(user, credential)
+	if nil != err {
+		//failures of the backend are not cached
+		logger.Error("[ERROR]Auth provider:%s failed to verify user:%s with reason:%v", s.conf.Type, user, err)
+		return "auth provider unavailable"
+	}
+	if ok {
+		cacheTTL := s.conf.cacheTTL()
+		if ttl > 0 && ttl < cacheTTL {
+			cacheTTL = ttl
+		}
+		s.store(key, true, cacheTTL, now)
+		return ""
+	}
+	s.store(key, false, s.conf.negativeCacheTTL(), now)
+	return "invalid credential"
+}
+
+// verifyByAuthProvider return the reason if the credential of the auth rejected by the provider
+func verifyByAuthProvider(auth *mux.AuthRequest) string {
+	state := getAuthProvider()
+	if nil == state || nil == state.provider {
+		return ""
+	}
+	if len(auth.User) == 0 || len(auth.Credential) == 0 {
+		return "credential required"
+	}
+	if len(auth.Credential) > maxCredentialSize {
+		return "credential too long"
+	}
+	return state.verify(auth.User, auth.Credential, time.Now())
+}
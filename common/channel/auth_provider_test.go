@@ -0,0 +1,245 @@
+package channel
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+type testAuthProvider struct {
+	calls int
+	err   error
+}
+
+func (p *testAuthProvider) Authenticate(user, credential string) (bool, time.Duration, error) {
+	p.calls++
+	return credential == "good", 0, p.err
+}
+
+func TestAuthProviderCache(t *testing.T) {
+	provider := &testAuthProvider{}
+	RegisterAuthProvider("test", func(conf *AuthProviderConfig) (AuthProvider, error) {
+		return provider, nil
+	})
+	if err := SetAuthProviderConfig(AuthProviderConfig{Type: "Test"}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetAuthProviderConfig(AuthProviderConfig{})
+	if !authProviderEnabled() {
+		t.Fatalf("provider should be enabled")
+	}
+	if reason := verifyByAuthProvider(&mux.AuthRequest{User: "a"}); len(reason) == 0 {
+		t.Fatalf("empty credential should be rejected")
+	}
+	for i := 0; i < 3; i++ {
+		if reason := verifyByAuthProvider(&mux.AuthRequest{User: "a", Credential: "good"}); len(reason) > 0 {
+			t.Fatalf("good credential rejected:%s", reason)
+		}
+		if reason := verifyByAuthProvider(&mux.AuthRequest{User: "a", Credential: "bad"}); len(reason) == 0 {
+			t.Fatalf("bad credential accepted")
+		}
+	}
+	if provider.calls != 2 {
+		t.Fatalf("results should be cached, calls:%d", provider.calls)
+	}
+	state := getAuthProvider()
+	now := time.Now()
+	state.verify("a", "bad", now.Add(time.Minute))
+	state.verify("a", "good", now.Add(time.Minute))
+	if provider.calls != 3 {
+		t.Fatalf("rejections should expire after 30 secs, calls:%d", provider.calls)
+	}
+	provider.err = errors.New("backend down")
+	state.verify("b", "good", now)
+	state.verify("b", "good", now)
+	if provider.calls != 5 {
+		t.Fatalf("backend failures should not be cached, calls:%d", provider.calls)
+	}
+	if err := SetAuthProviderConfig(AuthProviderConfig{Type: "unknown"}); nil == err || getAuthProvider() != state {
+		t.Fatalf("unknown provider should keep the current one")
+	}
+}
+
+func TestSetAuthConfigs(t *testing.T) {
+	if err := SetAuthConfigs([]UserConfig{{Name: "a"}}, AuthProviderConfig{}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetAuthConfigs(nil, AuthProviderConfig{})
+	state := getAuthProvider()
+	for _, c := range []struct {
+		users    []UserConfig
+		provider AuthProviderConfig
+	}{
+		{[]UserConfig{{Name: "b"}}, AuthProviderConfig{Type: "unknown"}},
+		{[]UserConfig{{Name: "b", SessionPolicy: "oldest"}}, AuthProviderConfig{}},
+	} {
+		if nil == SetAuthConfigs(c.users, c.provider) {
+			t.Fatalf("invalid configs accepted:%+v", c)
+		}
+		if nil == getUserConfig("a") || nil != getUserConfig("b") || getAuthProvider() != state {
+			t.Fatalf("invalid configs should keep both the users & the provider")
+		}
+	}
+}
+
+func TestEscapeLDAPDN(t *testing.T) {
+	for v, expected := range map[string]string{
+		"alice":    "alice",
+		"a,b=c":    "a\\,b\\=c",
+		"#x y ":    "\\#x y\\ ",
+		"x\x00":    "x\\00",
+		"a\\*)(cn": "a\\\\*)(cn",
+	} {
+		if escaped := escapeLDAPDN(v); escaped != expected {
+			t.Errorf("escape %q got %q expected %q", v, escaped, expected)
+		}
+	}
+}
+
+func TestLDAPAuthProvider(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if nil != err {
+				return
+			}
+			req, err := readLDAPMessage(c)
+			if nil != err {
+				c.Close()
+				continue
+			}
+			code := ldapResultInvalidCredentials
+			if string(req) == string(ldapBindRequest("uid=alice,dc=example", "secret")) {
+				code = ldapResultSuccess
+			}
+			op := append(berInt(berTagEnumerated, code), berTLV(berTagOctetString, nil)...)
+			op = append(op, berTLV(berTagOctetString, []byte("diagnostic"))...)
+			msg := append(berInt(berTagInteger, ldapBindMessageID), berTLV(ldapTagBindResponse, op)...)
+			c.Write(berTLV(berTagSequence, msg))
+			c.Close()
+		}
+	}()
+	p, err := newLDAPAuthProvider(&AuthProviderConfig{LDAP: LDAPAuthConfig{URL: "ldap://" + l.Addr().String(), BindDN: "uid=%s,dc=example"}})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if ok, _, err := p.Authenticate("alice", "secret"); !ok || nil != err {
+		t.Fatalf("valid password rejected:%v", err)
+	}
+	if ok, _, err := p.Authenticate("alice", "wrong"); ok || nil != err {
+		t.Fatalf("invalid password accepted:%v", err)
+	}
+	if ok, _, _ := p.Authenticate("alice", ""); ok {
+		t.Fatalf("empty password should never bind")
+	}
+}
+
+func TestRADIUSPassword(t *testing.T) {
+	//example of RFC 2865 section 7.1
+	authenticator, _ := hex.DecodeString("0f403f9473978057bd83d5cb98f4227a")
+	if hidden := hex.EncodeToString(radiusPassword("arctangent", "xyzzy5461", authenticator)); hidden != "0dbe708d93d413ce3196e43f782a0aee" {
+		t.Fatalf("hidden password mismatch:%s", hidden)
+	}
+}
+
+func TestRADIUSAuthProvider(t *testing.T) {
+	secret := "testing123"
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		b := make([]byte, radiusMaxPacketLen)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if nil != err {
+				return
+			}
+			req := b[:n]
+			authenticator := req[4:radiusHeaderLen]
+			code := byte(radiusAccessReject)
+			for pos := radiusHeaderLen; pos < n; pos += int(req[pos+1]) {
+				//hiding the padded password twice reveals it
+				if req[pos] == radiusAttrUserPassword {
+					hidden := req[pos+2 : pos+int(req[pos+1])]
+					if string(radiusPassword(string(hidden), secret, authenticator)[:6]) == "secret" {
+						code = radiusAccessAccept
+					}
+				}
+			}
+			res := []byte{code, req[1], 0, radiusHeaderLen}
+			h := md5.New()
+			h.Write(res[:4])
+			h.Write(authenticator)
+			h.Write([]byte(secret))
+			res = append(res, h.Sum(nil)...)
+			conn.WriteTo(res, addr)
+		}
+	}()
+	p, err := newRADIUSAuthProvider(&AuthProviderConfig{RADIUS: RADIUSAuthConfig{Server: conn.LocalAddr().String(), Secret: secret}})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if ok, _, err := p.Authenticate("alice", "secret"); !ok || nil != err {
+		t.Fatalf("valid password rejected:%v", err)
+	}
+	if ok, _, err := p.Authenticate("alice", "wrong!"); ok || nil != err {
+		t.Fatalf("invalid password accepted:%v", err)
+	}
+	p, _ = newRADIUSAuthProvider(&AuthProviderConfig{RADIUS: RADIUSAuthConfig{Server: conn.LocalAddr().String(), Secret: "other"}, TimeoutMS: 300})
+	if ok, _, err := p.Authenticate("alice", "secret"); ok || nil == err {
+		t.Fatalf("responses of wrong secret should be ignored")
+	}
+}
+
+func TestOAuthAuthProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "gsnova" || secret != "s" {
+			w.WriteHeader(401)
+			return
+		}
+		switch r.FormValue("token") {
+		case "t1":
+			w.Write([]byte(`{"active":true, "username":"alice", "scope":"proxy read", "exp":4102444800}`))
+		case "t2":
+			w.Write([]byte(`{"active":true, "username":"bob", "scope":"proxy"}`))
+		default:
+			w.Write([]byte(`{"active":false}`))
+		}
+	}))
+	defer srv.Close()
+	p, err := newOAuthAuthProvider(&AuthProviderConfig{OAuth: OAuthAuthConfig{IntrospectionURL: srv.URL, ClientID: "gsnova", ClientSecret: "s", Scopes: []string{"proxy"}}})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if ok, ttl, err := p.Authenticate("alice", "t1"); !ok || nil != err || ttl <= 0 {
+		t.Fatalf("active token rejected:%v %v", ttl, err)
+	}
+	if ok, _, _ := p.Authenticate("alice", "t2"); ok {
+		t.Fatalf("token of other user accepted")
+	}
+	if ok, _, _ := p.Authenticate("alice", "t3"); ok {
+		t.Fatalf("inactive token accepted")
+	}
+	token := map[string]interface{}{"active": true, "username": "alice", "scope": "read"}
+	if ok, _ := verifyIntrospection(&OAuthAuthConfig{Scopes: []string{"proxy"}}, "alice", token, time.Now()); ok {
+		t.Fatalf("token without required scope accepted")
+	}
+	token["exp"] = float64(time.Now().Add(-time.Minute).Unix())
+	if ok, _ := verifyIntrospection(&OAuthAuthConfig{}, "alice", token, time.Now()); ok {
+		t.Fatalf("expired token accepted")
+	}
+}
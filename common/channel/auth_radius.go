@@ -0,0 +1,204 @@
+package channel
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// RADIUSAuthConfig verify users by PAP Access-Requests with the credential as password
+type RADIUSAuthConfig struct {
+	//'host:port' of the server, default port 1812
+	Server string
+	Secret string
+	//NAS-Identifier of requests, default 'gsnova'
+	NASIdentifier string
+	//retransmits of unanswered requests within the timeout, 0 means default 2
+	Retries int
+}
+
+const (
+	radiusAccessRequest      = 1
+	radiusAccessAccept       = 2
+	radiusAccessReject       = 3
+	radiusAccessChallenge    = 11
+	radiusAttrUserName       = 1
+	radiusAttrUserPassword   = 2
+	radiusAttrNASIdentifier  = 32
+	radiusAttrMessageAuth    = 80
+	radiusHeaderLen          = 20
+	radiusMaxPasswordLen     = 128
+	radiusMaxPacketLen       = 4096
+	radiusAuthenticatorLen   = 16
+	radiusMessageAuthAttrLen = 18
+)
+
+var errInvalidRADIUSResponse = errors.New("invalid radius response")
+
+type radiusAuthProvider struct {
+	conf    *AuthProviderConfig
+	addr    string
+	retries int
+	timeout time.Duration
+}
+
+func newRADIUSAuthProvider(conf *AuthProviderConfig) (AuthProvider, error) {
+	if len(conf.RADIUS.Secret) == 0 {
+		return nil, errors.New("no radius secret")
+	}
+	addr := conf.RADIUS.Server
+	if _, _, err := net.SplitHostPort(addr); nil != err {
+		addr = net.JoinHostPort(addr, "1812")
+	}
+	p := &radiusAuthProvider{conf: conf, addr: addr, retries: conf.RADIUS.Retries, timeout: conf.timeout()}
+	if p.retries <= 0 {
+		p.retries = 2
+	}
+	return p, nil
+}
+
+func radiusAttr(typ byte, value []byte) []byte {
+	return append([]byte{typ, byte(len(value) + 2)}, value...)
+}
+
+// radiusPassword hide the password of User-Password as RFC 2865
+func radiusPassword(password, secret string, authenticator []byte) []byte {
+	n := (len(password) + 15) / 16 * 16
+	if n == 0 {
+		n = 16
+	}
+	b := make([]byte, n)
+	copy(b, password)
+	prev := authenticator
+	for i := 0; i < n; i += 16 {
+		h := md5.New()
+		h.Write([]byte(secret))
+		h.Write(prev)
+		sum := h.Sum(nil)
+		for j := 0; j < 16; j++ {
+			b[i+j] ^= sum[j]
+		}
+		prev = b[i : i+16]
+	}
+	return b
+}
+
+func radiusMessageAuth(packet []byte, secret string) []byte {
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(packet)
+	return mac.Sum(nil)
+}
+
+// radiusAccessRequestPacket build the request with Message-Authenticator, which resists forged responses
+func radiusAccessRequestPacket(id byte, authenticator []byte, user, password, nasID, secret string) []byte {
+	b := []byte{radiusAccessRequest, id, 0, 0}
+	b = append(b, authenticator...)
+	b = append(b, radiusAttr(radiusAttrUserName, []byte(user))...)
+	b = append(b, radiusAttr(radiusAttrUserPassword, radiusPassword(password, secret, authenticator))...)
+	b = append(b, radiusAttr(radiusAttrNASIdentifier, []byte(nasID))...)
+	pos := len(b) + 2
+	b = append(b, radiusAttr(radiusAttrMessageAuth, make([]byte, radiusAuthenticatorLen))...)
+	b[2], b[3] = byte(len(b)>>8), byte(len(b))
+	copy(b[pos:], radiusMessageAuth(b, secret))
+	return b
+}
+
+// verifyRADIUSResponse check the response authenticator & the Message-Authenticator if present
+func verifyRADIUSResponse(res []byte, id byte, reqAuthenticator []byte, secret string) error {
+	if len(res) < radiusHeaderLen || res[1] != id {
+		return errInvalidRADIUSResponse
+	}
+	n := int(res[2])<<8 | int(res[3])
+	if n < radiusHeaderLen || n > len(res) {
+		return errInvalidRADIUSResponse
+	}
+	res = res[:n]
+	h := md5.New()
+	h.Write(res[:4])
+	h.Write(reqAuthenticator)
+	h.Write(res[radiusHeaderLen:])
+	h.Write([]byte(secret))
+	if !hmac.Equal(h.Sum(nil), res[4:radiusHeaderLen]) {
+		return errInvalidRADIUSResponse
+	}
+	for pos := radiusHeaderLen; pos+2 <= n; {
+		alen := int(res[pos+1])
+		if alen < 2 || pos+alen > n {
+			return errInvalidRADIUSResponse
+		}
+		if res[pos] == radiusAttrMessageAuth {
+			if alen != radiusMessageAuthAttrLen {
+				return errInvalidRADIUSResponse
+			}
+			b := append([]byte{}, res...)
+			copy(b[4:radiusHeaderLen], reqAuthenticator)
+			copy(b[pos+2:pos+alen], make([]byte, radiusAuthenticatorLen))
+			if !hmac.Equal(radiusMessageAuth(b, secret), res[pos+2:pos+alen]) {
+				return errInvalidRADIUSResponse
+			}
+		}
+		pos += alen
+	}
+	return nil
+}
+
+func (p *radiusAuthProvider) Authenticate(user, credential string) (bool, time.Duration, error) {
+	if len(credential) == 0 || len(credential) > radiusMaxPasswordLen || len(user) > 253 {
+		return false, 0, nil
+	}
+	nasID := p.conf.RADIUS.NASIdentifier
+	if len(nasID) == 0 {
+		nasID = "gsnova"
+	}
+	secret := p.conf.RADIUS.Secret
+	authenticator := make([]byte, radiusAuthenticatorLen)
+	rand.Read(authenticator)
+	id := authenticator[0]
+	req := radiusAccessRequestPacket(id, authenticator, user, credential, nasID, secret)
+
+	conn, err := net.DialTimeout("udp", p.addr, p.timeout)
+	if nil != err {
+		return false, 0, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(p.timeout)
+	interval := p.timeout / time.Duration(p.retries+1)
+	res := make([]byte, radiusMaxPacketLen)
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if _, err = conn.Write(req); nil != err {
+			return false, 0, err
+		}
+		wait := time.Now().Add(interval)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+		for {
+			n, err := conn.Read(res)
+			if nil != err {
+				break
+			}
+			//ignore responses of other requests or forged by others
+			if verifyRADIUSResponse(res[:n], id, authenticator, secret) != nil {
+				continue
+			}
+			switch res[0] {
+			case radiusAccessAccept:
+				return true, 0, nil
+			case radiusAccessReject, radiusAccessChallenge:
+				//challenges of multi round auth are not supported
+				return false, 0, nil
+			}
+			return false, 0, fmt.Errorf("unexpected radius response code:%d", res[0])
+		}
+	}
+	return false, 0, fmt.Errorf("no response from radius server:%s", p.addr)
+}
+
+func init() {
+	RegisterAuthProvider("radius", newRADIUSAuthProvider)
+}
@@ -0,0 +1,57 @@
+package channel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// max time difference allowed between client auth timestamp and server clock
+var AuthReplayWindow = 120 * time.Second
+
+// authNonceCache remember the nonces seen in the replay window
+type authNonceCache struct {
+	nonces    map[string]int64
+	lastPurge time.Time
+	mutex     sync.Mutex
+}
+
+var authNonces = &authNonceCache{nonces: make(map[string]int64)}
+
+func (c *authNonceCache) purge(now time.Time) {
+	if now.Sub(c.lastPurge) < AuthReplayWindow/2 {
+		return
+	}
+	c.lastPurge = now
+	expire := now.Add(-AuthReplayWindow).Unix()
+	for nonce, ts := range c.nonces {
+		if ts < expire {
+			delete(c.nonces, nonce)
+		}
+	}
+}
+
+// check return the reason if the auth request is stale or replayed
+func (c *authNonceCache) check(auth *mux.AuthRequest) string {
+	if auth.Timestamp == 0 && auth.ProtocolLevel < 2 {
+		//legacy clients, could be rejected by MinProtocolLevel
+		return ""
+	}
+	now := time.Now()
+	ts := time.Unix(auth.Timestamp, 0)
+	if ts.Before(now.Add(-AuthReplayWindow)) || ts.After(now.Add(AuthReplayWindow)) {
+		return "stale auth request, please check the clock of client"
+	}
+	if len(auth.Nonce) == 0 {
+		return "missing auth nonce"
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.purge(now)
+	if _, exist := c.nonces[auth.Nonce]; exist {
+		return "duplicate auth request"
+	}
+	c.nonces[auth.Nonce] = auth.Timestamp
+	return ""
+}
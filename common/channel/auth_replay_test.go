@@ -0,0 +1,70 @@
+package channel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestAuthNonceCacheCheck(t *testing.T) {
+	c := &authNonceCache{nonces: make(map[string]int64)}
+	now := time.Now().Unix()
+	window := int64(AuthReplayWindow / time.Second)
+	tests := []struct {
+		name   string
+		auth   mux.AuthRequest
+		reason string
+	}{
+		{"legacy", mux.AuthRequest{ProtocolLevel: 1}, ""},
+		{"fresh", mux.AuthRequest{Timestamp: now, Nonce: "n1", ProtocolLevel: 2}, ""},
+		{"replayed", mux.AuthRequest{Timestamp: now, Nonce: "n1", ProtocolLevel: 2}, "duplicate"},
+		{"no timestamp", mux.AuthRequest{Nonce: "n2", ProtocolLevel: 2}, "stale"},
+		{"too old", mux.AuthRequest{Timestamp: now - window - 10, Nonce: "n3", ProtocolLevel: 2}, "stale"},
+		{"from future", mux.AuthRequest{Timestamp: now + window + 10, Nonce: "n4", ProtocolLevel: 2}, "stale"},
+		{"skewed in window", mux.AuthRequest{Timestamp: now - window + 10, Nonce: "n5", ProtocolLevel: 2}, ""},
+		{"no nonce", mux.AuthRequest{Timestamp: now, ProtocolLevel: 2}, "missing"},
+	}
+	for _, tt := range tests {
+		reason := c.check(&tt.auth)
+		if (len(tt.reason) == 0) != (len(reason) == 0) || !strings.Contains(reason, tt.reason) {
+			t.Errorf("%s: expect reason %q, but got %q", tt.name, tt.reason, reason)
+		}
+	}
+}
+
+func TestAuthNonceCachePurge(t *testing.T) {
+	c := &authNonceCache{nonces: make(map[string]int64)}
+	now := time.Now()
+	c.nonces["old"] = now.Add(-AuthReplayWindow - time.Second).Unix()
+	c.nonces["recent"] = now.Unix()
+	c.purge(now)
+	if _, exist := c.nonces["old"]; exist {
+		t.Errorf("nonce out of the replay window should be purged")
+	}
+	if _, exist := c.nonces["recent"]; !exist {
+		t.Errorf("nonce in the replay window should be kept")
+	}
+	//purged at most once per half window
+	c.nonces["old"] = 0
+	c.purge(now.Add(time.Second))
+	if _, exist := c.nonces["old"]; !exist {
+		t.Errorf("nonces should not be purged again so soon")
+	}
+}
+
+func TestAuthRejectReplayedRequest(t *testing.T) {
+	req := &mux.AuthRequest{User: "gsnova", ProtocolLevel: mux.ProtocolLevel}
+	session, err := authTestSession(t, req)
+	if nil != err {
+		t.Fatal(err)
+	}
+	session.Close()
+	replayed := &mux.AuthRequest{User: "gsnova", ProtocolLevel: mux.ProtocolLevel, Nonce: req.Nonce}
+	session, err = authTestSession(t, replayed)
+	if authErr, ok := err.(*mux.AuthError); !ok || authErr.Code != mux.AuthRejected || !strings.Contains(authErr.Reason, "duplicate") {
+		t.Errorf("expect replayed auth rejected, but got %v", err)
+	}
+	session.Close()
+}
@@ -0,0 +1,138 @@
+package channel
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+)
+
+type BondingConfig struct {
+	//stripe new streams across all servers in ServerList
+	Enable bool
+	//weight of each server url, default 1
+	Weights map[string]int
+	//mark a path down after continuous failures
+	FailThreshold int
+	//retry a down path after seconds
+	RecoverAfterSecs int
+}
+
+func (conf *BondingConfig) weight(server string) int {
+	if w, exist := conf.Weights[server]; exist && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// pathHealth is the health score of one mux session path
+type pathHealth struct {
+	mutex     sync.Mutex
+	fails     int
+	rtt       time.Duration
+	downUntil time.Time
+	//updated by health checker
+	probeFails int
+	unhealthy  bool
+	loss       float64
+}
+
+func (h *pathHealth) onSuccess() {
+	h.mutex.Lock()
+	h.fails = 0
+	h.downUntil = time.Time{}
+	h.mutex.Unlock()
+}
+
+func (h *pathHealth) onFailure(conf *BondingConfig, server string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.fails++
+	if h.fails >= conf.FailThreshold {
+		h.downUntil = time.Now().Add(time.Duration(conf.RecoverAfterSecs) * time.Second)
+		logger.Notice("Bonding path:%s marked down for %d failures, retry after %ds", server, h.fails, conf.RecoverAfterSecs)
+	}
+}
+
+func (h *pathHealth) onPing(rtt time.Duration) {
+	h.mutex.Lock()
+	if h.rtt == 0 {
+		h.rtt = rtt
+	} else {
+		h.rtt = (h.rtt*7 + rtt) / 8
+	}
+	h.loss = h.loss * 7 / 8
+	h.mutex.Unlock()
+}
+
+// score is the effective weight of the path, 0 means unusable now
+func (h *pathHealth) score(weight int) float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.unhealthy || (!h.downUntil.IsZero() && time.Now().Before(h.downUntil)) {
+		return 0
+	}
+	s := float64(weight*100) / float64(1+h.fails) * (1 - h.loss)
+	if h.rtt > 0 {
+		//penalty for slow paths, 100ms as baseline
+		s = s * 100 / (100 + float64(h.rtt/time.Millisecond))
+	}
+	return s
+}
+
+func (ch *LocalProxyChannel) getBondingMuxStream() (mux.MuxStream, error) {
+	conf := &ch.Conf.Bonding
+	candidates := make([]*muxSessionHolder, 0, len(ch.sessions))
+	scores := make([]float64, 0, len(ch.sessions))
+	total := float64(0)
+	for holder := range ch.sessions {
+		s := holder.health.score(conf.weight(holder.server))
+		if s <= 0 {
+			continue
+		}
+		candidates = append(candidates, holder)
+		scores = append(scores, s)
+		total += s
+	}
+	if len(candidates) == 0 {
+		//all paths are down, try all of them anyway
+		for holder := range ch.sessions {
+			candidates = append(candidates, holder)
+			scores = append(scores, 1)
+			total++
+		}
+	}
+	for len(candidates) > 0 {
+		r := rand.Float64() * total
+		idx := len(candidates) - 1
+		for i, s := range scores {
+			if r < s {
+				idx = i
+				break
+			}
+			r -= s
+		}
+		holder := candidates[idx]
+		stream, err := holder.getNewStream()
+		if nil == err {
+			holder.health.onSuccess()
+			if ch.autoExpire {
+				ch.lastActiveTime = time.Now()
+			}
+			return stream, nil
+		}
+		if err == pmux.ErrSessionShutdown {
+			holder.close()
+		}
+		holder.health.onFailure(conf, holder.server)
+		logger.Debug("Try next bonding path since %s failed to open new stream with err:%v", holder.server, err)
+		total -= scores[idx]
+		candidates = append(candidates[:idx], candidates[idx+1:]...)
+		scores = append(scores[:idx], scores[idx+1:]...)
+	}
+	return nil, fmt.Errorf("Create mux porxy stream failed on all bonding paths")
+}
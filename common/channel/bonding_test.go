@@ -0,0 +1,132 @@
+package channel
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestBondingWeight(t *testing.T) {
+	conf := &BondingConfig{Weights: map[string]int{"a": 3, "b": 0, "c": -1}}
+	tests := []struct {
+		server string
+		weight int
+	}{
+		{"a", 3},
+		{"b", 1},
+		{"c", 1},
+		{"d", 1},
+	}
+	for _, tt := range tests {
+		if w := conf.weight(tt.server); w != tt.weight {
+			t.Errorf("expect weight %d of %s, but got %d", tt.weight, tt.server, w)
+		}
+	}
+}
+
+func TestPathHealthScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		health *pathHealth
+		weight int
+		score  float64
+	}{
+		{"fresh", &pathHealth{}, 1, 100},
+		{"weighted", &pathHealth{}, 2, 200},
+		{"failed once", &pathHealth{fails: 1}, 1, 50},
+		{"100ms rtt", &pathHealth{rtt: 100 * time.Millisecond}, 1, 50},
+		{"half lost", &pathHealth{loss: 0.5}, 1, 50},
+		{"down", &pathHealth{downUntil: time.Now().Add(time.Minute)}, 1, 0},
+		{"recovered", &pathHealth{downUntil: time.Now().Add(-time.Second)}, 1, 100},
+		{"unhealthy", &pathHealth{unhealthy: true}, 1, 0},
+	}
+	for _, tt := range tests {
+		if s := tt.health.score(tt.weight); math.Abs(s-tt.score) > 0.001 {
+			t.Errorf("%s: expect score %v, but got %v", tt.name, tt.score, s)
+		}
+	}
+}
+
+func TestPathHealthMarkDown(t *testing.T) {
+	conf := &BondingConfig{FailThreshold: 2, RecoverAfterSecs: 30}
+	h := &pathHealth{}
+	h.onFailure(conf, "a")
+	if s := h.score(1); s <= 0 {
+		t.Fatalf("path should be usable below fail threshold, but got score %v", s)
+	}
+	h.onFailure(conf, "a")
+	if s := h.score(1); s != 0 {
+		t.Fatalf("path should be down at fail threshold, but got score %v", s)
+	}
+	h.onSuccess()
+	if s := h.score(1); s != 100 {
+		t.Errorf("path should be restored after success, but got score %v", s)
+	}
+}
+
+func TestPathHealthPingAverage(t *testing.T) {
+	h := &pathHealth{loss: 0.8}
+	h.onPing(80 * time.Millisecond)
+	if h.rtt != 80*time.Millisecond {
+		t.Fatalf("first ping should be taken as rtt, but got %v", h.rtt)
+	}
+	h.onPing(160 * time.Millisecond)
+	if h.rtt != 90*time.Millisecond {
+		t.Errorf("expect smoothed rtt 90ms, but got %v", h.rtt)
+	}
+	if math.Abs(h.loss-0.8*7/8*7/8) > 0.0001 {
+		t.Errorf("expect loss decayed by pings, but got %v", h.loss)
+	}
+}
+
+// unreachableChannel fail to create any session
+type unreachableChannel struct{}
+
+func (unreachableChannel) CreateMuxSession(server string, conf *ProxyChannelConfig) (mux.MuxSession, error) {
+	return nil, errors.New("unreachable")
+}
+func (unreachableChannel) Features() FeatureSet {
+	return FeatureSet{}
+}
+
+func TestBondingStreamSkipDownPaths(t *testing.T) {
+	ch := NewProxyChannel(&ProxyChannelConfig{Name: "bonding", Bonding: BondingConfig{Enable: true, Weights: map[string]int{"bad": 1000000}, FailThreshold: 1, RecoverAfterSecs: 30}})
+	client, server := newTestSessionPair(t)
+	defer server.Close()
+	good := &muxSessionHolder{server: "good", conf: &ch.Conf, Channel: unreachableChannel{}, muxSession: client, retiredSessions: make(map[mux.MuxSession]bool)}
+	bad := &muxSessionHolder{server: "bad", conf: &ch.Conf, Channel: unreachableChannel{}, retiredSessions: make(map[mux.MuxSession]bool)}
+	ch.sessions[good] = true
+	ch.sessions[bad] = true
+	//the heavy bad path is tried first & marked down, later streams go to the good path directly
+	for i := 0; i < 10; i++ {
+		stream, err := ch.getBondingMuxStream()
+		if nil != err {
+			t.Fatalf("stream %d should fall through to the good path, but got %v", i, err)
+		}
+		stream.Close()
+	}
+	if bad.health.fails != 1 || bad.health.score(1) != 0 {
+		t.Errorf("bad path should be marked down after one failure, but got %d fails", bad.health.fails)
+	}
+	if good.health.fails != 0 {
+		t.Errorf("good path should have no failure, but got %d", good.health.fails)
+	}
+
+	client.Close()
+	if _, err := ch.getBondingMuxStream(); nil == err {
+		t.Fatalf("expect error once the good session shutdown")
+	}
+	if good.health.score(1) != 0 {
+		t.Errorf("good path should be marked down after the session shutdown")
+	}
+	//all paths down are still tried
+	if _, err := ch.getBondingMuxStream(); nil == err {
+		t.Fatalf("expect error once all paths failed")
+	}
+	if good.health.fails != 2 || bad.health.fails != 2 {
+		t.Errorf("down path should be retried when all paths are down, but got %d fails", bad.health.fails)
+	}
+}
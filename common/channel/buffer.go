@@ -0,0 +1,54 @@
+package channel
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// bufferPool reuse copy buffers of one size, so that thousands of concurrent streams do not allocate
+// two fresh buffers each
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+var defaultStreamBufferPool = newBufferPool(128 * 1024)
+
+// *bufferPool of 'StreamBuffer' size, replaced as a whole once the size changed
+var streamBufferPool atomic.Value
+
+func setStreamBufferSize(size int) {
+	streamBufferSize = size
+	if currentBufferPool().size != size {
+		streamBufferPool.Store(newBufferPool(size))
+	}
+}
+
+func currentBufferPool() *bufferPool {
+	if p, ok := streamBufferPool.Load().(*bufferPool); ok {
+		return p
+	}
+	return defaultStreamBufferPool
+}
+
+// GetStreamBuffer return a copy buffer of 'StreamBuffer' size, it should be returned by PutStreamBuffer once
+// the copy is done
+func GetStreamBuffer() *[]byte {
+	return currentBufferPool().pool.Get().(*[]byte)
+}
+
+// PutStreamBuffer return the buffer to the pool, buffers of an old size are dropped
+func PutStreamBuffer(buf *[]byte) {
+	if p := currentBufferPool(); len(*buf) == p.size {
+		p.pool.Put(buf)
+	}
+}
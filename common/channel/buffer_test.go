@@ -0,0 +1,33 @@
+package channel
+
+import "testing"
+
+func TestStreamBufferPool(t *testing.T) {
+	defer setStreamBufferSize(128 * 1024)
+	if buf := GetStreamBuffer(); len(*buf) != 128*1024 {
+		t.Fatalf("expect default 128K buffer, but got %d", len(*buf))
+	}
+	setStreamBufferSize(8192)
+	buf := GetStreamBuffer()
+	if len(*buf) != 8192 {
+		t.Fatalf("expect 8K buffer, but got %d", len(*buf))
+	}
+	setStreamBufferSize(16384)
+	//buffer of the old size is dropped
+	PutStreamBuffer(buf)
+	if buf = GetStreamBuffer(); len(*buf) != 16384 {
+		t.Fatalf("expect 16K buffer, but got %d", len(*buf))
+	}
+	PutStreamBuffer(buf)
+	if StreamBufferSize() != 16384 {
+		t.Errorf("expect stream buffer size 16K, but got %d", StreamBufferSize())
+	}
+}
+
+func BenchmarkStreamBufferPool(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := GetStreamBuffer()
+		PutStreamBuffer(buf)
+	}
+}
@@ -0,0 +1,291 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+const (
+	//re-establish sessions, new connections may pass a blocking by flows
+	EscalateReconnect = "reconnect"
+	//pad auth requests & split data into small frames to change packet sizes
+	EscalatePadding = "padding"
+	//prefer the next server of ServerList
+	EscalateRotate = "rotate"
+	//prefer servers of another transport scheme in ServerList
+	EscalateTransport = "transport"
+)
+
+var defaultEscalations = []string{EscalateReconnect, EscalatePadding, EscalateRotate, EscalateTransport}
+
+var errNoCanarySession = errors.New("No session to fetch canary")
+
+// CanaryConfig fetch the canary url through the channel to detect blocking servers' probes would not notice,
+// like proxied traffic dropped by censors, obfuscation is escalated step by step on sustained failures
+type CanaryConfig struct {
+	//fetched by 'HEAD' like 'https://www.google.com/generate_204', empty disables
+	URL string
+	//default 60
+	IntervalSecs int
+	//default 10000
+	TimeoutMS int
+	//escalate after continuous failures, default 3
+	FailThreshold int
+	//'reconnect', 'padding', 'rotate' & 'transport' tried in order, default all
+	Escalate []string
+}
+
+func (conf *CanaryConfig) adjust() {
+	if conf.IntervalSecs <= 0 {
+		conf.IntervalSecs = 60
+	}
+	if conf.TimeoutMS <= 0 {
+		conf.TimeoutMS = 10000
+	}
+	if conf.FailThreshold <= 0 {
+		conf.FailThreshold = 3
+	}
+	if len(conf.Escalate) == 0 {
+		conf.Escalate = defaultEscalations
+	}
+}
+
+// ValidEscalation return true if step is a known escalation step
+func ValidEscalation(step string) bool {
+	for _, s := range defaultEscalations {
+		if strings.EqualFold(s, step) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanaryStatus is the state of the canary of a channel
+type CanaryStatus struct {
+	URL      string
+	OK       bool
+	Failures int
+	//steps applied since the canary failed
+	Escalated []string `json:",omitempty"`
+	//the latest step after which the canary recovered
+	WorkedBy  string `json:",omitempty"`
+	LastError string `json:",omitempty"`
+}
+
+type canaryState struct {
+	mutex  sync.Mutex
+	status CanaryStatus
+	failed bool
+	step   int
+	//servers preferred less by 'rotate' & 'transport'
+	demoted map[string]bool
+}
+
+func (c *canaryState) demotedServers() map[string]bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.demoted
+}
+
+// streamConn adapt a mux stream to net.Conn for http clients
+type streamConn struct {
+	mux.MuxStream
+	reader io.Reader
+	writer io.Writer
+}
+
+func (c *streamConn) Read(p []byte) (int, error)  { return c.reader.Read(p) }
+func (c *streamConn) Write(p []byte) (int, error) { return c.writer.Write(p) }
+func (c *streamConn) LocalAddr() net.Addr         { return &net.TCPAddr{} }
+func (c *streamConn) RemoteAddr() net.Addr        { return &net.TCPAddr{} }
+
+func (c *streamConn) Close() error {
+	if closer, ok := c.reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return c.MuxStream.Close()
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	c.MuxStream.SetReadDeadline(t)
+	return c.MuxStream.SetWriteDeadline(t)
+}
+
+// fetchCanary fetch the canary by the session new streams prefer, the server of the session is returned
+func (ch *LocalProxyChannel) fetchCanary() (string, error) {
+	holders := ch.selectSessions("")
+	if len(holders) == 0 {
+		return "", errNoCanarySession
+	}
+	holder := holders[0]
+	hc := &http.Client{
+		Timeout: time.Duration(ch.Conf.Canary.TimeoutMS) * time.Millisecond,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			Dial: func(network, addr string) (net.Conn, error) {
+				stream, err := holder.getNewStream()
+				if nil != err {
+					return nil, err
+				}
+				if err = stream.Connect("tcp", addr, mux.StreamOptions{DialTimeout: ch.Conf.RemoteDialMSTimeout}); nil != err {
+					stream.Close()
+					return nil, err
+				}
+				reader, writer := mux.GetCompressStreamReaderWriter(stream, ch.Conf.Compressor)
+				return &streamConn{MuxStream: stream, reader: reader, writer: writer}, nil
+			},
+		},
+	}
+	res, err := hc.Head(ch.Conf.Canary.URL)
+	if nil != err {
+		return holder.server, err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 500 {
+		return holder.server, fmt.Errorf("canary responsed status:%d", res.StatusCode)
+	}
+	return holder.server, nil
+}
+
+// canaryCheck fetch the canary periodically until the channel is replaced
+func (ch *LocalProxyChannel) canaryCheck() {
+	ticker := time.NewTicker(time.Duration(ch.Conf.Canary.IntervalSecs) * time.Second)
+	defer ticker.Stop()
+	ch.canary.mutex.Lock()
+	ch.canary.status.URL = ch.Conf.Canary.URL
+	ch.canary.mutex.Unlock()
+	for range ticker.C {
+		localChannelMutex.Lock()
+		current := localChannelTable[ch.Conf.Name] == ch
+		localChannelMutex.Unlock()
+		if !current {
+			return
+		}
+		if ch.Conf.lazyConnect && !ch.connected() {
+			//canary should not connect sessions of lazy channels
+			continue
+		}
+		server, err := ch.fetchCanary()
+		ch.onCanaryResult(server, err)
+	}
+}
+
+func (ch *LocalProxyChannel) connected() bool {
+	for holder := range ch.sessions {
+		if holder.connected() {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *LocalProxyChannel) onCanaryResult(server string, err error) {
+	c := &ch.canary
+	conf := &ch.Conf.Canary
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil == err {
+		if c.failed {
+			if n := len(c.status.Escalated); n > 0 {
+				c.status.WorkedBy = c.status.Escalated[n-1]
+				logger.Notice("Canary of channel:%s recovered after escalations:%v", ch.Conf.Name, c.status.Escalated)
+			} else {
+				logger.Notice("Canary of channel:%s recovered", ch.Conf.Name)
+			}
+		}
+		c.failed, c.step = false, 0
+		c.status.OK, c.status.Failures, c.status.Escalated, c.status.LastError = true, 0, nil, ""
+		return
+	}
+	c.failed = true
+	c.status.OK = false
+	c.status.Failures++
+	c.status.LastError = err.Error()
+	logger.Debug("Canary of channel:%s failed by %s with reason:%v", ch.Conf.Name, server, err)
+	if c.status.Failures%conf.FailThreshold != 0 {
+		return
+	}
+	if c.step >= len(conf.Escalate) {
+		//nothing worked, start over from no escalation
+		logger.Error("[ERROR]Canary of channel:%s still failed after escalations:%v", ch.Conf.Name, c.status.Escalated)
+		c.step, c.status.Escalated, c.demoted = 0, nil, nil
+		atomic.StoreInt32(&ch.Conf.padding, 0)
+		return
+	}
+	step := strings.ToLower(conf.Escalate[c.step])
+	c.step++
+	c.status.Escalated = append(c.status.Escalated, step)
+	logger.Notice("Canary of channel:%s failed %d times by %s, escalate:%s", ch.Conf.Name, c.status.Failures, server, step)
+	ch.escalate(step, server)
+}
+
+// escalate apply the step, the canary mutex is held
+func (ch *LocalProxyChannel) escalate(step string, server string) {
+	demote := func(match func(s string) bool) {
+		//copied on write since selecting sessions reads it without lock
+		demoted := make(map[string]bool, len(ch.canary.demoted)+1)
+		for s := range ch.canary.demoted {
+			demoted[s] = true
+		}
+		for holder := range ch.sessions {
+			if match(holder.server) {
+				demoted[holder.server] = true
+			}
+		}
+		ch.canary.demoted = demoted
+	}
+	switch step {
+	case EscalateReconnect:
+		ch.reconnect()
+	case EscalatePadding:
+		atomic.StoreInt32(&ch.Conf.padding, 1)
+		ch.reconnect()
+	case EscalateRotate:
+		demote(func(s string) bool { return s == server })
+	case EscalateTransport:
+		scheme := serverScheme(server)
+		demote(func(s string) bool { return serverScheme(s) == scheme })
+	}
+}
+
+// reconnect retire current sessions, streams relaying are not interrupted
+func (ch *LocalProxyChannel) reconnect() {
+	for holder := range ch.sessions {
+		holder.sessionMutex.Lock()
+		session := holder.muxSession
+		holder.sessionMutex.Unlock()
+		if nil != session {
+			holder.retire(session)
+		}
+	}
+}
+
+func serverScheme(server string) string {
+	if u, err := url.Parse(server); nil == err {
+		return strings.ToLower(u.Scheme)
+	}
+	return ""
+}
+
+// paddedAuth pad the auth request beyond the randomized range of fingerprint profiles
+func paddedAuth(req *mux.AuthRequest) {
+	req.Rand = helper.RandAsciiString(maxAuthPadding + rand.Intn(maxAuthPadding))
+}
+
+// paddedFrameSize return a small frame size in [1K, 4K) splitting data into small packets
+func paddedFrameSize() int {
+	return minMuxFrameSize + rand.Intn(3*minMuxFrameSize)
+}
@@ -0,0 +1,54 @@
+package channel
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCanaryEscalation(t *testing.T) {
+	ch := NewProxyChannel(&ProxyChannelConfig{Name: "canary", Canary: CanaryConfig{URL: "http://example.com/", FailThreshold: 2}})
+	ch.Conf.Canary.adjust()
+	servers := []string{"wss://a.example.com", "wss://b.example.com", "quic://c.example.com:443"}
+	for _, server := range servers {
+		ch.sessions[&muxSessionHolder{server: server, conf: &ch.Conf}] = true
+	}
+	order := func() []string {
+		var s []string
+		for _, holder := range ch.selectSessions("") {
+			s = append(s, holder.server)
+		}
+		return s
+	}
+	errBlocked := errors.New("blocked")
+	fail := func(server string) {
+		ch.onCanaryResult(server, errBlocked)
+		ch.onCanaryResult(server, errBlocked)
+	}
+	fail(servers[0])
+	fail(servers[0])
+	if ch.Conf.padding == 0 || !reflect.DeepEqual(ch.canary.status.Escalated, []string{EscalateReconnect, EscalatePadding}) {
+		t.Fatalf("expect reconnect & padding escalated, but got %v", ch.canary.status.Escalated)
+	}
+	fail(servers[0])
+	if s := order(); s[len(s)-1] != servers[0] {
+		t.Errorf("rotated server should be tried last, but got %v", s)
+	}
+	fail(servers[1])
+	if s := order(); s[0] != servers[2] {
+		t.Errorf("server of another transport should be tried first, but got %v", s)
+	}
+	ch.onCanaryResult(servers[2], nil)
+	st := ch.canary.status
+	if !st.OK || st.WorkedBy != EscalateTransport || len(st.Escalated) > 0 {
+		t.Errorf("expect recovered by transport, but got %+v", st)
+	}
+
+	//nothing worked, escalation starts over
+	for i := 0; i < 5; i++ {
+		fail(servers[2])
+	}
+	if ch.Conf.padding != 0 || len(ch.canary.demotedServers()) > 0 || len(ch.canary.status.Escalated) > 0 {
+		t.Errorf("escalations should be reset after all failed, but got %+v", ch.canary.status)
+	}
+}
@@ -0,0 +1,104 @@
+package channel
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// StreamChecksumStats count streams verified by checksums reported from server, streams not fully
+// read/written by either side are counted as incomplete.
+type StreamChecksumStats struct {
+	Verified   int64
+	Mismatched int64
+	Incomplete int64
+}
+
+var streamChecksumStats StreamChecksumStats
+
+type streamChecksumRecord struct {
+	local  bool
+	target string
+	up     mux.Checksum
+	down   mux.Checksum
+	remote *mux.ControlMessage
+	expire time.Time
+}
+
+var pendingStreamChecksums = make(map[string]*streamChecksumRecord)
+var pendingStreamChecksumsMutex sync.Mutex
+
+const streamChecksumWaitTime = time.Minute
+
+func streamChecksumKey(sessionID string, streamID uint32) string {
+	return fmt.Sprintf("%s:%d", sessionID, streamID)
+}
+
+func verifyStreamChecksum(key string, r *streamChecksumRecord) {
+	msg := r.remote
+	if r.up.Bytes != msg.Recv.Bytes || r.down.Bytes != msg.Sent.Bytes {
+		atomic.AddInt64(&streamChecksumStats.Incomplete, 1)
+		return
+	}
+	if r.up.CRC != msg.Recv.CRC || r.down.CRC != msg.Sent.CRC {
+		atomic.AddInt64(&streamChecksumStats.Mismatched, 1)
+		logger.Error("[ERROR]Stream[%s] to %s corrupted, checksum up:%08x/%08x down:%08x/%08x(local/remote)",
+			key, r.target, r.up.CRC, msg.Recv.CRC, r.down.CRC, msg.Sent.CRC)
+		return
+	}
+	atomic.AddInt64(&streamChecksumStats.Verified, 1)
+}
+
+// mergeStreamChecksum verify the stream once both local and remote checksums arrived
+func mergeStreamChecksum(key string, update func(r *streamChecksumRecord)) {
+	pendingStreamChecksumsMutex.Lock()
+	defer pendingStreamChecksumsMutex.Unlock()
+	now := time.Now()
+	r, exist := pendingStreamChecksums[key]
+	if !exist {
+		r = &streamChecksumRecord{expire: now.Add(streamChecksumWaitTime)}
+		pendingStreamChecksums[key] = r
+	}
+	update(r)
+	if nil != r.remote && r.local {
+		delete(pendingStreamChecksums, key)
+		verifyStreamChecksum(key, r)
+	}
+	if !exist && len(pendingStreamChecksums)%1024 == 0 {
+		for k, v := range pendingStreamChecksums {
+			if v.expire.Before(now) {
+				delete(pendingStreamChecksums, k)
+			}
+		}
+	}
+}
+
+// RecordStreamChecksum record checksums of the closed local stream to verify with the server's report
+func RecordStreamChecksum(stream mux.MuxStream, target string, up, down mux.Checksum) {
+	sessionID := mux.GetStreamSessionID(stream)
+	if len(sessionID) == 0 {
+		return
+	}
+	mergeStreamChecksum(streamChecksumKey(sessionID, stream.StreamID()), func(r *streamChecksumRecord) {
+		r.local, r.target, r.up, r.down = true, target, up, down
+	})
+}
+
+func onRemoteStreamChecksum(sessionID string, msg *mux.ControlMessage) {
+	mergeStreamChecksum(streamChecksumKey(sessionID, msg.StreamID), func(r *streamChecksumRecord) {
+		r.remote = msg
+	})
+}
+
+// GetStreamChecksumStats return the stats of stream checksum verification
+func GetStreamChecksumStats() StreamChecksumStats {
+	return StreamChecksumStats{
+		Verified:   atomic.LoadInt64(&streamChecksumStats.Verified),
+		Mismatched: atomic.LoadInt64(&streamChecksumStats.Mismatched),
+		Incomplete: atomic.LoadInt64(&streamChecksumStats.Incomplete),
+	}
+}
@@ -0,0 +1,73 @@
+package channel
+
+import (
+	"hash/crc32"
+	"net"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestStreamChecksumVerify(t *testing.T) {
+	up := mux.Checksum{CRC: crc32.ChecksumIEEE([]byte("request")), Bytes: 7}
+	down := mux.Checksum{CRC: crc32.ChecksumIEEE([]byte("response")), Bytes: 8}
+	corrupted := mux.Checksum{CRC: crc32.ChecksumIEEE([]byte("responsE")), Bytes: 8}
+	tests := []struct {
+		name        string
+		remoteFirst bool
+		recv, sent  mux.Checksum
+		expect      StreamChecksumStats
+	}{
+		{"verified", false, up, down, StreamChecksumStats{Verified: 1}},
+		{"verified remote first", true, up, down, StreamChecksumStats{Verified: 1}},
+		{"corrupted down", false, up, corrupted, StreamChecksumStats{Mismatched: 1}},
+		{"corrupted up", true, mux.Checksum{CRC: crc32.ChecksumIEEE([]byte("requesT")), Bytes: 7}, down, StreamChecksumStats{Mismatched: 1}},
+		{"truncated", false, up, mux.Checksum{CRC: down.CRC, Bytes: 4}, StreamChecksumStats{Incomplete: 1}},
+	}
+	for _, tt := range tests {
+		local, remote := net.Pipe()
+		remote.Close()
+		stream := mux.WithSessionID(&mux.ProxyMuxStream{TimeoutReadWriteCloser: local}, "checksum-test")
+		before := GetStreamChecksumStats()
+		msg := &mux.ControlMessage{Type: mux.ControlStreamChecksum, StreamID: stream.StreamID(), Recv: tt.recv, Sent: tt.sent}
+		if tt.remoteFirst {
+			onRemoteStreamChecksum("checksum-test", msg)
+		}
+		RecordStreamChecksum(stream, "example.com:80", up, down)
+		if !tt.remoteFirst {
+			onRemoteStreamChecksum("checksum-test", msg)
+		}
+		after := GetStreamChecksumStats()
+		delta := StreamChecksumStats{
+			Verified:   after.Verified - before.Verified,
+			Mismatched: after.Mismatched - before.Mismatched,
+			Incomplete: after.Incomplete - before.Incomplete,
+		}
+		if delta != tt.expect {
+			t.Errorf("%s: expect %+v, but got %+v", tt.name, tt.expect, delta)
+		}
+		pendingStreamChecksumsMutex.Lock()
+		_, pending := pendingStreamChecksums[streamChecksumKey("checksum-test", stream.StreamID())]
+		pendingStreamChecksumsMutex.Unlock()
+		if pending {
+			t.Errorf("%s: verified stream should not be pending", tt.name)
+		}
+		local.Close()
+	}
+}
+
+func TestStreamChecksumWithoutSession(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	defer local.Close()
+	pendingStreamChecksumsMutex.Lock()
+	n := len(pendingStreamChecksums)
+	pendingStreamChecksumsMutex.Unlock()
+	//streams of sessions without id are never reported by server
+	RecordStreamChecksum(&mux.ProxyMuxStream{TimeoutReadWriteCloser: local}, "example.com:80", mux.Checksum{}, mux.Checksum{})
+	pendingStreamChecksumsMutex.Lock()
+	defer pendingStreamChecksumsMutex.Unlock()
+	if len(pendingStreamChecksums) != n {
+		t.Errorf("expect no pending checksum of stream without session id")
+	}
+}
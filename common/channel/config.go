@@ -3,10 +3,13 @@ package channel
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/yinqiwen/gsnova/common/helper"
 	"github.com/yinqiwen/gsnova/common/logger"
@@ -17,39 +20,50 @@ import (
 var ErrNotSupportedOperation = errors.New("Not supported operation")
 
 type ProxyLimitConfig struct {
+	//patterns like '*.example.com', '.example.com', 'regex:<expr>', '10.0.0.0/8', 'ipset:<name>' or globs
 	WhiteList []string
 	BlackList []string
+	//named sets of CIDRs/IPs referenced by 'ipset:<name>' patterns
+	IPSets map[string][]string
+
+	//ISO country codes of destinations resolved by the MaxMind database 'GeoIPDB'
+	AllowCountries []string
+	DenyCountries  []string
+	GeoIPDB        string
+
+	//max concurrent streams to the same destination host in total & per user, 0 means unlimited
+	MaxConnsPerHost     int
+	MaxUserConnsPerHost int
+
+	PortLimitConfig
+
+	whiteMatcher *helper.HostMatcher
+	blackMatcher *helper.HostMatcher
+}
+
+// compile precompile matchers of white/black list, 'IPSets' are loaded by helper.SetIPSets of the caller
+func (limit *ProxyLimitConfig) compile() {
+	limit.whiteMatcher = helper.NewHostMatcher(limit.WhiteList)
+	limit.blackMatcher = helper.NewHostMatcher(limit.BlackList)
 }
 
 func (limit *ProxyLimitConfig) Allowed(host string) bool {
+	return limit.allowedHost(host) && limit.allowedCountry(host)
+}
+
+func (limit *ProxyLimitConfig) allowedHost(host string) bool {
 	if len(limit.WhiteList) == 0 && len(limit.BlackList) == 0 {
 		return true
 	}
-	if len(limit.BlackList) > 0 {
-		for _, rule := range limit.BlackList {
-			if rule == "*" {
-				return false
-			} else {
-				matched, _ := filepath.Match(rule, host)
-				if matched {
-					return false
-				}
-			}
-		}
-		return true
+	whiteMatcher, blackMatcher := limit.whiteMatcher, limit.blackMatcher
+	if nil == whiteMatcher || nil == blackMatcher {
+		//not compiled by SetDefaultProxyLimitConfig
+		whiteMatcher, blackMatcher = helper.NewHostMatcher(limit.WhiteList), helper.NewHostMatcher(limit.BlackList)
 	}
-
-	for _, rule := range limit.WhiteList {
-		if rule == "*" {
-			return true
-		} else {
-			matched, _ := filepath.Match(rule, host)
-			if matched {
-				return true
-			}
-		}
+	if len(limit.BlackList) > 0 {
+		return !blackMatcher.Match(host)
 	}
-	return false
+	return whiteMatcher.Match(host)
 }
 
 type FeatureSet struct {
@@ -62,6 +76,21 @@ type MuxConfig struct {
 	StreamMinRefresh   string
 	StreamIdleTimeout  int
 	SessionIdleTimeout int
+
+	//reset compress context of streams idle for seconds or every bytes written like '4m', bound memory of long lived streams
+	CompressResetIdle  int
+	CompressResetBytes string
+	//stop compressing a stream if it saves less than 'CompressMinRatio'(default 1.05) in a window of written bytes
+	//like '1M'(default), '0' never stops
+	CompressProbeBytes string
+	CompressMinRatio   float64
+
+	//max bytes per data frame written by sessions like '16K', channel's 'MaxFrameSize' takes precedence on client
+	MaxFrameSize string
+	//copy buffer of each direction of proxy streams pooled for reuse, larger buffers help high-BDP links, default '128K'
+	StreamBuffer string
+	//max streams opened by peer & waiting to be accepted
+	AcceptBacklog int
 }
 
 func (m *MuxConfig) ToPMuxConf() *pmux.Config {
@@ -70,8 +99,11 @@ func (m *MuxConfig) ToPMuxConf() *pmux.Config {
 
 	if len(m.MaxStreamWindow) > 0 {
 		v, err := helper.ToBytes(m.MaxStreamWindow)
-		if nil == err {
+		if nil == err && v >= 256*1024 {
 			cfg.MaxStreamWindowSize = uint32(v)
+		} else {
+			//pmux reject windows smaller than the initial 256K window
+			logger.Error("[ERROR]Invalid MaxStreamWindow:%s, it must be no less than 256K", m.MaxStreamWindow)
 		}
 	}
 	if len(m.StreamMinRefresh) > 0 {
@@ -80,26 +112,86 @@ func (m *MuxConfig) ToPMuxConf() *pmux.Config {
 			cfg.StreamMinRefresh = uint32(v)
 		}
 	}
+	if m.AcceptBacklog > 0 {
+		cfg.AcceptBacklog = m.AcceptBacklog
+	}
 	return cfg
 }
 
+type ClientVersionLimitConfig struct {
+	MinVersion       string
+	MinProtocolLevel int
+}
+
+//check return the reason if the client is not allowed
+func (conf *ClientVersionLimitConfig) check(auth *mux.AuthRequest) string {
+	if conf.MinProtocolLevel > 0 && auth.ProtocolLevel < conf.MinProtocolLevel {
+		return fmt.Sprintf("client protocol level %d is lower than required %d, please upgrade gsnova", auth.ProtocolLevel, conf.MinProtocolLevel)
+	}
+	if len(conf.MinVersion) > 0 && CompareVersion(auth.Version, conf.MinVersion) < 0 {
+		version := auth.Version
+		if len(version) == 0 {
+			version = "unknown"
+		}
+		return fmt.Sprintf("client version %s is lower than required %s, please upgrade gsnova", version, conf.MinVersion)
+	}
+	return ""
+}
+
+// client version limit of new sessions, replaced as a whole on reload
+var clientVersionLimit atomic.Value
+
+func SetClientVersionLimit(cfg ClientVersionLimitConfig) {
+	clientVersionLimit.Store(&cfg)
+}
+
+func getClientVersionLimit() *ClientVersionLimitConfig {
+	if cfg, ok := clientVersionLimit.Load().(*ClientVersionLimitConfig); ok {
+		return cfg
+	}
+	return &ClientVersionLimitConfig{}
+}
+
 type CipherConfig struct {
 	User   string
 	Method string
 	Key    string
+	//client side base32 TOTP secret of the user
+	TOTPSecret string
+	//client side per user key, the session key is derived from it instead of 'Key' after auth
+	UserKey string
+	//client side password or access token verified by the 'AuthProvider' of server
+	Credential string
 
 	allowedUser []string
 }
 
+func IsValidCipherMethod(method string) bool {
+	switch method {
+	case pmux.CipherChacha20Poly1305:
+	case pmux.CipherSalsa20:
+	case pmux.CipherAES256GCM:
+	case pmux.CipherNone:
+	default:
+		return false
+	}
+	return true
+}
+
 func (conf *CipherConfig) Adjust() {
 	if len(conf.Method) == 0 {
 		conf.Method = "auto"
 	}
+	switch strings.ToLower(conf.Method) {
+	case "chacha20-poly1305", "chacha20_poly1305", "chacha20":
+		conf.Method = pmux.CipherChacha20Poly1305
+	}
 	switch conf.Method {
 	case "auto":
 		if strings.Contains(runtime.GOARCH, "386") || strings.Contains(runtime.GOARCH, "amd64") {
 			conf.Method = pmux.CipherAES256GCM
-		} else if strings.Contains(runtime.GOARCH, "arm") {
+		} else {
+			//chacha20poly1305 is much faster than aes on cpus without aes instructions(arm/mips routers)
 			conf.Method = pmux.CipherChacha20Poly1305
 		}
 	case pmux.CipherChacha20Poly1305:
@@ -118,8 +210,16 @@ func (conf *CipherConfig) AllowUsers(users string) {
 	}
 }
 
-func (conf *CipherConfig) VerifyUser(user string) bool {
-	if len(conf.allowedUser) == 0 {
+//VerifyUser verify the user and its TOTP code if the user configured with TOTP secret, the static user
+//list is ignored once an auth provider configured
+func (conf *CipherConfig) VerifyUser(user string, totp string) bool {
+	if uc := getUserConfig(user); nil != uc && len(uc.TOTPSecret) > 0 {
+		if !helper.VerifyTOTP(uc.TOTPSecret, totp, time.Now(), 1) {
+			logger.Error("[ERROR]Invalid TOTP code for user:%s", user)
+			return false
+		}
+	}
+	if len(conf.allowedUser) == 0 || authProviderEnabled() {
 		return true
 	}
 	for _, u := range conf.allowedUser {
@@ -141,10 +241,32 @@ type HTTPBaseConfig struct {
 	UserAgent               string
 	ReadTimeout             int
 }
+type GRPCConfig struct {
+	//override the :authority header, useful for CDN fronting
+	Authority string
+	//tunnel method path like '/pkg.Service/Method'
+	Path string
+}
+
 type HTTPConfig struct {
 	HTTPBaseConfig
 }
 
+// SSHConfig of ssh channels, jump hosts & the server are authed by the user/password or 'key' of their urls
+type SSHConfig struct {
+	//bastions like 'ssh://user@host:22?key=./id_ed25519' dialed in order before the server, like ProxyJump
+	JumpHosts []string
+	//passphrase of encrypted private keys
+	KeyPassphrase string
+	//auth by keys of the ssh-agent at 'AgentSocket', default env SSH_AUTH_SOCK
+	Agent       bool
+	AgentSocket string
+	//host keys of the server & jump hosts pinned as 'SHA256:<base64>' fingerprints or authorized_keys lines
+	HostKeys []string
+	//known_hosts file verifying host keys, the key of any host is accepted with a warning if neither set
+	KnownHosts string
+}
+
 func (hcfg *HTTPConfig) UnmarshalJSON(data []byte) error {
 	hcfg.HTTPPushRateLimitPerSec = 3
 	hcfg.ReadTimeout = 30000
@@ -153,6 +275,7 @@ func (hcfg *HTTPConfig) UnmarshalJSON(data []byte) error {
 }
 
 type KCPBaseConfig struct {
+	//'normal', 'fast', 'fast2', 'fast3' presets of nodelay parameters, or 'manual'
 	Mode         string
 	Conn         int
 	AutoExpire   int
@@ -245,14 +368,74 @@ type ProxyChannelConfig struct {
 	Compressor             string
 	KCP                    KCPConfig
 	HTTP                   HTTPConfig
+	GRPC                   GRPCConfig
 	Cipher                 CipherConfig
 	Hops                   HopServers
 	RemoteSNIProxy         map[string]string
 	HibernateAfterSecs     int
 	P2SPRoom               string
+	//try direct webrtc connection with P2SP peer, fallback to server relay if failed
+	P2PWebRTC  bool
+	ICEServers []string
+	Bonding    BondingConfig
+	P2SPRelay  P2SPRelayConfig
+	Rendezvous RendezvousConfig
+	TLS        TLSPolicyConfig
+	//mimic ClientHello of 'chrome', 'firefox', 'safari', 'ios', 'edge' or 'randomized' for tls/wss/http2 servers, empty means stock go tls
+	UTLS       string
+	ECH        ECHConfig
+	KeyLogFile string
+	//max bytes per mux data frame like '16K' for transports requiring small frames, also applied by server
+	MaxFrameSize string
+	//verify relayed streams by checksums reported from server, results are counted in stats
+	StreamChecksum bool
+	//send the first client bytes(like TLS ClientHello) within the connect request to save a round trip, ignored for servers older than protocol level 3
+	EarlyData bool
+	//expose local services through the server
+	Reverse []ReverseTunnelConfig
+	//probe servers by ping streams, new streams prefer healthy servers
+	HealthCheck HealthCheckConfig
+	//strategy to select server of new streams when ServerList has several servers
+	Select SelectConfig
+	//min/max sessions per server & streams per session
+	Pool SessionPoolConfig
+	//ping idle sessions with jitter to survive aggressive NAT/CDN idle timeouts
+	KeepAlive KeepAliveConfig
+	//StartupEager or StartupLazy
+	Startup string
+	//fetch a canary url through the channel & escalate obfuscation on sustained failures
+	Canary CanaryConfig
+	//jump hosts, agent/encrypted key auth & host key pinning of ssh servers
+	SSH SSHConfig
 
 	proxyURL    *url.URL
 	lazyConnect bool
+	//set by canary escalation, new sessions pad auth requests & use small frames
+	padding int32
+}
+
+const (
+	//connect sessions of the channel at startup, the first request is fast
+	StartupEager = "eager"
+	//connect sessions on the first stream, no footprint of idle channels
+	StartupLazy = "lazy"
+)
+
+// ValidStartup return true if s is a known startup profile, empty is valid too
+func ValidStartup(s string) bool {
+	return len(s) == 0 || strings.EqualFold(s, StartupEager) || strings.EqualFold(s, StartupLazy)
+}
+
+func (conf *ProxyChannelConfig) maxFrameSize() int {
+	if len(conf.MaxFrameSize) == 0 {
+		return muxMaxFrameSize
+	}
+	v, err := helper.ToBytes(conf.MaxFrameSize)
+	if nil != err {
+		logger.Error("[ERROR]Invalid MaxFrameSize:%s with reason:%v", conf.MaxFrameSize, err)
+		return 0
+	}
+	return clampFrameSize(int(v))
 }
 
 func (conf *ProxyChannelConfig) GetRemoteSNI(domain string) string {
@@ -287,6 +470,7 @@ func (conf *ProxyChannelConfig) Adjust() {
 	if conf.ConnsPerServer == 0 {
 		conf.ConnsPerServer = 3
 	}
+	conf.adjustPool()
 	if 0 == conf.RemoteDNSReadMSTimeout {
 		conf.RemoteDNSReadMSTimeout = 1000
 	}
@@ -299,14 +483,41 @@ func (conf *ProxyChannelConfig) Adjust() {
 	if 0 == conf.HTTP.ReadTimeout {
 		conf.HTTP.ReadTimeout = 30000
 	}
-	if 0 == conf.RemoteDialMSTimeout {
-		conf.RemoteDialMSTimeout = 5000
+	if conf.Bonding.FailThreshold <= 0 {
+		conf.Bonding.FailThreshold = 3
+	}
+	if conf.Bonding.RecoverAfterSecs <= 0 {
+		conf.Bonding.RecoverAfterSecs = 30
+	}
+	conf.HealthCheck.adjust()
+	conf.Canary.adjust()
+	if strings.EqualFold(conf.Startup, StartupLazy) {
+		conf.lazyConnect = true
 	}
 	if 0 == conf.HibernateAfterSecs {
 		conf.HibernateAfterSecs = 1800
 	}
 }
 
+// CheckUserKey reject the per user key on channels not encrypted by the mux cipher, since no session key
+// would be derived from it there
+func (conf *ProxyChannelConfig) CheckUserKey() error {
+	if len(conf.Cipher.UserKey) == 0 {
+		return nil
+	}
+	for _, server := range conf.ServerList {
+		u, err := url.Parse(server)
+		if nil != err {
+			continue
+		}
+		switch u.Scheme {
+		case "http2", "quic", "ssh":
+			return fmt.Errorf("per user key is not supported by %s server:%s of channel:%s", u.Scheme, server, conf.Name)
+		}
+	}
+	return nil
+}
+
 func (c *ProxyChannelConfig) ProxyURL() *url.URL {
 	if nil != c.proxyURL {
 		return c.proxyURL
@@ -323,13 +534,99 @@ func (c *ProxyChannelConfig) ProxyURL() *url.URL {
 
 //var DefaultCipherKey string
 var defaultMuxConfig MuxConfig
-var defaultProxyLimitConfig ProxyLimitConfig
+var muxMaxFrameSize int
+
+// smaller frames are not allowed, tiny frames requested by peers would waste cpu on framing
+const minMuxFrameSize = 1024
+
+func clampFrameSize(size int) int {
+	if size > 0 && size < minMuxFrameSize {
+		return minMuxFrameSize
+	}
+	return size
+}
+var streamBufferSize = 128 * 1024
+
+// *ProxyLimitConfig of new streams, compiled & replaced as a whole
+var defaultProxyLimitConfig atomic.Value
+
+func defaultProxyLimit() *ProxyLimitConfig {
+	if cfg, ok := defaultProxyLimitConfig.Load().(*ProxyLimitConfig); ok {
+		return cfg
+	}
+	return &ProxyLimitConfig{}
+}
 
 func SetDefaultMuxConfig(cfg MuxConfig) {
 	defaultMuxConfig = cfg
+	var resetBytes int64
+	if len(cfg.CompressResetBytes) > 0 {
+		v, err := helper.ToBytes(cfg.CompressResetBytes)
+		if nil != err {
+			logger.Error("[ERROR]Invalid CompressResetBytes:%s with reason:%v", cfg.CompressResetBytes, err)
+		} else {
+			resetBytes = int64(v)
+		}
+	}
+	mux.SetCompressReset(time.Duration(cfg.CompressResetIdle)*time.Second, resetBytes)
+	probeBytes := int64(1024 * 1024)
+	if len(cfg.CompressProbeBytes) > 0 {
+		v, err := helper.ToBytes(cfg.CompressProbeBytes)
+		if nil != err {
+			logger.Error("[ERROR]Invalid CompressProbeBytes:%s with reason:%v", cfg.CompressProbeBytes, err)
+		} else {
+			probeBytes = int64(v)
+		}
+	}
+	minRatio := cfg.CompressMinRatio
+	if minRatio <= 0 {
+		minRatio = 1.05
+	}
+	mux.SetCompressAutoDisable(probeBytes, minRatio)
+	muxMaxFrameSize = 0
+	if len(cfg.MaxFrameSize) > 0 {
+		v, err := helper.ToBytes(cfg.MaxFrameSize)
+		if nil != err {
+			logger.Error("[ERROR]Invalid MaxFrameSize:%s with reason:%v", cfg.MaxFrameSize, err)
+		} else {
+			muxMaxFrameSize = clampFrameSize(int(v))
+		}
+	}
+	bufferSize := 128 * 1024
+	if len(cfg.StreamBuffer) > 0 {
+		v, err := helper.ToBytes(cfg.StreamBuffer)
+		if nil != err || v < 4096 {
+			logger.Error("[ERROR]Invalid StreamBuffer:%s, it must be no less than 4K", cfg.StreamBuffer)
+		} else {
+			bufferSize = int(v)
+		}
+	}
+	setStreamBufferSize(bufferSize)
+}
+
+// StreamBufferSize is the copy buffer size of each direction of proxy streams
+func StreamBufferSize() int {
+	return streamBufferSize
 }
 func SetDefaultProxyLimitConfig(cfg ProxyLimitConfig) {
-	defaultProxyLimitConfig = cfg
+	cfg.compile()
+	defaultProxyLimitConfig.Store(&cfg)
+	proxyLimitGeoIP.init(cfg.GeoIPDB)
+}
+
+// ReloadGeoIPDB reload 'GeoIPDB' of the default proxy limit now if the file changed
+func ReloadGeoIPDB() error {
+	return proxyLimitGeoIP.reload()
+}
+
+// GeoIPDBTime return the modification time of the loaded 'GeoIPDB', zero if not loaded
+func GeoIPDBTime() time.Time {
+	proxyLimitGeoIP.mutex.RLock()
+	defer proxyLimitGeoIP.mutex.RUnlock()
+	if nil == proxyLimitGeoIP.reader {
+		return time.Time{}
+	}
+	return proxyLimitGeoIP.modTime
 }
 
 func InitialPMuxConfig(cipher *CipherConfig) *pmux.Config {
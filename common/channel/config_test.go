@@ -0,0 +1,149 @@
+package channel
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+)
+
+func TestCipherConfigAdjust(t *testing.T) {
+	auto := pmux.CipherChacha20Poly1305
+	if strings.Contains(runtime.GOARCH, "386") || strings.Contains(runtime.GOARCH, "amd64") {
+		auto = pmux.CipherAES256GCM
+	}
+	tests := []struct {
+		method string
+		expect string
+	}{
+		{"", auto},
+		{"auto", auto},
+		{"chacha20-poly1305", pmux.CipherChacha20Poly1305},
+		{"Chacha20_Poly1305", pmux.CipherChacha20Poly1305},
+		{"CHACHA20", pmux.CipherChacha20Poly1305},
+		{pmux.CipherChacha20Poly1305, pmux.CipherChacha20Poly1305},
+		{pmux.CipherAES256GCM, pmux.CipherAES256GCM},
+		{pmux.CipherSalsa20, pmux.CipherSalsa20},
+		{pmux.CipherNone, pmux.CipherNone},
+		{"rc4", pmux.CipherChacha20Poly1305},
+	}
+	for _, tt := range tests {
+		conf := &CipherConfig{Method: tt.method}
+		conf.Adjust()
+		if conf.Method != tt.expect {
+			t.Errorf("expect cipher method %s of %q, but got %s", tt.expect, tt.method, conf.Method)
+		}
+		if !IsValidCipherMethod(conf.Method) {
+			t.Errorf("adjusted cipher method %s should be valid", conf.Method)
+		}
+	}
+	for _, method := range []string{"", "auto", "chacha20", "rc4"} {
+		if IsValidCipherMethod(method) {
+			t.Errorf("cipher method %q should be invalid on auth", method)
+		}
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	tests := []struct {
+		size   string
+		expect int
+	}{
+		{"", 0},
+		{"16K", 16 * 1024},
+		{"1m", 1024 * 1024},
+		//sizes need a unit as other byte sizes of config
+		{"4096", 0},
+		{"invalid", 0},
+	}
+	for _, tt := range tests {
+		conf := &ProxyChannelConfig{MaxFrameSize: tt.size}
+		if v := conf.maxFrameSize(); v != tt.expect {
+			t.Errorf("expect max frame size %d of %q, but got %d", tt.expect, tt.size, v)
+		}
+	}
+}
+
+func TestSetDefaultMuxConfigSizes(t *testing.T) {
+	defer SetDefaultMuxConfig(MuxConfig{})
+	tests := []struct {
+		conf         MuxConfig
+		frame        int
+		buffer       int
+		channelFrame string
+		expectFrame  int
+	}{
+		{MuxConfig{}, 0, 128 * 1024, "", 0},
+		{MuxConfig{MaxFrameSize: "16K", StreamBuffer: "1M"}, 16 * 1024, 1024 * 1024, "", 16 * 1024},
+		//channel's frame size takes precedence
+		{MuxConfig{MaxFrameSize: "16K"}, 16 * 1024, 128 * 1024, "32K", 32 * 1024},
+		{MuxConfig{MaxFrameSize: "100B", StreamBuffer: "1K"}, minMuxFrameSize, 128 * 1024, "", minMuxFrameSize},
+		{MuxConfig{MaxFrameSize: "invalid", StreamBuffer: "invalid"}, 0, 128 * 1024, "", 0},
+	}
+	for _, tt := range tests {
+		SetDefaultMuxConfig(tt.conf)
+		if muxMaxFrameSize != tt.frame || StreamBufferSize() != tt.buffer {
+			t.Errorf("%+v: expect frame %d & buffer %d, but got %d & %d", tt.conf, tt.frame, tt.buffer, muxMaxFrameSize, StreamBufferSize())
+		}
+		if v := (&ProxyChannelConfig{MaxFrameSize: tt.channelFrame}).maxFrameSize(); v != tt.expectFrame {
+			t.Errorf("%+v: expect channel frame %d of %q, but got %d", tt.conf, tt.expectFrame, tt.channelFrame, v)
+		}
+	}
+}
+
+func TestMuxConfigToPMuxConf(t *testing.T) {
+	def := pmux.DefaultConfig()
+	tests := []struct {
+		conf    MuxConfig
+		window  uint32
+		backlog int
+	}{
+		{MuxConfig{}, def.MaxStreamWindowSize, def.AcceptBacklog},
+		{MuxConfig{MaxStreamWindow: "1M", AcceptBacklog: 1024}, 1024 * 1024, 1024},
+		//windows smaller than the initial window are ignored
+		{MuxConfig{MaxStreamWindow: "64K", AcceptBacklog: -1}, def.MaxStreamWindowSize, def.AcceptBacklog},
+	}
+	for _, tt := range tests {
+		cfg := tt.conf.ToPMuxConf()
+		if cfg.MaxStreamWindowSize != tt.window || cfg.AcceptBacklog != tt.backlog || cfg.EnableKeepAlive {
+			t.Errorf("%+v: expect window %d & backlog %d, but got %d & %d", tt.conf, tt.window, tt.backlog, cfg.MaxStreamWindowSize, cfg.AcceptBacklog)
+		}
+	}
+}
+
+func TestServerMaxFrameSize(t *testing.T) {
+	defer SetDefaultMuxConfig(MuxConfig{})
+	tests := []struct {
+		server string
+		client int
+		expect int
+	}{
+		{"", 0, 0},
+		{"", 100, minMuxFrameSize},
+		{"", 8192, 8192},
+		//server limit caps frames requested by clients
+		{"4K", 0, 4096},
+		{"4K", 8192, 4096},
+		{"4K", 2048, 2048},
+	}
+	for _, tt := range tests {
+		SetDefaultMuxConfig(MuxConfig{MaxFrameSize: tt.server})
+		client, server := newTestSessionPair(t)
+		go ServProxyMuxSession(server, nil)
+		stream, err := client.OpenStream()
+		if nil != err {
+			t.Fatal(err)
+		}
+		if err = stream.Auth(&mux.AuthRequest{User: "frame-user", CipherMethod: pmux.CipherNone, CompressMethod: mux.NoneCompressor, MaxFrameSize: tt.client}); nil != err {
+			t.Fatal(err)
+		}
+		session := server.(*mux.ProxyMuxSession)
+		if !waitUntil(time.Second, func() bool { return session.MaxFrameSize == tt.expect }) {
+			t.Errorf("server %q & client %d: expect frame size %d, but got %d", tt.server, tt.client, tt.expect, session.MaxFrameSize)
+		}
+		client.Close()
+	}
+}
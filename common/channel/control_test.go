@@ -0,0 +1,76 @@
+package channel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// waitUntil poll 'cond' until it's true or timeout
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestSendControlWithoutControlStream(t *testing.T) {
+	ctx := newSessionContext(nil, &mux.AuthRequest{})
+	if err := ctx.sendControl(&mux.ControlMessage{Type: mux.ControlSessionClosing}); nil != err {
+		t.Errorf("expect no error without control stream, but got %v", err)
+	}
+}
+
+func TestSessionClosingNoticeRetireSession(t *testing.T) {
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	ctx := newSessionContext(server, &mux.AuthRequest{User: "idle"})
+	go servMuxSession(ctx)
+	holder := &muxSessionHolder{
+		server:          "control.test",
+		muxSession:      client,
+		retiredSessions: make(map[mux.MuxSession]bool),
+		controlStreams:  make(map[mux.MuxSession]mux.MuxStream),
+	}
+	go holder.watchControl(client, "control-test")
+	registered := waitUntil(5*time.Second, func() bool {
+		ctx.controlMutex.Lock()
+		defer ctx.controlMutex.Unlock()
+		return nil != ctx.control
+	})
+	if !registered {
+		t.Fatalf("control stream not registered on server")
+	}
+	holder.sessionMutex.Lock()
+	activeStreams := holder.activeStreams(client)
+	holder.sessionMutex.Unlock()
+	if activeStreams != 0 {
+		t.Errorf("control stream should not count as active stream, but got %d", activeStreams)
+	}
+
+	if err := ctx.sendControl(&mux.ControlMessage{Type: mux.ControlSessionClosing, Reason: "idle"}); nil != err {
+		t.Fatal(err)
+	}
+	retired := waitUntil(5*time.Second, func() bool {
+		holder.sessionMutex.Lock()
+		defer holder.sessionMutex.Unlock()
+		return nil == holder.muxSession && holder.retiredSessions[client]
+	})
+	if !retired {
+		t.Fatalf("session should be retired after the closing notice")
+	}
+	//retired session with the control stream only is closed at once
+	holder.sessionMutex.Lock()
+	holder.tryCloseRetiredSessions()
+	remain := len(holder.retiredSessions)
+	holder.sessionMutex.Unlock()
+	if remain != 0 {
+		t.Errorf("retired session without active streams should be removed, but %d remain", remain)
+	}
+	if _, err := client.OpenStream(); nil == err {
+		t.Errorf("retired session without active streams should be closed")
+	}
+}
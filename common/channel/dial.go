@@ -16,13 +16,23 @@ import (
 	"github.com/yinqiwen/gsnova/common/netx"
 )
 
-func NewTLSConfig(conf *ProxyChannelConfig) *tls.Config {
+func NewTLSConfig(conf *ProxyChannelConfig) (*tls.Config, error) {
 	tlscfg := &tls.Config{}
 	tlscfg.InsecureSkipVerify = true
 	if len(conf.SNI) > 0 {
 		tlscfg.ServerName = conf.SNI[0]
 	}
-	return tlscfg
+	tlscfg.KeyLogWriter = keyLogWriter(conf)
+	if err := conf.TLS.Apply(tlscfg); nil != err {
+		logger.Error("[ERROR]Invalid TLS policy for channel:%s with reason:%v", conf.Name, err)
+		return nil, err
+	}
+	return tlscfg, nil
+}
+
+// dialerTLS return true if tls of wss servers is handshaked in dialing rather than by websocket dialers
+func (conf *ProxyChannelConfig) dialerTLS() bool {
+	return len(conf.UTLS) > 0 || conf.ECH.Enable
 }
 
 func DialServerByConf(server string, conf *ProxyChannelConfig) (net.Conn, error) {
@@ -40,7 +50,7 @@ func DialServerByConf(server string, conf *ProxyChannelConfig) (net.Conn, error)
 		case "ssh":
 			tcpPort = "22"
 			tcpHost = rurl.Host
-		case "http2", "https", "quic", "kcp", "tls", "wss":
+		case "http2", "https", "quic", "kcp", "tls", "wss", "grpc":
 			tcpHost = rurl.Host
 			tcpPort = "443"
 		default:
@@ -48,7 +58,10 @@ func DialServerByConf(server string, conf *ProxyChannelConfig) (net.Conn, error)
 		}
 		hostport = net.JoinHostPort(tcpHost, tcpPort)
 	}
-	tlscfg := NewTLSConfig(conf)
+	tlscfg, err := NewTLSConfig(conf)
+	if nil != err {
+		return nil, err
+	}
 	if len(tlscfg.ServerName) == 0 {
 		if net.ParseIP(tcpHost) == nil {
 			tlscfg.ServerName = tcpHost
@@ -91,13 +104,35 @@ func DialServerByConf(server string, conf *ProxyChannelConfig) (net.Conn, error)
 		connAddr = conf.Proxy
 	}
 	if nil == err {
+		handshake := false
 		switch rurl.Scheme {
 		case "tls":
 			fallthrough
 		case "http2":
-			tlsconn := tls.Client(conn, tlscfg)
-			err = tlsconn.Handshake()
+			handshake = true
+		case "wss":
+			//websocket dialers handshake by stock go tls unless mimicking browsers or encrypting hellos
+			if conf.dialerTLS() {
+				handshake = true
+				if len(tlscfg.NextProtos) == 0 {
+					tlscfg.NextProtos = []string{"http/1.1"}
+				}
+			}
+		}
+		echDomain := ""
+		if handshake && conf.ECH.Enable {
+			echDomain, err = applyECH(conf, tlscfg)
+			if nil != err {
+				conn.Close()
+				logger.Notice("%v", err)
+				return nil, err
+			}
+		}
+		if handshake {
+			tlsconn, err := tlsClient(conn, tlscfg, conf)
 			if err != nil {
+				conn.Close()
+				echRejected(echDomain, err)
 				logger.Notice("TLS Handshake Failed %v", err)
 				return nil, err
 			}
@@ -0,0 +1,180 @@
+package channel
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// DialRetryConfig of retrying destinations failed to dial by server
+type DialRetryConfig struct {
+	//retries after the first failed dial, 0 means no retry
+	Retry int
+	//backoff before the first retry, doubled for each later retry, 0 means default 200
+	BackoffMS int
+	//dns servers like '8.8.8.8:53' re-resolving the destination host for retries, the first one for the first retry and so on
+	AlternateDNS []string
+	//interface name like 'eth1' or local ip of another egress, used by the last retry
+	FallbackEgress string
+}
+
+func (conf *DialRetryConfig) backoff(retry int) time.Duration {
+	backoff := time.Duration(conf.BackoffMS) * time.Millisecond
+	if backoff <= 0 {
+		backoff = 200 * time.Millisecond
+	}
+	return backoff << uint(retry)
+}
+
+var dialRetryConfig atomic.Value
+
+func SetDialRetryConfig(cfg DialRetryConfig) {
+	dialRetryConfig.Store(&cfg)
+}
+
+func getDialRetryConfig() *DialRetryConfig {
+	if cfg, ok := dialRetryConfig.Load().(*DialRetryConfig); ok {
+		return cfg
+	}
+	return &DialRetryConfig{}
+}
+
+// egressAddr return the local address to bind of the egress interface name or ip
+func egressAddr(egress string, network string) (net.Addr, error) {
+	ip := net.ParseIP(egress)
+	if nil == ip {
+		iface, err := net.InterfaceByName(egress)
+		if nil != err {
+			return nil, err
+		}
+		addrs, err := iface.Addrs()
+		if nil != err {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+				ip = ipnet.IP
+				break
+			}
+		}
+		if nil == ip {
+			return nil, &net.AddrError{Err: "no address of interface", Addr: egress}
+		}
+	}
+	if network == "udp" || network == "udp4" || network == "udp6" {
+		return &net.UDPAddr{IP: ip}, nil
+	}
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// alternateResolve resolve the host of addr by the dns server, the first address is returned
+func alternateResolve(server string, addr string, timeout time.Duration) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if nil != err || nil != net.ParseIP(host) {
+		return addr, err
+	}
+	if _, _, err = net.SplitHostPort(server); nil != err {
+		server = net.JoinHostPort(server, "53")
+	}
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := r.LookupHost(ctx, host)
+	if nil != err {
+		return "", err
+	}
+	return net.JoinHostPort(addrs[0], port), nil
+}
+
+// retryableDialErr return false for errors retries would not fix
+func retryableDialErr(err error) bool {
+	return dialErrorCode(err) != mux.DialErrNotAllowed
+}
+
+// dialDestinationWithRetry dial the destination & retry by the retry config
+func dialDestinationWithRetry(network string, addr string, timeout time.Duration, allowIP func(ip net.IP) bool) (net.Conn, error) {
+	conn, err := dialDestination(network, addr, timeout, allowIP)
+	if nil == err || !retryableDialErr(err) {
+		return conn, err
+	}
+	conf := getDialRetryConfig()
+	for i := 0; i < conf.Retry; i++ {
+		time.Sleep(conf.backoff(i))
+		target := addr
+		if i < len(conf.AlternateDNS) {
+			resolved, rerr := alternateResolve(conf.AlternateDNS[i], addr, timeout)
+			if nil == rerr {
+				target = resolved
+			} else {
+				logger.Debug("Failed to resolve %s by alternate dns:%s with reason:%v", addr, conf.AlternateDNS[i], rerr)
+			}
+		}
+		d := destinationDialer(timeout, allowIP)
+		if i == conf.Retry-1 && len(conf.FallbackEgress) > 0 {
+			if d.LocalAddr, err = egressAddr(conf.FallbackEgress, network); nil != err {
+				logger.Error("[ERROR]Invalid fallback egress:%s with reason:%v", conf.FallbackEgress, err)
+				d.LocalAddr = nil
+			}
+		}
+		logger.Debug("Retry %d to dial %s by %s", i+1, addr, target)
+		if conn, err = d.Dial(network, target); nil == err || !retryableDialErr(err) {
+			return conn, err
+		}
+	}
+	return nil, err
+}
+
+// ConnectErrorCode classify errors of MuxStream.Connect into 'DialErr*' codes
+func ConnectErrorCode(err error) int {
+	return dialErrorCode(err)
+}
+
+// dialErrorCode classify dial errors into codes reported to clients by ControlDialFailed & ConnectResponse
+func dialErrorCode(err error) int {
+	if connErr, ok := err.(*mux.ConnectError); ok {
+		//failed by next hop
+		return connErr.Code
+	}
+	if err == errDialIPNotAllowed {
+		return mux.DialErrNotAllowed
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	switch e := err.(type) {
+	case *net.DNSError:
+		if e.IsTimeout {
+			return mux.DialErrTimeout
+		}
+		return mux.DialErrResolve
+	case syscall.Errno:
+		switch e {
+		case syscall.ECONNREFUSED:
+			return mux.DialErrRefused
+		case syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+			return mux.DialErrUnreachable
+		}
+	}
+	if err == errDialIPNotAllowed {
+		return mux.DialErrNotAllowed
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return mux.DialErrTimeout
+	}
+	return mux.DialErrOther
+}
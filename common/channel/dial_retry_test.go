@@ -0,0 +1,39 @@
+package channel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestDialDestinationWithRetry(t *testing.T) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	addr := lp.Addr().String()
+	//nothing listening on the port any more
+	lp.Close()
+	SetDialRetryConfig(DialRetryConfig{Retry: 2, BackoffMS: 10})
+	defer SetDialRetryConfig(DialRetryConfig{})
+
+	start := time.Now()
+	_, err = dialDestinationWithRetry("tcp", addr, time.Second, nil)
+	if code := dialErrorCode(err); code != mux.DialErrRefused {
+		t.Errorf("expect refused code, but got %d for %v", code, err)
+	}
+	if cost := time.Now().Sub(start); cost < 30*time.Millisecond {
+		t.Errorf("2 retries should backoff 10ms & 20ms, but cost %v", cost)
+	}
+
+	start = time.Now()
+	_, err = dialDestinationWithRetry("tcp", addr, time.Second, func(ip net.IP) bool { return false })
+	if code := dialErrorCode(err); code != mux.DialErrNotAllowed {
+		t.Errorf("expect not allowed code, but got %d for %v", code, err)
+	}
+	if cost := time.Now().Sub(start); cost >= 10*time.Millisecond {
+		t.Errorf("dial not allowed should not be retried, but cost %v", cost)
+	}
+}
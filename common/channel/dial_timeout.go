@@ -0,0 +1,120 @@
+package channel
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	maxDefaultDialTimeout = 10 * time.Second
+	minDefaultDialTimeout = 2 * time.Second
+	dialHistoryLimit      = 10240
+)
+
+type destDialStat struct {
+	avg   time.Duration
+	fails int
+}
+
+var destDialHistory = make(map[string]*destDialStat)
+var destDialHistoryMutex sync.Mutex
+
+func dialHistoryKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if nil != err {
+		return addr
+	}
+	return host
+}
+
+func recordDialResult(addr string, cost time.Duration, err error) {
+	key := dialHistoryKey(addr)
+	destDialHistoryMutex.Lock()
+	defer destDialHistoryMutex.Unlock()
+	stat, exist := destDialHistory[key]
+	if !exist {
+		if len(destDialHistory) >= dialHistoryLimit {
+			destDialHistory = make(map[string]*destDialStat)
+		}
+		stat = &destDialStat{}
+		destDialHistory[key] = stat
+	}
+	if nil != err {
+		stat.fails++
+		return
+	}
+	stat.fails = 0
+	if stat.avg == 0 {
+		stat.avg = cost
+	} else {
+		stat.avg = (stat.avg*3 + cost) / 4
+	}
+}
+
+// defaultDialTimeout derive dial timeout from the dial history of the destination
+func defaultDialTimeout(addr string) time.Duration {
+	destDialHistoryMutex.Lock()
+	stat, exist := destDialHistory[dialHistoryKey(addr)]
+	destDialHistoryMutex.Unlock()
+	if !exist || stat.avg == 0 || stat.fails > 0 {
+		return maxDefaultDialTimeout
+	}
+	timeout := stat.avg*4 + time.Second
+	if timeout < minDefaultDialTimeout {
+		timeout = minDefaultDialTimeout
+	}
+	if timeout > maxDefaultDialTimeout {
+		timeout = maxDefaultDialTimeout
+	}
+	return timeout
+}
+
+var errDialIPNotAllowed = errors.New("dialed ip not allowed")
+
+// dialDestination dial the destination by hostname, so that every resolved address is tried with happy
+// eyeballs fallback, 'allowIP' checks the ip actually dialed if not nil
+func dialDestination(network string, addr string, timeout time.Duration, allowIP func(ip net.IP) bool) (net.Conn, error) {
+	return destinationDialer(timeout, allowIP).Dial(network, addr)
+}
+
+func destinationDialer(timeout time.Duration, allowIP func(ip net.IP) bool) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if nil != allowIP {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, _ := net.SplitHostPort(address)
+			if !allowIP(net.ParseIP(host)) {
+				return errDialIPNotAllowed
+			}
+			return nil
+		}
+	}
+	return d
+}
+
+// ChannelRTT return the average ping rtt of the channel's sessions, 0 if unknown.
+func ChannelRTT(name string) time.Duration {
+	localChannelMutex.Lock()
+	pch, exist := localChannelTable[name]
+	localChannelMutex.Unlock()
+	if !exist {
+		return 0
+	}
+	var total time.Duration
+	n := 0
+	for holder := range pch.sessions {
+		holder.health.mutex.Lock()
+		rtt := holder.health.rtt
+		holder.health.mutex.Unlock()
+		if rtt > 0 {
+			total += rtt
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / time.Duration(n)
+}
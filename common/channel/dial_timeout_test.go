@@ -0,0 +1,73 @@
+package channel
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDialHistoryKey(t *testing.T) {
+	tests := []struct {
+		addr string
+		key  string
+	}{
+		{"example.com:443", "example.com"},
+		{"1.2.3.4:80", "1.2.3.4"},
+		{"[::1]:443", "::1"},
+		{"example.com", "example.com"},
+	}
+	for _, tt := range tests {
+		if key := dialHistoryKey(tt.addr); key != tt.key {
+			t.Errorf("expect history key %s of %s, but got %s", tt.key, tt.addr, key)
+		}
+	}
+}
+
+func TestDefaultDialTimeout(t *testing.T) {
+	defer func() {
+		destDialHistory = make(map[string]*destDialStat)
+	}()
+	destDialHistory = map[string]*destDialStat{
+		"fast.test":   {avg: 100 * time.Millisecond},
+		"normal.test": {avg: time.Second},
+		"slow.test":   {avg: 5 * time.Second},
+		"failed.test": {avg: 100 * time.Millisecond, fails: 1},
+	}
+	tests := []struct {
+		addr    string
+		timeout time.Duration
+	}{
+		{"unknown.test:443", maxDefaultDialTimeout},
+		{"fast.test:443", minDefaultDialTimeout},
+		{"normal.test:80", 5 * time.Second},
+		{"slow.test:443", maxDefaultDialTimeout},
+		{"failed.test:443", maxDefaultDialTimeout},
+	}
+	for _, tt := range tests {
+		if timeout := defaultDialTimeout(tt.addr); timeout != tt.timeout {
+			t.Errorf("expect dial timeout %v of %s, but got %v", tt.timeout, tt.addr, timeout)
+		}
+	}
+}
+
+func TestRecordDialResult(t *testing.T) {
+	defer func() {
+		destDialHistory = make(map[string]*destDialStat)
+	}()
+	destDialHistory = make(map[string]*destDialStat)
+	addr := "history.test:443"
+	recordDialResult(addr, 400*time.Millisecond, nil)
+	recordDialResult("history.test:80", 800*time.Millisecond, nil)
+	stat := destDialHistory["history.test"]
+	if nil == stat || stat.avg != 500*time.Millisecond {
+		t.Fatalf("expect average dial cost 500ms of all ports, but got %+v", stat)
+	}
+	recordDialResult(addr, 0, errors.New("timeout"))
+	if timeout := defaultDialTimeout(addr); timeout != maxDefaultDialTimeout {
+		t.Errorf("failed destination should use the max timeout, but got %v", timeout)
+	}
+	recordDialResult(addr, 500*time.Millisecond, nil)
+	if timeout := defaultDialTimeout(addr); timeout != 3*time.Second {
+		t.Errorf("expect dial timeout 3s after success, but got %v", timeout)
+	}
+}
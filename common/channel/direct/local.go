@@ -13,7 +13,6 @@ import (
 	"github.com/yinqiwen/gsnova/common/hosts"
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/mux"
-	"github.com/yinqiwen/gsnova/common/netx"
 )
 
 type directStream struct {
@@ -70,7 +69,7 @@ func (tc *directStream) Connect(network string, addr string, opt mux.StreamOptio
 		opt.DialTimeout = 5000
 	}
 	//log.Printf("Session:%d connect %s:%s for %s %T %v %v %s", ev.GetId(), network, addr, host, ev, needHttpsConnect, conf.ProxyURL(), net.JoinHostPort(host, port))
-	c, err := netx.DialTimeout(network, addr, time.Duration(opt.DialTimeout)*time.Millisecond)
+	c, err := dialMarked(network, addr, time.Duration(opt.DialTimeout)*time.Millisecond, opt)
 	if nil != proxyURL && nil == err {
 		switch proxyURL.Scheme {
 		case "http_proxy":
@@ -85,6 +84,9 @@ func (tc *directStream) Connect(network string, addr string, opt mux.StreamOptio
 			err = helper.Socks5ProxyConnect(tc.conf.ProxyURL(), c, net.JoinHostPort(host, port))
 		}
 	}
+	if nil == err && len(opt.EarlyData) > 0 {
+		_, err = c.Write(opt.EarlyData)
+	}
 	if nil != err {
 		logger.Error("Failed to connect %s for %s with error:%v", addr, host, err)
 		return err
@@ -95,6 +97,12 @@ func (tc *directStream) Connect(network string, addr string, opt mux.StreamOptio
 	return nil
 }
 
+// TCPConn return the dialed tcp conn, so that relays could splice it in kernel
+func (tc *directStream) TCPConn() *net.TCPConn {
+	c, _ := tc.Conn.(*net.TCPConn)
+	return c
+}
+
 func (tc *directStream) StreamID() uint32 {
 	return 0
 }
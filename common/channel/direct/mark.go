@@ -0,0 +1,29 @@
+package direct
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/netx"
+)
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if nil != err {
+		return addr
+	}
+	return host
+}
+
+// dialMarked dial by netx unless socket mark or DSCP is set for the stream
+func dialMarked(network string, addr string, timeout time.Duration, opt mux.StreamOptions) (net.Conn, error) {
+	if opt.SocketMark <= 0 && opt.DSCP <= 0 {
+		return netx.DialTimeout(network, addr, timeout)
+	}
+	d := net.Dialer{Control: markControl(opt.SocketMark, opt.DSCP)}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, addr)
+}
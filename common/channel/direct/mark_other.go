@@ -0,0 +1,16 @@
+// +build android !linux
+
+package direct
+
+import (
+	"syscall"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+func markControl(mark int, dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		logger.Debug("SocketMark/DSCP is not supported on this platform, ignore it for %s", address)
+		return nil
+	}
+}
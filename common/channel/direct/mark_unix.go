@@ -0,0 +1,37 @@
+// +build linux,!android
+
+package direct
+
+import (
+	"net"
+	"syscall"
+)
+
+const soMark = 0x24
+
+// markControl set SO_MARK & DSCP on direct sockets so that tc/nftables QoS policies could classify them
+func markControl(mark int, dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if mark > 0 {
+				if serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soMark, mark); nil != serr {
+					return
+				}
+			}
+			if dscp > 0 {
+				//DSCP is the high 6 bits of TOS/traffic class
+				tos := (dscp & 0x3f) << 2
+				if ip := net.ParseIP(hostOf(address)); nil != ip && nil == ip.To4() {
+					serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+				} else {
+					serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+				}
+			}
+		})
+		if nil != err {
+			return err
+		}
+		return serr
+	}
+}
@@ -0,0 +1,86 @@
+// +build linux,!android
+
+package direct
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func sockoptOf(t *testing.T, c net.Conn, level, opt int) int {
+	raw, err := c.(*net.TCPConn).SyscallConn()
+	if nil != err {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	raw.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if nil != serr {
+		t.Fatal(serr)
+	}
+	return v
+}
+
+func TestDialMarkedDSCP(t *testing.T) {
+	tests := []struct {
+		network string
+		addr    string
+		dscp    int
+		level   int
+		opt     int
+		tos     int
+	}{
+		{"tcp4", "127.0.0.1:0", 46, syscall.IPPROTO_IP, syscall.IP_TOS, 46 << 2},
+		{"tcp4", "127.0.0.1:0", 8, syscall.IPPROTO_IP, syscall.IP_TOS, 8 << 2},
+		{"tcp6", "[::1]:0", 46, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, 46 << 2},
+	}
+	for _, tt := range tests {
+		l, err := net.Listen(tt.network, tt.addr)
+		if nil != err {
+			t.Logf("skip %s:%v", tt.network, err)
+			continue
+		}
+		c, err := dialMarked(tt.network, l.Addr().String(), time.Second, mux.StreamOptions{DSCP: tt.dscp})
+		if nil != err {
+			l.Close()
+			t.Fatal(err)
+		}
+		if tos := sockoptOf(t, c, tt.level, tt.opt); tos != tt.tos {
+			t.Errorf("%s: expect tos %d of dscp %d, but got %d", tt.network, tt.tos, tt.dscp, tos)
+		}
+		c.Close()
+		l.Close()
+	}
+}
+
+func TestDialMarkedSocketMark(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := dialMarked("tcp4", l.Addr().String(), time.Second, mux.StreamOptions{SocketMark: 100})
+	if nil != err {
+		//SO_MARK needs CAP_NET_ADMIN
+		t.Skipf("skip socket mark:%v", err)
+	}
+	defer c.Close()
+	if mark := sockoptOf(t, c, syscall.SOL_SOCKET, soMark); mark != 100 {
+		t.Errorf("expect socket mark 100, but got %d", mark)
+	}
+	//no mark nor dscp dial as usual
+	plain, err := dialMarked("tcp4", l.Addr().String(), time.Second, mux.StreamOptions{})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if mark := sockoptOf(t, plain, syscall.SOL_SOCKET, soMark); mark != 0 {
+		t.Errorf("expect no socket mark, but got %d", mark)
+	}
+}
@@ -0,0 +1,130 @@
+package dnstunnel
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+)
+
+// Upstream bytes are base32 labels of the query name '<data>.<header>.<zone>', downstream bytes are base64
+// TXT strings of the answer prefixed by a status byte. Queries are sent one by one, a query is retransmitted
+// with the same name until answered, and the server replays the last answer for it, so both directions are
+// reliable over lossy resolvers.
+
+const (
+	headerLen    = 9
+	maxNameLen   = 253
+	maxLabelLen  = 63
+	maxTXTString = 255
+
+	flagClose = 1
+
+	statusOK    = 0
+	statusReset = 1
+
+	//advertised to resolvers, avoid fragmentation of answers
+	ednsUDPSize = 1232
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type queryHeader struct {
+	flags   byte
+	session uint32
+	seq     uint32
+}
+
+func normalizeZone(zone string) string {
+	return strings.ToLower(strings.Trim(zone, "."))
+}
+
+// maxQueryPayload return bytes of upstream data carried by a query name under the zone
+func maxQueryPayload(zone string) int {
+	avail := maxNameLen - len(zone) - 1 - b32.EncodedLen(headerLen) - 1
+	//a dot follows every label
+	chars := avail * maxLabelLen / (maxLabelLen + 1)
+	if chars <= 0 {
+		return 0
+	}
+	return chars * 5 / 8
+}
+
+func encodeQuery(zone string, h queryHeader, data []byte) string {
+	var hdr [headerLen]byte
+	hdr[0] = h.flags
+	binary.BigEndian.PutUint32(hdr[1:], h.session)
+	binary.BigEndian.PutUint32(hdr[5:], h.seq)
+	var name strings.Builder
+	enc := strings.ToLower(b32.EncodeToString(data))
+	for len(enc) > 0 {
+		n := len(enc)
+		if n > maxLabelLen {
+			n = maxLabelLen
+		}
+		name.WriteString(enc[:n])
+		name.WriteByte('.')
+		enc = enc[n:]
+	}
+	name.WriteString(strings.ToLower(b32.EncodeToString(hdr[:])))
+	name.WriteByte('.')
+	name.WriteString(zone)
+	name.WriteByte('.')
+	return name.String()
+}
+
+// decodeQuery parse the query name, resolvers may randomize the case of names
+func decodeQuery(zone string, name string) (queryHeader, []byte, bool) {
+	var h queryHeader
+	name = strings.ToUpper(strings.TrimSuffix(name, "."))
+	suffix := "." + strings.ToUpper(zone)
+	if !strings.HasSuffix(name, suffix) {
+		return h, nil, false
+	}
+	labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+	hdr, err := b32.DecodeString(labels[len(labels)-1])
+	if nil != err || len(hdr) != headerLen {
+		return h, nil, false
+	}
+	data, err := b32.DecodeString(strings.Join(labels[:len(labels)-1], ""))
+	if nil != err {
+		return h, nil, false
+	}
+	h.flags = hdr[0]
+	h.session = binary.BigEndian.Uint32(hdr[1:])
+	h.seq = binary.BigEndian.Uint32(hdr[5:])
+	return h, data, true
+}
+
+// maxAnswerPayload return bytes of downstream data fit in an answer of 'size' to a query of 'queryLen' bytes
+func maxAnswerPayload(size int, queryLen int) int {
+	//answer name is compressed, type/class/ttl/rdlength & opt record, with some margin
+	chars := size - queryLen - 12 - 11 - 32
+	chars -= chars/(maxTXTString+1) + 1
+	if chars <= 4 {
+		return 0
+	}
+	return base64.StdEncoding.DecodedLen(chars/4*4) - 1
+}
+
+func encodeAnswer(status byte, data []byte) []string {
+	enc := base64.StdEncoding.EncodeToString(append([]byte{status}, data...))
+	var txt []string
+	for len(enc) > 0 {
+		n := len(enc)
+		if n > maxTXTString {
+			n = maxTXTString
+		}
+		txt = append(txt, enc[:n])
+		enc = enc[n:]
+	}
+	return txt
+}
+
+func decodeAnswer(txt []string) (byte, []byte, bool) {
+	b, err := base64.StdEncoding.DecodeString(strings.Join(txt, ""))
+	if nil != err || len(b) == 0 {
+		return 0, nil, false
+	}
+	return b[0], b[1:], true
+}
@@ -0,0 +1,57 @@
+package dnstunnel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQueryCodec(t *testing.T) {
+	zone := normalizeZone("T.Example.com.")
+	n := maxQueryPayload(zone)
+	data := bytes.Repeat([]byte{0xfe, 0x01, 0x7f}, n/3+1)[:n]
+	h := queryHeader{flags: flagClose, session: 0xdeadbeef, seq: 42}
+	name := encodeQuery(zone, h, data)
+	if len(name) > maxNameLen+1 {
+		t.Fatalf("name of %d bytes is too long", len(name))
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) > maxLabelLen {
+			t.Fatalf("label %s is too long", label)
+		}
+	}
+	//resolvers may randomize the case
+	rh, rdata, ok := decodeQuery(zone, strings.ToUpper(name))
+	if !ok || rh != h || !bytes.Equal(rdata, data) {
+		t.Fatalf("decode %s failed:%v %+v", name, ok, rh)
+	}
+	if _, _, ok = decodeQuery(zone, "abc.other.com."); ok {
+		t.Fatalf("name of other zone decoded")
+	}
+	if _, data, ok = decodeQuery(zone, encodeQuery(zone, h, nil)); !ok || len(data) != 0 {
+		t.Fatalf("empty poll query decode failed")
+	}
+}
+
+func TestAnswerCodec(t *testing.T) {
+	n := maxAnswerPayload(ednsUDPSize, 300)
+	if n < 600 {
+		t.Fatalf("answer payload %d is too small", n)
+	}
+	data := bytes.Repeat([]byte{0, 1, 2, 255}, n/4)
+	txt := encodeAnswer(statusOK, data)
+	size := 0
+	for _, s := range txt {
+		if len(s) > maxTXTString {
+			t.Fatalf("txt string of %d bytes", len(s))
+		}
+		size += len(s) + 1
+	}
+	if size+300+12+11 > ednsUDPSize {
+		t.Fatalf("answer of %d bytes exceeds udp size", size)
+	}
+	status, b, ok := decodeAnswer(txt)
+	if !ok || status != statusOK || !bytes.Equal(b, data) {
+		t.Fatalf("decode answer failed")
+	}
+}
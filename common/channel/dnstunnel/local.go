@@ -0,0 +1,285 @@
+package dnstunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/netx"
+	"github.com/yinqiwen/pmux"
+)
+
+const (
+	maxClientSendBuffer = 64 * 1024
+	queryTimeout        = 2 * time.Second
+	//the session is broken after continuous failed queries
+	maxQueryFails   = 10
+	defaultPollMax  = 500 * time.Millisecond
+	minPollInterval = 10 * time.Millisecond
+)
+
+var errNoTunnelAnswer = errors.New("no dns tunnel answer")
+
+// clientConn send queries one by one, an empty query polls downstream data when idle
+type clientConn struct {
+	zone       string
+	resolver   string
+	session    uint32
+	seq        uint32
+	maxPayload int
+	pollMax    time.Duration
+	client     *dns.Client
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	recv   bytes.Buffer
+	send   bytes.Buffer
+	closed bool
+	//the loop stopped, no more bytes received
+	broken bool
+	notify chan struct{}
+}
+
+func (c *clientConn) exchange(flags byte, data []byte) (byte, []byte, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(encodeQuery(c.zone, queryHeader{flags: flags, session: c.session, seq: c.seq}, data), dns.TypeTXT)
+	req.RecursionDesired = true
+	req.SetEdns0(ednsUDPSize, false)
+	conn, err := netx.DialTimeout("udp", c.resolver, queryTimeout)
+	if nil != err {
+		return 0, nil, err
+	}
+	defer conn.Close()
+	res, _, err := c.client.ExchangeWithConn(req, &dns.Conn{Conn: conn, UDPSize: ednsUDPSize})
+	if nil != err {
+		return 0, nil, err
+	}
+	if res.Rcode != dns.RcodeSuccess {
+		return 0, nil, fmt.Errorf("dns tunnel query failed with rcode:%s", dns.RcodeToString[res.Rcode])
+	}
+	for _, answer := range res.Answer {
+		if txt, ok := answer.(*dns.TXT); ok && strings.EqualFold(txt.Hdr.Name, req.Question[0].Name) {
+			if status, b, ok := decodeAnswer(txt.Txt); ok {
+				return status, b, nil
+			}
+		}
+	}
+	return 0, nil, errNoTunnelAnswer
+}
+
+func (c *clientConn) loop() {
+	var pending []byte
+	hasPending := false
+	poll := time.Duration(0)
+	fails := 0
+	for {
+		c.mutex.Lock()
+		closed := c.closed
+		if !hasPending {
+			n := c.send.Len()
+			if n > c.maxPayload {
+				n = c.maxPayload
+			}
+			pending = append([]byte{}, c.send.Next(n)...)
+			hasPending = true
+			c.cond.Broadcast()
+		}
+		c.mutex.Unlock()
+		var flags byte
+		if closed {
+			flags = flagClose
+		}
+		status, data, err := c.exchange(flags, pending)
+		if nil != err {
+			fails++
+			//close queries are best effort
+			if fails >= maxQueryFails || (closed && fails >= 3) {
+				logger.Notice("Stop dns tunnel session:%08x with reason:%v", c.session, err)
+				c.shutdown()
+				return
+			}
+			continue
+		}
+		fails = 0
+		c.seq++
+		sent := len(pending)
+		pending, hasPending = nil, false
+		if closed || status == statusReset {
+			c.shutdown()
+			return
+		}
+		c.mutex.Lock()
+		if len(data) > 0 {
+			c.recv.Write(data)
+			c.cond.Broadcast()
+		}
+		queued := c.send.Len()
+		c.mutex.Unlock()
+		if sent > 0 || len(data) > 0 || queued > 0 {
+			poll = 0
+			continue
+		}
+		//back off polling while idle
+		poll *= 2
+		if poll < minPollInterval {
+			poll = minPollInterval
+		}
+		if poll > c.pollMax {
+			poll = c.pollMax
+		}
+		timer := time.NewTimer(poll)
+		select {
+		case <-c.notify:
+			poll = 0
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *clientConn) shutdown() {
+	c.mutex.Lock()
+	c.closed, c.broken = true, true
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+}
+
+func (c *clientConn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.recv.Len() == 0 && !c.broken {
+		c.cond.Wait()
+	}
+	if c.recv.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.recv.Read(b)
+}
+
+func (c *clientConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	for c.send.Len() >= maxClientSendBuffer && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		c.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	n, err := c.send.Write(b)
+	c.mutex.Unlock()
+	helper.AsyncNotify(c.notify)
+	return n, err
+}
+
+// Close let the loop send a close query
+func (c *clientConn) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	helper.AsyncNotify(c.notify)
+	return nil
+}
+
+func (c *clientConn) LocalAddr() net.Addr {
+	return tunnelAddr("dns")
+}
+
+func (c *clientConn) RemoteAddr() net.Addr {
+	return tunnelAddr(c.resolver)
+}
+
+func (c *clientConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// systemResolver return the first nameserver of the system, which is usually the only one reachable
+// behind captive portals
+func systemResolver() string {
+	if cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf"); nil == err && len(cfg.Servers) > 0 {
+		return net.JoinHostPort(cfg.Servers[0], cfg.Port)
+	}
+	return "8.8.8.8:53"
+}
+
+type DNSTunnelProxy struct {
+}
+
+func (p *DNSTunnelProxy) Features() channel.FeatureSet {
+	return channel.FeatureSet{
+		AutoExpire: true,
+		Pingable:   true,
+	}
+}
+
+// CreateMuxSession by server url like 'dns://t.example.com?resolver=1.1.1.1:53&poll=500', the host is the zone
+// delegated to the server, 'resolver' default the system's, 'poll' is the max milliseconds between idle polls
+func (p *DNSTunnelProxy) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
+	rurl, err := url.Parse(server)
+	if nil != err {
+		return nil, err
+	}
+	zone := normalizeZone(rurl.Hostname())
+	c := &clientConn{
+		zone:       zone,
+		resolver:   rurl.Query().Get("resolver"),
+		maxPayload: maxQueryPayload(zone),
+		pollMax:    defaultPollMax,
+		client:     &dns.Client{Net: "udp", Timeout: queryTimeout, UDPSize: ednsUDPSize},
+		notify:     make(chan struct{}, 1),
+	}
+	if c.maxPayload < 16 {
+		return nil, fmt.Errorf("dns tunnel zone:%s is too long", zone)
+	}
+	if len(c.resolver) == 0 {
+		c.resolver = systemResolver()
+	} else if _, _, err := net.SplitHostPort(c.resolver); nil != err {
+		c.resolver = net.JoinHostPort(c.resolver, "53")
+	}
+	if s := rurl.Query().Get("poll"); len(s) > 0 {
+		ms, err := strconv.Atoi(s)
+		if nil != err || ms <= 0 {
+			return nil, fmt.Errorf("invalid dns tunnel poll:%s", s)
+		}
+		c.pollMax = time.Duration(ms) * time.Millisecond
+	}
+	var id [4]byte
+	rand.Read(id[:])
+	c.session = binary.BigEndian.Uint32(id[:])
+	c.cond = sync.NewCond(&c.mutex)
+	go c.loop()
+	muxConf := channel.InitialPMuxConfig(&conf.Cipher)
+	session, err := pmux.Client(c, muxConf)
+	if nil != err {
+		c.Close()
+		return nil, err
+	}
+	logger.Debug("Connect %s success by resolver %s.", server, c.resolver)
+	return &mux.ProxyMuxSession{Session: session, Config: muxConf}, nil
+}
+
+func init() {
+	channel.RegisterLocalChannelType("dns", &DNSTunnelProxy{})
+}
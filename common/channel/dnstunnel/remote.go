@@ -0,0 +1,240 @@
+package dnstunnel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+)
+
+const (
+	//writers block until the buffered downstream bytes drained by queries
+	maxServSendBuffer = 256 * 1024
+	servSessionIdle   = 2 * time.Minute
+)
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string {
+	return "dns"
+}
+
+func (a tunnelAddr) String() string {
+	return string(a)
+}
+
+// servConn is the server side of a tunnel session, fed by queries of the session
+type servConn struct {
+	session uint32
+	remote  tunnelAddr
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	recv    bytes.Buffer
+	send    bytes.Buffer
+	nextSeq uint32
+	//answer payload of 'nextSeq-1' replayed for retransmitted queries
+	last   []byte
+	active time.Time
+	closed bool
+}
+
+func newServConn(session uint32, remote string) *servConn {
+	c := &servConn{session: session, remote: tunnelAddr(remote), active: time.Now()}
+	c.cond = sync.NewCond(&c.mutex)
+	return c
+}
+
+// handle accept upstream data of the query in sequence & take downstream data for the answer
+func (c *servConn) handle(h queryHeader, data []byte, maxPayload int) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.active = time.Now()
+	if nil != c.last && h.seq == c.nextSeq-1 {
+		//a retransmitted query may carry the close flag once the client closed meanwhile
+		if h.flags&flagClose != 0 && !c.closed {
+			c.closed = true
+			c.cond.Broadcast()
+		}
+		return encodeAnswer(statusOK, c.last)
+	}
+	if c.closed {
+		return encodeAnswer(statusReset, nil)
+	}
+	if h.seq != c.nextSeq {
+		//stale query delayed by resolvers, not answered so that the client retries
+		return nil
+	}
+	c.recv.Write(data)
+	c.nextSeq++
+	n := c.send.Len()
+	if n > maxPayload {
+		n = maxPayload
+	}
+	c.last = append([]byte{}, c.send.Next(n)...)
+	if h.flags&flagClose != 0 {
+		c.closed = true
+	}
+	c.cond.Broadcast()
+	return encodeAnswer(statusOK, c.last)
+}
+
+func (c *servConn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.recv.Len() == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.recv.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.recv.Read(b)
+}
+
+func (c *servConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.send.Len() >= maxServSendBuffer && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return c.send.Write(b)
+}
+
+func (c *servConn) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *servConn) LocalAddr() net.Addr {
+	return tunnelAddr("dns")
+}
+
+func (c *servConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *servConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *servConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *servConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type tunnelServer struct {
+	zone     string
+	mutex    sync.Mutex
+	sessions map[uint32]*servConn
+}
+
+func (s *tunnelServer) getSession(h queryHeader, remote string) *servConn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, exist := s.sessions[h.session]
+	if exist || h.seq != 0 {
+		return c
+	}
+	c = newServConn(h.session, remote)
+	s.sessions[h.session] = c
+	muxConf := channel.InitialPMuxConfig(&channel.DefaultServerCipher)
+	session, err := pmux.Server(c, muxConf)
+	if nil != err {
+		logger.Error("[ERROR]Failed to create mux session for dns tunnel with reason:%v", err)
+		delete(s.sessions, h.session)
+		return nil
+	}
+	logger.Debug("New dns tunnel session:%08x from %s", h.session, remote)
+	muxSession := &mux.ProxyMuxSession{Session: session, Config: muxConf}
+	go channel.ServProxyMuxSession(muxSession, nil)
+	return c
+}
+
+// reap close sessions not queried for a while, closed sessions are kept idle to answer retransmitted close queries
+func (s *tunnelServer) reap() {
+	for range time.Tick(30 * time.Second) {
+		now := time.Now()
+		s.mutex.Lock()
+		for id, c := range s.sessions {
+			c.mutex.Lock()
+			idle := now.Sub(c.active) > servSessionIdle
+			c.mutex.Unlock()
+			if idle {
+				c.Close()
+				delete(s.sessions, id)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func (s *tunnelServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	res := new(dns.Msg)
+	res.SetReply(req)
+	res.Authoritative = true
+	//answer names are pointers to the question
+	res.Compress = true
+	if len(req.Question) != 1 {
+		res.Rcode = dns.RcodeFormatError
+		w.WriteMsg(res)
+		return
+	}
+	q := req.Question[0]
+	size := 512
+	if opt := req.IsEdns0(); nil != opt {
+		if int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+		if size > ednsUDPSize {
+			size = ednsUDPSize
+		}
+		res.SetEdns0(uint16(size), false)
+	}
+	//empty answers for other names & types like NS queries of qname minimization
+	if q.Qtype == dns.TypeTXT {
+		if h, data, ok := decodeQuery(s.zone, q.Name); ok {
+			var txt []string
+			if c := s.getSession(h, w.RemoteAddr().String()); nil != c {
+				txt = c.handle(h, data, maxAnswerPayload(size, req.Len()))
+			} else {
+				txt = encodeAnswer(statusReset, nil)
+			}
+			if len(txt) > 0 {
+				res.Answer = append(res.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+					Txt: txt,
+				})
+			}
+		}
+	}
+	w.WriteMsg(res)
+}
+
+// StartDNSTunnelServer answer tunnel queries of the zone delegated to the server by NS records
+func StartDNSTunnelServer(addr string, zone string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if nil != err {
+		logger.Error("[ERROR]Failed to listen DNS tunnel address:%s with reason:%v", addr, err)
+		return err
+	}
+	s := &tunnelServer{zone: normalizeZone(zone), sessions: make(map[uint32]*servConn)}
+	go s.reap()
+	logger.Info("Listen on DNS tunnel address:%s for zone:%s", addr, s.zone)
+	server := &dns.Server{PacketConn: pc, Handler: s}
+	return server.ActivateAndServe()
+}
@@ -0,0 +1,73 @@
+package channel
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// onStreamResult mark the channel down once no session could open a stream, sessions are
+// re-established in background until the channel recovered
+func (ch *LocalProxyChannel) onStreamResult(err error) {
+	if nil == err {
+		if atomic.SwapInt64(&ch.downSince, 0) != 0 {
+			logger.Notice("Proxy channel:%s recovered.", ch.Conf.Name)
+		}
+		return
+	}
+	if atomic.CompareAndSwapInt64(&ch.downSince, 0, time.Now().UnixNano()) {
+		logger.Error("[ERROR]Proxy channel:%s is down for reason:%v", ch.Conf.Name, err)
+		go ch.recoverDown()
+	}
+}
+
+func (ch *LocalProxyChannel) down() bool {
+	return atomic.LoadInt64(&ch.downSince) != 0
+}
+
+func (ch *LocalProxyChannel) recoverDown() {
+	for range time.Tick(3 * time.Second) {
+		localChannelMutex.Lock()
+		current := localChannelTable[ch.Conf.Name] == ch
+		var holders []*muxSessionHolder
+		for holder := range ch.sessions {
+			holders = append(holders, holder)
+		}
+		localChannelMutex.Unlock()
+		if !current || !ch.down() {
+			return
+		}
+		for _, holder := range holders {
+			if err := holder.init(true); nil == err {
+				ch.onStreamResult(nil)
+				return
+			}
+		}
+	}
+}
+
+// IsChannelDown return true if the proxy channel failed to open streams on all sessions recently
+func IsChannelDown(name string) bool {
+	localChannelMutex.Lock()
+	defer localChannelMutex.Unlock()
+	ch, exist := localChannelTable[name]
+	return exist && ch.down()
+}
+
+// AllProxyChannelsDown return true if every proxy channel except direct is down
+func AllProxyChannelsDown() bool {
+	localChannelMutex.Lock()
+	defer localChannelMutex.Unlock()
+	n := 0
+	for _, ch := range localChannelTable {
+		if ch.Conf.Name == DirectChannelName || ch.autoExpire {
+			continue
+		}
+		if !ch.down() {
+			return false
+		}
+		n++
+	}
+	return n > 0
+}
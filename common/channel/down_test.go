@@ -0,0 +1,88 @@
+package channel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+)
+
+// recoverableChannel fail to create sessions until recovered
+type recoverableChannel struct {
+	session   mux.MuxSession
+	recovered int32
+}
+
+func (c *recoverableChannel) CreateMuxSession(server string, conf *ProxyChannelConfig) (mux.MuxSession, error) {
+	if atomic.LoadInt32(&c.recovered) == 0 {
+		return unreachableChannel{}.CreateMuxSession(server, conf)
+	}
+	return c.session, nil
+}
+func (c *recoverableChannel) Features() FeatureSet {
+	return FeatureSet{}
+}
+
+func registerTestChannel(name string, p LocalChannel) *LocalProxyChannel {
+	ch := NewProxyChannel(&ProxyChannelConfig{Name: name, Cipher: CipherConfig{Method: pmux.CipherNone}, Compressor: mux.NoneCompressor})
+	holder := &muxSessionHolder{server: name, conf: &ch.Conf, Channel: p,
+		retiredSessions: make(map[mux.MuxSession]bool), controlStreams: make(map[mux.MuxSession]mux.MuxStream)}
+	ch.sessions[holder] = true
+	localChannelMutex.Lock()
+	localChannelTable[name] = ch
+	localChannelMutex.Unlock()
+	return ch
+}
+
+func TestChannelDown(t *testing.T) {
+	localChannelMutex.Lock()
+	saved := localChannelTable
+	localChannelTable = make(map[string]*LocalProxyChannel)
+	localChannelMutex.Unlock()
+	defer func() {
+		localChannelMutex.Lock()
+		localChannelTable = saved
+		localChannelMutex.Unlock()
+	}()
+	if AllProxyChannelsDown() {
+		t.Errorf("expect not all down without proxy channels")
+	}
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	go ServProxyMuxSession(server, nil)
+	p := &recoverableChannel{session: client}
+	a := registerTestChannel("down-a", p)
+	registerTestChannel("down-b", unreachableChannel{})
+	//direct channel never counts
+	registerTestChannel(DirectChannelName, unreachableChannel{})
+
+	if _, err := a.getMuxStream(""); nil == err {
+		t.Fatalf("expect error of unreachable channel")
+	}
+	if !IsChannelDown("down-a") || IsChannelDown("down-b") || IsChannelDown("down-c") {
+		t.Errorf("expect only down-a down")
+	}
+	if AllProxyChannelsDown() {
+		t.Errorf("expect not all down while down-b is up")
+	}
+	localChannelTable["down-b"].getMuxStream("")
+	if !AllProxyChannelsDown() {
+		t.Errorf("expect all proxy channels down")
+	}
+
+	//sessions are re-established in background once the server is reachable
+	atomic.StoreInt32(&p.recovered, 1)
+	if !waitUntil(5*time.Second, func() bool { return !IsChannelDown("down-a") }) {
+		t.Fatalf("expect down-a recovered")
+	}
+	if AllProxyChannelsDown() {
+		t.Errorf("expect not all down once down-a recovered")
+	}
+	stream, err := a.getMuxStream("")
+	if nil != err {
+		t.Fatalf("expect stream of recovered channel, but got %v", err)
+	}
+	stream.Close()
+}
@@ -0,0 +1,97 @@
+package channel
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// ECHConfig encrypt the ClientHello of tls/wss/http2 channels, the real SNI is only seen by the server
+// while observers see the public name of ECH configs
+type ECHConfig struct {
+	Enable bool
+	//domain publishing ECH configs by HTTPS records, default the SNI of the channel
+	Domain string
+	//base64 ECHConfigList used instead of dns lookups
+	ConfigList string
+	//fail the dialing if no ECH config found, otherwise fallback to plain SNI
+	Strict bool
+}
+
+const echMinCacheTTL = 5 * time.Minute
+
+type echCacheItem struct {
+	list   []byte
+	expire time.Time
+}
+
+var echCacheLock sync.Mutex
+var echCache = make(map[string]*echCacheItem)
+
+func putECHConfigList(domain string, list []byte, ttl time.Duration) {
+	if ttl < echMinCacheTTL {
+		ttl = echMinCacheTTL
+	}
+	echCacheLock.Lock()
+	echCache[domain] = &echCacheItem{list: list, expire: time.Now().Add(ttl)}
+	echCacheLock.Unlock()
+}
+
+// echConfigList return the configured or the cached list, or fetch it by trusted dns
+func (conf *ECHConfig) echConfigList(serverName string) ([]byte, string, error) {
+	if len(conf.ConfigList) > 0 {
+		list, err := base64.StdEncoding.DecodeString(conf.ConfigList)
+		return list, "", err
+	}
+	domain := conf.Domain
+	if len(domain) == 0 {
+		domain = serverName
+	}
+	if len(domain) == 0 {
+		return nil, "", errors.New("no domain to lookup ech config")
+	}
+	echCacheLock.Lock()
+	item, exist := echCache[domain]
+	echCacheLock.Unlock()
+	if exist && time.Now().Before(item.expire) {
+		return item.list, domain, nil
+	}
+	list, ttl, err := dns.LookupECHConfigList(domain)
+	if nil != err {
+		return nil, domain, err
+	}
+	putECHConfigList(domain, list, time.Duration(ttl)*time.Second)
+	return list, domain, nil
+}
+
+// applyECH set ECH configs on the tls config, which require TLS 1.3
+func applyECH(conf *ProxyChannelConfig, tlscfg *tls.Config) (string, error) {
+	list, domain, err := conf.ECH.echConfigList(tlscfg.ServerName)
+	if nil != err {
+		if conf.ECH.Strict {
+			return domain, fmt.Errorf("no ech config for channel:%s with reason:%v", conf.Name, err)
+		}
+		logger.Notice("Dial without ECH for channel:%s with reason:%v", conf.Name, err)
+		return domain, nil
+	}
+	tlscfg.EncryptedClientHelloConfigList = list
+	tlscfg.MinVersion = tls.VersionTLS13
+	tlscfg.MaxVersion = 0
+	return domain, nil
+}
+
+// echRejected keep the retry configs sent by the server, so that the next dialing with stale configs succeed
+func echRejected(domain string, err error) {
+	var rejection *tls.ECHRejectionError
+	if len(domain) == 0 || !errors.As(err, &rejection) || len(rejection.RetryConfigList) == 0 {
+		return
+	}
+	logger.Notice("ECH rejected by %s, retry with configs sent by server", domain)
+	putECHConfigList(domain, rejection.RetryConfigList, 0)
+}
@@ -0,0 +1,30 @@
+package channel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestECHConfigList(t *testing.T) {
+	conf := &ECHConfig{Enable: true, ConfigList: base64.StdEncoding.EncodeToString([]byte("static"))}
+	list, _, err := conf.echConfigList("a.example.com")
+	if nil != err || string(list) != "static" {
+		t.Fatalf("unexpected static list %q %v", list, err)
+	}
+
+	conf = &ECHConfig{Enable: true, Domain: "front.example.com"}
+	putECHConfigList("front.example.com", []byte("cached"), time.Minute)
+	list, domain, err := conf.echConfigList("a.example.com")
+	if nil != err || domain != "front.example.com" || string(list) != "cached" {
+		t.Fatalf("unexpected cached list %q %s %v", list, domain, err)
+	}
+
+	echRejected(domain, &tls.ECHRejectionError{RetryConfigList: []byte("retry")})
+	list, _, _ = conf.echConfigList("a.example.com")
+	if !bytes.Equal(list, []byte("retry")) {
+		t.Fatalf("retry configs not kept:%q", list)
+	}
+}
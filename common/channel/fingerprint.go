@@ -0,0 +1,91 @@
+package channel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// safe ranges of randomized parameters, values outside of them change no behavior peers or middleboxes rely on
+const (
+	maxAuthPadding        = 256
+	maxHeartBeatOffset    = 10
+	minKeepAliveJitter    = 2
+	maxKeepAliveJitter    = 15
+	minProfileFrameSize   = 8 * 1024
+	maxProfileFrameSize   = 32 * 1024
+	profileFrameSizeAlign = 1024
+)
+
+// FingerprintProfile of non-functional protocol parameters randomized once per installation, so that
+// all clients do not share one passive fingerprint, configured values always take precedence
+type FingerprintProfile struct {
+	//length range [AuthPaddingMin, AuthPaddingMax) of random padding in auth requests
+	AuthPaddingMin int
+	AuthPaddingMax int
+	//seconds added to 'HeartBeatPeriod' of channels
+	HeartBeatOffsetSecs int
+	//keepalive jitter of channels without 'KeepAlive.JitterSecs', at most a quarter of the interval
+	KeepAliveJitterSecs int
+	//data frame size of channels without 'MaxFrameSize'
+	MaxFrameSize int
+}
+
+func (p *FingerprintProfile) valid() bool {
+	return p.AuthPaddingMin >= 0 && p.AuthPaddingMax > p.AuthPaddingMin && p.AuthPaddingMax <= maxAuthPadding &&
+		p.HeartBeatOffsetSecs >= 0 && p.HeartBeatOffsetSecs <= maxHeartBeatOffset &&
+		p.KeepAliveJitterSecs >= minKeepAliveJitter && p.KeepAliveJitterSecs <= maxKeepAliveJitter &&
+		p.MaxFrameSize >= minProfileFrameSize && p.MaxFrameSize <= maxProfileFrameSize
+}
+
+// NewFingerprintProfile randomize a profile within the safe ranges
+func NewFingerprintProfile(r *rand.Rand) *FingerprintProfile {
+	p := &FingerprintProfile{}
+	p.AuthPaddingMin = r.Intn(maxAuthPadding / 4)
+	p.AuthPaddingMax = p.AuthPaddingMin + 32 + r.Intn(maxAuthPadding-p.AuthPaddingMin-32+1)
+	p.HeartBeatOffsetSecs = r.Intn(maxHeartBeatOffset + 1)
+	p.KeepAliveJitterSecs = minKeepAliveJitter + r.Intn(maxKeepAliveJitter-minKeepAliveJitter+1)
+	p.MaxFrameSize = minProfileFrameSize + r.Intn((maxProfileFrameSize-minProfileFrameSize)/profileFrameSizeAlign+1)*profileFrameSizeAlign
+	return p
+}
+
+// LoadFingerprintProfile load the profile saved in file, a new profile is randomized & saved if the file
+// does not exist or is invalid
+func LoadFingerprintProfile(file string) (*FingerprintProfile, error) {
+	data, err := ioutil.ReadFile(file)
+	if nil == err {
+		p := &FingerprintProfile{}
+		if err = json.Unmarshal(data, p); nil == err && p.valid() {
+			return p, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	p := NewFingerprintProfile(rand.New(rand.NewSource(time.Now().UnixNano())))
+	data, _ = json.MarshalIndent(p, "", "  ")
+	if err = ioutil.WriteFile(file, data, 0660); nil != err {
+		return p, fmt.Errorf("failed to save fingerprint profile:%v", err)
+	}
+	return p, nil
+}
+
+var fingerprintProfile atomic.Value
+
+// SetFingerprintProfile apply the profile to new sessions
+func SetFingerprintProfile(p *FingerprintProfile) {
+	fingerprintProfile.Store(p)
+	mux.SetAuthPadding(p.AuthPaddingMin, p.AuthPaddingMax)
+}
+
+func getFingerprintProfile() *FingerprintProfile {
+	if p, ok := fingerprintProfile.Load().(*FingerprintProfile); ok {
+		return p
+	}
+	return &FingerprintProfile{}
+}
@@ -0,0 +1,67 @@
+package channel
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewFingerprintProfile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	distinct := make(map[FingerprintProfile]bool)
+	for i := 0; i < 1000; i++ {
+		p := NewFingerprintProfile(r)
+		if !p.valid() {
+			t.Fatalf("profile out of safe ranges:%+v", p)
+		}
+		if p.MaxFrameSize%profileFrameSizeAlign != 0 {
+			t.Fatalf("frame size %d not aligned", p.MaxFrameSize)
+		}
+		distinct[*p] = true
+	}
+	if len(distinct) < 900 {
+		t.Errorf("expect diverse profiles, but got %d distinct of 1000", len(distinct))
+	}
+}
+
+func TestLoadFingerprintProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fingerprint")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "fingerprint.json")
+	p, err := LoadFingerprintProfile(file)
+	if nil != err {
+		t.Fatal(err)
+	}
+	saved, err := LoadFingerprintProfile(file)
+	if nil != err || !reflect.DeepEqual(p, saved) {
+		t.Fatalf("expect saved profile %+v, but got %+v %v", p, saved, err)
+	}
+	ioutil.WriteFile(file, []byte(`{"MaxFrameSize":1}`), 0660)
+	if p, err = LoadFingerprintProfile(file); nil != err || !p.valid() {
+		t.Fatalf("invalid profile should be regenerated, but got %+v %v", p, err)
+	}
+}
+
+func TestKeepAliveProfileJitter(t *testing.T) {
+	SetFingerprintProfile(&FingerprintProfile{AuthPaddingMax: 128, KeepAliveJitterSecs: 10})
+	defer fingerprintProfile.Store(&FingerprintProfile{})
+	for _, c := range []struct {
+		conf   KeepAliveConfig
+		jitter int
+	}{
+		{KeepAliveConfig{IntervalSecs: 60}, 10},
+		{KeepAliveConfig{IntervalSecs: 20}, 5},
+		{KeepAliveConfig{IntervalSecs: 60, JitterSecs: 3}, 3},
+		{KeepAliveConfig{IntervalSecs: 60, JitterSecs: -1}, -1},
+	} {
+		if jitter := c.conf.jitterSecs(); jitter != c.jitter {
+			t.Errorf("%+v: expect jitter %d, but got %d", c.conf, c.jitter, jitter)
+		}
+	}
+}
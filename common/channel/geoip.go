@@ -0,0 +1,134 @@
+package channel
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// geoIPDatabase is a MaxMind country database reloaded when the file changed
+type geoIPDatabase struct {
+	path    string
+	modTime time.Time
+	reader  *geoip2.Reader
+	mutex   sync.RWMutex
+	watch   sync.Once
+}
+
+var proxyLimitGeoIP = &geoIPDatabase{}
+
+func (db *geoIPDatabase) reload() error {
+	db.mutex.RLock()
+	path := db.path
+	db.mutex.RUnlock()
+	if len(path) == 0 {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if nil != err {
+		logger.Error("[ERROR]Failed to stat GeoIP database:%s with reason:%v", path, err)
+		return err
+	}
+	db.mutex.RLock()
+	unchanged := nil != db.reader && fi.ModTime().Equal(db.modTime)
+	db.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+	reader, err := geoip2.Open(path)
+	if nil != err {
+		logger.Error("[ERROR]Failed to load GeoIP database:%s with reason:%v", path, err)
+		return err
+	}
+	db.mutex.Lock()
+	old := db.reader
+	db.reader = reader
+	db.modTime = fi.ModTime()
+	db.mutex.Unlock()
+	if nil != old {
+		old.Close()
+	}
+	logger.Notice("GeoIP database:%s loaded.", path)
+	return nil
+}
+
+func (db *geoIPDatabase) init(path string) {
+	if len(path) == 0 {
+		return
+	}
+	db.mutex.Lock()
+	db.path = path
+	db.mutex.Unlock()
+	db.reload()
+	db.watch.Do(func() {
+		go func() {
+			for range time.Tick(time.Minute) {
+				db.reload()
+			}
+		}()
+	})
+}
+
+// country return the ISO country code of the ip, empty if unknown
+func (db *geoIPDatabase) country(ip net.IP) string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if nil == db.reader {
+		return ""
+	}
+	record, err := db.reader.Country(ip)
+	if nil != err {
+		return ""
+	}
+	return record.Country.IsoCode
+}
+
+// allowedDialIP check the ip dialed for the destination of the user by country rules & user ACL
+func allowedDialIP(user string, addr string, ip net.IP) bool {
+	return nil != ip && defaultProxyLimit().allowedCountryIP(ip) && allowedIPByUserACL(user, addr, ip)
+}
+
+// GeoIPCountry return the ISO country code of the ip by 'GeoIPDB' of proxy limit, empty if unknown or not loaded
+func GeoIPCountry(ip net.IP) string {
+	return proxyLimitGeoIP.country(ip)
+}
+
+func containsCountry(list []string, country string) bool {
+	for _, c := range list {
+		if c == "*" || strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedCountry check ip literal destinations only, domains are checked by the ip dialed
+func (limit *ProxyLimitConfig) allowedCountry(addr string) bool {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); nil == err {
+		host = h
+	}
+	if ip := net.ParseIP(host); nil != ip {
+		return limit.allowedCountryIP(ip)
+	}
+	return true
+}
+
+func (limit *ProxyLimitConfig) allowedCountryIP(ip net.IP) bool {
+	if len(limit.AllowCountries) == 0 && len(limit.DenyCountries) == 0 {
+		return true
+	}
+	country := proxyLimitGeoIP.country(ip)
+	if len(country) == 0 {
+		return len(limit.AllowCountries) == 0
+	}
+	if containsCountry(limit.DenyCountries, country) {
+		return false
+	}
+	return len(limit.AllowCountries) == 0 || containsCountry(limit.AllowCountries, country)
+}
@@ -0,0 +1,161 @@
+package channel
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mmdbString encode a utf8 string of the maxmind db data section
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+// mmdbUint encode uint16(type 5) or uint32(type 6)
+func mmdbUint(typ byte, v uint32) []byte {
+	if typ == 5 {
+		return []byte{typ<<5 | 2, byte(v >> 8), byte(v)}
+	}
+	return []byte{typ<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// writeCountryDB write an ipv4 country database mapping 'prefix.0.0.0/8' to the iso code
+func writeCountryDB(t *testing.T, path string, prefix byte, iso string) {
+	const nodeCount = 8
+	var buf bytes.Buffer
+	//24bits records of left & right branches
+	record := func(v uint32) {
+		buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+	}
+	for i := uint(0); i < 8; i++ {
+		next := uint32(i + 1)
+		if i == 7 {
+			//points to the record at offset 0 of data section
+			next = nodeCount + 16
+		}
+		if (prefix>>(7-i))&1 == 0 {
+			record(next)
+			record(nodeCount)
+		} else {
+			record(nodeCount)
+			record(next)
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.WriteByte(7<<5 | 1)
+	buf.Write(mmdbString("country"))
+	buf.WriteByte(7<<5 | 1)
+	buf.Write(mmdbString("iso_code"))
+	buf.Write(mmdbString(iso))
+
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	buf.WriteByte(7<<5 | 6)
+	buf.Write(mmdbString("node_count"))
+	buf.Write(mmdbUint(6, nodeCount))
+	buf.Write(mmdbString("record_size"))
+	buf.Write(mmdbUint(5, 24))
+	buf.Write(mmdbString("ip_version"))
+	buf.Write(mmdbUint(5, 4))
+	buf.Write(mmdbString("database_type"))
+	buf.Write(mmdbString("GeoLite2-Country"))
+	buf.Write(mmdbString("binary_format_major_version"))
+	buf.Write(mmdbUint(5, 2))
+	buf.Write(mmdbString("binary_format_minor_version"))
+	buf.Write(mmdbUint(5, 0))
+	replaceFile(t, path, buf.Bytes())
+}
+
+// replaceFile write by renaming as database updaters do, the loaded database maps the old file
+func replaceFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".tmp", path); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestGeoIPDatabaseReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "country.mmdb")
+	db := &geoIPDatabase{path: path}
+	if c := db.country(net.ParseIP("1.2.3.4")); c != "" {
+		t.Errorf("expect no country before loaded, but got %s", c)
+	}
+	writeCountryDB(t, path, 1, "JP")
+	if err = db.reload(); nil != err {
+		t.Fatal(err)
+	}
+	tests := map[string]string{"1.2.3.4": "JP", "1.255.0.1": "JP", "2.2.3.4": "", "::1": ""}
+	for ip, country := range tests {
+		if c := db.country(net.ParseIP(ip)); c != country {
+			t.Errorf("expect country %q of %s, but got %q", country, ip, c)
+		}
+	}
+	//changed file is reloaded, invalid one keeps the loaded database
+	writeCountryDB(t, path, 1, "US")
+	os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	db.reload()
+	if c := db.country(net.ParseIP("1.2.3.4")); c != "US" {
+		t.Errorf("expect reloaded country US, but got %q", c)
+	}
+	replaceFile(t, path, []byte("bad"))
+	os.Chtimes(path, time.Now().Add(2*time.Hour), time.Now().Add(2*time.Hour))
+	if err = db.reload(); nil == err {
+		t.Errorf("expect error reloading invalid database")
+	}
+	if c := db.country(net.ParseIP("1.2.3.4")); c != "US" {
+		t.Errorf("expect database kept after invalid reload, but got %q", c)
+	}
+}
+
+func TestProxyLimitCountries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "country.mmdb")
+	writeCountryDB(t, path, 1, "JP")
+	defer func(db *geoIPDatabase) { proxyLimitGeoIP = db }(proxyLimitGeoIP)
+	proxyLimitGeoIP = &geoIPDatabase{path: path}
+	proxyLimitGeoIP.reload()
+
+	tests := []struct {
+		allow, deny []string
+		addr        string
+		allowed     bool
+	}{
+		{nil, nil, "1.2.3.4:80", true},
+		{[]string{"JP"}, nil, "1.2.3.4:80", true},
+		{[]string{"jp"}, nil, "2.2.2.2:80", false},
+		{[]string{"US"}, nil, "1.2.3.4:80", false},
+		{nil, []string{"jp"}, "1.2.3.4", false},
+		{nil, []string{"JP"}, "2.2.2.2:80", true},
+		{nil, []string{"*"}, "1.2.3.4:80", false},
+		{[]string{"*"}, []string{"JP"}, "1.2.3.4:80", false},
+		//domains are checked by the dialed ip
+		{[]string{"US"}, nil, "example.com:80", true},
+	}
+	for _, tt := range tests {
+		limit := &ProxyLimitConfig{AllowCountries: tt.allow, DenyCountries: tt.deny}
+		if allowed := limit.Allowed(tt.addr); allowed != tt.allowed {
+			t.Errorf("allow:%v deny:%v expect %s allowed:%v, but got %v", tt.allow, tt.deny, tt.addr, tt.allowed, allowed)
+		}
+	}
+
+	defer SetDefaultProxyLimitConfig(*defaultProxyLimit())
+	SetDefaultProxyLimitConfig(ProxyLimitConfig{DenyCountries: []string{"JP"}})
+	if allowedDialIP("u", "a.test:80", net.ParseIP("1.2.3.4")) || !allowedDialIP("u", "a.test:80", net.ParseIP("2.2.2.2")) ||
+		allowedDialIP("u", "a.test:80", nil) {
+		t.Errorf("unexpected dial ip check with deny countries:%v", defaultProxyLimit().DenyCountries)
+	}
+}
@@ -0,0 +1,155 @@
+package grpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const DefaultTunnelPath = "/gsnova.Tunnel/Stream"
+
+const codecName = "gsnova"
+
+type frame struct {
+	data []byte
+}
+
+// rawCodec pass the mux session bytes as gRPC messages without protobuf
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, errors.New("invalid grpc tunnel frame")
+	}
+	//the message may be sent after Write returned, while the caller reuses the buffer
+	return append([]byte(nil), f.data...), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return errors.New("invalid grpc tunnel frame")
+	}
+	f.data = append(f.data[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(rawCodec{})
+}
+
+func splitTunnelPath(path string) (string, string) {
+	if len(path) == 0 || path == "/" {
+		path = DefaultTunnelPath
+	}
+	path = strings.TrimPrefix(path, "/")
+	idx := strings.LastIndex(path, "/")
+	if idx <= 0 || idx == len(path)-1 {
+		return path, "Stream"
+	}
+	return path[0:idx], path[idx+1:]
+}
+
+func tunnelStreamDesc(method string, handler grpc.StreamHandler) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName:    method,
+		Handler:       handler,
+		ServerStreams: true,
+		ClientStreams: true,
+	}
+}
+
+type grpcAddr string
+
+func (a grpcAddr) Network() string {
+	return "grpc"
+}
+func (a grpcAddr) String() string {
+	return string(a)
+}
+
+// streamConn adapt a bidirectional gRPC stream to net.Conn
+type streamConn struct {
+	stream     grpc.Stream
+	remoteAddr string
+	recvBuf    []byte
+	recvFrame  frame
+	closeOnce  sync.Once
+	closeFunc  func()
+	closeCh    chan struct{}
+	writeLock  sync.Mutex
+}
+
+func newStreamConn(stream grpc.Stream, remoteAddr string, closeFunc func()) *streamConn {
+	return &streamConn{
+		stream:     stream,
+		remoteAddr: remoteAddr,
+		closeFunc:  closeFunc,
+		closeCh:    make(chan struct{}),
+	}
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	if len(c.recvBuf) == 0 {
+		err := c.stream.RecvMsg(&c.recvFrame)
+		if nil != err {
+			return 0, err
+		}
+		c.recvBuf = c.recvFrame.data
+	}
+	n := copy(b, c.recvBuf)
+	c.recvBuf = c.recvBuf[n:]
+	return n, nil
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	select {
+	case <-c.closeCh:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	err := c.stream.SendMsg(&frame{data: b})
+	if nil != err {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+		if nil != c.closeFunc {
+			c.closeFunc()
+		}
+	})
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return grpcAddr("grpc")
+}
+func (c *streamConn) RemoteAddr() net.Addr {
+	return grpcAddr(c.remoteAddr)
+}
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return nil
+}
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
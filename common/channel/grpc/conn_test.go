@@ -0,0 +1,122 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestSplitTunnelPath(t *testing.T) {
+	for _, c := range []struct {
+		path            string
+		service, method string
+	}{
+		{"", "gsnova.Tunnel", "Stream"},
+		{"/", "gsnova.Tunnel", "Stream"},
+		{"/pkg.Service/Method", "pkg.Service", "Method"},
+		{"pkg.Service/Method", "pkg.Service", "Method"},
+		{"/pkg.Service", "pkg.Service", "Stream"},
+		{"/a/b.Service/Method", "a/b.Service", "Method"},
+	} {
+		service, method := splitTunnelPath(c.path)
+		if service != c.service || method != c.method {
+			t.Errorf("path %q split to %q %q, expected %q %q", c.path, service, method, c.service, c.method)
+		}
+	}
+}
+
+func TestRawCodecCopyFrames(t *testing.T) {
+	codec := rawCodec{}
+	data := []byte("mux frame")
+	b, err := codec.Marshal(&frame{data: data})
+	if nil != err {
+		t.Fatal(err)
+	}
+	//the buffer of Write may be reused before the message sent
+	data[0] = 'X'
+	if string(b) != "mux frame" {
+		t.Errorf("marshaled message changed with the written buffer:%q", b)
+	}
+	var f frame
+	if err = codec.Unmarshal(b, &f); nil != err || string(f.data) != "mux frame" {
+		t.Errorf("unmarshal got %q, %v", f.data, err)
+	}
+	if _, err = codec.Marshal("frame"); nil == err {
+		t.Errorf("marshal non frame should fail")
+	}
+	if err = codec.Unmarshal(b, new(string)); nil == err {
+		t.Errorf("unmarshal into non frame should fail")
+	}
+}
+
+func TestStreamConnRoundTrip(t *testing.T) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	serviceName, methodName := splitTunnelPath("/test.Tunnel/Echo")
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{tunnelStreamDesc(methodName, func(srv interface{}, stream grpc.ServerStream) error {
+			conn := newStreamConn(stream, "", nil)
+			io.Copy(conn, conn)
+			return nil
+		})},
+	}, struct{}{})
+	go server.Serve(lp)
+	defer server.Stop()
+
+	cc, err := grpc.Dial(lp.Addr().String(), grpc.WithInsecure())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	desc := tunnelStreamDesc(methodName, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := cc.NewStream(ctx, &desc, "/"+serviceName+"/"+methodName, grpc.CallContentSubtype(codecName))
+	if nil != err {
+		t.Fatal(err)
+	}
+	closed := false
+	conn := newStreamConn(stream, lp.Addr().String(), func() {
+		closed = true
+		stream.CloseSend()
+		cancel()
+	})
+	if conn.RemoteAddr().String() != lp.Addr().String() || conn.RemoteAddr().Network() != "grpc" {
+		t.Errorf("unexpected remote address %v", conn.RemoteAddr())
+	}
+	buf := make([]byte, 64*1024)
+	for _, size := range []int{1, 100, 16 * 1024, 64 * 1024} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		if _, err = conn.Write(data); nil != err {
+			t.Fatal(err)
+		}
+		//read by small pieces, the rest of a message is kept for next reads
+		var echo []byte
+		for len(echo) < size {
+			n, err := conn.Read(buf[:1+len(echo)%4096])
+			if nil != err {
+				t.Fatalf("read echo of %d bytes:%v", size, err)
+			}
+			echo = append(echo, buf[:n]...)
+		}
+		if !bytes.Equal(echo, data) {
+			t.Errorf("echo of %d bytes mismatched", size)
+		}
+	}
+	conn.Close()
+	conn.Close()
+	if !closed {
+		t.Errorf("close func not called")
+	}
+	if _, err = conn.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("write after close got %v", err)
+	}
+}
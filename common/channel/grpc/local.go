@@ -0,0 +1,89 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type GRPCProxy struct {
+}
+
+func (p *GRPCProxy) Features() channel.FeatureSet {
+	return channel.FeatureSet{
+		AutoExpire: true,
+		Pingable:   true,
+	}
+}
+
+func (p *GRPCProxy) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
+	rurl, err := url.Parse(server)
+	if nil != err {
+		return nil, err
+	}
+	path := conf.GRPC.Path
+	if len(path) == 0 {
+		path = rurl.Path
+	}
+	authority := conf.GRPC.Authority
+	if len(authority) == 0 {
+		authority = rurl.Query().Get("authority")
+	}
+	tcpHost, _, err := net.SplitHostPort(rurl.Host)
+	if nil != err {
+		tcpHost = rurl.Host
+	}
+	tlscfg, err := channel.NewTLSConfig(conf)
+	if nil != err {
+		return nil, err
+	}
+	if len(tlscfg.ServerName) == 0 && net.ParseIP(tcpHost) == nil {
+		tlscfg.ServerName = tcpHost
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(tlscfg)),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return channel.DialServerByConf(server, conf)
+		}),
+	}
+	if len(authority) > 0 {
+		opts = append(opts, grpc.WithAuthority(authority))
+	}
+	cc, err := grpc.Dial(rurl.Host, opts...)
+	if nil != err {
+		return nil, err
+	}
+	serviceName, methodName := splitTunnelPath(path)
+	desc := tunnelStreamDesc(methodName, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := cc.NewStream(ctx, &desc, "/"+serviceName+"/"+methodName, grpc.CallContentSubtype(codecName))
+	if nil != err {
+		cancel()
+		cc.Close()
+		return nil, err
+	}
+	conn := newStreamConn(stream, rurl.Host, func() {
+		stream.CloseSend()
+		cancel()
+		cc.Close()
+	})
+	logger.Info("gRPC Session:%v", server)
+	muxConf := channel.InitialPMuxConfig(&conf.Cipher)
+	ps, err := pmux.Client(conn, muxConf)
+	if nil != err {
+		conn.Close()
+		return nil, err
+	}
+	return &mux.ProxyMuxSession{Session: ps, Config: muxConf}, nil
+}
+
+func init() {
+	channel.RegisterLocalChannelType("grpc", &GRPCProxy{})
+}
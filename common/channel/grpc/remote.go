@@ -0,0 +1,61 @@
+package grpc
+
+import (
+	"crypto/tls"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/supervisor"
+	"github.com/yinqiwen/pmux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func servTunnelStream(srv interface{}, stream grpc.ServerStream) error {
+	remoteAddr := ""
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		remoteAddr = p.Addr.String()
+	}
+	closeCh := make(chan struct{})
+	conn := newStreamConn(stream, remoteAddr, func() {
+		close(closeCh)
+	})
+	muxConf := channel.InitialPMuxConfig(&channel.DefaultServerCipher)
+	session, err := pmux.Server(conn, muxConf)
+	if nil != err {
+		logger.Error("[ERROR]Failed to create mux session for grpc server with reason:%v", err)
+		return err
+	}
+	muxSession := &mux.ProxyMuxSession{Session: session, Config: muxConf}
+	go func() {
+		channel.ServProxyMuxSession(muxSession, nil)
+		conn.Close()
+	}()
+	select {
+	case <-closeCh:
+	case <-stream.Context().Done():
+		muxSession.Close()
+	}
+	return nil
+}
+
+// StartGRPCProxyServer serve the tunnel on path like '/pkg.Service/Method', empty path use the default one.
+func StartGRPCProxyServer(addr string, path string, config *tls.Config) error {
+	lp, err := supervisor.Listen("tcp", addr)
+	if nil != err {
+		logger.Error("[ERROR]Failed to listen gRPC address:%s with reason:%v", addr, err)
+		return err
+	}
+	serviceName, methodName := splitTunnelPath(path)
+	desc := &grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*interface{})(nil),
+		Streams:     []grpc.StreamDesc{tunnelStreamDesc(methodName, servTunnelStream)},
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	server.RegisterService(desc, struct{}{})
+	logger.Info("Listen on gRPC address:%s with path /%s/%s", addr, serviceName, methodName)
+	return server.Serve(lp)
+}
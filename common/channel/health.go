@@ -0,0 +1,137 @@
+package channel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+type HealthCheckConfig struct {
+	Enable bool
+	//probe interval, default 10
+	IntervalSecs int
+	//max wait time of the echo, default 3000
+	TimeoutMS int
+	//mark the server unhealthy after continuous probe failures, default 3
+	FailThreshold int
+}
+
+func (conf *HealthCheckConfig) adjust() {
+	if conf.IntervalSecs <= 0 {
+		conf.IntervalSecs = 10
+	}
+	if conf.TimeoutMS <= 0 {
+		conf.TimeoutMS = 3000
+	}
+	if conf.FailThreshold <= 0 {
+		conf.FailThreshold = 3
+	}
+}
+
+func (h *pathHealth) healthy() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return !h.unhealthy
+}
+
+// onProbe update the state by probe result, return true if the state changed
+func (h *pathHealth) onProbe(rtt time.Duration, err error, threshold int) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if nil != err {
+		h.loss = h.loss*7/8 + 0.125
+		h.probeFails++
+		if !h.unhealthy && h.probeFails >= threshold {
+			h.unhealthy = true
+			return true
+		}
+		return false
+	}
+	h.probeFails = 0
+	h.loss = h.loss * 7 / 8
+	if h.rtt == 0 {
+		h.rtt = rtt
+	} else {
+		h.rtt = (h.rtt*7 + rtt) / 8
+	}
+	if h.unhealthy {
+		h.unhealthy = false
+		return true
+	}
+	return false
+}
+
+func handlePingStream(stream mux.MuxStream) {
+	defer stream.Close()
+	b := make([]byte, 8)
+	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(stream, b); nil == err {
+		stream.Write(b)
+	}
+}
+
+// probe open a ping stream to the server & wait the echo
+func (s *muxSessionHolder) probe(timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	stream, err := s.getNewStream()
+	if nil != err {
+		return 0, err
+	}
+	defer stream.Close()
+	if err = stream.Connect(mux.PingNetwork, "", mux.StreamOptions{}); nil != err {
+		return 0, err
+	}
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(start.UnixNano()))
+	if _, err = stream.Write(payload); nil != err {
+		return 0, err
+	}
+	echo := make([]byte, 8)
+	stream.SetReadDeadline(start.Add(timeout))
+	if _, err = io.ReadFull(stream, echo); nil != err {
+		return 0, err
+	}
+	if !bytes.Equal(payload, echo) {
+		return 0, fmt.Errorf("invalid ping echo from %s", s.server)
+	}
+	return time.Now().Sub(start), nil
+}
+
+// connected report whether the holder currently owns an established session
+func (s *muxSessionHolder) connected() bool {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	return nil != s.muxSession || nil != s.p2pSession
+}
+
+// healthCheck probe the session holder until the channel is stopped or replaced
+func (ch *LocalProxyChannel) healthCheck(holder *muxSessionHolder) {
+	conf := &ch.Conf.HealthCheck
+	ticker := time.NewTicker(time.Duration(conf.IntervalSecs) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		localChannelMutex.Lock()
+		current := localChannelTable[ch.Conf.Name] == ch
+		localChannelMutex.Unlock()
+		if !current {
+			return
+		}
+		if ch.Conf.lazyConnect && !holder.connected() {
+			//probes should not connect sessions of lazy channels
+			continue
+		}
+		rtt, err := holder.probe(time.Duration(conf.TimeoutMS) * time.Millisecond)
+		if holder.health.onProbe(rtt, err, conf.FailThreshold) {
+			if nil != err {
+				logger.Error("[ERROR]Remote:%s marked unhealthy for reason:%v", holder.server, err)
+			} else {
+				logger.Notice("Remote:%s recovered with rtt:%v", holder.server, rtt)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package channel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestHealthCheckConfigAdjust(t *testing.T) {
+	tests := []struct {
+		conf     HealthCheckConfig
+		interval int
+		timeout  int
+		fails    int
+	}{
+		{HealthCheckConfig{}, 10, 3000, 3},
+		{HealthCheckConfig{IntervalSecs: -1, TimeoutMS: -1, FailThreshold: -1}, 10, 3000, 3},
+		{HealthCheckConfig{IntervalSecs: 5, TimeoutMS: 500, FailThreshold: 1}, 5, 500, 1},
+	}
+	for _, tt := range tests {
+		conf := tt.conf
+		conf.adjust()
+		if conf.IntervalSecs != tt.interval || conf.TimeoutMS != tt.timeout || conf.FailThreshold != tt.fails {
+			t.Errorf("expect %d/%d/%d of %+v, but got %+v", tt.interval, tt.timeout, tt.fails, tt.conf, conf)
+		}
+	}
+}
+
+func TestPathHealthOnProbe(t *testing.T) {
+	h := &pathHealth{}
+	probeErr := mux.ErrAuthFailed
+	tests := []struct {
+		rtt     time.Duration
+		err     error
+		changed bool
+		healthy bool
+		rtt2    time.Duration
+	}{
+		{80 * time.Millisecond, nil, false, true, 80 * time.Millisecond},
+		{0, probeErr, false, true, 80 * time.Millisecond},
+		{0, probeErr, true, false, 80 * time.Millisecond},
+		//still unhealthy, state not changed
+		{0, probeErr, false, false, 80 * time.Millisecond},
+		{160 * time.Millisecond, nil, true, true, 90 * time.Millisecond},
+		//failures are counted continuously
+		{0, probeErr, false, true, 90 * time.Millisecond},
+		{90 * time.Millisecond, nil, false, true, 90 * time.Millisecond},
+		{0, probeErr, false, true, 90 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		if changed := h.onProbe(tt.rtt, tt.err, 2); changed != tt.changed {
+			t.Errorf("probe %d: expect changed %v, but got %v", i, tt.changed, changed)
+		}
+		if h.healthy() != tt.healthy || h.rtt != tt.rtt2 {
+			t.Errorf("probe %d: expect healthy %v & rtt %v, but got %v & %v", i, tt.healthy, tt.rtt2, h.healthy(), h.rtt)
+		}
+	}
+	h.unhealthy = true
+	if s := h.score(1); s != 0 {
+		t.Errorf("unhealthy path should not be scored, but got %v", s)
+	}
+}
+
+func TestHealthProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		echo bool
+		err  bool
+	}{
+		{"echo", true, false},
+		{"no echo", false, true},
+	}
+	for _, tt := range tests {
+		client, server := newTestSessionPair(t)
+		ctx := newSessionContext(server, &mux.AuthRequest{})
+		go func(echo bool) {
+			stream, err := server.AcceptStream()
+			if nil != err {
+				return
+			}
+			if echo {
+				handleProxyStream(stream, ctx)
+			} else {
+				mux.ReadConnectRequest(stream)
+			}
+		}(tt.echo)
+		holder := &muxSessionHolder{server: "health.test", muxSession: client, retiredSessions: make(map[mux.MuxSession]bool)}
+		rtt, err := holder.probe(200 * time.Millisecond)
+		if tt.err {
+			if nil == err {
+				t.Errorf("%s: expect error", tt.name)
+			}
+		} else if nil != err || rtt <= 0 {
+			t.Errorf("%s: expect rtt, but got %v %v", tt.name, rtt, err)
+		}
+		//ping stream is not counted as active stream on server
+		if ctx.streamCouter != 0 {
+			t.Errorf("%s: expect no active stream, but got %d", tt.name, ctx.streamCouter)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+// countingChannel fail to create any session, and count the sessions tried
+type countingChannel struct {
+	created int
+}
+
+func (c *countingChannel) CreateMuxSession(server string, conf *ProxyChannelConfig) (mux.MuxSession, error) {
+	c.created++
+	return unreachableChannel{}.CreateMuxSession(server, conf)
+}
+func (c *countingChannel) Features() FeatureSet {
+	return FeatureSet{}
+}
+
+func TestMuxStreamPreferHealthy(t *testing.T) {
+	ch := NewProxyChannel(&ProxyChannelConfig{Name: "health"})
+	client, server := newTestSessionPair(t)
+	defer server.Close()
+	defer client.Close()
+	unhealthy := &countingChannel{}
+	good := &muxSessionHolder{server: "good", conf: &ch.Conf, Channel: unreachableChannel{}, muxSession: client, retiredSessions: make(map[mux.MuxSession]bool)}
+	bad := &muxSessionHolder{server: "bad", conf: &ch.Conf, Channel: unhealthy, retiredSessions: make(map[mux.MuxSession]bool)}
+	bad.health.unhealthy = true
+	ch.sessions[good] = true
+	ch.sessions[bad] = true
+	for i := 0; i < 10; i++ {
+		stream, err := ch.getMuxStream("")
+		if nil != err {
+			t.Fatal(err)
+		}
+		stream.Close()
+	}
+	if unhealthy.created != 0 {
+		t.Errorf("unhealthy server should not be tried while healthy server works, but got %d tries", unhealthy.created)
+	}
+	//unhealthy servers are still tried once healthy servers failed
+	good.health.unhealthy = true
+	bad.health.unhealthy = false
+	if _, err := ch.getMuxStream(""); nil != err {
+		t.Errorf("expect stream from the unhealthy server once the healthy one failed, but got %v", err)
+	}
+	if unhealthy.created != 1 {
+		t.Errorf("expect one try of the healthy server, but got %d", unhealthy.created)
+	}
+}
@@ -0,0 +1,61 @@
+package channel
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// hostConns count concurrent streams by destination host & by user+host
+var hostConns = make(map[string]int)
+var hostConnsMutex sync.Mutex
+
+// limitHost return the key of the destination host, spellings of the same host like 'Example.com.' or
+// '[::ffff:1.2.3.4]' share one key
+func limitHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if nil != err {
+		host = strings.Trim(addr, "[]")
+	}
+	if ip := net.ParseIP(host); nil != ip {
+		return ip.String()
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func userHostConnsLimit(user string) int {
+	if uc := getUserConfig(user); nil != uc && uc.MaxConnsPerHost != 0 {
+		return uc.MaxConnsPerHost
+	}
+	return defaultProxyLimit().MaxUserConnsPerHost
+}
+
+// acquireHostConn count a new stream to the destination, return false if the global or user limit reached
+func acquireHostConn(user string, addr string) (bool, func()) {
+	host := limitHost(addr)
+	userKey := user + "@" + host
+	globalLimit := defaultProxyLimit().MaxConnsPerHost
+	userLimit := userHostConnsLimit(user)
+	if globalLimit <= 0 && userLimit <= 0 {
+		return true, func() {}
+	}
+	hostConnsMutex.Lock()
+	defer hostConnsMutex.Unlock()
+	if globalLimit > 0 && hostConns[host] >= globalLimit {
+		return false, nil
+	}
+	if userLimit > 0 && hostConns[userKey] >= userLimit {
+		return false, nil
+	}
+	hostConns[host]++
+	hostConns[userKey]++
+	return true, func() {
+		hostConnsMutex.Lock()
+		defer hostConnsMutex.Unlock()
+		for _, key := range []string{host, userKey} {
+			if hostConns[key]--; hostConns[key] <= 0 {
+				delete(hostConns, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package channel
+
+import "testing"
+
+func TestLimitHost(t *testing.T) {
+	tests := []struct {
+		addr string
+		host string
+	}{
+		{"www.example.com:443", "www.example.com"},
+		{"WWW.Example.com.:80", "www.example.com"},
+		{"www.example.com", "www.example.com"},
+		{"1.2.3.4:80", "1.2.3.4"},
+		{"[::ffff:1.2.3.4]:443", "1.2.3.4"},
+		{"[2001:DB8::1]", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		if host := limitHost(tt.addr); host != tt.host {
+			t.Errorf("expect host %s of %s, but got %s", tt.host, tt.addr, host)
+		}
+	}
+}
+
+func TestAcquireHostConn(t *testing.T) {
+	defer SetDefaultProxyLimitConfig(ProxyLimitConfig{})
+	defer SetUserConfigs(nil)
+	SetDefaultProxyLimitConfig(ProxyLimitConfig{MaxConnsPerHost: 3, MaxUserConnsPerHost: 2})
+	if err := SetUserConfigs([]UserConfig{{Name: "vip", MaxConnsPerHost: -1}, {Name: "tiny", MaxConnsPerHost: 1}}); nil != err {
+		t.Fatal(err)
+	}
+	var releases []func()
+	tests := []struct {
+		user string
+		addr string
+		ok   bool
+	}{
+		{"alice", "www.example.com:443", true},
+		{"alice", "WWW.example.com:80", true},
+		//user limit of the host reached
+		{"alice", "www.example.com:443", false},
+		{"alice", "other.com:443", true},
+		{"tiny", "www.example.com:443", true},
+		//global limit of the host reached even for unlimited users
+		{"vip", "www.example.com:443", false},
+		{"tiny", "other.com:443", true},
+		{"tiny", "other.com:443", false},
+		{"vip", "other.com:443", true},
+	}
+	for _, tt := range tests {
+		ok, release := acquireHostConn(tt.user, tt.addr)
+		if ok != tt.ok {
+			t.Errorf("expect %s to %s allowed %v, but got %v", tt.user, tt.addr, tt.ok, ok)
+		}
+		if ok {
+			releases = append(releases, release)
+		}
+	}
+	//released streams make room again & the counters are dropped at zero
+	releases[0]()
+	if ok, release := acquireHostConn("vip", "www.example.com:443"); !ok {
+		t.Errorf("expect stream allowed once another one released")
+	} else {
+		releases = append(releases, release)
+	}
+	for _, release := range releases[1:] {
+		release()
+	}
+	hostConnsMutex.Lock()
+	remain := len(hostConns)
+	hostConnsMutex.Unlock()
+	if remain != 0 {
+		t.Errorf("expect no counter left, but got %v", hostConns)
+	}
+
+	SetDefaultProxyLimitConfig(ProxyLimitConfig{})
+	for i := 0; i < 10; i++ {
+		if ok, _ := acquireHostConn("alice", "www.example.com:443"); !ok {
+			t.Fatalf("expect unlimited streams without limits")
+		}
+	}
+}
@@ -402,11 +402,12 @@ func (ws *HTTPProxy) CreateMuxSession(server string, conf *channel.ProxyChannelC
 		return nil, err
 	}
 	//log.Printf("Connect %s success.", server)
-	ps, err := pmux.Client(conn, channel.InitialPMuxConfig(&conf.Cipher))
+	muxConf := channel.InitialPMuxConfig(&conf.Cipher)
+	ps, err := pmux.Client(conn, muxConf)
 	if nil != err {
 		return nil, err
 	}
-	return &mux.ProxyMuxSession{Session: ps}, nil
+	return &mux.ProxyMuxSession{Session: ps, Config: muxConf}, nil
 }
 
 func init() {
@@ -249,11 +249,12 @@ func HTTPInvoke(w http.ResponseWriter, r *http.Request) {
 			logger.Error("###ERR1 : %s", r.Header.Get(mux.HTTPMuxSessionACKIDHeader))
 			return
 		}
-		session, err := pmux.Server(c, channel.InitialPMuxConfig(&channel.DefaultServerCipher))
+		muxConf := channel.InitialPMuxConfig(&channel.DefaultServerCipher)
+		session, err := pmux.Server(c, muxConf)
 		if nil != err {
 			return
 		}
-		muxSession := &mux.ProxyMuxSession{Session: session}
+		muxSession := &mux.ProxyMuxSession{Session: session, Config: muxConf}
 		go func() {
 			err := channel.ServProxyMuxSession(muxSession, nil)
 			if nil != err {
@@ -14,6 +14,7 @@ import (
 	"github.com/yinqiwen/gsnova/common/helper"
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/supervisor"
 	//"github.com/yinqiwen/gsnova/remote"
 )
 
@@ -110,7 +111,7 @@ func servHTTP2(lp net.Listener, addr string, config *tls.Config) {
 }
 
 func StartHTTTP2ProxyServer(addr string, config *tls.Config) error {
-	lp, err := net.Listen("tcp", addr)
+	lp, err := supervisor.Listen("tcp", addr)
 	if nil != err {
 		logger.Error("[ERROR]Failed to listen TCP address:%s with reason:%v", addr, err)
 		return err
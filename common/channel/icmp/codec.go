@@ -0,0 +1,87 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"net"
+
+	xicmp "golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Mux bytes are carried in payloads of echo requests & replies prefixed by a header of magic, flags, session
+// & sequence. Requests are sent one by one, a request is retransmitted with the same sequence until replied,
+// and the server replays the last reply for it. Replies of the server have 'flagReply' set, echoes
+// answered by the kernel mirror the request & are ignored.
+
+const (
+	payloadMagic = "GSNI"
+	headerLen    = 13
+	//fits the MTU of ipv6 paths with tunnels
+	maxPayload = 1200
+
+	flagClose = 1
+	flagReply = 2
+	flagReset = 4
+
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+type packetHeader struct {
+	flags   byte
+	session uint32
+	seq     uint32
+}
+
+func encodePacket(h packetHeader, data []byte) []byte {
+	b := make([]byte, headerLen+len(data))
+	copy(b, payloadMagic)
+	b[4] = h.flags
+	binary.BigEndian.PutUint32(b[5:9], h.session)
+	binary.BigEndian.PutUint32(b[9:13], h.seq)
+	copy(b[headerLen:], data)
+	return b
+}
+
+func decodePacket(b []byte) (packetHeader, []byte, bool) {
+	var h packetHeader
+	if len(b) < headerLen || string(b[:4]) != payloadMagic {
+		return h, nil, false
+	}
+	h.flags = b[4]
+	h.session = binary.BigEndian.Uint32(b[5:9])
+	h.seq = binary.BigEndian.Uint32(b[9:13])
+	return h, b[headerLen:], true
+}
+
+// echoTypes return the echo request & reply types of the ip family
+func echoTypes(ip net.IP) (xicmp.Type, xicmp.Type) {
+	if nil == ip.To4() {
+		return ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+	return ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+}
+
+func icmpProtocol(ip net.IP) int {
+	if nil == ip.To4() {
+		return protocolIPv6ICMP
+	}
+	return protocolICMP
+}
+
+func marshalEcho(typ xicmp.Type, id, seq int, payload []byte) ([]byte, error) {
+	msg := &xicmp.Message{Type: typ, Body: &xicmp.Echo{ID: id, Seq: seq & 0xffff, Data: payload}}
+	//checksum of ICMPv6 is filled by the kernel
+	return msg.Marshal(nil)
+}
+
+// parseEcho return the echo body if the packet is an echo of the type
+func parseEcho(protocol int, b []byte, typ xicmp.Type) (*xicmp.Echo, bool) {
+	msg, err := xicmp.ParseMessage(protocol, b)
+	if nil != err || msg.Type != typ {
+		return nil, false
+	}
+	echo, ok := msg.Body.(*xicmp.Echo)
+	return echo, ok
+}
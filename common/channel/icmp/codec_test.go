@@ -0,0 +1,42 @@
+package icmp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestPacketCodec(t *testing.T) {
+	h := packetHeader{flags: flagClose, session: 0x01020304, seq: 70000}
+	data := []byte("mux bytes")
+	b := encodePacket(h, data)
+	dh, ddata, ok := decodePacket(b)
+	if !ok || dh != h || !bytes.Equal(ddata, data) {
+		t.Fatalf("decode mismatch:%v %v %q", ok, dh, ddata)
+	}
+	if _, _, ok := decodePacket([]byte("abcdefghijklmnopq")); ok {
+		t.Fatalf("payload of normal pings should be ignored")
+	}
+	if _, _, ok := decodePacket(b[:headerLen-1]); ok {
+		t.Fatalf("short payload should be ignored")
+	}
+}
+
+func TestEchoCodec(t *testing.T) {
+	for _, ip := range []string{"1.2.3.4", "2001:db8::1"} {
+		requestType, replyType := echoTypes(net.ParseIP(ip))
+		payload := encodePacket(packetHeader{session: 7, seq: 0x10001}, []byte("x"))
+		b, err := marshalEcho(requestType, 99, 0x10001, payload)
+		if nil != err {
+			t.Fatal(err)
+		}
+		protocol := icmpProtocol(net.ParseIP(ip))
+		if _, ok := parseEcho(protocol, b, replyType); ok {
+			t.Fatalf("request parsed as reply")
+		}
+		echo, ok := parseEcho(protocol, b, requestType)
+		if !ok || echo.ID != 99 || echo.Seq != 1 || !bytes.Equal(echo.Data, payload) {
+			t.Fatalf("echo mismatch of %s:%v", ip, echo)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package icmp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+	xicmp "golang.org/x/net/icmp"
+)
+
+const (
+	maxClientSendBuffer = 64 * 1024
+	//the session is broken after continuous unreplied requests
+	maxRequestFails = 10
+	defaultPollMax  = 500 * time.Millisecond
+	minPollInterval = 10 * time.Millisecond
+	initialRTO      = 500 * time.Millisecond
+	minRTO          = 100 * time.Millisecond
+	maxRTO          = 2 * time.Second
+)
+
+var errNoTunnelReply = errors.New("no icmp tunnel reply")
+
+type reply struct {
+	h    packetHeader
+	data []byte
+}
+
+// clientConn send echo requests one by one, an empty request polls downstream data when idle
+type clientConn struct {
+	conn        *xicmp.PacketConn
+	server      net.Addr
+	protocol    int
+	requestType xicmp.Type
+	replyType   xicmp.Type
+	id          int
+	session     uint32
+	seq         uint32
+	pollMax     time.Duration
+	srtt        time.Duration
+	replies     chan reply
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	recv   bytes.Buffer
+	send   bytes.Buffer
+	closed bool
+	//the loop stopped, no more bytes received
+	broken bool
+	notify chan struct{}
+}
+
+func (c *clientConn) rto() time.Duration {
+	if 0 == c.srtt {
+		return initialRTO
+	}
+	rto := 2 * c.srtt
+	if rto < minRTO {
+		rto = minRTO
+	}
+	if rto > maxRTO {
+		rto = maxRTO
+	}
+	return rto
+}
+
+// readReplies push replies of the session to the loop, other icmp packets received by the socket are dropped
+func (c *clientConn) readReplies() {
+	b := make([]byte, 65536)
+	for {
+		n, _, err := c.conn.ReadFrom(b)
+		if nil != err {
+			return
+		}
+		echo, ok := parseEcho(c.protocol, b[:n], c.replyType)
+		if !ok {
+			continue
+		}
+		//the echo id may be rewritten by NATs & unprivileged sockets, the session in payload identifies replies
+		h, data, ok := decodePacket(echo.Data)
+		if !ok || h.session != c.session || h.flags&flagReply == 0 {
+			continue
+		}
+		select {
+		case c.replies <- reply{h, append([]byte{}, data...)}:
+		default:
+		}
+	}
+}
+
+func (c *clientConn) exchange(flags byte, data []byte, retransmit bool) (byte, []byte, error) {
+	packet, err := marshalEcho(c.requestType, c.id, int(c.seq), encodePacket(packetHeader{flags: flags, session: c.session, seq: c.seq}, data))
+	if nil != err {
+		return 0, nil, err
+	}
+	start := time.Now()
+	if _, err = c.conn.WriteTo(packet, c.server); nil != err {
+		return 0, nil, err
+	}
+	timer := time.NewTimer(c.rto())
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-c.replies:
+			if r.h.seq != c.seq {
+				//late reply of a retransmitted request
+				continue
+			}
+			if !retransmit {
+				//rtt of retransmitted requests is ambiguous
+				rtt := time.Now().Sub(start)
+				if 0 == c.srtt {
+					c.srtt = rtt
+				} else {
+					c.srtt = (c.srtt*7 + rtt) / 8
+				}
+			}
+			return r.h.flags, r.data, nil
+		case <-timer.C:
+			return 0, nil, errNoTunnelReply
+		}
+	}
+}
+
+func (c *clientConn) loop() {
+	var pending []byte
+	hasPending := false
+	poll := time.Duration(0)
+	fails := 0
+	for {
+		c.mutex.Lock()
+		closed := c.closed
+		if !hasPending {
+			n := c.send.Len()
+			if n > maxPayload-headerLen {
+				n = maxPayload - headerLen
+			}
+			pending = append([]byte{}, c.send.Next(n)...)
+			hasPending = true
+			c.cond.Broadcast()
+		}
+		c.mutex.Unlock()
+		var flags byte
+		if closed {
+			flags = flagClose
+		}
+		rflags, data, err := c.exchange(flags, pending, fails > 0)
+		if nil != err {
+			fails++
+			//close requests are best effort
+			if fails >= maxRequestFails || (closed && fails >= 3) {
+				logger.Notice("Stop icmp tunnel session:%08x with reason:%v", c.session, err)
+				c.shutdown()
+				return
+			}
+			continue
+		}
+		fails = 0
+		c.seq++
+		sent := len(pending)
+		pending, hasPending = nil, false
+		if closed || rflags&flagReset != 0 {
+			c.shutdown()
+			return
+		}
+		c.mutex.Lock()
+		if len(data) > 0 {
+			c.recv.Write(data)
+			c.cond.Broadcast()
+		}
+		queued := c.send.Len()
+		c.mutex.Unlock()
+		if sent > 0 || len(data) > 0 || queued > 0 {
+			poll = 0
+			continue
+		}
+		//back off polling while idle
+		poll *= 2
+		if poll < minPollInterval {
+			poll = minPollInterval
+		}
+		if poll > c.pollMax {
+			poll = c.pollMax
+		}
+		timer := time.NewTimer(poll)
+		select {
+		case <-c.notify:
+			poll = 0
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *clientConn) shutdown() {
+	c.mutex.Lock()
+	c.closed, c.broken = true, true
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	c.conn.Close()
+}
+
+func (c *clientConn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.recv.Len() == 0 && !c.broken {
+		c.cond.Wait()
+	}
+	if c.recv.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.recv.Read(b)
+}
+
+func (c *clientConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	for c.send.Len() >= maxClientSendBuffer && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		c.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	n, err := c.send.Write(b)
+	c.mutex.Unlock()
+	helper.AsyncNotify(c.notify)
+	return n, err
+}
+
+// Close let the loop send a close request
+func (c *clientConn) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	helper.AsyncNotify(c.notify)
+	return nil
+}
+
+func (c *clientConn) LocalAddr() net.Addr {
+	return tunnelAddr("icmp")
+}
+
+func (c *clientConn) RemoteAddr() net.Addr {
+	return tunnelAddr(c.server.String())
+}
+
+func (c *clientConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// listenICMP open a raw icmp socket which needs root or CAP_NET_RAW, or an unprivileged datagram one
+// allowed by 'net.ipv4.ping_group_range' on linux & by default on macOS
+func listenICMP(ip net.IP) (*xicmp.PacketConn, net.Addr, error) {
+	raw, dgram := "ip4:icmp", "udp4"
+	if nil == ip.To4() {
+		raw, dgram = "ip6:ipv6-icmp", "udp6"
+	}
+	conn, err := xicmp.ListenPacket(raw, "")
+	if nil == err {
+		return conn, &net.IPAddr{IP: ip}, nil
+	}
+	conn, derr := xicmp.ListenPacket(dgram, "")
+	if nil != derr {
+		return nil, nil, fmt.Errorf("%v, and unprivileged icmp socket failed:%v", err, derr)
+	}
+	return conn, &net.UDPAddr{IP: ip}, nil
+}
+
+type ICMPTunnelProxy struct {
+}
+
+func (p *ICMPTunnelProxy) Features() channel.FeatureSet {
+	return channel.FeatureSet{
+		AutoExpire: true,
+		Pingable:   true,
+	}
+}
+
+// CreateMuxSession by server url like 'icmp://1.2.3.4?poll=500', 'poll' is the max milliseconds between idle polls
+func (p *ICMPTunnelProxy) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
+	rurl, err := url.Parse(server)
+	if nil != err {
+		return nil, err
+	}
+	addr, err := net.ResolveIPAddr("ip", rurl.Hostname())
+	if nil != err {
+		return nil, err
+	}
+	c := &clientConn{
+		protocol: icmpProtocol(addr.IP),
+		pollMax:  defaultPollMax,
+		replies:  make(chan reply, 16),
+		notify:   make(chan struct{}, 1),
+	}
+	c.requestType, c.replyType = echoTypes(addr.IP)
+	if s := rurl.Query().Get("poll"); len(s) > 0 {
+		ms, err := strconv.Atoi(s)
+		if nil != err || ms <= 0 {
+			return nil, fmt.Errorf("invalid icmp tunnel poll:%s", s)
+		}
+		c.pollMax = time.Duration(ms) * time.Millisecond
+	}
+	if c.conn, c.server, err = listenICMP(addr.IP); nil != err {
+		return nil, err
+	}
+	var id [6]byte
+	rand.Read(id[:])
+	c.session = binary.BigEndian.Uint32(id[:4])
+	c.id = int(binary.BigEndian.Uint16(id[4:]))
+	c.cond = sync.NewCond(&c.mutex)
+	go c.readReplies()
+	go c.loop()
+	muxConf := channel.InitialPMuxConfig(&conf.Cipher)
+	session, err := pmux.Client(c, muxConf)
+	if nil != err {
+		c.Close()
+		return nil, err
+	}
+	logger.Debug("Connect %s success by %s socket.", server, c.server.Network())
+	return &mux.ProxyMuxSession{Session: session, Config: muxConf}, nil
+}
+
+func init() {
+	channel.RegisterLocalChannelType("icmp", &ICMPTunnelProxy{})
+}
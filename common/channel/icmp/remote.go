@@ -0,0 +1,242 @@
+package icmp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+	xicmp "golang.org/x/net/icmp"
+)
+
+const (
+	//writers block until the buffered downstream bytes drained by requests
+	maxServSendBuffer = 256 * 1024
+	servSessionIdle   = 2 * time.Minute
+)
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string {
+	return "icmp"
+}
+
+func (a tunnelAddr) String() string {
+	return string(a)
+}
+
+// servConn is the server side of a tunnel session, fed by echo requests of the session
+type servConn struct {
+	session uint32
+	remote  tunnelAddr
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	recv    bytes.Buffer
+	send    bytes.Buffer
+	nextSeq uint32
+	//reply payload of 'nextSeq-1' replayed for retransmitted requests
+	last   []byte
+	active time.Time
+	closed bool
+}
+
+func newServConn(session uint32, remote string) *servConn {
+	c := &servConn{session: session, remote: tunnelAddr(remote), active: time.Now()}
+	c.cond = sync.NewCond(&c.mutex)
+	return c
+}
+
+// handle accept upstream data of the request in sequence & take downstream data for the reply,
+// false if the request should not be replied
+func (c *servConn) handle(h packetHeader, data []byte) (byte, []byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.active = time.Now()
+	if nil != c.last && h.seq == c.nextSeq-1 {
+		//a retransmitted request may carry the close flag once the client closed meanwhile
+		if h.flags&flagClose != 0 && !c.closed {
+			c.closed = true
+			c.cond.Broadcast()
+		}
+		return flagReply, c.last, true
+	}
+	if c.closed {
+		return flagReply | flagReset, nil, true
+	}
+	if h.seq != c.nextSeq {
+		return 0, nil, false
+	}
+	c.recv.Write(data)
+	c.nextSeq++
+	n := c.send.Len()
+	if n > maxPayload-headerLen {
+		n = maxPayload - headerLen
+	}
+	c.last = append([]byte{}, c.send.Next(n)...)
+	if h.flags&flagClose != 0 {
+		c.closed = true
+	}
+	c.cond.Broadcast()
+	return flagReply, c.last, true
+}
+
+func (c *servConn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.recv.Len() == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.recv.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.recv.Read(b)
+}
+
+func (c *servConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.send.Len() >= maxServSendBuffer && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return c.send.Write(b)
+}
+
+func (c *servConn) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *servConn) LocalAddr() net.Addr {
+	return tunnelAddr("icmp")
+}
+
+func (c *servConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *servConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *servConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *servConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type tunnelServer struct {
+	conn     *xicmp.PacketConn
+	protocol int
+	mutex    sync.Mutex
+	sessions map[uint32]*servConn
+}
+
+func (s *tunnelServer) getSession(h packetHeader, remote string) *servConn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, exist := s.sessions[h.session]
+	if exist || h.seq != 0 {
+		return c
+	}
+	c = newServConn(h.session, remote)
+	s.sessions[h.session] = c
+	muxConf := channel.InitialPMuxConfig(&channel.DefaultServerCipher)
+	session, err := pmux.Server(c, muxConf)
+	if nil != err {
+		logger.Error("[ERROR]Failed to create mux session for icmp tunnel with reason:%v", err)
+		delete(s.sessions, h.session)
+		return nil
+	}
+	logger.Debug("New icmp tunnel session:%08x from %s", h.session, remote)
+	muxSession := &mux.ProxyMuxSession{Session: session, Config: muxConf}
+	go channel.ServProxyMuxSession(muxSession, nil)
+	return c
+}
+
+// reap close sessions not requested for a while, closed sessions are kept idle to reply retransmitted close requests
+func (s *tunnelServer) reap() {
+	for range time.Tick(30 * time.Second) {
+		now := time.Now()
+		s.mutex.Lock()
+		for id, c := range s.sessions {
+			c.mutex.Lock()
+			idle := now.Sub(c.active) > servSessionIdle
+			c.mutex.Unlock()
+			if idle {
+				c.Close()
+				delete(s.sessions, id)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func (s *tunnelServer) serve(ip net.IP) error {
+	requestType, replyType := echoTypes(ip)
+	b := make([]byte, 65536)
+	for {
+		n, addr, err := s.conn.ReadFrom(b)
+		if nil != err {
+			return err
+		}
+		//requests of normal pings are left to the kernel
+		echo, ok := parseEcho(s.protocol, b[:n], requestType)
+		if !ok {
+			continue
+		}
+		h, data, ok := decodePacket(echo.Data)
+		if !ok || h.flags&flagReply != 0 {
+			continue
+		}
+		var flags byte = flagReply | flagReset
+		var reply []byte
+		if c := s.getSession(h, addr.String()); nil != c {
+			if flags, reply, ok = c.handle(h, data); !ok {
+				//stale request, not replied so that the client retries
+				continue
+			}
+		}
+		packet, err := marshalEcho(replyType, echo.ID, echo.Seq, encodePacket(packetHeader{flags: flags, session: h.session, seq: h.seq}, reply))
+		if nil == err {
+			_, err = s.conn.WriteTo(packet, addr)
+		}
+		if nil != err {
+			logger.Debug("Failed to reply icmp tunnel session:%08x with reason:%v", h.session, err)
+		}
+	}
+}
+
+// StartICMPTunnelServer reply echo requests of tunnel sessions by a raw socket bound to the ip, which needs
+// root or CAP_NET_RAW; the kernel still replies the requests, those replies are ignored by clients
+func StartICMPTunnelServer(addr string) error {
+	ip := net.ParseIP(addr)
+	if nil == ip {
+		ip = net.IPv4zero
+	}
+	network := "ip4:icmp"
+	if nil == ip.To4() {
+		network = "ip6:ipv6-icmp"
+	}
+	conn, err := xicmp.ListenPacket(network, ip.String())
+	if nil != err {
+		logger.Error("[ERROR]Failed to listen ICMP tunnel address:%s with reason:%v", addr, err)
+		return err
+	}
+	s := &tunnelServer{conn: conn, protocol: icmpProtocol(ip), sessions: make(map[uint32]*servConn)}
+	go s.reap()
+	logger.Info("Listen on ICMP tunnel address:%s", ip)
+	return s.serve(ip)
+}
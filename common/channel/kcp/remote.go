@@ -43,12 +43,13 @@ func servKCP(lp *kcp.Listener, config *channel.KCPConfig) {
 		conn.SetMtu(config.MTU)
 		conn.SetWindowSize(config.SndWnd, config.RcvWnd)
 		conn.SetACKNoDelay(config.AckNodelay)
-		session, err := pmux.Server(conn, channel.InitialPMuxConfig(&channel.DefaultServerCipher))
+		muxConf := channel.InitialPMuxConfig(&channel.DefaultServerCipher)
+		session, err := pmux.Server(conn, muxConf)
 		if nil != err {
 			logger.Error("[ERROR]Failed to create mux session for tcp server with reason:%v", err)
 			continue
 		}
-		muxSession := &mux.ProxyMuxSession{Session: session}
+		muxSession := &mux.ProxyMuxSession{Session: session, Config: muxConf}
 		go channel.ServProxyMuxSession(muxSession, nil)
 	}
 	//ws.WriteMessage(websocket.CloseMessage, []byte{})
@@ -0,0 +1,101 @@
+package channel
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ValidKCPMode return true for presets of nodelay parameters, 'manual' keeps configured 'NoDelay', 'Interval',
+// 'Resend' & 'NoCongestion'
+func ValidKCPMode(mode string) bool {
+	switch mode {
+	case "normal", "fast", "fast2", "fast3", "manual":
+		return true
+	}
+	return false
+}
+
+func (kcfg *KCPConfig) validate() error {
+	if !ValidKCPMode(kcfg.Mode) {
+		return fmt.Errorf("invalid kcp mode:%s", kcfg.Mode)
+	}
+	if kcfg.MTU < 100 || kcfg.MTU > 1500 {
+		return fmt.Errorf("kcp mtu %d is not in [100, 1500]", kcfg.MTU)
+	}
+	if kcfg.SndWnd <= 0 || kcfg.RcvWnd <= 0 {
+		return fmt.Errorf("invalid kcp window %d/%d", kcfg.SndWnd, kcfg.RcvWnd)
+	}
+	if kcfg.DataShard < 0 || kcfg.ParityShard < 0 {
+		return fmt.Errorf("invalid kcp fec shards %d/%d", kcfg.DataShard, kcfg.ParityShard)
+	}
+	return nil
+}
+
+// WithURL return the config overridden by query parameters of the kcp url, like
+// 'kcp://host:port?mode=fast3&sndwnd=1024&rcvwnd=1024&mtu=1200&datashard=10&parityshard=3', any of 'nodelay',
+// 'interval', 'resend' & 'nc' switch the mode to 'manual'. FEC shards must be the same on both sides.
+func (kcfg KCPConfig) WithURL(u *url.URL) (KCPConfig, error) {
+	q := u.Query()
+	if mode := q.Get("mode"); len(mode) > 0 {
+		kcfg.Mode = mode
+		kcfg.adjustByMode()
+	}
+	params := []struct {
+		name   string
+		v      *int
+		manual bool
+	}{
+		{"mtu", &kcfg.MTU, false},
+		{"sndwnd", &kcfg.SndWnd, false},
+		{"rcvwnd", &kcfg.RcvWnd, false},
+		{"datashard", &kcfg.DataShard, false},
+		{"parityshard", &kcfg.ParityShard, false},
+		{"dscp", &kcfg.DSCP, false},
+		{"sockbuf", &kcfg.SockBuf, false},
+		{"nodelay", &kcfg.NoDelay, true},
+		{"interval", &kcfg.Interval, true},
+		{"resend", &kcfg.Resend, true},
+		{"nc", &kcfg.NoCongestion, true},
+	}
+	for _, p := range params {
+		s := q.Get(p.name)
+		if len(s) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if nil != err {
+			return kcfg, fmt.Errorf("invalid kcp parameter %s=%s", p.name, s)
+		}
+		*p.v = n
+		if p.manual {
+			kcfg.Mode = "manual"
+		}
+	}
+	if s := q.Get("acknodelay"); len(s) > 0 {
+		v, err := strconv.ParseBool(s)
+		if nil != err {
+			return kcfg, fmt.Errorf("invalid kcp parameter acknodelay=%s", s)
+		}
+		kcfg.AckNodelay = v
+	}
+	return kcfg, kcfg.validate()
+}
+
+// CheckKCPServers validate the kcp parameters of every kcp server of the channel
+func (conf *ProxyChannelConfig) CheckKCPServers() error {
+	kcfg := conf.KCP
+	if len(kcfg.Mode) == 0 {
+		kcfg.InitDefaultConf()
+	}
+	for _, server := range conf.ServerList {
+		u, err := url.Parse(server)
+		if nil != err || u.Scheme != "kcp" {
+			continue
+		}
+		if _, err = kcfg.WithURL(u); nil != err {
+			return fmt.Errorf("%s %v", server, err)
+		}
+	}
+	return nil
+}
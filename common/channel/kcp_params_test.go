@@ -0,0 +1,37 @@
+package channel
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestKCPConfigWithURL(t *testing.T) {
+	var base KCPConfig
+	base.InitDefaultConf()
+	base.adjustByMode()
+
+	u, _ := url.Parse("kcp://1.2.3.4:48101?mode=fast3&sndwnd=1024&rcvwnd=2048&mtu=1200&datashard=5&parityshard=2")
+	kcfg, err := base.WithURL(u)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if kcfg.Mode != "fast3" || kcfg.NoDelay != 1 || kcfg.Interval != 10 || kcfg.SndWnd != 1024 || kcfg.RcvWnd != 2048 ||
+		kcfg.MTU != 1200 || kcfg.DataShard != 5 || kcfg.ParityShard != 2 {
+		t.Fatalf("unexpected config %+v", kcfg)
+	}
+	if base.SndWnd != 128 {
+		t.Fatalf("base config changed")
+	}
+
+	u, _ = url.Parse("kcp://1.2.3.4:48101?mode=fast2&interval=15&acknodelay=false")
+	if kcfg, err = base.WithURL(u); nil != err || kcfg.Mode != "manual" || kcfg.NoDelay != 1 || kcfg.Interval != 15 || kcfg.AckNodelay {
+		t.Fatalf("unexpected manual config %+v %v", kcfg, err)
+	}
+
+	for _, s := range []string{"kcp://h:1?mode=turbo", "kcp://h:1?mtu=9000", "kcp://h:1?sndwnd=x"} {
+		u, _ = url.Parse(s)
+		if _, err = base.WithURL(u); nil == err {
+			t.Fatalf("%s should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package channel
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// KeepAliveConfig ping idle sessions to keep NAT/CDN idle timers from dropping them
+type KeepAliveConfig struct {
+	//ping sessions without new streams or pings for seconds, 0 disables
+	IntervalSecs int
+	//random seconds in [-JitterSecs, JitterSecs] added to each interval, 0 means the jitter of the install
+	//fingerprint profile, negative disables
+	JitterSecs int
+}
+
+func (conf *KeepAliveConfig) jitterSecs() int {
+	if 0 != conf.JitterSecs {
+		return conf.JitterSecs
+	}
+	jitter := getFingerprintProfile().KeepAliveJitterSecs
+	if jitter > conf.IntervalSecs/4 {
+		jitter = conf.IntervalSecs / 4
+	}
+	return jitter
+}
+
+func (conf *KeepAliveConfig) next() time.Duration {
+	d := time.Duration(conf.IntervalSecs) * time.Second
+	if jitter := conf.jitterSecs(); jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*jitter)*int64(time.Second))) - time.Duration(jitter)*time.Second
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// keepalive ping the session once it's idle for a jittered interval, the session is closed if ping failed
+func (s *muxSessionHolder) keepalive() {
+	conf := &s.conf.KeepAlive
+	for {
+		interval := conf.next()
+		time.Sleep(interval)
+		s.sessionMutex.Lock()
+		session := s.muxSession
+		idle := time.Now().Sub(s.activeTime) >= interval && time.Now().Sub(s.pingTime) >= interval
+		s.sessionMutex.Unlock()
+		if nil == session || !idle {
+			continue
+		}
+		rtt, err := session.Ping()
+		if nil != err {
+			s.health.onPingLost()
+			logger.Error("[ERROR]Keepalive ping remote:%s failed:%v", s.server, err)
+			s.close()
+			continue
+		}
+		s.health.onPing(rtt)
+		s.sessionMutex.Lock()
+		s.pingTime = time.Now()
+		s.sessionMutex.Unlock()
+	}
+}
@@ -0,0 +1,68 @@
+package channel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestKeepAliveNext(t *testing.T) {
+	tests := []struct {
+		conf KeepAliveConfig
+		min  time.Duration
+		max  time.Duration
+	}{
+		{KeepAliveConfig{IntervalSecs: 30, JitterSecs: -1}, 30 * time.Second, 30 * time.Second},
+		{KeepAliveConfig{IntervalSecs: 30, JitterSecs: 5}, 25 * time.Second, 35 * time.Second},
+		//never less than one second
+		{KeepAliveConfig{IntervalSecs: 1, JitterSecs: 5}, time.Second, 6 * time.Second},
+	}
+	for _, tt := range tests {
+		varied := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			d := tt.conf.next()
+			if d < tt.min || d > tt.max {
+				t.Fatalf("%+v: expect interval in [%v, %v], but got %v", tt.conf, tt.min, tt.max, d)
+			}
+			varied[d] = true
+		}
+		if jittered := tt.conf.JitterSecs > 0; jittered != (len(varied) > 1) {
+			t.Errorf("%+v: expect jittered %v, but got %d distinct intervals", tt.conf, jittered, len(varied))
+		}
+	}
+}
+
+func TestKeepAlivePingIdleSession(t *testing.T) {
+	client, server := newTestSessionPair(t)
+	defer server.Close()
+	defer client.Close()
+	holder := &muxSessionHolder{server: "keepalive", conf: &ProxyChannelConfig{KeepAlive: KeepAliveConfig{IntervalSecs: 1, JitterSecs: -1}},
+		muxSession: client, retiredSessions: make(map[mux.MuxSession]bool)}
+	go holder.keepalive()
+	pinged := waitUntil(5*time.Second, func() bool {
+		holder.sessionMutex.Lock()
+		defer holder.sessionMutex.Unlock()
+		return !holder.pingTime.IsZero()
+	})
+	if !pinged {
+		t.Fatalf("expect idle session pinged")
+	}
+	holder.health.mutex.Lock()
+	rtt := holder.health.rtt
+	holder.health.mutex.Unlock()
+	if rtt <= 0 {
+		t.Errorf("expect rtt measured by keepalive ping, but got %v", rtt)
+	}
+
+	//session is dropped once the ping failed
+	server.Close()
+	dropped := waitUntil(5*time.Second, func() bool {
+		holder.sessionMutex.Lock()
+		defer holder.sessionMutex.Unlock()
+		return nil == holder.muxSession
+	})
+	if !dropped {
+		t.Errorf("expect session closed after keepalive ping failed")
+	}
+}
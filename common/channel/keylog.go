@@ -0,0 +1,38 @@
+package channel
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+var keyLogWriters = make(map[string]io.Writer)
+var keyLogMutex sync.Mutex
+
+// keyLogWriter return the writer exporting TLS key material of channel connections in NSS key log format,
+// enabled by channel's 'KeyLogFile' or env SSLKEYLOGFILE.
+func keyLogWriter(conf *ProxyChannelConfig) io.Writer {
+	path := conf.KeyLogFile
+	if len(path) == 0 {
+		path = os.Getenv("SSLKEYLOGFILE")
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	keyLogMutex.Lock()
+	defer keyLogMutex.Unlock()
+	if w, exist := keyLogWriters[path]; exist {
+		return w
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if nil != err {
+		logger.Error("[ERROR]Failed to open TLS key log file:%s with reason:%v", path, err)
+		keyLogWriters[path] = nil
+		return nil
+	}
+	logger.Notice("TLS key material of channel connections would be written into %s, DO NOT enable it except debugging.", path)
+	keyLogWriters[path] = file
+	return file
+}
@@ -0,0 +1,70 @@
+package channel
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyLogWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keylog")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("SSLKEYLOGFILE", os.Getenv("SSLKEYLOGFILE"))
+	os.Unsetenv("SSLKEYLOGFILE")
+
+	if w := keyLogWriter(&ProxyChannelConfig{}); nil != w {
+		t.Errorf("expect no key log writer by default")
+	}
+	path := filepath.Join(dir, "keys.log")
+	w := keyLogWriter(&ProxyChannelConfig{KeyLogFile: path})
+	if nil == w || w != keyLogWriter(&ProxyChannelConfig{KeyLogFile: path}) {
+		t.Fatalf("expect one writer shared by channels of same key log file")
+	}
+	if fi, err := os.Stat(path); nil != err || fi.Mode().Perm() != 0600 {
+		t.Errorf("expect key log file only readable by owner, but got %v %v", fi, err)
+	}
+	envPath := filepath.Join(dir, "env.log")
+	os.Setenv("SSLKEYLOGFILE", envPath)
+	if w := keyLogWriter(&ProxyChannelConfig{}); nil == w || w == keyLogWriter(&ProxyChannelConfig{KeyLogFile: path}) {
+		t.Errorf("expect key log file by env SSLKEYLOGFILE")
+	}
+	if w := keyLogWriter(&ProxyChannelConfig{KeyLogFile: filepath.Join(dir, "none", "keys.log")}); nil != w {
+		t.Errorf("expect no writer if key log file can not be opened")
+	}
+
+	//key material of channel tls handshakes is appended
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(&ProxyChannelConfig{KeyLogFile: path})
+	if nil != err {
+		t.Fatal(err)
+	}
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	go tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}).Handshake()
+	if err = tls.Client(clientConn, cfg).Handshake(); nil != err {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(content), "CLIENT_TRAFFIC_SECRET_0 ") {
+		t.Errorf("expect NSS key log lines, but got %q", content)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math"
 	"net/url"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -39,14 +40,35 @@ type muxSessionHolder struct {
 	sessionMutex    sync.Mutex
 	conf            *ProxyChannelConfig
 	heatbeating     bool
+	p2pSession      mux.MuxSession
+	p2pConnecting   bool
+	health          pathHealth
+	controlStreams  map[mux.MuxSession]mux.MuxStream
+	sessionID       string
+	//established at startup & after network changes
+	prewarm bool
+	quota   *mux.QuotaStatus
+	//latest ping by heartbeat or keepalive
+	pingTime      time.Time
+	keepaliveOnce sync.Once
+}
+
+// activeStreams return the number of streams in the session, the control stream does not count
+func (s *muxSessionHolder) activeStreams(session mux.MuxSession) int {
+	n := session.NumStreams()
+	if _, hasControl := s.controlStreams[session]; hasControl {
+		n--
+	}
+	return n
 }
 
 func (s *muxSessionHolder) tryCloseRetiredSessions() {
 	for retiredSession := range s.retiredSessions {
-		if retiredSession.NumStreams() <= 0 {
+		if s.activeStreams(retiredSession) <= 0 {
 			logger.Debug("Close retired mux session since it's has no active stream.")
 			retiredSession.Close()
 			delete(s.retiredSessions, retiredSession)
+			delete(s.controlStreams, retiredSession)
 		}
 	}
 }
@@ -55,7 +77,7 @@ func (s *muxSessionHolder) dumpStat(w io.Writer) {
 	s.sessionMutex.Lock()
 	defer s.sessionMutex.Unlock()
 	s.tryCloseRetiredSessions()
-	fmt.Fprintf(w, "Server:%s, CreateTime:%v, RetireTime:%v, RetireSessionNum:%v\n", s.server, s.creatTime.Format("15:04:05"), s.expireTime.Format("15:04:05"), len(s.retiredSessions))
+	fmt.Fprintf(w, "Server:%s, Session:%s, CreateTime:%v, RetireTime:%v, RetireSessionNum:%v\n", s.server, s.sessionID, s.creatTime.Format("15:04:05"), s.expireTime.Format("15:04:05"), len(s.retiredSessions))
 }
 
 func (s *muxSessionHolder) close() {
@@ -66,6 +88,136 @@ func (s *muxSessionHolder) close() {
 		s.muxSession.Close()
 		s.muxSession = nil
 	}
+	if nil != s.p2pSession {
+		s.p2pSession.Close()
+		s.p2pSession = nil
+	}
+}
+
+// retire mark the session retired, new streams would be created on a new session
+func (s *muxSessionHolder) retire(session mux.MuxSession) {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	if s.muxSession == session {
+		s.retiredSessions[session] = true
+		s.muxSession = nil
+	}
+}
+
+// protocol levels of servers by session id, removed once the control stream of the session closed
+var sessionProtocolLevels sync.Map
+
+// SupportEarlyData return true if the server of the stream's session relays 'EarlyData' of connect requests
+func SupportEarlyData(conf *ProxyChannelConfig, stream mux.MuxStream) bool {
+	return supportProtocolLevel(conf, stream, mux.EarlyDataProtocolLevel)
+}
+
+// SupportConnectResponse return true if the server of the stream's session replies ConnectResponse,
+// the direct channel returns dial errors from Connect anyway
+func SupportConnectResponse(conf *ProxyChannelConfig, stream mux.MuxStream) bool {
+	return supportProtocolLevel(conf, stream, mux.ConnectResponseProtocolLevel)
+}
+
+func supportProtocolLevel(conf *ProxyChannelConfig, stream mux.MuxStream, level int) bool {
+	if DirectChannelName == conf.Name {
+		return true
+	}
+	if v, exist := sessionProtocolLevels.Load(mux.GetStreamSessionID(stream)); exist {
+		return v.(int) >= level
+	}
+	return false
+}
+
+func (s *muxSessionHolder) watchControl(session mux.MuxSession, sessionID string) {
+	defer sessionProtocolLevels.Delete(sessionID)
+	stream, err := session.OpenStream()
+	if nil != err {
+		return
+	}
+	defer func() {
+		stream.Close()
+		s.sessionMutex.Lock()
+		delete(s.controlStreams, session)
+		s.sessionMutex.Unlock()
+	}()
+	err = stream.Connect(mux.ControlNetwork, "", mux.StreamOptions{})
+	if nil != err {
+		return
+	}
+	s.sessionMutex.Lock()
+	s.controlStreams[session] = stream
+	s.sessionMutex.Unlock()
+	for {
+		stream.SetReadDeadline(time.Now().Add(24 * time.Hour))
+		msg, err := mux.ReadControlMessage(stream)
+		if nil != err {
+			return
+		}
+		switch msg.Type {
+		case mux.ControlSessionClosing:
+			logger.Notice("Remote:%s would close session for reason:%s, mark it retired.", s.server, msg.Reason)
+			s.retire(session)
+		case mux.ControlStreamChecksum:
+			onRemoteStreamChecksum(sessionID, msg)
+		case mux.ControlQuotaStatus:
+			s.onQuotaStatus(msg)
+		case mux.ControlRuleBundle:
+			s.onRuleBundle(msg)
+		case mux.ControlDialFailed:
+			logger.Notice("Remote:%s failed to dial %s for stream[%s:%d] with code:%d reason:%s", s.server, msg.Addr, sessionID, msg.StreamID, msg.Code, msg.Reason)
+		case mux.ControlStreamReset:
+			logger.Notice("Remote:%s reset stream[%s:%d] to %s for reason:%s", s.server, sessionID, msg.StreamID, msg.Addr, msg.Reason)
+		default:
+			logger.Debug("Unknown control message:%v from %s", msg, s.server)
+		}
+	}
+}
+
+func (s *muxSessionHolder) tryP2PSession(session mux.MuxSession) {
+	s.sessionMutex.Lock()
+	if s.p2pConnecting || nil != s.p2pSession {
+		s.sessionMutex.Unlock()
+		return
+	}
+	s.p2pConnecting = true
+	s.sessionMutex.Unlock()
+	var p2pSession mux.MuxSession
+	signal, err := session.OpenStream()
+	if nil == err {
+		p2pSession, err = dialP2PSession(signal, s.conf.ICEServers)
+	}
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	s.p2pConnecting = false
+	if nil != err {
+		logger.Notice("Failed to establish webrtc session with P2SP peer for reason:%v, use server relay instead.", err)
+		return
+	}
+	logger.Notice("WebRTC direct session established with P2SP peer in room:%s", s.conf.P2SPRoom)
+	s.p2pSession = p2pSession
+}
+
+// tryRendezvousP2PSession reach room peer via rendezvous backend while the server is unreachable,
+// it may wait the answer for a while, so the session mutex is not held while dialing
+func (s *muxSessionHolder) tryRendezvousP2PSession() {
+	s.sessionMutex.Lock()
+	if s.p2pConnecting || nil != s.p2pSession {
+		s.sessionMutex.Unlock()
+		return
+	}
+	s.p2pConnecting = true
+	s.sessionMutex.Unlock()
+	p2pSession, err := dialRendezvousP2PSession(s.conf)
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	s.p2pConnecting = false
+	if nil != err {
+		logger.Notice("Failed to establish webrtc session via rendezvous for reason:%v", err)
+		return
+	}
+	logger.Notice("WebRTC direct session established with P2SP peer via rendezvous:%s", s.conf.Rendezvous.URL)
+	s.p2pSession = p2pSession
+	s.sessionID = helper.RandHexString(16)
 }
 func (s *muxSessionHolder) check() {
 	if nil != s.muxSession && !s.expireTime.IsZero() && s.expireTime.Before(time.Now()) {
@@ -81,14 +233,28 @@ func (s *muxSessionHolder) getNewStream() (mux.MuxStream, error) {
 		s.tryCloseRetiredSessions()
 	}()
 	s.check()
-	if nil == s.muxSession {
+	if nil == s.muxSession && nil == s.p2pSession {
 		s.init(false)
 	}
-	if nil == s.muxSession {
+	if nil == s.muxSession && nil == s.p2pSession {
 		return nil, pmux.ErrSessionShutdown
 	}
 	s.activeTime = time.Now()
-	return s.muxSession.OpenStream()
+	if nil != s.p2pSession {
+		stream, err := s.p2pSession.OpenStream()
+		if nil == err {
+			return mux.WithSessionID(stream, s.sessionID), nil
+		}
+		logger.Notice("WebRTC session with P2SP peer broken:%v, fallback to server relay.", err)
+		s.p2pSession.Close()
+		s.p2pSession = nil
+		if nil == s.muxSession {
+			return nil, err
+		}
+		go s.tryP2PSession(s.muxSession)
+	}
+	stream, err := s.muxSession.OpenStream()
+	return mux.WithSessionID(stream, s.sessionID), err
 }
 
 func (s *muxSessionHolder) heartbeat(interval int) {
@@ -105,7 +271,15 @@ func (s *muxSessionHolder) heartbeat(interval int) {
 			s.sessionMutex.Unlock()
 			if nil != session {
 				if s.Channel.Features().Pingable {
-					_, err := session.Ping()
+					rtt, err := session.Ping()
+					if nil == err {
+						s.health.onPing(rtt)
+						s.sessionMutex.Lock()
+						s.pingTime = time.Now()
+						s.sessionMutex.Unlock()
+					} else {
+						s.health.onPingLost()
+					}
 					if err != nil {
 						logger.Error("[ERR]: Ping remote:%s failed: %v", s.server, err)
 						s.close()
@@ -132,13 +306,38 @@ func (s *muxSessionHolder) init(lock bool) error {
 	if nil != s.muxSession {
 		return nil
 	}
+	startP2SPRelay(s.conf)
+	startRendezvousWatcher(s.conf)
 	session, err := s.Channel.CreateMuxSession(s.server, s.conf)
+	if nil != err && len(s.conf.P2SPRoom) > 0 {
+		if relaySession, relayErr := createRelayMuxSession(s.conf); nil == relayErr {
+			session, err = relaySession, nil
+		} else {
+			logger.Debug("No P2SP relay used for reason:%v", relayErr)
+		}
+	}
+	if nil != err && nil == s.p2pSession && s.conf.P2PWebRTC && len(s.conf.Rendezvous.URL) > 0 && len(s.conf.P2SPRoom) > 0 {
+		//server is not reachable at all, try to reach room peer directly
+		go s.tryRendezvousP2PSession()
+	}
 	if nil == err && nil != session {
+		maxFrameSize := s.conf.maxFrameSize()
+		if 0 == maxFrameSize && len(s.conf.MaxFrameSize) == 0 {
+			maxFrameSize = getFingerprintProfile().MaxFrameSize
+		}
+		padding := atomic.LoadInt32(&s.conf.padding) != 0
+		if padding {
+			maxFrameSize = paddedFrameSize()
+		}
+		if psession, ok := session.(*mux.ProxyMuxSession); ok {
+			psession.MaxFrameSize = maxFrameSize
+		}
 		authStream, err := session.OpenStream()
 		if nil != err {
 			return err
 		}
 		counter := uint64(helper.RandBetween(0, math.MaxInt32))
+		sessionID := helper.RandHexString(16)
 		cipherMethod := s.conf.Cipher.Method
 		if strings.HasPrefix(s.server, "https://") || strings.HasPrefix(s.server, "wss://") || strings.HasPrefix(s.server, "tls://") || strings.HasPrefix(s.server, "quic://") || strings.HasPrefix(s.server, "http2://") {
 			cipherMethod = "none"
@@ -149,17 +348,53 @@ func (s *muxSessionHolder) init(lock bool) error {
 			CipherMethod:   cipherMethod,
 			CompressMethod: s.conf.Compressor,
 			P2SPRoomId:     s.conf.P2SPRoom,
+			SessionID:      sessionID,
+			Version:        Version,
+			ProtocolLevel:  mux.ProtocolLevel,
+			MaxFrameSize:   maxFrameSize,
+			StreamChecksum: s.conf.StreamChecksum,
+			Credential:     s.conf.Cipher.Credential,
+		}
+		if padding {
+			paddedAuth(authReq)
+		}
+		if len(s.conf.Cipher.TOTPSecret) > 0 {
+			authReq.TOTP, err = helper.TOTPCode(s.conf.Cipher.TOTPSecret, time.Now())
+			if nil != err {
+				logger.Error("[ERROR]Invalid TOTP secret:%v", err)
+			}
 		}
 		if len(s.conf.P2SPRoom) > 0 {
 			authReq.P2SPConnId = p2spConnID
+			authReq.P2SPRelayAddr = p2spRelayAdvertiseAddr(s.conf)
+		}
+		sessionKey := ""
+		if len(s.conf.Cipher.UserKey) > 0 {
+			authReq.Nonce = helper.RandHexString(16)
+			authReq.KeyProof = userKeyProof(s.conf.Cipher.UserKey, authReq.Nonce)
+			sessionKey = userSessionKey(s.conf.Cipher.UserKey, authReq.Nonce)
 		}
 		err = authStream.Auth(authReq)
 		authStream.Close()
 		if nil != err {
+			if authErr, ok := err.(*mux.AuthError); ok {
+				logger.Error("[ERROR]Remote:%s rejected auth with code:%d for reason:%s", s.server, authErr.Code, authErr.Reason)
+			}
+			session.Close()
 			return err
 		}
+		//streams of ssh channels are relayed locally & always support the current level
+		serverLevel := mux.ProtocolLevel
+		if ar, ok := authStream.(interface {
+			AuthResponse() *mux.AuthResponse
+		}); ok {
+			serverLevel = 0
+			if res := ar.AuthResponse(); nil != res {
+				serverLevel = res.ProtocolLevel
+			}
+		}
 		if psession, ok := session.(*mux.ProxyMuxSession); ok {
-			err = psession.Session.ResetCryptoContext(cipherMethod, counter)
+			err = psession.ResetCryptoContextWithKey(sessionKey, cipherMethod, counter)
 			if nil != err {
 				logger.Error("[ERROR]Failed to reset cipher context with reason:%v, while cipher method:%s", err, cipherMethod)
 				return err
@@ -167,6 +402,8 @@ func (s *muxSessionHolder) init(lock bool) error {
 		}
 		s.creatTime = time.Now()
 		s.muxSession = session
+		s.sessionID = sessionID
+		logger.Info("Session:%s established to %s", sessionID, s.server)
 		features := s.Channel.Features()
 		if features.AutoExpire {
 			expireAfter := 1800
@@ -177,11 +414,31 @@ func (s *muxSessionHolder) init(lock bool) error {
 			s.expireTime = time.Now().Add(time.Duration(expireAfter) * time.Second)
 		}
 		if features.Pingable && s.conf.HeartBeatPeriod > 0 {
-			go s.heartbeat(s.conf.HeartBeatPeriod)
+			go s.heartbeat(s.conf.HeartBeatPeriod + getFingerprintProfile().HeartBeatOffsetSecs)
+		}
+		if features.Pingable && s.conf.KeepAlive.IntervalSecs > 0 {
+			s.keepaliveOnce.Do(func() {
+				go s.keepalive()
+			})
+		}
+		if len(s.conf.P2SPRoom) > 0 && s.conf.P2PWebRTC {
+			go s.tryP2PSession(session)
+		}
+		if len(s.conf.P2SPRoom) > 0 {
+			go fetchP2SPRelays(session, s.conf.P2SPRoom)
 		}
 		if DirectChannelName != s.conf.Name {
-			if len(defaultProxyLimitConfig.BlackList) > 0 || len(defaultProxyLimitConfig.WhiteList) > 0 {
+			sessionProtocolLevels.Store(sessionID, serverLevel)
+			go s.watchControl(session, sessionID)
+		}
+		if DirectChannelName != s.conf.Name {
+			for _, reverse := range s.conf.Reverse {
+				go registerReverse(session, sessionID, s.server, reverse)
+			}
+			if limit := defaultProxyLimit(); len(limit.BlackList) > 0 || len(limit.WhiteList) > 0 {
 				go ServProxyMuxSession(session, authReq)
+			} else if len(s.conf.Reverse) > 0 {
+				go servReverseMuxSession(session, authReq)
 			}
 		}
 
@@ -201,6 +458,10 @@ type LocalProxyChannel struct {
 	sessions       map[*muxSessionHolder]bool
 	lastActiveTime time.Time
 	autoExpire     bool
+	sticky         stickyTable
+	//unix nano since all sessions failed to open streams, 0 if up
+	downSince int64
+	canary    canaryState
 }
 
 func (ch *LocalProxyChannel) createMuxSessionByProxy(p LocalChannel, server string, init bool) (*muxSessionHolder, error) {
@@ -209,6 +470,7 @@ func (ch *LocalProxyChannel) createMuxSessionByProxy(p LocalChannel, server stri
 		Channel:         p,
 		server:          server,
 		retiredSessions: make(map[mux.MuxSession]bool),
+		controlStreams:  make(map[mux.MuxSession]mux.MuxStream),
 	}
 	var err error
 	if init {
@@ -222,8 +484,15 @@ func (ch *LocalProxyChannel) createMuxSessionByProxy(p LocalChannel, server stri
 	return nil, err
 }
 
-func (ch *LocalProxyChannel) getMuxStream() (stream mux.MuxStream, err error) {
-	for holder := range ch.sessions {
+// getMuxStream open stream on sessions in the order of select strategy, 'host' is the destination for sticky selection
+func (ch *LocalProxyChannel) getMuxStream(host string) (stream mux.MuxStream, err error) {
+	defer func() {
+		ch.onStreamResult(err)
+	}()
+	if ch.Conf.Bonding.Enable {
+		return ch.getBondingMuxStream()
+	}
+	for _, holder := range ch.selectSessions(host) {
 		stream, err = holder.getNewStream()
 		if nil != err {
 			if err == pmux.ErrSessionShutdown {
@@ -231,6 +500,7 @@ func (ch *LocalProxyChannel) getMuxStream() (stream mux.MuxStream, err error) {
 			}
 			logger.Debug("Try to get next session since current session failed to open new stream with err:%v", err)
 		} else {
+			ch.onSessionSelected(holder, host)
 			if ch.autoExpire {
 				ch.lastActiveTime = time.Now()
 			}
@@ -260,13 +530,19 @@ func (ch *LocalProxyChannel) Init(lock bool) bool {
 			v := reflect.New(t)
 			p := v.Interface().(LocalChannel)
 			for i := 0; i < conf.ConnsPerServer; i++ {
-				_, err := ch.createMuxSessionByProxy(p, server, i == 0)
+				holder, err := ch.createMuxSessionByProxy(p, server, i == 0 && !conf.lazyConnect)
 				if nil != err {
 					logger.Error("[ERROR]Failed to create mux session for %s:%d with reason:%v", server, i, err)
 					break
 				} else {
 					success = true
 				}
+				if !conf.lazyConnect && i < conf.Pool.MinSessions {
+					holder.prewarm = true
+					if i > 0 {
+						go holder.init(true)
+					}
+				}
 			}
 		}
 	}
@@ -278,6 +554,17 @@ func (ch *LocalProxyChannel) Init(lock bool) bool {
 			defer localChannelMutex.Unlock()
 		}
 		localChannelTable[conf.Name] = ch
+		if conf.HealthCheck.Enable {
+			for holder := range ch.sessions {
+				go ch.healthCheck(holder)
+			}
+		}
+		if len(conf.Canary.URL) > 0 {
+			go ch.canaryCheck()
+		}
+		if !conf.lazyConnect && conf.Name != DirectChannelName {
+			watchNetworkChange()
+		}
 
 	} else {
 		logger.Error("[ERROR]Proxy channel:%s init failed", conf.Name)
@@ -297,6 +584,83 @@ func DumpLoaclChannelStat(w io.Writer) {
 	}
 }
 
+// SessionStat is the state of the session holder to one server
+type SessionStat struct {
+	Server    string
+	SessionID string
+	Connected bool
+	P2P       bool
+	Healthy   bool
+	Streams   int
+	Fails     int
+	RTTMillis int64
+	//smoothed ratio of lost heartbeats & probes
+	Loss float64
+	//pushed by quota limited server
+	Quota *mux.QuotaStatus `json:",omitempty"`
+}
+
+// ChannelStat is the state of a local proxy channel
+type ChannelStat struct {
+	Name     string
+	Sessions []SessionStat
+	Canary   *CanaryStatus `json:",omitempty"`
+}
+
+func (s *muxSessionHolder) stat() SessionStat {
+	s.sessionMutex.Lock()
+	st := SessionStat{
+		Server:    s.server,
+		SessionID: s.sessionID,
+		Connected: nil != s.muxSession,
+		P2P:       nil != s.p2pSession,
+	}
+	if nil != s.muxSession {
+		st.Streams = s.muxSession.NumStreams()
+	}
+	st.Quota = s.quota
+	s.sessionMutex.Unlock()
+	s.health.mutex.Lock()
+	st.Fails = s.health.fails
+	st.Healthy = !s.health.unhealthy
+	st.RTTMillis = int64(s.health.rtt / time.Millisecond)
+	st.Loss = s.health.loss
+	s.health.mutex.Unlock()
+	return st
+}
+
+// LocalChannelStats return the state of all local proxy channels except direct
+func LocalChannelStats() []ChannelStat {
+	localChannelMutex.Lock()
+	defer localChannelMutex.Unlock()
+	var stats []ChannelStat
+	for _, pch := range localChannelTable {
+		if pch.Conf.Name == DirectChannelName {
+			continue
+		}
+		st := ChannelStat{Name: pch.Conf.Name}
+		if len(pch.Conf.Canary.URL) > 0 {
+			pch.canary.mutex.Lock()
+			canary := pch.canary.status
+			pch.canary.mutex.Unlock()
+			st.Canary = &canary
+		}
+		for holder := range pch.sessions {
+			if nil != holder {
+				st.Sessions = append(st.Sessions, holder.stat())
+			}
+		}
+		sort.Slice(st.Sessions, func(i, j int) bool {
+			return st.Sessions[i].Server < st.Sessions[j].Server
+		})
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
 func NewProxyChannel(conf *ProxyChannelConfig) *LocalProxyChannel {
 	channel := &LocalProxyChannel{
 		Conf:     *conf,
@@ -306,11 +670,16 @@ func NewProxyChannel(conf *ProxyChannelConfig) *LocalProxyChannel {
 }
 
 func GetMuxStreamByChannel(name string) (mux.MuxStream, *ProxyChannelConfig, error) {
+	return GetMuxStreamByChannelForHost(name, "")
+}
+
+// GetMuxStreamByChannelForHost open stream to the destination host by the channel's select strategy
+func GetMuxStreamByChannelForHost(name string, host string) (mux.MuxStream, *ProxyChannelConfig, error) {
 	pch, exist := localChannelTable[name]
 	if !exist {
 		return nil, nil, fmt.Errorf("No proxy found to get mux session")
 	}
-	stream, err := pch.getMuxStream()
+	stream, err := pch.getMuxStream(host)
 	return stream, &pch.Conf, err
 }
 
@@ -349,7 +718,7 @@ func GetMuxStreamByURL(u *url.URL, defaultUser string, defaultCipher *CipherConf
 	ch := NewProxyChannel(conf)
 	if ch.Init(false) {
 		ch.autoExpire = true
-		stream, err := ch.getMuxStream()
+		stream, err := ch.getMuxStream("")
 		expireLocalChannels()
 		return stream, conf, err
 	}
@@ -390,7 +759,10 @@ func expireLocalChannels() {
 					expire = false
 					break
 				}
-				if nil != session.muxSession && session.muxSession.NumStreams() > 0 {
+				session.sessionMutex.Lock()
+				active := nil != session.muxSession && session.activeStreams(session.muxSession) > 0
+				session.sessionMutex.Unlock()
+				if active {
 					expire = false
 					break
 				}
@@ -0,0 +1,240 @@
+package channel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/rendezvous"
+)
+
+// RendezvousConfig exchange webrtc signals via third-party infrastructure when server is unreachable
+type RendezvousConfig struct {
+	//like 'mqtt://broker.hivemq.com:1883/prefix', 'gist://<id>?token=xxx', 'dns://rdv.example.com?zone=<id>&token=xxx'
+	URL string
+	//secret shared by room peers to seal signals, default the cipher key
+	Secret string
+	//interval to poll offers from peers, default 5
+	PollSecs int
+}
+
+const rendezvousOfferTTL = 60 * time.Second
+
+// offers are published in slots by peer so that concurrent offers from peers rarely overwrite each other,
+// answers are keyed by the offer id
+const rendezvousOfferSlots = 4
+
+func rendezvousOfferKey(peer string) string {
+	sum := sha256.Sum256([]byte(peer))
+	return fmt.Sprintf("offer-%d", int(sum[0])%rendezvousOfferSlots)
+}
+
+type rendezvousRecord struct {
+	ID         string
+	From       string
+	Time       int64
+	Compressor string
+	Signal     p2pSignalMessage
+}
+
+type rendezvousRoom struct {
+	backend rendezvous.Backend
+	aead    cipher.AEAD
+	prefix  string
+}
+
+func newRendezvousRoom(conf *ProxyChannelConfig) (*rendezvousRoom, error) {
+	backend, err := rendezvous.New(conf.Rendezvous.URL)
+	if nil != err {
+		return nil, err
+	}
+	secret := conf.Rendezvous.Secret
+	if len(secret) == 0 {
+		secret = conf.Cipher.Key
+	}
+	key := sha256.Sum256([]byte("gsnova-rendezvous:" + secret + ":" + conf.P2SPRoom))
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	//keys on the public backend should not expose the room name
+	id := sha256.Sum256(key[:])
+	return &rendezvousRoom{backend: backend, aead: aead, prefix: hex.EncodeToString(id[:8])}, nil
+}
+
+func (r *rendezvousRoom) seal(rec *rendezvousRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	json.NewEncoder(zw).Encode(rec)
+	zw.Close()
+	nonce := make([]byte, r.aead.NonceSize())
+	rand.Read(nonce)
+	sealed := r.aead.Seal(nonce, nonce, buf.Bytes(), nil)
+	return []byte(base64.RawURLEncoding.EncodeToString(sealed)), nil
+}
+
+func (r *rendezvousRoom) open(v []byte) (*rendezvousRecord, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(string(v))
+	if nil != err {
+		return nil, err
+	}
+	if len(sealed) < r.aead.NonceSize() {
+		return nil, errors.New("invalid rendezvous record")
+	}
+	nonce := sealed[:r.aead.NonceSize()]
+	plain, err := r.aead.Open(nil, nonce, sealed[r.aead.NonceSize():], nil)
+	if nil != err {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if nil != err {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(zr)
+	if nil != err {
+		return nil, err
+	}
+	var rec rendezvousRecord
+	err = json.Unmarshal(data, &rec)
+	return &rec, err
+}
+
+func (r *rendezvousRoom) put(key string, rec *rendezvousRecord) error {
+	v, err := r.seal(rec)
+	if nil != err {
+		return err
+	}
+	return r.backend.Put(r.prefix+"-"+key, v)
+}
+
+func (r *rendezvousRoom) get(key string) (*rendezvousRecord, error) {
+	v, err := r.backend.Get(r.prefix + "-" + key)
+	if nil != err || len(v) == 0 {
+		return nil, err
+	}
+	return r.open(v)
+}
+
+// dialRendezvousP2PSession publish the offer on rendezvous backend and wait the answer from room peer
+func dialRendezvousP2PSession(conf *ProxyChannelConfig) (mux.MuxSession, error) {
+	room, err := newRendezvousRoom(conf)
+	if nil != err {
+		return nil, err
+	}
+	return dialP2P(conf.ICEServers, func(offer *p2pSignalMessage) (*p2pSignalMessage, error) {
+		rec := &rendezvousRecord{
+			ID:         helper.RandHexString(16),
+			From:       p2spConnID,
+			Time:       time.Now().Unix(),
+			Compressor: conf.Compressor,
+			Signal:     *offer,
+		}
+		if err := room.put(rendezvousOfferKey(p2spConnID), rec); nil != err {
+			return nil, err
+		}
+		deadline := time.Now().Add(rendezvousOfferTTL)
+		wait := time.Second
+		for time.Now().Before(deadline) {
+			time.Sleep(wait)
+			answer, err := room.get("answer-" + rec.ID)
+			if nil == err && nil != answer && answer.ID == rec.ID {
+				return &answer.Signal, nil
+			}
+			//answer needs a poll interval of the peer at least, back off to save backend requests
+			if wait < 5*time.Second {
+				wait += wait / 2
+			}
+		}
+		return nil, errP2PTimeout
+	})
+}
+
+var rendezvousWatchers = make(map[string]bool)
+var rendezvousWatcherMutex sync.Mutex
+
+// startRendezvousWatcher answer offers published by room peers on rendezvous backend
+func startRendezvousWatcher(conf *ProxyChannelConfig) {
+	if len(conf.Rendezvous.URL) == 0 || len(conf.P2SPRoom) == 0 || !conf.P2PWebRTC {
+		return
+	}
+	rendezvousWatcherMutex.Lock()
+	defer rendezvousWatcherMutex.Unlock()
+	if rendezvousWatchers[conf.Name] {
+		return
+	}
+	rendezvousWatchers[conf.Name] = true
+	interval := conf.Rendezvous.PollSecs
+	if interval <= 0 {
+		interval = 5
+	}
+	go func() {
+		var room *rendezvousRoom
+		lastOfferIDs := make(map[string]string)
+		//poll interval backs off up to 5 times while no offer, still below the offer ttl by default
+		idle := 0
+		for {
+			backoff := idle
+			if backoff > 4 {
+				backoff = 4
+			}
+			time.Sleep(time.Duration(interval*(1+backoff)) * time.Second)
+			var err error
+			if nil == room {
+				if room, err = newRendezvousRoom(conf); nil != err {
+					logger.Error("[ERROR]Failed to init rendezvous:%s with reason:%v", conf.Rendezvous.URL, err)
+					continue
+				}
+			}
+			idle++
+			for slot := 0; slot < rendezvousOfferSlots; slot++ {
+				key := fmt.Sprintf("offer-%d", slot)
+				offer, err := room.get(key)
+				if nil != err || nil == offer || offer.ID == lastOfferIDs[key] || offer.From == p2spConnID {
+					continue
+				}
+				lastOfferIDs[key] = offer.ID
+				if time.Now().Sub(time.Unix(offer.Time, 0)) > rendezvousOfferTTL {
+					continue
+				}
+				idle = 0
+				logger.Notice("Recv webrtc offer from P2SP peer via rendezvous in room:%s", conf.P2SPRoom)
+				go answerRendezvousOffer(room, conf, offer)
+			}
+		}
+	}()
+}
+
+func answerRendezvousOffer(room *rendezvousRoom, conf *ProxyChannelConfig, offer *rendezvousRecord) {
+	session, err := acceptP2POffer(&offer.Signal, conf.ICEServers, func(answer *p2pSignalMessage) error {
+		return room.put("answer-"+offer.ID, &rendezvousRecord{
+			ID:     offer.ID,
+			From:   p2spConnID,
+			Time:   time.Now().Unix(),
+			Signal: *answer,
+		})
+	})
+	if nil != err {
+		logger.Error("[ERROR]Failed to establish webrtc session with peer via rendezvous:%v", err)
+		return
+	}
+	logger.Notice("WebRTC direct session established with P2SP peer via rendezvous.")
+	auth := &mux.AuthRequest{
+		User:           conf.Cipher.User,
+		CompressMethod: offer.Compressor,
+		P2SPRoomId:     conf.P2SPRoom,
+		SessionID:      offer.ID,
+	}
+	go ServProxyMuxSession(session, auth)
+}
@@ -0,0 +1,117 @@
+package channel
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/rendezvous"
+)
+
+// memBackend keep values in memory, same host share one map
+type memBackend struct {
+	values map[string][]byte
+	mutex  sync.Mutex
+}
+
+func (m *memBackend) Put(key string, value []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *memBackend) Get(key string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.values[key], nil
+}
+
+var memBackends = make(map[string]*memBackend)
+
+func init() {
+	rendezvous.Register("mem", func(u *url.URL) (rendezvous.Backend, error) {
+		b, exist := memBackends[u.Host]
+		if !exist {
+			b = &memBackend{values: make(map[string][]byte)}
+			memBackends[u.Host] = b
+		}
+		return b, nil
+	})
+}
+
+func TestRendezvousOfferKey(t *testing.T) {
+	slots := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		peer := string(rune('a'+i%26)) + strings.Repeat("x", i)
+		key := rendezvousOfferKey(peer)
+		if key != rendezvousOfferKey(peer) {
+			t.Fatalf("expect stable offer key of peer %s", peer)
+		}
+		slots[key] = true
+	}
+	if len(slots) != rendezvousOfferSlots {
+		t.Errorf("expect offers spread over %d slots, but got %v", rendezvousOfferSlots, slots)
+	}
+	for slot := range slots {
+		if !strings.HasPrefix(slot, "offer-") {
+			t.Errorf("unexpected offer key:%s", slot)
+		}
+	}
+}
+
+func TestRendezvousRoomSealRecords(t *testing.T) {
+	conf := &ProxyChannelConfig{P2SPRoom: "secret-room", Rendezvous: RendezvousConfig{URL: "mem://seal"}}
+	conf.Cipher.Key = "channel-key"
+	room, err := newRendezvousRoom(conf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if rec, err := room.get("offer-0"); nil != err || nil != rec {
+		t.Fatalf("expect nil record of empty slot, but got %v %v", rec, err)
+	}
+	rec := &rendezvousRecord{ID: "id1", From: "peer1", Time: 100, Compressor: "snappy", Signal: p2pSignalMessage{Type: "offer", SDP: "v=0"}}
+	if err = room.put("offer-0", rec); nil != err {
+		t.Fatal(err)
+	}
+	got, err := room.get("offer-0")
+	if nil != err || *got != *rec {
+		t.Fatalf("expect record %+v, but got %+v %v", rec, got, err)
+	}
+	//neither key nor value on the backend expose the room or signal
+	for key, value := range memBackends["seal"].values {
+		if !strings.HasPrefix(key, room.prefix+"-") || strings.Contains(key, "secret-room") {
+			t.Errorf("unexpected backend key:%s", key)
+		}
+		if strings.Contains(string(value), "v=0") || strings.Contains(string(value), "peer1") {
+			t.Errorf("expect sealed backend value, but got %s", value)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		room   string
+		secret string
+		key    string
+		open   bool
+	}{
+		{"same room", "secret-room", "", "channel-key", true},
+		{"secret overriding key", "secret-room", "channel-key", "other-key", true},
+		{"other secret", "secret-room", "other-secret", "channel-key", false},
+		{"other key", "secret-room", "", "other-key", false},
+		{"other room", "other-room", "", "channel-key", false},
+	}
+	v, _ := memBackends["seal"].Get(room.prefix + "-offer-0")
+	for _, tt := range tests {
+		c := &ProxyChannelConfig{P2SPRoom: tt.room, Rendezvous: RendezvousConfig{URL: "mem://seal", Secret: tt.secret}}
+		c.Cipher.Key = tt.key
+		peer, _ := newRendezvousRoom(c)
+		if _, err := peer.open(v); (nil == err) != tt.open {
+			t.Errorf("%s: expect open:%v, but got err:%v", tt.name, tt.open, err)
+		}
+	}
+	if _, err = room.open([]byte("AAAA")); nil == err {
+		t.Errorf("expect error for truncated record")
+	}
+}
@@ -0,0 +1,286 @@
+package channel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+)
+
+const (
+	p2pDataChannelLabel = "gsnova"
+	p2pConnectTimeout   = 15 * time.Second
+	//max payload of one data channel message
+	p2pMaxMessageSize = 16 * 1024
+)
+
+var errP2PTimeout = errors.New("webrtc peer connect timeout")
+
+var defaultICEServers = []string{"stun:stun.l.google.com:19302"}
+
+type p2pSignalMessage struct {
+	Type string
+	SDP  string
+}
+
+type p2pAddr struct{}
+
+func (p2pAddr) Network() string {
+	return "webrtc"
+}
+func (p2pAddr) String() string {
+	return "webrtc"
+}
+
+// dataChannelConn adapt a detached webrtc data channel to net.Conn for pmux
+type dataChannelConn struct {
+	rw      io.ReadWriteCloser
+	pc      *webrtc.PeerConnection
+	readBuf []byte
+	pending []byte
+	once    sync.Once
+}
+
+func newDataChannelConn(rw io.ReadWriteCloser, pc *webrtc.PeerConnection) *dataChannelConn {
+	return &dataChannelConn{
+		rw:      rw,
+		pc:      pc,
+		readBuf: make([]byte, 65536),
+	}
+}
+
+func (c *dataChannelConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		n, err := c.rw.Read(c.readBuf)
+		if nil != err {
+			return 0, err
+		}
+		c.pending = c.readBuf[0:n]
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *dataChannelConn) Write(b []byte) (int, error) {
+	total := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > p2pMaxMessageSize {
+			chunk = chunk[0:p2pMaxMessageSize]
+		}
+		n, err := c.rw.Write(chunk)
+		total += n
+		if nil != err {
+			return total, err
+		}
+		b = b[len(chunk):]
+	}
+	return total, nil
+}
+
+func (c *dataChannelConn) Close() error {
+	c.once.Do(func() {
+		c.rw.Close()
+		c.pc.Close()
+	})
+	return nil
+}
+
+func (c *dataChannelConn) LocalAddr() net.Addr {
+	return p2pAddr{}
+}
+func (c *dataChannelConn) RemoteAddr() net.Addr {
+	return p2pAddr{}
+}
+func (c *dataChannelConn) SetDeadline(t time.Time) error {
+	return nil
+}
+func (c *dataChannelConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+func (c *dataChannelConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func newP2PPeerConnection(iceServers []string) (*webrtc.PeerConnection, error) {
+	if len(iceServers) == 0 {
+		iceServers = defaultICEServers
+	}
+	s := webrtc.SettingEngine{}
+	s.DetachDataChannels()
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(s))
+	return api.NewPeerConnection(webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{URLs: iceServers}},
+	})
+}
+
+// p2pMuxConfig disable mux cipher since data channel is already protected by DTLS
+func p2pMuxConfig() *pmux.Config {
+	cfg := defaultMuxConfig.ToPMuxConf()
+	cfg.CipherMethod = pmux.CipherNone
+	return cfg
+}
+
+// waitDataChannel wait the data channel open or the ice connection failed
+func waitDataChannel(pc *webrtc.PeerConnection, dcCh chan io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	failCh := make(chan error, 1)
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		logger.Debug("WebRTC ICE connection state changed to %s", state.String())
+		if state == webrtc.ICEConnectionStateFailed || state == webrtc.ICEConnectionStateClosed {
+			select {
+			case failCh <- errors.New("webrtc ice connection " + state.String()):
+			default:
+			}
+		}
+	})
+	select {
+	case rw := <-dcCh:
+		return rw, nil
+	case err := <-failCh:
+		return nil, err
+	case <-time.After(p2pConnectTimeout):
+		return nil, errP2PTimeout
+	}
+}
+
+func onDataChannelOpen(dc *webrtc.DataChannel, dcCh chan io.ReadWriteCloser) {
+	dc.OnOpen(func() {
+		rw, err := dc.Detach()
+		if nil != err {
+			logger.Error("[ERROR]Failed to detach webrtc data channel:%v", err)
+			return
+		}
+		dcCh <- rw
+	})
+}
+
+// dialP2P negotiate a direct webrtc session, 'exchange' deliver the offer to peer and return its answer.
+func dialP2P(iceServers []string, exchange func(offer *p2pSignalMessage) (*p2pSignalMessage, error)) (mux.MuxSession, error) {
+	pc, err := newP2PPeerConnection(iceServers)
+	if nil != err {
+		return nil, err
+	}
+	dc, err := pc.CreateDataChannel(p2pDataChannelLabel, nil)
+	if nil != err {
+		pc.Close()
+		return nil, err
+	}
+	dcCh := make(chan io.ReadWriteCloser, 1)
+	onDataChannelOpen(dc, dcCh)
+	offer, err := pc.CreateOffer(nil)
+	if nil == err {
+		gatherComplete := webrtc.GatheringCompletePromise(pc)
+		if err = pc.SetLocalDescription(offer); nil == err {
+			<-gatherComplete
+		}
+	}
+	var answer *p2pSignalMessage
+	if nil == err {
+		answer, err = exchange(&p2pSignalMessage{Type: "offer", SDP: pc.LocalDescription().SDP})
+	}
+	if nil == err {
+		err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP})
+	}
+	var rw io.ReadWriteCloser
+	if nil == err {
+		rw, err = waitDataChannel(pc, dcCh)
+	}
+	if nil != err {
+		pc.Close()
+		return nil, err
+	}
+	session, err := pmux.Client(newDataChannelConn(rw, pc), p2pMuxConfig())
+	if nil != err {
+		pc.Close()
+		return nil, err
+	}
+	return &mux.ProxyMuxSession{Session: session}, nil
+}
+
+// dialP2PSession negotiate a direct webrtc session with the peer, the signal stream is relayed by the server.
+func dialP2PSession(signal mux.MuxStream, iceServers []string) (mux.MuxSession, error) {
+	defer signal.Close()
+	err := signal.Connect(mux.P2PSignalNetwork, "", mux.StreamOptions{})
+	if nil != err {
+		return nil, err
+	}
+	return dialP2P(iceServers, func(offer *p2pSignalMessage) (*p2pSignalMessage, error) {
+		if err := mux.WriteMessage(signal, offer); nil != err {
+			return nil, err
+		}
+		var answer p2pSignalMessage
+		signal.SetReadDeadline(time.Now().Add(p2pConnectTimeout))
+		err := mux.ReadMessage(signal, &answer)
+		return &answer, err
+	})
+}
+
+// acceptP2POffer answer the offer by 'reply' and return the direct session served as the remote side.
+func acceptP2POffer(offer *p2pSignalMessage, iceServers []string, reply func(answer *p2pSignalMessage) error) (mux.MuxSession, error) {
+	pc, err := newP2PPeerConnection(iceServers)
+	if nil != err {
+		return nil, err
+	}
+	dcCh := make(chan io.ReadWriteCloser, 1)
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() == p2pDataChannelLabel {
+			onDataChannelOpen(dc, dcCh)
+		}
+	})
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP})
+	var answer webrtc.SessionDescription
+	if nil == err {
+		answer, err = pc.CreateAnswer(nil)
+	}
+	if nil == err {
+		gatherComplete := webrtc.GatheringCompletePromise(pc)
+		if err = pc.SetLocalDescription(answer); nil == err {
+			<-gatherComplete
+		}
+	}
+	if nil == err {
+		err = reply(&p2pSignalMessage{Type: "answer", SDP: pc.LocalDescription().SDP})
+	}
+	var rw io.ReadWriteCloser
+	if nil == err {
+		rw, err = waitDataChannel(pc, dcCh)
+	}
+	if nil != err {
+		pc.Close()
+		return nil, err
+	}
+	session, err := pmux.Server(newDataChannelConn(rw, pc), p2pMuxConfig())
+	if nil != err {
+		pc.Close()
+		return nil, err
+	}
+	return &mux.ProxyMuxSession{Session: session}, nil
+}
+
+// handleP2PSignalStream answer the webrtc offer from peer, and serve the direct session as the relay server does.
+func handleP2PSignalStream(signal mux.MuxStream, ctx *sessionContext) {
+	defer signal.Close()
+	var offer p2pSignalMessage
+	signal.SetReadDeadline(time.Now().Add(p2pConnectTimeout))
+	err := mux.ReadMessage(signal, &offer)
+	if nil != err {
+		logger.Error("[ERROR]Failed to read webrtc offer:%v", err)
+		return
+	}
+	session, err := acceptP2POffer(&offer, nil, func(answer *p2pSignalMessage) error {
+		return mux.WriteMessage(signal, answer)
+	})
+	if nil != err {
+		logger.Error("[ERROR]Failed to establish webrtc session with peer:%v", err)
+		return
+	}
+	logger.Notice("WebRTC direct session established with P2SP peer.")
+	go ServProxyMuxSession(session, ctx.auth)
+}
@@ -0,0 +1,206 @@
+package channel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// messageRW record every written message & return queued messages one per read as a data channel does
+type messageRW struct {
+	written [][]byte
+	queued  [][]byte
+	closed  bool
+}
+
+func (m *messageRW) Read(b []byte) (int, error) {
+	if len(m.queued) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, m.queued[0])
+	m.queued = m.queued[1:]
+	return n, nil
+}
+func (m *messageRW) Write(b []byte) (int, error) {
+	m.written = append(m.written, append([]byte(nil), b...))
+	return len(b), nil
+}
+func (m *messageRW) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestDataChannelConnWriteFragments(t *testing.T) {
+	tests := []struct {
+		size   int
+		chunks []int
+	}{
+		{0, nil},
+		{100, []int{100}},
+		{p2pMaxMessageSize, []int{p2pMaxMessageSize}},
+		{p2pMaxMessageSize + 1, []int{p2pMaxMessageSize, 1}},
+		{2*p2pMaxMessageSize + 100, []int{p2pMaxMessageSize, p2pMaxMessageSize, 100}},
+	}
+	for _, tt := range tests {
+		rw := &messageRW{}
+		c := newDataChannelConn(rw, nil)
+		data := bytes.Repeat([]byte{'x'}, tt.size)
+		n, err := c.Write(data)
+		if nil != err || n != tt.size {
+			t.Errorf("write %d bytes got n=%d err=%v", tt.size, n, err)
+			continue
+		}
+		if len(rw.written) != len(tt.chunks) {
+			t.Errorf("write %d bytes expect %d messages, but got %d", tt.size, len(tt.chunks), len(rw.written))
+			continue
+		}
+		for i, chunk := range rw.written {
+			if len(chunk) != tt.chunks[i] {
+				t.Errorf("write %d bytes expect message %d of %d bytes, but got %d", tt.size, i, tt.chunks[i], len(chunk))
+			}
+		}
+	}
+}
+
+func TestDataChannelConnPartialRead(t *testing.T) {
+	rw := &messageRW{queued: [][]byte{[]byte("hello"), []byte("world")}}
+	c := newDataChannelConn(rw, nil)
+	var got []string
+	b := make([]byte, 3)
+	for {
+		n, err := c.Read(b)
+		if nil != err {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		got = append(got, string(b[0:n]))
+	}
+	//one message is never merged with the next one in a read
+	expect := []string{"hel", "lo", "wor", "ld"}
+	if len(got) != len(expect) {
+		t.Fatalf("expect reads %q, but got %q", expect, got)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Fatalf("expect reads %q, but got %q", expect, got)
+		}
+	}
+}
+
+func TestDataChannelConnCloseOnce(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if nil != err {
+		t.Fatal(err)
+	}
+	rw := &messageRW{}
+	c := newDataChannelConn(rw, pc)
+	c.Close()
+	c.Close()
+	if !rw.closed {
+		t.Errorf("expect data channel closed")
+	}
+	if pc.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		t.Errorf("expect peer connection closed, but got %s", pc.ConnectionState())
+	}
+}
+
+// startSTUNServer answer binding requests with the mapped address, so that candidates gathering completes at once
+func startSTUNServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if nil != err {
+				return
+			}
+			req := &stun.Message{Raw: append([]byte(nil), buf[0:n]...)}
+			if nil != req.Decode() || req.Type != stun.BindingRequest {
+				continue
+			}
+			udpAddr := addr.(*net.UDPAddr)
+			res, err := stun.Build(stun.NewTransactionIDSetter(req.TransactionID), stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port}, stun.Fingerprint)
+			if nil == err {
+				conn.WriteTo(res.Raw, addr)
+			}
+		}
+	}()
+	return conn
+}
+
+func TestP2PSessionOverLoopback(t *testing.T) {
+	stunServer := startSTUNServer(t)
+	defer stunServer.Close()
+	iceServers := []string{"stun:" + stunServer.LocalAddr().String()}
+	accepted := make(chan error, 1)
+	remoteCh := make(chan mux.MuxSession, 1)
+	local, err := dialP2P(iceServers, func(offer *p2pSignalMessage) (*p2pSignalMessage, error) {
+		if offer.Type != "offer" || len(offer.SDP) == 0 {
+			t.Errorf("unexpected offer:%+v", offer)
+		}
+		answerCh := make(chan *p2pSignalMessage, 1)
+		go func() {
+			session, err := acceptP2POffer(offer, iceServers, func(answer *p2pSignalMessage) error {
+				answerCh <- answer
+				return nil
+			})
+			if nil == err {
+				remoteCh <- session
+			}
+			accepted <- err
+		}()
+		select {
+		case answer := <-answerCh:
+			return answer, nil
+		case <-time.After(p2pConnectTimeout):
+			return nil, errP2PTimeout
+		}
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	if err = <-accepted; nil != err {
+		t.Fatal(err)
+	}
+	remote := <-remoteCh
+	defer remote.Close()
+	go func() {
+		stream, err := remote.AcceptStream()
+		if nil == err {
+			io.Copy(stream, stream)
+			stream.Close()
+		}
+	}()
+
+	stream, err := local.OpenStream()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	//larger than one data channel message
+	data := bytes.Repeat([]byte("0123456789abcdef"), p2pMaxMessageSize/8)
+	if _, err = stream.Write(data); nil != err {
+		t.Fatal(err)
+	}
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	echo := make([]byte, len(data))
+	if _, err = io.ReadFull(stream, echo); nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, echo) {
+		t.Errorf("echo mismatch over webrtc session")
+	}
+}
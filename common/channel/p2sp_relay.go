@@ -0,0 +1,213 @@
+package channel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/ratelimit"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// P2SPRelayConfig let a P2SP member relay other room members' sessions to the server
+type P2SPRelayConfig struct {
+	//listen address of the relay, empty to disable relaying for others
+	Listen string
+	//address advertised to room members, the host is filled by server if empty
+	Advertise string
+	//'host:port' of the server's tcp listener which relayed bytes go to
+	Upstream string
+	MaxConns int
+	//bandwidth cap per second like '512K' and total bytes cap like '10G' of the relay
+	MaxBandwidth  string
+	MaxTotalBytes string
+	//static relays to try before any relay learned from server
+	Peers []string
+}
+
+var errRelayQuotaExceed = errors.New("relay total bytes quota exceed")
+
+type p2spRelay struct {
+	conf      *ProxyChannelConfig
+	bucket    *ratelimit.Bucket
+	maxTotal  int64
+	conns     int32
+	upBytes   int64
+	downBytes int64
+}
+
+func (r *p2spRelay) total() int64 {
+	return atomic.LoadInt64(&r.upBytes) + atomic.LoadInt64(&r.downBytes)
+}
+
+type relayReader struct {
+	io.Reader
+	relay *p2spRelay
+}
+
+func (r *relayReader) Read(p []byte) (int, error) {
+	if r.relay.maxTotal > 0 && r.relay.total() >= r.relay.maxTotal {
+		return 0, errRelayQuotaExceed
+	}
+	return r.Reader.Read(p)
+}
+
+func (r *p2spRelay) reader(c io.Reader, counter *int64) io.Reader {
+	var reader io.Reader = &relayReader{&countReader{c, counter}, r}
+	if nil != r.bucket {
+		reader = ratelimit.Reader(reader, r.bucket)
+	}
+	return reader
+}
+
+func (r *p2spRelay) serve(conn net.Conn) {
+	defer conn.Close()
+	relayConf := &r.conf.P2SPRelay
+	if relayConf.MaxConns > 0 && atomic.LoadInt32(&r.conns) >= int32(relayConf.MaxConns) {
+		logger.Notice("Reject relay conn from %v since max conns:%d reached.", conn.RemoteAddr(), relayConf.MaxConns)
+		return
+	}
+	if r.maxTotal > 0 && r.total() >= r.maxTotal {
+		logger.Notice("Reject relay conn from %v since total bytes quota exceed.", conn.RemoteAddr())
+		return
+	}
+	atomic.AddInt32(&r.conns, 1)
+	defer atomic.AddInt32(&r.conns, -1)
+	upstream, err := DialServerByConf("tcp://"+relayConf.Upstream, r.conf)
+	if nil != err {
+		logger.Error("[ERROR]Failed to connect relay upstream:%s with reason:%v", relayConf.Upstream, err)
+		return
+	}
+	defer upstream.Close()
+	start := time.Now()
+	up, down := atomic.LoadInt64(&r.upBytes), atomic.LoadInt64(&r.downBytes)
+	closeSig := make(chan bool, 1)
+	go func() {
+		io.Copy(upstream, r.reader(conn, &r.upBytes))
+		upstream.Close()
+		closeSig <- true
+	}()
+	io.Copy(conn, r.reader(upstream, &r.downBytes))
+	conn.Close()
+	<-closeSig
+	logger.Info("Relayed conn from %v for %v with %d/%d bytes up/down, relay total:%d bytes.", conn.RemoteAddr(), time.Now().Sub(start),
+		atomic.LoadInt64(&r.upBytes)-up, atomic.LoadInt64(&r.downBytes)-down, r.total())
+}
+
+var p2spRelays = make(map[string]*p2spRelay)
+var p2spRelayMutex sync.Mutex
+
+// startP2SPRelay start the relay listener of the channel once
+func startP2SPRelay(conf *ProxyChannelConfig) {
+	relayConf := &conf.P2SPRelay
+	if len(relayConf.Listen) == 0 || len(conf.P2SPRoom) == 0 {
+		return
+	}
+	p2spRelayMutex.Lock()
+	defer p2spRelayMutex.Unlock()
+	if _, exist := p2spRelays[relayConf.Listen]; exist {
+		return
+	}
+	if len(relayConf.Upstream) == 0 {
+		logger.Error("[ERROR]P2SP relay disabled since no 'Upstream' configured.")
+		return
+	}
+	r := &p2spRelay{conf: conf}
+	if len(relayConf.MaxBandwidth) > 0 {
+		if v, err := helper.ToBytes(relayConf.MaxBandwidth); nil == err && v > 0 {
+			r.bucket = ratelimit.NewBucketWithRate(float64(v), int64(v))
+		}
+	}
+	if len(relayConf.MaxTotalBytes) > 0 {
+		if v, err := helper.ToBytes(relayConf.MaxTotalBytes); nil == err {
+			r.maxTotal = int64(v)
+		}
+	}
+	lp, err := net.Listen("tcp", relayConf.Listen)
+	if nil != err {
+		logger.Error("[ERROR]Failed to listen P2SP relay address:%s with reason:%v", relayConf.Listen, err)
+		return
+	}
+	p2spRelays[relayConf.Listen] = r
+	logger.Notice("Listen on P2SP relay address:%s for room:%s", relayConf.Listen, conf.P2SPRoom)
+	go func() {
+		for {
+			conn, err := lp.Accept()
+			if nil != err {
+				logger.Error("[ERROR]P2SP relay accept failed:%v", err)
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+}
+
+func p2spRelayAdvertiseAddr(conf *ProxyChannelConfig) string {
+	p2spRelayMutex.Lock()
+	defer p2spRelayMutex.Unlock()
+	if _, exist := p2spRelays[conf.P2SPRelay.Listen]; !exist {
+		return ""
+	}
+	if len(conf.P2SPRelay.Advertise) > 0 {
+		return conf.P2SPRelay.Advertise
+	}
+	return conf.P2SPRelay.Listen
+}
+
+var p2spLearnedRelays = make(map[string][]string)
+
+// fetchP2SPRelays learn relays advertised by other room members from server
+func fetchP2SPRelays(session mux.MuxSession, room string) {
+	stream, err := session.OpenStream()
+	if nil != err {
+		return
+	}
+	defer stream.Close()
+	err = stream.Connect(mux.P2SPRelaysNetwork, "", mux.StreamOptions{})
+	if nil != err {
+		return
+	}
+	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var list mux.P2SPRelayList
+	if err = mux.ReadMessage(stream, &list); nil != err {
+		logger.Debug("Failed to fetch P2SP relays for room:%s with reason:%v", room, err)
+		return
+	}
+	p2spRelayMutex.Lock()
+	p2spLearnedRelays[room] = list.Addrs
+	p2spRelayMutex.Unlock()
+	logger.Debug("Learned P2SP relays:%v for room:%s", list.Addrs, room)
+}
+
+// createRelayMuxSession create the session via room members' relays when server is not reachable
+func createRelayMuxSession(conf *ProxyChannelConfig) (mux.MuxSession, error) {
+	if len(conf.Cipher.UserKey) == 0 {
+		//relays know the shared key, only per user key keeps payloads away from them
+		return nil, errors.New("P2SP relay requires 'UserKey' in cipher config")
+	}
+	t, exist := LocalChannelTypeTable["tcp"]
+	if !exist {
+		return nil, errors.New("no tcp channel for P2SP relay")
+	}
+	p2spRelayMutex.Lock()
+	addrs := append(append([]string{}, conf.P2SPRelay.Peers...), p2spLearnedRelays[conf.P2SPRoom]...)
+	p2spRelayMutex.Unlock()
+	ch := reflect.New(t).Interface().(LocalChannel)
+	err := errors.New("no P2SP relay available")
+	for _, addr := range addrs {
+		var session mux.MuxSession
+		session, err = ch.CreateMuxSession("tcp://"+addr, conf)
+		if nil == err {
+			logger.Notice("Session created via P2SP relay:%s", addr)
+			return session, nil
+		}
+		logger.Error("[ERROR]Failed to connect P2SP relay:%s with reason:%v", addr, err)
+	}
+	return nil, err
+}
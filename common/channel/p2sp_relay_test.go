@@ -0,0 +1,131 @@
+package channel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// addrSession is a session with a fixed remote address
+type addrSession struct {
+	mux.MuxSession
+	addr net.Addr
+}
+
+func (s *addrSession) RemoteAddr() net.Addr {
+	return s.addr
+}
+
+func TestP2SPRelayAddr(t *testing.T) {
+	ctx := &sessionContext{session: &addrSession{addr: &net.TCPAddr{IP: net.ParseIP("9.9.9.9"), Port: 1234}}}
+	noAddrCtx := &sessionContext{session: &addrSession{}}
+	tests := []struct {
+		addr   string
+		ctx    *sessionContext
+		expect string
+	}{
+		{"1.2.3.4:100", ctx, "1.2.3.4:100"},
+		{"relay.test:100", ctx, "relay.test:100"},
+		{":100", ctx, "9.9.9.9:100"},
+		{"0.0.0.0:100", ctx, "9.9.9.9:100"},
+		{"[::]:100", ctx, "9.9.9.9:100"},
+		{":100", noAddrCtx, ""},
+		{"1.2.3.4", ctx, ""},
+		{"", ctx, ""},
+	}
+	for _, tt := range tests {
+		if addr := p2spRelayAddr(tt.addr, tt.ctx); addr != tt.expect {
+			t.Errorf("advertised %q expect relay addr %q, but got %q", tt.addr, tt.expect, addr)
+		}
+	}
+}
+
+func TestP2SPRelayTable(t *testing.T) {
+	room := "relay-test-room"
+	s1, s2 := &addrSession{}, &addrSession{}
+	addP2spSession(room, "c1", s1)
+	addP2spSession(room, "c2", s2)
+	addP2spRelay(room, "c1", "1.1.1.1:1")
+	addP2spRelay(room, "c2", "2.2.2.2:2")
+	//members only learn relays of the others
+	if addrs := getP2spRelays(room, "c1"); len(addrs) != 1 || addrs[0] != "2.2.2.2:2" {
+		t.Errorf("expect relays [2.2.2.2:2] for c1, but got %v", addrs)
+	}
+	removeP2spSession(room, "c2", s2)
+	if addrs := getP2spRelays(room, "c1"); len(addrs) != 0 {
+		t.Errorf("expect relay removed with the member, but got %v", addrs)
+	}
+	removeP2spSession(room, "c1", s1)
+	p2spSessionMutex.Lock()
+	_, exist := p2spRelayTable[room]
+	p2spSessionMutex.Unlock()
+	if exist {
+		t.Errorf("expect relays of the room removed once all members exit")
+	}
+}
+
+func TestRelayReaderQuota(t *testing.T) {
+	r := &p2spRelay{maxTotal: 8}
+	reader := r.reader(bytes.NewReader(bytes.Repeat([]byte{'x'}, 100)), &r.upBytes)
+	b := make([]byte, 5)
+	for i := 0; i < 2; i++ {
+		if _, err := reader.Read(b); nil != err {
+			t.Fatalf("read %d below quota failed:%v", i, err)
+		}
+	}
+	if _, err := reader.Read(b); err != errRelayQuotaExceed {
+		t.Errorf("expect quota exceed error, but got %v", err)
+	}
+	if r.total() != 10 {
+		t.Errorf("expect 10 bytes counted, but got %d", r.total())
+	}
+}
+
+func TestP2SPRelayServe(t *testing.T) {
+	upstream := startEchoServer(t)
+	defer upstream.Close()
+	conf := &ProxyChannelConfig{P2SPRelay: P2SPRelayConfig{Upstream: upstream.Addr().String(), MaxConns: 1}}
+	r := &p2spRelay{conf: conf}
+	local, remote := net.Pipe()
+	done := make(chan bool)
+	go func() {
+		r.serve(remote)
+		done <- true
+	}()
+	local.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := local.Write([]byte("hello")); nil != err {
+		t.Fatal(err)
+	}
+	echo := make([]byte, 5)
+	if _, err := io.ReadFull(local, echo); nil != err || string(echo) != "hello" {
+		t.Fatalf("expect relayed echo 'hello', but got %q %v", echo, err)
+	}
+
+	//the second conn is over the conns cap
+	rejected, peer := net.Pipe()
+	go r.serve(peer)
+	rejected.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := rejected.Read(echo); err != io.EOF {
+		t.Errorf("expect conn over max conns closed, but got %v", err)
+	}
+
+	local.Close()
+	<-done
+	if r.upBytes != 5 || r.downBytes != 5 || r.total() != 10 {
+		t.Errorf("expect 5/5 bytes relayed, but got %d/%d", r.upBytes, r.downBytes)
+	}
+	if r.conns != 0 {
+		t.Errorf("expect no active relay conn, but got %d", r.conns)
+	}
+}
+
+func TestCreateRelayMuxSessionRequireUserKey(t *testing.T) {
+	conf := &ProxyChannelConfig{P2SPRoom: "room", P2SPRelay: P2SPRelayConfig{Peers: []string{"127.0.0.1:1"}}}
+	if _, err := createRelayMuxSession(conf); nil == err {
+		t.Errorf("expect relays refused without user key")
+	}
+}
@@ -0,0 +1,132 @@
+package channel
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// SessionPoolConfig of mux sessions to each server of the channel
+type SessionPoolConfig struct {
+	//max sessions per server, 0 means 'ConnsPerServer'
+	MaxSessions int
+	//sessions established at startup & re-established after local network changed, default 1
+	MinSessions int
+	//new streams prefer sessions carrying less streams than this, 0 means unlimited
+	MaxStreamsPerSession int
+}
+
+func (conf *ProxyChannelConfig) adjustPool() {
+	if conf.Pool.MaxSessions > 0 {
+		conf.ConnsPerServer = conf.Pool.MaxSessions
+	}
+	if conf.Pool.MinSessions <= 0 {
+		conf.Pool.MinSessions = 1
+	}
+	if conf.Pool.MinSessions > conf.ConnsPerServer {
+		conf.Pool.MinSessions = conf.ConnsPerServer
+	}
+}
+
+func (s *muxSessionHolder) numStreams() (int, bool) {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	if nil == s.muxSession {
+		return 0, false
+	}
+	return s.muxSession.NumStreams(), true
+}
+
+// sortByLoad order connected sessions with room for more streams first, then unconnected, then full ones
+func sortByLoad(holders []*muxSessionHolder, maxStreams int) {
+	ranks := make(map[*muxSessionHolder]int, len(holders))
+	for _, holder := range holders {
+		n, connected := holder.numStreams()
+		switch {
+		case !connected:
+			ranks[holder] = 1
+		case n >= maxStreams:
+			ranks[holder] = 2
+		}
+	}
+	sort.SliceStable(holders, func(i, j int) bool {
+		return ranks[holders[i]] < ranks[holders[j]]
+	})
+}
+
+// rewarm replace the session established on the old network, streams on it are left to drain
+func (s *muxSessionHolder) rewarm() {
+	s.sessionMutex.Lock()
+	if nil != s.muxSession {
+		s.retiredSessions[s.muxSession] = true
+		s.muxSession = nil
+	}
+	s.sessionMutex.Unlock()
+	if err := s.init(true); nil != err {
+		logger.Error("[ERROR]Failed to re-establish session to %s with reason:%v", s.server, err)
+	}
+}
+
+func localNetworkAddrs() string {
+	addrs, err := net.InterfaceAddrs()
+	if nil != err {
+		return ""
+	}
+	list := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		list = append(list, a.String())
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+var networkWatchOnce sync.Once
+var networkChangeHandlers []func()
+var networkChangeMutex sync.Mutex
+
+// OnLocalNetworkChange register the handler called once local addresses changed, e.g. to migrate the
+// connections of a channel
+func OnLocalNetworkChange(handler func()) {
+	networkChangeMutex.Lock()
+	networkChangeHandlers = append(networkChangeHandlers, handler)
+	networkChangeMutex.Unlock()
+	watchNetworkChange()
+}
+
+// watchNetworkChange poll local addresses, pre-warmed sessions are re-established once addresses changed
+func watchNetworkChange() {
+	networkWatchOnce.Do(func() {
+		go func() {
+			last := localNetworkAddrs()
+			for range time.Tick(5 * time.Second) {
+				current := localNetworkAddrs()
+				if current == last {
+					continue
+				}
+				last = current
+				logger.Notice("Local network changed, re-establish pre-warmed sessions.")
+				localChannelMutex.Lock()
+				for _, ch := range localChannelTable {
+					if ch.autoExpire || ch.Conf.Name == DirectChannelName {
+						continue
+					}
+					for holder := range ch.sessions {
+						if holder.prewarm {
+							go holder.rewarm()
+						}
+					}
+				}
+				localChannelMutex.Unlock()
+				networkChangeMutex.Lock()
+				for _, handler := range networkChangeHandlers {
+					go handler()
+				}
+				networkChangeMutex.Unlock()
+			}
+		}()
+	})
+}
@@ -0,0 +1,77 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestAdjustPool(t *testing.T) {
+	tests := []struct {
+		conns       int
+		pool        SessionPoolConfig
+		expectConns int
+		expectMin   int
+	}{
+		{3, SessionPoolConfig{}, 3, 1},
+		{3, SessionPoolConfig{MaxSessions: 5, MinSessions: 2}, 5, 2},
+		{3, SessionPoolConfig{MinSessions: 4}, 3, 3},
+		{3, SessionPoolConfig{MaxSessions: 2, MinSessions: 4}, 2, 2},
+		{3, SessionPoolConfig{MinSessions: -1}, 3, 1},
+	}
+	for _, tt := range tests {
+		conf := &ProxyChannelConfig{ConnsPerServer: tt.conns, Pool: tt.pool}
+		conf.adjustPool()
+		if conf.ConnsPerServer != tt.expectConns || conf.Pool.MinSessions != tt.expectMin {
+			t.Errorf("%+v: expect %d sessions & %d min, but got %d & %d", tt.pool, tt.expectConns, tt.expectMin, conf.ConnsPerServer, conf.Pool.MinSessions)
+		}
+	}
+}
+
+func TestSortByLoad(t *testing.T) {
+	fullClient, fullServer := newTestSessionPair(t)
+	defer fullServer.Close()
+	defer fullClient.Close()
+	idleClient, idleServer := newTestSessionPair(t)
+	defer idleServer.Close()
+	defer idleClient.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := fullClient.OpenStream(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	full := &muxSessionHolder{server: "full", muxSession: fullClient}
+	unconnected := &muxSessionHolder{server: "unconnected"}
+	idle := &muxSessionHolder{server: "idle", muxSession: idleClient}
+	tests := []struct {
+		maxStreams int
+		order      []string
+	}{
+		{2, []string{"idle", "unconnected", "full"}},
+		{3, []string{"full", "idle", "unconnected"}},
+	}
+	for _, tt := range tests {
+		holders := []*muxSessionHolder{full, unconnected, idle}
+		sortByLoad(holders, tt.maxStreams)
+		for i, holder := range holders {
+			if holder.server != tt.order[i] {
+				t.Errorf("max %d: expect order %v, but got %s at %d", tt.maxStreams, tt.order, holder.server, i)
+			}
+		}
+	}
+}
+
+func TestRewarm(t *testing.T) {
+	client, server := newTestSessionPair(t)
+	defer server.Close()
+	defer client.Close()
+	holder := &muxSessionHolder{server: "rewarm", conf: &ProxyChannelConfig{}, Channel: unreachableChannel{}, muxSession: client, retiredSessions: make(map[mux.MuxSession]bool)}
+	holder.rewarm()
+	//the old session is left to drain even if the new one failed
+	if nil != holder.muxSession || !holder.retiredSessions[client] {
+		t.Errorf("expect old session retired, but got %v", holder.retiredSessions)
+	}
+	if _, err := client.OpenStream(); nil != err {
+		t.Errorf("retired session should not be closed by rewarm, but got %v", err)
+	}
+}
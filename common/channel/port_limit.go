@@ -0,0 +1,75 @@
+package channel
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// PortLimitConfig restrict networks and ports a connect request may target
+type PortLimitConfig struct {
+	//'tcp' or 'udp', empty allows all
+	Networks []string
+	//ports or port ranges like '443', '8000-9000', empty allows all
+	AllowPorts []string
+	DenyPorts  []string
+}
+
+func normalizeNetwork(network string) string {
+	switch network {
+	case mux.UDPAssociateNetwork:
+		return "udp"
+	}
+	return strings.TrimRight(strings.ToLower(network), "46")
+}
+
+func (limit *PortLimitConfig) Allowed(network string, addr string) bool {
+	if len(limit.Networks) > 0 {
+		network = normalizeNetwork(network)
+		allowed := false
+		for _, n := range limit.Networks {
+			if strings.EqualFold(n, network) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if len(limit.AllowPorts) == 0 && len(limit.DenyPorts) == 0 {
+		return true
+	}
+	_, portStr, err := net.SplitHostPort(addr)
+	if nil != err {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	if nil != err {
+		return false
+	}
+	for _, rule := range limit.DenyPorts {
+		if matchPort(rule, port) {
+			return false
+		}
+	}
+	if len(limit.AllowPorts) == 0 {
+		return true
+	}
+	for _, rule := range limit.AllowPorts {
+		if matchPort(rule, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedPort check the destination by user's port limit which overrides the global one
+func allowedPort(user string, network string, addr string) bool {
+	if uc := getUserConfig(user); nil != uc && nil != uc.PortLimit {
+		return uc.PortLimit.Allowed(network, addr)
+	}
+	return defaultProxyLimit().PortLimitConfig.Allowed(network, addr)
+}
@@ -0,0 +1,82 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestNormalizeNetwork(t *testing.T) {
+	tests := map[string]string{
+		"tcp":                   "tcp",
+		"TCP4":                  "tcp",
+		"udp6":                  "udp",
+		mux.UDPAssociateNetwork: "udp",
+	}
+	for network, expect := range tests {
+		if n := normalizeNetwork(network); n != expect {
+			t.Errorf("expect network %s of %s, but got %s", expect, network, n)
+		}
+	}
+}
+
+func TestPortLimitAllowed(t *testing.T) {
+	tests := []struct {
+		limit   PortLimitConfig
+		network string
+		addr    string
+		allowed bool
+	}{
+		{PortLimitConfig{}, "udp", "a.test:53", true},
+		{PortLimitConfig{Networks: []string{"TCP"}}, "tcp6", "a.test:80", true},
+		{PortLimitConfig{Networks: []string{"tcp"}}, "udp", "a.test:53", false},
+		{PortLimitConfig{AllowPorts: []string{"80", "443"}}, "tcp", "a.test:443", true},
+		{PortLimitConfig{AllowPorts: []string{"80", "443"}}, "tcp", "a.test:22", false},
+		{PortLimitConfig{DenyPorts: []string{"25", "6000-7000"}}, "tcp", "a.test:6500", false},
+		{PortLimitConfig{DenyPorts: []string{"25"}}, "tcp", "a.test:80", true},
+		{PortLimitConfig{AllowPorts: []string{"1-1024"}, DenyPorts: []string{"25"}}, "tcp", "a.test:25", false},
+		//port required once ports limited
+		{PortLimitConfig{DenyPorts: []string{"25"}}, "tcp", "a.test", false},
+		{PortLimitConfig{DenyPorts: []string{"25"}}, "tcp", "a.test:http", false},
+	}
+	for _, tt := range tests {
+		if allowed := tt.limit.Allowed(tt.network, tt.addr); allowed != tt.allowed {
+			t.Errorf("%+v expect %s %s allowed:%v, but got %v", tt.limit, tt.network, tt.addr, tt.allowed, allowed)
+		}
+	}
+}
+
+func TestAllowedPortByUser(t *testing.T) {
+	defer SetDefaultProxyLimitConfig(*defaultProxyLimit())
+	SetDefaultProxyLimitConfig(ProxyLimitConfig{PortLimitConfig: PortLimitConfig{AllowPorts: []string{"443"}}})
+	if err := SetUserConfigs([]UserConfig{{Name: "ssh", PortLimit: &PortLimitConfig{AllowPorts: []string{"22"}}}, {Name: "plain"}}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	tests := []struct {
+		user    string
+		addr    string
+		allowed bool
+	}{
+		{"ssh", "a.test:22", true},
+		//user's limit overrides the global one
+		{"ssh", "a.test:443", false},
+		{"plain", "a.test:443", true},
+		{"plain", "a.test:22", false},
+		{"unknown", "a.test:22", false},
+	}
+	for _, tt := range tests {
+		if allowed := allowedPort(tt.user, "tcp", tt.addr); allowed != tt.allowed {
+			t.Errorf("user %s expect %s allowed:%v, but got %v", tt.user, tt.addr, tt.allowed, allowed)
+		}
+	}
+
+	echo := startEchoServer(t)
+	defer echo.Close()
+	session := newTestProxySession(t, &mux.AuthRequest{User: "plain", CompressMethod: mux.NoneCompressor})
+	defer session.Close()
+	if stream, err := pingTestStream(session, "tcp", echo.Addr().String()); nil == err {
+		stream.Close()
+		t.Errorf("expect stream to port not allowed rejected")
+	}
+}
@@ -1,11 +1,14 @@
 package quic
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/url"
+	"sync"
+	"time"
 
-	quic "github.com/lucas-clemente/quic-go"
+	quic "github.com/quic-go/quic-go"
 	"github.com/yinqiwen/gsnova/common/channel"
 	"github.com/yinqiwen/gsnova/common/dns"
 	"github.com/yinqiwen/gsnova/common/logger"
@@ -13,6 +16,117 @@ import (
 	"github.com/yinqiwen/gsnova/common/netx"
 )
 
+// tls session caches by server address, the session cache of quic is keyed by 'ServerName' only,
+// tickets of servers on the same ip with different ports would be mixed up & rejected
+var quicSessionCaches = make(map[string]tls.ClientSessionCache)
+
+// ALPN offered if not set by the channel's TLS policy, 0-RTT is only used with the protocol negotiated before
+const defaultALPN = "gsnova"
+
+var quicSessions = make(map[*quicClientSession]bool)
+var quicSessionsMutex sync.Mutex
+var quicMigrateOnce sync.Once
+
+// quicClientSession move the connection to a new udp socket once the local network changed,
+// sockets of previous paths are released with the session
+type quicClientSession struct {
+	*mux.QUICMuxSession
+	server     string
+	mutex      sync.Mutex
+	transports []*quic.Transport
+	path       *quic.Path
+	migrating  bool
+}
+
+func newQUICTransport() (*quic.Transport, error) {
+	udpConn, err := netx.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if nil != err {
+		return nil, err
+	}
+	return &quic.Transport{Conn: udpConn}, nil
+}
+
+func (s *quicClientSession) migrate() {
+	s.mutex.Lock()
+	if s.migrating || nil == s.transports {
+		s.mutex.Unlock()
+		return
+	}
+	s.migrating = true
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.migrating = false
+		s.mutex.Unlock()
+	}()
+
+	tr, err := newQUICTransport()
+	if nil != err {
+		logger.Error("[ERROR]Failed to rebind QUIC connection to %s with reason:%v", s.server, err)
+		return
+	}
+	path, err := s.AddPath(tr)
+	if nil == err {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err = path.Probe(ctx); nil == err {
+			err = path.Switch()
+		}
+		cancel()
+		if nil != err {
+			path.Close()
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if nil != err || nil == s.transports {
+		tr.Close()
+		if nil != err {
+			logger.Error("[ERROR]Failed to migrate QUIC connection to %s with reason:%v", s.server, err)
+		}
+		return
+	}
+	if nil != s.path {
+		//the previous path is no longer active after switching
+		s.path.Close()
+	}
+	s.path = path
+	s.transports = append(s.transports, tr)
+	logger.Notice("Migrate QUIC connection to %s from local address:%v", s.server, tr.Conn.LocalAddr())
+}
+
+func (s *quicClientSession) Close() error {
+	quicSessionsMutex.Lock()
+	delete(quicSessions, s)
+	quicSessionsMutex.Unlock()
+	err := s.QUICMuxSession.Close()
+	s.mutex.Lock()
+	for _, tr := range s.transports {
+		tr.Close()
+	}
+	s.transports = nil
+	s.mutex.Unlock()
+	return err
+}
+
+func getQUICSessionCache(hostport string) tls.ClientSessionCache {
+	quicSessionsMutex.Lock()
+	defer quicSessionsMutex.Unlock()
+	cache, exist := quicSessionCaches[hostport]
+	if !exist {
+		cache = tls.NewLRUClientSessionCache(4)
+		quicSessionCaches[hostport] = cache
+	}
+	return cache
+}
+
+func migrateQUICSessions() {
+	quicSessionsMutex.Lock()
+	defer quicSessionsMutex.Unlock()
+	for s := range quicSessions {
+		go s.migrate()
+	}
+}
+
 type QUICProxy struct {
 	//proxy.BaseProxy
 }
@@ -30,7 +144,24 @@ func (tc *QUICProxy) CreateMuxSession(server string, conf *channel.ProxyChannelC
 		return nil, err
 	}
 	hostport := rurl.Host
-	tcpHost, tcpPort, _ := net.SplitHostPort(hostport)
+	tcpHost, tcpPort, err := net.SplitHostPort(hostport)
+	if nil != err {
+		tcpHost = rurl.Host
+		tcpPort = "443"
+		hostport = net.JoinHostPort(tcpHost, tcpPort)
+	}
+	tlscfg, err := channel.NewTLSConfig(conf)
+	if nil != err {
+		return nil, err
+	}
+	if len(tlscfg.ServerName) == 0 && net.ParseIP(tcpHost) == nil {
+		tlscfg.ServerName = tcpHost
+	}
+	//resume tls sessions on reconnect, streams opened before the handshake completed are sent as 0-RTT data
+	tlscfg.ClientSessionCache = getQUICSessionCache(hostport)
+	if len(tlscfg.NextProtos) == 0 {
+		tlscfg.NextProtos = []string{defaultALPN}
+	}
 	if net.ParseIP(tcpHost) == nil {
 		iphost, err := dns.DnsGetDoaminIP(tcpHost)
 		if nil != err {
@@ -38,26 +169,42 @@ func (tc *QUICProxy) CreateMuxSession(server string, conf *channel.ProxyChannelC
 		}
 		hostport = net.JoinHostPort(iphost, tcpPort)
 	}
-	var quicSession quic.Session
 
 	udpAddr, err := net.ResolveUDPAddr("udp", hostport)
 	if err != nil {
 		return nil, err
 	}
-	udpConn, err := netx.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	tr, err := newQUICTransport()
 	if err != nil {
 		return nil, err
 	}
 	quicConfig := &quic.Config{
-		KeepAlive: true,
+		KeepAlivePeriod: 15 * time.Second,
 	}
-	quicSession, err = quic.Dial(udpConn, udpAddr, hostport, &tls.Config{InsecureSkipVerify: true}, quicConfig)
-
+	dialTimeout := conf.LocalDialMSTimeout
+	if 0 == dialTimeout {
+		dialTimeout = 5000
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(dialTimeout)*time.Millisecond)
+	defer cancel()
+	quicConn, err := tr.DialEarly(ctx, udpAddr, tlscfg, quicConfig)
 	if err != nil {
+		tr.Close()
 		return nil, err
 	}
 	logger.Debug("Connect %s success.", server)
-	return &mux.QUICMuxSession{Session: quicSession}, nil
+	session := &quicClientSession{
+		QUICMuxSession: &mux.QUICMuxSession{Conn: quicConn},
+		server:         server,
+		transports:     []*quic.Transport{tr},
+	}
+	quicSessionsMutex.Lock()
+	quicSessions[session] = true
+	quicSessionsMutex.Unlock()
+	quicMigrateOnce.Do(func() {
+		channel.OnLocalNetworkChange(migrateQUICSessions)
+	})
+	return session, nil
 }
 
 func init() {
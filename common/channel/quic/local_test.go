@@ -0,0 +1,130 @@
+package quic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func startQUICEchoServer(t *testing.T) *quic.EarlyListener {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	lp, err := quic.ListenAddrEarly("127.0.0.1:0", acceptClientALPN(cfg), &quic.Config{Allow0RTT: true})
+	if nil != err {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := lp.Accept(context.Background())
+			if nil != err {
+				return
+			}
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if nil != err {
+						return
+					}
+					go func() {
+						io.Copy(stream, stream)
+						stream.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return lp
+}
+
+func echoQUICStream(t *testing.T, session mux.MuxSession, msg string) {
+	stream, err := session.OpenStream()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if _, err = stream.Write([]byte(msg)); nil != err {
+		t.Fatal(err)
+	}
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, len(msg))
+	if _, err = io.ReadFull(stream, b); nil != err || string(b) != msg {
+		t.Fatalf("echo of %q got %q, %v", msg, b, err)
+	}
+}
+
+func TestAcceptClientALPN(t *testing.T) {
+	lp := startQUICEchoServer(t)
+	defer lp.Close()
+	for _, protos := range [][]string{nil, {"h3"}, {defaultALPN, "h3"}} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := quic.DialAddr(ctx, lp.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: protos}, nil)
+		cancel()
+		if nil != err {
+			t.Errorf("client offered ALPN %v failed to connect:%v", protos, err)
+			continue
+		}
+		expected := ""
+		if len(protos) > 0 {
+			expected = protos[0]
+		}
+		if proto := conn.ConnectionState().TLS.NegotiatedProtocol; proto != expected {
+			t.Errorf("client offered ALPN %v negotiated %q", protos, proto)
+		}
+		conn.CloseWithError(0, "")
+	}
+}
+
+func TestQUICSessionResumeAndMigrate(t *testing.T) {
+	//servers on the same ip with different ticket keys
+	a, b := startQUICEchoServer(t), startQUICEchoServer(t)
+	defer a.Close()
+	defer b.Close()
+	p := &QUICProxy{}
+	for i, lp := range []*quic.EarlyListener{a, b, a, b} {
+		ms, err := p.CreateMuxSession("quic://"+lp.Addr().String(), &channel.ProxyChannelConfig{})
+		if nil != err {
+			t.Fatal(err)
+		}
+		session := ms.(*quicClientSession)
+		echoQUICStream(t, session, "hello")
+		//the session is resumed by the ticket of the first one, with streams sent as 0-RTT data
+		select {
+		case <-session.HandshakeComplete():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handshake of session %d not completed", i)
+		}
+		if used := session.ConnectionState().Used0RTT; used != (i > 1) {
+			t.Errorf("session %d used 0-RTT:%v", i, used)
+		}
+		//session ticket for the next session is sent by server after the handshake
+		echoQUICStream(t, session, "after handshake")
+		session.migrate()
+		if len(session.transports) != 2 || nil == session.path {
+			t.Fatalf("session %d not migrated to a new socket", i)
+		}
+		echoQUICStream(t, session, "after migration")
+		session.Close()
+		quicSessionsMutex.Lock()
+		registered := quicSessions[session]
+		quicSessionsMutex.Unlock()
+		if registered || nil != session.transports {
+			t.Errorf("closed session %d not released", i)
+		}
+	}
+}
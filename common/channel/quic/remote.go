@@ -1,28 +1,55 @@
 package quic
 
 import (
+	"context"
 	"crypto/tls"
+	"time"
 
-	quic "github.com/lucas-clemente/quic-go"
+	quic "github.com/quic-go/quic-go"
 	"github.com/yinqiwen/gsnova/common/channel"
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/mux"
 )
 
-func servQUIC(lp quic.Listener) {
+func servQUIC(lp *quic.EarlyListener) {
 	for {
-		sess, err := lp.Accept()
+		sess, err := lp.Accept(context.Background())
 		if nil != err {
-			continue
+			logger.Error("[ERROR]Stop QUIC listener:%v with reason:%v", lp.Addr(), err)
+			return
 		}
-		muxSession := &mux.QUICMuxSession{Session: sess}
+		muxSession := &mux.QUICMuxSession{Conn: sess}
 		go channel.ServProxyMuxSession(muxSession, nil)
 	}
 	//ws.WriteMessage(websocket.CloseMessage, []byte{})
 }
 
+// acceptClientALPN select the ALPN offered by clients if the listener's TLS policy set none,
+// 0-RTT needs a negotiated protocol while old clients offer none
+func acceptClientALPN(config *tls.Config) *tls.Config {
+	if len(config.NextProtos) > 0 {
+		return config
+	}
+	base := config
+	config = config.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if len(hello.SupportedProtos) == 0 {
+			return nil, nil
+		}
+		cfg := base.Clone()
+		cfg.NextProtos = hello.SupportedProtos[:1]
+		return cfg, nil
+	}
+	return config
+}
+
 func StartQuicProxyServer(addr string, config *tls.Config) error {
-	lp, err := quic.ListenAddr(addr, config, nil)
+	//replayed 0-RTT auth requests are rejected by the auth nonce cache
+	quicConfig := &quic.Config{
+		KeepAlivePeriod: 15 * time.Second,
+		Allow0RTT:       true,
+	}
+	lp, err := quic.ListenAddrEarly(addr, acceptClientALPN(config), quicConfig)
 	if nil != err {
 		logger.Error("[ERROR]Failed to listen QUIC address:%s with reason:%v", addr, err)
 		return err
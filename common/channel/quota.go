@@ -0,0 +1,257 @@
+package channel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/store"
+)
+
+var errQuotaExceeded = errors.New("traffic quota exceeded")
+
+const quotaStoreBucket = "quota"
+
+// userQuotaUsage is persisted in the store, usage is reset when the period changed.
+// 'Used' & 'TopUp' are updated atomically, 'Period' is changed with quotaUsagesMutex held.
+type userQuotaUsage struct {
+	Period string
+	Used   int64
+	TopUp  int64
+	dirty  int32
+	//unix nano of the period end, usage is rolled to the next period after it
+	periodEnd int64
+}
+
+// quotaUsages is user -> *userQuotaUsage, entries are created with quotaUsagesMutex held
+var quotaUsages sync.Map
+var quotaUsagesMutex sync.Mutex
+var quotaTaskOnce sync.Once
+
+func (uc *UserConfig) compileQuota() {
+	uc.quotaBytes = 0
+	if len(uc.Quota) == 0 {
+		return
+	}
+	v, err := helper.ToBytes(uc.Quota)
+	if nil != err {
+		logger.Error("[ERROR]Invalid quota:%s for user:%s with reason:%v", uc.Quota, uc.Name, err)
+		return
+	}
+	uc.quotaBytes = int64(v)
+	quotaTaskOnce.Do(func() {
+		go quotaTask()
+	})
+}
+
+// quotaPeriod return the start & end of the period containing now, periods start on 'QuotaResetDay' of months
+func (uc *UserConfig) quotaPeriod(now time.Time) (time.Time, time.Time) {
+	day := uc.QuotaResetDay
+	if day <= 0 || day > 28 {
+		day = 1
+	}
+	start := time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, now.Location())
+	if start.After(now) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// lockedUsage return the usage of current period, quotaUsagesMutex must be held
+func lockedUsage(uc *UserConfig, now time.Time) *userQuotaUsage {
+	start, end := uc.quotaPeriod(now)
+	period := start.Format("2006-01-02")
+	var u *userQuotaUsage
+	if v, exist := quotaUsages.Load(uc.Name); exist {
+		u = v.(*userQuotaUsage)
+	} else {
+		u = &userQuotaUsage{}
+		if b, err := store.Default().Get(quotaStoreBucket, uc.Name); nil == err {
+			json.Unmarshal(b, u)
+		}
+		quotaUsages.Store(uc.Name, u)
+	}
+	if u.Period != period {
+		u.Period = period
+		atomic.StoreInt64(&u.Used, 0)
+		atomic.StoreInt64(&u.TopUp, 0)
+		atomic.StoreInt32(&u.dirty, 1)
+	}
+	atomic.StoreInt64(&u.periodEnd, end.UnixNano())
+	return u
+}
+
+// currentUsage return the usage of current period without locking unless the period is rolled
+func currentUsage(uc *UserConfig, now time.Time) *userQuotaUsage {
+	if v, exist := quotaUsages.Load(uc.Name); exist {
+		u := v.(*userQuotaUsage)
+		if now.UnixNano() < atomic.LoadInt64(&u.periodEnd) {
+			return u
+		}
+	}
+	quotaUsagesMutex.Lock()
+	defer quotaUsagesMutex.Unlock()
+	return lockedUsage(uc, now)
+}
+
+func (uc *UserConfig) quotaStatus(u *userQuotaUsage, now time.Time) *mux.QuotaStatus {
+	_, end := uc.quotaPeriod(now)
+	st := &mux.QuotaStatus{Limit: uc.quotaBytes + atomic.LoadInt64(&u.TopUp), Used: atomic.LoadInt64(&u.Used), ResetTime: end.Unix()}
+	if st.Remaining = st.Limit - st.Used; st.Remaining < 0 {
+		st.Remaining = 0
+	}
+	return st
+}
+
+func getQuotaUser(user string) *UserConfig {
+	if uc := getUserConfig(user); nil != uc && uc.quotaBytes > 0 {
+		return uc
+	}
+	return nil
+}
+
+// addQuotaUsage count traffic of the user, return false if the quota is exceeded
+func addQuotaUsage(uc *UserConfig, n int64) bool {
+	u := currentUsage(uc, time.Now())
+	used := atomic.AddInt64(&u.Used, n)
+	if 0 == atomic.LoadInt32(&u.dirty) {
+		atomic.StoreInt32(&u.dirty, 1)
+	}
+	return used < uc.quotaBytes+atomic.LoadInt64(&u.TopUp)
+}
+
+// allowedByQuota check the user's quota, the reason is sent to client if exceeded
+func allowedByQuota(user string) (bool, string) {
+	uc := getQuotaUser(user)
+	if nil == uc {
+		return true, ""
+	}
+	st := GetQuotaStatus(user)
+	if st.Remaining > 0 {
+		return true, ""
+	}
+	return false, fmt.Sprintf("traffic quota %s of user:%s exceeded, reset at %s", uc.Quota, user, time.Unix(st.ResetTime, 0).Format(time.RFC3339))
+}
+
+// GetQuotaStatus return the quota status of the user, nil if no quota configured
+func GetQuotaStatus(user string) *mux.QuotaStatus {
+	uc := getQuotaUser(user)
+	if nil == uc {
+		return nil
+	}
+	now := time.Now()
+	return uc.quotaStatus(currentUsage(uc, now), now)
+}
+
+// TopUpQuota add extra bytes to the user's quota of current period & push the new status to the user
+func TopUpQuota(user string, bytes int64) (*mux.QuotaStatus, error) {
+	uc := getQuotaUser(user)
+	if nil == uc {
+		return nil, fmt.Errorf("no quota configured for user:%s", user)
+	}
+	now := time.Now()
+	u := currentUsage(uc, now)
+	atomic.AddInt64(&u.TopUp, bytes)
+	atomic.StoreInt32(&u.dirty, 1)
+	st := uc.quotaStatus(u, now)
+	pushQuotaStatus(user)
+	return st, nil
+}
+
+func pushQuotaStatus(user string) {
+	st := GetQuotaStatus(user)
+	if nil == st {
+		return
+	}
+	rangeLiveSessions(func(ctx *sessionContext) bool {
+		if ctx.auth.User == user {
+			ctx.sendControl(&mux.ControlMessage{Type: mux.ControlQuotaStatus, Quota: st})
+		}
+		return true
+	})
+}
+
+func flushQuotaUsages() {
+	quotaUsagesMutex.Lock()
+	defer quotaUsagesMutex.Unlock()
+	quotaUsages.Range(func(key, value interface{}) bool {
+		user, u := key.(string), value.(*userQuotaUsage)
+		if !atomic.CompareAndSwapInt32(&u.dirty, 1, 0) {
+			return true
+		}
+		snapshot := &userQuotaUsage{Period: u.Period, Used: atomic.LoadInt64(&u.Used), TopUp: atomic.LoadInt64(&u.TopUp)}
+		b, _ := json.Marshal(snapshot)
+		if err := store.Default().Put(quotaStoreBucket, user, b); nil != err {
+			logger.Error("[ERROR]Failed to save quota usage of user:%s with reason:%v", user, err)
+			atomic.StoreInt32(&u.dirty, 1)
+		}
+		return true
+	})
+}
+
+// quotaTask persist usages & push status to sessions of quota limited users periodically
+func quotaTask() {
+	ticks := 0
+	for range time.Tick(10 * time.Second) {
+		flushQuotaUsages()
+		ticks++
+		if ticks%6 != 0 {
+			continue
+		}
+		users := make(map[string]bool)
+		rangeLiveSessions(func(ctx *sessionContext) bool {
+			users[ctx.auth.User] = true
+			return true
+		})
+		for user := range users {
+			pushQuotaStatus(user)
+		}
+	}
+}
+
+// quotaReader count read bytes into the user's quota, the stream is cut once the quota exceeded
+type quotaReader struct {
+	io.Reader
+	uc *UserConfig
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && !addQuotaUsage(r.uc, int64(n)) {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+// withQuota count the reader into the user's quota if configured
+func withQuota(user string, r io.Reader) io.Reader {
+	if uc := getQuotaUser(user); nil != uc {
+		return &quotaReader{r, uc}
+	}
+	return r
+}
+
+// onQuotaStatus keep the latest status pushed by server, warn once the remaining quota is running low
+func (s *muxSessionHolder) onQuotaStatus(msg *mux.ControlMessage) {
+	if nil == msg.Quota {
+		return
+	}
+	st := msg.Quota
+	s.sessionMutex.Lock()
+	old := s.quota
+	s.quota = st
+	s.sessionMutex.Unlock()
+	reset := time.Unix(st.ResetTime, 0).Format("2006-01-02 15:04")
+	if len(msg.Reason) > 0 {
+		logger.Error("[ERROR]Remote:%s rejected stream for reason:%s", s.server, msg.Reason)
+	} else if st.Remaining*10 < st.Limit && (nil == old || old.Remaining*10 >= old.Limit) {
+		logger.Notice("Remote:%s traffic quota is running low, %d of %d bytes remaining until %s", s.server, st.Remaining, st.Limit, reset)
+	}
+}
@@ -0,0 +1,79 @@
+package channel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestQuotaPeriod(t *testing.T) {
+	day := func(s string) time.Time {
+		v, _ := time.Parse("2006-01-02 15:04", s)
+		return v
+	}
+	for _, c := range []struct {
+		resetDay   int
+		now        string
+		start, end string
+	}{
+		{0, "2024-03-14 12:00", "2024-03-01 00:00", "2024-04-01 00:00"},
+		{15, "2024-03-14 23:59", "2024-02-15 00:00", "2024-03-15 00:00"},
+		{15, "2024-03-15 00:00", "2024-03-15 00:00", "2024-04-15 00:00"},
+		{28, "2024-01-28 08:00", "2024-01-28 00:00", "2024-02-28 00:00"},
+		//days not in every month fall back to the first day
+		{31, "2024-02-10 08:00", "2024-02-01 00:00", "2024-03-01 00:00"},
+	} {
+		uc := &UserConfig{QuotaResetDay: c.resetDay}
+		start, end := uc.quotaPeriod(day(c.now))
+		if !start.Equal(day(c.start)) || !end.Equal(day(c.end)) {
+			t.Errorf("reset day %d at %s got period %v-%v, expected %s-%s", c.resetDay, c.now, start, end, c.start, c.end)
+		}
+	}
+}
+
+func TestQuotaRejectStreamsWhenExhausted(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	if err := SetUserConfigs([]UserConfig{{Name: "metered", Quota: "1KB"}}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	defer quotaUsages.Delete("metered")
+	uc := getQuotaUser("metered")
+	if nil == uc {
+		t.Fatalf("quota of user not compiled")
+	}
+
+	session := newTestProxySession(t, &mux.AuthRequest{SessionID: "quota-test", User: "metered", CompressMethod: mux.NoneCompressor})
+	defer session.Close()
+	stream, err := pingTestStream(session, "tcp", echo.Addr().String())
+	if nil != err {
+		t.Fatalf("stream rejected before quota exhausted for reason:%v", err)
+	}
+	stream.Close()
+	if addQuotaUsage(uc, 1024) {
+		t.Errorf("usage over the quota should be reported")
+	}
+	if st := GetQuotaStatus("metered"); st.Remaining != 0 || st.Limit != 1024 {
+		t.Errorf("unexpected quota status %+v", st)
+	}
+	if stream, err = pingTestStream(session, "tcp", echo.Addr().String()); nil == err || !strings.Contains(err.Error(), "quota") {
+		t.Errorf("stream after quota exhausted not rejected, got %v", err)
+		if nil != stream {
+			stream.Close()
+		}
+	}
+	if _, err = TopUpQuota("metered", 4096); nil != err {
+		t.Fatal(err)
+	}
+	if stream, err = pingTestStream(session, "tcp", echo.Addr().String()); nil != err {
+		t.Errorf("stream after top up rejected for reason:%v", err)
+	} else {
+		stream.Close()
+	}
+	if _, err = TopUpQuota("nobody", 4096); nil == err {
+		t.Errorf("top up of user without quota should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"bytes"
 	"io"
 	"net"
 	"net/url"
@@ -13,6 +14,8 @@ import (
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/mux"
 	"github.com/yinqiwen/pmux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var DefaultServerRateLimit RateLimitConfig
@@ -26,6 +29,63 @@ type sessionContext struct {
 	session      mux.MuxSession
 	closed       bool
 	isP2SP       bool
+	//client session serving inbound streams of its registered reverse tunnels only
+	reverseOnly bool
+
+	control      mux.MuxStream
+	controlMutex sync.Mutex
+
+	lastActive int64
+	recvBytes  int64
+	sentBytes  int64
+	//set once bytes of the closed session are added to the server totals
+	accounted int32
+
+	//*activeStream of proxy, udp associate & reverse streams
+	streams sync.Map
+}
+
+func (ctx *sessionContext) sessionID() string {
+	if nil == ctx.auth {
+		return ""
+	}
+	return ctx.auth.SessionID
+}
+
+// log attach session and stream fields to records for structured logging
+func (ctx *sessionContext) log(stream mux.MuxStream) *logger.Entry {
+	fields := logger.Fields{"session": ctx.sessionID(), "addr": ctx.remoteAddr()}
+	if nil != ctx.auth {
+		fields["user"] = ctx.auth.User
+	}
+	if nil != stream {
+		fields["stream"] = stream.StreamID()
+	}
+	return logger.WithFields(fields)
+}
+
+func (ctx *sessionContext) setControlStream(stream mux.MuxStream) {
+	ctx.controlMutex.Lock()
+	defer ctx.controlMutex.Unlock()
+	if nil != ctx.control {
+		ctx.control.Close()
+	}
+	ctx.control = stream
+}
+
+func (ctx *sessionContext) sendControl(msg *mux.ControlMessage) error {
+	ctx.controlMutex.Lock()
+	defer ctx.controlMutex.Unlock()
+	if nil == ctx.control {
+		return nil
+	}
+	ctx.control.SetWriteDeadline(time.Now().Add(time.Second))
+	err := mux.WriteMessage(ctx.control, msg)
+	if nil != err {
+		ctx.control.Close()
+		ctx.control = nil
+	}
+	return err
 }
 
 func (ctx *sessionContext) close() {
@@ -35,9 +95,16 @@ func (ctx *sessionContext) close() {
 	}
 	ctx.session.Close()
 	emptySessions.Delete(ctx)
+	liveSessions.Delete(ctx)
+	if atomic.CompareAndSwapInt32(&ctx.accounted, 0, 1) {
+		atomic.AddInt64(&closedRecvBytes, atomic.LoadInt64(&ctx.recvBytes))
+		atomic.AddInt64(&closedSentBytes, atomic.LoadInt64(&ctx.sentBytes))
+	}
 }
 
 func getRateLimitBucket(user string) *ratelimit.Bucket {
+	rateLimitBucketLock.Lock()
+	defer rateLimitBucketLock.Unlock()
 	if nil == DefaultServerRateLimit.Limit {
 		return nil
 	}
@@ -59,8 +126,6 @@ func getRateLimitBucket(user string) *ratelimit.Bucket {
 	if limitPerSec <= 0 {
 		return nil
 	}
-	rateLimitBucketLock.Lock()
-	defer rateLimitBucketLock.Unlock()
 	r, ok := rateLimitBuckets[user]
 	if !ok {
 		r = ratelimit.NewBucket(1*time.Second, limitPerSec)
@@ -69,6 +134,14 @@ func getRateLimitBucket(user string) *ratelimit.Bucket {
 	return r
 }
 
+// SetServerRateLimit replace the rate limit config, buckets are recreated for new streams
+func SetServerRateLimit(cfg RateLimitConfig) {
+	rateLimitBucketLock.Lock()
+	defer rateLimitBucketLock.Unlock()
+	DefaultServerRateLimit = cfg
+	rateLimitBuckets = make(map[string]*ratelimit.Bucket)
+}
+
 var emptySessions sync.Map
 
 func init() {
@@ -80,8 +153,9 @@ func init() {
 					ctx := key.(*sessionContext)
 					ago := time.Now().Sub(ctx.activeIOTime)
 					if ago > time.Duration(defaultMuxConfig.SessionIdleTimeout)*time.Second {
+						ctx.sendControl(&mux.ControlMessage{Type: mux.ControlSessionClosing, Reason: "idle"})
 						ctx.close()
-						logger.Error("Close mux session since it's not active since %v ago.", ago)
+						logger.Error("Close mux session:%s since it's not active since %v ago.", ctx.sessionID(), ago)
 					}
 					return true
 				})
@@ -100,165 +174,485 @@ func isTimeoutErr(err error) bool {
 	return false
 }
 
+// replyConnect write the ConnectResponse if asked by the client, 'err' is the reason failed to connect
+func replyConnect(stream mux.MuxStream, creq *mux.ConnectRequest, err error) {
+	if !creq.Response {
+		return
+	}
+	res := &mux.ConnectResponse{}
+	if nil != err {
+		res.Code, res.Reason = dialErrorCode(err), err.Error()
+	}
+	stream.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	mux.WriteMessage(stream, res)
+	stream.SetWriteDeadline(time.Time{})
+}
+
+// rejectStream reply the reason to clients asked ConnectResponse & close the stream
+func rejectStream(stream mux.MuxStream, creq *mux.ConnectRequest, reason string) {
+	replyConnect(stream, creq, &mux.ConnectError{Code: mux.DialErrNotAllowed, Reason: reason})
+	stream.Close()
+}
+
 func handleProxyStream(stream mux.MuxStream, ctx *sessionContext) {
-	atomic.AddInt32(&ctx.streamCouter, 1)
+	creq, err := mux.ReadConnectRequest(stream)
+	if nil != err {
+		stream.Close()
+		ctx.log(stream).Error("[ERROR]:Failed to read connect request:%v", err)
+		return
+	}
+	if ctx.reverseOnly {
+		if _, ok := reverseLocalAddr(ctx.sessionID(), creq.Addr); !ok || creq.Network != mux.ReverseNetwork {
+			ctx.log(stream).Error("[ERROR]Reject %s stream to '%s' not matched any registered reverse tunnel.", creq.Network, creq.Addr)
+			stream.Close()
+			return
+		}
+	}
+	if creq.Network == mux.ControlNetwork {
+		//control stream is not counted as active stream
+		ctx.setControlStream(stream)
+		if st := GetQuotaStatus(ctx.auth.User); nil != st {
+			ctx.sendControl(&mux.ControlMessage{Type: mux.ControlQuotaStatus, Quota: st})
+		}
+		pushRuleBundle(ctx, getRuleBundle())
+		return
+	}
+	if creq.Network == mux.PingNetwork {
+		//health probe is not counted as active stream either
+		handlePingStream(stream)
+		return
+	}
+	streams := atomic.AddInt32(&ctx.streamCouter, 1)
 	emptySessions.Delete(ctx)
 	defer func() {
+		ctx.touch()
 		if 0 == atomic.AddInt32(&ctx.streamCouter, -1) && !ctx.closed {
 			emptySessions.Store(ctx, true)
 		}
 	}()
-	creq, err := mux.ReadConnectRequest(stream)
-	if nil != err {
-		stream.Close()
-		logger.Error("[ERROR]:Failed to read connect request:%v", err)
+	audit := newStreamAudit(ctx, creq)
+	defer audit.emit()
+	if !allowedStreams(streams) {
+		ctx.log(stream).Notice("Reject stream for too many streams(%d) of session", streams-1)
+		audit.reject(stream, creq, "too many streams of session")
 		return
 	}
-	logger.Debug("[%d]Start handle stream:%v with comprresor:%s", stream.StreamID(), creq, ctx.auth.CompressMethod)
-	if !defaultProxyLimitConfig.Allowed(creq.Addr) {
-		logger.Error("'%s' is NOT allowed by proxy limit config.", creq.Addr)
-		stream.Close()
+	ctx.log(stream).Debug("Start handle stream:%v with comprresor:%s", creq, ctx.auth.CompressMethod)
+	if allowed, reason := allowedBySchedule(ctx.auth.User); !allowed {
+		//only the stream is rejected, streams already relaying are not interrupted
+		ctx.log(stream).Notice("Reject stream for reason:%s", reason)
+		audit.reject(stream, creq, reason)
+		return
+	}
+	if allowed, reason := allowedByQuota(ctx.auth.User); !allowed {
+		ctx.log(stream).Notice("Reject stream for reason:%s", reason)
+		audit.reject(stream, creq, reason)
+		ctx.sendControl(&mux.ControlMessage{Type: mux.ControlQuotaStatus, Reason: reason, Quota: GetQuotaStatus(ctx.auth.User)})
+		return
+	}
+	if creq.Network == mux.SpeedTestNetwork {
+		handleSpeedTestStream(stream, ctx, creq, audit)
+		return
+	}
+	if creq.Network == mux.P2PSignalNetwork {
+		if len(ctx.auth.P2SPRoomId) > 0 {
+			handleP2PSignalStream(stream, ctx)
+		} else {
+			stream.Close()
+		}
 		return
 	}
+	if creq.Network == mux.UDPAssociateNetwork && len(creq.Hops) == 0 {
+		handleUDPAssociateStream(stream, ctx, creq)
+		return
+	}
+	limited := creq.Network != mux.UDPAssociateNetwork
+	if creq.Network == mux.ReverseNetwork {
+		local, ok := reverseLocalAddr(ctx.sessionID(), creq.Addr)
+		if !ok {
+			handleReverseStream(stream, ctx, creq)
+			return
+		}
+		//inbound connection of the reverse tunnel registered by this client
+		creq.Network, creq.Addr = "tcp", local
+		limited = false
+	}
+	if limited && !defaultProxyLimit().Allowed(creq.Addr) {
+		ctx.log(stream).Error("'%s' is NOT allowed by proxy limit config.", creq.Addr)
+		audit.reject(stream, creq, "not allowed by proxy limit")
+		return
+	}
+	if limited && !allowedByUserACL(ctx.auth.User, creq.Addr) {
+		ctx.log(stream).Error("'%s' is NOT allowed by ACL of user:%s.", creq.Addr, ctx.auth.User)
+		audit.reject(stream, creq, "not allowed by ACL")
+		return
+	}
+	if limited && !allowedPort(ctx.auth.User, creq.Network, creq.Addr) {
+		ctx.log(stream).Error("%s '%s' is NOT allowed by port limit of user:%s.", creq.Network, creq.Addr, ctx.auth.User)
+		audit.reject(stream, creq, "not allowed by port limit")
+		return
+	}
+	if limited {
+		ok, release := acquireHostConn(ctx.auth.User, creq.Addr)
+		if !ok {
+			ctx.log(stream).Error("Too many concurrent streams to '%s' for user:%s.", creq.Addr, ctx.auth.User)
+			audit.reject(stream, creq, "too many concurrent streams to the host")
+			return
+		}
+		defer release()
+	}
+	spanCtx, span := startStreamSpan(creq.TraceContext, "gsnova.stream",
+		attribute.String("network", creq.Network), attribute.String("addr", creq.Addr),
+		attribute.String("session", ctx.sessionID()), attribute.String("user", ctx.auth.User),
+		attribute.Int("hops", len(creq.Hops)))
+	defer func() {
+		endSpan(span, err)
+	}()
 
 	maxIdleTime := time.Duration(defaultMuxConfig.StreamIdleTimeout) * time.Second
 	if maxIdleTime == 0 {
 		maxIdleTime = 10 * time.Second
 	}
 	var c io.ReadWriteCloser
-	dialTimeout := creq.DialTimeout
+	dialTimeout := time.Duration(creq.DialTimeout) * time.Millisecond
 	if dialTimeout == 0 {
-		dialTimeout = 10000
+		dialTimeout = defaultDialTimeout(creq.Addr)
 	}
+	//data sent by clients speaking first(TLS, SSH) while dialing is buffered by the stream's receive window
 	if len(creq.Hops) == 0 {
 		var conn net.Conn
-		conn, err = net.DialTimeout(creq.Network, creq.Addr, time.Duration(dialTimeout)*time.Millisecond)
-		if nil != err {
-			logger.Error("[ERROR]:Failed to connect %s:%v for reason:%v", creq.Network, creq.Addr, err)
+		if t := lookupHairpinTunnel(ctx, creq.Network, creq.Addr); nil != t {
+			//reverse tunnel exposed by this server, relay over the mux session of the tunnel directly
+			var hs *hairpinStream
+			if hs, err = t.open(); nil == err {
+				c = hs
+			} else {
+				ctx.log(stream).Error("[ERROR]Failed to open hairpin stream to %s for reason:%v", creq.Addr, err)
+			}
 		} else {
-			if creq.ReadTimeout > 0 {
-				//connection need to set read timeout to avoid hang forever
-				readTimeout := time.Duration(creq.ReadTimeout) * time.Millisecond
-				maxIdleTime = readTimeout
+			dialStart := time.Now()
+			_, dialSpan := tracer.Start(spanCtx, "gsnova.dial")
+			var allowIP func(ip net.IP) bool
+			if limited {
+				allowIP = func(ip net.IP) bool {
+					if !allowedDialIP(ctx.auth.User, creq.Addr, ip) {
+						ctx.log(stream).Error("'%s' via %v is NOT allowed by proxy limit or ACL of user:%s.", creq.Addr, ip, ctx.auth.User)
+						return false
+					}
+					return true
+				}
+			}
+			conn, err = dialDestinationWithRetry(creq.Network, creq.Addr, dialTimeout, allowIP)
+			endSpan(dialSpan, err)
+			recordDialResult(creq.Addr, time.Now().Sub(dialStart), err)
+			if nil != err {
+				ctx.log(stream).Error("[ERROR]Failed to connect %s:%v for reason:%v", creq.Network, creq.Addr, err)
+				if !creq.Response {
+					//let the client tell why instead of a silent close
+					ctx.sendControl(&mux.ControlMessage{Type: mux.ControlDialFailed, StreamID: stream.StreamID(),
+						Code: dialErrorCode(err), Addr: creq.Addr, Reason: err.Error()})
+				}
+			} else {
+				if creq.ReadTimeout > 0 {
+					//connection need to set read timeout to avoid hang forever
+					readTimeout := time.Duration(creq.ReadTimeout) * time.Millisecond
+					maxIdleTime = readTimeout
+				}
+				applyTargetKeepAlive(conn)
+				c = conn
 			}
-			c = conn
 		}
 	} else {
 		var nextURL *url.URL
 		var nextStream mux.MuxStream
 		next := creq.Hops[0]
 		nextHops := creq.Hops[1:]
+		hopCtx, hopSpan := tracer.Start(spanCtx, "gsnova.hop", trace.WithAttributes(attribute.String("next", next)))
 		nextURL, err = url.Parse(next)
 		if nil == err {
-			nextStream, _, err = GetMuxStreamByURL(nextURL, ctx.auth.User, &DefaultServerCipher)
+			var nextConf *ProxyChannelConfig
+			nextStream, nextConf, err = GetMuxStreamByURL(nextURL, ctx.auth.User, &DefaultServerCipher)
 			if nil == err {
+				hopDialTimeout := creq.DialTimeout
+				if hopDialTimeout > 0 {
+					//leave the round trip to next hop out of the dial budget
+					hopDialTimeout -= int(ChannelRTT(nextURL.String()) / time.Millisecond)
+					if hopDialTimeout <= 0 {
+						hopDialTimeout = 1
+					}
+				}
 				opt := mux.StreamOptions{
-					DialTimeout: creq.DialTimeout,
-					ReadTimeout: creq.ReadTimeout,
-					Hops:        nextHops,
+					DialTimeout:  hopDialTimeout,
+					ReadTimeout:  creq.ReadTimeout,
+					Hops:         nextHops,
+					TraceContext: traceCarrier(hopCtx),
+					//errors of next hops are relayed back
+					WaitResponse: creq.Response && SupportConnectResponse(nextConf, nextStream),
 				}
 				err = nextStream.Connect(creq.Network, creq.Addr, opt)
 				if nil == err {
 					c = nextStream
 				} else {
-					logger.Error("[ERROR]:Failed to connect next:%s for reason:%v", next, err)
+					ctx.log(stream).Error("[ERROR]Failed to connect next:%s for reason:%v", next, err)
 				}
 			}
 		} else {
-			logger.Error("Failed to parse proxy url:%s with reason:%v", next, err)
+			ctx.log(stream).Error("Failed to parse proxy url:%s with reason:%v", next, err)
 		}
+		endSpan(hopSpan, err)
 	}
 
+	replyConnect(stream, creq, err)
 	if nil != err {
+		audit.reason = "connect failed:" + err.Error()
 		stream.Close()
 		return
 	}
 	streamReader, streamWriter := mux.GetCompressStreamReaderWriter(stream, ctx.auth.CompressMethod)
+	var recvSum *mux.ChecksumReader
+	var sentSum *mux.ChecksumWriter
+	if ctx.auth.StreamChecksum {
+		recvSum, sentSum = mux.NewChecksumReader(streamReader), mux.NewChecksumWriter(streamWriter)
+		streamReader, streamWriter = recvSum, sentSum
+	}
 	defer c.Close()
 	closeSig := make(chan bool, 1)
 
+	var recvBytes, sentBytes int64
+	start := time.Now()
+	defer ctx.trackStream(stream, c, creq.Network, creq.Addr, &recvBytes, &sentBytes)()
+	_, copySpan := tracer.Start(spanCtx, "gsnova.copy")
+	var streamCountReader, connReader io.Reader
+	var earlyReader io.Reader
+	streamCountReader = &countReader{&countReader{streamReader, &ctx.recvBytes}, &recvBytes}
+	connReader = &countReader{&countReader{c, &ctx.sentBytes}, &sentBytes}
+	earlyReader = &countReader{&countReader{bytes.NewReader(creq.EarlyData), &ctx.recvBytes}, &recvBytes}
+	if uc := getQuotaUser(ctx.auth.User); nil != uc {
+		streamCountReader = &quotaReader{streamCountReader, uc}
+		connReader = &quotaReader{connReader, uc}
+		earlyReader = &quotaReader{earlyReader, uc}
+	}
+	if len(creq.EarlyData) > 0 {
+		//early data is forwarded before any data read from the stream
+		_, err = io.Copy(c, earlyReader)
+		if nil != err {
+			ctx.log(stream).Error("[ERROR]Failed to write early data to %s for reason:%v", creq.Addr, err)
+			stream.Close()
+			return
+		}
+	}
 	go func() {
-		buf := make([]byte, 128*1024)
-		io.CopyBuffer(c, streamReader, buf)
+		buf := GetStreamBuffer()
+		defer PutStreamBuffer(buf)
+		_, err := io.CopyBuffer(c, streamCountReader, *buf)
+		if err == errQuotaExceeded {
+			c.Close()
+		}
 		closeSig <- true
 	}()
 
-	var connReader io.Reader
-	connReader = c
 	rateLimitBucket := getRateLimitBucket(ctx.auth.User)
 	if nil != rateLimitBucket {
-		connReader = ratelimit.Reader(c, rateLimitBucket)
+		connReader = ratelimit.Reader(connReader, rateLimitBucket)
 	}
 
-	buf := make([]byte, 128*1024)
+	buf := GetStreamBuffer()
+	defer PutStreamBuffer(buf)
 	for {
 		if d, ok := c.(DeadLineAccetor); ok {
 			d.SetReadDeadline(time.Now().Add(maxIdleTime))
 		}
-		_, err := io.CopyBuffer(streamWriter, connReader, buf)
+		_, err := io.CopyBuffer(streamWriter, connReader, *buf)
+		if isKeepAliveErr(err) {
+			//half-open target dropped by keepalive probes, checked first since it's a timeout error too
+			ctx.log(stream).Notice("Stream to %s reset since keepalive probes of the target failed", creq.Addr)
+			audit.reason = "target keepalive failed"
+			ctx.sendControl(&mux.ControlMessage{Type: mux.ControlStreamReset, StreamID: stream.StreamID(),
+				Addr: creq.Addr, Reason: err.Error()})
+			c.Close()
+			stream.Close()
+			break
+		}
 		if isTimeoutErr(err) && time.Now().Sub(stream.LatestIOTime()) < maxIdleTime {
 			continue
 		}
+		if err == errQuotaExceeded {
+			ctx.log(stream).Notice("Stream to %s cut since traffic quota exceeded", creq.Addr)
+			pushQuotaStatus(ctx.auth.User)
+			audit.reason = "quota exceeded"
+		} else if isTimeoutErr(err) {
+			audit.reason = "idle timeout"
+		}
 		c.Close()
 		stream.Close()
 		break
 	}
 	<-closeSig
+	copySpan.SetAttributes(attribute.Int64("recv_bytes", atomic.LoadInt64(&recvBytes)), attribute.Int64("sent_bytes", atomic.LoadInt64(&sentBytes)))
+	audit.up, audit.down = atomic.LoadInt64(&recvBytes), atomic.LoadInt64(&sentBytes)
+	copySpan.End()
 	if close, ok := streamWriter.(io.Closer); ok {
 		close.Close()
 	}
 	if close, ok := streamReader.(io.Closer); ok {
 		close.Close()
 	}
+	if ctx.auth.StreamChecksum {
+		ctx.sendControl(&mux.ControlMessage{Type: mux.ControlStreamChecksum, StreamID: stream.StreamID(), Recv: recvSum.Sum(), Sent: sentSum.Sum()})
+	}
+	ctx.log(stream).WithFields(logger.Fields{
+		"target":     creq.Addr,
+		"recv_bytes": atomic.LoadInt64(&recvBytes),
+		"sent_bytes": atomic.LoadInt64(&sentBytes),
+	}).Info("Stream to %s closed after %v", creq.Addr, time.Now().Sub(start))
 }
 
 var DefaultServerCipher CipherConfig
 
+func rejectAuth(session mux.MuxSession, stream mux.MuxStream, code int, reason string) {
+	authRes := &mux.AuthResponse{
+		Code:   code,
+		Reason: reason,
+	}
+	stream.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	mux.WriteMessage(stream, authRes)
+	stream.Close()
+	session.Close()
+}
+
 func ServProxyMuxSession(session mux.MuxSession, auth *mux.AuthRequest) error {
+	return servMuxSession(newSessionContext(session, auth))
+}
+
+// servReverseMuxSession serve streams opened by server on the client session for reverse tunnels
+func servReverseMuxSession(session mux.MuxSession, auth *mux.AuthRequest) error {
+	ctx := newSessionContext(session, auth)
+	ctx.reverseOnly = true
+	return servMuxSession(ctx)
+}
+
+func newSessionContext(session mux.MuxSession, auth *mux.AuthRequest) *sessionContext {
 	ctx := &sessionContext{}
 	ctx.auth = auth
 	ctx.activeIOTime = time.Now()
 	ctx.session = session
+	return ctx
+}
+
+func servMuxSession(ctx *sessionContext) error {
+	session := ctx.session
+	ctx.touch()
+	liveSessions.Store(ctx, true)
 	defer ctx.close()
+	if nil == ctx.auth && authBans.banned(ctx.sourceIP(), time.Now()) {
+		ctx.log(nil).Debug("Close session from banned ip")
+		return mux.ErrAuthFailed
+	}
 	for {
 		stream, err := session.AcceptStream()
 		if nil != err {
 			if err != pmux.ErrSessionShutdown {
-				logger.Error("Failed to accept stream with error:%v", err)
+				ctx.log(nil).Error("Failed to accept stream with error:%v", err)
 			}
 			return err
 		}
 		if nil == ctx.auth {
 			recvAuth, err := mux.ReadAuthRequest(stream)
 			if nil != err {
-				logger.Error("[ERROR]:Failed to read auth request:%v", err)
+				ctx.log(stream).Error("[ERROR]:Failed to read auth request:%v", err)
+				if onAuthFailure(ctx) {
+					return mux.ErrAuthFailed
+				}
 				continue
 			}
-			logger.Info("Recv auth:%v", recvAuth)
-			if !DefaultServerCipher.VerifyUser(recvAuth.User) {
+			if len(recvAuth.SessionID) == 0 {
+				//old clients do not carry session id
+				recvAuth.SessionID = helper.RandHexString(16)
+			}
+			authLog := ctx.log(nil).WithFields(logger.Fields{"session": recvAuth.SessionID, "user": recvAuth.User, "version": recvAuth.Version})
+			logged := *recvAuth
+			if len(logged.Credential) > 0 {
+				//never log passwords & tokens
+				logged.Credential = "***"
+			}
+			authLog.Info("Recv auth:%v", &logged)
+			if !DefaultServerCipher.VerifyUser(recvAuth.User, recvAuth.TOTP) {
+				onAuthFailure(ctx)
+				session.Close()
+				return mux.ErrAuthFailed
+			}
+			if !IsValidCipherMethod(recvAuth.CipherMethod) {
+				authLog.Error("[ERROR]Invalid cipher method:%s", recvAuth.CipherMethod)
 				session.Close()
 				return mux.ErrAuthFailed
 			}
 			if !mux.IsValidCompressor(recvAuth.CompressMethod) {
-				logger.Error("[ERROR]Invalid compressor:%s", recvAuth.CompressMethod)
+				authLog.Error("[ERROR]Invalid compressor:%s", recvAuth.CompressMethod)
 				session.Close()
 				return mux.ErrAuthFailed
 			}
-			ctx.auth = recvAuth
+			if reason := authNonces.check(recvAuth); len(reason) > 0 {
+				authLog.Error("[ERROR]Reject auth from user:%s for reason:%s", recvAuth.User, reason)
+				rejectAuth(session, stream, mux.AuthRejected, reason)
+				return mux.ErrAuthFailed
+			}
+			if reason := getClientVersionLimit().check(recvAuth); len(reason) > 0 {
+				authLog.Notice("Reject session:%s from user:%s for reason:%s", recvAuth.SessionID, recvAuth.User, reason)
+				rejectAuth(session, stream, mux.AuthVersionRejected, reason)
+				return mux.ErrAuthFailed
+			}
+			if reason := verifyByAuthProvider(recvAuth); len(reason) > 0 {
+				authLog.Error("[ERROR]Reject auth from user:%s for reason:%s", recvAuth.User, reason)
+				onAuthFailure(ctx)
+				rejectAuth(session, stream, mux.AuthRejected, reason)
+				return mux.ErrAuthFailed
+			}
+			sessionKey, reason := verifyUserKey(recvAuth)
+			if len(reason) > 0 {
+				authLog.Error("[ERROR]Reject auth from user:%s for reason:%s", recvAuth.User, reason)
+				onAuthFailure(ctx)
+				rejectAuth(session, stream, mux.AuthRejected, reason)
+				return mux.ErrAuthFailed
+			}
+			//schedule & quota of the user are only reported to clients proved the user key
+			if allowed, reason := allowedBySchedule(recvAuth.User); !allowed {
+				authLog.Notice("Reject session:%s from user:%s for reason:%s", recvAuth.SessionID, recvAuth.User, reason)
+				rejectAuth(session, stream, mux.AuthScheduleRejected, reason)
+				return mux.ErrAuthFailed
+			}
+			if allowed, reason := allowedByQuota(recvAuth.User); !allowed {
+				authLog.Notice("Reject session:%s from user:%s for reason:%s", recvAuth.SessionID, recvAuth.User, reason)
+				rejectAuth(session, stream, mux.AuthQuotaRejected, reason)
+				return mux.ErrAuthFailed
+			}
+			if code, reason := admitSession(ctx, recvAuth); len(reason) > 0 {
+				authLog.Notice("Reject session:%s from user:%s for reason:%s", recvAuth.SessionID, recvAuth.User, reason)
+				rejectAuth(session, stream, code, reason)
+				return mux.ErrAuthFailed
+			}
 			if len(recvAuth.P2SPRoomId) > 0 {
 				if !addP2spSession(recvAuth.P2SPRoomId, recvAuth.P2SPConnId, session) {
 					session.Close()
 					return mux.ErrAuthFailed
 				}
 				ctx.isP2SP = true
+				if addr := p2spRelayAddr(recvAuth.P2SPRelayAddr, ctx); len(addr) > 0 {
+					addP2spRelay(recvAuth.P2SPRoomId, recvAuth.P2SPConnId, addr)
+				}
 			}
+			ctx.log(nil).Info("Session authed from %s", ctx.remoteAddr())
 			authRes := &mux.AuthResponse{
-				Code: mux.AuthOK,
+				Code:          mux.AuthOK,
+				ProtocolLevel: mux.ProtocolLevel,
 			}
 			mux.WriteMessage(stream, authRes)
 			stream.Close()
 			if tmp, ok := session.(*mux.ProxyMuxSession); ok {
-				tmp.Session.ResetCryptoContext(recvAuth.CipherMethod, recvAuth.CipherCounter)
+				tmp.ResetCryptoContextWithKey(sessionKey, recvAuth.CipherMethod, recvAuth.CipherCounter)
+				tmp.MaxFrameSize = clampFrameSize(recvAuth.MaxFrameSize)
+				if muxMaxFrameSize > 0 && (tmp.MaxFrameSize <= 0 || tmp.MaxFrameSize > muxMaxFrameSize) {
+					tmp.MaxFrameSize = muxMaxFrameSize
+				}
 			}
 			continue
 		}
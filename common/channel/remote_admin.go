@@ -0,0 +1,227 @@
+package channel
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/store"
+)
+
+var liveSessions sync.Map
+
+// SessionInfo is the snapshot of a live server side session
+type SessionInfo struct {
+	ID         string
+	User       string
+	RemoteAddr string
+	Streams    int32
+	RecvBytes  int64
+	SentBytes  int64
+	IdleSecs   int64
+	UpSecs     int64
+}
+
+// RateLimitBucketInfo is the state of a user's rate limit bucket
+type RateLimitBucketInfo struct {
+	User      string
+	Rate      float64
+	Capacity  int64
+	Available int64
+}
+
+type countReader struct {
+	io.Reader
+	n *int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		atomic.AddInt64(r.n, int64(n))
+	}
+	return n, err
+}
+
+func (ctx *sessionContext) touch() {
+	atomic.StoreInt64(&ctx.lastActive, time.Now().UnixNano())
+}
+
+func (ctx *sessionContext) remoteAddr() string {
+	if s, ok := ctx.session.(interface {
+		RemoteAddr() net.Addr
+	}); ok && nil != s.RemoteAddr() {
+		return s.RemoteAddr().String()
+	}
+	return ""
+}
+
+func (ctx *sessionContext) info() SessionInfo {
+	now := time.Now()
+	info := SessionInfo{
+		ID:         ctx.sessionID(),
+		User:       ctx.auth.User,
+		RemoteAddr: ctx.remoteAddr(),
+		Streams:    atomic.LoadInt32(&ctx.streamCouter),
+		RecvBytes:  atomic.LoadInt64(&ctx.recvBytes),
+		SentBytes:  atomic.LoadInt64(&ctx.sentBytes),
+		UpSecs:     int64(now.Sub(ctx.activeIOTime) / time.Second),
+	}
+	info.IdleSecs = int64(now.Sub(time.Unix(0, atomic.LoadInt64(&ctx.lastActive))) / time.Second)
+	if info.Streams > 0 {
+		info.IdleSecs = 0
+	}
+	return info
+}
+
+// bytes of closed sessions, bytes of live sessions are summed on demand
+var closedRecvBytes, closedSentBytes int64
+
+// bytes of previous runs loaded from the store
+var persistedRecvBytes, persistedSentBytes int64
+
+const statsStoreBucket = "stats"
+const trafficStatsKey = "traffic"
+
+var trafficStatsTaskOnce sync.Once
+
+// TrafficStats is the aggregate traffic of all sessions without per user data
+type TrafficStats struct {
+	Sessions  int
+	Streams   int
+	RecvBytes int64
+	SentBytes int64
+	//bytes including previous runs of the server, persisted in the store
+	TotalRecvBytes int64
+	TotalSentBytes int64
+}
+
+// GetTrafficStats return live sessions & streams, and bytes of all sessions since server started
+func GetTrafficStats() TrafficStats {
+	st := TrafficStats{
+		RecvBytes: atomic.LoadInt64(&closedRecvBytes),
+		SentBytes: atomic.LoadInt64(&closedSentBytes),
+	}
+	rangeLiveSessions(func(ctx *sessionContext) bool {
+		st.Sessions++
+		st.Streams += int(atomic.LoadInt32(&ctx.streamCouter))
+		st.RecvBytes += atomic.LoadInt64(&ctx.recvBytes)
+		st.SentBytes += atomic.LoadInt64(&ctx.sentBytes)
+		return true
+	})
+	st.TotalRecvBytes = atomic.LoadInt64(&persistedRecvBytes) + st.RecvBytes
+	st.TotalSentBytes = atomic.LoadInt64(&persistedSentBytes) + st.SentBytes
+	return st
+}
+
+type persistedTrafficStats struct {
+	RecvBytes int64
+	SentBytes int64
+}
+
+func saveTrafficStats() {
+	st := GetTrafficStats()
+	b, _ := json.Marshal(&persistedTrafficStats{RecvBytes: st.TotalRecvBytes, SentBytes: st.TotalSentBytes})
+	if err := store.Default().Put(statsStoreBucket, trafficStatsKey, b); nil != err {
+		logger.Error("[ERROR]Failed to save traffic stats with reason:%v", err)
+	}
+}
+
+// LoadTrafficStats restore the total traffic of previous runs from the store & save it every minute
+func LoadTrafficStats() {
+	var st persistedTrafficStats
+	if b, err := store.Default().Get(statsStoreBucket, trafficStatsKey); nil == err {
+		json.Unmarshal(b, &st)
+	}
+	atomic.StoreInt64(&persistedRecvBytes, st.RecvBytes)
+	atomic.StoreInt64(&persistedSentBytes, st.SentBytes)
+	trafficStatsTaskOnce.Do(func() {
+		go func() {
+			for range time.Tick(time.Minute) {
+				saveTrafficStats()
+			}
+		}()
+	})
+}
+
+func rangeLiveSessions(f func(ctx *sessionContext) bool) {
+	liveSessions.Range(func(key, value interface{}) bool {
+		ctx := key.(*sessionContext)
+		if nil == ctx.auth {
+			return true
+		}
+		return f(ctx)
+	})
+}
+
+// ListSessions return all authenticated sessions, or the sessions of the user if it's not empty
+func ListSessions(user string) []SessionInfo {
+	var infos []SessionInfo
+	rangeLiveSessions(func(ctx *sessionContext) bool {
+		if len(user) == 0 || ctx.auth.User == user {
+			infos = append(infos, ctx.info())
+		}
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UpSecs > infos[j].UpSecs
+	})
+	return infos
+}
+
+func kickSession(ctx *sessionContext) {
+	ctx.sendControl(&mux.ControlMessage{Type: mux.ControlSessionClosing, Reason: "kicked"})
+	ctx.close()
+}
+
+// CloseSession force close the session by its id
+func CloseSession(id string) bool {
+	found := false
+	rangeLiveSessions(func(ctx *sessionContext) bool {
+		if ctx.sessionID() == id {
+			kickSession(ctx)
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// CloseUserSessions force close all sessions of the user, return the closed session count
+func CloseUserSessions(user string) int {
+	n := 0
+	rangeLiveSessions(func(ctx *sessionContext) bool {
+		if ctx.auth.User == user {
+			kickSession(ctx)
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// DumpRateLimitBuckets return the state of created rate limit buckets
+func DumpRateLimitBuckets() []RateLimitBucketInfo {
+	rateLimitBucketLock.Lock()
+	defer rateLimitBucketLock.Unlock()
+	infos := make([]RateLimitBucketInfo, 0, len(rateLimitBuckets))
+	for user, bucket := range rateLimitBuckets {
+		infos = append(infos, RateLimitBucketInfo{
+			User:      user,
+			Rate:      bucket.Rate(),
+			Capacity:  bucket.Capacity(),
+			Available: bucket.Available(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].User < infos[j].User
+	})
+	return infos
+}
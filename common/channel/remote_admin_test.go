@@ -0,0 +1,160 @@
+package channel
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// testSessionIDs return ids of the sessions of the user
+func testSessionIDs(user string) []string {
+	var ids []string
+	for _, info := range ListSessions(user) {
+		ids = append(ids, info.ID)
+	}
+	return ids
+}
+
+func TestAdminListAndCloseSessions(t *testing.T) {
+	for _, c := range []struct{ user, id string }{{"admin-alice", "admin-s1"}, {"admin-alice", "admin-s2"}, {"admin-bob", "admin-s3"}} {
+		session, err := authTestSession(t, &mux.AuthRequest{User: c.user, SessionID: c.id, ProtocolLevel: mux.ProtocolLevel})
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer session.Close()
+	}
+	ids := testSessionIDs("admin-alice")
+	if len(ids) != 2 {
+		t.Fatalf("expect 2 sessions of admin-alice, but got %v", ids)
+	}
+	if !CloseSession("admin-s1") {
+		t.Errorf("expect session admin-s1 closed")
+	}
+	if CloseSession("admin-missing") {
+		t.Errorf("unknown session should not be closed")
+	}
+	if ids = testSessionIDs("admin-alice"); !reflect.DeepEqual(ids, []string{"admin-s2"}) {
+		t.Errorf("expect only session admin-s2 left, but got %v", ids)
+	}
+	if n := CloseUserSessions("admin-bob"); n != 1 {
+		t.Errorf("expect 1 session of admin-bob closed, but got %d", n)
+	}
+	if ids = testSessionIDs("admin-bob"); len(ids) != 0 {
+		t.Errorf("expect no session of admin-bob, but got %v", ids)
+	}
+	CloseUserSessions("admin-alice")
+}
+
+func TestDumpRateLimitBuckets(t *testing.T) {
+	defer SetServerRateLimit(RateLimitConfig{})
+	SetServerRateLimit(RateLimitConfig{Limit: map[string]string{"limited": "1MB", "*": "2MB", "unlimited": "-1"}})
+	for _, user := range []string{"limited", "other", "another", "unlimited"} {
+		getRateLimitBucket(user)
+	}
+	buckets := DumpRateLimitBuckets()
+	if len(buckets) != 2 || buckets[0].User != "*" || buckets[1].User != "limited" {
+		t.Fatalf("expect buckets of '*' & limited, but got %+v", buckets)
+	}
+	if buckets[0].Capacity != 2*1024*1024 || buckets[1].Capacity != 1024*1024 {
+		t.Errorf("unexpected bucket capacities:%+v", buckets)
+	}
+}
+
+func TestStreamFilterMatch(t *testing.T) {
+	now := time.Now()
+	s := &activeStream{ctx: newSessionContext(nil, &mux.AuthRequest{User: "alice", SessionID: "s1"}), addr: "www.Example.com:443", start: now.Add(-time.Minute)}
+	tests := []struct {
+		filter StreamFilter
+		match  bool
+	}{
+		{StreamFilter{}, true},
+		{StreamFilter{Session: "s1"}, true},
+		{StreamFilter{Session: "s2"}, false},
+		{StreamFilter{User: "alice"}, true},
+		{StreamFilter{User: "bob"}, false},
+		{StreamFilter{Addr: "www.example.com:443"}, true},
+		{StreamFilter{Addr: "www.example.com"}, true},
+		{StreamFilter{Addr: "example.com"}, true},
+		{StreamFilter{Addr: "ample.com"}, false},
+		{StreamFilter{Addr: "www.example.com:80"}, false},
+		{StreamFilter{MinAgeSecs: 60}, true},
+		{StreamFilter{MinAgeSecs: 61}, false},
+		{StreamFilter{User: "alice", Addr: "other.com"}, false},
+	}
+	for _, tt := range tests {
+		if match := tt.filter.match(s, now); match != tt.match {
+			t.Errorf("%+v: expect match %v, but got %v", tt.filter, tt.match, match)
+		}
+	}
+}
+
+func TestAdminListAndCloseStreams(t *testing.T) {
+	//the target keep the connection until closed by server
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if nil != err {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+	session, err := authTestSession(t, &mux.AuthRequest{User: "stream-alice", SessionID: "stream-s1", ProtocolLevel: mux.ProtocolLevel})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var streams []mux.MuxStream
+	for i := 0; i < 2; i++ {
+		stream, err := session.OpenStream()
+		if nil != err {
+			t.Fatal(err)
+		}
+		if err = stream.Connect("tcp", target.Addr().String(), mux.StreamOptions{}); nil != err {
+			t.Fatal(err)
+		}
+		streams = append(streams, stream)
+	}
+	f := StreamFilter{User: "stream-alice"}
+	if !waitUntil(5*time.Second, func() bool { return len(ListStreams(f)) == 2 }) {
+		t.Fatalf("expect 2 streams of stream-alice, but got %v", ListStreams(f))
+	}
+	for _, info := range ListStreams(f) {
+		if info.Session != "stream-s1" || info.Network != "tcp" || info.Addr != target.Addr().String() {
+			t.Errorf("unexpected stream info:%+v", info)
+		}
+	}
+	if n := CloseStreams(StreamFilter{}); n != 0 {
+		t.Errorf("expect no stream closed by empty filter, but got %d", n)
+	}
+	if n := CloseStreams(StreamFilter{Session: "stream-s1", User: "stream-bob"}); n != 0 {
+		t.Errorf("expect no stream closed of unmatched filter, but got %d", n)
+	}
+	if n := CloseStreams(f); n != 2 {
+		t.Errorf("expect 2 streams closed, but got %d", n)
+	}
+	//clients close their side once streams are closed by server
+	for _, stream := range streams {
+		stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err = stream.Read(make([]byte, 1)); nil == err {
+			t.Errorf("expect closed stream")
+		}
+		stream.Close()
+	}
+	if !waitUntil(5*time.Second, func() bool { return len(ListStreams(f)) == 0 }) {
+		t.Errorf("expect no stream left, but got %v", ListStreams(f))
+	}
+	//the session is kept for new streams
+	if _, err = session.OpenStream(); nil != err {
+		t.Errorf("expect session kept, but got %v", err)
+	}
+}
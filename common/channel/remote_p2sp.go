@@ -2,6 +2,7 @@ package channel
 
 import (
 	"io"
+	"net"
 	"sync"
 
 	"github.com/yinqiwen/gsnova/common/logger"
@@ -11,6 +12,9 @@ import (
 var p2spSessionTable = make(map[string]map[string]map[mux.MuxSession]bool)
 var p2spSessionMutex sync.Mutex
 
+// relay address advertised by room members, room -> conn id -> addr
+var p2spRelayTable = make(map[string]map[string]string)
+
 func addP2spSession(roomID string, cid string, session mux.MuxSession) bool {
 	p2spSessionMutex.Lock()
 	defer p2spSessionMutex.Unlock()
@@ -48,6 +52,12 @@ func removeP2spSession(roomID string, cid string, session mux.MuxSession) bool {
 	delete(sessions, session)
 	if len(sessions) == 0 {
 		delete(m1, cid)
+		if relays, exist := p2spRelayTable[roomID]; exist {
+			delete(relays, cid)
+			if len(relays) == 0 {
+				delete(p2spRelayTable, roomID)
+			}
+		}
 		logger.Info("P2SP Room:%s have %d members, '%s' just exit.", roomID, len(m1), cid)
 		if len(m1) == 0 {
 			delete(p2spSessionTable, roomID)
@@ -81,12 +91,73 @@ func openPeerStream(roomID string, cid string) (mux.MuxStream, bool) {
 	return nil, false
 }
 
+func addP2spRelay(roomID string, cid string, addr string) {
+	p2spSessionMutex.Lock()
+	defer p2spSessionMutex.Unlock()
+	relays, exist := p2spRelayTable[roomID]
+	if !exist {
+		relays = make(map[string]string)
+		p2spRelayTable[roomID] = relays
+	}
+	relays[cid] = addr
+	logger.Info("P2SP Room:%s member '%s' opened relay:%s", roomID, cid, addr)
+}
+
+func getP2spRelays(roomID string, cid string) []string {
+	p2spSessionMutex.Lock()
+	defer p2spSessionMutex.Unlock()
+	var addrs []string
+	for connID, addr := range p2spRelayTable[roomID] {
+		if connID != cid {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// p2spRelayAddr fill the host of the advertised relay address with the remote ip of the member
+func p2spRelayAddr(addr string, ctx *sessionContext) string {
+	host, port, err := net.SplitHostPort(addr)
+	if nil != err {
+		return ""
+	}
+	if len(host) == 0 || net.ParseIP(host).IsUnspecified() {
+		remoteHost, _, err := net.SplitHostPort(ctx.remoteAddr())
+		if nil != err {
+			return ""
+		}
+		host = remoteHost
+	}
+	return net.JoinHostPort(host, port)
+}
+
 func handleP2spProxyStream(stream mux.MuxStream, ctx *sessionContext) {
+	creq, err := mux.ReadConnectRequest(stream)
+	if nil != err {
+		stream.Close()
+		logger.Error("[ERROR]:Failed to read connect request:%v", err)
+		return
+	}
+	switch creq.Network {
+	case mux.ControlNetwork:
+		ctx.setControlStream(stream)
+		return
+	case mux.P2SPRelaysNetwork:
+		mux.WriteMessage(stream, &mux.P2SPRelayList{Addrs: getP2spRelays(ctx.auth.P2SPRoomId, ctx.auth.P2SPConnId)})
+		stream.Close()
+		return
+	}
 	peerStream, success := openPeerStream(ctx.auth.P2SPRoomId, ctx.auth.P2SPConnId)
 	if !success {
 		stream.Close()
 		return
 	}
+	//peer would read the connect request again
+	if err = mux.WriteMessage(peerStream, creq); nil != err {
+		stream.Close()
+		peerStream.Close()
+		return
+	}
 	closeSig := make(chan bool, 1)
 	go func() {
 		io.Copy(stream, peerStream)
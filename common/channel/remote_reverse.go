@@ -0,0 +1,341 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// reverseTunnel is registered by client with a listen address like ':8080', or a hostname
+// served by the shared reverse http listener.
+type reverseTunnel struct {
+	ctx  *sessionContext
+	name string
+	lp   net.Listener
+}
+
+var reverseHosts = make(map[string]*reverseTunnel)
+var reverseListens = make(map[string]*reverseTunnel)
+var reverseHostsMutex sync.Mutex
+
+// port of the reverse http listener & public addresses of the server, for hairpin access
+var reverseHTTPPort string
+var hairpinHosts = make(map[string]bool)
+
+// SetReverseHairpinHosts set the public hostnames/ips of the server, streams to reverse tunnels through them
+// are routed over the mux sessions directly instead of out & back through the public endpoint
+func SetReverseHairpinHosts(hosts []string) {
+	m := make(map[string]bool)
+	for _, h := range hosts {
+		m[strings.ToLower(h)] = true
+	}
+	reverseHostsMutex.Lock()
+	hairpinHosts = m
+	reverseHostsMutex.Unlock()
+}
+
+func isLocalInterfaceIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if nil != err {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupHairpinTunnel return the reverse tunnel exposed by 'addr' through this server, the tunnel must be
+// registered by the same user or a client in the same P2SP room
+func lookupHairpinTunnel(ctx *sessionContext, network string, addr string) *reverseTunnel {
+	if network != "tcp" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if nil != err {
+		return nil
+	}
+	host = strings.ToLower(host)
+	reverseHostsMutex.Lock()
+	t := reverseHosts[host]
+	if nil != t && port != reverseHTTPPort {
+		t = nil
+	}
+	if nil == t && nil != reverseListens[port] {
+		if ip := net.ParseIP(host); hairpinHosts[host] || (nil != ip && isLocalInterfaceIP(ip)) {
+			t = reverseListens[port]
+		}
+	}
+	reverseHostsMutex.Unlock()
+	if nil == t {
+		return nil
+	}
+	if t.ctx.auth.User == ctx.auth.User || (len(ctx.auth.P2SPRoomId) > 0 && t.ctx.auth.P2SPRoomId == ctx.auth.P2SPRoomId) {
+		return t
+	}
+	return nil
+}
+
+// hairpinStream is a reverse stream relaying a stream of another client, traffic is counted into
+// the quota of the tunnel owner like streams of its listen tunnels
+type hairpinStream struct {
+	mux.MuxStream
+	r  io.Reader
+	w  io.Writer
+	uc *UserConfig
+}
+
+func (s *hairpinStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 && nil != s.uc && !addQuotaUsage(s.uc, int64(n)) {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+func (s *hairpinStream) Write(p []byte) (int, error) {
+	if nil != s.uc && !addQuotaUsage(s.uc, int64(len(p))) {
+		return 0, errQuotaExceeded
+	}
+	return s.w.Write(p)
+}
+
+func (s *hairpinStream) Close() error {
+	if close, ok := s.w.(io.Closer); ok {
+		close.Close()
+	}
+	err := s.MuxStream.Close()
+	if close, ok := s.r.(io.Closer); ok {
+		close.Close()
+	}
+	return err
+}
+
+// open a stream back to the client registered the tunnel
+func (t *reverseTunnel) open() (*hairpinStream, error) {
+	stream, err := t.ctx.session.OpenStream()
+	if nil != err {
+		return nil, err
+	}
+	if err = stream.Connect(mux.ReverseNetwork, t.name, mux.StreamOptions{}); nil != err {
+		stream.Close()
+		return nil, err
+	}
+	r, w := mux.GetCompressStreamReaderWriter(stream, t.ctx.auth.CompressMethod)
+	return &hairpinStream{MuxStream: stream, r: r, w: w, uc: getQuotaUser(t.ctx.auth.User)}, nil
+}
+
+// allowedReverse check the registration by user's 'Reverse' rules, ports/port ranges for listen
+// addresses and patterns for hostnames, reverse tunnel is not allowed if no rule configured.
+func allowedReverse(user string, addr string) bool {
+	uc := getUserConfig(user)
+	if nil == uc {
+		return false
+	}
+	_, portStr, err := net.SplitHostPort(addr)
+	for _, rule := range uc.Reverse {
+		if nil == err {
+			port, perr := strconv.Atoi(portStr)
+			if nil == perr && matchPort(rule, port) {
+				return true
+			}
+		} else if matched, _ := filepath.Match(strings.ToLower(rule), strings.ToLower(addr)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// forward open a stream back to the client for the inbound connection
+func (t *reverseTunnel) forward(conn net.Conn) {
+	defer conn.Close()
+	stream, err := t.ctx.session.OpenStream()
+	if nil != err {
+		t.ctx.log(nil).Error("[ERROR]Failed to open reverse stream for %s with reason:%v", t.name, err)
+		return
+	}
+	defer stream.Close()
+	if err = stream.Connect(mux.ReverseNetwork, t.name, mux.StreamOptions{}); nil != err {
+		return
+	}
+	//traffic of the inbound connection is sent to & received from the client
+	var recvBytes, sentBytes int64
+	defer t.ctx.trackStream(stream, conn, mux.ReverseNetwork, t.name, &recvBytes, &sentBytes)()
+	streamReader, streamWriter := mux.GetCompressStreamReaderWriter(stream, t.ctx.auth.CompressMethod)
+	closeSig := make(chan bool, 1)
+	go func() {
+		io.Copy(conn, withQuota(t.ctx.auth.User, &countReader{&countReader{streamReader, &t.ctx.recvBytes}, &recvBytes}))
+		conn.Close()
+		closeSig <- true
+	}()
+	if _, err = io.Copy(streamWriter, withQuota(t.ctx.auth.User, &countReader{&countReader{conn, &t.ctx.sentBytes}, &sentBytes})); err == errQuotaExceeded {
+		pushQuotaStatus(t.ctx.auth.User)
+	}
+	if close, ok := streamWriter.(io.Closer); ok {
+		close.Close()
+	}
+	stream.Close()
+	<-closeSig
+	if close, ok := streamReader.(io.Closer); ok {
+		close.Close()
+	}
+}
+
+func (t *reverseTunnel) serve() {
+	for {
+		conn, err := t.lp.Accept()
+		if nil != err {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+// handleReverseStream keep the reverse tunnel registered until the stream or session closed
+func handleReverseStream(stream mux.MuxStream, ctx *sessionContext, creq *mux.ConnectRequest) {
+	defer stream.Close()
+	if !allowedReverse(ctx.auth.User, creq.Addr) {
+		ctx.log(stream).Error("Reverse tunnel '%s' is NOT allowed for user:%s.", creq.Addr, ctx.auth.User)
+		return
+	}
+	t := &reverseTunnel{ctx: ctx, name: creq.Addr}
+	if _, port, err := net.SplitHostPort(creq.Addr); nil == err {
+		t.lp, err = net.Listen("tcp", creq.Addr)
+		if nil != err {
+			ctx.log(stream).Error("[ERROR]Failed to listen reverse tunnel %s with reason:%v", creq.Addr, err)
+			return
+		}
+		defer t.lp.Close()
+		go t.serve()
+		reverseHostsMutex.Lock()
+		reverseListens[port] = t
+		reverseHostsMutex.Unlock()
+		defer func() {
+			reverseHostsMutex.Lock()
+			if reverseListens[port] == t {
+				delete(reverseListens, port)
+			}
+			reverseHostsMutex.Unlock()
+		}()
+	} else {
+		host := strings.ToLower(creq.Addr)
+		reverseHostsMutex.Lock()
+		_, exist := reverseHosts[host]
+		if !exist {
+			reverseHosts[host] = t
+		}
+		reverseHostsMutex.Unlock()
+		if exist {
+			ctx.log(stream).Error("[ERROR]Reverse tunnel host:%s already registered", host)
+			return
+		}
+		defer func() {
+			reverseHostsMutex.Lock()
+			if reverseHosts[host] == t {
+				delete(reverseHosts, host)
+			}
+			reverseHostsMutex.Unlock()
+		}()
+	}
+	ctx.log(stream).Notice("Reverse tunnel %s registered", creq.Addr)
+	//closing the registration stream unregister the tunnel, traffic is counted by forwarded streams
+	var recvBytes, sentBytes int64
+	defer ctx.trackStream(stream, nil, mux.ReverseNetwork, creq.Addr, &recvBytes, &sentBytes)()
+	b := make([]byte, 1)
+	for {
+		stream.SetReadDeadline(time.Now().Add(24 * time.Hour))
+		if _, err := stream.Read(b); nil != err && !isTimeoutErr(err) {
+			break
+		}
+	}
+	ctx.log(stream).Notice("Reverse tunnel %s unregistered", creq.Addr)
+}
+
+func lookupReverseHost(host string) *reverseTunnel {
+	if h, _, err := net.SplitHostPort(host); nil == err {
+		host = h
+	}
+	reverseHostsMutex.Lock()
+	defer reverseHostsMutex.Unlock()
+	return reverseHosts[strings.ToLower(host)]
+}
+
+// dialReverseHost open a stream to the reverse tunnel registered by the hostname of 'addr'
+func dialReverseHost(ctx context.Context, network, addr string) (net.Conn, error) {
+	t := lookupReverseHost(addr)
+	if nil == t {
+		return nil, fmt.Errorf("no reverse tunnel for %s", addr)
+	}
+	hs, err := t.open()
+	if nil != err {
+		t.ctx.log(nil).Error("[ERROR]Failed to open reverse stream for %s with reason:%v", t.name, err)
+		return nil, err
+	}
+	return &mux.MuxStreamConn{MuxStream: hs}, nil
+}
+
+// newReverseHTTPHandler route every request by 'Host', requests of a keep-alive connection may be
+// served by different tunnels
+func newReverseHTTPHandler() http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = req.Host
+		},
+		Transport: &http.Transport{
+			DialContext:        dialReverseHost,
+			DisableCompression: true,
+			IdleConnTimeout:    30 * time.Second,
+		},
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if nil == lookupReverseHost(req.Host) {
+			http.NotFound(w, req)
+			return
+		}
+		proxy.ServeHTTP(w, req)
+	})
+}
+
+// StartReverseHTTPServer route inbound http requests by 'Host' to reverse tunnels registered by hostname
+func StartReverseHTTPServer(addr string) error {
+	lp, err := net.Listen("tcp", addr)
+	if nil != err {
+		logger.Error("[ERROR]Failed to listen reverse http server:%s with reason:%v", addr, err)
+		return err
+	}
+	logger.Notice("Reverse http server listen on %s", addr)
+	if _, port, err := net.SplitHostPort(lp.Addr().String()); nil == err {
+		reverseHostsMutex.Lock()
+		reverseHTTPPort = port
+		reverseHostsMutex.Unlock()
+	}
+	server := &http.Server{
+		Handler:           newReverseHTTPHandler(),
+		ReadHeaderTimeout: 30 * time.Second,
+		ErrorLog:          log.New(ioutil.Discard, "", 0),
+	}
+	return server.Serve(lp)
+}
@@ -0,0 +1,323 @@
+package channel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+)
+
+// newTestSessionPair create a client & server mux session connected by loopback tcp
+func newTestSessionPair(t *testing.T) (mux.MuxSession, mux.MuxSession) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer lp.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := lp.Accept()
+		accepted <- c
+	}()
+	conn, err := net.Dial("tcp", lp.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	cfg := InitialPMuxConfig(&CipherConfig{Key: "reverse-test"})
+	client, err := pmux.Client(conn, cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+	server, err := pmux.Server(<-accepted, InitialPMuxConfig(&CipherConfig{Key: "reverse-test"}))
+	if nil != err {
+		t.Fatal(err)
+	}
+	return &mux.ProxyMuxSession{Session: client, Config: cfg}, &mux.ProxyMuxSession{Session: server, Config: cfg}
+}
+
+// newTestProxySession return the client of a session served by servMuxSession as the authed 'auth'
+func newTestProxySession(t *testing.T, auth *mux.AuthRequest) mux.MuxSession {
+	client, server := newTestSessionPair(t)
+	go servMuxSession(newSessionContext(server, auth))
+	return client
+}
+
+// pingTestStream connect a stream to the echo server 'addr' & check the echo, the stream is left open
+func pingTestStream(session mux.MuxSession, network, addr string) (mux.MuxStream, error) {
+	stream, err := session.OpenStream()
+	if nil != err {
+		return nil, err
+	}
+	if err = stream.Connect(network, addr, mux.StreamOptions{DialTimeout: 1000, WaitResponse: true}); nil == err {
+		_, err = stream.Write([]byte("ping"))
+	}
+	if nil == err {
+		stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 4)
+		if _, err = io.ReadFull(stream, b); nil == err && string(b) != "ping" {
+			err = fmt.Errorf("unexpected echo:%q", b)
+		}
+		stream.SetReadDeadline(time.Time{})
+	}
+	if nil != err {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func startEchoServer(t *testing.T) net.Listener {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := lp.Accept()
+			if nil != err {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return lp
+}
+
+func TestReverseOnlySessionRejectUnregisteredStreams(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	auth := &mux.AuthRequest{SessionID: "reverse-only-test", CompressMethod: mux.NoneCompressor}
+	reverseLocalAddrs.Store(auth.SessionID+"|:18080", echo.Addr().String())
+	defer reverseLocalAddrs.Delete(auth.SessionID + "|:18080")
+
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+	go servReverseMuxSession(client, auth)
+
+	relay := func(network, addr string) (string, error) {
+		stream, err := server.OpenStream()
+		if nil != err {
+			return "", err
+		}
+		defer stream.Close()
+		if err = stream.Connect(network, addr, mux.StreamOptions{}); nil != err {
+			return "", err
+		}
+		if _, err = stream.Write([]byte("ping")); nil != err {
+			return "", err
+		}
+		stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 4)
+		_, err = io.ReadFull(stream, b)
+		return string(b), err
+	}
+	for _, c := range []struct{ network, addr string }{
+		{"tcp", echo.Addr().String()},
+		{mux.ReverseNetwork, ":18081"},
+		{"tcp", ":18080"},
+	} {
+		if s, err := relay(c.network, c.addr); nil == err {
+			t.Errorf("%s stream to %s should be rejected, but relayed %q", c.network, c.addr, s)
+		}
+	}
+	if s, err := relay(mux.ReverseNetwork, ":18080"); nil != err || s != "ping" {
+		t.Errorf("registered reverse tunnel not relayed, got %q, %v", s, err)
+	}
+}
+
+func TestReverseHTTPRouteKeepAliveRequestsByHost(t *testing.T) {
+	auth := &mux.AuthRequest{SessionID: "reverse-http-test", CompressMethod: mux.NoneCompressor}
+	client, server := newTestSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+	go servReverseMuxSession(client, auth)
+
+	ctx := &sessionContext{auth: auth, session: server}
+	for _, name := range []string{"a.reverse.test", "b.reverse.test"} {
+		backend := httptest.NewServer(http.HandlerFunc(func(name string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+			}
+		}(name)))
+		defer backend.Close()
+		key := auth.SessionID + "|" + name
+		reverseLocalAddrs.Store(key, backend.Listener.Addr().String())
+		defer reverseLocalAddrs.Delete(key)
+		reverseHostsMutex.Lock()
+		reverseHosts[name] = &reverseTunnel{ctx: ctx, name: name}
+		reverseHostsMutex.Unlock()
+		defer func(name string) {
+			reverseHostsMutex.Lock()
+			delete(reverseHosts, name)
+			reverseHostsMutex.Unlock()
+		}(name)
+	}
+
+	front := httptest.NewServer(newReverseHTTPHandler())
+	defer front.Close()
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	br := bufio.NewReader(conn)
+	//requests of one keep-alive connection are routed by their own 'Host'
+	for i, c := range []struct{ host, path, expected string }{
+		{"a.reverse.test", "/1", "a.reverse.test /1"},
+		{"b.reverse.test:80", "/2", "b.reverse.test /2"},
+		{"A.Reverse.Test", "/3", "a.reverse.test /3"},
+		{"c.reverse.test", "/4", ""},
+	} {
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", c.path, c.host)
+		res, err := http.ReadResponse(br, nil)
+		if nil != err {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if len(c.expected) == 0 {
+			if res.StatusCode != http.StatusNotFound {
+				t.Errorf("request %d to unregistered host got status %d", i, res.StatusCode)
+			}
+			continue
+		}
+		if res.StatusCode != http.StatusOK || string(body) != c.expected {
+			t.Errorf("request %d got %d %q, expected %q", i, res.StatusCode, body, c.expected)
+		}
+	}
+}
+
+func TestLookupHairpinTunnel(t *testing.T) {
+	owner := &sessionContext{auth: &mux.AuthRequest{User: "alice", P2SPRoomId: "room1"}}
+	reverseHostsMutex.Lock()
+	reverseHosts["web.hairpin.test"] = &reverseTunnel{ctx: owner, name: "web.hairpin.test"}
+	reverseListens["18090"] = &reverseTunnel{ctx: owner, name: ":18090"}
+	prevPort := reverseHTTPPort
+	reverseHTTPPort = "18443"
+	reverseHostsMutex.Unlock()
+	SetReverseHairpinHosts([]string{"Proxy.Hairpin.Test"})
+	defer func() {
+		reverseHostsMutex.Lock()
+		delete(reverseHosts, "web.hairpin.test")
+		delete(reverseListens, "18090")
+		reverseHTTPPort = prevPort
+		reverseHostsMutex.Unlock()
+		SetReverseHairpinHosts(nil)
+	}()
+
+	for _, c := range []struct {
+		user, room, network, addr string
+		expected                  string
+	}{
+		{"alice", "", "tcp", "web.hairpin.test:18443", "web.hairpin.test"},
+		{"alice", "", "tcp", "WEB.hairpin.test:18443", "web.hairpin.test"},
+		{"alice", "", "tcp", "web.hairpin.test:80", ""},
+		{"alice", "", "tcp", "127.0.0.1:18090", ":18090"},
+		{"alice", "", "tcp", "proxy.hairpin.test:18090", ":18090"},
+		{"alice", "", "tcp", "203.0.113.9:18090", ""},
+		{"alice", "", "tcp", "127.0.0.1:18091", ""},
+		{"alice", "", "udp", "127.0.0.1:18090", ""},
+		{"bob", "room1", "tcp", "127.0.0.1:18090", ":18090"},
+		{"bob", "room2", "tcp", "127.0.0.1:18090", ""},
+		{"bob", "", "tcp", "web.hairpin.test:18443", ""},
+	} {
+		ctx := &sessionContext{auth: &mux.AuthRequest{User: c.user, P2SPRoomId: c.room}}
+		name := ""
+		if tunnel := lookupHairpinTunnel(ctx, c.network, c.addr); nil != tunnel {
+			name = tunnel.name
+		}
+		if name != c.expected {
+			t.Errorf("%s(%s) %s %s got tunnel %q, expected %q", c.user, c.room, c.network, c.addr, name, c.expected)
+		}
+	}
+}
+
+func TestIsLocalInterfaceIP(t *testing.T) {
+	for _, c := range []struct {
+		ip       string
+		expected bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"0.0.0.0", true},
+		{"203.0.113.9", false},
+		{"2001:db8::1", false},
+	} {
+		if v := isLocalInterfaceIP(net.ParseIP(c.ip)); v != c.expected {
+			t.Errorf("isLocalInterfaceIP(%s)=%v, expected %v", c.ip, v, c.expected)
+		}
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !isLocalInterfaceIP(ipnet.IP) {
+			t.Errorf("address %v of local interface not matched", ipnet.IP)
+		}
+	}
+}
+
+func TestHairpinStreamRelayedOverTunnelSession(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	//nothing listen on the tunnel port, streams not hairpinned fail to dial
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(lp.Addr().String())
+	lp.Close()
+	name := ":" + port
+
+	ownerAuth := &mux.AuthRequest{SessionID: "hairpin-owner", User: "alice", P2SPRoomId: "room1", CompressMethod: mux.NoneCompressor}
+	reverseLocalAddrs.Store(ownerAuth.SessionID+"|"+name, echo.Addr().String())
+	defer reverseLocalAddrs.Delete(ownerAuth.SessionID + "|" + name)
+	ownerClient, ownerServer := newTestSessionPair(t)
+	defer ownerClient.Close()
+	defer ownerServer.Close()
+	go servReverseMuxSession(ownerClient, ownerAuth)
+	reverseHostsMutex.Lock()
+	reverseListens[port] = &reverseTunnel{ctx: &sessionContext{auth: ownerAuth, session: ownerServer}, name: name}
+	reverseHostsMutex.Unlock()
+	defer func() {
+		reverseHostsMutex.Lock()
+		delete(reverseListens, port)
+		reverseHostsMutex.Unlock()
+	}()
+
+	for _, c := range []struct {
+		user, room, addr string
+		relayed          bool
+	}{
+		{"alice", "", "127.0.0.1" + name, true},
+		{"bob", "room1", "127.0.0.1" + name, true},
+		//not hairpinned, dialed directly
+		{"bob", "room2", "127.0.0.1" + name, false},
+		{"bob", "room2", echo.Addr().String(), true},
+	} {
+		auth := &mux.AuthRequest{SessionID: "hairpin-" + c.user + c.room, User: c.user, P2SPRoomId: c.room, CompressMethod: mux.NoneCompressor}
+		session := newTestProxySession(t, auth)
+		stream, err := pingTestStream(session, "tcp", c.addr)
+		if c.relayed && nil != err {
+			t.Errorf("%s(%s) stream to %s not relayed for reason:%v", c.user, c.room, c.addr, err)
+		}
+		if !c.relayed && nil == err {
+			t.Errorf("%s(%s) stream to %s should fail to dial, but relayed", c.user, c.room, c.addr)
+		}
+		if nil != stream {
+			stream.Close()
+		}
+		session.Close()
+	}
+}
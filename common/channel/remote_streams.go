@@ -0,0 +1,153 @@
+package channel
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// StreamInfo is the snapshot of an active proxy stream on server
+type StreamInfo struct {
+	Session   string
+	User      string
+	StreamID  uint32
+	Network   string
+	Addr      string
+	RecvBytes int64
+	SentBytes int64
+	AgeSecs   int64
+}
+
+// StreamFilter select active streams, empty fields match all
+type StreamFilter struct {
+	Session string
+	User    string
+	//destination 'host:port', 'host' or a parent domain of host
+	Addr string
+	//streams opened at least seconds ago
+	MinAgeSecs int64
+}
+
+func (f *StreamFilter) empty() bool {
+	return len(f.Session) == 0 && len(f.User) == 0 && len(f.Addr) == 0 && f.MinAgeSecs <= 0
+}
+
+type activeStream struct {
+	ctx       *sessionContext
+	stream    mux.MuxStream
+	conn      io.Closer
+	network   string
+	addr      string
+	start     time.Time
+	recvBytes *int64
+	sentBytes *int64
+}
+
+func (s *activeStream) info(now time.Time) StreamInfo {
+	return StreamInfo{
+		Session:   s.ctx.sessionID(),
+		User:      s.ctx.auth.User,
+		StreamID:  s.stream.StreamID(),
+		Network:   s.network,
+		Addr:      s.addr,
+		RecvBytes: atomic.LoadInt64(s.recvBytes),
+		SentBytes: atomic.LoadInt64(s.sentBytes),
+		AgeSecs:   int64(now.Sub(s.start) / time.Second),
+	}
+}
+
+func (s *activeStream) close() {
+	if nil != s.conn {
+		s.conn.Close()
+	}
+	s.stream.Close()
+}
+
+// trackStream register a stream of the session to be listed & closed by admin api, conn is closed with the stream
+// if not nil, the returned func unregister it
+func (ctx *sessionContext) trackStream(stream mux.MuxStream, conn io.Closer, network, addr string, recvBytes, sentBytes *int64) func() {
+	as := &activeStream{ctx: ctx, stream: stream, conn: conn, network: network, addr: addr,
+		start: time.Now(), recvBytes: recvBytes, sentBytes: sentBytes}
+	ctx.streams.Store(as, true)
+	return func() {
+		ctx.streams.Delete(as)
+	}
+}
+
+func matchStreamAddr(pattern, addr string) bool {
+	if strings.EqualFold(pattern, addr) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if nil != err {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+func (f *StreamFilter) match(s *activeStream, now time.Time) bool {
+	if len(f.Session) > 0 && s.ctx.sessionID() != f.Session {
+		return false
+	}
+	if len(f.User) > 0 && s.ctx.auth.User != f.User {
+		return false
+	}
+	if len(f.Addr) > 0 && !matchStreamAddr(f.Addr, s.addr) {
+		return false
+	}
+	return f.MinAgeSecs <= 0 || int64(now.Sub(s.start)/time.Second) >= f.MinAgeSecs
+}
+
+func rangeActiveStreams(f func(s *activeStream) bool) {
+	rangeLiveSessions(func(ctx *sessionContext) bool {
+		next := true
+		ctx.streams.Range(func(key, value interface{}) bool {
+			next = f(key.(*activeStream))
+			return next
+		})
+		return next
+	})
+}
+
+// ListStreams return active proxy streams matched by the filter, the oldest first
+func ListStreams(f StreamFilter) []StreamInfo {
+	now := time.Now()
+	var infos []StreamInfo
+	rangeActiveStreams(func(s *activeStream) bool {
+		if f.match(s, now) {
+			infos = append(infos, s.info(now))
+		}
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].AgeSecs > infos[j].AgeSecs
+	})
+	return infos
+}
+
+// CloseStreams terminate active proxy streams matched by the filter while keeping their sessions, return the closed count
+func CloseStreams(f StreamFilter) int {
+	if f.empty() {
+		//never close all streams by mistake
+		return 0
+	}
+	now := time.Now()
+	n := 0
+	rangeActiveStreams(func(s *activeStream) bool {
+		if f.match(s, now) {
+			logger.Notice("Close stream[%s:%d] of user:%s to %s", s.ctx.sessionID(), s.stream.StreamID(), s.ctx.auth.User, s.addr)
+			s.close()
+			n++
+		}
+		return true
+	})
+	return n
+}
@@ -0,0 +1,151 @@
+package channel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/pmux"
+)
+
+// authTestSession send 'req' on a session served by ServProxyMuxSession, return the client session & the auth result
+func authTestSession(t *testing.T, req *mux.AuthRequest) (mux.MuxSession, error) {
+	client, server := newTestSessionPair(t)
+	go ServProxyMuxSession(server, nil)
+	if len(req.CipherMethod) == 0 {
+		req.CipherMethod = pmux.CipherNone
+	}
+	if len(req.CompressMethod) == 0 {
+		req.CompressMethod = mux.NoneCompressor
+	}
+	stream, err := client.OpenStream()
+	if nil != err {
+		t.Fatal(err)
+	}
+	return client, stream.Auth(req)
+}
+
+func TestAuthRejectByClientVersionLimit(t *testing.T) {
+	defer SetClientVersionLimit(ClientVersionLimitConfig{})
+	SetClientVersionLimit(ClientVersionLimitConfig{MinVersion: "0.33.0", MinProtocolLevel: 2})
+	tests := []struct {
+		version string
+		level   int
+		code    int
+	}{
+		{"0.33.0", mux.ProtocolLevel, mux.AuthOK},
+		{"0.32.0", mux.ProtocolLevel, mux.AuthVersionRejected},
+		{"0.33.0", 1, mux.AuthVersionRejected},
+	}
+	for _, tt := range tests {
+		session, err := authTestSession(t, &mux.AuthRequest{User: "gsnova", Version: tt.version, ProtocolLevel: tt.level})
+		code := mux.AuthOK
+		if authErr, ok := err.(*mux.AuthError); ok {
+			code = authErr.Code
+		} else if nil != err {
+			t.Fatalf("auth version:%s level:%d failed:%v", tt.version, tt.level, err)
+		}
+		if code != tt.code {
+			t.Errorf("auth version:%s level:%d expect code %d, but got %d", tt.version, tt.level, tt.code, code)
+		}
+		session.Close()
+	}
+}
+
+func TestProxyStreamEarlyData(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	tests := []struct {
+		early string
+		data  string
+	}{
+		{"", "helloworld"},
+		{"hello", "world"},
+		{"helloworld", ""},
+	}
+	for _, tt := range tests {
+		//the target answer all received bytes at once, early data must come first
+		go func() {
+			c, err := target.Accept()
+			if nil == err {
+				b := make([]byte, 10)
+				io.ReadFull(c, b)
+				c.Write(b)
+				c.Close()
+			}
+		}()
+		session, err := authTestSession(t, &mux.AuthRequest{User: "early-user"})
+		if nil != err {
+			t.Fatal(err)
+		}
+		stream, err := session.OpenStream()
+		if nil != err {
+			t.Fatal(err)
+		}
+		if err = stream.Connect("tcp", target.Addr().String(), mux.StreamOptions{EarlyData: []byte(tt.early)}); nil != err {
+			t.Fatal(err)
+		}
+		if len(tt.data) > 0 {
+			stream.Write([]byte(tt.data))
+		}
+		stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 10)
+		if _, err = io.ReadFull(stream, b); nil != err || string(b) != "helloworld" {
+			t.Errorf("early data %q: expect echo 'helloworld', but got %q %v", tt.early, b, err)
+		}
+		session.Close()
+	}
+}
+
+func TestProxyStreamDataBeforeDialed(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	data := make([]byte, 128*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	go func() {
+		//the target is slow to accept, data keeps buffered by the stream meanwhile
+		time.Sleep(100 * time.Millisecond)
+		c, err := target.Accept()
+		if nil == err {
+			b := make([]byte, len(data))
+			io.ReadFull(c, b)
+			c.Write(b)
+			c.Close()
+		}
+	}()
+	session, err := authTestSession(t, &mux.AuthRequest{User: "dial-user"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stream, err := session.OpenStream()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = stream.Connect("tcp", target.Addr().String(), mux.StreamOptions{}); nil != err {
+		t.Fatal(err)
+	}
+	if _, err = stream.Write(data); nil != err {
+		t.Fatal(err)
+	}
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, len(data))
+	if _, err = io.ReadFull(stream, b); nil != err {
+		t.Fatal(err)
+	}
+	for i := range b {
+		if b[i] != data[i] {
+			t.Fatalf("expect data relayed in order, but got mismatch at %d", i)
+		}
+	}
+}
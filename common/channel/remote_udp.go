@@ -0,0 +1,133 @@
+package channel
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+const maxUDPAssociateResolveCache = 1024
+
+// handleUDPAssociateStream relay udp datagrams carried by the stream via a
+// NAT-style udp socket, every stream has its own udp socket.
+func handleUDPAssociateStream(stream mux.MuxStream, ctx *sessionContext, creq *mux.ConnectRequest) {
+	defer stream.Close()
+	conn, err := net.ListenUDP("udp", nil)
+	if nil != err {
+		logger.Error("[ERROR]:Failed to create udp socket for udp associate stream:%v", err)
+		return
+	}
+	defer conn.Close()
+	var recvBytes, sentBytes int64
+	defer ctx.trackStream(stream, conn, creq.Network, creq.Addr, &recvBytes, &sentBytes)()
+	maxIdleTime := time.Duration(defaultMuxConfig.StreamIdleTimeout) * time.Second
+	if creq.ReadTimeout > 0 {
+		maxIdleTime = time.Duration(creq.ReadTimeout) * time.Millisecond
+	}
+	if maxIdleTime == 0 {
+		maxIdleTime = 30 * time.Second
+	}
+	streamReader, streamWriter := mux.GetCompressStreamReaderWriter(stream, ctx.auth.CompressMethod)
+	quotaUser := getQuotaUser(ctx.auth.User)
+	closeSig := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(maxIdleTime))
+			n, addr, err := conn.ReadFrom(buf)
+			if nil != err {
+				if isTimeoutErr(err) && time.Now().Sub(stream.LatestIOTime()) < maxIdleTime {
+					continue
+				}
+				break
+			}
+			if nil != quotaUser && !addQuotaUsage(quotaUser, int64(n)) {
+				pushQuotaStatus(ctx.auth.User)
+				break
+			}
+			err = mux.WriteMessage(streamWriter, &mux.UDPDatagram{Addr: addr.String(), Data: buf[0:n]})
+			if nil != err {
+				break
+			}
+			atomic.AddInt64(&ctx.sentBytes, int64(n))
+			atomic.AddInt64(&sentBytes, int64(n))
+		}
+		stream.Close()
+		closeSig <- true
+	}()
+
+	resolved := make(map[string]*net.UDPAddr)
+	//destination hosts of the stream counted by host limits, released when the stream closed
+	limitedHosts := make(map[string]func())
+	defer func() {
+		for _, release := range limitedHosts {
+			release()
+		}
+	}()
+	dgramReader := withQuota(ctx.auth.User, &countReader{&countReader{streamReader, &ctx.recvBytes}, &recvBytes})
+	for {
+		dgram, err := mux.ReadUDPDatagram(dgramReader)
+		if nil != err {
+			if err == errQuotaExceeded {
+				pushQuotaStatus(ctx.auth.User)
+			}
+			if err != io.EOF {
+				logger.Debug("[%d]Udp associate stream closed for reason:%v", stream.StreamID(), err)
+			}
+			break
+		}
+		addr, exist := resolved[dgram.Addr]
+		if !exist {
+			if !defaultProxyLimit().Allowed(dgram.Addr) {
+				logger.Error("'%s' is NOT allowed by proxy limit config.", dgram.Addr)
+				continue
+			}
+			if !allowedByUserACL(ctx.auth.User, dgram.Addr) {
+				logger.Error("'%s' is NOT allowed by ACL of user:%s.", dgram.Addr, ctx.auth.User)
+				continue
+			}
+			if !allowedPort(ctx.auth.User, "udp", dgram.Addr) {
+				logger.Error("udp '%s' is NOT allowed by port limit of user:%s.", dgram.Addr, ctx.auth.User)
+				continue
+			}
+			addr, err = net.ResolveUDPAddr("udp", dgram.Addr)
+			if nil != err {
+				logger.Error("[ERROR]:Failed to resolve udp address:%s for reason:%v", dgram.Addr, err)
+				continue
+			}
+			if !allowedDialIP(ctx.auth.User, dgram.Addr, addr.IP) {
+				logger.Error("'%s' via %v is NOT allowed by proxy limit or ACL of user:%s.", dgram.Addr, addr.IP, ctx.auth.User)
+				continue
+			}
+			if host := limitHost(dgram.Addr); nil == limitedHosts[host] {
+				var ok bool
+				var release func()
+				if len(limitedHosts) < maxUDPAssociateResolveCache {
+					ok, release = acquireHostConn(ctx.auth.User, dgram.Addr)
+				}
+				if !ok {
+					logger.Error("Too many concurrent streams to '%s' for user:%s.", dgram.Addr, ctx.auth.User)
+					continue
+				}
+				limitedHosts[host] = release
+			}
+			if len(resolved) >= maxUDPAssociateResolveCache {
+				resolved = make(map[string]*net.UDPAddr)
+			}
+			resolved[dgram.Addr] = addr
+		}
+		conn.WriteTo(dgram.Data, addr)
+	}
+	conn.Close()
+	<-closeSig
+	if close, ok := streamWriter.(io.Closer); ok {
+		close.Close()
+	}
+	if close, ok := streamReader.(io.Closer); ok {
+		close.Close()
+	}
+}
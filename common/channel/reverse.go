@@ -0,0 +1,54 @@
+package channel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// ReverseTunnelConfig expose the 'Local' address through the server by 'Remote', which is a listen
+// address like ':8080' on server, or a hostname routed by the server's 'ReverseHTTP' listener.
+type ReverseTunnelConfig struct {
+	Remote string
+	Local  string
+}
+
+// local addresses of reverse tunnels registered by sessions, 'session id|remote' -> local
+var reverseLocalAddrs sync.Map
+
+func reverseLocalAddr(sessionID string, remote string) (string, bool) {
+	if v, exist := reverseLocalAddrs.Load(sessionID + "|" + remote); exist {
+		return v.(string), true
+	}
+	return "", false
+}
+
+// registerReverse keep the reverse tunnel registered on the session, the registration is
+// retried until the session closed.
+func registerReverse(session mux.MuxSession, sessionID string, server string, conf ReverseTunnelConfig) {
+	key := sessionID + "|" + conf.Remote
+	reverseLocalAddrs.Store(key, conf.Local)
+	defer reverseLocalAddrs.Delete(key)
+	for {
+		stream, err := session.OpenStream()
+		if nil != err {
+			return
+		}
+		err = stream.Connect(mux.ReverseNetwork, conf.Remote, mux.StreamOptions{})
+		if nil == err {
+			logger.Info("Register reverse tunnel %s -> %s on %s", conf.Remote, conf.Local, server)
+			b := make([]byte, 1)
+			for {
+				stream.SetReadDeadline(time.Now().Add(24 * time.Hour))
+				if _, err = stream.Read(b); nil != err && !isTimeoutErr(err) {
+					break
+				}
+			}
+		}
+		stream.Close()
+		logger.Notice("Reverse tunnel %s -> %s closed by %s, retry later.", conf.Remote, conf.Local, server)
+		time.Sleep(30 * time.Second)
+	}
+}
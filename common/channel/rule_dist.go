@@ -0,0 +1,176 @@
+package channel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"golang.org/x/crypto/ed25519"
+)
+
+// RuleDistributionConfig push rule files to authenticated clients over control streams, files are reloaded once changed
+type RuleDistributionConfig struct {
+	//gfwlist file in base64 as published
+	GFWList string
+	//hosts file like hosts.json
+	Hosts string
+	//json array of PAC rules
+	PAC string
+	//base64 ed25519 private key(32 bytes seed or 64 bytes key), clients verify bundles by the public key
+	SigningKey string
+}
+
+func (conf *RuleDistributionConfig) files() []string {
+	var files []string
+	for _, f := range []string{conf.GFWList, conf.Hosts, conf.PAC} {
+		if len(f) > 0 {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+var ruleBundle *mux.RuleBundle
+var ruleBundleMutex sync.Mutex
+var ruleBundleHandler func(b *mux.RuleBundle)
+
+// SetRuleBundleHandler set the client callback of rule bundles pushed by servers
+func SetRuleBundleHandler(f func(b *mux.RuleBundle)) {
+	ruleBundleHandler = f
+}
+
+// GenRuleSigningKey return a new base64 signing key for servers & the public key for clients
+func GenRuleSigningKey() (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if nil != err {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+func parseSigningKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if nil != err {
+		return nil, err
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, errors.New("invalid ed25519 private key size")
+}
+
+// buildRuleBundle load & sign the rule files, the version is the latest modify time of files
+func buildRuleBundle(conf *RuleDistributionConfig, key ed25519.PrivateKey) (*mux.RuleBundle, error) {
+	b := &mux.RuleBundle{}
+	for _, f := range conf.files() {
+		st, err := os.Stat(f)
+		if nil != err {
+			return nil, err
+		}
+		if v := st.ModTime().UnixNano(); v > b.Version {
+			b.Version = v
+		}
+	}
+	if len(conf.GFWList) > 0 {
+		data, err := ioutil.ReadFile(conf.GFWList)
+		if nil != err {
+			return nil, err
+		}
+		b.GFWList = string(data)
+	}
+	if len(conf.Hosts) > 0 {
+		data, err := helper.ReadWithoutComment(conf.Hosts, "//")
+		if nil == err {
+			err = json.Unmarshal(data, &b.Hosts)
+		}
+		if nil != err {
+			return nil, err
+		}
+	}
+	if len(conf.PAC) > 0 {
+		data, err := helper.ReadWithoutComment(conf.PAC, "//")
+		if nil != err {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = json.Compact(&buf, data); nil != err {
+			return nil, err
+		}
+		b.PAC = buf.Bytes()
+	}
+	b.Signature = ed25519.Sign(key, b.SignedData())
+	//clients can't read control messages larger than mux.MaxMessageSize
+	if err := mux.WriteMessage(ioutil.Discard, &mux.ControlMessage{Type: mux.ControlRuleBundle, Rules: b}); nil != err {
+		return nil, fmt.Errorf("rule bundle can NOT be pushed for reason:%v", err)
+	}
+	return b, nil
+}
+
+func getRuleBundle() *mux.RuleBundle {
+	ruleBundleMutex.Lock()
+	defer ruleBundleMutex.Unlock()
+	return ruleBundle
+}
+
+func pushRuleBundle(ctx *sessionContext, b *mux.RuleBundle) {
+	if nil != b {
+		ctx.sendControl(&mux.ControlMessage{Type: mux.ControlRuleBundle, Rules: b})
+	}
+}
+
+// StartRuleDistribution build the rule bundle & push it to all sessions whenever rule files changed
+func StartRuleDistribution(conf RuleDistributionConfig) error {
+	if len(conf.files()) == 0 {
+		return nil
+	}
+	key, err := parseSigningKey(conf.SigningKey)
+	if nil != err {
+		return err
+	}
+	reload := func() {
+		b, err := buildRuleBundle(&conf, key)
+		if nil != err {
+			logger.Error("[ERROR]Failed to build rule bundle for reason:%v", err)
+			return
+		}
+		if old := getRuleBundle(); nil != old && old.Version == b.Version {
+			return
+		}
+		ruleBundleMutex.Lock()
+		ruleBundle = b
+		ruleBundleMutex.Unlock()
+		logger.Notice("Distribute rule bundle of version:%d", b.Version)
+		rangeLiveSessions(func(ctx *sessionContext) bool {
+			pushRuleBundle(ctx, b)
+			return true
+		})
+	}
+	reload()
+	go func() {
+		for range time.Tick(30 * time.Second) {
+			reload()
+		}
+	}()
+	return nil
+}
+
+func (s *muxSessionHolder) onRuleBundle(msg *mux.ControlMessage) {
+	if nil == msg.Rules || nil == ruleBundleHandler {
+		return
+	}
+	logger.Debug("Recv rule bundle of version:%d from %s", msg.Rules.Version, s.server)
+	ruleBundleHandler(msg.Rules)
+}
@@ -0,0 +1,109 @@
+package channel
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestParseSigningKey(t *testing.T) {
+	seed, pub, err := GenRuleSigningKey()
+	if nil != err {
+		t.Fatal(err)
+	}
+	key, _ := parseSigningKey(seed)
+	tests := []struct {
+		name string
+		key  string
+		err  bool
+	}{
+		{"seed", seed, false},
+		{"seed with newline", seed + "\n", false},
+		{"private key", base64.StdEncoding.EncodeToString(key), false},
+		{"short key", base64.StdEncoding.EncodeToString(key[:16]), true},
+		{"invalid base64", "invalid!", true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		k, err := parseSigningKey(tt.key)
+		if (nil != err) != tt.err {
+			t.Errorf("%s: expect error %v, but got %v", tt.name, tt.err, err)
+			continue
+		}
+		if nil == err && base64.StdEncoding.EncodeToString(k.Public().(ed25519.PublicKey)) != pub {
+			t.Errorf("%s: expect public key %s", tt.name, pub)
+		}
+	}
+}
+
+func TestBuildRuleBundle(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ruledist")
+	defer os.RemoveAll(dir)
+	file := func(name, content string, mtime time.Time) string {
+		f := filepath.Join(dir, name)
+		ioutil.WriteFile(f, []byte(content), 0644)
+		os.Chtimes(f, mtime, mtime)
+		return f
+	}
+	now := time.Now().Truncate(time.Second)
+	gfw := file("gfwlist.txt", "W0F1dG9Qcm94eV0K", now.Add(-time.Hour))
+	hosts := file("hosts.json", "//comment\n{\"a.com\":[\"1.1.1.1\"]}", now)
+	pac := file("pac.json", "[\n  {\"Remote\": \"direct\"}\n]", now.Add(-time.Minute))
+	badHosts := file("bad_hosts.json", `{"a.com":`, now)
+	large := file("large.txt", strings.Repeat("A", mux.MaxMessageSize), now)
+	key, _ := parseSigningKey(base64.StdEncoding.EncodeToString(make([]byte, ed25519.SeedSize)))
+	tests := []struct {
+		name string
+		conf RuleDistributionConfig
+		err  bool
+	}{
+		{"all", RuleDistributionConfig{GFWList: gfw, Hosts: hosts, PAC: pac}, false},
+		{"pac only", RuleDistributionConfig{PAC: pac}, false},
+		{"missing file", RuleDistributionConfig{PAC: filepath.Join(dir, "none.json")}, true},
+		{"invalid hosts", RuleDistributionConfig{Hosts: badHosts}, true},
+		{"invalid pac", RuleDistributionConfig{PAC: badHosts}, true},
+		{"oversized", RuleDistributionConfig{GFWList: large}, true},
+	}
+	for _, tt := range tests {
+		b, err := buildRuleBundle(&tt.conf, key)
+		if (nil != err) != tt.err {
+			t.Errorf("%s: expect error %v, but got %v", tt.name, tt.err, err)
+			continue
+		}
+		if nil != err {
+			continue
+		}
+		if !ed25519.Verify(key.Public().(ed25519.PublicKey), b.SignedData(), b.Signature) {
+			t.Errorf("%s: expect bundle signed", tt.name)
+		}
+		//the version is the latest modify time of files
+		var latest time.Time
+		for _, f := range tt.conf.files() {
+			if st, _ := os.Stat(f); st.ModTime().After(latest) {
+				latest = st.ModTime()
+			}
+		}
+		if b.Version != latest.UnixNano() {
+			t.Errorf("%s: expect version %d, but got %d", tt.name, latest.UnixNano(), b.Version)
+		}
+		if string(b.PAC) != `[{"Remote":"direct"}]` {
+			t.Errorf("%s: expect compacted pac, but got %s", tt.name, b.PAC)
+		}
+	}
+	b, _ := buildRuleBundle(&RuleDistributionConfig{GFWList: gfw, Hosts: hosts}, key)
+	if b.GFWList != "W0F1dG9Qcm94eV0K" || len(b.Hosts["a.com"]) != 1 || len(b.PAC) != 0 {
+		t.Errorf("unexpected bundle %+v", b)
+	}
+	//any modified field breaks the signature
+	b.Hosts["a.com"] = []string{"2.2.2.2"}
+	if ed25519.Verify(key.Public().(ed25519.PublicKey), b.SignedData(), b.Signature) {
+		t.Errorf("expect signature invalid once the bundle modified")
+	}
+}
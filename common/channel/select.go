@@ -0,0 +1,173 @@
+package channel
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SelectLowestLatency = "lowest-latency"
+	SelectWeighted      = "weighted"
+	SelectSticky        = "sticky"
+)
+
+// SelectConfig choose the server of new streams by RTT & loss measured over heartbeats
+type SelectConfig struct {
+	//'lowest-latency', 'weighted' or 'sticky'(per destination host), default any available server
+	Strategy string
+	//weight of each server url for 'weighted', default 1
+	Weights map[string]int
+	//sticky destination is forgotten after idle seconds, default 600
+	StickySecs int
+}
+
+func (conf *SelectConfig) weight(server string) int {
+	if w, exist := conf.Weights[server]; exist && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (conf *SelectConfig) stickyTTL() time.Duration {
+	if conf.StickySecs <= 0 {
+		return 600 * time.Second
+	}
+	return time.Duration(conf.StickySecs) * time.Second
+}
+
+func (h *pathHealth) onPingLost() {
+	h.mutex.Lock()
+	h.loss = h.loss*7/8 + 0.125
+	h.mutex.Unlock()
+}
+
+// cost is the expected latency in ms considering retransmission by loss, unmeasured paths cost most
+func (h *pathHealth) cost() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.rtt == 0 {
+		return math.MaxFloat64
+	}
+	return float64(h.rtt/time.Millisecond+1) / math.Max(1-h.loss, 0.01)
+}
+
+type stickyRoute struct {
+	server string
+	expire time.Time
+}
+
+type stickyTable struct {
+	routes map[string]stickyRoute
+	mutex  sync.Mutex
+}
+
+func (t *stickyTable) get(host string) (string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r, exist := t.routes[host]
+	if !exist || r.expire.Before(time.Now()) {
+		return "", false
+	}
+	return r.server, true
+}
+
+func (t *stickyTable) put(host string, server string, ttl time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	if nil == t.routes {
+		t.routes = make(map[string]stickyRoute)
+	}
+	if len(t.routes) >= 10000 {
+		for k, r := range t.routes {
+			if r.expire.Before(now) {
+				delete(t.routes, k)
+			}
+		}
+	}
+	t.routes[host] = stickyRoute{server: server, expire: now.Add(ttl)}
+}
+
+func sortByCost(holders []*muxSessionHolder) {
+	costs := make(map[*muxSessionHolder]float64, len(holders))
+	for _, holder := range holders {
+		costs[holder] = holder.health.cost()
+	}
+	sort.SliceStable(holders, func(i, j int) bool {
+		return costs[holders[i]] < costs[holders[j]]
+	})
+}
+
+// weightedShuffle order holders randomly with the probability of their weighted health scores
+func weightedShuffle(holders []*muxSessionHolder, conf *SelectConfig) {
+	scores := make([]float64, len(holders))
+	total := float64(0)
+	for i, holder := range holders {
+		scores[i] = math.Max(holder.health.score(conf.weight(holder.server)), 0.001)
+		total += scores[i]
+	}
+	for i := range holders {
+		r := rand.Float64() * total
+		idx := len(holders) - 1
+		for j := i; j < len(holders); j++ {
+			if r < scores[j] {
+				idx = j
+				break
+			}
+			r -= scores[j]
+		}
+		total -= scores[idx]
+		holders[i], holders[idx] = holders[idx], holders[i]
+		scores[i], scores[idx] = scores[idx], scores[i]
+	}
+}
+
+// selectSessions return session holders in the order to try by the select strategy for the host
+func (ch *LocalProxyChannel) selectSessions(host string) []*muxSessionHolder {
+	holders := make([]*muxSessionHolder, 0, len(ch.sessions))
+	for holder := range ch.sessions {
+		holders = append(holders, holder)
+	}
+	conf := &ch.Conf.Select
+	switch strings.ToLower(conf.Strategy) {
+	case SelectLowestLatency:
+		sortByCost(holders)
+	case SelectWeighted:
+		weightedShuffle(holders, conf)
+	case SelectSticky:
+		sortByCost(holders)
+		if server, ok := ch.sticky.get(host); ok && len(host) > 0 {
+			sort.SliceStable(holders, func(i, j int) bool {
+				return holders[i].server == server && holders[j].server != server
+			})
+		}
+	}
+	if maxStreams := ch.Conf.Pool.MaxStreamsPerSession; maxStreams > 0 {
+		sortByLoad(holders, maxStreams)
+	}
+	//servers demoted by canary escalation are tried after others of the same health
+	if demoted := ch.canary.demotedServers(); len(demoted) > 0 {
+		sort.SliceStable(holders, func(i, j int) bool {
+			return !demoted[holders[i].server] && demoted[holders[j].server]
+		})
+	}
+	//unhealthy sessions are tried only if all healthy sessions failed
+	healthy := make(map[*muxSessionHolder]bool, len(holders))
+	for _, holder := range holders {
+		healthy[holder] = holder.health.healthy()
+	}
+	sort.SliceStable(holders, func(i, j int) bool {
+		return healthy[holders[i]] && !healthy[holders[j]]
+	})
+	return holders
+}
+
+func (ch *LocalProxyChannel) onSessionSelected(holder *muxSessionHolder, host string) {
+	if len(host) > 0 && strings.EqualFold(ch.Conf.Select.Strategy, SelectSticky) {
+		ch.sticky.put(host, holder.server, ch.Conf.Select.stickyTTL())
+	}
+}
@@ -0,0 +1,147 @@
+package channel
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSelectConfig(t *testing.T) {
+	conf := &SelectConfig{Weights: map[string]int{"a": 5, "b": 0, "c": -2}}
+	tests := []struct {
+		server string
+		weight int
+	}{
+		{"a", 5},
+		{"b", 1},
+		{"c", 1},
+		{"d", 1},
+	}
+	for _, tt := range tests {
+		if w := conf.weight(tt.server); w != tt.weight {
+			t.Errorf("expect weight %d of %s, but got %d", tt.weight, tt.server, w)
+		}
+	}
+	ttls := []struct {
+		secs int
+		ttl  time.Duration
+	}{
+		{0, 600 * time.Second},
+		{-1, 600 * time.Second},
+		{30, 30 * time.Second},
+	}
+	for _, tt := range ttls {
+		if ttl := (&SelectConfig{StickySecs: tt.secs}).stickyTTL(); ttl != tt.ttl {
+			t.Errorf("expect sticky ttl %v of %d, but got %v", tt.ttl, tt.secs, ttl)
+		}
+	}
+}
+
+func TestPathHealthCost(t *testing.T) {
+	tests := []struct {
+		name   string
+		health *pathHealth
+		cost   float64
+	}{
+		{"unmeasured", &pathHealth{}, math.MaxFloat64},
+		{"no loss", &pathHealth{rtt: 99 * time.Millisecond}, 100},
+		{"half lost", &pathHealth{rtt: 99 * time.Millisecond, loss: 0.5}, 200},
+		{"all lost", &pathHealth{rtt: 99 * time.Millisecond, loss: 1}, 10000},
+	}
+	for _, tt := range tests {
+		if c := tt.health.cost(); math.Abs(c-tt.cost) > 0.001 {
+			t.Errorf("%s: expect cost %v, but got %v", tt.name, tt.cost, c)
+		}
+	}
+	h := &pathHealth{}
+	h.onPingLost()
+	h.onPingLost()
+	if math.Abs(h.loss-(0.125*7/8+0.125)) > 0.0001 {
+		t.Errorf("expect loss grown by lost pings, but got %v", h.loss)
+	}
+}
+
+func TestStickyTable(t *testing.T) {
+	var table stickyTable
+	if _, ok := table.get("www.example.com"); ok {
+		t.Errorf("expect no sticky route in empty table")
+	}
+	table.put("www.example.com", "a", time.Minute)
+	table.put("api.example.com", "b", -time.Second)
+	tests := []struct {
+		host   string
+		server string
+		ok     bool
+	}{
+		{"www.example.com", "a", true},
+		{"api.example.com", "", false},
+		{"other.com", "", false},
+	}
+	for _, tt := range tests {
+		if server, ok := table.get(tt.host); server != tt.server || ok != tt.ok {
+			t.Errorf("expect sticky server %q(%v) of %s, but got %q(%v)", tt.server, tt.ok, tt.host, server, ok)
+		}
+	}
+}
+
+func testSelectChannel(conf SelectConfig) (*LocalProxyChannel, map[string]*muxSessionHolder) {
+	ch := NewProxyChannel(&ProxyChannelConfig{Name: "select", Select: conf})
+	holders := make(map[string]*muxSessionHolder)
+	for server, rtt := range map[string]time.Duration{"fast": 10 * time.Millisecond, "medium": 50 * time.Millisecond, "slow": 200 * time.Millisecond} {
+		holder := &muxSessionHolder{server: server, conf: &ch.Conf}
+		holder.health.rtt = rtt
+		ch.sessions[holder] = true
+		holders[server] = holder
+	}
+	return ch, holders
+}
+
+func selectedServers(ch *LocalProxyChannel, host string) []string {
+	var servers []string
+	for _, holder := range ch.selectSessions(host) {
+		servers = append(servers, holder.server)
+	}
+	return servers
+}
+
+func TestSelectSessions(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      SelectConfig
+		sticky    string
+		unhealthy string
+		first     string
+		last      string
+	}{
+		{"lowest latency", SelectConfig{Strategy: "Lowest-Latency"}, "", "", "fast", "slow"},
+		{"unhealthy last", SelectConfig{Strategy: SelectLowestLatency}, "", "fast", "medium", "fast"},
+		{"sticky", SelectConfig{Strategy: SelectSticky}, "slow", "", "slow", "medium"},
+		{"sticky to unhealthy", SelectConfig{Strategy: SelectSticky}, "slow", "slow", "fast", "slow"},
+		{"weighted", SelectConfig{Strategy: SelectWeighted, Weights: map[string]int{"slow": 1000000}}, "", "", "slow", ""},
+	}
+	for _, tt := range tests {
+		ch, holders := testSelectChannel(tt.conf)
+		if len(tt.sticky) > 0 {
+			ch.onSessionSelected(holders[tt.sticky], "www.example.com")
+		}
+		if len(tt.unhealthy) > 0 {
+			holders[tt.unhealthy].health.unhealthy = true
+		}
+		servers := selectedServers(ch, "www.example.com")
+		if len(servers) != 3 || servers[0] != tt.first || (len(tt.last) > 0 && servers[2] != tt.last) {
+			t.Errorf("%s: expect %s first & %q last, but got %v", tt.name, tt.first, tt.last, servers)
+		}
+	}
+
+	//sticky routes are only recorded for the sticky strategy & known hosts
+	ch, holders := testSelectChannel(SelectConfig{Strategy: SelectLowestLatency})
+	ch.onSessionSelected(holders["slow"], "www.example.com")
+	if _, ok := ch.sticky.get("www.example.com"); ok {
+		t.Errorf("expect no sticky route of lowest latency strategy")
+	}
+	ch, holders = testSelectChannel(SelectConfig{Strategy: SelectSticky})
+	ch.onSessionSelected(holders["slow"], "")
+	if servers := selectedServers(ch, ""); servers[0] != "fast" {
+		t.Errorf("expect lowest latency order without host, but got %v", servers)
+	}
+}
@@ -0,0 +1,120 @@
+package channel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+const (
+	//reject new sessions of the user reached 'MaxSessions'
+	SessionPolicyReject = "reject"
+	//close the session idle for the longest time to admit the new one, for roaming devices leaving zombie sessions
+	SessionPolicyPreemptIdle = "preempt-idle"
+)
+
+// SessionLimitConfig of server wide limits, keep one misbehaving client from exhausting server fds, 0 means unlimited
+type SessionLimitConfig struct {
+	//concurrent proxy streams of a session
+	MaxStreamsPerSession int
+	//sessions of a user, 'MaxSessions' of the user in 'Users' takes precedence
+	MaxSessionsPerUser int
+	//sessions from a source ip
+	MaxSessionsPerSourceIP int
+}
+
+var sessionLimitConfig atomic.Value
+
+func SetSessionLimitConfig(cfg SessionLimitConfig) {
+	sessionLimitConfig.Store(&cfg)
+}
+
+func getSessionLimitConfig() *SessionLimitConfig {
+	if cfg, ok := sessionLimitConfig.Load().(*SessionLimitConfig); ok {
+		return cfg
+	}
+	return &SessionLimitConfig{}
+}
+
+// allowedStreams return false if the session has more than 'MaxStreamsPerSession' streams
+func allowedStreams(streams int32) bool {
+	max := getSessionLimitConfig().MaxStreamsPerSession
+	return max <= 0 || streams <= int32(max)
+}
+
+// sourceIP return the ip of the session's remote address
+func (ctx *sessionContext) sourceIP() string {
+	host, _, err := net.SplitHostPort(ctx.remoteAddr())
+	if nil != err {
+		return ""
+	}
+	return host
+}
+
+// serialize counting & admitting sessions of users
+var sessionAdmitMutex sync.Mutex
+
+func validSessionPolicy(policy string) bool {
+	return len(policy) == 0 || strings.EqualFold(policy, SessionPolicyReject) || strings.EqualFold(policy, SessionPolicyPreemptIdle)
+}
+
+// admitSession set the auth of the session if its source ip has not reached 'MaxSessionsPerSourceIP' & the user
+// has not reached 'MaxSessions', or the session idle for the longest time is pre-empted by the policy,
+// the auth code & reason are returned if rejected
+func admitSession(ctx *sessionContext, auth *mux.AuthRequest) (int, string) {
+	sessionAdmitMutex.Lock()
+	defer sessionAdmitMutex.Unlock()
+	limit := getSessionLimitConfig()
+	maxSessions, policy := limit.MaxSessionsPerUser, ""
+	if uc := getUserConfig(auth.User); nil != uc && uc.MaxSessions > 0 {
+		maxSessions, policy = uc.MaxSessions, uc.SessionPolicy
+	}
+	sourceIP := ctx.sourceIP()
+	if len(sourceIP) == 0 {
+		//sessions without address like p2sp ones are not limited by source
+		limit = &SessionLimitConfig{}
+	}
+	if maxSessions <= 0 && limit.MaxSessionsPerSourceIP <= 0 {
+		ctx.auth = auth
+		return mux.AuthOK, ""
+	}
+	n, fromIP := 0, 0
+	var idlest *sessionContext
+	var idlestActive int64
+	rangeLiveSessions(func(live *sessionContext) bool {
+		if live.reverseOnly || live.closed || live == ctx {
+			return true
+		}
+		if limit.MaxSessionsPerSourceIP > 0 && live.sourceIP() == sourceIP {
+			fromIP++
+		}
+		if live.auth.User != auth.User {
+			return true
+		}
+		n++
+		if 0 == atomic.LoadInt32(&live.streamCouter) {
+			if lastActive := atomic.LoadInt64(&live.lastActive); nil == idlest || lastActive < idlestActive {
+				idlest, idlestActive = live, lastActive
+			}
+		}
+		return true
+	})
+	if limit.MaxSessionsPerSourceIP > 0 && fromIP >= limit.MaxSessionsPerSourceIP {
+		return mux.AuthSourceIPLimitRejected, fmt.Sprintf("too many sessions(%d) from %s", fromIP, sourceIP)
+	}
+	if maxSessions > 0 && n >= maxSessions {
+		//only one session is pre-empted for a new one, even if the limit was lowered by reload
+		if !strings.EqualFold(policy, SessionPolicyPreemptIdle) || nil == idlest || n > maxSessions {
+			return mux.AuthSessionLimitRejected, fmt.Sprintf("too many sessions(%d) of user:%s", n, auth.User)
+		}
+		idlest.log(nil).Notice("Pre-empt idle session for new session:%s of user:%s", auth.SessionID, auth.User)
+		idlest.sendControl(&mux.ControlMessage{Type: mux.ControlSessionClosing, Reason: "preempted"})
+		idlest.close()
+	}
+	ctx.auth = auth
+	return mux.AuthOK, ""
+}
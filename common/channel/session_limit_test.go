@@ -0,0 +1,129 @@
+package channel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestAdmitSessionPolicy(t *testing.T) {
+	if err := SetUserConfigs([]UserConfig{
+		{Name: "fixed", MaxSessions: 1},
+		{Name: "mobile", MaxSessions: 2, SessionPolicy: SessionPolicyPreemptIdle},
+	}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	if nil == SetUserConfigs([]UserConfig{{Name: "x", SessionPolicy: "oldest"}}) {
+		t.Fatalf("unknown session policy should be rejected")
+	}
+	newSession := func(user string, lastActive time.Time) *sessionContext {
+		_, server := newTestSessionPair(t)
+		ctx := newSessionContext(server, nil)
+		if _, reason := admitSession(ctx, &mux.AuthRequest{User: user, SessionID: user + lastActive.String()}); len(reason) > 0 {
+			t.Fatalf("session of %s rejected for reason:%s", user, reason)
+		}
+		ctx.lastActive = lastActive.UnixNano()
+		liveSessions.Store(ctx, true)
+		return ctx
+	}
+	now := time.Now()
+	fixed := newSession("fixed", now)
+	defer fixed.close()
+	if _, reason := admitSession(newSessionContext(nil, nil), &mux.AuthRequest{User: "fixed"}); len(reason) == 0 {
+		t.Errorf("session over the limit should be rejected")
+	}
+
+	older := newSession("mobile", now.Add(-time.Hour))
+	busy := newSession("mobile", now.Add(-2*time.Hour))
+	defer busy.close()
+	//sessions relaying streams are never pre-empted
+	busy.streamCouter = 1
+	third := newSession("mobile", now)
+	defer third.close()
+	if !older.closed || busy.closed {
+		t.Errorf("the idle session should be pre-empted, not the busy one")
+	}
+	third.streamCouter = 1
+	if _, reason := admitSession(newSessionContext(nil, nil), &mux.AuthRequest{User: "mobile"}); len(reason) == 0 {
+		t.Errorf("session should be rejected if no idle session to pre-empt")
+	}
+}
+
+func TestAdmitSessionLimits(t *testing.T) {
+	SetSessionLimitConfig(SessionLimitConfig{MaxStreamsPerSession: 2, MaxSessionsPerUser: 1, MaxSessionsPerSourceIP: 2})
+	defer SetSessionLimitConfig(SessionLimitConfig{})
+	if !allowedStreams(2) || allowedStreams(3) {
+		t.Errorf("streams should be limited to 2")
+	}
+	admit := func(user string) (*sessionContext, int) {
+		_, server := newTestSessionPair(t)
+		ctx := newSessionContext(server, nil)
+		code, _ := admitSession(ctx, &mux.AuthRequest{User: user, SessionID: user})
+		if code == mux.AuthOK {
+			liveSessions.Store(ctx, true)
+		}
+		return ctx, code
+	}
+	for _, c := range []struct {
+		user string
+		code int
+	}{
+		{"a", mux.AuthOK},
+		{"a", mux.AuthSessionLimitRejected},
+		{"b", mux.AuthOK},
+		//both sessions are from 127.0.0.1
+		{"c", mux.AuthSourceIPLimitRejected},
+	} {
+		ctx, code := admit(c.user)
+		defer ctx.close()
+		if code != c.code {
+			t.Errorf("session of %s: expect code %d, but got %d", c.user, c.code, code)
+		}
+	}
+	//sessions without source address are only limited per user
+	if code, _ := admitSession(newSessionContext(nil, nil), &mux.AuthRequest{User: "c"}); code != mux.AuthOK {
+		t.Errorf("session without source address should be admitted, but got %d", code)
+	}
+}
+
+func TestStreamLimitRejectExtraStreams(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	SetSessionLimitConfig(SessionLimitConfig{MaxStreamsPerSession: 1})
+	defer SetSessionLimitConfig(SessionLimitConfig{})
+	//the echo target never closes, the relay of a closed stream ends by idle timeout
+	idleTimeout := defaultMuxConfig.StreamIdleTimeout
+	defaultMuxConfig.StreamIdleTimeout = 1
+	defer func() {
+		defaultMuxConfig.StreamIdleTimeout = idleTimeout
+	}()
+	session := newTestProxySession(t, &mux.AuthRequest{SessionID: "stream-limit-test", User: "limited", CompressMethod: mux.NoneCompressor})
+	defer session.Close()
+
+	first, err := pingTestStream(session, "tcp", echo.Addr().String())
+	if nil != err {
+		t.Fatalf("first stream rejected for reason:%v", err)
+	}
+	if stream, err := pingTestStream(session, "tcp", echo.Addr().String()); nil == err || !strings.Contains(err.Error(), "too many streams") {
+		t.Errorf("stream over the limit not rejected, got %v", err)
+		if nil != stream {
+			stream.Close()
+		}
+	}
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stream, err := pingTestStream(session, "tcp", echo.Addr().String())
+		if nil == err {
+			stream.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stream still rejected after the first one closed:%v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
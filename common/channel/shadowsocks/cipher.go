@@ -0,0 +1,240 @@
+package shadowsocks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/yinqiwen/gsnova/common/socks"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// AEAD ciphers of the shadowsocks protocol, a stream is the salt followed by chunks of sealed 2 bytes length &
+// sealed payload with a little endian counter nonce; a packet is the salt followed by the sealed payload
+// with zero nonce. The first payload of a stream or each packet is prefixed by the socks5 style target address.
+
+const (
+	maxChunkSize = 0x3FFF
+	subkeyInfo   = "ss-subkey"
+)
+
+var errShortPacket = errors.New("shadowsocks packet too short")
+
+type aeadCipher struct {
+	keySize int
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if nil != err {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var aeadCiphers = map[string]aeadCipher{
+	"aes-128-gcm":             {16, newGCM},
+	"aes-192-gcm":             {24, newGCM},
+	"aes-256-gcm":             {32, newGCM},
+	"chacha20-ietf-poly1305":  {32, chacha20poly1305.New},
+	"xchacha20-ietf-poly1305": {32, chacha20poly1305.NewX},
+}
+
+// shadowCipher is the cipher of a server, the salt is as long as the key
+type shadowCipher struct {
+	aeadCipher
+	key []byte
+}
+
+// evpBytesToKey derive the master key from the password like openssl EVP_BytesToKey with md5
+func evpBytesToKey(password string, keySize int) []byte {
+	var key, prev []byte
+	for len(key) < keySize {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:keySize]
+}
+
+func newShadowCipher(method, password string) (*shadowCipher, error) {
+	c, exist := aeadCiphers[strings.ToLower(method)]
+	if !exist {
+		return nil, fmt.Errorf("unsupported shadowsocks method:%s", method)
+	}
+	return &shadowCipher{aeadCipher: c, key: evpBytesToKey(password, c.keySize)}, nil
+}
+
+func (c *shadowCipher) saltSize() int {
+	return c.keySize
+}
+
+// aead derive the subkey of the salt
+func (c *shadowCipher) aead(salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, c.keySize)
+	if _, err := io.ReadFull(hkdf.New(sha1.New, c.key, salt, []byte(subkeyInfo)), subkey); nil != err {
+		return nil, err
+	}
+	return c.newAEAD(subkey)
+}
+
+func increaseNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// streamConn seal & open chunks of the tcp conn to the server
+type streamConn struct {
+	net.Conn
+	cipher   *shadowCipher
+	enc      cipher.AEAD
+	encNonce []byte
+	dec      cipher.AEAD
+	decNonce []byte
+	//opened payload not read yet
+	pending []byte
+	buf     []byte
+}
+
+func newStreamConn(conn net.Conn, c *shadowCipher) *streamConn {
+	return &streamConn{Conn: conn, cipher: c}
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	var out []byte
+	if nil == c.enc {
+		salt := make([]byte, c.cipher.saltSize())
+		rand.Read(salt)
+		enc, err := c.cipher.aead(salt)
+		if nil != err {
+			return 0, err
+		}
+		c.enc, c.encNonce = enc, make([]byte, enc.NonceSize())
+		out = salt
+	}
+	overhead := c.enc.Overhead()
+	n := 0
+	for n < len(p) {
+		size := len(p) - n
+		if size > maxChunkSize {
+			size = maxChunkSize
+		}
+		out = c.enc.Seal(out, c.encNonce, []byte{byte(size >> 8), byte(size)}, nil)
+		increaseNonce(c.encNonce)
+		out = c.enc.Seal(out, c.encNonce, p[n:n+size], nil)
+		increaseNonce(c.encNonce)
+		n += size
+		if len(out) > 64*1024-maxChunkSize-2*overhead {
+			if _, err := c.Conn.Write(out); nil != err {
+				return 0, err
+			}
+			out = out[:0]
+		}
+	}
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); nil != err {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if err := c.readChunk(); nil != err {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *streamConn) readChunk() error {
+	if nil == c.dec {
+		salt := make([]byte, c.cipher.saltSize())
+		if _, err := io.ReadFull(c.Conn, salt); nil != err {
+			return err
+		}
+		dec, err := c.cipher.aead(salt)
+		if nil != err {
+			return err
+		}
+		c.dec, c.decNonce = dec, make([]byte, dec.NonceSize())
+		c.buf = make([]byte, maxChunkSize+dec.Overhead())
+	}
+	overhead := c.dec.Overhead()
+	b := c.buf[:2+overhead]
+	if _, err := io.ReadFull(c.Conn, b); nil != err {
+		return err
+	}
+	if _, err := c.dec.Open(b[:0], c.decNonce, b, nil); nil != err {
+		return err
+	}
+	increaseNonce(c.decNonce)
+	size := (int(b[0])<<8 | int(b[1])) & maxChunkSize
+	b = c.buf[:size+overhead]
+	if _, err := io.ReadFull(c.Conn, b); nil != err {
+		return err
+	}
+	payload, err := c.dec.Open(b[:0], c.decNonce, b, nil)
+	if nil != err {
+		return err
+	}
+	increaseNonce(c.decNonce)
+	c.pending = payload
+	return nil
+}
+
+func (c *shadowCipher) sealPacket(payload []byte) ([]byte, error) {
+	salt := make([]byte, c.saltSize())
+	rand.Read(salt)
+	aead, err := c.aead(salt)
+	if nil != err {
+		return nil, err
+	}
+	return aead.Seal(salt, make([]byte, aead.NonceSize()), payload, nil), nil
+}
+
+func (c *shadowCipher) openPacket(b []byte) ([]byte, error) {
+	if len(b) < c.saltSize() {
+		return nil, errShortPacket
+	}
+	aead, err := c.aead(b[:c.saltSize()])
+	if nil != err {
+		return nil, err
+	}
+	if len(b) < c.saltSize()+aead.Overhead() {
+		return nil, errShortPacket
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), b[c.saltSize():], nil)
+}
+
+// encodeAddr encode the 'host:port' into the socks5 address & append the data, which is the socks5 udp
+// datagram without the RSV & FRAG
+func encodeAddr(addr string, data []byte) ([]byte, error) {
+	b, err := socks.BuildUDPDatagram(addr, data)
+	if nil != err {
+		return nil, err
+	}
+	return b[3:], nil
+}
+
+func decodeAddr(b []byte) (string, []byte, error) {
+	return socks.ParseUDPDatagram(append([]byte{0, 0, 0}, b...))
+}
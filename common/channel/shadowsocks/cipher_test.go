@@ -0,0 +1,121 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestKeyDerivation(t *testing.T) {
+	c, err := newShadowCipher("AES-256-GCM", "password")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(c.key) != "5f4dcc3b5aa765d61d8327deb882cf992b95990a9151374abd8ff8c5a7a0fe08" {
+		t.Fatalf("master key mismatch:%x", c.key)
+	}
+	salt := make([]byte, c.saltSize())
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+	aead, err := c.aead(salt)
+	if nil != err {
+		t.Fatal(err)
+	}
+	//subkey ee187aed... sealing empty plaintext with zero nonce
+	expected, _ := newGCM(mustHex("ee187aed3f87574907a39db98606f60a526114831288097cac66054b33a9464f"))
+	nonce := make([]byte, aead.NonceSize())
+	if !bytes.Equal(aead.Seal(nil, nonce, nil, nil), expected.Seal(nil, nonce, nil, nil)) {
+		t.Fatalf("subkey mismatch")
+	}
+	if _, err := newShadowCipher("rc4-md5", "password"); nil == err {
+		t.Fatalf("stream ciphers should be rejected")
+	}
+}
+
+func mustHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func TestStreamConn(t *testing.T) {
+	for method := range aeadCiphers {
+		c, _ := newShadowCipher(method, "secret")
+		local, remote := net.Pipe()
+		data := make([]byte, 3*maxChunkSize+100)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		go func() {
+			conn := newStreamConn(local, c)
+			conn.Write(data[:10])
+			conn.Write(data[10:])
+			local.Close()
+		}()
+		b, err := ioutil.ReadAll(newStreamConn(remote, c))
+		if nil != err || !bytes.Equal(b, data) {
+			t.Fatalf("stream of %s mismatch:%v %d", method, err, len(b))
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	c, _ := newShadowCipher("chacha20-ietf-poly1305", "secret")
+	for _, addr := range []string{"1.2.3.4:53", "[2001:db8::1]:443", "example.com:80"} {
+		b, err := encodeAddr(addr, []byte("payload"))
+		if nil != err {
+			t.Fatal(err)
+		}
+		sealed, err := c.sealPacket(b)
+		if nil != err {
+			t.Fatal(err)
+		}
+		opened, err := c.openPacket(sealed)
+		if nil != err {
+			t.Fatal(err)
+		}
+		daddr, payload, err := decodeAddr(opened)
+		if nil != err || net.JoinHostPort(splitHost(daddr)) != net.JoinHostPort(splitHost(addr)) || string(payload) != "payload" {
+			t.Fatalf("packet mismatch:%v %s %q", err, daddr, payload)
+		}
+		sealed[len(sealed)-1]++
+		if _, err := c.openPacket(sealed); nil == err {
+			t.Fatalf("tampered packet should be rejected")
+		}
+	}
+	if _, err := c.openPacket([]byte("short")); nil == err {
+		t.Fatalf("short packet should be rejected")
+	}
+}
+
+func splitHost(addr string) (string, string) {
+	host, port, _ := net.SplitHostPort(addr)
+	return host, port
+}
+
+func TestParseServerURL(t *testing.T) {
+	for _, server := range []string{
+		"ss://YWVzLTI1Ni1nY206cGFzc3dvcmQ@1.1.1.1:8388",
+		"ss://YWVzLTI1Ni1nY206cGFzc3dvcmQ=@1.1.1.1:8388#tag",
+		"ss://aes-256-gcm:password@1.1.1.1:8388",
+	} {
+		hostport, c, err := parseServerURL(server)
+		if nil != err {
+			t.Fatalf("parse %s failed:%v", server, err)
+		}
+		if hostport != "1.1.1.1:8388" || hex.EncodeToString(c.key) != "5f4dcc3b5aa765d61d8327deb882cf992b95990a9151374abd8ff8c5a7a0fe08" {
+			t.Fatalf("parse %s mismatch:%s %x", server, hostport, c.key)
+		}
+	}
+	for _, server := range []string{
+		"ss://1.1.1.1:8388",
+		"ss://aes-256-gcm:password@1.1.1.1",
+		"ss://YWVzLTI1Ni1nY206cGFzc3dvcmQ@1.1.1.1:8388?plugin=obfs-local",
+	} {
+		if _, _, err := parseServerURL(server); nil == err {
+			t.Fatalf("parse %s should fail", server)
+		}
+	}
+}
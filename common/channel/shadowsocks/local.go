@@ -0,0 +1,287 @@
+package shadowsocks
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/netx"
+)
+
+var errUDPOverProxy = errors.New("shadowsocks udp relay is not supported over proxy")
+
+// parseServerURL parse the SIP002 url 'ss://base64url(method:password)@host:port' or the plain
+// 'ss://method:password@host:port'
+func parseServerURL(server string) (string, *shadowCipher, error) {
+	u, err := url.Parse(server)
+	if nil != err {
+		return "", nil, err
+	}
+	if nil == u.User {
+		return "", nil, fmt.Errorf("no method & password in shadowsocks server:%s", server)
+	}
+	if len(u.Query().Get("plugin")) > 0 {
+		return "", nil, fmt.Errorf("shadowsocks plugin is not supported:%s", u.Query().Get("plugin"))
+	}
+	if _, port, _ := net.SplitHostPort(u.Host); len(port) == 0 {
+		return "", nil, fmt.Errorf("no port in shadowsocks server:%s", server)
+	}
+	method := u.User.Username()
+	password, exist := u.User.Password()
+	if !exist {
+		userinfo := strings.TrimRight(method, "=")
+		var b []byte
+		if b, err = base64.RawURLEncoding.DecodeString(userinfo); nil != err {
+			b, err = base64.RawStdEncoding.DecodeString(userinfo)
+		}
+		if nil != err {
+			return "", nil, fmt.Errorf("invalid userinfo in shadowsocks server:%s", server)
+		}
+		ss := strings.SplitN(string(b), ":", 2)
+		if len(ss) != 2 {
+			return "", nil, fmt.Errorf("invalid userinfo in shadowsocks server:%s", server)
+		}
+		method, password = ss[0], ss[1]
+	}
+	c, err := newShadowCipher(method, password)
+	if nil != err {
+		return "", nil, err
+	}
+	return u.Host, c, nil
+}
+
+type shadowStream struct {
+	net.Conn
+	session *shadowMuxSession
+	//the target address to prefix udp packets
+	udpAddr      string
+	latestIOTime time.Time
+}
+
+func (s *shadowStream) Auth(req *mux.AuthRequest) error {
+	return nil
+}
+
+func (s *shadowStream) Connect(network string, addr string, opt mux.StreamOptions) error {
+	var err error
+	switch network {
+	case "tcp":
+		err = s.connectTCP(addr, opt)
+	case "udp":
+		err = s.connectUDP(addr, opt)
+	default:
+		return channel.ErrNotSupportedOperation
+	}
+	if nil != err {
+		logger.Error("[ERROR]Failed to connect %s via shadowsocks server %s with error:%v", addr, s.session.server, err)
+	}
+	return err
+}
+
+func (s *shadowStream) connectTCP(addr string, opt mux.StreamOptions) error {
+	//the target address is sent with the early data to save a round trip
+	head, err := encodeAddr(addr, opt.EarlyData)
+	if nil != err {
+		return err
+	}
+	c, err := channel.DialServerByConf("tcp://"+s.session.server, s.session.conf)
+	if nil != err {
+		return err
+	}
+	conn := newStreamConn(c, s.session.cipher)
+	if _, err = conn.Write(head); nil != err {
+		c.Close()
+		return err
+	}
+	s.Conn = conn
+	return nil
+}
+
+func (s *shadowStream) connectUDP(addr string, opt mux.StreamOptions) error {
+	if len(s.session.conf.Proxy) > 0 {
+		return errUDPOverProxy
+	}
+	host, port, err := net.SplitHostPort(s.session.server)
+	if nil != err {
+		return err
+	}
+	if net.ParseIP(host) == nil {
+		if host, err = dns.DnsGetDoaminIP(host); nil != err {
+			return err
+		}
+	}
+	if 0 == opt.DialTimeout {
+		opt.DialTimeout = 5000
+	}
+	c, err := netx.DialTimeout("udp", net.JoinHostPort(host, port), time.Duration(opt.DialTimeout)*time.Millisecond)
+	if nil != err {
+		return err
+	}
+	s.Conn = c
+	s.udpAddr = addr
+	if len(opt.EarlyData) > 0 {
+		if _, err = s.Write(opt.EarlyData); nil != err {
+			c.Close()
+			s.Conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shadowStream) StreamID() uint32 {
+	return 0
+}
+
+func (s *shadowStream) LatestIOTime() time.Time {
+	return s.latestIOTime
+}
+
+func (s *shadowStream) Read(p []byte) (int, error) {
+	if nil == s.Conn {
+		return 0, io.EOF
+	}
+	s.latestIOTime = time.Now()
+	if len(s.udpAddr) == 0 {
+		return s.Conn.Read(p)
+	}
+	b := make([]byte, 64*1024)
+	for {
+		n, err := s.Conn.Read(b)
+		if nil != err {
+			return 0, err
+		}
+		payload, err := s.session.cipher.openPacket(b[:n])
+		if nil == err {
+			_, payload, err = decodeAddr(payload)
+		}
+		if nil != err {
+			logger.Debug("Drop invalid shadowsocks packet from %s:%v", s.session.server, err)
+			continue
+		}
+		return copy(p, payload), nil
+	}
+}
+
+func (s *shadowStream) Write(p []byte) (int, error) {
+	if nil == s.Conn {
+		return 0, io.EOF
+	}
+	s.latestIOTime = time.Now()
+	if len(s.udpAddr) == 0 {
+		return s.Conn.Write(p)
+	}
+	b, err := encodeAddr(s.udpAddr, p)
+	if nil == err {
+		b, err = s.session.cipher.sealPacket(b)
+	}
+	if nil == err {
+		_, err = s.Conn.Write(b)
+	}
+	if nil != err {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *shadowStream) Close() error {
+	conn := s.Conn
+	if nil != conn {
+		conn.Close()
+		s.Conn = nil
+	}
+	s.session.closeStream(s)
+	return nil
+}
+
+type shadowMuxSession struct {
+	conf         *channel.ProxyChannelConfig
+	server       string
+	cipher       *shadowCipher
+	streams      map[*shadowStream]bool
+	streamsMutex sync.Mutex
+}
+
+func (ss *shadowMuxSession) closeStream(s *shadowStream) {
+	ss.streamsMutex.Lock()
+	defer ss.streamsMutex.Unlock()
+	delete(ss.streams, s)
+}
+
+func (ss *shadowMuxSession) CloseStream(stream mux.MuxStream) error {
+	return nil
+}
+
+func (ss *shadowMuxSession) OpenStream() (mux.MuxStream, error) {
+	ss.streamsMutex.Lock()
+	defer ss.streamsMutex.Unlock()
+	stream := &shadowStream{
+		session: ss,
+	}
+	ss.streams[stream] = true
+	return stream, nil
+}
+
+func (ss *shadowMuxSession) AcceptStream() (mux.MuxStream, error) {
+	return nil, channel.ErrNotSupportedOperation
+}
+
+func (ss *shadowMuxSession) NumStreams() int {
+	ss.streamsMutex.Lock()
+	defer ss.streamsMutex.Unlock()
+	return len(ss.streams)
+}
+
+func (ss *shadowMuxSession) Ping() (time.Duration, error) {
+	return 0, nil
+}
+
+func (ss *shadowMuxSession) Close() error {
+	ss.streamsMutex.Lock()
+	streams := ss.streams
+	ss.streams = make(map[*shadowStream]bool)
+	ss.streamsMutex.Unlock()
+	for stream := range streams {
+		stream.Close()
+	}
+	return nil
+}
+
+// ShadowsocksProxy relay streams to the shadowsocks server with AEAD ciphers, each stream is a new connection
+type ShadowsocksProxy struct {
+}
+
+func (p *ShadowsocksProxy) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
+	hostport, cipher, err := parseServerURL(server)
+	if nil != err {
+		return nil, err
+	}
+	session := &shadowMuxSession{
+		conf:    conf,
+		server:  hostport,
+		cipher:  cipher,
+		streams: make(map[*shadowStream]bool),
+	}
+	return session, nil
+}
+
+func (p *ShadowsocksProxy) Features() channel.FeatureSet {
+	return channel.FeatureSet{
+		AutoExpire: false,
+		Pingable:   false,
+	}
+}
+
+func init() {
+	channel.RegisterLocalChannelType("ss", &ShadowsocksProxy{})
+}
@@ -0,0 +1,268 @@
+package channel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+const (
+	//server send the bytes to client
+	SpeedTestDownload = "download"
+	//client send the bytes to server
+	SpeedTestUpload = "upload"
+
+	speedTestTimeout = 60 * time.Second
+)
+
+// SpeedTestConfig of the speed test served to clients, tests are counted into quotas of users
+type SpeedTestConfig struct {
+	Disable bool
+	//larger tests are cut to the size, default '100M'
+	MaxSize string
+	//min interval of tests in one direction per user, default 300
+	CooldownSecs int
+	//concurrent tests of the server, default 2
+	MaxConcurrent int
+}
+
+var speedTestConfig atomic.Value
+
+func SetSpeedTestConfig(cfg SpeedTestConfig) error {
+	c := &speedTestSettings{conf: cfg, maxSize: 100 * 1024 * 1024}
+	if len(cfg.MaxSize) > 0 {
+		v, err := helper.ToBytes(cfg.MaxSize)
+		if nil != err {
+			return fmt.Errorf("invalid speed test MaxSize:%s with reason:%v", cfg.MaxSize, err)
+		}
+		c.maxSize = int64(v)
+	}
+	if c.conf.CooldownSecs <= 0 {
+		c.conf.CooldownSecs = 300
+	}
+	if c.conf.MaxConcurrent <= 0 {
+		c.conf.MaxConcurrent = 2
+	}
+	speedTestConfig.Store(c)
+	return nil
+}
+
+type speedTestSettings struct {
+	conf    SpeedTestConfig
+	maxSize int64
+}
+
+func getSpeedTestConfig() *speedTestSettings {
+	if c, ok := speedTestConfig.Load().(*speedTestSettings); ok {
+		return c
+	}
+	return &speedTestSettings{conf: SpeedTestConfig{CooldownSecs: 300, MaxConcurrent: 2}, maxSize: 100 * 1024 * 1024}
+}
+
+type speedTestLimiter struct {
+	mutex   sync.Mutex
+	running int
+	//latest test time of 'user:direction'
+	lastTests map[string]time.Time
+}
+
+var speedTests = &speedTestLimiter{lastTests: make(map[string]time.Time)}
+
+// acquire return the reason if the test is not allowed now
+func (l *speedTestLimiter) acquire(key string, cfg *speedTestSettings, now time.Time) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.running >= cfg.conf.MaxConcurrent {
+		return "too many running speed tests"
+	}
+	cooldown := time.Duration(cfg.conf.CooldownSecs) * time.Second
+	if last, exist := l.lastTests[key]; exist && now.Sub(last) < cooldown {
+		return fmt.Sprintf("speed test cooldown, retry after %v", (cooldown - now.Sub(last)).Truncate(time.Second))
+	}
+	for k, t := range l.lastTests {
+		if now.Sub(t) >= cooldown {
+			delete(l.lastTests, k)
+		}
+	}
+	l.lastTests[key] = now
+	l.running++
+	return ""
+}
+
+func (l *speedTestLimiter) release() {
+	l.mutex.Lock()
+	l.running--
+	l.mutex.Unlock()
+}
+
+// speedTestAddr is the 'Addr' of speed test connect requests like 'download:10485760'
+func speedTestAddr(direction string, size int64) string {
+	return direction + ":" + strconv.FormatInt(size, 10)
+}
+
+func parseSpeedTestAddr(addr string) (string, int64, error) {
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) != 2 || (parts[0] != SpeedTestDownload && parts[0] != SpeedTestUpload) {
+		return "", 0, fmt.Errorf("invalid speed test:%s", addr)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if nil != err || size <= 0 {
+		return "", 0, fmt.Errorf("invalid speed test size:%s", parts[1])
+	}
+	return parts[0], size, nil
+}
+
+// random payload of download tests, never sent from pooled buffers which may hold data of other streams
+var speedTestPayload = func() []byte {
+	b := make([]byte, 64*1024)
+	rand.Read(b)
+	return b
+}()
+
+// payloadReader read 'n' bytes of the payload repeatedly
+type payloadReader struct {
+	n int64
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n := copy(p, speedTestPayload)
+	r.n -= int64(n)
+	return n, nil
+}
+
+func handleSpeedTestStream(stream mux.MuxStream, ctx *sessionContext, creq *mux.ConnectRequest, audit *streamAudit) {
+	direction, size, err := parseSpeedTestAddr(creq.Addr)
+	if nil != err {
+		audit.reject(stream, creq, err.Error())
+		return
+	}
+	cfg := getSpeedTestConfig()
+	if cfg.conf.Disable {
+		audit.reject(stream, creq, "speed test is disabled")
+		return
+	}
+	if reason := speedTests.acquire(ctx.auth.User+":"+direction, cfg, time.Now()); len(reason) > 0 {
+		ctx.log(stream).Notice("Reject speed test for reason:%s", reason)
+		audit.reject(stream, creq, reason)
+		return
+	}
+	defer speedTests.release()
+	defer stream.Close()
+	if size > cfg.maxSize {
+		size = cfg.maxSize
+	}
+	replyConnect(stream, creq, nil)
+	stream.SetReadDeadline(time.Now().Add(speedTestTimeout))
+	stream.SetWriteDeadline(time.Now().Add(speedTestTimeout))
+	buf := GetStreamBuffer()
+	defer PutStreamBuffer(buf)
+	start := time.Now()
+	if direction == SpeedTestDownload {
+		audit.down, err = io.CopyBuffer(stream, withQuota(ctx.auth.User, &payloadReader{n: size}), *buf)
+	} else {
+		audit.up, err = io.CopyBuffer(ioutil.Discard, withQuota(ctx.auth.User, io.LimitReader(stream, size)), *buf)
+		if nil == err {
+			//the count tells client the upload is done
+			ack := make([]byte, 8)
+			binary.BigEndian.PutUint64(ack, uint64(audit.up))
+			_, err = stream.Write(ack)
+		}
+	}
+	if nil != err {
+		audit.reason = "speed test failed:" + err.Error()
+	}
+	ctx.log(stream).Notice("Speed test %s %d bytes in %v", direction, audit.up+audit.down, time.Now().Sub(start))
+}
+
+// SpeedTestResult is the throughput measured to the server of a channel
+type SpeedTestResult struct {
+	Channel       string
+	Session       string
+	DownloadBytes int64
+	//bytes per second
+	DownloadRate int64
+	UploadBytes  int64
+	UploadRate   int64
+}
+
+func speedTestStream(channelName string, direction string, size int64) (mux.MuxStream, error) {
+	if channelName == DirectChannelName {
+		return nil, fmt.Errorf("no server to test by channel:%s", channelName)
+	}
+	stream, conf, err := GetMuxStreamByChannel(channelName)
+	if nil != err {
+		return nil, err
+	}
+	if !supportProtocolLevel(conf, stream, mux.SpeedTestProtocolLevel) {
+		stream.Close()
+		return nil, fmt.Errorf("server of channel:%s does not support speed test", channelName)
+	}
+	if err = stream.Connect(mux.SpeedTestNetwork, speedTestAddr(direction, size), mux.StreamOptions{WaitResponse: true}); nil != err {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func bytesRate(n int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(n) / d.Seconds())
+}
+
+// SpeedTest download then upload 'size' bytes from/to the server of the channel
+func SpeedTest(channelName string, size int64) (*SpeedTestResult, error) {
+	res := &SpeedTestResult{Channel: channelName}
+	stream, err := speedTestStream(channelName, SpeedTestDownload, size)
+	if nil != err {
+		return nil, err
+	}
+	res.Session = mux.GetStreamSessionID(stream)
+	start := time.Now()
+	stream.SetReadDeadline(start.Add(speedTestTimeout))
+	buf := GetStreamBuffer()
+	defer PutStreamBuffer(buf)
+	res.DownloadBytes, err = io.CopyBuffer(ioutil.Discard, stream, *buf)
+	res.DownloadRate = bytesRate(res.DownloadBytes, time.Now().Sub(start))
+	stream.Close()
+	if nil != err {
+		return res, err
+	}
+
+	if stream, err = speedTestStream(channelName, SpeedTestUpload, size); nil != err {
+		return res, err
+	}
+	defer stream.Close()
+	start = time.Now()
+	stream.SetWriteDeadline(start.Add(speedTestTimeout))
+	stream.SetReadDeadline(start.Add(speedTestTimeout))
+	//writing fails once the server cut the test by its max size, the ack is still readable
+	_, werr := io.CopyBuffer(stream, &payloadReader{n: size}, *buf)
+	ack := make([]byte, 8)
+	if _, err = io.ReadFull(stream, ack); nil != err {
+		if nil != werr {
+			err = werr
+		}
+		return res, err
+	}
+	res.UploadBytes = int64(binary.BigEndian.Uint64(ack))
+	res.UploadRate = bytesRate(res.UploadBytes, time.Now().Sub(start))
+	return res, nil
+}
@@ -0,0 +1,50 @@
+package channel
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseSpeedTestAddr(t *testing.T) {
+	direction, size, err := parseSpeedTestAddr(speedTestAddr(SpeedTestUpload, 1024))
+	if nil != err || direction != SpeedTestUpload || size != 1024 {
+		t.Fatalf("unexpected %s %d %v", direction, size, err)
+	}
+	for _, addr := range []string{"download", "sideways:10", "download:-1", "upload:x"} {
+		if _, _, err = parseSpeedTestAddr(addr); nil == err {
+			t.Errorf("expect error for %s", addr)
+		}
+	}
+}
+
+func TestSpeedTestLimiter(t *testing.T) {
+	cfg := &speedTestSettings{conf: SpeedTestConfig{CooldownSecs: 60, MaxConcurrent: 1}}
+	l := &speedTestLimiter{lastTests: make(map[string]time.Time)}
+	now := time.Now()
+	if reason := l.acquire("u1:download", cfg, now); len(reason) > 0 {
+		t.Fatalf("unexpected reject:%s", reason)
+	}
+	if reason := l.acquire("u2:download", cfg, now); len(reason) == 0 {
+		t.Errorf("expect rejected by max concurrent")
+	}
+	l.release()
+	if reason := l.acquire("u1:download", cfg, now.Add(30*time.Second)); len(reason) == 0 {
+		t.Errorf("expect rejected by cooldown")
+	}
+	if reason := l.acquire("u1:upload", cfg, now.Add(30*time.Second)); len(reason) > 0 {
+		t.Errorf("unexpected reject of another direction:%s", reason)
+	}
+	l.release()
+	if reason := l.acquire("u1:download", cfg, now.Add(61*time.Second)); len(reason) > 0 {
+		t.Errorf("unexpected reject after cooldown:%s", reason)
+	}
+}
+
+func TestPayloadReader(t *testing.T) {
+	n, err := io.Copy(ioutil.Discard, &payloadReader{n: 200*1024 + 7})
+	if nil != err || n != 200*1024+7 {
+		t.Errorf("expect %d bytes, but got %d with err:%v", 200*1024+7, n, err)
+	}
+}
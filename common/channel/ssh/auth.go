@@ -0,0 +1,164 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// loadSigner parse the private key file, encrypted keys are decrypted by the passphrase
+func loadSigner(path string, passphrase string) (ssh.Signer, error) {
+	content, err := ioutil.ReadFile(path)
+	if nil != err {
+		logger.Error("Invalid SSH identify path:%s for reason:%v", path, err)
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(content)
+	if _, encrypted := err.(*ssh.PassphraseMissingError); encrypted {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("SSH key:%s is encrypted, but no 'KeyPassphrase' configured", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(content, []byte(passphrase))
+	}
+	if nil != err {
+		logger.Error("Invalid pem content for path:%s with reason:%v\n", path, err)
+		return nil, err
+	}
+	return signer, nil
+}
+
+// agentAuth sign by keys of the ssh-agent, the agent connection is kept open until the auth done
+type agentAuth struct {
+	socket string
+	conn   net.Conn
+}
+
+func (a *agentAuth) signers() ([]ssh.Signer, error) {
+	socket := a.socket
+	if len(socket) == 0 {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if len(socket) == 0 {
+		return nil, errors.New("no ssh-agent socket, neither 'AgentSocket' nor env SSH_AUTH_SOCK set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if nil != err {
+		return nil, err
+	}
+	a.close()
+	a.conn = conn
+	return agent.NewClient(conn).Signers()
+}
+
+func (a *agentAuth) close() {
+	if nil != a.conn {
+		a.conn.Close()
+		a.conn = nil
+	}
+}
+
+// authMethods of the server or jump host url, the key is tried before keys of agent, then the password,
+// the returned agent must be closed once authed
+func authMethods(u *url.URL, conf *channel.SSHConfig) ([]ssh.AuthMethod, *agentAuth, error) {
+	var signers []ssh.Signer
+	if identify := u.Query().Get("key"); len(identify) > 0 {
+		signer, err := loadSigner(identify, conf.KeyPassphrase)
+		if nil != err {
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	}
+	var methods []ssh.AuthMethod
+	var ag *agentAuth
+	if useAgent, _ := strconv.ParseBool(u.Query().Get("agent")); useAgent || conf.Agent {
+		ag = &agentAuth{socket: conf.AgentSocket}
+		//all keys must be in one method, since methods of the same name are tried once
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			agentSigners, err := ag.signers()
+			if nil != err {
+				logger.Error("[ERROR]Failed to get keys of ssh-agent with reason:%v", err)
+			}
+			return append(append([]ssh.Signer{}, signers...), agentSigners...), nil
+		}))
+	} else if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if passwd, ok := u.User.Password(); ok {
+		methods = append(methods, ssh.Password(passwd))
+	}
+	if len(methods) == 0 {
+		return nil, nil, fmt.Errorf("No password, key or agent to auth ssh server:%s", u.Host)
+	}
+	return methods, ag, nil
+}
+
+// parseHostKeys parse pinned 'SHA256:' fingerprints & authorized_keys lines
+func parseHostKeys(keys []string) (map[string]bool, []ssh.PublicKey, error) {
+	fingerprints := make(map[string]bool)
+	var pubKeys []ssh.PublicKey
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		if strings.HasPrefix(k, "SHA256:") {
+			fingerprints[strings.TrimRight(k, "=")] = true
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if nil != err {
+			return nil, nil, fmt.Errorf("invalid ssh host key:%s with reason:%v", k, err)
+		}
+		pubKeys = append(pubKeys, pub)
+	}
+	return fingerprints, pubKeys, nil
+}
+
+// hostKeyCallback verify host keys by pinned keys or known_hosts, any key is accepted with a warning
+// of its fingerprint if neither configured
+func hostKeyCallback(conf *channel.SSHConfig) (ssh.HostKeyCallback, error) {
+	if len(conf.HostKeys) == 0 && len(conf.KnownHosts) == 0 {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			logger.Notice("Accept unverified host key %s of ssh server:%s, pin it by 'HostKeys' of 'SSH'", ssh.FingerprintSHA256(key), hostname)
+			return nil
+		}, nil
+	}
+	fingerprints, pubKeys, err := parseHostKeys(conf.HostKeys)
+	if nil != err {
+		return nil, err
+	}
+	var known ssh.HostKeyCallback
+	if len(conf.KnownHosts) > 0 {
+		if known, err = knownhosts.New(conf.KnownHosts); nil != err {
+			return nil, err
+		}
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if fingerprints[fingerprint] {
+			return nil
+		}
+		for _, pub := range pubKeys {
+			if bytes.Equal(pub.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		if nil != known {
+			if err := known(hostname, remote, key); nil == err {
+				return nil
+			}
+		}
+		logger.Error("[ERROR]Reject host key %s of ssh server:%s not pinned", fingerprint, hostname)
+		return fmt.Errorf("host key %s of %s not pinned", fingerprint, hostname)
+	}, nil
+}
@@ -3,7 +3,6 @@ package ssh
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/url"
 	"sync"
@@ -48,6 +47,12 @@ func (tc *sshStream) Connect(network string, addr string, opt mux.StreamOptions)
 		tc.session.Close()
 		return err
 	}
+	if len(opt.EarlyData) > 0 {
+		if _, err = conn.Write(opt.EarlyData); nil != err {
+			conn.Close()
+			return err
+		}
+	}
 	tc.Conn = conn
 	tc.addr = addr
 	return nil
@@ -87,6 +92,8 @@ type sshMuxSession struct {
 	streams      map[*sshStream]bool
 	streamsMutex sync.Mutex
 	sshClient    *ssh.Client
+	//clients of jump hosts in dialing order, closed after the server's
+	jumpClients []*ssh.Client
 }
 
 func (tc *sshMuxSession) getSSHClient() *ssh.Client {
@@ -137,6 +144,10 @@ func (tc *sshMuxSession) Close() error {
 		tc.sshClient.Close()
 		tc.sshClient = nil
 	}
+	for i := len(tc.jumpClients) - 1; i >= 0; i-- {
+		tc.jumpClients[i].Close()
+	}
+	tc.jumpClients = nil
 	return nil
 }
 
@@ -159,62 +170,77 @@ func (p *SSHProxy) Features() channel.FeatureSet {
 	}
 }
 
-func (p *SSHProxy) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
-	u, err := url.Parse(server)
+// dialHop handshake the ssh server of the url over the conn, the conn is closed if failed
+func dialHop(c net.Conn, u *url.URL, conf *channel.SSHConfig, hostKey ssh.HostKeyCallback) (*ssh.Client, error) {
+	methods, ag, err := authMethods(u, conf)
 	if nil != err {
+		c.Close()
 		return nil, err
 	}
-	c, err := channel.DialServerByConf(server, conf)
-	if err != nil {
+	if nil != ag {
+		defer ag.close()
+	}
+	sshConf := &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            methods,
+		HostKeyCallback: hostKey,
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(c, hostPort(u), sshConf)
+	if nil != err {
+		c.Close()
 		return nil, err
 	}
-	var sshConf *ssh.ClientConfig
-	passwd, ok := u.User.Password()
-	if ok {
-		sshConf = &ssh.ClientConfig{
-			User: u.User.Username(),
-			Auth: []ssh.AuthMethod{
-				ssh.Password(passwd),
-			},
-			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-				return nil
-			},
-		}
-	} else {
-		if identify := u.Query().Get("key"); len(identify) > 0 {
-			if content, err := ioutil.ReadFile(identify); nil != err {
-				logger.Error("Invalid SSH identify path:%s for reason:%v", identify, err)
-				return nil, err
-			} else {
-				signer, err := ssh.ParsePrivateKey(content)
-				if nil != err {
-					logger.Error("Invalid pem content for path:%s with reason:%v\n", identify, err)
-					return nil, err
-				}
-				sshConf = &ssh.ClientConfig{
-					User: u.User.Username(),
-					Auth: []ssh.AuthMethod{
-						ssh.PublicKeys(signer),
-					},
-					HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-						return nil
-					},
-				}
-			}
-		} else {
-			return nil, fmt.Errorf("Can NOT connect ssh server:%s", server)
-		}
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+func hostPort(u *url.URL) string {
+	if _, _, err := net.SplitHostPort(u.Host); nil != err {
+		return net.JoinHostPort(u.Host, "22")
 	}
+	return u.Host
+}
 
-	conn, chans, reqs, err := ssh.NewClientConn(c, u.Host, sshConf)
+func (p *SSHProxy) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
+	hostKey, err := hostKeyCallback(&conf.SSH)
 	if nil != err {
 		return nil, err
 	}
-	sClient := ssh.NewClient(conn, chans, reqs)
+	//jump hosts are dialed in order, the server is dialed through the last one
+	hops := append(append([]string{}, conf.SSH.JumpHosts...), server)
+	var clients []*ssh.Client
+	for i, hop := range hops {
+		var u *url.URL
+		var c net.Conn
+		if u, err = url.Parse(hop); nil == err && u.Scheme != "ssh" {
+			err = fmt.Errorf("Invalid ssh url:%s", hop)
+		}
+		if nil == err {
+			if i == 0 {
+				c, err = channel.DialServerByConf(hop, conf)
+			} else {
+				c, err = clients[i-1].Dial("tcp", hostPort(u))
+			}
+		}
+		var client *ssh.Client
+		if nil == err {
+			client, err = dialHop(c, u, &conf.SSH, hostKey)
+		}
+		if nil != err {
+			if i < len(hops)-1 {
+				logger.Error("[ERROR]Failed to connect ssh jump host:%s with reason:%v", hop, err)
+			}
+			for j := len(clients) - 1; j >= 0; j-- {
+				clients[j].Close()
+			}
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
 	session := &sshMuxSession{
-		conf:      conf,
-		streams:   make(map[*sshStream]bool),
-		sshClient: sClient,
+		conf:        conf,
+		streams:     make(map[*sshStream]bool),
+		sshClient:   clients[len(clients)-1],
+		jumpClients: clients[:len(clients)-1],
 	}
 	return session, nil
 }
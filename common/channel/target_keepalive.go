@@ -0,0 +1,86 @@
+package channel
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// TargetKeepAliveConfig of tcp keepalive probes on connections dialed to destinations by server, streams of
+// half-open connections are reset once probes failed instead of lingering until the idle timeout
+type TargetKeepAliveConfig struct {
+	//seconds idle before the first probe, 0 means default 30, negative disables probes
+	IdleSecs int
+	//seconds between unacknowledged probes, 0 means default 10
+	IntervalSecs int
+	//unacknowledged probes before the connection is dropped, 0 means default 3
+	Count int
+}
+
+func (conf *TargetKeepAliveConfig) idle() time.Duration {
+	if conf.IdleSecs == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(conf.IdleSecs) * time.Second
+}
+
+func (conf *TargetKeepAliveConfig) interval() time.Duration {
+	if conf.IntervalSecs <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(conf.IntervalSecs) * time.Second
+}
+
+func (conf *TargetKeepAliveConfig) count() int {
+	if conf.Count <= 0 {
+		return 3
+	}
+	return conf.Count
+}
+
+var targetKeepAliveConfig atomic.Value
+
+func SetTargetKeepAliveConfig(cfg TargetKeepAliveConfig) {
+	targetKeepAliveConfig.Store(&cfg)
+}
+
+func getTargetKeepAliveConfig() *TargetKeepAliveConfig {
+	if cfg, ok := targetKeepAliveConfig.Load().(*TargetKeepAliveConfig); ok {
+		return cfg
+	}
+	return &TargetKeepAliveConfig{}
+}
+
+// applyTargetKeepAlive enable keepalive probes on the tcp connection dialed to a destination
+func applyTargetKeepAlive(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	conf := getTargetKeepAliveConfig()
+	var err error
+	if conf.IdleSecs < 0 {
+		err = tc.SetKeepAlive(false)
+	} else {
+		err = setTCPKeepAlive(tc, conf.idle(), conf.interval(), conf.count())
+	}
+	if nil != err {
+		logger.Debug("Failed to set keepalive of %v with reason:%v", tc.RemoteAddr(), err)
+	}
+}
+
+// isKeepAliveErr return true if the connection is dropped by unacknowledged keepalive probes, unlike
+// deadline timeouts the connection is dead & the stream should be reset
+func isKeepAliveErr(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ETIMEDOUT
+}
@@ -0,0 +1,33 @@
+// +build linux
+
+package channel
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+func setTCPKeepAlive(tc *net.TCPConn, idle, interval time.Duration, count int) error {
+	if err := tc.SetKeepAlive(true); nil != err {
+		return err
+	}
+	raw, err := tc.SyscallConn()
+	if nil != err {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, int(idle/time.Second)); nil != serr {
+			return
+		}
+		if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, int(interval/time.Second)); nil != serr {
+			return
+		}
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	})
+	if nil != err {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,17 @@
+// +build !linux
+
+package channel
+
+import (
+	"net"
+	"time"
+)
+
+// setTCPKeepAlive on platforms without per socket interval & count, probes are sent by the idle period
+// & dropped by the system defaults
+func setTCPKeepAlive(tc *net.TCPConn, idle, interval time.Duration, count int) error {
+	if err := tc.SetKeepAlive(true); nil != err {
+		return err
+	}
+	return tc.SetKeepAlivePeriod(idle)
+}
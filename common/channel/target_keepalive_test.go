@@ -0,0 +1,58 @@
+package channel
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/pmux"
+)
+
+func TestTargetKeepAliveDefaults(t *testing.T) {
+	conf := &TargetKeepAliveConfig{}
+	if conf.idle() != 30*time.Second || conf.interval() != 10*time.Second || conf.count() != 3 {
+		t.Fatalf("unexpected defaults:%v %v %d", conf.idle(), conf.interval(), conf.count())
+	}
+	conf = &TargetKeepAliveConfig{IdleSecs: 5, IntervalSecs: 2, Count: 4}
+	if conf.idle() != 5*time.Second || conf.interval() != 2*time.Second || conf.count() != 4 {
+		t.Fatalf("unexpected config:%v %v %d", conf.idle(), conf.interval(), conf.count())
+	}
+}
+
+func TestIsKeepAliveErr(t *testing.T) {
+	probeErr := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ETIMEDOUT)}
+	if !isKeepAliveErr(probeErr) {
+		t.Fatalf("expect keepalive error:%v", probeErr)
+	}
+	if isKeepAliveErr(pmux.ErrTimeout) || isKeepAliveErr(errors.New("EOF")) || isKeepAliveErr(nil) {
+		t.Fatalf("deadline timeouts are not keepalive errors")
+	}
+}
+
+func TestApplyTargetKeepAlive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); nil == err {
+			defer c.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	SetTargetKeepAliveConfig(TargetKeepAliveConfig{IdleSecs: 5, IntervalSecs: 2, Count: 4})
+	defer SetTargetKeepAliveConfig(TargetKeepAliveConfig{})
+	if err = setTCPKeepAlive(conn.(*net.TCPConn), 5*time.Second, 2*time.Second, 4); nil != err {
+		t.Fatalf("failed to set keepalive:%v", err)
+	}
+	applyTargetKeepAlive(conn)
+}
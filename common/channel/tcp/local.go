@@ -24,11 +24,12 @@ func (tc *TcpProxy) CreateMuxSession(server string, conf *channel.ProxyChannelCo
 		return nil, err
 	}
 	logger.Info("TCP Session:%v", server)
-	ps, err := pmux.Client(conn, channel.InitialPMuxConfig(&conf.Cipher))
+	muxConf := channel.InitialPMuxConfig(&conf.Cipher)
+	ps, err := pmux.Client(conn, muxConf)
 	if nil != err {
 		return nil, err
 	}
-	return &mux.ProxyMuxSession{Session: ps}, nil
+	return &mux.ProxyMuxSession{Session: ps, Config: muxConf}, nil
 }
 
 func init() {
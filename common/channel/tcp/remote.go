@@ -7,6 +7,7 @@ import (
 	"github.com/yinqiwen/gsnova/common/channel"
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/supervisor"
 	"github.com/yinqiwen/pmux"
 )
 
@@ -16,20 +17,21 @@ func servTCP(lp net.Listener) {
 		if nil != err {
 			continue
 		}
-		session, err := pmux.Server(conn, channel.InitialPMuxConfig(&channel.DefaultServerCipher))
+		muxConf := channel.InitialPMuxConfig(&channel.DefaultServerCipher)
+		session, err := pmux.Server(conn, muxConf)
 		if nil != err {
 			logger.Error("[ERROR]Failed to create mux session for tcp server with reason:%v", err)
 			continue
 		}
 
-		muxSession := &mux.ProxyMuxSession{Session: session}
+		muxSession := &mux.ProxyMuxSession{Session: session, Config: muxConf}
 		go channel.ServProxyMuxSession(muxSession, nil)
 	}
 	//ws.WriteMessage(websocket.CloseMessage, []byte{})
 }
 
 func StartTcpProxyServer(addr string) error {
-	lp, err := net.Listen("tcp", addr)
+	lp, err := supervisor.Listen("tcp", addr)
 	if nil != err {
 		logger.Error("[ERROR]Failed to listen TCP address:%s with reason:%v", addr, err)
 		return err
@@ -40,7 +42,7 @@ func StartTcpProxyServer(addr string) error {
 }
 
 func StartTLSProxyServer(addr string, config *tls.Config) error {
-	lp, err := net.Listen("tcp", addr)
+	lp, err := supervisor.Listen("tcp", addr)
 	if nil != err {
 		logger.Error("[ERROR]Failed to listen TLS address:%s with reason:%v", addr, err)
 		return err
@@ -0,0 +1,93 @@
+package channel
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLSPolicyConfig restrict versions, cipher suites, curves and ALPN of a TLS listener or dialer
+type TLSPolicyConfig struct {
+	//'1.0', '1.1', '1.2' or '1.3'
+	MinVersion string
+	MaxVersion string
+	//names like 'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256', not applied to TLS 1.3
+	CipherSuites []string
+	//'X25519', 'P256', 'P384' or 'P521'
+	Curves []string
+	ALPN   []string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+func tlsCipherSuite(name string) (uint16, bool) {
+	for _, s := range tls.CipherSuites() {
+		if strings.EqualFold(s.Name, name) {
+			return s.ID, true
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if strings.EqualFold(s.Name, name) {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	ver, exist := tlsVersions[strings.TrimPrefix(strings.ToUpper(v), "TLS")]
+	if !exist {
+		return 0, fmt.Errorf("invalid tls version:%s", v)
+	}
+	return ver, nil
+}
+
+// Apply set the policy on the config, ALPN already required by the transport is kept if 'ALPN' is empty
+func (p *TLSPolicyConfig) Apply(cfg *tls.Config) error {
+	var err error
+	if cfg.MinVersion, err = parseTLSVersion(p.MinVersion); nil != err {
+		return err
+	}
+	if cfg.MaxVersion, err = parseTLSVersion(p.MaxVersion); nil != err {
+		return err
+	}
+	if len(p.CipherSuites) > 0 {
+		cfg.CipherSuites = nil
+		for _, name := range p.CipherSuites {
+			id, ok := tlsCipherSuite(name)
+			if !ok {
+				return fmt.Errorf("invalid tls cipher suite:%s", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	if len(p.Curves) > 0 {
+		cfg.CurvePreferences = nil
+		for _, name := range p.Curves {
+			id, ok := tlsCurves[strings.ToUpper(name)]
+			if !ok {
+				return fmt.Errorf("invalid tls curve:%s", name)
+			}
+			cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+		}
+	}
+	if len(p.ALPN) > 0 {
+		cfg.NextProtos = append([]string{}, p.ALPN...)
+	}
+	return nil
+}
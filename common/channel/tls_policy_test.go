@@ -0,0 +1,82 @@
+package channel
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		v     string
+		ver   uint16
+		valid bool
+	}{
+		{"", 0, true},
+		{"1.0", tls.VersionTLS10, true},
+		{"1.2", tls.VersionTLS12, true},
+		{"TLS1.3", tls.VersionTLS13, true},
+		{"tls1.1", tls.VersionTLS11, true},
+		{"1.4", 0, false},
+		{"SSL3.0", 0, false},
+	}
+	for _, tt := range tests {
+		ver, err := parseTLSVersion(tt.v)
+		if ver != tt.ver || (nil == err) != tt.valid {
+			t.Errorf("%q expect version %x valid:%v, but got %x %v", tt.v, tt.ver, tt.valid, ver, err)
+		}
+	}
+}
+
+func TestTLSPolicyApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TLSPolicyConfig
+		expect *tls.Config
+		valid  bool
+	}{
+		{"empty keeps transport alpn", TLSPolicyConfig{}, &tls.Config{NextProtos: []string{"h2"}}, true},
+		{"versions", TLSPolicyConfig{MinVersion: "1.2", MaxVersion: "1.3"},
+			&tls.Config{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS13, NextProtos: []string{"h2"}}, true},
+		{"suites & curves", TLSPolicyConfig{CipherSuites: []string{"tls_ecdhe_rsa_with_aes_128_gcm_sha256", "TLS_RSA_WITH_AES_128_CBC_SHA"}, Curves: []string{"x25519", "P256"}},
+			&tls.Config{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+				CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256}, NextProtos: []string{"h2"}}, true},
+		{"alpn", TLSPolicyConfig{ALPN: []string{"http/1.1"}}, &tls.Config{NextProtos: []string{"http/1.1"}}, true},
+		{"bad min version", TLSPolicyConfig{MinVersion: "2.0"}, nil, false},
+		{"bad max version", TLSPolicyConfig{MaxVersion: "2.0"}, nil, false},
+		{"bad suite", TLSPolicyConfig{CipherSuites: []string{"TLS_NULL"}}, nil, false},
+		{"bad curve", TLSPolicyConfig{Curves: []string{"P224"}}, nil, false},
+	}
+	for _, tt := range tests {
+		cfg := &tls.Config{NextProtos: []string{"h2"}}
+		err := tt.policy.Apply(cfg)
+		if (nil == err) != tt.valid {
+			t.Errorf("%s: expect valid:%v, but got %v", tt.name, tt.valid, err)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+		if cfg.MinVersion != tt.expect.MinVersion || cfg.MaxVersion != tt.expect.MaxVersion ||
+			!reflect.DeepEqual(cfg.CipherSuites, tt.expect.CipherSuites) || !reflect.DeepEqual(cfg.CurvePreferences, tt.expect.CurvePreferences) ||
+			!reflect.DeepEqual(cfg.NextProtos, tt.expect.NextProtos) {
+			t.Errorf("%s: unexpected config min:%x max:%x suites:%v curves:%v alpn:%v", tt.name, cfg.MinVersion, cfg.MaxVersion,
+				cfg.CipherSuites, cfg.CurvePreferences, cfg.NextProtos)
+		}
+	}
+}
+
+func TestNewTLSConfigRejectInvalidPolicy(t *testing.T) {
+	conf := &ProxyChannelConfig{Name: "tls", SNI: []string{"a.test"}, TLS: TLSPolicyConfig{MinVersion: "1.3"}}
+	cfg, err := NewTLSConfig(conf)
+	if nil != err || cfg.MinVersion != tls.VersionTLS13 || cfg.ServerName != "a.test" {
+		t.Fatalf("unexpected tls config:%+v %v", cfg, err)
+	}
+	conf.TLS.Curves = []string{"bad"}
+	if _, err = NewTLSConfig(conf); nil == err {
+		t.Errorf("expect dial config rejected by invalid policy")
+	}
+	if _, err = DialServerByConf("tls://127.0.0.1:1", conf); nil == err {
+		t.Errorf("expect dial failed by invalid policy")
+	}
+}
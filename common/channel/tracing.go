@@ -0,0 +1,101 @@
+package channel
+
+import (
+	"context"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingConfig export stream lifecycle spans via OTLP
+type TracingConfig struct {
+	Enable bool
+	//OTLP grpc collector address like '127.0.0.1:4317'
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	//ratio of traced streams not carrying parent trace context, default 1
+	SampleRatio float64
+}
+
+// spans are noop until a tracer provider set by InitTracing
+var tracer = otel.Tracer("github.com/yinqiwen/gsnova")
+var tracePropagator = propagation.TraceContext{}
+var tracerProvider *sdktrace.TracerProvider
+
+func InitTracing(conf *TracingConfig) error {
+	if !conf.Enable {
+		return nil
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(conf.Endpoint)}
+	if conf.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if nil != err {
+		return err
+	}
+	name := conf.ServiceName
+	if len(name) == 0 {
+		name = "gsnova"
+	}
+	ratio := conf.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(name), semconv.ServiceVersionKey.String(Version))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	logger.Notice("Export tracing spans to OTLP collector:%s", conf.Endpoint)
+	return nil
+}
+
+// ShutdownTracing flush pending spans
+func ShutdownTracing() {
+	if nil == tracerProvider {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracerProvider.Shutdown(ctx)
+}
+
+// startStreamSpan start a span continuing the trace carried by connect request if any
+func startStreamSpan(carrier map[string]string, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if len(carrier) > 0 {
+		ctx = tracePropagator.Extract(ctx, propagation.MapCarrier(carrier))
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// traceCarrier return the trace context passed to next hop
+func traceCarrier(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	return carrier
+}
+
+func endSpan(span trace.Span, err error) {
+	if nil != err {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,152 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// the package tracer delegates to the first global provider only, so it's set once
+var testTracerProvider *sdktrace.TracerProvider
+var testTracerProviderOnce sync.Once
+
+// recordSpans let spans of the package recorded until the returned func called
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+	testTracerProviderOnce.Do(func() {
+		testTracerProvider = sdktrace.NewTracerProvider()
+		otel.SetTracerProvider(testTracerProvider)
+	})
+	recorder := tracetest.NewSpanRecorder()
+	testTracerProvider.RegisterSpanProcessor(recorder)
+	return recorder, func() { testTracerProvider.UnregisterSpanProcessor(recorder) }
+}
+
+func TestTraceCarrier(t *testing.T) {
+	if carrier := traceCarrier(context.Background()); nil != carrier {
+		t.Errorf("expect no carrier without span, but got %v", carrier)
+	}
+	recorder, restore := recordSpans()
+	defer restore()
+	parentCtx, parent := tracer.Start(context.Background(), "parent")
+	carrier := traceCarrier(parentCtx)
+	if len(carrier["traceparent"]) == 0 {
+		t.Fatalf("expect w3c traceparent in carrier, but got %v", carrier)
+	}
+	tests := []struct {
+		name    string
+		carrier map[string]string
+		child   bool
+	}{
+		{"continued", carrier, true},
+		{"root", nil, false},
+		{"invalid", map[string]string{"traceparent": "bad"}, false},
+	}
+	for _, tt := range tests {
+		_, span := startStreamSpan(tt.carrier, tt.name)
+		sc := span.SpanContext()
+		if child := sc.TraceID() == parent.SpanContext().TraceID(); child != tt.child {
+			t.Errorf("%s: expect child of the carried trace:%v, but got %v", tt.name, tt.child, child)
+		}
+		endSpan(span, nil)
+	}
+	_, span := startStreamSpan(nil, "failed")
+	endSpan(span, errors.New("dial failed"))
+	parent.End()
+	for _, s := range recorder.Ended() {
+		if s.Name() == "failed" && (s.Status().Code != codes.Error || s.Status().Description != "dial failed" || len(s.Events()) != 1) {
+			t.Errorf("expect error recorded for span, but got %+v %v", s.Status(), s.Events())
+		}
+		if s.Name() == "root" && s.Parent().IsValid() {
+			t.Errorf("expect root span without parent")
+		}
+	}
+}
+
+func TestProxyStreamSpans(t *testing.T) {
+	recorder, restore := recordSpans()
+	defer restore()
+	//answer once & close, so that server side copying ends without waiting the idle timeout
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if nil == err {
+			b := make([]byte, 4)
+			io.ReadFull(c, b)
+			c.Write(b)
+			c.Close()
+		}
+	}()
+	session, err := authTestSession(t, &mux.AuthRequest{User: "trace-user"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	parentCtx, parent := tracer.Start(context.Background(), "client")
+	stream, err := session.OpenStream()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = stream.Connect("tcp", echo.Addr().String(), mux.StreamOptions{TraceContext: traceCarrier(parentCtx)}); nil != err {
+		t.Fatal(err)
+	}
+	stream.Write([]byte("ping"))
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 4)
+	if _, err = io.ReadFull(stream, b); nil != err {
+		t.Fatal(err)
+	}
+	stream.Close()
+	parent.End()
+
+	var spans map[string]sdktrace.ReadOnlySpan
+	waitUntil(5*time.Second, func() bool {
+		spans = make(map[string]sdktrace.ReadOnlySpan)
+		for _, s := range recorder.Ended() {
+			spans[s.Name()] = s
+		}
+		return nil != spans["gsnova.stream"]
+	})
+	streamSpan := spans["gsnova.stream"]
+	if nil == streamSpan {
+		t.Fatalf("expect stream span ended, but got %v", spans)
+	}
+	if streamSpan.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expect stream span continue the client trace")
+	}
+	for _, name := range []string{"gsnova.dial", "gsnova.copy"} {
+		s := spans[name]
+		if nil == s || s.Parent().SpanID() != streamSpan.SpanContext().SpanID() {
+			t.Errorf("expect %s span as child of the stream span, but got %v", name, s)
+		}
+	}
+	attrs := make(map[string]string)
+	for _, kv := range streamSpan.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["user"] != "trace-user" || attrs["addr"] != echo.Addr().String() || attrs["network"] != "tcp" {
+		t.Errorf("unexpected stream span attributes:%v", attrs)
+	}
+	if nil == spans["gsnova.copy"] {
+		return
+	}
+	for _, kv := range spans["gsnova.copy"].Attributes() {
+		if kv.Key == "recv_bytes" && kv.Value.AsInt64() != 4 {
+			t.Errorf("expect 4 bytes recv by copy span, but got %d", kv.Value.AsInt64())
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package trojan
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/yinqiwen/gsnova/common/socks"
+)
+
+// The trojan request is 'hex(sha224(password)) CRLF CMD ATYP DST.ADDR DST.PORT CRLF' in front of the payload over tls,
+// udp packets after the request are framed as 'ATYP DST.ADDR DST.PORT LENGTH CRLF PAYLOAD'.
+
+const (
+	cmdConnect      = 1
+	cmdUDPAssociate = 3
+	hashLen         = 56
+	maxUDPPayload   = 65535
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+var crlf = []byte{'\r', '\n'}
+
+var errInvalidRequest = errors.New("invalid trojan request")
+
+// passwordHash is the hex sha224 of the password sent at the head of requests
+func passwordHash(password string) string {
+	h := sha256.Sum224([]byte(password))
+	return hex.EncodeToString(h[:])
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// validHead return false once the bytes could not be the head of a trojan request
+func validHead(b []byte) bool {
+	for i, c := range b {
+		switch {
+		case i < hashLen:
+			if !isHex(c) {
+				return false
+			}
+		case i == hashLen:
+			if c != '\r' {
+				return false
+			}
+		case c != '\n':
+			return false
+		}
+	}
+	return true
+}
+
+// encodeAddr encode 'host:port' as socks5 address, which is the socks5 udp datagram without RSV & FRAG
+func encodeAddr(addr string) ([]byte, error) {
+	b, err := socks.BuildUDPDatagram(addr, nil)
+	if nil != err {
+		return nil, err
+	}
+	return b[3:], nil
+}
+
+func readAddr(r io.Reader) (string, error) {
+	b := make([]byte, 1, 256)
+	if _, err := io.ReadFull(r, b); nil != err {
+		return "", err
+	}
+	var host string
+	switch b[0] {
+	case atypIPv4, atypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if b[0] == atypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); nil != err {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case atypDomain:
+		if _, err := io.ReadFull(r, b); nil != err {
+			return "", err
+		}
+		domain := make([]byte, b[0])
+		if _, err := io.ReadFull(r, domain); nil != err {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errInvalidRequest
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); nil != err {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func readCRLF(r io.Reader) error {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(r, b); nil != err {
+		return err
+	}
+	if b[0] != '\r' || b[1] != '\n' {
+		return errInvalidRequest
+	}
+	return nil
+}
+
+func encodeRequest(hash string, cmd byte, addr string) ([]byte, error) {
+	a, err := encodeAddr(addr)
+	if nil != err {
+		return nil, err
+	}
+	b := make([]byte, 0, hashLen+len(a)+5)
+	b = append(b, hash...)
+	b = append(b, crlf...)
+	b = append(b, cmd)
+	b = append(b, a...)
+	return append(b, crlf...), nil
+}
+
+// readRequest read the command & address after the password hash
+func readRequest(r io.Reader) (byte, string, error) {
+	cmd := make([]byte, 1)
+	if _, err := io.ReadFull(r, cmd); nil != err {
+		return 0, "", err
+	}
+	if cmd[0] != cmdConnect && cmd[0] != cmdUDPAssociate {
+		return 0, "", errInvalidRequest
+	}
+	addr, err := readAddr(r)
+	if nil == err {
+		err = readCRLF(r)
+	}
+	return cmd[0], addr, err
+}
+
+func encodePacket(addr string, payload []byte) ([]byte, error) {
+	if len(payload) > maxUDPPayload {
+		return nil, errInvalidRequest
+	}
+	a, err := encodeAddr(addr)
+	if nil != err {
+		return nil, err
+	}
+	b := make([]byte, 0, len(a)+4+len(payload))
+	b = append(b, a...)
+	b = append(b, byte(len(payload)>>8), byte(len(payload)))
+	b = append(b, crlf...)
+	return append(b, payload...), nil
+}
+
+func readPacket(r io.Reader) (string, []byte, error) {
+	addr, err := readAddr(r)
+	if nil != err {
+		return "", nil, err
+	}
+	size := make([]byte, 2)
+	if _, err = io.ReadFull(r, size); nil != err {
+		return "", nil, err
+	}
+	if err = readCRLF(r); nil != err {
+		return "", nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(size))
+	if _, err = io.ReadFull(r, payload); nil != err {
+		return "", nil, err
+	}
+	return addr, payload, nil
+}
@@ -0,0 +1,72 @@
+package trojan
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPasswordHash(t *testing.T) {
+	if h := passwordHash("password"); h != "d63dc919e201d7bc4c825630d2cf25fdc93d4b2f0d46706d29038d01" || len(h) != hashLen {
+		t.Fatalf("hash mismatch:%s", h)
+	}
+}
+
+func TestValidHead(t *testing.T) {
+	head := []byte(passwordHash("password") + "\r\n")
+	for i := 1; i <= len(head); i++ {
+		if !validHead(head[:i]) {
+			t.Fatalf("prefix of %d bytes should be valid", i)
+		}
+	}
+	for _, b := range []string{"GET / HTTP/1.1\r\n", "\x16\x03\x01", "d63dX"} {
+		if validHead([]byte(b)) {
+			t.Fatalf("%q should be invalid", b)
+		}
+	}
+	head[hashLen] = '\n'
+	if validHead(head) {
+		t.Fatalf("hash without CRLF should be invalid")
+	}
+}
+
+func TestRequestCodec(t *testing.T) {
+	for _, addr := range []string{"1.2.3.4:443", "[2001:db8::1]:53", "example.com:80"} {
+		b, err := encodeRequest(passwordHash("password"), cmdConnect, addr)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !validHead(b[:hashLen+2]) {
+			t.Fatalf("invalid head of request")
+		}
+		cmd, daddr, err := readRequest(bytes.NewReader(b[hashLen+2:]))
+		if nil != err || cmd != cmdConnect || daddr != addr {
+			t.Fatalf("request mismatch:%v %d %s", err, cmd, daddr)
+		}
+	}
+	if _, _, err := readRequest(bytes.NewReader([]byte{2, atypIPv4, 1, 2, 3, 4, 0, 80, '\r', '\n'})); nil == err {
+		t.Fatalf("unknown command should be rejected")
+	}
+	if _, _, err := readRequest(bytes.NewReader([]byte{cmdConnect, atypIPv4, 1, 2, 3, 4, 0, 80, '\n', '\r'})); nil == err {
+		t.Fatalf("request without CRLF should be rejected")
+	}
+}
+
+func TestPacketCodec(t *testing.T) {
+	var buf bytes.Buffer
+	for _, addr := range []string{"8.8.8.8:53", "example.com:443"} {
+		b, err := encodePacket(addr, []byte("payload of "+addr))
+		if nil != err {
+			t.Fatal(err)
+		}
+		buf.Write(b)
+	}
+	for _, addr := range []string{"8.8.8.8:53", "example.com:443"} {
+		daddr, payload, err := readPacket(&buf)
+		if nil != err || daddr != addr || string(payload) != "payload of "+addr {
+			t.Fatalf("packet mismatch:%v %s %q", err, daddr, payload)
+		}
+	}
+	if _, err := encodePacket("8.8.8.8:53", make([]byte, maxUDPPayload+1)); nil == err {
+		t.Fatalf("too large payload should be rejected")
+	}
+}
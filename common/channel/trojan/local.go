@@ -0,0 +1,214 @@
+package trojan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+type trojanLocalStream struct {
+	net.Conn
+	session *trojanMuxSession
+	reader  *bufio.Reader
+	//the target address of udp packets
+	udpAddr      string
+	latestIOTime time.Time
+}
+
+func (s *trojanLocalStream) Auth(req *mux.AuthRequest) error {
+	return nil
+}
+
+func (s *trojanLocalStream) Connect(network string, addr string, opt mux.StreamOptions) error {
+	var cmd byte
+	switch network {
+	case "tcp":
+		cmd = cmdConnect
+	case "udp":
+		cmd = cmdUDPAssociate
+	default:
+		return channel.ErrNotSupportedOperation
+	}
+	req, err := encodeRequest(s.session.hash, cmd, addr)
+	if nil != err {
+		return err
+	}
+	if len(opt.EarlyData) > 0 {
+		if cmd == cmdConnect {
+			req = append(req, opt.EarlyData...)
+		} else {
+			b, err := encodePacket(addr, opt.EarlyData)
+			if nil != err {
+				return err
+			}
+			req = append(req, b...)
+		}
+	}
+	c, err := channel.DialServerByConf(s.session.server, s.session.conf)
+	if nil == err {
+		if _, err = c.Write(req); nil != err {
+			c.Close()
+		}
+	}
+	if nil != err {
+		logger.Error("[ERROR]Failed to connect %s via trojan server %s with error:%v", addr, s.session.server, err)
+		return err
+	}
+	s.Conn = c
+	s.reader = bufio.NewReader(c)
+	if cmd == cmdUDPAssociate {
+		s.udpAddr = addr
+	}
+	return nil
+}
+
+func (s *trojanLocalStream) StreamID() uint32 {
+	return 0
+}
+
+func (s *trojanLocalStream) LatestIOTime() time.Time {
+	return s.latestIOTime
+}
+
+func (s *trojanLocalStream) Read(p []byte) (int, error) {
+	if nil == s.Conn {
+		return 0, io.EOF
+	}
+	s.latestIOTime = time.Now()
+	if len(s.udpAddr) == 0 {
+		return s.reader.Read(p)
+	}
+	_, payload, err := readPacket(s.reader)
+	if nil != err {
+		return 0, err
+	}
+	return copy(p, payload), nil
+}
+
+func (s *trojanLocalStream) Write(p []byte) (int, error) {
+	if nil == s.Conn {
+		return 0, io.EOF
+	}
+	s.latestIOTime = time.Now()
+	if len(s.udpAddr) == 0 {
+		return s.Conn.Write(p)
+	}
+	b, err := encodePacket(s.udpAddr, p)
+	if nil == err {
+		_, err = s.Conn.Write(b)
+	}
+	if nil != err {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *trojanLocalStream) Close() error {
+	conn := s.Conn
+	if nil != conn {
+		conn.Close()
+		s.Conn = nil
+	}
+	s.session.closeStream(s)
+	return nil
+}
+
+type trojanMuxSession struct {
+	conf *channel.ProxyChannelConfig
+	//tls url of the server
+	server       string
+	hash         string
+	streams      map[*trojanLocalStream]bool
+	streamsMutex sync.Mutex
+}
+
+func (ts *trojanMuxSession) closeStream(s *trojanLocalStream) {
+	ts.streamsMutex.Lock()
+	defer ts.streamsMutex.Unlock()
+	delete(ts.streams, s)
+}
+
+func (ts *trojanMuxSession) CloseStream(stream mux.MuxStream) error {
+	return nil
+}
+
+func (ts *trojanMuxSession) OpenStream() (mux.MuxStream, error) {
+	ts.streamsMutex.Lock()
+	defer ts.streamsMutex.Unlock()
+	stream := &trojanLocalStream{
+		session: ts,
+	}
+	ts.streams[stream] = true
+	return stream, nil
+}
+
+func (ts *trojanMuxSession) AcceptStream() (mux.MuxStream, error) {
+	return nil, channel.ErrNotSupportedOperation
+}
+
+func (ts *trojanMuxSession) NumStreams() int {
+	ts.streamsMutex.Lock()
+	defer ts.streamsMutex.Unlock()
+	return len(ts.streams)
+}
+
+func (ts *trojanMuxSession) Ping() (time.Duration, error) {
+	return 0, nil
+}
+
+func (ts *trojanMuxSession) Close() error {
+	ts.streamsMutex.Lock()
+	streams := ts.streams
+	ts.streams = make(map[*trojanLocalStream]bool)
+	ts.streamsMutex.Unlock()
+	for stream := range streams {
+		stream.Close()
+	}
+	return nil
+}
+
+// TrojanProxy relay streams to trojan servers by url 'trojan://password@host:port', each stream is a new tls
+// connection, sni/tls/utls/ech of the channel config are applied
+type TrojanProxy struct {
+}
+
+func (p *TrojanProxy) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
+	u, err := url.Parse(server)
+	if nil != err {
+		return nil, err
+	}
+	if nil == u.User || len(u.User.Username()) == 0 {
+		return nil, fmt.Errorf("no password in trojan server:%s", server)
+	}
+	//the password is the userinfo, ':' is allowed in it
+	password := u.User.String()
+	if password, err = url.PathUnescape(password); nil != err {
+		return nil, err
+	}
+	session := &trojanMuxSession{
+		conf:    conf,
+		server:  "tls://" + u.Host,
+		hash:    passwordHash(password),
+		streams: make(map[*trojanLocalStream]bool),
+	}
+	return session, nil
+}
+
+func (p *TrojanProxy) Features() channel.FeatureSet {
+	return channel.FeatureSet{
+		AutoExpire: false,
+		Pingable:   false,
+	}
+}
+
+func init() {
+	channel.RegisterLocalChannelType("trojan", &TrojanProxy{})
+}
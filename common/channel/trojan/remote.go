@@ -0,0 +1,318 @@
+package trojan
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/supervisor"
+	"github.com/yinqiwen/pmux"
+)
+
+// trojan clients must send the request within the timeout, else the connection is served by the fallback
+const requestTimeout = 10 * time.Second
+
+const decoyIdleTimeout = 60 * time.Second
+
+const decoyPage = `<html>
+<head><title>404 Not Found</title></head>
+<body>
+<center><h1>404 Not Found</h1></center>
+<hr><center>nginx</center>
+</body>
+</html>
+`
+
+// trojanStream is the only stream of the connection, the connect request is read first by the server as
+// mux streams, udp packets are translated from/to mux udp datagrams
+type trojanStream struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	udp    bool
+	//bytes of the connect request or translated udp datagram not read yet
+	pending []byte
+	//mux udp datagrams written partially
+	wbuf         bytes.Buffer
+	latestIOTime time.Time
+	closeOnce    sync.Once
+	closed       chan struct{}
+}
+
+func (s *trojanStream) Connect(network string, addr string, opt mux.StreamOptions) error {
+	return channel.ErrNotSupportedOperation
+}
+
+func (s *trojanStream) Auth(req *mux.AuthRequest) error {
+	return nil
+}
+
+func (s *trojanStream) StreamID() uint32 {
+	return 1
+}
+
+func (s *trojanStream) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+func (s *trojanStream) SetWriteDeadline(t time.Time) error {
+	return s.conn.SetWriteDeadline(t)
+}
+
+func (s *trojanStream) LatestIOTime() time.Time {
+	return s.latestIOTime
+}
+
+func (s *trojanStream) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		s.latestIOTime = time.Now()
+		if !s.udp {
+			return s.reader.Read(p)
+		}
+		addr, payload, err := readPacket(s.reader)
+		if nil != err {
+			return 0, err
+		}
+		var b bytes.Buffer
+		if err = mux.WriteMessage(&b, &mux.UDPDatagram{Addr: addr, Data: payload}); nil != err {
+			return 0, err
+		}
+		s.pending = b.Bytes()
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *trojanStream) Write(p []byte) (int, error) {
+	s.latestIOTime = time.Now()
+	if !s.udp {
+		return s.conn.Write(p)
+	}
+	s.wbuf.Write(p)
+	for s.wbuf.Len() >= 4 {
+		if size := binary.BigEndian.Uint32(s.wbuf.Bytes()); s.wbuf.Len() < 4+int(size) {
+			break
+		}
+		dgram, err := mux.ReadUDPDatagram(&s.wbuf)
+		if nil != err {
+			return 0, err
+		}
+		b, err := encodePacket(dgram.Addr, dgram.Data)
+		if nil != err {
+			return 0, err
+		}
+		if _, err = s.conn.Write(b); nil != err {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (s *trojanStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.conn.Close()
+		close(s.closed)
+	})
+	return nil
+}
+
+// trojanSession serve the single stream of the connection as a mux session authed already
+type trojanSession struct {
+	stream   *trojanStream
+	accepted bool
+}
+
+func (ts *trojanSession) OpenStream() (mux.MuxStream, error) {
+	return nil, channel.ErrNotSupportedOperation
+}
+
+func (ts *trojanSession) CloseStream(stream mux.MuxStream) error {
+	return nil
+}
+
+func (ts *trojanSession) AcceptStream() (mux.MuxStream, error) {
+	if !ts.accepted {
+		ts.accepted = true
+		return ts.stream, nil
+	}
+	<-ts.stream.closed
+	return nil, pmux.ErrSessionShutdown
+}
+
+func (ts *trojanSession) Ping() (time.Duration, error) {
+	return 0, nil
+}
+
+func (ts *trojanSession) NumStreams() int {
+	return 1
+}
+
+func (ts *trojanSession) Close() error {
+	return ts.stream.Close()
+}
+
+func (ts *trojanSession) RemoteAddr() net.Addr {
+	return ts.stream.conn.RemoteAddr()
+}
+
+// readUser read the password hash & return the user, empty if the password is unknown, connections of other
+// protocols are detected by the first bytes without waiting for the whole hash, false is returned for them
+func readUser(br *bufio.Reader) (string, bool) {
+	for n := 1; ; {
+		b, err := br.Peek(n)
+		if nil != err || !validHead(b) {
+			return "", false
+		}
+		if n == hashLen+2 {
+			break
+		}
+		n = br.Buffered() + 1
+		if n > hashLen+2 {
+			n = hashLen + 2
+		}
+	}
+	b, _ := br.Peek(hashLen)
+	user, exist := channel.TrojanUser(strings.ToLower(string(b)))
+	if !exist {
+		return "", true
+	}
+	br.Discard(hashLen + 2)
+	return user, true
+}
+
+// serveDecoy reply 404 like nginx to http requests if no fallback website configured
+func serveDecoy(conn net.Conn, br *bufio.Reader) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(decoyIdleTimeout))
+		req, err := http.ReadRequest(br)
+		if nil != err {
+			if err != io.EOF {
+				io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nServer: nginx\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			}
+			return
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(req.Body, 1024*1024))
+		req.Body.Close()
+		res := &http.Response{
+			StatusCode:    404,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Server": {"nginx"}, "Content-Type": {"text/html"}},
+			ContentLength: int64(len(decoyPage)),
+			Body:          ioutil.NopCloser(strings.NewReader(decoyPage)),
+			Close:         req.Close,
+		}
+		if nil != res.Write(conn) || req.Close {
+			return
+		}
+	}
+}
+
+// serveFallback relay the connection with bytes read already to the fallback website
+func serveFallback(conn net.Conn, br *bufio.Reader, fallback string) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Time{})
+	if len(fallback) == 0 {
+		serveDecoy(conn, br)
+		return
+	}
+	c, err := net.DialTimeout("tcp", fallback, 5*time.Second)
+	if nil != err {
+		logger.Error("[ERROR]Failed to connect trojan fallback:%s with reason:%v", fallback, err)
+		return
+	}
+	defer c.Close()
+	go func() {
+		io.Copy(conn, c)
+		conn.Close()
+	}()
+	io.Copy(c, br)
+}
+
+func serveConn(conn net.Conn, fallback string) {
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	br := bufio.NewReader(conn)
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	user, isTrojan := readUser(br)
+	if isTrojan && len(user) == 0 {
+		//wrong passwords count as auth failures of the ip like mux sessions
+		channel.RecordAuthFailure(ip)
+	}
+	if len(user) == 0 || channel.AuthBanned(ip) {
+		serveFallback(conn, br, fallback)
+		return
+	}
+	cmd, addr, err := readRequest(br)
+	if nil != err {
+		logger.Error("[ERROR]Invalid trojan request of user:%s from %v with reason:%v", user, conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	creq := &mux.ConnectRequest{Network: "tcp", Addr: addr}
+	if cmd == cmdUDPAssociate {
+		creq.Network, creq.Addr = mux.UDPAssociateNetwork, ""
+	}
+	var head bytes.Buffer
+	mux.WriteMessage(&head, creq)
+	stream := &trojanStream{
+		conn:    conn,
+		reader:  br,
+		udp:     cmd == cmdUDPAssociate,
+		pending: head.Bytes(),
+		closed:  make(chan struct{}),
+	}
+	auth := &mux.AuthRequest{
+		User:           user,
+		SessionID:      helper.RandHexString(16),
+		Version:        "trojan",
+		CompressMethod: mux.NoneCompressor,
+	}
+	//every trojan connection is a session, so limits of sessions are not applied
+	channel.ServProxyMuxSession(&trojanSession{stream: stream}, auth)
+}
+
+// StartTrojanProxyServer serve trojan clients on the tls address, connections of unknown passwords or other
+// protocols are relayed to the fallback website
+func StartTrojanProxyServer(addr string, fallback string, config *tls.Config) error {
+	lp, err := supervisor.Listen("tcp", addr)
+	if nil != err {
+		logger.Error("[ERROR]Failed to listen Trojan address:%s with reason:%v", addr, err)
+		return err
+	}
+	lp = tls.NewListener(lp, config)
+	logger.Info("Listen on Trojan address:%s with fallback:%s", addr, fallback)
+	var tempDelay time.Duration
+	for {
+		conn, err := lp.Accept()
+		if nil != err {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() && !errors.Is(err, net.ErrClosed) {
+				//back off on errors like running out of fds
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else if tempDelay *= 2; tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			logger.Error("[ERROR]Stop Trojan listener:%s with reason:%v", addr, err)
+			return err
+		}
+		tempDelay = 0
+		go serveConn(conn, fallback)
+	}
+}
@@ -0,0 +1,95 @@
+package trojan
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+)
+
+func TestReadUser(t *testing.T) {
+	if err := channel.SetUserConfigs([]channel.UserConfig{{Name: "u", TrojanPassword: "secret"}}); nil != err {
+		t.Fatal(err)
+	}
+	defer channel.SetUserConfigs(nil)
+	for _, c := range []struct {
+		data     string
+		user     string
+		isTrojan bool
+	}{
+		{passwordHash("secret") + "\r\n", "u", true},
+		{passwordHash("wrong") + "\r\n", "", true},
+		{"GET / HTTP/1.1\r\n\r\n", "", false},
+		{passwordHash("secret")[:10], "", false},
+	} {
+		user, isTrojan := readUser(bufio.NewReader(bytes.NewBufferString(c.data)))
+		if user != c.user || isTrojan != c.isTrojan {
+			t.Errorf("read %q got (%q,%v) expected (%q,%v)", c.data, user, isTrojan, c.user, c.isTrojan)
+		}
+	}
+}
+
+// decoyStatus send the data to a trojan connection served with the decoy & return the status of the reply
+func decoyStatus(t *testing.T, lp net.Listener, data []byte) int {
+	c, err := net.Dial("tcp", lp.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := lp.Accept()
+	if nil != err {
+		t.Fatal(err)
+	}
+	go serveConn(conn, "")
+	c.Write(data)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return res.StatusCode
+}
+
+func TestWrongPasswordBanned(t *testing.T) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer lp.Close()
+	channel.SetAuthBanConfig(channel.AuthBanConfig{MaxFailures: 2})
+	defer channel.SetAuthBanConfig(channel.AuthBanConfig{})
+	defer channel.UnbanIP("127.0.0.1")
+	if err := channel.SetUserConfigs([]channel.UserConfig{{Name: "u", TrojanPassword: "secret"}}); nil != err {
+		t.Fatal(err)
+	}
+	defer channel.SetUserConfigs(nil)
+
+	for i := 0; i < 3; i++ {
+		if status := decoyStatus(t, lp, []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")); status != http.StatusNotFound {
+			t.Fatalf("decoy status:%d", status)
+		}
+	}
+	if channel.AuthBanned("127.0.0.1") {
+		t.Fatalf("requests of other protocols should not be counted")
+	}
+	wrong, _ := encodeRequest(passwordHash("wrong"), cmdConnect, "1.2.3.4:80")
+	for i := 0; i < 2; i++ {
+		if status := decoyStatus(t, lp, wrong); status != http.StatusBadRequest {
+			t.Fatalf("decoy status of wrong password:%d", status)
+		}
+	}
+	if !channel.AuthBanned("127.0.0.1") {
+		t.Fatalf("ip should be banned after wrong passwords")
+	}
+	good, _ := encodeRequest(passwordHash("secret"), cmdConnect, "1.2.3.4:80")
+	if status := decoyStatus(t, lp, good); status != http.StatusBadRequest {
+		t.Fatalf("banned ip should be served by the fallback, status:%d", status)
+	}
+}
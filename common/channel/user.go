@@ -0,0 +1,158 @@
+package channel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// UserConfig is the per user setting on server side
+type UserConfig struct {
+	Name string
+	//base32 TOTP secret, the user must present a valid TOTP code in auth if set
+	TOTPSecret string
+	//per user cipher key, client must configure the same 'UserKey'
+	Key string
+	ACL UserACLConfig
+	//password of trojan clients, the user is identified by it on trojan listeners
+	TrojanPassword string
+	//override the global network & port limit
+	PortLimit *PortLimitConfig
+	//ports/port ranges or hostname patterns the user may register reverse tunnels on
+	Reverse []string
+	//time windows the user may login & open streams like 'Mon-Fri 09:00-18:00', empty allows any time
+	Schedule []string
+	//time zone of schedule like 'Asia/Shanghai', default local
+	TimeZone string
+	//traffic quota of each period like '100G', empty means unlimited
+	Quota string
+	//day of month(1-28) the quota is reset, default 1
+	QuotaResetDay int
+	//override 'MaxUserConnsPerHost' of the proxy limit, negative means unlimited
+	MaxConnsPerHost int
+	//max sessions of the user, 0 means unlimited
+	MaxSessions int
+	//SessionPolicyReject(default) or SessionPolicyPreemptIdle once 'MaxSessions' reached
+	SessionPolicy string
+
+	schedule   *userSchedule
+	quotaBytes int64
+}
+
+var userConfigTable = make(map[string]*UserConfig)
+
+// user names by the hex sha224 of trojan passwords
+var trojanUserTable = make(map[string]string)
+var userConfigMutex sync.RWMutex
+
+type userTables struct {
+	users  map[string]*UserConfig
+	trojan map[string]string
+}
+
+// buildUserTables validate the users(with their ACLs) & compile schedules, quotas and trojan passwords
+func buildUserTables(users []UserConfig) (*userTables, error) {
+	for i := range users {
+		if err := users[i].ACL.validate(users[i].Name); nil != err {
+			return nil, err
+		}
+		if !validSessionPolicy(users[i].SessionPolicy) {
+			return nil, fmt.Errorf("invalid session policy:%s of user:%s", users[i].SessionPolicy, users[i].Name)
+		}
+	}
+	tables := &userTables{users: make(map[string]*UserConfig), trojan: make(map[string]string)}
+	for i := range users {
+		tables.users[users[i].Name] = &users[i]
+		users[i].compileSchedule()
+		users[i].compileQuota()
+		if len(users[i].TrojanPassword) > 0 {
+			h := sha256.Sum224([]byte(users[i].TrojanPassword))
+			tables.trojan[hex.EncodeToString(h[:])] = users[i].Name
+		}
+	}
+	return tables, nil
+}
+
+// SetUserConfigs swap the user table, nothing changed if any user config is invalid
+func SetUserConfigs(users []UserConfig) error {
+	tables, err := buildUserTables(users)
+	if nil != err {
+		return err
+	}
+	userConfigMutex.Lock()
+	userConfigTable = tables.users
+	trojanUserTable = tables.trojan
+	userConfigMutex.Unlock()
+	return nil
+}
+
+// SetAuthConfigs build the user table & the auth provider first, then swap them together,
+// nothing changed if any of them is invalid
+func SetAuthConfigs(users []UserConfig, provider AuthProviderConfig) error {
+	tables, err := buildUserTables(users)
+	if nil != err {
+		return err
+	}
+	state, err := newAuthProviderState(provider)
+	if nil != err {
+		return err
+	}
+	userConfigMutex.Lock()
+	userConfigTable = tables.users
+	trojanUserTable = tables.trojan
+	authProviderValue.Store(state)
+	userConfigMutex.Unlock()
+	return nil
+}
+
+func getUserConfig(user string) *UserConfig {
+	userConfigMutex.RLock()
+	defer userConfigMutex.RUnlock()
+	return userConfigTable[user]
+}
+
+// TrojanUser return the user of the hex sha224 of the trojan password
+func TrojanUser(hash string) (string, bool) {
+	userConfigMutex.RLock()
+	defer userConfigMutex.RUnlock()
+	user, exist := trojanUserTable[hash]
+	return user, exist
+}
+
+func userKeyHMAC(key string, label string, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(label))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func userKeyProof(key string, nonce string) string {
+	return userKeyHMAC(key, "auth:", nonce)
+}
+
+// userSessionKey derive the cipher key of the session from the user key & auth nonce
+func userSessionKey(key string, nonce string) string {
+	return userKeyHMAC(key, "session:", nonce)
+}
+
+// verifyUserKey return the session key if the user configured with key, or the reason if the proof is invalid
+func verifyUserKey(auth *mux.AuthRequest) (string, string) {
+	uc := getUserConfig(auth.User)
+	if nil == uc || len(uc.Key) == 0 {
+		if len(auth.KeyProof) > 0 {
+			return "", "no user key configured"
+		}
+		return "", ""
+	}
+	if len(auth.KeyProof) == 0 {
+		return "", "user key required"
+	}
+	if !hmac.Equal([]byte(auth.KeyProof), []byte(userKeyProof(uc.Key, auth.Nonce))) {
+		return "", "invalid user key"
+	}
+	return userSessionKey(uc.Key, auth.Nonce), ""
+}
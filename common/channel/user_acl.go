@@ -0,0 +1,161 @@
+package channel
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ACLRule match destinations by domain patterns or CIDRs, and ports
+type ACLRule struct {
+	//patterns like '*.example.com', CIDRs are matched by the ip dialed for domains
+	Domains []string
+	CIDRs   []string
+	//ports or port ranges like '443', '8000-9000', empty matches all ports
+	Ports []string
+}
+
+// UserACLConfig is checked after the global proxy limit, 'Deny' rules first,
+// then destination must match one of 'Allow' rules if any.
+type UserACLConfig struct {
+	Allow []ACLRule
+	Deny  []ACLRule
+}
+
+func parsePortRange(rule string) (int, int, error) {
+	if idx := strings.Index(rule, "-"); idx > 0 {
+		from, err := strconv.Atoi(rule[:idx])
+		if nil != err {
+			return 0, 0, err
+		}
+		to, err := strconv.Atoi(rule[idx+1:])
+		return from, to, err
+	}
+	v, err := strconv.Atoi(rule)
+	return v, v, err
+}
+
+func matchPort(rule string, port int) bool {
+	from, to, err := parsePortRange(rule)
+	return nil == err && port >= from && port <= to
+}
+
+func (r *ACLRule) validate() error {
+	for _, cidr := range r.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); nil != err {
+			return err
+		}
+	}
+	for _, p := range r.Ports {
+		if _, _, err := parsePortRange(p); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// match check the destination, 'ip' is the ip literal of host or the ip dialed for the domain, nil if not dialed yet
+func (r *ACLRule) match(host string, ip net.IP, port int) bool {
+	hostMatched := len(r.Domains) == 0 && len(r.CIDRs) == 0
+	if !hostMatched {
+		lhost := strings.ToLower(host)
+		for _, pattern := range r.Domains {
+			if matched, _ := filepath.Match(strings.ToLower(pattern), lhost); matched {
+				hostMatched = true
+				break
+			}
+		}
+	}
+	if !hostMatched {
+		if nil != ip {
+			for _, cidr := range r.CIDRs {
+				if _, ipnet, err := net.ParseCIDR(cidr); nil == err && ipnet.Contains(ip) {
+					hostMatched = true
+					break
+				}
+			}
+		}
+	}
+	if !hostMatched {
+		return false
+	}
+	if len(r.Ports) == 0 {
+		return true
+	}
+	for _, p := range r.Ports {
+		if matchPort(p, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed check the destination before dialing, domains matched by CIDR rules only are checked again by AllowedIP
+func (acl *UserACLConfig) Allowed(addr string) bool {
+	return acl.AllowedIP(addr, nil)
+}
+
+// AllowedIP check the destination with the ip dialed for it
+func (acl *UserACLConfig) AllowedIP(addr string, dialed net.IP) bool {
+	if len(acl.Allow) == 0 && len(acl.Deny) == 0 {
+		return true
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if nil != err {
+		host = addr
+	}
+	port, _ := strconv.Atoi(portStr)
+	ip := net.ParseIP(host)
+	if nil == ip {
+		ip = dialed
+	}
+	for i := range acl.Deny {
+		if acl.Deny[i].match(host, ip, port) {
+			return false
+		}
+	}
+	if len(acl.Allow) == 0 {
+		return true
+	}
+	//not dialed yet, the domain may be allowed by CIDR rules
+	pending := false
+	for i := range acl.Allow {
+		if acl.Allow[i].match(host, ip, port) {
+			return true
+		}
+		if nil == ip && len(acl.Allow[i].CIDRs) > 0 {
+			pending = true
+		}
+	}
+	return pending
+}
+
+func (acl *UserACLConfig) validate(user string) error {
+	for _, rules := range [][]ACLRule{acl.Allow, acl.Deny} {
+		for i := range rules {
+			if err := rules[i].validate(); nil != err {
+				return fmt.Errorf("invalid ACL rule:%v for user:%s with reason:%v", rules[i], user, err)
+			}
+		}
+	}
+	return nil
+}
+
+// allowedByUserACL check the destination against the ACL of the user if configured
+func allowedByUserACL(user string, addr string) bool {
+	uc := getUserConfig(user)
+	if nil == uc {
+		return true
+	}
+	return uc.ACL.Allowed(addr)
+}
+
+func allowedIPByUserACL(user string, addr string, ip net.IP) bool {
+	uc := getUserConfig(user)
+	if nil == uc {
+		return true
+	}
+	return uc.ACL.AllowedIP(addr, ip)
+}
@@ -0,0 +1,133 @@
+package channel
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestMatchPort(t *testing.T) {
+	tests := []struct {
+		rule  string
+		port  int
+		match bool
+	}{
+		{"443", 443, true},
+		{"443", 80, false},
+		{"8000-9000", 8000, true},
+		{"8000-9000", 9000, true},
+		{"8000-9000", 9001, false},
+		{"bad", 80, false},
+		{"80-bad", 80, false},
+	}
+	for _, tt := range tests {
+		if m := matchPort(tt.rule, tt.port); m != tt.match {
+			t.Errorf("port rule %s expect match %d:%v, but got %v", tt.rule, tt.port, tt.match, m)
+		}
+	}
+}
+
+func TestUserACLAllowedIP(t *testing.T) {
+	acl := &UserACLConfig{
+		Allow: []ACLRule{
+			{Domains: []string{"*.Example.com"}, Ports: []string{"443", "8000-9000"}},
+			{CIDRs: []string{"10.0.0.0/8"}},
+		},
+		Deny: []ACLRule{
+			{Domains: []string{"admin.example.com"}},
+			{CIDRs: []string{"10.1.0.0/16"}, Ports: []string{"22"}},
+		},
+	}
+	tests := []struct {
+		addr    string
+		dialed  string
+		allowed bool
+	}{
+		{"www.example.com:443", "", true},
+		{"WWW.EXAMPLE.COM:8080", "", true},
+		{"www.example.com:80", "93.184.216.34", false},
+		{"admin.example.com:443", "", false},
+		{"10.2.3.4:22", "", true},
+		{"10.1.3.4:22", "", false},
+		{"10.1.3.4:80", "", true},
+		{"192.168.1.1:443", "", false},
+		//domains may be allowed by CIDR rules until dialed
+		{"intranet.test:22", "", true},
+		{"www.example.com:80", "", true},
+		{"intranet.test:22", "10.2.0.1", true},
+		{"intranet.test:22", "10.1.0.1", false},
+		{"intranet.test:22", "192.168.1.1", false},
+		//ip literal is checked rather than the dialed ip
+		{"192.168.1.1:443", "10.2.0.1", false},
+	}
+	for _, tt := range tests {
+		var dialed net.IP
+		if len(tt.dialed) > 0 {
+			dialed = net.ParseIP(tt.dialed)
+		}
+		if allowed := acl.AllowedIP(tt.addr, dialed); allowed != tt.allowed {
+			t.Errorf("%s dialed %s expect allowed:%v, but got %v", tt.addr, tt.dialed, tt.allowed, allowed)
+		}
+	}
+	if !(&UserACLConfig{}).Allowed("any.test:1") {
+		t.Errorf("empty ACL should allow all")
+	}
+	if (&UserACLConfig{Deny: []ACLRule{{}}}).Allowed("any.test:1") {
+		t.Errorf("empty deny rule should match all")
+	}
+}
+
+func TestSetUserConfigsRejectInvalidACL(t *testing.T) {
+	defer SetUserConfigs(nil)
+	tests := []struct {
+		rule  ACLRule
+		valid bool
+	}{
+		{ACLRule{CIDRs: []string{"10.0.0.0/8"}, Ports: []string{"1-1024"}}, true},
+		{ACLRule{CIDRs: []string{"10.0.0.0"}}, false},
+		{ACLRule{Ports: []string{"http"}}, false},
+	}
+	for _, tt := range tests {
+		err := SetUserConfigs([]UserConfig{{Name: "acl", ACL: UserACLConfig{Deny: []ACLRule{tt.rule}}}})
+		if (nil == err) != tt.valid {
+			t.Errorf("rule %+v expect valid:%v, but got %v", tt.rule, tt.valid, err)
+		}
+	}
+}
+
+func TestUserACLRejectDialedIP(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	_, port, _ := net.SplitHostPort(echo.Addr().String())
+	if err := SetUserConfigs([]UserConfig{{Name: "acl", ACL: UserACLConfig{Deny: []ACLRule{{CIDRs: []string{"127.0.0.0/8"}, Ports: []string{port}}}}}}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	portNum, _ := strconv.Atoi(port)
+	if allowedIPByUserACL("acl", "localhost:"+port, net.ParseIP("127.0.0.1")) || !allowedByUserACL("acl", "localhost:"+strconv.Itoa(portNum+1)) {
+		t.Errorf("unexpected ACL check of user")
+	}
+	if !allowedByUserACL("other", "localhost:"+port) {
+		t.Errorf("users without config should be allowed")
+	}
+	tests := []struct {
+		user    string
+		allowed bool
+	}{
+		{"acl", false},
+		{"other", true},
+	}
+	for _, tt := range tests {
+		session := newTestProxySession(t, &mux.AuthRequest{User: tt.user, CompressMethod: mux.NoneCompressor})
+		stream, err := pingTestStream(session, "tcp", "localhost:"+port)
+		if (nil == err) != tt.allowed {
+			t.Errorf("user %s expect allowed:%v, but got %v", tt.user, tt.allowed, err)
+		}
+		if nil != stream {
+			stream.Close()
+		}
+		session.Close()
+	}
+}
@@ -0,0 +1,164 @@
+package channel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// timeWindow is a daily time range on selected weekdays, the range may cross midnight like '22:00-06:00'
+type timeWindow struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+func parseWeekday(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range weekdayNames {
+		if strings.HasPrefix(s, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday:%s", s)
+}
+
+func parseClock(s string) (int, error) {
+	idx := strings.Index(s, ":")
+	if idx <= 0 {
+		return 0, fmt.Errorf("invalid time:%s", s)
+	}
+	h, err := strconv.Atoi(s[:idx])
+	if nil != err || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time:%s", s)
+	}
+	m, err := strconv.Atoi(s[idx+1:])
+	if nil != err || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time:%s", s)
+	}
+	return h*60 + m, nil
+}
+
+// parseTimeWindow parse windows like '09:00-18:00', 'Mon-Fri 09:00-18:00' or 'Sat,Sun 10:00-12:00'
+func parseTimeWindow(s string) (*timeWindow, error) {
+	w := &timeWindow{}
+	fields := strings.Fields(s)
+	var clock string
+	switch len(fields) {
+	case 1:
+		clock = fields[0]
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		clock = fields[1]
+		for _, part := range strings.Split(fields[0], ",") {
+			if part == "*" {
+				for i := range w.days {
+					w.days[i] = true
+				}
+				continue
+			}
+			from, to := part, part
+			if idx := strings.Index(part, "-"); idx > 0 {
+				from, to = part[:idx], part[idx+1:]
+			}
+			start, err := parseWeekday(from)
+			if nil != err {
+				return nil, err
+			}
+			end, err := parseWeekday(to)
+			if nil != err {
+				return nil, err
+			}
+			for d := start; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == end {
+					break
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid time window:%s", s)
+	}
+	idx := strings.Index(clock, "-")
+	if idx <= 0 {
+		return nil, fmt.Errorf("invalid time window:%s", s)
+	}
+	var err error
+	if w.start, err = parseClock(clock[:idx]); nil != err {
+		return nil, err
+	}
+	if w.end, err = parseClock(clock[idx+1:]); nil != err {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *timeWindow) contains(t time.Time) bool {
+	day := int(t.Weekday())
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	//the part after midnight belongs to the window started on previous day
+	return (w.days[day] && minute >= w.start) || (w.days[(day+6)%7] && minute < w.end)
+}
+
+type userSchedule struct {
+	windows []*timeWindow
+	loc     *time.Location
+}
+
+func (uc *UserConfig) compileSchedule() {
+	uc.schedule = nil
+	if len(uc.Schedule) == 0 {
+		return
+	}
+	s := &userSchedule{loc: time.Local}
+	if len(uc.TimeZone) > 0 {
+		loc, err := time.LoadLocation(uc.TimeZone)
+		if nil != err {
+			logger.Error("[ERROR]Invalid time zone:%s for user:%s with reason:%v", uc.TimeZone, uc.Name, err)
+		} else {
+			s.loc = loc
+		}
+	}
+	for _, rule := range uc.Schedule {
+		w, err := parseTimeWindow(rule)
+		if nil != err {
+			logger.Error("[ERROR]Invalid schedule:%s for user:%s with reason:%v", rule, uc.Name, err)
+			continue
+		}
+		s.windows = append(s.windows, w)
+	}
+	//user with only invalid windows is never allowed
+	uc.schedule = s
+}
+
+func (s *userSchedule) allowed(t time.Time) bool {
+	t = t.In(s.loc)
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedBySchedule check current time against the user's schedule, the reason is sent to client if not allowed
+func allowedBySchedule(user string) (bool, string) {
+	uc := getUserConfig(user)
+	if nil == uc || nil == uc.schedule {
+		return true, ""
+	}
+	if uc.schedule.allowed(time.Now()) {
+		return true, ""
+	}
+	return false, fmt.Sprintf("user:%s is only allowed in %s(%s)", user, strings.Join(uc.Schedule, ", "), uc.schedule.loc)
+}
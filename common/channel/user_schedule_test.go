@@ -0,0 +1,89 @@
+package channel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestTimeWindowBoundary(t *testing.T) {
+	//2024-01-01 is monday
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 1, 1+day, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		window   string
+		day      int
+		clock    string
+		expected bool
+	}{
+		{"09:00-18:00", 0, "08:59", false},
+		{"09:00-18:00", 0, "09:00", true},
+		{"09:00-18:00", 0, "17:59", true},
+		{"09:00-18:00", 0, "18:00", false},
+		{"Mon-Fri 09:00-18:00", 4, "12:00", true},
+		{"Mon-Fri 09:00-18:00", 5, "12:00", false},
+		{"Sat,Sun 10:00-12:00", 6, "10:00", true},
+		{"Fri-Mon 00:00-24:00", 0, "23:59", true},
+		{"Fri-Mon 00:00-24:00", 1, "00:00", false},
+		//window across midnight belongs to the day it started
+		{"Mon 22:00-06:00", 0, "21:59", false},
+		{"Mon 22:00-06:00", 0, "22:00", true},
+		{"Mon 22:00-06:00", 1, "05:59", true},
+		{"Mon 22:00-06:00", 1, "06:00", false},
+		{"Mon 22:00-06:00", 0, "05:00", false},
+	} {
+		w, err := parseTimeWindow(c.window)
+		if nil != err {
+			t.Fatalf("invalid window %s:%v", c.window, err)
+		}
+		now := at(c.day, c.clock)
+		if v := w.contains(now); v != c.expected {
+			t.Errorf("%s contains %s %s=%v, expected %v", c.window, now.Weekday(), c.clock, v, c.expected)
+		}
+	}
+	for _, s := range []string{"9-18", "09:00-25:00", "09:60-18:00", "Someday 09:00-18:00", "Mon 09:00-18:00 UTC"} {
+		if _, err := parseTimeWindow(s); nil == err {
+			t.Errorf("invalid window %s parsed", s)
+		}
+	}
+}
+
+func TestScheduleRejectStreamsOutOfWindow(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	//the window starts at the current minute for 'day', and ends at it for 'night'
+	now := time.Now().UTC()
+	clock := now.Format("15:04")
+	if err := SetUserConfigs([]UserConfig{
+		{Name: "day", Schedule: []string{clock + "-" + now.Add(time.Hour).Format("15:04")}, TimeZone: "UTC"},
+		{Name: "night", Schedule: []string{now.Add(-time.Hour).Format("15:04") + "-" + clock}, TimeZone: "UTC"},
+	}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	for _, c := range []struct {
+		user    string
+		allowed bool
+	}{
+		{"day", true},
+		{"night", false},
+		{"nobody", true},
+	} {
+		session := newTestProxySession(t, &mux.AuthRequest{SessionID: "schedule-" + c.user, User: c.user, CompressMethod: mux.NoneCompressor})
+		stream, err := pingTestStream(session, "tcp", echo.Addr().String())
+		if c.allowed && nil != err {
+			t.Errorf("stream of %s rejected for reason:%v", c.user, err)
+		}
+		if !c.allowed && (nil == err || !strings.Contains(err.Error(), "only allowed in")) {
+			t.Errorf("stream of %s out of schedule not rejected, got %v", c.user, err)
+		}
+		if nil != stream {
+			stream.Close()
+		}
+		session.Close()
+	}
+}
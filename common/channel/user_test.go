@@ -0,0 +1,114 @@
+package channel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestAuthRequireTOTPOfUser(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if err := SetUserConfigs([]UserConfig{{Name: "otp", TOTPSecret: secret}}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	code, _ := helper.TOTPCode(secret, time.Now())
+	tests := []struct {
+		user  string
+		totp  string
+		valid bool
+	}{
+		{"otp", code, true},
+		{"otp", "", false},
+		{"otp", "000000", code == "000000"},
+		//users without secret need no code
+		{"plain", "", true},
+	}
+	for _, tt := range tests {
+		session, err := authTestSession(t, &mux.AuthRequest{User: tt.user, TOTP: tt.totp, ProtocolLevel: mux.ProtocolLevel})
+		if (nil == err) != tt.valid {
+			t.Errorf("auth user:%s totp:%q expect valid:%v, but got %v", tt.user, tt.totp, tt.valid, err)
+		}
+		session.Close()
+	}
+}
+
+func TestVerifyUserKey(t *testing.T) {
+	if err := SetUserConfigs([]UserConfig{{Name: "keyed", Key: "user-key"}}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	nonce := "0123456789abcdef"
+	tests := []struct {
+		user       string
+		proof      string
+		sessionKey string
+		reason     string
+	}{
+		{"keyed", userKeyProof("user-key", nonce), userSessionKey("user-key", nonce), ""},
+		{"keyed", "", "", "user key required"},
+		{"keyed", userKeyProof("other-key", nonce), "", "invalid user key"},
+		{"keyed", userKeyProof("user-key", "other-nonce"), "", "invalid user key"},
+		{"plain", userKeyProof("user-key", nonce), "", "no user key configured"},
+		{"plain", "", "", ""},
+	}
+	for _, tt := range tests {
+		sessionKey, reason := verifyUserKey(&mux.AuthRequest{User: tt.user, Nonce: nonce, KeyProof: tt.proof})
+		if sessionKey != tt.sessionKey || reason != tt.reason {
+			t.Errorf("verify user:%s expect (%q,%q), but got (%q,%q)", tt.user, tt.sessionKey, tt.reason, sessionKey, reason)
+		}
+	}
+	if userSessionKey("user-key", nonce) == userKeyProof("user-key", nonce) {
+		t.Errorf("session key should not be the proof sent in clear auth")
+	}
+}
+
+func TestAuthDeriveSessionKeyFromUserKey(t *testing.T) {
+	if err := SetUserConfigs([]UserConfig{{Name: "keyed", Key: "user-key"}}); nil != err {
+		t.Fatal(err)
+	}
+	defer SetUserConfigs(nil)
+	nonce := helper.RandHexString(16)
+	session, err := authTestSession(t, &mux.AuthRequest{User: "keyed", Nonce: nonce, KeyProof: userKeyProof("user-key", nonce), ProtocolLevel: mux.ProtocolLevel})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	//the test session pair shares one config
+	if key := string(session.(*mux.ProxyMuxSession).Config.CipherKey); key != userSessionKey("user-key", nonce) {
+		t.Errorf("expect cipher key derived from user key after auth, but got %q", key)
+	}
+	session, err = authTestSession(t, &mux.AuthRequest{User: "keyed", ProtocolLevel: mux.ProtocolLevel})
+	if authErr, ok := err.(*mux.AuthError); !ok || authErr.Code != mux.AuthRejected {
+		t.Errorf("expect auth without key proof rejected, but got %v", err)
+	}
+	session.Close()
+}
+
+func TestCheckUserKeyOfChannel(t *testing.T) {
+	tests := []struct {
+		server string
+		valid  bool
+	}{
+		{"wss://example.com/ws", true},
+		{"tcp://example.com:48100", true},
+		{"kcp://example.com:48101", true},
+		{"http2://example.com:443", false},
+		{"quic://example.com:443", false},
+		{"ssh://user@example.com:22", false},
+	}
+	for _, tt := range tests {
+		conf := &ProxyChannelConfig{Name: "keyed", ServerList: []string{tt.server}, Cipher: CipherConfig{UserKey: "user-key"}}
+		err := conf.CheckUserKey()
+		if (nil == err) != tt.valid || (nil != err && !strings.Contains(err.Error(), tt.server)) {
+			t.Errorf("check user key of %s expect valid:%v, but got %v", tt.server, tt.valid, err)
+		}
+		conf.Cipher.UserKey = ""
+		if err = conf.CheckUserKey(); nil != err {
+			t.Errorf("channel without user key should be valid, but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package channel
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// ClientHello fingerprints of browsers mimicked by tls/wss/http2 channels, stock go ClientHellos are
+// easily told from browsers by censors
+var utlsHelloIDs = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"safari":     utls.HelloSafari_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"edge":       utls.HelloEdge_Auto,
+	"randomized": utls.HelloRandomized,
+}
+
+// ValidUTLS return true if s is a known fingerprint, empty is valid too
+func ValidUTLS(s string) bool {
+	_, exist := utlsHelloIDs[strings.ToLower(s)]
+	return len(s) == 0 || exist
+}
+
+// utlsClient handshake by the ClientHello of the fingerprint, versions, suites & curves of TLS policy are
+// decided by the fingerprint, ALPN is replaced by 'NextProtos' if not empty
+func utlsClient(conn net.Conn, cfg *tls.Config, fingerprint string) (net.Conn, error) {
+	id, exist := utlsHelloIDs[strings.ToLower(fingerprint)]
+	if !exist {
+		return nil, fmt.Errorf("unknown utls fingerprint:%s", fingerprint)
+	}
+	ucfg := &utls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		NextProtos:         cfg.NextProtos,
+		KeyLogWriter:       cfg.KeyLogWriter,
+	}
+	if id == utls.HelloRandomized {
+		//randomized hellos take ALPN from config
+		uconn := utls.UClient(conn, ucfg, id)
+		return uconn, uconn.Handshake()
+	}
+	spec, err := utls.UTLSIdToSpec(id)
+	if nil != err {
+		return nil, err
+	}
+	if len(cfg.NextProtos) > 0 {
+		for _, ext := range spec.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = cfg.NextProtos
+			}
+		}
+	}
+	uconn := utls.UClient(conn, ucfg, utls.HelloCustom)
+	if err = uconn.ApplyPreset(&spec); nil != err {
+		return nil, err
+	}
+	return uconn, uconn.Handshake()
+}
+
+// tlsClient handshake the conn by stock go tls or utls of the channel
+func tlsClient(conn net.Conn, cfg *tls.Config, conf *ProxyChannelConfig) (net.Conn, error) {
+	if len(conf.UTLS) > 0 {
+		return utlsClient(conn, cfg, conf.UTLS)
+	}
+	tlsconn := tls.Client(conn, cfg)
+	return tlsconn, tlsconn.Handshake()
+}
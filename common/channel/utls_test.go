@@ -0,0 +1,16 @@
+package channel
+
+import "testing"
+
+func TestValidUTLS(t *testing.T) {
+	for _, s := range []string{"", "chrome", "Firefox", "safari", "ios", "edge", "randomized"} {
+		if !ValidUTLS(s) {
+			t.Fatalf("%s should be valid", s)
+		}
+	}
+	for _, s := range []string{"opera", "chrome_auto"} {
+		if ValidUTLS(s) {
+			t.Fatalf("%s should be invalid", s)
+		}
+	}
+}
@@ -1,3 +1,29 @@
 package channel
 
+import (
+	"strconv"
+	"strings"
+)
+
 const Version = "0.33.0"
+
+// CompareVersion compare dot separated versions like '0.33.0', return -1/0/1
+func CompareVersion(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package channel
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+func TestCompareVersion(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		result int
+	}{
+		{"0.33.0", "0.33.0", 0},
+		{"v0.33.0", "0.33.0", 0},
+		{"0.33", "0.33.0", 0},
+		{"0.32.9", "0.33.0", -1},
+		{"0.33.10", "0.33.9", 1},
+		{"1.0", "0.99.99", 1},
+		{"", "0.1", -1},
+	}
+	for _, tt := range tests {
+		if r := CompareVersion(tt.a, tt.b); r != tt.result {
+			t.Errorf("compare %q with %q expect %d, but got %d", tt.a, tt.b, tt.result, r)
+		}
+	}
+}
+
+func TestClientVersionLimitCheck(t *testing.T) {
+	conf := &ClientVersionLimitConfig{MinVersion: "0.33.0", MinProtocolLevel: 2}
+	tests := []struct {
+		auth   mux.AuthRequest
+		reason string
+	}{
+		{mux.AuthRequest{Version: "0.33.0", ProtocolLevel: 2}, ""},
+		{mux.AuthRequest{Version: "0.34.1", ProtocolLevel: 3}, ""},
+		{mux.AuthRequest{Version: "0.34.1", ProtocolLevel: 1}, "protocol level 1"},
+		{mux.AuthRequest{Version: "0.32.0", ProtocolLevel: 2}, "version 0.32.0"},
+		{mux.AuthRequest{ProtocolLevel: 2}, "version unknown"},
+	}
+	for _, tt := range tests {
+		reason := conf.check(&tt.auth)
+		if (len(tt.reason) == 0) != (len(reason) == 0) || !strings.Contains(reason, tt.reason) {
+			t.Errorf("check %+v expect reason %q, but got %q", tt.auth, tt.reason, reason)
+		}
+	}
+	if reason := (&ClientVersionLimitConfig{}).check(&mux.AuthRequest{}); len(reason) > 0 {
+		t.Errorf("empty limit should allow any client, but got %q", reason)
+	}
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"net"
 	"net/url"
 
 	"github.com/gorilla/websocket"
@@ -28,18 +29,29 @@ func (ws *WebsocketProxy) CreateMuxSession(server string, conf *channel.ProxyCha
 	u.Path = "/ws"
 	wsDialer := &websocket.Dialer{}
 	wsDialer.NetDial = channel.NewDialByConf(conf, u.Scheme)
-	wsDialer.TLSClientConfig = channel.NewTLSConfig(conf)
+	wsDialer.TLSClientConfig, err = channel.NewTLSConfig(conf)
+	if nil != err {
+		return nil, err
+	}
+	if u.Scheme == "wss" && (len(conf.UTLS) > 0 || conf.ECH.Enable) {
+		//tls is handshaked by utls or with ECH in dialing, the dialer speaks plain websocket over it
+		if _, _, err := net.SplitHostPort(u.Host); nil != err {
+			u.Host = net.JoinHostPort(u.Host, "443")
+		}
+		u.Scheme = "ws"
+	}
 	c, _, err := wsDialer.Dial(u.String(), nil)
 	if err != nil {
 		logger.Notice("dial websocket error:%v %v", err, u.String())
 		return nil, err
 	}
 	logger.Debug("Connect %s success.", server)
-	ps, err := pmux.Client(&mux.WsConn{Conn: c}, channel.InitialPMuxConfig(&conf.Cipher))
+	muxConf := channel.InitialPMuxConfig(&conf.Cipher)
+	ps, err := pmux.Client(&mux.WsConn{Conn: c}, muxConf)
 	if nil != err {
 		return nil, err
 	}
-	return &mux.ProxyMuxSession{Session: ps}, nil
+	return &mux.ProxyMuxSession{Session: ps, Config: muxConf}, nil
 }
 
 func init() {
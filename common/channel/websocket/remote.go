@@ -29,11 +29,12 @@ func WebsocketInvoke(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error Upgrading to websockets", 400)
 		return
 	}
-	session, err := pmux.Server(&mux.WsConn{Conn: ws}, channel.InitialPMuxConfig(&channel.DefaultServerCipher))
+	muxConf := channel.InitialPMuxConfig(&channel.DefaultServerCipher)
+	session, err := pmux.Server(&mux.WsConn{Conn: ws}, muxConf)
 	if nil != err {
 		return
 	}
-	muxSession := &mux.ProxyMuxSession{Session: session}
+	muxSession := &mux.ProxyMuxSession{Session: session, Config: muxConf}
 	channel.ServProxyMuxSession(muxSession, nil)
 	//ws.WriteMessage(websocket.CloseMessage, []byte{})
 }
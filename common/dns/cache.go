@@ -0,0 +1,263 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/helper"
+)
+
+// CacheConfig of the LRU cache shared by local proxy resolution & server dialing, TTLs are in seconds
+type CacheConfig struct {
+	//0 means default 10000, negative disables the cache
+	MaxSize int
+	//clamp the TTL of answers, also answers relayed to clients by the local dns listener & tunneled dns queries,
+	//so that resolvers answering TTL 0 would not cause query storms
+	MinTTL int
+	MaxTTL int
+	//TTL of failed lookups, 0 means default 30, negative disables negative caching
+	NegativeTTL int
+	//clamps of matched domains, the first matched one takes precedence over 'MinTTL' & 'MaxTTL'
+	Overrides []TTLOverride
+}
+
+// TTLOverride clamp the TTL of answers for domains matched by 'Domains' patterns like '*.example.com'
+type TTLOverride struct {
+	Domains []string
+	MinTTL  int
+	//0 means the 'MaxTTL' of the cache
+	MaxTTL int
+}
+
+type ttlOverride struct {
+	matcher *helper.HostMatcher
+	min     int
+	max     int
+}
+
+const (
+	defaultDNSCacheSize = 10000
+	defaultNegativeTTL  = 30
+	defaultMaxTTL       = 24 * 3600
+	//system resolver does not report TTL
+	defaultResolverTTL = 60
+)
+
+func (conf *CacheConfig) normalize() {
+	if 0 == conf.MaxSize {
+		conf.MaxSize = defaultDNSCacheSize
+	}
+	if 0 == conf.NegativeTTL {
+		conf.NegativeTTL = defaultNegativeTTL
+	}
+	if conf.MaxTTL <= 0 {
+		conf.MaxTTL = defaultMaxTTL
+	}
+}
+
+type dnsCacheItem struct {
+	domain string
+	ip     string
+	err    error
+	expire time.Time
+}
+
+// CacheStats is reported by admin api
+type CacheStats struct {
+	Size         int
+	MaxSize      int
+	Hits         uint64
+	NegativeHits uint64
+	Misses       uint64
+	Evictions    uint64
+}
+
+type dnsCache struct {
+	conf      CacheConfig
+	overrides []ttlOverride
+	items map[string]*list.Element
+	lru   *list.List
+	stats CacheStats
+	mutex sync.Mutex
+}
+
+var sharedCache = newDNSCache(CacheConfig{})
+
+func newDNSCache(conf CacheConfig) *dnsCache {
+	conf.normalize()
+	return &dnsCache{
+		conf:      conf,
+		overrides: compileTTLOverrides(&conf),
+		items:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+func compileTTLOverrides(conf *CacheConfig) []ttlOverride {
+	var overrides []ttlOverride
+	for _, o := range conf.Overrides {
+		max := o.MaxTTL
+		if max <= 0 {
+			max = conf.MaxTTL
+		}
+		overrides = append(overrides, ttlOverride{matcher: helper.NewHostMatcher(o.Domains), min: o.MinTTL, max: max})
+	}
+	return overrides
+}
+
+// InitCache replace the shared dns cache with new config, cached records are dropped
+func InitCache(conf CacheConfig) {
+	conf.normalize()
+	sharedCache.mutex.Lock()
+	sharedCache.conf = conf
+	sharedCache.overrides = compileTTLOverrides(&conf)
+	sharedCache.items = make(map[string]*list.Element)
+	sharedCache.lru = list.New()
+	sharedCache.mutex.Unlock()
+}
+
+func (c *dnsCache) get(domain string) (*dnsCacheItem, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conf.MaxSize < 0 {
+		return nil, false
+	}
+	e, exist := c.items[domain]
+	if !exist {
+		c.stats.Misses++
+		return nil, false
+	}
+	item := e.Value.(*dnsCacheItem)
+	if item.expire.Before(time.Now()) {
+		c.lru.Remove(e)
+		delete(c.items, domain)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	if nil != item.err {
+		c.stats.NegativeHits++
+	} else {
+		c.stats.Hits++
+	}
+	return item, true
+}
+
+func (c *dnsCache) put(domain string, ip string, ttl uint32, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conf.MaxSize < 0 {
+		return
+	}
+	var expire time.Duration
+	if nil != err {
+		if c.conf.NegativeTTL < 0 {
+			return
+		}
+		expire = time.Duration(c.conf.NegativeTTL) * time.Second
+	} else {
+		secs := c.clampTTL(domain, int(ttl))
+		if 0 == secs {
+			return
+		}
+		expire = time.Duration(secs) * time.Second
+	}
+	item := &dnsCacheItem{domain: domain, ip: ip, err: err, expire: time.Now().Add(expire)}
+	if e, exist := c.items[domain]; exist {
+		e.Value = item
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.items[domain] = c.lru.PushFront(item)
+	for c.lru.Len() > c.conf.MaxSize {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.items, last.Value.(*dnsCacheItem).domain)
+		c.stats.Evictions++
+	}
+}
+
+// clampTTL clamp the TTL of the domain by the first matched override or the cache config, the caller holds the mutex
+func (c *dnsCache) clampTTL(domain string, ttl int) int {
+	min, max := c.conf.MinTTL, c.conf.MaxTTL
+	for _, o := range c.overrides {
+		if o.matcher.Match(domain) {
+			min, max = o.min, o.max
+			break
+		}
+	}
+	if ttl < min {
+		ttl = min
+	}
+	if ttl > max {
+		ttl = max
+	}
+	return ttl
+}
+
+// ClampResponseTTL rewrite TTLs of records in the raw dns response by the clamps of the shared dns cache,
+// the response is returned as is if no TTL changed or failed to parse
+func ClampResponseTTL(res []byte) []byte {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(res); nil != err || len(msg.Question) == 0 {
+		return res
+	}
+	domain := strings.TrimSuffix(msg.Question[0].Name, ".")
+	changed := false
+	sharedCache.mutex.Lock()
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				//'Ttl' of OPT is extended rcode & flags
+				continue
+			}
+			if ttl := uint32(sharedCache.clampTTL(domain, int(hdr.Ttl))); ttl != hdr.Ttl {
+				hdr.Ttl = ttl
+				changed = true
+			}
+		}
+	}
+	sharedCache.mutex.Unlock()
+	if !changed {
+		return res
+	}
+	b, err := msg.Pack()
+	if nil != err {
+		return res
+	}
+	return b
+}
+
+// cachedLookup resolve the domain by the resolver returning ip & ttl on cache miss
+func (c *dnsCache) cachedLookup(domain string, resolve func(string) (string, uint32, error)) (string, error) {
+	if item, ok := c.get(domain); ok {
+		return item.ip, item.err
+	}
+	ip, ttl, err := resolve(domain)
+	c.put(domain, ip, ttl, err)
+	return ip, err
+}
+
+// GetCacheStats return the stats of the shared dns cache
+func GetCacheStats() CacheStats {
+	sharedCache.mutex.Lock()
+	defer sharedCache.mutex.Unlock()
+	stats := sharedCache.stats
+	stats.Size = sharedCache.lru.Len()
+	stats.MaxSize = sharedCache.conf.MaxSize
+	return stats
+}
+
+// FlushCache drop all records in the shared dns cache, return the number of dropped records
+func FlushCache() int {
+	sharedCache.mutex.Lock()
+	defer sharedCache.mutex.Unlock()
+	n := sharedCache.lru.Len()
+	sharedCache.items = make(map[string]*list.Element)
+	sharedCache.lru = list.New()
+	return n
+}
@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// countingResolver answer 'ip' of ttl or fail, and count the lookups
+type countingResolver struct {
+	ip      string
+	ttl     uint32
+	err     error
+	lookups int
+}
+
+func (r *countingResolver) resolve(domain string) (string, uint32, error) {
+	r.lookups++
+	return r.ip, r.ttl, r.err
+}
+
+func TestDNSCacheLookup(t *testing.T) {
+	c := newDNSCache(CacheConfig{})
+	ok := &countingResolver{ip: "1.2.3.4", ttl: 60}
+	for i := 0; i < 3; i++ {
+		if ip, err := c.cachedLookup("www.example.com", ok.resolve); nil != err || ip != "1.2.3.4" {
+			t.Fatalf("expect 1.2.3.4, but got %s %v", ip, err)
+		}
+	}
+	failed := &countingResolver{err: errors.New("no such host")}
+	for i := 0; i < 2; i++ {
+		if _, err := c.cachedLookup("bad.example.com", failed.resolve); nil == err {
+			t.Fatalf("expect cached failure")
+		}
+	}
+	if ok.lookups != 1 || failed.lookups != 1 {
+		t.Errorf("expect one lookup each, but got %d & %d", ok.lookups, failed.lookups)
+	}
+	if c.stats.Hits != 2 || c.stats.NegativeHits != 1 || c.stats.Misses != 2 {
+		t.Errorf("unexpected stats:%+v", c.stats)
+	}
+
+	//expired records are resolved again
+	c.items["www.example.com"].Value.(*dnsCacheItem).expire = time.Now().Add(-time.Second)
+	c.cachedLookup("www.example.com", ok.resolve)
+	if ok.lookups != 2 {
+		t.Errorf("expect expired record resolved again, but got %d lookups", ok.lookups)
+	}
+}
+
+func TestDNSCacheConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    CacheConfig
+		ttl     uint32
+		err     error
+		cached  bool
+		expires time.Duration
+	}{
+		{"default", CacheConfig{}, 300, nil, true, 300 * time.Second},
+		{"disabled", CacheConfig{MaxSize: -1}, 300, nil, false, 0},
+		{"zero ttl", CacheConfig{}, 0, nil, false, 0},
+		{"min ttl", CacheConfig{MinTTL: 30}, 0, nil, true, 30 * time.Second},
+		{"max ttl", CacheConfig{MaxTTL: 100}, 300, nil, true, 100 * time.Second},
+		{"negative default", CacheConfig{}, 0, errors.New("failed"), true, defaultNegativeTTL * time.Second},
+		{"negative ttl", CacheConfig{NegativeTTL: 5}, 0, errors.New("failed"), true, 5 * time.Second},
+		{"negative disabled", CacheConfig{NegativeTTL: -1}, 0, errors.New("failed"), false, 0},
+	}
+	for _, tt := range tests {
+		c := newDNSCache(tt.conf)
+		start := time.Now()
+		c.put("www.example.com", "1.2.3.4", tt.ttl, tt.err)
+		e, exist := c.items["www.example.com"]
+		if exist != tt.cached {
+			t.Errorf("%s: expect cached %v, but got %v", tt.name, tt.cached, exist)
+			continue
+		}
+		if !exist {
+			continue
+		}
+		if d := e.Value.(*dnsCacheItem).expire.Sub(start); d < tt.expires || d > tt.expires+time.Second {
+			t.Errorf("%s: expect expire in %v, but got %v", tt.name, tt.expires, d)
+		}
+	}
+}
+
+func TestDNSCacheClampTTL(t *testing.T) {
+	c := newDNSCache(CacheConfig{MinTTL: 10, MaxTTL: 600, Overrides: []TTLOverride{
+		{Domains: []string{"*.cdn.example.com"}, MinTTL: 0, MaxTTL: 30},
+		{Domains: []string{"static.example.com"}, MinTTL: 3600},
+		{Domains: []string{"*.example.com"}, MinTTL: 60, MaxTTL: 120},
+	}})
+	tests := []struct {
+		domain string
+		ttl    int
+		expect int
+	}{
+		{"www.other.com", 0, 10},
+		{"www.other.com", 300, 300},
+		{"www.other.com", 3600, 600},
+		{"a.cdn.example.com", 0, 0},
+		{"a.cdn.example.com", 300, 30},
+		//override without max is clamped by the cache max
+		{"static.example.com", 0, 600},
+		{"www.example.com", 10, 60},
+		{"www.example.com", 300, 120},
+	}
+	for _, tt := range tests {
+		if ttl := c.clampTTL(tt.domain, tt.ttl); ttl != tt.expect {
+			t.Errorf("clamp ttl %d of %s expect %d, but got %d", tt.ttl, tt.domain, tt.expect, ttl)
+		}
+	}
+}
+
+func TestDNSCacheEvict(t *testing.T) {
+	c := newDNSCache(CacheConfig{MaxSize: 2})
+	c.put("a.com", "1.1.1.1", 60, nil)
+	c.put("b.com", "2.2.2.2", 60, nil)
+	//recently used records are kept
+	c.get("a.com")
+	c.put("c.com", "3.3.3.3", 60, nil)
+	if _, exist := c.items["b.com"]; exist {
+		t.Errorf("expect least recently used record evicted")
+	}
+	for _, domain := range []string{"a.com", "c.com"} {
+		if _, exist := c.items[domain]; !exist {
+			t.Errorf("expect %s cached", domain)
+		}
+	}
+	//update keeps the size
+	c.put("a.com", "4.4.4.4", 60, nil)
+	if item, _ := c.get("a.com"); nil == item || item.ip != "4.4.4.4" || c.lru.Len() != 2 {
+		t.Errorf("expect updated record of a.com in 2 records")
+	}
+	if c.stats.Evictions != 1 {
+		t.Errorf("expect 1 eviction, but got %d", c.stats.Evictions)
+	}
+}
+
+func TestClampResponseTTL(t *testing.T) {
+	InitCache(CacheConfig{MinTTL: 60, MaxTTL: 3600})
+	defer InitCache(CacheConfig{})
+	response := func(ttl uint32) []byte {
+		msg := new(dns.Msg)
+		msg.SetQuestion("www.example.com.", dns.TypeA)
+		msg.Response = true
+		rr, _ := dns.NewRR("www.example.com. 0 IN A 1.2.3.4")
+		rr.Header().Ttl = ttl
+		msg.Answer = append(msg.Answer, rr)
+		msg.SetEdns0(4096, true)
+		b, _ := msg.Pack()
+		return b
+	}
+	tests := []struct {
+		ttl    uint32
+		expect uint32
+	}{
+		{0, 60},
+		{300, 300},
+		{86400, 3600},
+	}
+	for _, tt := range tests {
+		res := response(tt.ttl)
+		b := ClampResponseTTL(res)
+		msg := new(dns.Msg)
+		if err := msg.Unpack(b); nil != err {
+			t.Fatal(err)
+		}
+		if ttl := msg.Answer[0].Header().Ttl; ttl != tt.expect {
+			t.Errorf("expect ttl %d clamped to %d, but got %d", tt.ttl, tt.expect, ttl)
+		}
+		if opt := msg.IsEdns0(); nil == opt || !opt.Do() {
+			t.Errorf("expect OPT record kept as is")
+		}
+		if tt.ttl == tt.expect && !bytes.Equal(b, res) {
+			t.Errorf("expect response of ttl %d returned as is", tt.ttl)
+		}
+	}
+	if b := ClampResponseTTL([]byte("invalid")); string(b) != "invalid" {
+		t.Errorf("expect invalid response returned as is")
+	}
+}
@@ -2,12 +2,15 @@ package dns
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 	"net"
+	"strings"
+	"sync/atomic"
 
 	"github.com/miekg/dns"
 	"github.com/yinqiwen/fdns"
-	"github.com/yinqiwen/gotoolkit/cip"
+	"github.com/yinqiwen/gsnova/common/helper"
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/netx"
 )
@@ -28,6 +31,17 @@ func pickIP(rr []dns.RR) string {
 	return ""
 }
 
+// answerTTL return the min TTL of answers
+func answerTTL(rr []dns.RR) uint32 {
+	ttl := uint32(0)
+	for _, answer := range rr {
+		if 0 == ttl || answer.Header().Ttl < ttl {
+			ttl = answer.Header().Ttl
+		}
+	}
+	return ttl
+}
+
 func selectDNSServer(ss []string) string {
 	var server string
 	slen := len(ss)
@@ -48,31 +62,106 @@ func getIPByDefaultResolver(domain string) (string, error) {
 }
 
 func DnsGetDoaminIP(domain string) (string, error) {
+	ip, err := dnsGetDoaminIP(domain)
+	if nil == err && IsRebinding(domain, net.ParseIP(ip)) {
+		logger.Notice("Block dns rebinding %s -> %s", domain, ip)
+		return "", ErrDNSRebinding
+	}
+	return ip, err
+}
+
+func dnsGetDoaminIP(domain string) (string, error) {
+	return sharedCache.cachedLookup(domain, resolveDomainIP)
+}
+
+func resolveDomainIP(domain string) (string, uint32, error) {
+	if len(secureDNSServers) > 0 {
+		ip, ttl, err := secureLookupIP(domain)
+		if nil == err || secureDNSStrict {
+			return ip, ttl, err
+		}
+	}
 	if nil != LocalDNS {
 		ips, err := LocalDNS.LookupA(domain)
 		if len(ips) > 0 {
-			return pickIP(ips), err
+			return pickIP(ips), answerTTL(ips), err
 		}
 	}
-	return getIPByDefaultResolver(domain)
+	ip, err := getIPByDefaultResolver(domain)
+	if nil == err && IsFakeIP(net.ParseIP(ip)) {
+		//system resolver points to local fake ip dns
+		return "", 0, fmt.Errorf("fake ip %s resolved for %s by system resolver", ip, domain)
+	}
+	return ip, defaultResolverTTL, err
+}
+
+// CountryIPSet is the ip ranges of a country loaded into radix trie
+type CountryIPSet struct {
+	Country string
+	trie    *helper.IPTrie
 }
 
-var CNIPSet *cip.CountryIPSet
+func (s *CountryIPSet) IsInCountry(ip net.IP, country string) bool {
+	return s.Country == country && s.trie.Contains(ip)
+}
+
+// Len return the number of ip ranges in the set
+func (s *CountryIPSet) Len() int {
+	return s.trie.Len()
+}
+
+// LoadCountryIPSet load the CIDR file of the country, the parsed trie is cached as '<file>.trie'
+func LoadCountryIPSet(file string, country string) (*CountryIPSet, error) {
+	trie, err := helper.LoadIPTrieFile(file)
+	if nil != err {
+		return nil, err
+	}
+	logger.Info("Loaded %d %s ip ranges from %s into %d bytes", trie.Len(), country, file, trie.MemSize())
+	return &CountryIPSet{Country: country, trie: trie}, nil
+}
+
+var cnIPSet atomic.Value
+
+// GetCNIPSet return the loaded CN ip set, nil if not loaded
+func GetCNIPSet() *CountryIPSet {
+	s, _ := cnIPSet.Load().(*CountryIPSet)
+	return s
+}
+
+// SetCNIPSet replace the CN ip set used by resolvers, lookups in progress keep the old one
+func SetCNIPSet(s *CountryIPSet) {
+	cnIPSet.Store(s)
+}
 
 type LocalDNSConfig struct {
 	Listen     string
 	TrustedDNS []string
 	FastDNS    []string
 	CNIPSet    string
+	//DoH/DoT servers tried in order before other resolvers, like 'https://1.1.1.1/dns-query', 'tls://8.8.8.8:853#dns.google'
+	SecureDNS []string
+	//do not fallback to plain dns if all secure servers failed
+	SecureDNSStrict bool
+
+	RebindingProtection bool
+	//domain patterns allowed to resolve to internal addresses
+	RebindingAllowList []string
+
+	Cache    CacheConfig
+	FakeIP   FakeIPConfig
+	SplitDNS SplitDNSConfig
 }
 
 func Init(conf *LocalDNSConfig) {
-	cnipset, err := cip.LoadIPSet(conf.CNIPSet, "CN")
-	if nil != err {
-		logger.Error("Failed to load IP range file:%s with reason:%v", conf.CNIPSet, err)
-	} else {
-		CNIPSet = cnipset
+	rebindingProtection = conf.RebindingProtection
+	initSecureDNS(conf.SecureDNS, conf.SecureDNSStrict)
+	InitCache(conf.Cache)
+	initFakeIP(&conf.FakeIP)
+	rebindingAllowList = nil
+	for _, rule := range conf.RebindingAllowList {
+		rebindingAllowList = append(rebindingAllowList, strings.ToLower(rule))
 	}
+	//'CNIPSet' is loaded by the caller with LoadCountryIPSet & SetCNIPSet
 	cfg := &fdns.Config{}
 	cfg.Listen = conf.Listen
 	for _, s := range conf.FastDNS {
@@ -94,10 +183,11 @@ func Init(conf *LocalDNSConfig) {
 	cfg.MinTTL = 24 * 3600
 	cfg.DialTimeout = netx.DialTimeout
 	cfg.IsCNIP = func(ip net.IP) bool {
-		if nil == CNIPSet {
+		cnipset := GetCNIPSet()
+		if nil == cnipset {
 			return false
 		}
-		return CNIPSet.IsInCountry(ip, "CN")
+		return cnipset.IsInCountry(ip, "CN")
 	}
 	cfg.IsDomainPoisioned = func(domain string) int {
 		//conf.GFWList.Load()
@@ -105,11 +195,7 @@ func Init(conf *LocalDNSConfig) {
 	}
 	LocalDNS, _ = fdns.NewTrustedDNS(cfg)
 	if len(conf.Listen) > 0 {
-		go func() {
-			err := LocalDNS.Start()
-			if nil != err {
-				logger.Error("Failed to start dns server:%v", err)
-			}
-		}()
+		startLocalDNSServer(conf.Listen)
 	}
+	initSplitDNS(&conf.SplitDNS, conf.Listen)
 }
@@ -0,0 +1,224 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// FakeIPConfig make local dns answer A queries with addresses from a reserved pool which are mapped back
+// to the queried domains when proxying, so domain based rules work under transparent/TUN interception.
+type FakeIPConfig struct {
+	Enable bool
+	//default 198.18.0.0/15
+	Range string
+	//domain patterns resolved to real addresses, like '*.lan', 'time.*.com'
+	Exclude []string
+	//TTL of fake answers in seconds, default 1
+	TTL int
+}
+
+const defaultFakeIPRange = "198.18.0.0/15"
+
+// fakeIPPool allocate addresses cyclically, the oldest mapping is recycled once the pool is exhausted
+type fakeIPPool struct {
+	ipnet   *net.IPNet
+	base    uint32
+	size    uint32
+	next    uint32
+	ttl     uint32
+	exclude []string
+	ip2host map[uint32]string
+	host2ip map[string]uint32
+	mutex   sync.Mutex
+}
+
+var fakeIP *fakeIPPool
+
+func newFakeIPPool(conf *FakeIPConfig) (*fakeIPPool, error) {
+	cidr := conf.Range
+	if len(cidr) == 0 {
+		cidr = defaultFakeIPRange
+	}
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if nil != err {
+		return nil, err
+	}
+	if nil == ipnet.IP.To4() {
+		return nil, fmt.Errorf("fake ip range:%s is not ipv4", cidr)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones < 2 || bits-ones > 24 {
+		return nil, fmt.Errorf("fake ip range:%s should be between /8 and /30", cidr)
+	}
+	pool := &fakeIPPool{
+		ipnet:   ipnet,
+		base:    binary.BigEndian.Uint32(ipnet.IP.To4()),
+		size:    uint32(1)<<uint(bits-ones) - 2,
+		ttl:     uint32(conf.TTL),
+		ip2host: make(map[uint32]string),
+		host2ip: make(map[string]uint32),
+	}
+	if 0 == pool.ttl {
+		pool.ttl = 1
+	}
+	for _, pattern := range conf.Exclude {
+		pool.exclude = append(pool.exclude, strings.ToLower(pattern))
+	}
+	return pool, nil
+}
+
+func (p *fakeIPPool) excluded(domain string) bool {
+	for _, pattern := range p.exclude {
+		if matched, _ := filepath.Match(pattern, domain); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *fakeIPPool) lookup(domain string) net.IP {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	offset, exist := p.host2ip[domain]
+	if !exist {
+		//skip the network address
+		offset = p.next + 1
+		p.next = (p.next + 1) % p.size
+		if old, used := p.ip2host[offset]; used {
+			delete(p.host2ip, old)
+		}
+		p.ip2host[offset] = domain
+		p.host2ip[domain] = offset
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, p.base+offset)
+	return ip
+}
+
+func (p *fakeIPPool) host(ip net.IP) (string, bool) {
+	ip4 := ip.To4()
+	if nil == ip4 || !p.ipnet.Contains(ip4) {
+		return "", false
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	host, exist := p.ip2host[binary.BigEndian.Uint32(ip4)-p.base]
+	return host, exist
+}
+
+// response answer A queries with fake addresses & AAAA queries with empty answer, false if not handled
+func (p *fakeIPPool) response(req *dns.Msg) (*dns.Msg, bool) {
+	if len(req.Question) != 1 {
+		return nil, false
+	}
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET || (q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+		return nil, false
+	}
+	domain := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	if len(domain) == 0 || nil != net.ParseIP(domain) || p.excluded(domain) {
+		return nil, false
+	}
+	res := new(dns.Msg)
+	res.SetReply(req)
+	res.RecursionAvailable = true
+	if q.Qtype == dns.TypeA {
+		res.Answer = append(res.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: p.ttl},
+			A:   p.lookup(domain),
+		})
+	}
+	return res, true
+}
+
+func initFakeIP(conf *FakeIPConfig) {
+	fakeIP = nil
+	if !conf.Enable {
+		return
+	}
+	pool, err := newFakeIPPool(conf)
+	if nil != err {
+		logger.Error("[ERROR]Failed to init fake ip with reason:%v", err)
+		return
+	}
+	logger.Notice("Fake ip mode enabled with range:%s", pool.ipnet)
+	fakeIP = pool
+}
+
+// FakeIPEnabled return true if local dns answer with fake addresses
+func FakeIPEnabled() bool {
+	return nil != fakeIP
+}
+
+// IsFakeIP return true if the ip is in the fake ip range
+func IsFakeIP(ip net.IP) bool {
+	pool := fakeIP
+	return nil != pool && nil != ip && pool.ipnet.Contains(ip)
+}
+
+// FakeIPHost return the domain mapped to the fake ip, the address is returned if not a mapped fake ip
+func FakeIPHost(addr string) (string, bool) {
+	pool := fakeIP
+	if nil == pool {
+		return addr, false
+	}
+	ip := net.ParseIP(addr)
+	if nil == ip {
+		return addr, false
+	}
+	if host, ok := pool.host(ip); ok {
+		return host, true
+	}
+	return addr, false
+}
+
+func fakeIPResponse(packet []byte) ([]byte, bool) {
+	pool := fakeIP
+	if nil == pool {
+		return nil, false
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(packet); nil != err {
+		return nil, false
+	}
+	res, ok := pool.response(req)
+	if !ok {
+		return nil, false
+	}
+	b, err := res.Pack()
+	return b, nil == err
+}
+
+// startLocalDNSServer serve local dns by QueryRaw, so that queries to the listener go through the fake ip pool,
+// secure servers & rebinding filter like those resolved internally
+func startLocalDNSServer(listen string) {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		packet, err := req.Pack()
+		if nil == err {
+			packet, err = QueryRaw(packet)
+		}
+		if nil != err {
+			logger.Debug("Failed to resolve %v with reason:%v", req.Question, err)
+			res := new(dns.Msg)
+			res.SetRcode(req, dns.RcodeServerFailure)
+			w.WriteMsg(res)
+			return
+		}
+		w.Write(FilterRebindingResponse(ClampResponseTTL(packet)))
+	})
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: listen, Net: network, Handler: handler}
+		go func() {
+			if err := server.ListenAndServe(); nil != err {
+				logger.Error("Failed to start dns server:%s with reason:%v", server.Net, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,160 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewFakeIPPool(t *testing.T) {
+	tests := []struct {
+		conf FakeIPConfig
+		base string
+		size uint32
+		ttl  uint32
+		err  bool
+	}{
+		{FakeIPConfig{}, "198.18.0.0", 1<<17 - 2, 1, false},
+		{FakeIPConfig{Range: "10.10.0.0/24", TTL: 30}, "10.10.0.0", 254, 30, false},
+		{FakeIPConfig{Range: "10.10.0.5/30"}, "10.10.0.4", 2, 1, false},
+		{FakeIPConfig{Range: "10.10.0.0/31"}, "", 0, 0, true},
+		{FakeIPConfig{Range: "10.0.0.0/7"}, "", 0, 0, true},
+		{FakeIPConfig{Range: "fd00::/112"}, "", 0, 0, true},
+		{FakeIPConfig{Range: "invalid"}, "", 0, 0, true},
+	}
+	for _, tt := range tests {
+		pool, err := newFakeIPPool(&tt.conf)
+		if tt.err {
+			if nil == err {
+				t.Errorf("range %q: expect error", tt.conf.Range)
+			}
+			continue
+		}
+		if nil != err {
+			t.Errorf("range %q: unexpected error:%v", tt.conf.Range, err)
+			continue
+		}
+		if !pool.ipnet.IP.Equal(net.ParseIP(tt.base)) || pool.size != tt.size || pool.ttl != tt.ttl {
+			t.Errorf("range %q: expect %s/%d/%d, but got %s/%d/%d", tt.conf.Range, tt.base, tt.size, tt.ttl, pool.ipnet.IP, pool.size, pool.ttl)
+		}
+	}
+}
+
+func TestFakeIPPoolLookup(t *testing.T) {
+	pool, _ := newFakeIPPool(&FakeIPConfig{Range: "10.10.0.0/30"})
+	tests := []struct {
+		domain string
+		ip     string
+	}{
+		{"a.example.com", "10.10.0.1"},
+		{"b.example.com", "10.10.0.2"},
+		{"a.example.com", "10.10.0.1"},
+		//the pool is exhausted, the oldest mapping is recycled
+		{"c.example.com", "10.10.0.1"},
+		{"d.example.com", "10.10.0.2"},
+	}
+	for _, tt := range tests {
+		if ip := pool.lookup(tt.domain); ip.String() != tt.ip {
+			t.Errorf("expect fake ip %s of %s, but got %s", tt.ip, tt.domain, ip)
+		}
+	}
+	hosts := []struct {
+		ip    string
+		host  string
+		exist bool
+	}{
+		{"10.10.0.1", "c.example.com", true},
+		{"10.10.0.2", "d.example.com", true},
+		{"10.10.0.3", "", false},
+		{"8.8.8.8", "", false},
+	}
+	for _, tt := range hosts {
+		if host, exist := pool.host(net.ParseIP(tt.ip)); host != tt.host || exist != tt.exist {
+			t.Errorf("expect host %q(%v) of %s, but got %q(%v)", tt.host, tt.exist, tt.ip, host, exist)
+		}
+	}
+	if _, exist := pool.host2ip["a.example.com"]; exist {
+		t.Errorf("expect recycled domain removed")
+	}
+}
+
+func TestFakeIPPoolResponse(t *testing.T) {
+	pool, _ := newFakeIPPool(&FakeIPConfig{Range: "10.10.0.0/24", Exclude: []string{"*.LAN", "time.*.com"}, TTL: 5})
+	tests := []struct {
+		name    string
+		qtype   uint16
+		handled bool
+		answers int
+	}{
+		{"www.example.com.", dns.TypeA, true, 1},
+		{"WWW.Example.com.", dns.TypeA, true, 1},
+		{"www.example.com.", dns.TypeAAAA, true, 0},
+		{"www.example.com.", dns.TypeMX, false, 0},
+		{"nas.lan.", dns.TypeA, false, 0},
+		{"time.apple.com.", dns.TypeA, false, 0},
+		{"1.2.3.4.", dns.TypeA, false, 0},
+	}
+	for _, tt := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion(tt.name, tt.qtype)
+		res, handled := pool.response(req)
+		if handled != tt.handled {
+			t.Errorf("%s %s: expect handled %v, but got %v", tt.name, dns.TypeToString[tt.qtype], tt.handled, handled)
+			continue
+		}
+		if !handled {
+			continue
+		}
+		if res.Id != req.Id || len(res.Answer) != tt.answers {
+			t.Errorf("%s %s: expect %d answers, but got %v", tt.name, dns.TypeToString[tt.qtype], tt.answers, res)
+			continue
+		}
+		if tt.answers > 0 {
+			a := res.Answer[0].(*dns.A)
+			if a.Hdr.Ttl != 5 || !pool.ipnet.Contains(a.A) {
+				t.Errorf("%s: unexpected fake answer %v", tt.name, a)
+			}
+		}
+	}
+	//case insensitive names share the fake ip
+	if len(pool.host2ip) != 1 {
+		t.Errorf("expect one mapped domain, but got %v", pool.host2ip)
+	}
+}
+
+func TestFakeIPHost(t *testing.T) {
+	defer initFakeIP(&FakeIPConfig{})
+	if host, ok := FakeIPHost("198.18.0.1"); ok || host != "198.18.0.1" || FakeIPEnabled() {
+		t.Errorf("expect address as is without fake ip mode")
+	}
+	initFakeIP(&FakeIPConfig{Enable: true})
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	packet, _ := req.Pack()
+	b, ok := fakeIPResponse(packet)
+	if !ok {
+		t.Fatalf("expect fake ip response")
+	}
+	res := new(dns.Msg)
+	res.Unpack(b)
+	ip := res.Answer[0].(*dns.A).A
+	if !IsFakeIP(ip) || IsFakeIP(net.ParseIP("8.8.8.8")) {
+		t.Errorf("unexpected fake ip check of %s", ip)
+	}
+	tests := []struct {
+		addr string
+		host string
+		ok   bool
+	}{
+		{ip.String(), "www.example.com", true},
+		{"198.19.255.254", "198.19.255.254", false},
+		{"8.8.8.8", "8.8.8.8", false},
+		{"www.example.com", "www.example.com", false},
+	}
+	for _, tt := range tests {
+		if host, ok := FakeIPHost(tt.addr); host != tt.host || ok != tt.ok {
+			t.Errorf("expect host %s(%v) of %s, but got %s(%v)", tt.host, tt.ok, tt.addr, host, ok)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// trustedExchange query by secure servers if configured, or the trusted local dns, never by system resolver
+// since poisoned answers of it would leak or break the queried records
+func trustedExchange(req *dns.Msg) (*dns.Msg, error) {
+	if len(secureDNSServers) > 0 {
+		res, err := secureExchange(req)
+		if nil == err || secureDNSStrict {
+			return res, err
+		}
+	}
+	if nil == LocalDNS {
+		return nil, errNoSecureDNS
+	}
+	packed, err := req.Pack()
+	if nil != err {
+		return nil, err
+	}
+	b, err := LocalDNS.QueryRaw(packed)
+	if nil != err {
+		return nil, err
+	}
+	res := new(dns.Msg)
+	if err = res.Unpack(b); nil != err {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LookupECHConfigList return the ECHConfigList published by the HTTPS record of the domain with its TTL,
+// alias mode records are followed once
+func LookupECHConfigList(domain string) ([]byte, uint32, error) {
+	name := dns.Fqdn(domain)
+	for i := 0; i < 2; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeHTTPS)
+		res, err := trustedExchange(req)
+		if nil != err {
+			return nil, 0, err
+		}
+		alias := ""
+		for _, answer := range res.Answer {
+			rr, ok := answer.(*dns.HTTPS)
+			if !ok {
+				continue
+			}
+			if rr.Priority == 0 {
+				alias = rr.Target
+				continue
+			}
+			for _, kv := range rr.Value {
+				if ech, ok := kv.(*dns.SVCBECHConfig); ok && len(ech.ECH) > 0 {
+					return ech.ECH, answerTTL(res.Answer), nil
+				}
+			}
+		}
+		if len(alias) == 0 || alias == "." {
+			break
+		}
+		name = alias
+	}
+	return nil, 0, fmt.Errorf("no ech config published for %s", domain)
+}
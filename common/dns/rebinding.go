@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+var ErrDNSRebinding = errors.New("dns rebinding detected")
+
+var rebindingProtection bool
+var rebindingAllowList []string
+var internalNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		internalNets = append(internalNets, n)
+	}
+}
+
+func isInternalIP(ip net.IP) bool {
+	if nil == ip {
+		return false
+	}
+	if ip.IsUnspecified() {
+		return true
+	}
+	if ip4 := ip.To4(); nil != ip4 {
+		ip = ip4
+	}
+	for _, n := range internalNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRebindingAllowed(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, rule := range rebindingAllowList {
+		if rule == domain {
+			return true
+		}
+		if matched, _ := filepath.Match(rule, domain); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRebinding return true if a public name resolved to an internal address.
+func IsRebinding(domain string, ip net.IP) bool {
+	if !rebindingProtection || len(domain) == 0 || nil != net.ParseIP(domain) {
+		return false
+	}
+	if !isInternalIP(ip) {
+		return false
+	}
+	if strings.EqualFold(domain, "localhost") || isRebindingAllowed(domain) {
+		return false
+	}
+	return true
+}
+
+// FilterRebindingResponse drop the A/AAAA answers pointing to internal addresses
+// from a raw dns response.
+func FilterRebindingResponse(res []byte) []byte {
+	if !rebindingProtection {
+		return res
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(res); nil != err {
+		return res
+	}
+	var answers []dns.RR
+	dropped := false
+	for _, answer := range msg.Answer {
+		var ip net.IP
+		switch rr := answer.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		}
+		if nil != ip && IsRebinding(answer.Header().Name, ip) {
+			logger.Notice("Block dns rebinding answer %s -> %v", answer.Header().Name, ip)
+			dropped = true
+			continue
+		}
+		answers = append(answers, answer)
+	}
+	if !dropped {
+		return res
+	}
+	msg.Answer = answers
+	if len(answers) == 0 {
+		msg.Rcode = dns.RcodeNameError
+	}
+	b, err := msg.Pack()
+	if nil != err {
+		return res
+	}
+	return b
+}
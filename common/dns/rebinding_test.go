@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsRebinding(t *testing.T) {
+	rebindingProtection = true
+	rebindingAllowList = []string{"nas.example.com", "*.lan.example.com"}
+	defer func() {
+		rebindingProtection = false
+		rebindingAllowList = nil
+	}()
+	for _, c := range []struct {
+		domain   string
+		ip       string
+		expected bool
+	}{
+		{"evil.example.com", "192.168.1.1", true},
+		{"evil.example.com.", "10.0.0.1", true},
+		{"evil.example.com", "127.0.0.1", true},
+		{"evil.example.com", "100.64.1.1", true},
+		{"evil.example.com", "0.0.0.0", true},
+		{"evil.example.com", "::1", true},
+		{"evil.example.com", "fd00::1", true},
+		{"evil.example.com", "::ffff:192.168.1.1", true},
+		{"evil.example.com", "8.8.8.8", false},
+		{"evil.example.com", "172.32.0.1", false},
+		{"evil.example.com", "2001:db8::1", false},
+		{"localhost", "127.0.0.1", false},
+		{"NAS.example.com", "192.168.1.2", false},
+		{"printer.lan.example.com.", "192.168.1.3", false},
+		{"lan.example.com", "192.168.1.3", true},
+		{"192.168.1.1", "192.168.1.1", false},
+		{"", "192.168.1.1", false},
+	} {
+		if v := IsRebinding(c.domain, net.ParseIP(c.ip)); v != c.expected {
+			t.Errorf("IsRebinding(%s, %s)=%v, expected %v", c.domain, c.ip, v, c.expected)
+		}
+	}
+	rebindingProtection = false
+	if IsRebinding("evil.example.com", net.ParseIP("192.168.1.1")) {
+		t.Errorf("rebinding should not be checked if protection disabled")
+	}
+}
+
+func TestFilterRebindingResponse(t *testing.T) {
+	rebindingProtection = true
+	defer func() {
+		rebindingProtection = false
+	}()
+	response := func(name string, ips ...string) []byte {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		msg.Response = true
+		for _, ip := range ips {
+			rr, _ := dns.NewRR(name + " 60 IN A " + ip)
+			msg.Answer = append(msg.Answer, rr)
+		}
+		b, _ := msg.Pack()
+		return b
+	}
+	for _, c := range []struct {
+		ips      []string
+		expected []string
+		rcode    int
+	}{
+		{[]string{"8.8.8.8", "1.1.1.1"}, []string{"8.8.8.8", "1.1.1.1"}, dns.RcodeSuccess},
+		{[]string{"8.8.8.8", "192.168.1.1"}, []string{"8.8.8.8"}, dns.RcodeSuccess},
+		{[]string{"10.0.0.1", "127.0.0.1"}, nil, dns.RcodeNameError},
+	} {
+		res := new(dns.Msg)
+		if err := res.Unpack(FilterRebindingResponse(response("evil.example.com.", c.ips...))); nil != err {
+			t.Fatal(err)
+		}
+		var ips []string
+		for _, rr := range res.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+		if len(ips) != len(c.expected) || res.Rcode != c.rcode {
+			t.Errorf("answers %v filtered to %v(rcode %d), expected %v(rcode %d)", c.ips, ips, res.Rcode, c.expected, c.rcode)
+			continue
+		}
+		for i := range ips {
+			if ips[i] != c.expected[i] {
+				t.Errorf("answers %v filtered to %v, expected %v", c.ips, ips, c.expected)
+			}
+		}
+	}
+	garbage := []byte{1, 2, 3}
+	if b := FilterRebindingResponse(garbage); string(b) != string(garbage) {
+		t.Errorf("invalid response should be returned as is")
+	}
+}
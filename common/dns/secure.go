@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/netx"
+)
+
+var errNoSecureDNS = errors.New("no secure dns server available")
+
+const secureDNSTimeout = 3 * time.Second
+
+type secureDNSServer interface {
+	exchange(req *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// dohServer send queries by RFC 8484 POST requests over reused http connections
+type dohServer struct {
+	url    string
+	client *http.Client
+}
+
+func (s *dohServer) String() string {
+	return s.url
+}
+
+func (s *dohServer) exchange(req *dns.Msg) (*dns.Msg, error) {
+	packed, err := req.Pack()
+	if nil != err {
+		return nil, err
+	}
+	hreq, _ := http.NewRequest("POST", s.url, bytes.NewReader(packed))
+	hreq.Header.Set("Content-Type", "application/dns-message")
+	hreq.Header.Set("Accept", "application/dns-message")
+	res, err := s.client.Do(hreq)
+	if nil != err {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if nil != err {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("doh server:%s response status:%d", s.url, res.StatusCode)
+	}
+	msg := new(dns.Msg)
+	err = msg.Unpack(body)
+	return msg, err
+}
+
+// dotServer send queries over one reused tls connection which is redialed on failure
+type dotServer struct {
+	addr       string
+	serverName string
+	conn       *dns.Conn
+	mutex      sync.Mutex
+}
+
+func (s *dotServer) String() string {
+	return "tls://" + s.addr
+}
+
+func (s *dotServer) dial() (*dns.Conn, error) {
+	c, err := netx.DialTimeout("tcp", s.addr, secureDNSTimeout)
+	if nil != err {
+		return nil, err
+	}
+	tlsConn := tls.Client(c, &tls.Config{ServerName: s.serverName})
+	tlsConn.SetDeadline(time.Now().Add(secureDNSTimeout))
+	if err = tlsConn.Handshake(); nil != err {
+		c.Close()
+		return nil, err
+	}
+	return &dns.Conn{Conn: tlsConn}, nil
+}
+
+func (s *dotServer) exchange(req *dns.Msg) (*dns.Msg, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var err error
+	for i := 0; i < 2; i++ {
+		if nil == s.conn {
+			if s.conn, err = s.dial(); nil != err {
+				return nil, err
+			}
+		}
+		var res *dns.Msg
+		s.conn.SetDeadline(time.Now().Add(secureDNSTimeout))
+		if err = s.conn.WriteMsg(req); nil == err {
+			res, err = s.conn.ReadMsg()
+		}
+		if nil == err {
+			return res, nil
+		}
+		//the server may close idle connection, retry with a new one
+		s.conn.Close()
+		s.conn = nil
+	}
+	return nil, err
+}
+
+func newSecureDNSServer(server string) (secureDNSServer, error) {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		transport := &http.Transport{
+			DialContext:         netx.DialContext,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: secureDNSTimeout,
+			ForceAttemptHTTP2:   true,
+		}
+		return &dohServer{url: server, client: &http.Client{Transport: transport, Timeout: secureDNSTimeout}}, nil
+	case strings.HasPrefix(server, "tls://"):
+		addr := strings.TrimPrefix(server, "tls://")
+		serverName := ""
+		if idx := strings.Index(addr, "#"); idx > 0 {
+			//'tls://1.1.1.1:853#cloudflare-dns.com' to verify the server by name
+			addr, serverName = addr[:idx], addr[idx+1:]
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if nil != err {
+			host = addr
+			addr = net.JoinHostPort(addr, "853")
+		}
+		if len(serverName) == 0 {
+			serverName = host
+		}
+		return &dotServer{addr: addr, serverName: serverName}, nil
+	}
+	return nil, fmt.Errorf("invalid secure dns server:%s", server)
+}
+
+var secureDNSServers []secureDNSServer
+var secureDNSStrict bool
+
+func initSecureDNS(servers []string, strict bool) {
+	secureDNSStrict = strict
+	secureDNSServers = nil
+	for _, s := range servers {
+		server, err := newSecureDNSServer(s)
+		if nil != err {
+			logger.Error("[ERROR]%v", err)
+			continue
+		}
+		secureDNSServers = append(secureDNSServers, server)
+	}
+}
+
+// secureExchange try secure servers in configured order
+func secureExchange(req *dns.Msg) (*dns.Msg, error) {
+	err := errNoSecureDNS
+	for _, server := range secureDNSServers {
+		var res *dns.Msg
+		res, err = server.exchange(req)
+		if nil == err {
+			return res, nil
+		}
+		logger.Notice("Failed to query secure dns server:%s with reason:%v", server, err)
+	}
+	return nil, err
+}
+
+func secureLookupIP(domain string) (string, uint32, error) {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		req := new(dns.Msg)
+		req.SetQuestion(dns.Fqdn(domain), qtype)
+		res, err := secureExchange(req)
+		if nil != err {
+			return "", 0, err
+		}
+		if ip := pickIP(res.Answer); len(ip) > 0 {
+			return ip, answerTTL(res.Answer), nil
+		}
+	}
+	return "", 0, fmt.Errorf("no address found for %s", domain)
+}
+
+// QueryRaw resolve the raw dns packet by fake ip pool or secure servers if configured, or the local dns
+func QueryRaw(packet []byte) ([]byte, error) {
+	if res, ok := fakeIPResponse(packet); ok {
+		return res, nil
+	}
+	if len(secureDNSServers) > 0 {
+		req := new(dns.Msg)
+		if err := req.Unpack(packet); nil != err {
+			return nil, err
+		}
+		res, err := secureExchange(req)
+		if nil == err {
+			res.Id = req.Id
+			return res.Pack()
+		}
+		if secureDNSStrict {
+			return nil, err
+		}
+	}
+	if nil == LocalDNS {
+		return nil, errNoSecureDNS
+	}
+	return LocalDNS.QueryRaw(packet)
+}
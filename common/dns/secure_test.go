@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewSecureDNSServer(t *testing.T) {
+	tests := []struct {
+		server     string
+		addr       string
+		serverName string
+		err        bool
+	}{
+		{"https://1.1.1.1/dns-query", "", "", false},
+		{"tls://8.8.8.8:853#dns.google", "8.8.8.8:853", "dns.google", false},
+		{"tls://1.1.1.1", "1.1.1.1:853", "1.1.1.1", false},
+		{"tls://dns.example.com:8853", "dns.example.com:8853", "dns.example.com", false},
+		{"tls://1.1.1.1#cloudflare-dns.com", "1.1.1.1:853", "cloudflare-dns.com", false},
+		{"udp://8.8.8.8", "", "", true},
+		{"8.8.8.8", "", "", true},
+	}
+	for _, tt := range tests {
+		s, err := newSecureDNSServer(tt.server)
+		if tt.err {
+			if nil == err {
+				t.Errorf("%s: expect error", tt.server)
+			}
+			continue
+		}
+		if nil != err {
+			t.Errorf("%s: unexpected error:%v", tt.server, err)
+			continue
+		}
+		switch v := s.(type) {
+		case *dohServer:
+			if v.url != tt.server {
+				t.Errorf("%s: unexpected doh url %s", tt.server, v.url)
+			}
+		case *dotServer:
+			if v.addr != tt.addr || v.serverName != tt.serverName {
+				t.Errorf("%s: expect %s#%s, but got %s#%s", tt.server, tt.addr, tt.serverName, v.addr, v.serverName)
+			}
+		}
+	}
+}
+
+// testSecureServer answer queries with the address or fail, and record the queries
+type testSecureServer struct {
+	name    string
+	ip      string
+	queries int
+}
+
+func (s *testSecureServer) String() string {
+	return s.name
+}
+
+func (s *testSecureServer) exchange(req *dns.Msg) (*dns.Msg, error) {
+	s.queries++
+	if len(s.ip) == 0 {
+		return nil, errors.New(s.name + " failed")
+	}
+	res := new(dns.Msg)
+	res.SetReply(req)
+	if req.Question[0].Qtype == dns.TypeA {
+		rr, _ := dns.NewRR(req.Question[0].Name + " 60 IN A " + s.ip)
+		res.Answer = append(res.Answer, rr)
+	}
+	return res, nil
+}
+
+func TestSecureExchangeOrder(t *testing.T) {
+	defer initSecureDNS(nil, false)
+	bad := &testSecureServer{name: "bad"}
+	good := &testSecureServer{name: "good", ip: "1.2.3.4"}
+	other := &testSecureServer{name: "other", ip: "5.6.7.8"}
+	secureDNSServers = []secureDNSServer{bad, good, other}
+	ip, ttl, err := secureLookupIP("www.example.com")
+	if nil != err || ip != "1.2.3.4" || ttl != 60 {
+		t.Fatalf("expect 1.2.3.4 with ttl 60 from the second server, but got %s %d %v", ip, ttl, err)
+	}
+	if bad.queries != 1 || good.queries != 1 || other.queries != 0 {
+		t.Errorf("servers should be tried in order until one answered, but got %d/%d/%d queries", bad.queries, good.queries, other.queries)
+	}
+
+	secureDNSServers = []secureDNSServer{bad}
+	if _, _, err = secureLookupIP("www.example.com"); nil == err || err.Error() != "bad failed" {
+		t.Errorf("expect error of the last server, but got %v", err)
+	}
+	secureDNSServers = nil
+	if _, err = secureExchange(new(dns.Msg)); err != errNoSecureDNS {
+		t.Errorf("expect %v without servers, but got %v", errNoSecureDNS, err)
+	}
+}
+
+func TestQueryRawSecureFallback(t *testing.T) {
+	defer initSecureDNS(nil, false)
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	req.Id = 1234
+	packet, _ := req.Pack()
+
+	secureDNSServers = []secureDNSServer{&testSecureServer{name: "good", ip: "1.2.3.4"}}
+	b, err := QueryRaw(packet)
+	if nil != err {
+		t.Fatal(err)
+	}
+	res := new(dns.Msg)
+	if err = res.Unpack(b); nil != err {
+		t.Fatal(err)
+	}
+	if res.Id != 1234 || pickIP(res.Answer) != "1.2.3.4" {
+		t.Errorf("expect answer 1.2.3.4 of id 1234, but got %v", res)
+	}
+
+	//no local dns to fallback in test
+	secureDNSServers = []secureDNSServer{&testSecureServer{name: "bad"}}
+	if _, err = QueryRaw(packet); err != errNoSecureDNS {
+		t.Errorf("expect fallback to the local dns, but got %v", err)
+	}
+	secureDNSStrict = true
+	if _, err = QueryRaw(packet); nil == err || err.Error() != "bad failed" {
+		t.Errorf("expect error of secure server in strict mode, but got %v", err)
+	}
+}
+
+func TestDoHExchange(t *testing.T) {
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/dns-message" {
+			t.Errorf("unexpected doh request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req := new(dns.Msg)
+		if err := req.Unpack(body); nil != err {
+			t.Errorf("invalid doh request:%v", err)
+		}
+		res := new(dns.Msg)
+		res.SetReply(req)
+		rr, _ := dns.NewRR(req.Question[0].Name + " 30 IN A 9.9.9.9")
+		res.Answer = append(res.Answer, rr)
+		b, _ := res.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.WriteHeader(status)
+		w.Write(b)
+	}))
+	defer srv.Close()
+	s := &dohServer{url: srv.URL, client: srv.Client()}
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+
+	status = 200
+	res, err := s.exchange(req)
+	if nil != err || pickIP(res.Answer) != "9.9.9.9" {
+		t.Fatalf("expect 9.9.9.9, but got %v %v", res, err)
+	}
+	status = 500
+	if _, err = s.exchange(req); nil == err {
+		t.Errorf("expect error of non 200 response")
+	}
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// splitDNSMarker tag system rules created by gsnova, only tagged rules are removed
+const splitDNSMarker = "gsnova"
+
+// SplitDNSConfig scope system resolvers so only listed domains are routed to 'Listen', other domains
+// like those of corporate VPN keep using system dns, by NRPT rules on windows & /etc/resolver on macOS
+type SplitDNSConfig struct {
+	Enable bool
+	//domain suffixes like 'google.com', subdomains included
+	Domains []string
+}
+
+// validSplitDomain allow only [a-z0-9.-], domains are passed to powershell scripts & resolver file names
+func validSplitDomain(d string) bool {
+	for _, c := range d {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '.' && c != '-' {
+			return false
+		}
+	}
+	return !strings.Contains(d, "..")
+}
+
+func (conf *SplitDNSConfig) domains() []string {
+	var ds []string
+	for _, d := range conf.Domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".*")
+		if len(d) == 0 {
+			continue
+		}
+		if !validSplitDomain(d) {
+			logger.Error("[ERROR]Invalid split dns domain:%s", d)
+			continue
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+func initSplitDNS(conf *SplitDNSConfig, listen string) {
+	ResetSplitDNS()
+	if !conf.Enable || len(listen) == 0 {
+		return
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if nil != err {
+		logger.Error("[ERROR]Invalid dns listen address:%s for split dns", listen)
+		return
+	}
+	if len(host) == 0 || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	domains := conf.domains()
+	if len(domains) == 0 {
+		return
+	}
+	if err := applySplitDNS(domains, host, port); nil != err {
+		logger.Error("[ERROR]Failed to apply split dns for reason:%v", err)
+		return
+	}
+	logger.Notice("Split dns routes %v to %s:%s", domains, host, port)
+}
+
+// ResetSplitDNS remove system resolver rules created by gsnova
+func ResetSplitDNS() {
+	if err := resetSplitDNS(); nil != err {
+		logger.Error("[ERROR]Failed to reset split dns for reason:%v", err)
+	}
+}
@@ -0,0 +1,52 @@
+// +build darwin
+
+package dns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const resolverDir = "/etc/resolver"
+
+// applySplitDNS write a resolver file per domain, existing files not created by gsnova are kept
+func applySplitDNS(domains []string, host string, port string) error {
+	if err := os.MkdirAll(resolverDir, 0755); nil != err {
+		return err
+	}
+	content := fmt.Sprintf("# %s\nnameserver %s\nport %s\n", splitDNSMarker, host, port)
+	for _, d := range domains {
+		file := filepath.Join(resolverDir, d)
+		if old, err := ioutil.ReadFile(file); nil == err && !isSplitDNSFile(old) {
+			return fmt.Errorf("resolver file:%s exists", file)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func isSplitDNSFile(content []byte) bool {
+	return strings.HasPrefix(string(content), "# "+splitDNSMarker+"\n")
+}
+
+func resetSplitDNS() error {
+	files, err := ioutil.ReadDir(resolverDir)
+	if nil != err {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, f := range files {
+		file := filepath.Join(resolverDir, f.Name())
+		if content, err := ioutil.ReadFile(file); nil == err && isSplitDNSFile(content) {
+			os.Remove(file)
+		}
+	}
+	return nil
+}
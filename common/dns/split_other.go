@@ -0,0 +1,13 @@
+// +build !windows,!darwin
+
+package dns
+
+import "errors"
+
+func applySplitDNS(domains []string, host string, port string) error {
+	return errors.New("split dns is only supported on windows & macOS")
+}
+
+func resetSplitDNS() error {
+	return nil
+}
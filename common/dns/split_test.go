@@ -0,0 +1,35 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidSplitDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		valid  bool
+	}{
+		{"google.com", true},
+		{"corp-vpn.example.com", true},
+		{"10.in-addr.arpa", true},
+		{"a..com", false},
+		{"Google.com", false},
+		{"b.com;rm -rf", false},
+		{"../etc/passwd", false},
+		{"a.com/b", false},
+	}
+	for _, tt := range tests {
+		if valid := validSplitDomain(tt.domain); valid != tt.valid {
+			t.Errorf("expect %q valid %v, but got %v", tt.domain, tt.valid, valid)
+		}
+	}
+}
+
+func TestSplitDNSDomains(t *testing.T) {
+	conf := &SplitDNSConfig{Domains: []string{" Google.COM ", "*.youtube.com", ".twitter.com.", "", "*", "bad domain.com", "a..com"}}
+	expect := []string{"google.com", "youtube.com", "twitter.com"}
+	if ds := conf.domains(); !reflect.DeepEqual(ds, expect) {
+		t.Errorf("expect domains %v, but got %v", expect, ds)
+	}
+}
@@ -0,0 +1,36 @@
+// +build windows
+
+package dns
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func runPowerShell(script string) error {
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).CombinedOutput()
+	if nil != err {
+		return fmt.Errorf("%v:%s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// applySplitDNS add NRPT rules, NRPT name servers are always queried on port 53
+func applySplitDNS(domains []string, host string, port string) error {
+	if port != "53" {
+		return fmt.Errorf("NRPT name server must listen on port 53, but got %s", port)
+	}
+	for _, d := range domains {
+		script := fmt.Sprintf("Add-DnsClientNrptRule -Namespace '.%s' -NameServers '%s' -Comment '%s'", d, host, splitDNSMarker)
+		if err := runPowerShell(script); nil != err {
+			return err
+		}
+	}
+	return runPowerShell("Clear-DnsClientCache")
+}
+
+func resetSplitDNS() error {
+	script := fmt.Sprintf("Get-DnsClientNrptRule | Where-Object { $_.Comment -eq '%s' } | Remove-DnsClientNrptRule -Force", splitDNSMarker)
+	return runPowerShell(script)
+}
@@ -0,0 +1,140 @@
+package dnspub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareProvider has no geo routing for plain records, regional records
+// are published to '<region>.<name>' instead.
+type cloudflareProvider struct {
+	token  string
+	zoneID string
+	ttl    int
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+type cloudflareResponse struct {
+	Success bool            `json:"success"`
+	Errors  json.RawMessage `json:"errors"`
+	Result  json.RawMessage `json:"result"`
+}
+
+func (p *cloudflareProvider) call(method, path string, body interface{}, result interface{}) error {
+	var buf bytes.Buffer
+	if nil != body {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+path, &buf)
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := probeClient.Do(req)
+	if nil != err {
+		return err
+	}
+	defer res.Body.Close()
+	var cres cloudflareResponse
+	err = json.NewDecoder(res.Body).Decode(&cres)
+	if nil != err {
+		return err
+	}
+	if !cres.Success {
+		return fmt.Errorf("cloudflare api error:%s", string(cres.Errors))
+	}
+	if nil != result {
+		return json.Unmarshal(cres.Result, result)
+	}
+	return nil
+}
+
+func regionName(name, region string) string {
+	if len(region) == 0 {
+		return name
+	}
+	return strings.ToLower(region) + "." + name
+}
+
+func recordType(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "AAAA"
+	}
+	return "A"
+}
+
+func (p *cloudflareProvider) list(name string) ([]cloudflareRecord, error) {
+	var records []cloudflareRecord
+	err := p.call("GET", "/zones/"+p.zoneID+"/dns_records?name="+url.QueryEscape(name), nil, &records)
+	if nil != err {
+		return nil, err
+	}
+	var rs []cloudflareRecord
+	for _, r := range records {
+		if r.Type == "A" || r.Type == "AAAA" {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (p *cloudflareProvider) GetRecords(name string, region string) ([]string, error) {
+	records, err := p.list(regionName(name, region))
+	if nil != err {
+		return nil, err
+	}
+	ips := []string{}
+	for _, r := range records {
+		ips = append(ips, r.Content)
+	}
+	return ips, nil
+}
+
+func (p *cloudflareProvider) SetRecords(name string, region string, ips []string) error {
+	name = regionName(name, region)
+	records, err := p.list(name)
+	if nil != err {
+		return err
+	}
+	expected := make(map[string]bool)
+	for _, ip := range ips {
+		expected[ip] = true
+	}
+	for _, r := range records {
+		if expected[r.Content] {
+			delete(expected, r.Content)
+			continue
+		}
+		err = p.call("DELETE", "/zones/"+p.zoneID+"/dns_records/"+r.ID, nil, nil)
+		if nil != err {
+			return err
+		}
+	}
+	for ip := range expected {
+		cr := &cloudflareRecord{
+			Type:    recordType(ip),
+			Name:    name,
+			Content: ip,
+			TTL:     p.ttl,
+		}
+		err = p.call("POST", "/zones/"+p.zoneID+"/dns_records", cr, nil)
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package dnspub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// Provider publish the addresses of a name, addresses of one region are returned
+// for resolvers located in the region, empty region means the default one.
+type Provider interface {
+	GetRecords(name string, region string) ([]string, error)
+	SetRecords(name string, region string, ips []string) error
+}
+
+type ProviderConfig struct {
+	//cloudflare/route53
+	Type   string
+	Token  string
+	ZoneID string
+	//route53 credentials
+	AccessKey string
+	SecretKey string
+}
+
+type AddressConfig struct {
+	IP string
+	//empty means detect public ip by 'IPEcho'
+	Region string
+}
+
+type Config struct {
+	Name     string
+	Port     int
+	TTL      int
+	Provider ProviderConfig
+	Address  []AddressConfig
+	//url returns the public ip of this machine in body
+	IPEcho string
+	//external probe urls, '{addr}' replaced by 'ip:port', 2xx response means reachable
+	Probes []string
+	//republish period in seconds, 0 means publish once
+	Interval int
+}
+
+func LoadConfig(file string) (*Config, error) {
+	data, err := helper.ReadWithoutComment(file, "//")
+	if nil != err {
+		return nil, err
+	}
+	conf := &Config{}
+	err = json.Unmarshal(data, conf)
+	if nil != err {
+		return nil, err
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = 120
+	}
+	if conf.Port <= 0 {
+		conf.Port = 443
+	}
+	return conf, nil
+}
+
+func newProvider(conf *ProviderConfig, ttl int) (Provider, error) {
+	switch strings.ToLower(conf.Type) {
+	case "cloudflare":
+		return &cloudflareProvider{token: conf.Token, zoneID: conf.ZoneID, ttl: ttl}, nil
+	case "route53":
+		return newRoute53Provider(conf, ttl)
+	default:
+		return nil, fmt.Errorf("Unsupported dns provider:%s", conf.Type)
+	}
+}
+
+var probeClient = &http.Client{Timeout: 10 * time.Second}
+
+func detectPublicIP(echoURL string) (string, error) {
+	res, err := probeClient.Get(echoURL)
+	if nil != err {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if nil != err {
+		return "", err
+	}
+	ip := strings.TrimSpace(string(b))
+	if nil == net.ParseIP(ip) {
+		return "", fmt.Errorf("Invalid ip:%s returned by %s", ip, echoURL)
+	}
+	return ip, nil
+}
+
+// reachable check address by external probes, fallback to direct tcp dial if no probe configured
+func reachable(conf *Config, ip string) bool {
+	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", conf.Port))
+	if len(conf.Probes) == 0 {
+		c, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if nil != err {
+			return false
+		}
+		c.Close()
+		return true
+	}
+	for _, probe := range conf.Probes {
+		res, err := probeClient.Get(strings.Replace(probe, "{addr}", addr, -1))
+		if nil != err {
+			logger.Error("[ERROR]Probe %s failed:%v", probe, err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			logger.Notice("Address %s is NOT reachable from probe:%s", addr, probe)
+			return false
+		}
+	}
+	return true
+}
+
+// Publish make the dns records of the name exactly the reachable addresses
+func Publish(conf *Config) error {
+	provider, err := newProvider(&conf.Provider, conf.TTL)
+	if nil != err {
+		return err
+	}
+	regions := make(map[string][]string)
+	for _, addr := range conf.Address {
+		if _, exist := regions[addr.Region]; !exist {
+			regions[addr.Region] = []string{}
+		}
+		ip := addr.IP
+		if len(ip) == 0 {
+			ip, err = detectPublicIP(conf.IPEcho)
+			if nil != err {
+				logger.Error("[ERROR]Failed to detect public ip:%v", err)
+				continue
+			}
+		}
+		if !reachable(conf, ip) {
+			logger.Notice("Remove blocked address:%s for region:%s", ip, addr.Region)
+			continue
+		}
+		regions[addr.Region] = append(regions[addr.Region], ip)
+	}
+	for region, ips := range regions {
+		existing, err := provider.GetRecords(conf.Name, region)
+		if nil != err {
+			logger.Error("[ERROR]Failed to get dns records of %s(%s):%v", conf.Name, region, err)
+			continue
+		}
+		sort.Strings(existing)
+		sort.Strings(ips)
+		if strings.Join(existing, ",") == strings.Join(ips, ",") {
+			continue
+		}
+		logger.Notice("Publish dns records %s(%s) -> %v, previous:%v", conf.Name, region, ips, existing)
+		if err := provider.SetRecords(conf.Name, region, ips); nil != err {
+			logger.Error("[ERROR]Failed to publish dns records of %s(%s):%v", conf.Name, region, err)
+		}
+	}
+	return nil
+}
+
+// Run publish records once or periodically by config
+func Run(file string) error {
+	conf, err := LoadConfig(file)
+	if nil != err {
+		return err
+	}
+	for {
+		err = Publish(conf)
+		if nil != err {
+			logger.Error("[ERROR]Failed to publish server address:%v", err)
+		}
+		if conf.Interval <= 0 {
+			return err
+		}
+		time.Sleep(time.Duration(conf.Interval) * time.Second)
+	}
+}
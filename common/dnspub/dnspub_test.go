@@ -0,0 +1,150 @@
+package dnspub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnspub")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "dnspub.json")
+	ioutil.WriteFile(file, []byte(`{
+		//published name
+		"Name":"proxy.example.com",
+		"Provider":{"Type":"cloudflare"}
+	}`), 0644)
+	conf, err := LoadConfig(file)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if conf.Name != "proxy.example.com" || conf.TTL != 120 || conf.Port != 443 {
+		t.Errorf("unexpected config:%+v", conf)
+	}
+	if _, err = newProvider(&ProviderConfig{Type: "unknown"}, 120); nil == err {
+		t.Errorf("unknown provider should be rejected")
+	}
+}
+
+func TestRegionNameAndRecordType(t *testing.T) {
+	tests := []struct {
+		region, name string
+		ip, rtype    string
+	}{
+		{"", "proxy.example.com", "1.2.3.4", "A"},
+		{"JP", "jp.proxy.example.com", "2001:db8::1", "AAAA"},
+		{"us", "us.proxy.example.com", "::ffff:1.2.3.4", "AAAA"},
+	}
+	for _, tt := range tests {
+		if name := regionName("proxy.example.com", tt.region); name != tt.name {
+			t.Errorf("expect name %s of region %q, but got %s", tt.name, tt.region, name)
+		}
+		if rtype := recordType(tt.ip); rtype != tt.rtype {
+			t.Errorf("expect record type %s of %s, but got %s", tt.rtype, tt.ip, rtype)
+		}
+	}
+}
+
+// fakeCloudflare serve dns records api of one zone
+type fakeCloudflare struct {
+	records map[string]cloudflareRecord
+	nextID  int
+	mutex   sync.Mutex
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var result interface{}
+	switch r.Method {
+	case "GET":
+		rs := []cloudflareRecord{}
+		for _, rec := range f.records {
+			if rec.Name == r.URL.Query().Get("name") {
+				rs = append(rs, rec)
+			}
+		}
+		result = rs
+	case "POST":
+		var rec cloudflareRecord
+		json.NewDecoder(r.Body).Decode(&rec)
+		f.nextID++
+		rec.ID = fmt.Sprintf("r%d", f.nextID)
+		f.records[rec.ID] = rec
+	case "DELETE":
+		delete(f.records, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	}
+	b, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(&cloudflareResponse{Success: true, Errors: json.RawMessage("[]"), Result: b})
+}
+
+func (f *fakeCloudflare) contents(name string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var ips []string
+	for _, rec := range f.records {
+		if rec.Name == name {
+			ips = append(ips, rec.Type+":"+rec.Content)
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func TestPublishReachableAddressesToCloudflare(t *testing.T) {
+	api := &fakeCloudflare{records: map[string]cloudflareRecord{
+		"old": {ID: "old", Type: "A", Name: "proxy.example.com", Content: "9.9.9.9", TTL: 120},
+		"txt": {ID: "txt", Type: "TXT", Name: "proxy.example.com", Content: "keep"},
+	}}
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+	defer func(url string) { cloudflareAPI = url }(cloudflareAPI)
+	cloudflareAPI = apiServer.URL
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Query().Get("addr"), "1.2.3.5:") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer probe.Close()
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("5.6.7.8\n"))
+	}))
+	defer echo.Close()
+
+	conf := &Config{
+		Name:     "proxy.example.com",
+		Port:     443,
+		TTL:      60,
+		Provider: ProviderConfig{Type: "cloudflare", ZoneID: "zone"},
+		Address:  []AddressConfig{{IP: "1.2.3.4"}, {IP: "1.2.3.5"}, {IP: "2001:db8::1"}, {Region: "JP"}},
+		IPEcho:   echo.URL,
+		Probes:   []string{probe.URL + "/?addr={addr}"},
+	}
+	if err := Publish(conf); nil != err {
+		t.Fatal(err)
+	}
+	if ips := api.contents("proxy.example.com"); !reflect.DeepEqual(ips, []string{"A:1.2.3.4", "AAAA:2001:db8::1", "TXT:keep"}) {
+		t.Errorf("unexpected records of default region:%v", ips)
+	}
+	if ips := api.contents("jp.proxy.example.com"); !reflect.DeepEqual(ips, []string{"A:5.6.7.8"}) {
+		t.Errorf("unexpected records of region JP:%v", ips)
+	}
+	//nothing changed on republish
+	next := api.nextID
+	if err := Publish(conf); nil != err || api.nextID != next {
+		t.Errorf("unchanged records should not be republished")
+	}
+}
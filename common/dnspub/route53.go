@@ -0,0 +1,135 @@
+package dnspub
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// route53Provider publish every region as a geolocation record set
+type route53Provider struct {
+	svc    *route53.Route53
+	zoneID string
+	ttl    int
+}
+
+func newRoute53Provider(conf *ProviderConfig, ttl int) (Provider, error) {
+	awsConf := &aws.Config{Region: aws.String("us-east-1")}
+	if len(conf.AccessKey) > 0 {
+		awsConf.Credentials = credentials.NewStaticCredentials(conf.AccessKey, conf.SecretKey, "")
+	}
+	sess, err := session.NewSession(awsConf)
+	if nil != err {
+		return nil, err
+	}
+	return &route53Provider{svc: route53.New(sess), zoneID: conf.ZoneID, ttl: ttl}, nil
+}
+
+func geoLocation(region string) *route53.GeoLocation {
+	if len(region) == 0 {
+		return &route53.GeoLocation{CountryCode: aws.String("*")}
+	}
+	region = strings.ToUpper(region)
+	//continent codes are two letters too, prefix them with 'continent:' in config
+	if strings.HasPrefix(region, "CONTINENT:") {
+		return &route53.GeoLocation{ContinentCode: aws.String(region[len("CONTINENT:"):])}
+	}
+	return &route53.GeoLocation{CountryCode: aws.String(region)}
+}
+
+func setIdentifier(region string) string {
+	if len(region) == 0 {
+		return "gsnova-default"
+	}
+	return "gsnova-" + strings.ToLower(strings.Replace(region, ":", "-", -1))
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func (p *route53Provider) getRecordSet(name string, region string, rtype string) (*route53.ResourceRecordSet, error) {
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(p.zoneID),
+		StartRecordName: aws.String(fqdn(name)),
+		StartRecordType: aws.String(rtype),
+	}
+	id := setIdentifier(region)
+	var found *route53.ResourceRecordSet
+	err := p.svc.ListResourceRecordSetsPages(input, func(out *route53.ListResourceRecordSetsOutput, last bool) bool {
+		for _, rs := range out.ResourceRecordSets {
+			if aws.StringValue(rs.Name) != fqdn(name) || aws.StringValue(rs.Type) != rtype {
+				return false
+			}
+			if aws.StringValue(rs.SetIdentifier) == id {
+				found = rs
+				return false
+			}
+		}
+		return true
+	})
+	return found, err
+}
+
+func (p *route53Provider) GetRecords(name string, region string) ([]string, error) {
+	ips := []string{}
+	for _, rtype := range []string{"A", "AAAA"} {
+		rs, err := p.getRecordSet(name, region, rtype)
+		if nil != err {
+			return []string{}, err
+		}
+		if nil == rs {
+			continue
+		}
+		for _, r := range rs.ResourceRecords {
+			ips = append(ips, aws.StringValue(r.Value))
+		}
+	}
+	return ips, nil
+}
+
+// SetRecords publish ipv4 & ipv6 addresses as A & AAAA record sets, a record set without address is deleted
+func (p *route53Provider) SetRecords(name string, region string, ips []string) error {
+	ipsByType := make(map[string][]string)
+	for _, ip := range ips {
+		ipsByType[recordType(ip)] = append(ipsByType[recordType(ip)], ip)
+	}
+	var changes []*route53.Change
+	for _, rtype := range []string{"A", "AAAA"} {
+		if len(ipsByType[rtype]) == 0 {
+			rs, err := p.getRecordSet(name, region, rtype)
+			if nil != err {
+				return err
+			}
+			if nil != rs {
+				changes = append(changes, &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: rs})
+			}
+			continue
+		}
+		rs := &route53.ResourceRecordSet{
+			Name:          aws.String(fqdn(name)),
+			Type:          aws.String(rtype),
+			TTL:           aws.Int64(int64(p.ttl)),
+			SetIdentifier: aws.String(setIdentifier(region)),
+			GeoLocation:   geoLocation(region),
+		}
+		for _, ip := range ipsByType[rtype] {
+			rs.ResourceRecords = append(rs.ResourceRecords, &route53.ResourceRecord{Value: aws.String(ip)})
+		}
+		changes = append(changes, &route53.Change{Action: aws.String("UPSERT"), ResourceRecordSet: rs})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err := p.svc.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(p.zoneID),
+		ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+	})
+	return err
+}
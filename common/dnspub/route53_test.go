@@ -0,0 +1,34 @@
+package dnspub
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestRoute53RecordSetOfRegion(t *testing.T) {
+	tests := []struct {
+		region    string
+		country   string
+		continent string
+		id        string
+	}{
+		{"", "*", "", "gsnova-default"},
+		{"jp", "JP", "", "gsnova-jp"},
+		{"continent:EU", "", "EU", "gsnova-continent-eu"},
+	}
+	for _, tt := range tests {
+		geo := geoLocation(tt.region)
+		if aws.StringValue(geo.CountryCode) != tt.country || aws.StringValue(geo.ContinentCode) != tt.continent {
+			t.Errorf("unexpected geo location of region %q:%v/%v", tt.region, aws.StringValue(geo.CountryCode), aws.StringValue(geo.ContinentCode))
+		}
+		if id := setIdentifier(tt.region); id != tt.id {
+			t.Errorf("expect set identifier %s of region %q, but got %s", tt.id, tt.region, id)
+		}
+	}
+	for _, name := range []string{"proxy.example.com", "proxy.example.com."} {
+		if fqdn(name) != "proxy.example.com." {
+			t.Errorf("unexpected fqdn %s of %s", fqdn(name), name)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+const ipTrieMagic = "GIPT"
+const ipTrieVersion = 1
+const ipTrieHeadSize = 33
+const ipTrieNodeSize = 26
+
+var errInvalidIPTrieCache = errors.New("invalid ip trie cache")
+
+// ipTrieNode is a path compressed node keyed by 128 bits, ipv4 is keyed as ipv4-mapped ipv6
+type ipTrieNode struct {
+	hi, lo   uint64
+	length   uint8
+	terminal bool
+	//index+1 of children, 0 means none
+	child [2]uint32
+}
+
+// IPTrie is a path compressed binary radix trie of CIDRs stored in a flat node array,
+// prefixes covered by shorter ones are dropped.
+type IPTrie struct {
+	nodes []ipTrieNode
+	root  uint32
+	count int
+}
+
+func ipTrieKey(ip net.IP) (uint64, uint64, bool) {
+	ip16 := ip.To16()
+	if nil == ip16 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint64(ip16[0:8]), binary.BigEndian.Uint64(ip16[8:16]), true
+}
+
+func ipTrieBit(hi, lo uint64, i uint8) int {
+	if i < 64 {
+		return int(hi>>(63-i)) & 1
+	}
+	return int(lo>>(127-i)) & 1
+}
+
+func ipTrieMask(hi, lo uint64, length uint8) (uint64, uint64) {
+	switch {
+	case length == 0:
+		return 0, 0
+	case length < 64:
+		return hi & ^(^uint64(0) >> length), 0
+	case length == 64:
+		return hi, 0
+	case length < 128:
+		return hi, lo & ^(^uint64(0) >> (length - 64))
+	}
+	return hi, lo
+}
+
+func ipTrieCommonLen(ahi, alo, bhi, blo uint64, max uint8) uint8 {
+	var n int
+	if x := ahi ^ bhi; x != 0 {
+		n = bits.LeadingZeros64(x)
+	} else {
+		n = 64 + bits.LeadingZeros64(alo^blo)
+	}
+	if n > int(max) {
+		return max
+	}
+	return uint8(n)
+}
+
+func (t *IPTrie) newNode(hi, lo uint64, length uint8, terminal bool) uint32 {
+	hi, lo = ipTrieMask(hi, lo, length)
+	t.nodes = append(t.nodes, ipTrieNode{hi: hi, lo: lo, length: length, terminal: terminal})
+	return uint32(len(t.nodes))
+}
+
+// slot return the reference to the child of parent, or the root if parent is 0
+func (t *IPTrie) slot(parent uint32, b int) *uint32 {
+	if 0 == parent {
+		return &t.root
+	}
+	return &t.nodes[parent-1].child[b]
+}
+
+func (t *IPTrie) insert(hi, lo uint64, length uint8) {
+	var parent uint32
+	var side int
+	for {
+		idx := *t.slot(parent, side)
+		if 0 == idx {
+			leaf := t.newNode(hi, lo, length, true)
+			*t.slot(parent, side) = leaf
+			t.count++
+			return
+		}
+		node := t.nodes[idx-1]
+		max := node.length
+		if length < max {
+			max = length
+		}
+		common := ipTrieCommonLen(node.hi, node.lo, hi, lo, max)
+		if common < node.length {
+			//split the node at the common prefix, the node is dropped if covered by the new prefix
+			split := t.newNode(hi, lo, common, common == length)
+			if common < length {
+				leaf := t.newNode(hi, lo, length, true)
+				t.nodes[split-1].child[ipTrieBit(node.hi, node.lo, common)] = idx
+				t.nodes[split-1].child[ipTrieBit(hi, lo, common)] = leaf
+			}
+			*t.slot(parent, side) = split
+			t.count++
+			return
+		}
+		if node.terminal {
+			//covered by a shorter prefix
+			return
+		}
+		if node.length == length {
+			t.nodes[idx-1].terminal = true
+			t.nodes[idx-1].child = [2]uint32{}
+			t.count++
+			return
+		}
+		parent, side = idx, ipTrieBit(hi, lo, node.length)
+	}
+}
+
+// NewIPTrie build the trie from CIDRs
+func NewIPTrie(nets []*net.IPNet) *IPTrie {
+	type prefix struct {
+		hi, lo uint64
+		length uint8
+	}
+	prefixes := make([]prefix, 0, len(nets))
+	for _, ipnet := range nets {
+		hi, lo, ok := ipTrieKey(ipnet.IP)
+		if !ok {
+			continue
+		}
+		ones, size := ipnet.Mask.Size()
+		if size == 32 {
+			ones += 96
+		}
+		prefixes = append(prefixes, prefix{hi, lo, uint8(ones)})
+	}
+	//insert shorter prefixes first so covered ones are never stored
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i].length < prefixes[j].length
+	})
+	t := &IPTrie{nodes: make([]ipTrieNode, 0, 2*len(prefixes))}
+	for _, p := range prefixes {
+		t.insert(p.hi, p.lo, p.length)
+	}
+	t.nodes = append([]ipTrieNode(nil), t.nodes...)
+	return t
+}
+
+// Contains return true if the ip is in any CIDR of the trie
+func (t *IPTrie) Contains(ip net.IP) bool {
+	if nil == t {
+		return false
+	}
+	hi, lo, ok := ipTrieKey(ip)
+	if !ok {
+		return false
+	}
+	idx := t.root
+	for 0 != idx {
+		node := &t.nodes[idx-1]
+		if ipTrieCommonLen(node.hi, node.lo, hi, lo, node.length) < node.length {
+			return false
+		}
+		if node.terminal {
+			return true
+		}
+		idx = node.child[ipTrieBit(hi, lo, node.length)]
+	}
+	return false
+}
+
+// Len return the number of stored CIDRs
+func (t *IPTrie) Len() int {
+	return t.count
+}
+
+// MemSize return the bytes used by trie nodes
+func (t *IPTrie) MemSize() int {
+	return cap(t.nodes) * 32
+}
+
+// writeTo serialize the trie with the fingerprint of its source
+func (t *IPTrie) writeTo(w io.Writer, fingerprint [2]int64) error {
+	bw := bufio.NewWriter(w)
+	head := make([]byte, ipTrieHeadSize)
+	copy(head, ipTrieMagic)
+	head[4] = ipTrieVersion
+	binary.BigEndian.PutUint64(head[5:], uint64(fingerprint[0]))
+	binary.BigEndian.PutUint64(head[13:], uint64(fingerprint[1]))
+	binary.BigEndian.PutUint32(head[21:], uint32(t.count))
+	binary.BigEndian.PutUint32(head[25:], uint32(len(t.nodes)))
+	binary.BigEndian.PutUint32(head[29:], t.root)
+	bw.Write(head)
+	b := make([]byte, ipTrieNodeSize)
+	for i := range t.nodes {
+		node := &t.nodes[i]
+		binary.BigEndian.PutUint64(b[0:], node.hi)
+		binary.BigEndian.PutUint64(b[8:], node.lo)
+		b[16] = node.length
+		b[17] = 0
+		if node.terminal {
+			b[17] = 1
+		}
+		binary.BigEndian.PutUint32(b[18:], node.child[0])
+		binary.BigEndian.PutUint32(b[22:], node.child[1])
+		bw.Write(b)
+	}
+	return bw.Flush()
+}
+
+func readIPTrie(r io.Reader, fingerprint [2]int64) (*IPTrie, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, ipTrieHeadSize)
+	if _, err := io.ReadFull(br, head); nil != err {
+		return nil, err
+	}
+	if string(head[0:4]) != ipTrieMagic || head[4] != ipTrieVersion ||
+		int64(binary.BigEndian.Uint64(head[5:])) != fingerprint[0] || int64(binary.BigEndian.Uint64(head[13:])) != fingerprint[1] {
+		return nil, errInvalidIPTrieCache
+	}
+	t := &IPTrie{count: int(binary.BigEndian.Uint32(head[21:])), root: binary.BigEndian.Uint32(head[29:])}
+	n := binary.BigEndian.Uint32(head[25:])
+	if t.root > n || uint32(t.count) > n || (0 == t.root) != (0 == n) {
+		return nil, errInvalidIPTrieCache
+	}
+	//nodes are appended while reading, a corrupt count fails at EOF instead of allocating all at once
+	prealloc := n
+	if prealloc > 65536 {
+		prealloc = 65536
+	}
+	t.nodes = make([]ipTrieNode, 0, prealloc)
+	b := make([]byte, ipTrieNodeSize)
+	for i := uint32(0); i < n; i++ {
+		if _, err := io.ReadFull(br, b); nil != err {
+			return nil, err
+		}
+		t.nodes = append(t.nodes, ipTrieNode{})
+		node := &t.nodes[i]
+		node.hi = binary.BigEndian.Uint64(b[0:])
+		node.lo = binary.BigEndian.Uint64(b[8:])
+		node.length = b[16]
+		node.terminal = b[17] == 1
+		node.child[0] = binary.BigEndian.Uint32(b[18:])
+		node.child[1] = binary.BigEndian.Uint32(b[22:])
+		if node.child[0] > n || node.child[1] > n || node.length > 128 {
+			return nil, errInvalidIPTrieCache
+		}
+	}
+	//prefix length must grow along every path, so that cyclic links are rejected & lookups terminate
+	for i := range t.nodes {
+		node := &t.nodes[i]
+		for _, c := range node.child {
+			if 0 != c && t.nodes[c-1].length <= node.length {
+				return nil, errInvalidIPTrieCache
+			}
+		}
+	}
+	return t, nil
+}
+
+// ReadCIDRFile parse CIDRs or IPs per line, lines start with '#' are comments
+func ReadCIDRFile(file string) ([]*net.IPNet, error) {
+	f, err := os.Open(file)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+	var nets []*net.IPNet
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if len(s) == 0 || strings.HasPrefix(s, "#") {
+			continue
+		}
+		ipnet := parseIPNet(s)
+		if nil == ipnet {
+			return nil, fmt.Errorf("invalid CIDR:%s at line:%d of %s", s, line, file)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, scanner.Err()
+}
+
+// LoadIPTrieFile load the CIDR file into trie, the serialized trie is cached as '<file>.trie'
+// and reused until the file changed.
+func LoadIPTrieFile(file string) (*IPTrie, error) {
+	st, err := os.Stat(file)
+	if nil != err {
+		return nil, err
+	}
+	fingerprint := [2]int64{st.Size(), st.ModTime().UnixNano()}
+	cacheFile := file + ".trie"
+	if f, err := os.Open(cacheFile); nil == err {
+		t, err := readIPTrie(f, fingerprint)
+		f.Close()
+		if nil == err {
+			return t, nil
+		}
+		logger.Debug("Ignore ip trie cache:%s for reason:%v", cacheFile, err)
+	}
+	nets, err := ReadCIDRFile(file)
+	if nil != err {
+		return nil, err
+	}
+	t := NewIPTrie(nets)
+	tmp := cacheFile + ".tmp"
+	if f, err := os.Create(tmp); nil == err {
+		err = t.writeTo(f, fingerprint)
+		f.Close()
+		if nil == err {
+			err = os.Rename(tmp, cacheFile)
+		}
+		if nil != err {
+			os.Remove(tmp)
+			logger.Debug("Failed to write ip trie cache:%s for reason:%v", cacheFile, err)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,223 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func randomIPNets(r *rand.Rand, n int) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, n)
+	for i := 0; i < n; i++ {
+		if r.Intn(4) == 0 {
+			ip := make(net.IP, net.IPv6len)
+			r.Read(ip)
+			ones := 16 + r.Intn(113)
+			nets = append(nets, &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 128)), Mask: net.CIDRMask(ones, 128)})
+		} else {
+			ip := net.IPv4(byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))).To4()
+			ones := 8 + r.Intn(25)
+			nets = append(nets, &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)})
+		}
+	}
+	return nets
+}
+
+func linearContains(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIPTrieContains(t *testing.T) {
+	nets := []*net.IPNet{
+		parseIPNet("10.0.0.0/8"),
+		parseIPNet("10.1.0.0/16"),
+		parseIPNet("192.168.1.0/24"),
+		parseIPNet("192.168.2.1"),
+		parseIPNet("1.0.0.0/32"),
+		parseIPNet("2001:db8::/32"),
+		parseIPNet("::1"),
+	}
+	trie := NewIPTrie(nets)
+	//10.1.0.0/16 is covered by 10.0.0.0/8
+	if trie.Len() != len(nets)-1 {
+		t.Errorf("expected %d prefixes, got %d", len(nets)-1, trie.Len())
+	}
+	for _, c := range []struct {
+		ip       string
+		contains bool
+	}{
+		{"10.255.1.1", true},
+		{"11.0.0.1", false},
+		{"192.168.1.255", true},
+		{"192.168.2.1", true},
+		{"192.168.2.2", false},
+		{"1.0.0.0", true},
+		{"1.0.0.1", false},
+		{"0.0.0.0", false},
+		{"2001:db8:1::1", true},
+		{"2001:db9::1", false},
+		{"::1", true},
+		{"::2", false},
+	} {
+		if trie.Contains(net.ParseIP(c.ip)) != c.contains {
+			t.Errorf("Contains(%s) should be %v", c.ip, c.contains)
+		}
+	}
+	var empty *IPTrie
+	if empty.Contains(net.ParseIP("10.0.0.1")) || NewIPTrie(nil).Contains(net.ParseIP("10.0.0.1")) {
+		t.Errorf("empty trie should contain nothing")
+	}
+}
+
+func TestIPTrieMatchLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	nets := randomIPNets(r, 2000)
+	trie := NewIPTrie(nets)
+	for i := 0; i < 20000; i++ {
+		var ip net.IP
+		if i%2 == 0 {
+			//ips inside the nets with random host bits
+			ipnet := nets[r.Intn(len(nets))]
+			ip = make(net.IP, len(ipnet.IP))
+			r.Read(ip)
+			for j := range ip {
+				ip[j] = ipnet.IP[j] | (ip[j] &^ ipnet.Mask[j])
+			}
+		} else if i%4 == 1 {
+			ip = net.IPv4(byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)))
+		} else {
+			ip = make(net.IP, net.IPv6len)
+			r.Read(ip)
+		}
+		if trie.Contains(ip) != linearContains(nets, ip) {
+			t.Fatalf("Contains(%s) mismatch with linear scan", ip)
+		}
+	}
+}
+
+func TestIPTrieRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	nets := randomIPNets(r, 1000)
+	trie := NewIPTrie(nets)
+	fingerprint := [2]int64{123, 456}
+	var buf bytes.Buffer
+	if err := trie.writeTo(&buf, fingerprint); nil != err {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	loaded, err := readIPTrie(bytes.NewReader(data), fingerprint)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if loaded.Len() != trie.Len() || len(loaded.nodes) != len(trie.nodes) || loaded.root != trie.root {
+		t.Fatalf("loaded trie mismatch, %d/%d prefixes", loaded.Len(), trie.Len())
+	}
+	for i := range trie.nodes {
+		if loaded.nodes[i] != trie.nodes[i] {
+			t.Fatalf("node %d mismatch", i)
+		}
+	}
+	if _, err = readIPTrie(bytes.NewReader(data), [2]int64{123, 457}); err != errInvalidIPTrieCache {
+		t.Errorf("trie with changed fingerprint should be rejected, got %v", err)
+	}
+	if _, err = readIPTrie(bytes.NewReader(data[:len(data)-1]), fingerprint); nil == err {
+		t.Errorf("truncated trie should be rejected")
+	}
+
+	var empty bytes.Buffer
+	NewIPTrie(nil).writeTo(&empty, fingerprint)
+	if loaded, err = readIPTrie(&empty, fingerprint); nil != err || loaded.Contains(net.ParseIP("1.1.1.1")) {
+		t.Errorf("empty trie round trip failed:%v", err)
+	}
+}
+
+func TestReadIPTrieRejectCorrupt(t *testing.T) {
+	fingerprint := [2]int64{1, 2}
+	trie := NewIPTrie([]*net.IPNet{parseIPNet("10.0.0.0/8"), parseIPNet("11.0.0.0/8"), parseIPNet("12.0.0.0/8")})
+	var buf bytes.Buffer
+	trie.writeTo(&buf, fingerprint)
+	valid := buf.Bytes()
+	nodeOffset := func(idx uint32) int {
+		return ipTrieHeadSize + int(idx-1)*ipTrieNodeSize
+	}
+	corrupt := func(f func(b []byte)) []byte {
+		b := append([]byte(nil), valid...)
+		f(b)
+		return b
+	}
+	root := binary.BigEndian.Uint32(valid[29:])
+	cases := map[string][]byte{
+		"root out of range": corrupt(func(b []byte) {
+			binary.BigEndian.PutUint32(b[29:], 100)
+		}),
+		"child out of range": corrupt(func(b []byte) {
+			binary.BigEndian.PutUint32(b[nodeOffset(root)+18:], 100)
+		}),
+		"cyclic child": corrupt(func(b []byte) {
+			binary.BigEndian.PutUint32(b[nodeOffset(root)+18:], root)
+		}),
+		"prefix too long": corrupt(func(b []byte) {
+			b[nodeOffset(root)+16] = 129
+		}),
+		"count too large": corrupt(func(b []byte) {
+			binary.BigEndian.PutUint32(b[21:], 100)
+		}),
+		"huge node count": corrupt(func(b []byte) {
+			binary.BigEndian.PutUint32(b[25:], 0xFFFFFFFF)
+		}),
+	}
+	for name, data := range cases {
+		if _, err := readIPTrie(bytes.NewReader(data), fingerprint); nil == err {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestLoadIPTrieFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iptrie")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cn.txt")
+	ioutil.WriteFile(file, []byte("# comment\n1.0.1.0/24\n\n1.0.2.0/23\n2001:db8::/32\n"), 0660)
+	for i := 0; i < 2; i++ {
+		trie, err := LoadIPTrieFile(file)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if trie.Len() != 3 || !trie.Contains(net.ParseIP("1.0.3.1")) || trie.Contains(net.ParseIP("1.0.4.1")) {
+			t.Fatalf("load %d: unexpected trie with %d prefixes", i, trie.Len())
+		}
+		if _, err = os.Stat(file + ".trie"); nil != err {
+			t.Fatalf("cache not written:%v", err)
+		}
+	}
+	ioutil.WriteFile(file, []byte("1.0.1.0/24\nbad\n"), 0660)
+	if _, err = LoadIPTrieFile(file); nil == err {
+		t.Errorf("invalid CIDR file should fail")
+	}
+}
+
+func BenchmarkIPTrieContains(b *testing.B) {
+	r := rand.New(rand.NewSource(3))
+	trie := NewIPTrie(randomIPNets(r, 10000))
+	ips := make([]net.IP, 1024)
+	for i := range ips {
+		ips[i] = net.IPv4(byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Contains(ips[i%len(ips)])
+	}
+}
@@ -0,0 +1,169 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSONSchema generate the JSON schema(draft-07) of the config struct by reflection, fields are named as encoding/json does
+func JSONSchema(v interface{}, title string) map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(v), make(map[reflect.Type]bool))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = title
+	return schema
+}
+
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name := strings.Split(tag, ",")[0]
+	if len(name) == 0 {
+		name = f.Name
+	}
+	return name, true
+}
+
+func structProperties(t reflect.Type, props map[string]interface{}, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && len(f.Tag.Get("json")) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structProperties(ft, props, visiting)
+				continue
+			}
+		}
+		if len(f.PkgPath) > 0 {
+			//unexported
+			continue
+		}
+		name, ok := jsonFieldName(f)
+		if !ok {
+			continue
+		}
+		props[name] = typeSchema(f.Type, visiting)
+	}
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), visiting)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		props := make(map[string]interface{})
+		structProperties(t, props, visiting)
+		return map[string]interface{}{"type": "object", "properties": props, "additionalProperties": false}
+	}
+	return map[string]interface{}{}
+}
+
+// ValidateJSON check the json data against the schema generated by JSONSchema, property names are matched
+// case insensitive like encoding/json.
+func ValidateJSON(schema map[string]interface{}, data []byte) []string {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); nil != err {
+		return []string{err.Error()}
+	}
+	var errs []string
+	validateValue(schema, v, "$", &errs)
+	return errs
+}
+
+func validateValue(schema map[string]interface{}, v interface{}, path string, errs *[]string) {
+	typ, _ := schema["type"].(string)
+	if nil == v || len(typ) == 0 {
+		return
+	}
+	mismatch := func() {
+		*errs = append(*errs, fmt.Sprintf("%s: expect %s but got %v", path, typ, reflect.TypeOf(v)))
+	}
+	switch typ {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			mismatch()
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			mismatch()
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			mismatch()
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			mismatch()
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			validateValue(itemSchema, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		props, _ := schema["properties"].(map[string]interface{})
+		for _, k := range keys {
+			if nil == props {
+				if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+					validateValue(additional, obj[k], path+"."+k, errs)
+				}
+				continue
+			}
+			var propSchema map[string]interface{}
+			for name, s := range props {
+				if strings.EqualFold(name, k) {
+					propSchema, _ = s.(map[string]interface{})
+					break
+				}
+			}
+			if nil == propSchema {
+				*errs = append(*errs, fmt.Sprintf("%s: unknown property '%s'", path, k))
+				continue
+			}
+			validateValue(propSchema, obj[k], path+"."+k, errs)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+)
+
+type schemaInner struct {
+	Port int
+}
+
+type schemaEmbedded struct {
+	Token string
+}
+
+type schemaTestConfig struct {
+	schemaEmbedded
+	Name     string
+	Enable   bool
+	Ratio    float64
+	Tags     []string
+	Key      []byte
+	Inner    schemaInner
+	InnerPtr *schemaInner
+	Limits   map[string]int
+	Alias    string `json:"alias_name,omitempty"`
+	Skipped  string `json:"-"`
+	Next     *schemaTestConfig
+	hidden   int
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema(schemaTestConfig{}, "test")
+	if schema["title"] != "test" || schema["$schema"] != "http://json-schema.org/draft-07/schema#" || schema["additionalProperties"] != false {
+		t.Fatalf("unexpected schema header:%v", schema)
+	}
+	props := schema["properties"].(map[string]interface{})
+	tests := map[string]string{
+		"Token":      "string",
+		"Name":       "string",
+		"Enable":     "boolean",
+		"Ratio":      "number",
+		"Tags":       "array",
+		"Key":        "string",
+		"Inner":      "object",
+		"InnerPtr":   "object",
+		"Limits":     "object",
+		"alias_name": "string",
+		"Next":       "object",
+	}
+	for name, typ := range tests {
+		p, exist := props[name].(map[string]interface{})
+		if !exist || p["type"] != typ {
+			t.Errorf("expect property %s of type %s, but got %v", name, typ, props[name])
+		}
+	}
+	for _, name := range []string{"Skipped", "hidden", "schemaEmbedded", "Alias"} {
+		if _, exist := props[name]; exist {
+			t.Errorf("unexpected property %s", name)
+		}
+	}
+	if !reflect.DeepEqual(props["Tags"], map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}) {
+		t.Errorf("unexpected array schema:%v", props["Tags"])
+	}
+	//recursive type is cut as a plain object
+	if next := props["Next"].(map[string]interface{}); nil != next["properties"] {
+		t.Errorf("expect recursive struct cut, but got %v", next)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	schema := JSONSchema(schemaTestConfig{}, "test")
+	tests := []struct {
+		data string
+		errs []string
+	}{
+		{`{"name":"a","Enable":true,"Ratio":1.5,"Tags":["x"],"Inner":{"Port":80},"Limits":{"a":1},"Alias_Name":"b","Token":"t"}`, nil},
+		{`{"Name":null,"InnerPtr":null}`, nil},
+		{`{"Name":1,"Enable":"yes"}`, []string{"$.Enable: expect boolean but got string", "$.Name: expect string but got float64"}},
+		{`{"Inner":{"Port":1.5}}`, []string{"$.Inner.Port: expect integer but got float64"}},
+		{`{"Tags":["a",2]}`, []string{"$.Tags[1]: expect string but got float64"}},
+		{`{"Limits":{"a":"b"}}`, []string{"$.Limits.a: expect integer but got string"}},
+		{`{"Unknown":1,"Inner":{"Host":"x"}}`, []string{"$.Inner: unknown property 'Host'", "$: unknown property 'Unknown'"}},
+		{`{"Skipped":"x"}`, []string{"$: unknown property 'Skipped'"}},
+		{`[]`, []string{"$: expect object but got []interface {}"}},
+	}
+	for _, tt := range tests {
+		if errs := ValidateJSON(schema, []byte(tt.data)); !reflect.DeepEqual(errs, tt.errs) {
+			t.Errorf("%s expect errors %q, but got %q", tt.data, tt.errs, errs)
+		}
+	}
+	if errs := ValidateJSON(schema, []byte(`{`)); len(errs) != 1 {
+		t.Errorf("expect parse error, but got %q", errs)
+	}
+}
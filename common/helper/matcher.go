@@ -0,0 +1,232 @@
+package helper
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+const (
+	regexPatternPrefix = "regex:"
+	ipSetPatternPrefix = "ipset:"
+)
+
+var namedIPSets atomic.Value
+
+// IPSets is an immutable table of named ip sets referenced by 'ipset:<name>' patterns
+type IPSets map[string]*IPTrie
+
+// Contains return true if the ip is in the named ip set
+func (sets IPSets) Contains(name string, ip net.IP) bool {
+	return sets[name].Contains(ip)
+}
+
+// LoadIPSets build ip sets from entries of CIDRs, IPs or '@<file>' of CIDR list, a set of only one file
+// is loaded with the cached trie of the file. Sets failed to load are taken from 'old' if exist, the last
+// error is returned.
+func LoadIPSets(sets map[string][]string, old IPSets) (IPSets, error) {
+	table := make(IPSets)
+	var lastErr error
+	for name, entries := range sets {
+		if len(entries) == 1 && strings.HasPrefix(entries[0], "@") {
+			trie, err := LoadIPTrieFile(entries[0][1:])
+			if nil != err {
+				logger.Error("[ERROR]Failed to load ip set:%s with reason:%v", name, err)
+				lastErr = fmt.Errorf("ip set %s:%v", name, err)
+				if prev, exist := old[name]; exist {
+					table[name] = prev
+				}
+				continue
+			}
+			table[name] = trie
+			continue
+		}
+		var nets []*net.IPNet
+		failed := false
+		for _, entry := range entries {
+			if strings.HasPrefix(entry, "@") {
+				fileNets, err := ReadCIDRFile(entry[1:])
+				if nil != err {
+					logger.Error("[ERROR]Failed to load ip set:%s with reason:%v", name, err)
+					lastErr = fmt.Errorf("ip set %s:%v", name, err)
+					failed = true
+				}
+				nets = append(nets, fileNets...)
+			} else if ipnet := parseIPNet(entry); nil != ipnet {
+				nets = append(nets, ipnet)
+			} else {
+				logger.Error("[ERROR]Invalid entry:%s in ip set:%s", entry, name)
+			}
+		}
+		if prev, exist := old[name]; failed && exist {
+			table[name] = prev
+			continue
+		}
+		table[name] = NewIPTrie(nets)
+	}
+	return table, lastErr
+}
+
+// SetIPSets load & replace the ip sets referenced by 'ipset:<name>' patterns
+func SetIPSets(sets map[string][]string) {
+	table, _ := LoadIPSets(sets, nil)
+	StoreIPSets(table)
+}
+
+// StoreIPSets replace the ip sets referenced by 'ipset:<name>' patterns, matches in progress keep the old one
+func StoreIPSets(sets IPSets) {
+	namedIPSets.Store(sets)
+}
+
+// CurrentIPSets return the ip sets stored by StoreIPSets
+func CurrentIPSets() IPSets {
+	sets, _ := namedIPSets.Load().(IPSets)
+	return sets
+}
+
+// InIPSet return true if the ip is in the named ip set
+func InIPSet(name string, ip net.IP) bool {
+	return CurrentIPSets().Contains(name, ip)
+}
+
+func parseIPNet(s string) *net.IPNet {
+	if _, ipnet, err := net.ParseCIDR(s); nil == err {
+		return ipnet
+	}
+	ip := net.ParseIP(s)
+	if nil == ip {
+		return nil
+	}
+	if ip4 := ip.To4(); nil != ip4 {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// HostMatcher is precompiled from host patterns. '*' matches all, 'www.example.com' matches exactly,
+// '*.example.com' matches subdomains while '.example.com' matches the domain and subdomains,
+// 'regex:<expr>' matches by regular expression, '10.0.0.0/8' matches ips in the CIDR and 'ipset:<name>'
+// matches ips in the named ip set, others are matched as glob by filepath.Match.
+// Patterns except regex are case insensitive, the port of 'host:port' is ignored except for globs & regex.
+type HostMatcher struct {
+	all        bool
+	exact      map[string]bool
+	subdomains map[string]bool
+	domains    map[string]bool
+	nets       []*net.IPNet
+	ipsets     []string
+	globs      []string
+	regexps    []*regexp.Regexp
+}
+
+// NewHostMatcher compile the patterns, invalid patterns are logged & ignored
+func NewHostMatcher(patterns []string) *HostMatcher {
+	m := &HostMatcher{
+		exact:      make(map[string]bool),
+		subdomains: make(map[string]bool),
+		domains:    make(map[string]bool),
+	}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		switch {
+		case len(pattern) == 0:
+		case pattern == "*":
+			m.all = true
+		case strings.HasPrefix(pattern, regexPatternPrefix):
+			re, err := regexp.Compile(pattern[len(regexPatternPrefix):])
+			if nil != err {
+				logger.Error("[ERROR]Invalid pattern:%s with reason:%v", pattern, err)
+				continue
+			}
+			m.regexps = append(m.regexps, re)
+		case strings.HasPrefix(pattern, ipSetPatternPrefix):
+			m.ipsets = append(m.ipsets, pattern[len(ipSetPatternPrefix):])
+		case strings.Contains(pattern, "/"):
+			if _, ipnet, err := net.ParseCIDR(pattern); nil == err {
+				m.nets = append(m.nets, ipnet)
+			} else {
+				logger.Error("[ERROR]Invalid pattern:%s with reason:%v", pattern, err)
+			}
+		default:
+			pattern = strings.ToLower(pattern)
+			if strings.HasPrefix(pattern, "*.") && !strings.ContainsAny(pattern[2:], "*?[\\") {
+				m.subdomains[pattern[2:]] = true
+			} else if strings.HasPrefix(pattern, ".") && !strings.ContainsAny(pattern[1:], "*?[\\") {
+				m.domains[pattern[1:]] = true
+			} else if !strings.ContainsAny(pattern, "*?[\\") {
+				m.exact[pattern] = true
+			} else if _, err := filepath.Match(pattern, ""); nil != err {
+				logger.Error("[ERROR]Invalid pattern:%s with reason:%v", pattern, err)
+			} else {
+				m.globs = append(m.globs, pattern)
+			}
+		}
+	}
+	return m
+}
+
+// Empty return true if no valid pattern compiled
+func (m *HostMatcher) Empty() bool {
+	return !m.all && len(m.exact) == 0 && len(m.subdomains) == 0 && len(m.domains) == 0 &&
+		len(m.nets) == 0 && len(m.ipsets) == 0 && len(m.globs) == 0 && len(m.regexps) == 0
+}
+
+// Match the host or 'host:port'
+func (m *HostMatcher) Match(s string) bool {
+	return m.MatchIn(s, CurrentIPSets())
+}
+
+// MatchIn match the host or 'host:port' with 'ipset:<name>' patterns looked up in 'sets'
+func (m *HostMatcher) MatchIn(s string, sets IPSets) bool {
+	if m.all {
+		return true
+	}
+	full := strings.ToLower(s)
+	host := full
+	if h, _, err := net.SplitHostPort(full); nil == err {
+		host = h
+	}
+	if m.exact[host] || m.exact[full] || m.domains[host] {
+		return true
+	}
+	if ip := net.ParseIP(host); nil != ip {
+		for _, ipnet := range m.nets {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+		for _, name := range m.ipsets {
+			if sets.Contains(name, ip) {
+				return true
+			}
+		}
+	} else if len(m.subdomains) > 0 || len(m.domains) > 0 {
+		for i := 0; i < len(host); i++ {
+			if host[i] == '.' {
+				suffix := host[i+1:]
+				if m.subdomains[suffix] || m.domains[suffix] {
+					return true
+				}
+			}
+		}
+	}
+	for _, pattern := range m.globs {
+		if matched, _ := filepath.Match(pattern, full); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, host); matched && len(host) != len(full) {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(s) || (len(host) != len(full) && re.MatchString(host)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHostMatcher(t *testing.T) {
+	SetIPSets(map[string][]string{"lan": {"192.168.0.0/16", "1.2.3.4"}})
+	defer SetIPSets(nil)
+	m := NewHostMatcher([]string{
+		"*.google.com",
+		".example.org",
+		"www.x.com",
+		"regex:^ad[0-9]+\\.",
+		"10.0.0.0/8",
+		"ipset:lan",
+		"*yt*",
+		"api.?.net",
+		"[bad",
+		"regex:(",
+		"300.0.0.0/8",
+	})
+	if m.Empty() {
+		t.Fatalf("matcher should not be empty")
+	}
+	for _, c := range []struct {
+		host    string
+		matched bool
+	}{
+		{"www.google.com", true},
+		{"a.b.GOOGLE.com:443", true},
+		{"google.com", false},
+		{"fakegoogle.com", false},
+		{"example.org", true},
+		{"x.example.org", true},
+		{"example.org.cn", false},
+		{"www.x.com", true},
+		{"www.x.com:80", true},
+		{"x.com", false},
+		{"ad12.foo", true},
+		{"bad12.foo", false},
+		{"10.1.1.1", true},
+		{"10.1.1.1:53", true},
+		{"11.1.1.1", false},
+		{"192.168.3.3", true},
+		{"1.2.3.4", true},
+		{"1.2.3.5", false},
+		{"myyt.com", true},
+		{"api.a.net", true},
+		{"api.ab.net", false},
+		{"nope.com", false},
+	} {
+		if m.Match(c.host) != c.matched {
+			t.Errorf("Match(%s) should be %v", c.host, c.matched)
+		}
+	}
+	if !NewHostMatcher([]string{"*"}).Match("any.host") {
+		t.Errorf("'*' should match all")
+	}
+	if !NewHostMatcher([]string{"[bad", "regex:("}).Empty() {
+		t.Errorf("matcher of invalid patterns should be empty")
+	}
+}
+
+func TestInIPSet(t *testing.T) {
+	SetIPSets(map[string][]string{"a": {"10.0.0.0/8", "bad"}, "b": {"2001:db8::/32"}})
+	defer SetIPSets(nil)
+	for _, c := range []struct {
+		set     string
+		ip      string
+		matched bool
+	}{
+		{"a", "10.1.2.3", true},
+		{"a", "11.1.2.3", false},
+		{"b", "2001:db8::1", true},
+		{"b", "10.1.2.3", false},
+		{"none", "10.1.2.3", false},
+	} {
+		if InIPSet(c.set, parseIPNet(c.ip).IP) != c.matched {
+			t.Errorf("InIPSet(%s, %s) should be %v", c.set, c.ip, c.matched)
+		}
+	}
+}
+
+func BenchmarkHostMatcher(b *testing.B) {
+	patterns := make([]string, 0, 30000)
+	for i := 0; i < 10000; i++ {
+		patterns = append(patterns, fmt.Sprintf(".domain%d.com", i), fmt.Sprintf("*.sub%d.net", i), fmt.Sprintf("host%d.org", i))
+	}
+	m := NewHostMatcher(patterns)
+	hosts := []string{"a.b.domain5000.com", "x.sub9999.net:443", "host1234.org", "not.matched.example.com"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(hosts[i%len(hosts)])
+	}
+}
@@ -1,6 +1,8 @@
 package helper
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
 	"time"
 )
@@ -23,3 +25,12 @@ func RandAsciiString(n int) string {
 	}
 	return string(b)
 }
+
+// RandHexString return hex string of n random bytes from crypto/rand
+func RandHexString(n int) string {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); nil != err {
+		return RandAsciiString(2 * n)
+	}
+	return hex.EncodeToString(b)
+}
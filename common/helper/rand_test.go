@@ -0,0 +1,20 @@
+package helper
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestRandHexString(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		s := RandHexString(16)
+		if b, err := hex.DecodeString(s); nil != err || len(b) != 16 {
+			t.Fatalf("expect hex string of 16 bytes, but got %q", s)
+		}
+		if seen[s] {
+			t.Fatalf("duplicate random hex string %s", s)
+		}
+		seen[s] = true
+	}
+}
@@ -10,6 +10,40 @@ import (
 	"strings"
 )
 
+// IsUpgradeRequest return true if the request asks to switch protocols like websocket by 'Connection: Upgrade'
+func IsUpgradeRequest(req *http.Request) bool {
+	if nil == req || len(req.Header.Get("Upgrade")) == 0 {
+		return false
+	}
+	for _, v := range req.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsDomainName return true if s looks like a domain name, but not an ip, used to check names sniffed from clients
+func IsDomainName(s string) bool {
+	if len(s) == 0 || len(s) > 253 || s[0] == '.' || strings.Contains(s, "..") {
+		return false
+	}
+	letter := false
+	for _, c := range s {
+		switch {
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_':
+			letter = true
+		case (c >= '0' && c <= '9') || c == '-' || c == '.':
+		default:
+			return false
+		}
+	}
+	//all digits & dots like '1.2.3.4' is an ip
+	return letter
+}
+
 func GetRequestURLString(req *http.Request) string {
 	if nil == req {
 		return ""
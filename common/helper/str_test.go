@@ -0,0 +1,58 @@
+package helper
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIsUpgradeRequest(t *testing.T) {
+	cases := []struct {
+		req     string
+		upgrade bool
+	}{
+		{"GET ws://example.com/chat HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", true},
+		{"GET /chat HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n\r\n", true},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: upgrade\r\nUpgrade: h2c\r\n\r\n", true},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\n\r\n", false},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\n\r\n", false},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive\r\n\r\n", false},
+	}
+	for _, c := range cases {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(c.req)))
+		if nil != err {
+			t.Fatalf("invalid request:%q %v", c.req, err)
+		}
+		if IsUpgradeRequest(req) != c.upgrade {
+			t.Errorf("IsUpgradeRequest(%q) should be %v", c.req, c.upgrade)
+		}
+	}
+	if IsUpgradeRequest(nil) {
+		t.Errorf("nil request is not upgrade")
+	}
+}
+
+func TestIsDomainName(t *testing.T) {
+	cases := map[string]bool{
+		"example.com":            true,
+		"WWW.Example.COM.":       true,
+		"_dmarc.example.com":     true,
+		"localhost":              true,
+		"a-b.c1.net":             true,
+		"":                       false,
+		"1.2.3.4":                false,
+		"::1":                    false,
+		"example.com:443":        false,
+		".example.com":           false,
+		"a..com":                 false,
+		"exa mple.com":           false,
+		"example.com/x":          false,
+		strings.Repeat("a", 254): false,
+	}
+	for s, valid := range cases {
+		if IsDomainName(s) != valid {
+			t.Errorf("IsDomainName(%q) should be %v", s, valid)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const totpPeriod = 30
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	secret = strings.TrimRight(secret, "=")
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+func totpCodeAt(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+// TOTPCode generate the RFC 6238 code by the base32 secret
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if nil != err {
+		return "", err
+	}
+	return totpCodeAt(key, uint64(t.Unix()/totpPeriod)), nil
+}
+
+// VerifyTOTP verify the code with 'skew' periods tolerance before/after t
+func VerifyTOTP(secret string, code string, t time.Time, skew int) bool {
+	key, err := decodeTOTPSecret(secret)
+	if nil != err || len(code) != 6 {
+		return false
+	}
+	counter := t.Unix() / totpPeriod
+	for i := -skew; i <= skew; i++ {
+		if hmac.Equal([]byte(totpCodeAt(key, uint64(counter+int64(i)))), []byte(code)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+// base32 of the RFC 6238 sha1 test secret "12345678901234567890"
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	//last 6 digits of RFC 6238 sha1 test vectors
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(testTOTPSecret, time.Unix(tt.unix, 0))
+		if nil != err || code != tt.code {
+			t.Errorf("expect code %s at %d, but got %s %v", tt.code, tt.unix, code, err)
+		}
+	}
+	if _, err := TOTPCode("not base32!", time.Now()); nil == err {
+		t.Errorf("invalid secret should be rejected")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(testTOTPSecret, now)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		skew   int
+		valid  bool
+	}{
+		{"current", testTOTPSecret, code, now, 0, true},
+		{"lower case secret with spaces", "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", code, now, 0, true},
+		{"previous period in skew", testTOTPSecret, code, now.Add(30 * time.Second), 1, true},
+		{"previous period without skew", testTOTPSecret, code, now.Add(30 * time.Second), 0, false},
+		{"out of skew", testTOTPSecret, code, now.Add(90 * time.Second), 1, false},
+		{"wrong code", testTOTPSecret, "000000", now, 1, false},
+		{"short code", testTOTPSecret, code[1:], now, 1, false},
+		{"invalid secret", "!!", code, now, 1, false},
+	}
+	for _, tt := range tests {
+		if valid := VerifyTOTP(tt.secret, tt.code, tt.at, tt.skew); valid != tt.valid {
+			t.Errorf("%s: expect valid:%v, but got %v", tt.name, tt.valid, valid)
+		}
+	}
+}
@@ -113,6 +113,12 @@ func Init(confile string) error {
 		//fmt.Printf("Failed to load hosts config:%s for reason:%v", string(data), err)
 		return err
 	}
+	SetMappings(hs)
+	return nil
+}
+
+// SetMappings replace all host mappings
+func SetMappings(hs map[string][]string) {
 	mappingMutex.Lock()
 	defer mappingMutex.Unlock()
 	hostMappingTable = make(map[string]*hostMapping)
@@ -128,5 +134,4 @@ func Init(confile string) error {
 			hostMappingTable[k] = mapping
 		}
 	}
-	return nil
 }
@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	levelDebug  = "debug"
+	levelInfo   = "info"
+	levelNotice = "notice"
+	levelError  = "error"
+	levelFatal  = "fatal"
+)
+
+// Fields attached to a log record, emitted as json keys in json mode and 'key=value' pairs in text mode
+type Fields map[string]interface{}
+
+type Entry struct {
+	fields Fields
+}
+
+func WithFields(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+// WithFields return a new entry with extra fields
+func (e *Entry) WithFields(fields Fields) *Entry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Entry{fields: merged}
+}
+
+func (e *Entry) Debug(format string, v ...interface{}) {
+	output(levelDebug, e.fields, format, v...)
+}
+
+func (e *Entry) Notice(format string, v ...interface{}) {
+	output(levelNotice, e.fields, format, v...)
+}
+
+func (e *Entry) Info(format string, v ...interface{}) {
+	output(levelInfo, e.fields, format, v...)
+}
+
+func (e *Entry) Error(format string, v ...interface{}) {
+	output(levelError, e.fields, format, v...)
+}
+
+var jsonOutputMutex sync.Mutex
+
+func (fields Fields) text() string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, " %s=%v", k, fields[k])
+	}
+	return buf.String()
+}
+
+func writeJSON(level string, fields Fields, msg string) {
+	record := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record["time"] = time.Now().Format(time.RFC3339Nano)
+	record["level"] = level
+	record["msg"] = msg
+	if _, file, line, ok := runtime.Caller(3); ok {
+		record["file"] = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	js, err := json.Marshal(record)
+	if nil != err {
+		js, _ = json.Marshal(map[string]interface{}{"level": level, "msg": msg})
+	}
+	jsonOutputMutex.Lock()
+	jsonOutput.Write(append(js, '\n'))
+	jsonOutputMutex.Unlock()
+}
+
+func output(level string, fields Fields, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if withFile {
+		if withJSON {
+			writeJSON(level, fields, msg)
+		} else {
+			log.Output(3, msg+fields.text())
+		}
+	}
+	if withColorConsole {
+		switch level {
+		case levelNotice:
+			setNoticeColor()
+			defer unsetNoticeColor()
+		case levelInfo:
+			setINFOColor()
+			defer unsetINFOColor()
+		case levelError, levelFatal:
+			setErrorColor()
+			defer unsetErrorColor()
+		}
+		colorConsoleLogger.Output(3, msg+fields.text())
+	}
+}
@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func captureLog(jsonMode bool, f func()) string {
+	savedFile, savedJSON, savedColor, savedOutput := withFile, withJSON, withColorConsole, jsonOutput
+	defer func() {
+		withFile, withJSON, withColorConsole, jsonOutput = savedFile, savedJSON, savedColor, savedOutput
+		log.SetOutput(os.Stderr)
+	}()
+	var buf bytes.Buffer
+	withFile, withJSON, withColorConsole, jsonOutput = true, jsonMode, false, &buf
+	log.SetOutput(&buf)
+	f()
+	return buf.String()
+}
+
+func TestJSONOutput(t *testing.T) {
+	out := captureLog(true, func() {
+		WithFields(Fields{"session": "s1", "bytes": 10}).Error("closed %s", "session")
+	})
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &record); nil != err {
+		t.Fatalf("expect one json record, but got %q:%v", out, err)
+	}
+	expected := map[string]interface{}{"session": "s1", "bytes": float64(10), "level": levelError, "msg": "closed session"}
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("expect %s=%v, but got %v", k, v, record[k])
+		}
+	}
+	//the caller of the logger is recorded
+	if file, _ := record["file"].(string); !strings.HasPrefix(file, "fields_test.go:") {
+		t.Errorf("expect caller file, but got %v", record["file"])
+	}
+	if _, exist := record["time"]; !exist {
+		t.Errorf("expect time in record")
+	}
+
+	out = captureLog(true, func() {
+		Notice("plain")
+	})
+	if err := json.Unmarshal([]byte(out), &record); nil != err || record["level"] != levelNotice || record["msg"] != "plain" {
+		t.Errorf("expect plain notice json record, but got %q", out)
+	}
+}
+
+func TestTextOutput(t *testing.T) {
+	tests := []struct {
+		fields Fields
+		suffix string
+	}{
+		{nil, "hello\n"},
+		{Fields{"user": "alice"}, "hello user=alice\n"},
+		{Fields{"up": 1, "down": 2, "session": "s1"}, "hello down=2 session=s1 up=1\n"},
+	}
+	for _, tt := range tests {
+		out := captureLog(false, func() {
+			WithFields(tt.fields).Info("hello")
+		})
+		if !strings.HasSuffix(out, tt.suffix) {
+			t.Errorf("expect text log ends with %q, but got %q", tt.suffix, out)
+		}
+	}
+}
+
+func TestEntryWithFields(t *testing.T) {
+	base := WithFields(Fields{"session": "s1", "user": "alice"})
+	stream := base.WithFields(Fields{"stream": 3, "user": "bob"})
+	if len(base.fields) != 2 || base.fields["user"] != "alice" {
+		t.Errorf("base entry should not be changed, but got %v", base.fields)
+	}
+	if len(stream.fields) != 3 || stream.fields["user"] != "bob" || stream.fields["session"] != "s1" {
+		t.Errorf("unexpected merged fields:%v", stream.fields)
+	}
+}
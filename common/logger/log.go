@@ -76,6 +76,7 @@ func IsDebugEnable() bool {
 
 func InitLogger(output []string) {
 	withFile = false
+	withJSON = false
 	ws := make([]io.Writer, 0)
 	for _, name := range output {
 		if strings.EqualFold(name, "stdout") {
@@ -87,6 +88,8 @@ func InitLogger(output []string) {
 		} else if strings.EqualFold(name, "color") {
 			//ws = append(ws, os.Stderr)
 			withColorConsole = true
+		} else if strings.EqualFold(name, "json") {
+			withJSON = true
 		} else {
 			ws = append(ws, initLogWriter(name))
 			withFile = true
@@ -94,65 +97,34 @@ func InitLogger(output []string) {
 	}
 	if len(ws) > 0 {
 		log.SetOutput(io.MultiWriter(ws...))
+		jsonOutput = io.MultiWriter(ws...)
 	}
 }
 
 var withColorConsole bool
 var withFile bool
+var withJSON bool
+var jsonOutput io.Writer = os.Stdout
 var colorConsoleLogger *log.Logger
 
 func Debug(format string, v ...interface{}) {
-	if withFile {
-		log.Output(2, fmt.Sprintf(format, v...))
-	}
-	if withColorConsole {
-		colorConsoleLogger.Output(2, fmt.Sprintf(format, v...))
-	}
-
+	output(levelDebug, nil, format, v...)
 }
+
 func Notice(format string, v ...interface{}) {
-	if withFile {
-		log.Output(2, fmt.Sprintf(format, v...))
-	}
-	if withColorConsole {
-		setNoticeColor()
-		colorConsoleLogger.Output(2, fmt.Sprintf(format, v...))
-		unsetNoticeColor()
-	}
+	output(levelNotice, nil, format, v...)
 }
 
 func Info(format string, v ...interface{}) {
-	if withFile {
-		log.Output(2, fmt.Sprintf(format, v...))
-	}
-
-	if withColorConsole {
-		setINFOColor()
-		colorConsoleLogger.Output(2, fmt.Sprintf(format, v...))
-		unsetINFOColor()
-	}
+	output(levelInfo, nil, format, v...)
 }
 
 func Error(format string, v ...interface{}) {
-	if withFile {
-		log.Output(2, fmt.Sprintf(format, v...))
-	}
-	if withColorConsole {
-		setErrorColor()
-		colorConsoleLogger.Output(2, fmt.Sprintf(format, v...))
-		unsetErrorColor()
-	}
+	output(levelError, nil, format, v...)
 }
 
 func Fatal(format string, v ...interface{}) {
-	if withFile {
-		log.Output(2, fmt.Sprintf(format, v...))
-	}
-	if withColorConsole {
-		setErrorColor()
-		colorConsoleLogger.Output(2, fmt.Sprintf(format, v...))
-		unsetErrorColor()
-	}
+	output(levelFatal, nil, format, v...)
 	os.Exit(1)
 }
 
@@ -0,0 +1,82 @@
+package mux
+
+import (
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Checksum is the rolling crc32 & length of bytes passed through a stream in one direction
+type Checksum struct {
+	CRC   uint32
+	Bytes int64
+}
+
+type checksum struct {
+	sum   Checksum
+	mutex sync.Mutex
+}
+
+func (c *checksum) update(p []byte) {
+	c.mutex.Lock()
+	c.sum.CRC = crc32.Update(c.sum.CRC, crc32.IEEETable, p)
+	c.sum.Bytes += int64(len(p))
+	c.mutex.Unlock()
+}
+
+// Sum return the checksum of bytes passed so far
+func (c *checksum) Sum() Checksum {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sum
+}
+
+// ChecksumReader update the checksum by bytes read
+type ChecksumReader struct {
+	checksum
+	r io.Reader
+}
+
+func (r *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.update(p[:n])
+	}
+	return n, err
+}
+
+func (r *ChecksumReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ChecksumWriter update the checksum by bytes written
+type ChecksumWriter struct {
+	checksum
+	w io.Writer
+}
+
+func (w *ChecksumWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.update(p[:n])
+	}
+	return n, err
+}
+
+func (w *ChecksumWriter) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func NewChecksumReader(r io.Reader) *ChecksumReader {
+	return &ChecksumReader{r: r}
+}
+
+func NewChecksumWriter(w io.Writer) *ChecksumWriter {
+	return &ChecksumWriter{w: w}
+}
@@ -0,0 +1,69 @@
+package mux
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestChecksumReaderWriter(t *testing.T) {
+	tests := [][]string{
+		nil,
+		{"hello"},
+		{"hello", " ", "world"},
+		{string(bytes.Repeat([]byte("gsnova"), 10000)), "tail"},
+	}
+	for _, chunks := range tests {
+		var all []byte
+		var buf bytes.Buffer
+		w := NewChecksumWriter(&buf)
+		for _, c := range chunks {
+			if _, err := w.Write([]byte(c)); nil != err {
+				t.Fatal(err)
+			}
+			all = append(all, c...)
+		}
+		expect := Checksum{CRC: crc32.ChecksumIEEE(all), Bytes: int64(len(all))}
+		if sum := w.Sum(); sum != expect {
+			t.Errorf("expect written checksum %+v of %d chunks, but got %+v", expect, len(chunks), sum)
+		}
+		r := NewChecksumReader(io.LimitReader(&buf, int64(len(all))))
+		if b, err := ioutil.ReadAll(r); nil != err || !bytes.Equal(b, all) {
+			t.Fatalf("read back %d bytes mismatch with %v", len(b), err)
+		}
+		if sum := r.Sum(); sum != expect {
+			t.Errorf("expect read checksum %+v of %d chunks, but got %+v", expect, len(chunks), sum)
+		}
+	}
+}
+
+// shortWriter accept at most n bytes per write
+type shortWriter struct {
+	bytes.Buffer
+	n int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.Buffer.Write(p[:w.n])
+		return w.n, io.ErrShortWrite
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestChecksumWriterPartial(t *testing.T) {
+	sw := &shortWriter{n: 3}
+	w := NewChecksumWriter(sw)
+	if n, err := w.Write([]byte("hello")); n != 3 || err != io.ErrShortWrite {
+		t.Fatalf("expect short write, but got %d %v", n, err)
+	}
+	//only bytes accepted by the underlying writer are counted
+	if sum := w.Sum(); sum.Bytes != 3 || sum.CRC != crc32.ChecksumIEEE([]byte("hel")) {
+		t.Errorf("unexpected checksum of short write:%+v", sum)
+	}
+	if err := w.Close(); nil != err {
+		t.Errorf("close of non closer should be nil, but got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package mux
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+var compressProbeBytes int64 = 1024 * 1024
+var compressMinRatio = 1.05
+
+// SetCompressAutoDisable make writers stop compressing streams whose ratio is below 'minRatio' in a window of
+// 'probeBytes' written, like encrypted or media content, 0 disables it. Raw zstd frames & uncompressed snappy
+// chunks are decoded natively by peers, so no protocol change.
+func SetCompressAutoDisable(probeBytes int64, minRatio float64) {
+	compressProbeBytes = probeBytes
+	compressMinRatio = minRatio
+}
+
+// CompressStats is the aggregated result of stream compression
+type CompressStats struct {
+	//streams written compressed & stopped compressing later
+	Streams  int64
+	Disabled int64
+	//bytes written by streams & bytes actually sent
+	RawBytes        int64
+	CompressedBytes int64
+	SavedBytes      int64
+	//time spent in compressing
+	CPUMillis int64
+}
+
+var compressStats CompressStats
+var compressNanos int64
+
+// GetCompressStats return the aggregated stats of stream writers
+func GetCompressStats() CompressStats {
+	stats := CompressStats{
+		Streams:         atomic.LoadInt64(&compressStats.Streams),
+		Disabled:        atomic.LoadInt64(&compressStats.Disabled),
+		RawBytes:        atomic.LoadInt64(&compressStats.RawBytes),
+		CompressedBytes: atomic.LoadInt64(&compressStats.CompressedBytes),
+		CPUMillis:       atomic.LoadInt64(&compressNanos) / int64(time.Millisecond),
+	}
+	stats.SavedBytes = stats.RawBytes - stats.CompressedBytes
+	return stats
+}
+
+// DumpCompressStats write the stats in text for stat pages
+func DumpCompressStats(w io.Writer) {
+	stats := GetCompressStats()
+	ratio := 1.0
+	if stats.CompressedBytes > 0 {
+		ratio = float64(stats.RawBytes) / float64(stats.CompressedBytes)
+	}
+	fmt.Fprintf(w, "CompressStreams: %d(%d disabled) Ratio: %.2f Saved: %d bytes CPU: %dms\n",
+		stats.Streams, stats.Disabled, ratio, stats.SavedBytes, stats.CPUMillis)
+}
+
+// compressMeter count bytes written to the stream by the compressor & measure the ratio of a stream
+type compressMeter struct {
+	w        io.Writer
+	in       int64
+	out      int64
+	winIn    int64
+	winOut   int64
+	disabled bool
+}
+
+func (m *compressMeter) Write(p []byte) (int, error) {
+	n, err := m.w.Write(p)
+	m.out += int64(n)
+	return n, err
+}
+
+// record account a write of n bytes which started when 'out' bytes sent, compression is disabled if the
+// ratio of the window is too low
+func (m *compressMeter) record(n int, out int64, start time.Time) {
+	sent := m.out - out
+	if m.in == 0 {
+		atomic.AddInt64(&compressStats.Streams, 1)
+	}
+	m.in += int64(n)
+	atomic.AddInt64(&compressStats.RawBytes, int64(n))
+	atomic.AddInt64(&compressStats.CompressedBytes, sent)
+	if m.disabled {
+		return
+	}
+	atomic.AddInt64(&compressNanos, int64(time.Since(start)))
+	m.winIn += int64(n)
+	m.winOut += sent
+	if compressProbeBytes <= 0 || m.winIn < compressProbeBytes {
+		return
+	}
+	if float64(m.winIn) < float64(m.winOut)*compressMinRatio {
+		m.disabled = true
+		atomic.AddInt64(&compressStats.Disabled, 1)
+	}
+	m.winIn, m.winOut = 0, 0
+}
+
+// zstd frame header of no content size & 128K window, followed by raw blocks
+var zstdRawFrameHeader = []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38}
+
+const zstdMaxRawBlock = 128 * 1024
+
+func writeZstdRawFrame(w io.Writer, p []byte) error {
+	buf := make([]byte, 0, len(zstdRawFrameHeader)+len(p)+3*(len(p)/zstdMaxRawBlock+1))
+	buf = append(buf, zstdRawFrameHeader...)
+	for {
+		n := len(p)
+		if n > zstdMaxRawBlock {
+			n = zstdMaxRawBlock
+		}
+		//raw block type is 0, the lowest bit marks the last block
+		h := uint32(n) << 3
+		if n == len(p) {
+			h |= 1
+		}
+		buf = append(buf, byte(h), byte(h>>8), byte(h>>16))
+		buf = append(buf, p[:n]...)
+		p = p[n:]
+		if len(p) == 0 {
+			break
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+const snappyMaxRawChunk = 65536
+
+var snappyCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+func snappyMaskedCRC(b []byte) uint32 {
+	c := crc32.Update(0, snappyCRCTable, b)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// writeSnappyRawChunks write uncompressed chunks of snappy framing format
+func writeSnappyRawChunks(w io.Writer, p []byte) error {
+	buf := make([]byte, 0, len(p)+8*(len(p)/snappyMaxRawChunk+1))
+	for len(p) > 0 {
+		n := len(p)
+		if n > snappyMaxRawChunk {
+			n = snappyMaxRawChunk
+		}
+		size := n + 4
+		crc := snappyMaskedCRC(p[:n])
+		buf = append(buf, 0x01, byte(size), byte(size>>8), byte(size>>16),
+			byte(crc), byte(crc>>8), byte(crc>>16), byte(crc>>24))
+		buf = append(buf, p[:n]...)
+		p = p[n:]
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// snappyWriter compress every write into chunks until disabled by the meter
+type snappyWriter struct {
+	enc   *snappy.Writer
+	w     io.WriteCloser
+	meter compressMeter
+}
+
+func newSnappyWriter(stream io.WriteCloser) *snappyWriter {
+	w := &snappyWriter{w: stream}
+	w.meter.w = stream
+	w.enc = snappy.NewWriter(&w.meter)
+	return w
+}
+
+func (w *snappyWriter) Write(p []byte) (int, error) {
+	start, out := time.Now(), w.meter.out
+	var err error
+	n := len(p)
+	if w.meter.disabled {
+		err = writeSnappyRawChunks(&w.meter, p)
+	} else {
+		n, err = w.enc.Write(p)
+	}
+	if nil == err {
+		w.meter.record(n, out, start)
+	}
+	return n, err
+}
+
+func (w *snappyWriter) Close() error {
+	return w.w.Close()
+}
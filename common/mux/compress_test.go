@@ -0,0 +1,71 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func testCompressAutoDisable(t *testing.T, newWriter func(io.WriteCloser) (io.Writer, *compressMeter), newReader func(io.Reader) io.Reader) {
+	SetCompressAutoDisable(64*1024, 1.05)
+	defer SetCompressAutoDisable(1024*1024, 1.05)
+
+	random := make([]byte, 256*1024)
+	rand.Read(random)
+	text := bytes.Repeat([]byte("gsnova compressible stream text "), 8*1024)
+	for _, c := range []struct {
+		data     []byte
+		disabled bool
+	}{{random, true}, {text, false}} {
+		var buf bufferCloser
+		w, meter := newWriter(&buf)
+		for i := 0; i < len(c.data); i += 16 * 1024 {
+			if _, err := w.Write(c.data[i : i+16*1024]); nil != err {
+				t.Fatal(err)
+			}
+		}
+		if meter.disabled != c.disabled {
+			t.Fatalf("expected disabled:%v after %d bytes sent", c.disabled, meter.out)
+		}
+		w.(io.Closer).Close()
+		b, err := ioutil.ReadAll(newReader(&buf))
+		if nil != err || !bytes.Equal(b, c.data) {
+			t.Fatalf("decoded %d bytes mismatch with %v", len(b), err)
+		}
+	}
+}
+
+func TestZstdAutoDisable(t *testing.T) {
+	testCompressAutoDisable(t, func(stream io.WriteCloser) (io.Writer, *compressMeter) {
+		_, w := newZstdReaderWriter(&struct {
+			io.Reader
+			io.WriteCloser
+		}{&bytes.Buffer{}, stream}, defaultZstdLevel)
+		return w, &w.(*zstdWriter).meter
+	}, func(r io.Reader) io.Reader {
+		dec, _ := zstd.NewReader(r)
+		return dec
+	})
+}
+
+func TestSnappyAutoDisable(t *testing.T) {
+	testCompressAutoDisable(t, func(stream io.WriteCloser) (io.Writer, *compressMeter) {
+		w := newSnappyWriter(stream)
+		return w, &w.meter
+	}, func(r io.Reader) io.Reader {
+		return snappy.NewReader(r)
+	})
+}
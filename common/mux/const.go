@@ -1,17 +1,74 @@
 package mux
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	DefaultMuxCipherMethod         = "chacha20poly1305"
 	DefaultMuxInitialCipherCounter = uint64(47816489)
 	AuthOK                         = 1
+	AuthRejected                   = 2
+	AuthVersionRejected            = 3
+	AuthScheduleRejected           = 4
+	AuthQuotaRejected              = 5
+	AuthSessionLimitRejected       = 6
+	AuthSourceIPLimitRejected      = 7
+
+	//increased when client/server protocol changed incompatibly
+	ProtocolLevel = 5
+	//servers relay 'EarlyData' of connect requests since this level, older ones drop it
+	EarlyDataProtocolLevel = 3
+	//servers reply ConnectResponse for connect requests asked since this level
+	ConnectResponseProtocolLevel = 4
+	//servers serve speed test streams since this level
+	SpeedTestProtocolLevel = 5
+
+	//max length of messages written by WriteMessage & read by ReadMessage
+	MaxMessageSize = 1000000
 
 	//GZipCompressor   = "gzip"
 
 	HTTPMuxSessionIDHeader    = "X-Session-ID"
 	HTTPMuxSessionACKIDHeader = "X-Session-ACK-ID"
 	HTTPMuxPullPeriodHeader   = "X-PullPeriod"
+
+	//stream carry multiple udp datagrams to different destinations
+	UDPAssociateNetwork = "udp_associate"
+	//stream exchange webrtc offer/answer between two P2SP peers
+	P2PSignalNetwork = "p2p_signal"
+	//long lived stream for server to push control messages
+	ControlNetwork = "control"
+	//stream to fetch relay addresses advertised by P2SP room members
+	P2SPRelaysNetwork = "p2sp_relays"
+	//stream registering a reverse tunnel by client, or opened by server for inbound connections of the tunnel
+	ReverseNetwork = "reverse"
+	//stream echo the probe payload by server to check the health of the full stream path
+	PingNetwork = "ping"
+	//stream sink or source bytes by server to measure the bandwidth, 'Addr' is like 'download:<bytes>'
+	SpeedTestNetwork = "speedtest"
+
+	//server would close the session soon
+	ControlSessionClosing = "session_closing"
+	//server report checksums of a closed stream
+	ControlStreamChecksum = "stream_checksum"
+	//server push the traffic quota status of the user
+	ControlQuotaStatus = "quota_status"
+	//server push the signed rule bundle of gfwlist, hosts & routing rules
+	ControlRuleBundle = "rule_bundle"
+	//server failed to dial the destination of a stream, before closing the stream
+	ControlDialFailed = "dial_failed"
+	//server reset a stream since the connection to its destination is dead, like keepalive probes failed
+	ControlStreamReset = "stream_reset"
+
+	//codes of ControlDialFailed & ConnectResponse
+	DialErrOther       = 1
+	DialErrTimeout     = 2
+	DialErrRefused     = 3
+	DialErrUnreachable = 4
+	DialErrResolve     = 5
+	DialErrNotAllowed  = 6
 )
 
 var (
@@ -19,3 +76,26 @@ var (
 	ErrAuthFailed      = errors.New("auth failed")
 	ErrDataReadMissing = errors.New("auth failed")
 )
+
+// AuthError is returned by MuxStream.Auth when server rejected the auth request
+type AuthError struct {
+	Code   int
+	Reason string
+}
+
+// ConnectError is returned by MuxStream.Connect if the server failed to connect the destination
+type ConnectError struct {
+	Code   int
+	Reason string
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("remote connect failed with code:%d for reason:%s", e.Code, e.Reason)
+}
+
+func (e *AuthError) Error() string {
+	if len(e.Reason) == 0 {
+		return fmt.Sprintf("auth failed with code:%d", e.Code)
+	}
+	return fmt.Sprintf("auth failed with code:%d for reason:%s", e.Code, e.Reason)
+}
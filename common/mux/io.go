@@ -14,9 +14,12 @@ const (
 )
 
 func GetCompressStreamReaderWriter(stream io.ReadWriteCloser, method string) (io.Reader, io.Writer) {
+	if level, ok := parseZstdMethod(method); ok {
+		return newZstdReaderWriter(stream, level)
+	}
 	switch method {
 	case SnappyCompressor:
-		return snappy.NewReader(stream), snappy.NewWriter(stream)
+		return snappy.NewReader(stream), newSnappyWriter(stream)
 	case NoneCompressor:
 		fallthrough
 	default:
@@ -25,6 +28,9 @@ func GetCompressStreamReaderWriter(stream io.ReadWriteCloser, method string) (io
 }
 
 func IsValidCompressor(method string) bool {
+	if _, ok := parseZstdMethod(method); ok {
+		return true
+	}
 	switch method {
 	case SnappyCompressor:
 	case NoneCompressor:
@@ -2,14 +2,16 @@ package mux
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"sync/atomic"
 	"time"
 
-	quic "github.com/lucas-clemente/quic-go"
+	quic "github.com/quic-go/quic-go"
 	"github.com/vmihailenco/msgpack"
 	"github.com/yinqiwen/gsnova/common/helper"
 	"github.com/yinqiwen/pmux"
@@ -29,6 +31,95 @@ type ConnectRequest struct {
 	DialTimeout int
 	ReadTimeout int
 	Hops        []string
+
+	//w3c trace context of the stream span at previous hop
+	TraceContext map[string]string
+	//first bytes from client written to the destination once dialed, uncompressed
+	EarlyData []byte
+	//server reply ConnectResponse before relaying
+	Response bool
+}
+
+// ConnectResponse is the result of connecting the destination by server
+type ConnectResponse struct {
+	//0 if connected, else one of 'DialErr*'
+	Code   int
+	Reason string
+}
+
+// UDPDatagram is the length-prefixed frame carried by a stream connected with
+// network UDPAssociateNetwork, Addr is the destination (client->server) or
+// the source (server->client) address.
+type UDPDatagram struct {
+	Addr string
+	Data []byte
+}
+
+func ReadUDPDatagram(stream io.Reader) (*UDPDatagram, error) {
+	var d UDPDatagram
+	err := ReadMessage(stream, &d)
+	return &d, err
+}
+
+// ControlMessage is sent by the server on the session's control stream, which
+// is the stream connected with network ControlNetwork by the client.
+type ControlMessage struct {
+	Type   string
+	Reason string
+
+	//checksums of the closed stream for ControlStreamChecksum, 'Recv' is data from client
+	//or the stream failed to dial for ControlDialFailed, or reset for ControlStreamReset
+	StreamID uint32
+	Recv     Checksum
+	Sent     Checksum
+
+	//for ControlQuotaStatus
+	Quota *QuotaStatus
+	//for ControlRuleBundle
+	Rules *RuleBundle
+	//for ControlDialFailed, one of 'DialErr*'
+	Code int
+	Addr string
+}
+
+// RuleBundle is distributed by server to clients, signed by the ed25519 key of the operator
+type RuleBundle struct {
+	//newer bundle has larger version
+	Version int64
+	//gfwlist content in base64 as published
+	GFWList string
+	//host -> mapping addresses like hosts.json
+	Hosts map[string][]string
+	//json array of PAC rules replacing the rules of local proxies
+	PAC       []byte
+	Signature []byte
+}
+
+// SignedData is the digest covered by the signature
+func (b *RuleBundle) SignedData() []byte {
+	h := sha256.New()
+	hosts, _ := json.Marshal(b.Hosts)
+	binary.Write(h, binary.BigEndian, b.Version)
+	for _, field := range [][]byte{[]byte(b.GFWList), hosts, b.PAC} {
+		binary.Write(h, binary.BigEndian, uint32(len(field)))
+		h.Write(field)
+	}
+	return h.Sum(nil)
+}
+
+// QuotaStatus is the traffic quota of the user in current period, all counted in bytes
+type QuotaStatus struct {
+	Limit     int64
+	Used      int64
+	Remaining int64
+	//unix time of next reset
+	ResetTime int64
+}
+
+func ReadControlMessage(stream io.Reader) (*ControlMessage, error) {
+	var m ControlMessage
+	err := ReadMessage(stream, &m)
+	return &m, err
 }
 
 type AuthRequest struct {
@@ -40,9 +131,39 @@ type AuthRequest struct {
 
 	P2SPRoomId string
 	P2SPConnId string
+	//address of the relay this P2SP member opened for other room members
+	P2SPRelayAddr string
+
+	//globally unique id generated by client for log correlation
+	SessionID string
+
+	Version       string
+	ProtocolLevel int
+
+	//unix seconds and random nonce to reject replayed auth requests
+	Timestamp int64
+	Nonce     string
+	//current TOTP code if the user enabled second factor
+	TOTP string
+	//password or access token of the user verified by the auth provider of server
+	Credential string
+	//proof of the per user key, the session key is derived from it after auth
+	KeyProof string
+	//max bytes per data frame the server should write, 0 means unlimited
+	MaxFrameSize int
+	//server report checksums of closed streams on control stream
+	StreamChecksum bool
 }
+//P2SPRelayList is the response of P2SPRelaysNetwork stream
+type P2SPRelayList struct {
+	Addrs []string
+}
+
 type AuthResponse struct {
-	Code int
+	Code   int
+	Reason string
+	//protocol level of the server, 0 for old servers
+	ProtocolLevel int
 }
 
 func ReadConnectRequest(stream io.Reader) (*ConnectRequest, error) {
@@ -65,6 +186,10 @@ func WriteMessage(stream io.Writer, req interface{}) error {
 	if nil != err {
 		return err
 	}
+	if buf.Len()-4 > MaxMessageSize {
+		//the peer would drop it & the stream
+		return ErrToolargeMessage
+	}
 	binary.BigEndian.PutUint32(buf.Bytes(), uint32(buf.Len()-4))
 	_, err = stream.Write(buf.Bytes())
 	return err
@@ -76,7 +201,7 @@ func ReadMessage(stream io.Reader, res interface{}) error {
 	length := uint32(0)
 	if n == len(lenbuf) {
 		length = binary.BigEndian.Uint32(lenbuf)
-		if length > 1000000 {
+		if length > MaxMessageSize {
 			return ErrToolargeMessage
 		}
 	} else {
@@ -93,9 +218,17 @@ func ReadMessage(stream io.Reader, res interface{}) error {
 }
 
 type StreamOptions struct {
-	DialTimeout int
-	ReadTimeout int
-	Hops        []string
+	DialTimeout  int
+	ReadTimeout  int
+	Hops         []string
+	TraceContext map[string]string
+	EarlyData    []byte
+	//wait the ConnectResponse in Connect, only for servers of ConnectResponseProtocolLevel
+	WaitResponse bool
+
+	//only used by local direct channel, not sent to remote
+	SocketMark int
+	DSCP       int
 }
 
 type MuxStream interface {
@@ -122,6 +255,8 @@ type ProxyMuxStream struct {
 	session      MuxSession
 	sessionID    int64
 	latestIOTime time.Time
+	maxFrameSize int
+	authRes      *AuthResponse
 }
 
 func (s *ProxyMuxStream) OnIO(read bool) {
@@ -155,7 +290,23 @@ func (s *ProxyMuxStream) Read(p []byte) (int, error) {
 }
 func (s *ProxyMuxStream) Write(p []byte) (int, error) {
 	s.latestIOTime = time.Now()
-	return s.TimeoutReadWriteCloser.Write(p)
+	if s.maxFrameSize <= 0 || len(p) <= s.maxFrameSize {
+		return s.TimeoutReadWriteCloser.Write(p)
+	}
+	//pmux write one data frame per call unless limited by stream window
+	n := 0
+	for n < len(p) {
+		end := n + s.maxFrameSize
+		if end > len(p) {
+			end = len(p)
+		}
+		nn, err := s.TimeoutReadWriteCloser.Write(p[n:end])
+		n += nn
+		if nil != err {
+			return n, err
+		}
+	}
+	return n, nil
 }
 func (s *ProxyMuxStream) LatestIOTime() time.Time {
 	return s.latestIOTime
@@ -164,7 +315,7 @@ func (s *ProxyMuxStream) LatestIOTime() time.Time {
 func (s *ProxyMuxStream) StreamID() uint32 {
 	if ps, ok := s.TimeoutReadWriteCloser.(*pmux.Stream); ok {
 		return ps.ID()
-	} else if qs, ok := s.TimeoutReadWriteCloser.(quic.Stream); ok {
+	} else if qs, ok := s.TimeoutReadWriteCloser.(*quic.Stream); ok {
 		return uint32(qs.StreamID())
 	}
 	if 0 == s.sessionID {
@@ -187,12 +338,55 @@ func (s *ProxyMuxStream) Connect(network string, addr string, opt StreamOptions)
 		DialTimeout: opt.DialTimeout,
 		ReadTimeout: opt.ReadTimeout,
 		Hops:        opt.Hops,
+
+		TraceContext: opt.TraceContext,
+		EarlyData:    opt.EarlyData,
+		Response:     opt.WaitResponse,
+	}
+	err := WriteMessage(s, req)
+	if nil != err || !opt.WaitResponse {
+		return err
+	}
+	//server may retry the dial for a while
+	s.SetReadDeadline(time.Now().Add(time.Duration(opt.DialTimeout)*time.Millisecond + 30*time.Second))
+	res := &ConnectResponse{}
+	err = ReadMessage(s, res)
+	s.SetReadDeadline(time.Time{})
+	if nil != err {
+		return err
 	}
-	return WriteMessage(s, req)
+	if 0 != res.Code {
+		return &ConnectError{Code: res.Code, Reason: res.Reason}
+	}
+	return nil
+}
+// AuthResponse return the response received by Auth, nil if not authed
+func (s *ProxyMuxStream) AuthResponse() *AuthResponse {
+	return s.authRes
 }
+
+// length range of random padding in auth requests
+var authPaddingMin, authPaddingMax int32 = 0, 128
+
+// SetAuthPadding set the length range [min, max) of random padding in auth requests
+func SetAuthPadding(min, max int) {
+	if min < 0 || max <= min {
+		return
+	}
+	atomic.StoreInt32(&authPaddingMin, int32(min))
+	atomic.StoreInt32(&authPaddingMax, int32(max))
+}
+
 func (s *ProxyMuxStream) Auth(req *AuthRequest) error {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	req.Rand = helper.RandAsciiString(int(r.Int31n(128)))
+	if len(req.Rand) == 0 {
+		min, max := atomic.LoadInt32(&authPaddingMin), atomic.LoadInt32(&authPaddingMax)
+		req.Rand = helper.RandAsciiString(int(min + r.Int31n(max-min)))
+	}
+	req.Timestamp = time.Now().Unix()
+	if len(req.Nonce) == 0 {
+		req.Nonce = helper.RandHexString(16)
+	}
 	err := WriteMessage(s, req)
 	if nil != err {
 		return err
@@ -202,19 +396,65 @@ func (s *ProxyMuxStream) Auth(req *AuthRequest) error {
 	if nil != err {
 		return err
 	}
+	s.authRes = res
 	if nil == err {
 		//wait remote close
 		ioutil.ReadAll(s)
 	}
 	//s.Read(make([]byte, 1))
 	if res.Code != AuthOK {
-		return ErrAuthFailed
+		return &AuthError{Code: res.Code, Reason: res.Reason}
 	}
 	return nil
 }
 
+// sessionStream tag a stream with the id of the session it belongs to
+type sessionStream struct {
+	MuxStream
+	sessionID string
+}
+
+func (s *sessionStream) SessionID() string {
+	return s.sessionID
+}
+
+func (s *sessionStream) WriteTo(w io.Writer) (int64, error) {
+	if writerTo, ok := s.MuxStream.(io.WriterTo); ok {
+		return writerTo.WriteTo(w)
+	}
+	return io.Copy(w, struct{ io.Reader }{s.MuxStream})
+}
+
+func WithSessionID(stream MuxStream, sessionID string) MuxStream {
+	if nil == stream || len(sessionID) == 0 {
+		return stream
+	}
+	return &sessionStream{MuxStream: stream, sessionID: sessionID}
+}
+
+// GetStreamSessionID return the session id of the stream, empty if unknown
+func GetStreamSessionID(stream MuxStream) string {
+	if s, ok := stream.(interface {
+		SessionID() string
+	}); ok {
+		return s.SessionID()
+	}
+	return ""
+}
+
 type ProxyMuxSession struct {
 	*pmux.Session
+	Config *pmux.Config
+	//fragment writes of streams opened/accepted later, 0 means unlimited
+	MaxFrameSize int
+}
+
+//ResetCryptoContextWithKey replace the session cipher key before resetting the crypto context
+func (s *ProxyMuxSession) ResetCryptoContextWithKey(key string, method string, counter uint64) error {
+	if len(key) > 0 && nil != s.Config {
+		s.Config.CipherKey = []byte(key)
+	}
+	return s.Session.ResetCryptoContext(method, counter)
 }
 
 func (s *ProxyMuxSession) CloseStream(stream MuxStream) error {
@@ -226,7 +466,7 @@ func (s *ProxyMuxSession) OpenStream() (MuxStream, error) {
 	if nil != err {
 		return nil, err
 	}
-	stream := &ProxyMuxStream{TimeoutReadWriteCloser: ss}
+	stream := &ProxyMuxStream{TimeoutReadWriteCloser: ss, maxFrameSize: s.MaxFrameSize}
 	ss.IOCallback = stream
 	return stream, nil
 }
@@ -236,7 +476,7 @@ func (s *ProxyMuxSession) AcceptStream() (MuxStream, error) {
 	if nil != err {
 		return nil, err
 	}
-	stream := &ProxyMuxStream{TimeoutReadWriteCloser: ss}
+	stream := &ProxyMuxStream{TimeoutReadWriteCloser: ss, maxFrameSize: s.MaxFrameSize}
 	ss.IOCallback = stream
 	return stream, nil
 }
@@ -2,8 +2,12 @@ package mux
 
 import (
 	"bytes"
+	"io"
 	"log"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 )
 
 type A struct {
@@ -36,3 +40,102 @@ func TestXYZ(t *testing.T) {
 	// err := ReadMessage(&buffer, zz)
 	// log.Printf("#### %v %v", zz, err)
 }
+
+func TestConnectWaitResponse(t *testing.T) {
+	for _, code := range []int{0, DialErrRefused} {
+		local, remote := net.Pipe()
+		stream := &ProxyMuxStream{TimeoutReadWriteCloser: local}
+		go func() {
+			req, err := ReadConnectRequest(remote)
+			if nil != err || !req.Response || req.Addr != "example.com:80" {
+				remote.Close()
+				return
+			}
+			WriteMessage(remote, &ConnectResponse{Code: code, Reason: "refused"})
+		}()
+		err := stream.Connect("tcp", "example.com:80", StreamOptions{WaitResponse: true})
+		if connErr, ok := err.(*ConnectError); code != 0 && (!ok || connErr.Code != code) {
+			t.Errorf("expect connect error code %d, but got %v", code, err)
+		} else if code == 0 && nil != err {
+			t.Errorf("connect failed:%v", err)
+		}
+		local.Close()
+	}
+}
+
+func TestWithSessionID(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	stream := &ProxyMuxStream{TimeoutReadWriteCloser: local}
+	if s := WithSessionID(stream, ""); s != MuxStream(stream) {
+		t.Errorf("stream should not be wrapped without session id")
+	}
+	if s := WithSessionID(nil, "s1"); nil != s {
+		t.Errorf("nil stream should not be wrapped, but got %v", s)
+	}
+	if id := GetStreamSessionID(stream); len(id) != 0 {
+		t.Errorf("expect empty session id of untagged stream, but got %s", id)
+	}
+	tagged := WithSessionID(stream, "s1")
+	if id := GetStreamSessionID(tagged); id != "s1" {
+		t.Errorf("expect session id s1, but got %s", id)
+	}
+	//tagged stream is still read by the underlying WriterTo
+	go func() {
+		remote.Write([]byte("hello"))
+		remote.Close()
+	}()
+	var buffer bytes.Buffer
+	if n, err := tagged.(io.WriterTo).WriteTo(&buffer); n != 5 || buffer.String() != "hello" {
+		t.Errorf("expect 'hello' copied, but got %q %v", buffer.String(), err)
+	}
+}
+
+// frameRecorder record every write to the underlying stream, and fail once 'failAt' writes done
+type frameRecorder struct {
+	bytes.Buffer
+	writes []int
+	failAt int
+}
+
+func (r *frameRecorder) Write(p []byte) (int, error) {
+	if r.failAt > 0 && len(r.writes) == r.failAt {
+		return 0, io.ErrClosedPipe
+	}
+	r.writes = append(r.writes, len(p))
+	return r.Buffer.Write(p)
+}
+func (r *frameRecorder) Close() error                       { return nil }
+func (r *frameRecorder) SetReadDeadline(t time.Time) error  { return nil }
+func (r *frameRecorder) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestStreamMaxFrameSize(t *testing.T) {
+	tests := []struct {
+		maxFrameSize int
+		size         int
+		writes       []int
+	}{
+		{0, 100000, []int{100000}},
+		{16384, 100, []int{100}},
+		{16384, 16384, []int{16384}},
+		{16384, 16385, []int{16384, 1}},
+		{1000, 3500, []int{1000, 1000, 1000, 500}},
+	}
+	for _, tt := range tests {
+		r := &frameRecorder{}
+		stream := &ProxyMuxStream{TimeoutReadWriteCloser: r, maxFrameSize: tt.maxFrameSize}
+		data := bytes.Repeat([]byte{'x'}, tt.size)
+		if n, err := stream.Write(data); nil != err || n != tt.size {
+			t.Errorf("write %d bytes got n=%d err=%v", tt.size, n, err)
+		}
+		if !reflect.DeepEqual(r.writes, tt.writes) || !bytes.Equal(r.Bytes(), data) {
+			t.Errorf("write %d bytes by max frame %d expect frames %v, but got %v", tt.size, tt.maxFrameSize, tt.writes, r.writes)
+		}
+	}
+	//written bytes before the failed frame are reported
+	r := &frameRecorder{failAt: 2}
+	stream := &ProxyMuxStream{TimeoutReadWriteCloser: r, maxFrameSize: 1000}
+	if n, err := stream.Write(make([]byte, 3500)); err != io.ErrClosedPipe || n != 2000 {
+		t.Errorf("expect 2000 bytes written before error, but got n=%d err=%v", n, err)
+	}
+}
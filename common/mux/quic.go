@@ -1,15 +1,16 @@
 package mux
 
 import (
+	"context"
 	"sync/atomic"
 	"time"
 
-	quic "github.com/lucas-clemente/quic-go"
+	quic "github.com/quic-go/quic-go"
 )
 
 type QUICMuxSession struct {
 	streamCounter int64
-	quic.Session
+	*quic.Conn
 }
 
 func (q *QUICMuxSession) Ping() (time.Duration, error) {
@@ -22,7 +23,13 @@ func (q *QUICMuxSession) CloseStream(stream MuxStream) error {
 }
 
 func (q *QUICMuxSession) OpenStream() (MuxStream, error) {
-	s, err := q.OpenStreamSync()
+	s, err := q.OpenStreamSync(context.Background())
+	if err == quic.Err0RTTRejected {
+		//server rejected the early data, continue once the full handshake completed
+		if _, err = q.NextConnection(context.Background()); nil == err {
+			s, err = q.OpenStreamSync(context.Background())
+		}
+	}
 	if nil != err {
 		return nil, err
 	}
@@ -31,7 +38,7 @@ func (q *QUICMuxSession) OpenStream() (MuxStream, error) {
 }
 
 func (q *QUICMuxSession) AcceptStream() (MuxStream, error) {
-	s, err := q.Session.AcceptStream()
+	s, err := q.Conn.AcceptStream(context.Background())
 	if nil != err {
 		return nil, err
 	}
@@ -44,5 +51,5 @@ func (q *QUICMuxSession) NumStreams() int {
 
 func (q *QUICMuxSession) Close() error {
 	q.streamCounter = 0
-	return q.Session.Close(nil)
+	return q.CloseWithError(0, "")
 }
@@ -0,0 +1,166 @@
+package mux
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ZstdCompressor       = "zstd"
+	defaultZstdLevel     = 3
+	zstdStreamWindowSize = 256 * 1024
+)
+
+// parseZstdMethod parse compress method like 'zstd' or 'zstd:5'
+func parseZstdMethod(method string) (int, bool) {
+	if method == ZstdCompressor {
+		return defaultZstdLevel, true
+	}
+	if !strings.HasPrefix(method, ZstdCompressor+":") {
+		return 0, false
+	}
+	level, err := strconv.Atoi(method[len(ZstdCompressor)+1:])
+	if nil != err || level < 1 || level > 22 {
+		return 0, false
+	}
+	return level, true
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (r *zstdReader) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+var compressResetIdle time.Duration
+var compressResetBytes int64
+
+// SetCompressReset make zstd writers end the current frame and drop the compress context after the
+// stream is idle for 'idle' or every 'bytes' written, 0 disables it. Decoders handle concatenated
+// frames natively, so the frame end is a flush point known by both peers without protocol change.
+// Snappy has no context across chunks and needs no reset.
+func SetCompressReset(idle time.Duration, bytes int64) {
+	compressResetIdle = idle
+	compressResetBytes = bytes
+}
+
+// zstdWriter flush every write since mux streams carry interactive traffic
+type zstdWriter struct {
+	enc     *zstd.Encoder
+	w       io.WriteCloser
+	level   int
+	written int64
+	idle    *time.Timer
+	closed  bool
+	mutex   sync.Mutex
+	meter   compressMeter
+}
+
+func newZstdEncoder(w io.Writer, level int) (*zstd.Encoder, error) {
+	return zstd.NewWriter(w,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(zstdStreamWindowSize))
+}
+
+// reset end current frame, the encoder is released to bound memory of idle streams
+func (w *zstdWriter) reset(release bool) {
+	if nil == w.enc {
+		return
+	}
+	w.enc.Close()
+	if release {
+		w.enc = nil
+	} else {
+		w.enc.Reset(&w.meter)
+	}
+	w.written = 0
+}
+
+func (w *zstdWriter) onIdle() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.closed {
+		w.reset(true)
+	}
+}
+
+func (w *zstdWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	start, out := time.Now(), w.meter.out
+	if w.meter.disabled {
+		if err := writeZstdRawFrame(&w.meter, p); nil != err {
+			return 0, err
+		}
+		w.meter.record(len(p), out, start)
+		return len(p), nil
+	}
+	if nil == w.enc {
+		enc, err := newZstdEncoder(&w.meter, w.level)
+		if nil != err {
+			return 0, err
+		}
+		w.enc = enc
+	}
+	n, err := w.enc.Write(p)
+	if nil != err {
+		return n, err
+	}
+	err = w.enc.Flush()
+	w.written += int64(n)
+	if nil == err {
+		w.meter.record(n, out, start)
+	}
+	if w.meter.disabled {
+		//end the frame, later writes are raw frames
+		w.reset(true)
+		return n, err
+	}
+	if compressResetBytes > 0 && w.written >= compressResetBytes {
+		w.reset(false)
+	}
+	if compressResetIdle > 0 {
+		if nil == w.idle {
+			w.idle = time.AfterFunc(compressResetIdle, w.onIdle)
+		} else {
+			w.idle.Reset(compressResetIdle)
+		}
+	}
+	return n, err
+}
+
+func (w *zstdWriter) Close() error {
+	w.mutex.Lock()
+	w.closed = true
+	if nil != w.idle {
+		w.idle.Stop()
+	}
+	if nil != w.enc {
+		w.enc.Close()
+	}
+	w.mutex.Unlock()
+	return w.w.Close()
+}
+
+func newZstdReaderWriter(stream io.ReadWriteCloser, level int) (io.Reader, io.Writer) {
+	dec, err := zstd.NewReader(stream, zstd.WithDecoderConcurrency(1))
+	if nil != err {
+		return stream, stream
+	}
+	w := &zstdWriter{w: stream, level: level}
+	w.meter.w = stream
+	if w.enc, err = newZstdEncoder(&w.meter, level); nil != err {
+		dec.Close()
+		return stream, stream
+	}
+	return &zstdReader{dec}, w
+}
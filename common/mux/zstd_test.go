@@ -0,0 +1,158 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestParseZstdMethod(t *testing.T) {
+	tests := []struct {
+		method string
+		level  int
+		ok     bool
+	}{
+		{"zstd", defaultZstdLevel, true},
+		{"zstd:1", 1, true},
+		{"zstd:22", 22, true},
+		{"zstd:0", 0, false},
+		{"zstd:23", 0, false},
+		{"zstd:", 0, false},
+		{"zstd:fast", 0, false},
+		{"zstdx", 0, false},
+		{"snappy", 0, false},
+	}
+	for _, tt := range tests {
+		level, ok := parseZstdMethod(tt.method)
+		if level != tt.level || ok != tt.ok {
+			t.Errorf("parse %q expect (%d,%v), but got (%d,%v)", tt.method, tt.level, tt.ok, level, ok)
+		}
+		if IsValidCompressor(tt.method) != (tt.ok || tt.method == SnappyCompressor) {
+			t.Errorf("unexpected valid compressor result of %q", tt.method)
+		}
+	}
+}
+
+// testZstdPipe return the reader of the peer & the writer of a zstd compressed pipe
+func testZstdPipe(t *testing.T, method string) (io.Reader, io.Writer, func()) {
+	local, remote := net.Pipe()
+	_, w := GetCompressStreamReaderWriter(local, method)
+	r, _ := GetCompressStreamReaderWriter(remote, method)
+	if _, ok := w.(*zstdWriter); !ok {
+		t.Fatalf("expect zstd writer for %s, but got %T", method, w)
+	}
+	return r, w, func() {
+		local.Close()
+		remote.Close()
+	}
+}
+
+func TestZstdStreamFlushEveryWrite(t *testing.T) {
+	r, w, closeAll := testZstdPipe(t, "zstd:5")
+	defer closeAll()
+	for _, msg := range []string{"hello", "interactive", string(bytes.Repeat([]byte("gsnova"), 10000))} {
+		go w.Write([]byte(msg))
+		//each write is readable by peer without more data
+		b := make([]byte, len(msg))
+		done := make(chan error, 1)
+		go func() {
+			_, err := io.ReadFull(r, b)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if nil != err || string(b) != msg {
+				t.Fatalf("read %d bytes mismatch with %v", len(msg), err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("write of %d bytes not flushed", len(msg))
+		}
+	}
+}
+
+var zstdFrameMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func TestZstdCompressResetBytes(t *testing.T) {
+	defer SetCompressReset(0, 0)
+	msg := bytes.Repeat([]byte("gsnova reset "), 25)
+	tests := []struct {
+		resetBytes int64
+		writes     int
+		frames     int
+	}{
+		{0, 10, 1},
+		//frames end once 1000 bytes written, the next frame starts on the next write
+		{1000, 10, 3},
+		{1000, 4, 1},
+		{100, 3, 3},
+	}
+	for _, tt := range tests {
+		SetCompressReset(0, tt.resetBytes)
+		var buf bufferCloser
+		_, w := newZstdReaderWriter(&struct {
+			io.Reader
+			io.WriteCloser
+		}{&bytes.Buffer{}, &buf}, defaultZstdLevel)
+		var expect []byte
+		for i := 0; i < tt.writes; i++ {
+			if _, err := w.Write(msg); nil != err {
+				t.Fatal(err)
+			}
+			expect = append(expect, msg...)
+		}
+		w.(io.Closer).Close()
+		if frames := bytes.Count(buf.Bytes(), zstdFrameMagic); frames != tt.frames {
+			t.Errorf("reset bytes %d with %d writes expect %d frames, but got %d", tt.resetBytes, tt.writes, tt.frames, frames)
+		}
+		dec, _ := zstd.NewReader(&buf)
+		b, err := ioutil.ReadAll(dec)
+		dec.Close()
+		if nil != err || !bytes.Equal(b, expect) {
+			t.Errorf("reset bytes %d decoded %d bytes mismatch with %v", tt.resetBytes, len(b), err)
+		}
+	}
+}
+
+func TestZstdCompressResetIdle(t *testing.T) {
+	SetCompressReset(20*time.Millisecond, 0)
+	defer SetCompressReset(0, 0)
+	var buf bufferCloser
+	_, w := newZstdReaderWriter(&struct {
+		io.Reader
+		io.WriteCloser
+	}{&bytes.Buffer{}, &buf}, defaultZstdLevel)
+	zw := w.(*zstdWriter)
+	for _, msg := range []string{"before idle", "after idle"} {
+		if _, err := w.Write([]byte(msg)); nil != err {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			zw.mutex.Lock()
+			released := nil == zw.enc
+			zw.mutex.Unlock()
+			if released {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("encoder not released after %q on idle", msg)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	w.(io.Closer).Close()
+	//every write after idle starts a new frame
+	if frames := bytes.Count(buf.Bytes(), zstdFrameMagic); frames != 2 {
+		t.Errorf("expect 2 frames, but got %d", frames)
+	}
+	dec, _ := zstd.NewReader(&buf)
+	defer dec.Close()
+	if b, err := ioutil.ReadAll(dec); nil != err || string(b) != "before idleafter idle" {
+		t.Errorf("decoded %q mismatch with %v", b, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"net"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -72,6 +73,27 @@ func OverrideDialUDP(dial func(nnetwork string, laddr, raddr *net.UDPAddr) (net.
 	dialUDP.Store(dial)
 }
 
+// OverrideControl dial & listen by sockets set up by the control function, like binding the outgoing interface
+func OverrideControl(control func(network, address string, c syscall.RawConn) error) {
+	d := &net.Dialer{Control: control}
+	OverrideDial(d.DialContext)
+	lc := &net.ListenConfig{Control: control}
+	OverrideListenUDP(func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+		return lc.ListenPacket(context.Background(), network, laddr.String())
+	})
+	OverrideDialUDP(func(network string, laddr, raddr *net.UDPAddr) (net.PacketConn, error) {
+		ud := &net.Dialer{Control: control}
+		if nil != laddr {
+			ud.LocalAddr = laddr
+		}
+		c, err := ud.Dial(network, raddr.String())
+		if nil != err {
+			return nil, err
+		}
+		return c.(*net.UDPConn), nil
+	})
+}
+
 // Reset resets netx to its default settings
 func Reset() {
 	var d net.Dialer
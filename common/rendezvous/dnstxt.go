@@ -0,0 +1,139 @@
+package rendezvous
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// dnsTXTBackend keep each key as TXT record '<key>.<domain>', records are written by
+// cloudflare api and read by plain dns lookup if no token configured.
+type dnsTXTBackend struct {
+	domain string
+	zoneID string
+	token  string
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+type cloudflareResponse struct {
+	Success bool            `json:"success"`
+	Errors  json.RawMessage `json:"errors"`
+	Result  json.RawMessage `json:"result"`
+}
+
+func (d *dnsTXTBackend) call(method, path string, body interface{}, result interface{}) error {
+	var buf bytes.Buffer
+	if nil != body {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+"/zones/"+d.zoneID+path, &buf)
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := httpClient.Do(req)
+	if nil != err {
+		return err
+	}
+	defer res.Body.Close()
+	var cres cloudflareResponse
+	if err = json.NewDecoder(res.Body).Decode(&cres); nil != err {
+		return err
+	}
+	if !cres.Success {
+		return fmt.Errorf("cloudflare api error:%s", string(cres.Errors))
+	}
+	if nil != result {
+		return json.Unmarshal(cres.Result, result)
+	}
+	return nil
+}
+
+func (d *dnsTXTBackend) list(name string) ([]cloudflareRecord, error) {
+	var records []cloudflareRecord
+	err := d.call("GET", "/dns_records?type=TXT&name="+url.QueryEscape(name), nil, &records)
+	return records, err
+}
+
+// txtContent split the value into quoted strings of at most 255 bytes
+func txtContent(value []byte) string {
+	var parts []string
+	for len(value) > 0 {
+		n := len(value)
+		if n > 255 {
+			n = 255
+		}
+		parts = append(parts, "\""+string(value[:n])+"\"")
+		value = value[n:]
+	}
+	return strings.Join(parts, " ")
+}
+
+func (d *dnsTXTBackend) Put(key string, value []byte) error {
+	if len(d.token) == 0 || len(d.zoneID) == 0 {
+		return errors.New("cloudflare zone & token required to put")
+	}
+	name := key + "." + d.domain
+	records, err := d.list(name)
+	if nil != err {
+		return err
+	}
+	record := &cloudflareRecord{Type: "TXT", Name: name, Content: txtContent(value), TTL: 60}
+	if len(records) > 0 {
+		return d.call("PUT", "/dns_records/"+records[0].ID, record, nil)
+	}
+	return d.call("POST", "/dns_records", record, nil)
+}
+
+func (d *dnsTXTBackend) Get(key string) ([]byte, error) {
+	name := key + "." + d.domain
+	if len(d.token) > 0 && len(d.zoneID) > 0 {
+		records, err := d.list(name)
+		if nil != err || len(records) == 0 {
+			return nil, err
+		}
+		content := strings.Replace(records[0].Content, "\" \"", "", -1)
+		return []byte(strings.Trim(content, "\"")), nil
+	}
+	txts, err := net.LookupTXT(name)
+	if nil != err {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(txts) == 0 {
+		return nil, nil
+	}
+	return []byte(txts[0]), nil
+}
+
+func newDNSTXTBackend(u *url.URL) (Backend, error) {
+	if len(u.Host) == 0 {
+		return nil, errors.New("domain required")
+	}
+	return &dnsTXTBackend{
+		domain: u.Host,
+		zoneID: u.Query().Get("zone"),
+		token:  u.Query().Get("token"),
+	}, nil
+}
+
+func init() {
+	Register("dns", newDNSTXTBackend)
+}
@@ -0,0 +1,83 @@
+package rendezvous
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// gistBackend keep each key as a file of the gist
+type gistBackend struct {
+	id    string
+	token string
+}
+
+type gistFile struct {
+	Content string `json:"content"`
+}
+
+type gist struct {
+	Files map[string]*gistFile `json:"files"`
+}
+
+func (g *gistBackend) call(method string, body interface{}, result interface{}) error {
+	var buf bytes.Buffer
+	if nil != body {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, "https://api.github.com/gists/"+g.id, &buf)
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if len(g.token) > 0 {
+		req.Header.Set("Authorization", "token "+g.token)
+	}
+	res, err := httpClient.Do(req)
+	if nil != err {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("gist api error with status:%d", res.StatusCode)
+	}
+	if nil != result {
+		return json.NewDecoder(res.Body).Decode(result)
+	}
+	return nil
+}
+
+func (g *gistBackend) Put(key string, value []byte) error {
+	if len(g.token) == 0 {
+		return errors.New("gist token required to put")
+	}
+	return g.call("PATCH", &gist{Files: map[string]*gistFile{key: {Content: string(value)}}}, nil)
+}
+
+func (g *gistBackend) Get(key string) ([]byte, error) {
+	var v gist
+	if err := g.call("GET", nil, &v); nil != err {
+		return nil, err
+	}
+	if f, exist := v.Files[key]; exist && nil != f {
+		return []byte(f.Content), nil
+	}
+	return nil, nil
+}
+
+func newGistBackend(u *url.URL) (Backend, error) {
+	if len(u.Host) == 0 {
+		return nil, errors.New("gist id required")
+	}
+	return &gistBackend{id: u.Host, token: u.Query().Get("token")}, nil
+}
+
+func init() {
+	Register("gist", newGistBackend)
+}
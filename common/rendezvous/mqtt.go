@@ -0,0 +1,91 @@
+package rendezvous
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/yinqiwen/gsnova/common/helper"
+)
+
+const mqttTimeout = 5 * time.Second
+
+// mqttBackend keep values as retained messages on a public broker
+type mqttBackend struct {
+	client mqtt.Client
+	prefix string
+}
+
+func (m *mqttBackend) topic(key string) string {
+	return m.prefix + "/" + key
+}
+
+func (m *mqttBackend) Put(key string, value []byte) error {
+	token := m.client.Publish(m.topic(key), 1, true, value)
+	if !token.WaitTimeout(mqttTimeout) {
+		return errors.New("mqtt publish timeout")
+	}
+	return token.Error()
+}
+
+func (m *mqttBackend) Get(key string) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	topic := m.topic(key)
+	token := m.client.Subscribe(topic, 1, func(c mqtt.Client, msg mqtt.Message) {
+		select {
+		case ch <- msg.Payload():
+		default:
+		}
+	})
+	if !token.WaitTimeout(mqttTimeout) {
+		return nil, errors.New("mqtt subscribe timeout")
+	}
+	if nil != token.Error() {
+		return nil, token.Error()
+	}
+	defer m.client.Unsubscribe(topic)
+	select {
+	case v := <-ch:
+		return v, nil
+	case <-time.After(2 * time.Second):
+		//no retained message
+		return nil, nil
+	}
+}
+
+func newMQTTBackend(u *url.URL) (Backend, error) {
+	opts := mqtt.NewClientOptions()
+	scheme := "tcp"
+	if u.Scheme == "mqtts" {
+		scheme = "ssl"
+	}
+	opts.AddBroker(scheme + "://" + u.Host)
+	opts.SetClientID("gsnova-" + helper.RandHexString(8))
+	opts.SetAutoReconnect(true)
+	opts.SetConnectTimeout(mqttTimeout)
+	if nil != u.User {
+		opts.SetUsername(u.User.Username())
+		password, _ := u.User.Password()
+		opts.SetPassword(password)
+	}
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		return nil, errors.New("mqtt connect timeout")
+	}
+	if nil != token.Error() {
+		return nil, token.Error()
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if len(prefix) == 0 {
+		prefix = "gsnova"
+	}
+	return &mqttBackend{client: client, prefix: prefix}, nil
+}
+
+func init() {
+	Register("mqtt", newMQTTBackend)
+	Register("mqtts", newMQTTBackend)
+}
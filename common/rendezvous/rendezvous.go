@@ -0,0 +1,48 @@
+// Package rendezvous store small signaling records on third-party infrastructure,
+// so that P2SP peers could still coordinate when the gsnova server is unreachable.
+package rendezvous
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Backend keeps the latest value of each key, values must be printable text.
+type Backend interface {
+	Put(key string, value []byte) error
+	// Get return nil value if the key does not exist
+	Get(key string) ([]byte, error)
+}
+
+var backendFactories = make(map[string]func(u *url.URL) (Backend, error))
+var backends = make(map[string]Backend)
+var backendMutex sync.Mutex
+
+func Register(scheme string, f func(u *url.URL) (Backend, error)) {
+	backendFactories[scheme] = f
+}
+
+// New return the backend like 'mqtt://broker:1883/prefix', 'gist://<id>?token=xxx'
+// or 'dns://rdv.example.com?zone=<id>&token=xxx', same url share one backend.
+func New(rawurl string) (Backend, error) {
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+	if b, exist := backends[rawurl]; exist {
+		return b, nil
+	}
+	u, err := url.Parse(rawurl)
+	if nil != err {
+		return nil, err
+	}
+	f, exist := backendFactories[u.Scheme]
+	if !exist {
+		return nil, fmt.Errorf("Invalid rendezvous scheme:%s", u.Scheme)
+	}
+	b, err := f(u)
+	if nil != err {
+		return nil, err
+	}
+	backends[rawurl] = b
+	return b, nil
+}
@@ -0,0 +1,149 @@
+package rendezvous
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewBackend(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"gist://abc?token=x", true},
+		{"gist://", false},
+		{"dns://rdv.example.com?zone=z&token=x", true},
+		{"dns://", false},
+		{"ftp://example.com", false},
+		{"://bad", false},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.url); (nil == err) != tt.valid {
+			t.Errorf("%s expect valid:%v, but got err:%v", tt.url, tt.valid, err)
+		}
+	}
+	b1, _ := New("gist://shared")
+	b2, _ := New("gist://shared")
+	if b1 != b2 {
+		t.Errorf("expect same url share one backend")
+	}
+	if err := b1.Put("k", []byte("v")); nil == err {
+		t.Errorf("expect gist put rejected without token")
+	}
+}
+
+func TestTXTContent(t *testing.T) {
+	tests := []struct {
+		size  int
+		parts []int
+	}{
+		{0, nil},
+		{10, []int{10}},
+		{255, []int{255}},
+		{256, []int{255, 1}},
+		{600, []int{255, 255, 90}},
+	}
+	for _, tt := range tests {
+		content := txtContent([]byte(strings.Repeat("x", tt.size)))
+		var parts []int
+		if len(content) > 0 {
+			for _, p := range strings.Split(content, " ") {
+				if !strings.HasPrefix(p, "\"") || !strings.HasSuffix(p, "\"") {
+					t.Errorf("expect quoted part, but got %s", p)
+				}
+				parts = append(parts, len(p)-2)
+			}
+		}
+		if fmt.Sprint(parts) != fmt.Sprint(tt.parts) {
+			t.Errorf("%d bytes expect parts %v, but got %v", tt.size, tt.parts, parts)
+		}
+	}
+}
+
+// fakeCloudflare serve TXT records api of one zone
+type fakeCloudflare struct {
+	records map[string]cloudflareRecord
+	nextID  int
+	writes  int
+	mutex   sync.Mutex
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" || !strings.HasPrefix(r.URL.Path, "/zones/z1/dns_records") {
+		json.NewEncoder(w).Encode(&cloudflareResponse{Success: false, Errors: json.RawMessage(`["denied"]`)})
+		return
+	}
+	var result interface{}
+	switch r.Method {
+	case "GET":
+		rs := []cloudflareRecord{}
+		for _, rec := range f.records {
+			if rec.Name == r.URL.Query().Get("name") && rec.Type == r.URL.Query().Get("type") {
+				rs = append(rs, rec)
+			}
+		}
+		result = rs
+	case "POST", "PUT":
+		var rec cloudflareRecord
+		json.NewDecoder(r.Body).Decode(&rec)
+		if r.Method == "POST" {
+			f.nextID++
+			rec.ID = fmt.Sprintf("r%d", f.nextID)
+		} else {
+			rec.ID = r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		}
+		f.records[rec.ID] = rec
+		f.writes++
+	}
+	b, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(&cloudflareResponse{Success: true, Errors: json.RawMessage("[]"), Result: b})
+}
+
+func TestDNSTXTBackend(t *testing.T) {
+	api := &fakeCloudflare{records: make(map[string]cloudflareRecord)}
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+	defer func(url string) { cloudflareAPI = url }(cloudflareAPI)
+	cloudflareAPI = apiServer.URL
+
+	b, err := New("dns://rdv.example.com?zone=z1&token=secret")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if v, err := b.Get("offer-0"); nil != err || nil != v {
+		t.Fatalf("expect nil value of missing key, but got %q %v", v, err)
+	}
+	long := strings.Repeat("0123456789", 30)
+	for _, v := range []string{"first", long} {
+		if err = b.Put("offer-0", []byte(v)); nil != err {
+			t.Fatal(err)
+		}
+		got, err := b.Get("offer-0")
+		if nil != err || string(got) != v {
+			t.Errorf("expect value %q, but got %q %v", v, got, err)
+		}
+	}
+	//the record is updated in place
+	if len(api.records) != 1 || api.writes != 2 {
+		t.Errorf("expect one record written twice, but got %d records %d writes", len(api.records), api.writes)
+	}
+	if api.records["r1"].Name != "offer-0.rdv.example.com" || api.records["r1"].TTL != 60 {
+		t.Errorf("unexpected record:%+v", api.records["r1"])
+	}
+
+	denied, _ := New("dns://rdv.example.com?zone=z1&token=wrong")
+	if err = denied.Put("offer-0", []byte("x")); nil == err {
+		t.Errorf("expect api error with wrong token")
+	}
+	readonly, _ := New("dns://rdv.example.com")
+	if err = readonly.Put("offer-0", []byte("x")); nil == err {
+		t.Errorf("expect put rejected without zone & token")
+	}
+}
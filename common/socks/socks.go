@@ -65,6 +65,11 @@ type SocksRequest struct {
 	Password string
 	// The parsed contents of Username as a key–value mapping.
 	Args Args
+	// The SOCKS command, CONNECT or UDP ASSOCIATE.
+	Command byte
+	// True if Target is a domain name left to the proxy to resolve (socks5h/socks4a),
+	// false if the client resolved it already (socks5/socks4).
+	Domain bool
 }
 
 // SocksConn encapsulates a net.Conn and information associated with a SOCKS request.
@@ -83,6 +88,18 @@ func (conn *SocksConn) Version() string {
 	return "unknown"
 }
 
+// Scheme is the version with who resolves the target, like curl's 'socks5h' for proxy
+// resolved domains and 'socks5' for client resolved addresses.
+func (conn *SocksConn) Scheme() string {
+	if !conn.Req.Domain {
+		return conn.Version()
+	}
+	if conn.socksVersion == socks4Version {
+		return "socks4a"
+	}
+	return conn.Version() + "h"
+}
+
 // Send a message to the proxy client that access to the given address is
 // granted.
 // For SOCKS5, Addr is ignored, and "0.0.0.0:0" is always sent back for
@@ -96,6 +113,18 @@ func (conn *SocksConn) Grant(addr *net.TCPAddr) error {
 	return sendSocks5ResponseGranted(conn)
 }
 
+// IsUDPAssociate returns true if the client sent a SOCKS5 UDP ASSOCIATE
+// command, the connection should NOT be granted by Grant in this case.
+func (conn *SocksConn) IsUDPAssociate() bool {
+	return conn.socksVersion == socks5Version && conn.Req.Command == socksCmdUDP
+}
+
+// Send a message to the proxy client that the UDP association is granted,
+// addr is the UDP relay address the client should send datagrams to.
+func (conn *SocksConn) GrantUDP(addr *net.UDPAddr) error {
+	return sendSocks5ResponseWithAddr(conn, socksRepSucceeded, addr.IP, addr.Port)
+}
+
 // Send a message to the proxy client that access was rejected or failed.  This
 // sends back a "General Failure" error code.  RejectReason should be used if
 // more specific error reporting is desired.
@@ -198,6 +227,16 @@ func (ln *SocksListener) Accept() (net.Conn, error) {
 // 	}
 
 func NewSocksConn(c net.Conn) (*SocksConn, *bufio.Reader, error) {
+	return NewSocksConnWithAuth(c, nil)
+}
+
+// Authenticator verify the RFC 1929 username/password of SOCKS5 clients.
+type Authenticator func(username, password string) bool
+
+// NewSocksConnWithAuth is the same as NewSocksConn, except that SOCKS5 clients
+// must authenticate by username/password if auth is not nil, SOCKS4 clients
+// which could not send password are rejected.
+func NewSocksConnWithAuth(c net.Conn, auth Authenticator) (*SocksConn, *bufio.Reader, error) {
 	conn := new(SocksConn)
 	conn.Conn = c
 	bio := bufio.NewReader(c)
@@ -213,10 +252,15 @@ func NewSocksConn(c net.Conn) (*SocksConn, *bufio.Reader, error) {
 			conn.Close()
 			return nil, nil, err
 		}
+		if nil != auth {
+			sendSocks4aResponseRejected(conn)
+			conn.Close()
+			return nil, nil, newTemporaryNetError("AcceptSocks: SOCKS4 request rejected since authentication required")
+		}
 	} else if version == socks5Version {
 		conn.socksVersion = socks5Version
 		rw := bufio.NewReadWriter(bio, bufio.NewWriter(conn))
-		conn.Req, err = socks5Handshake(rw)
+		conn.Req, err = socks5Handshake(rw, auth)
 		if err != nil {
 			conn.Close()
 			return nil, nil, err
@@ -261,7 +305,7 @@ func (ln *SocksListener) AcceptSocks() (*SocksConn, error) {
 		}
 	} else if version == socks5Version {
 		conn.socksVersion = socks5Version
-		conn.Req, err = socks5Handshake(rw)
+		conn.Req, err = socks5Handshake(rw, nil)
 		if err != nil {
 			conn.Close()
 			return nil, err
@@ -289,16 +333,17 @@ func (ln *SocksListener) Version() string {
 
 // socks5handshake conducts the SOCKS5 handshake up to the point where the
 // client command is read and the proxy must open the outgoing connection.
-// Returns a SocksRequest.
-func socks5Handshake(rw *bufio.ReadWriter) (req SocksRequest, err error) {
+// Returns a SocksRequest. Username/password authentication is required if
+// auth is not nil.
+func socks5Handshake(rw *bufio.ReadWriter, auth Authenticator) (req SocksRequest, err error) {
 	// Negotiate the authentication method.
 	var method byte
-	if method, err = socks5NegotiateAuth(rw); err != nil {
+	if method, err = socks5NegotiateAuth(rw, nil != auth); err != nil {
 		return
 	}
 
 	// Authenticate the client.
-	if err = socks5Authenticate(rw, method, &req); err != nil {
+	if err = socks5Authenticate(rw, method, &req, auth); err != nil {
 		return
 	}
 
@@ -309,7 +354,8 @@ func socks5Handshake(rw *bufio.ReadWriter) (req SocksRequest, err error) {
 
 // socks5NegotiateAuth negotiates the authentication method and returns the
 // selected method as a byte.  On negotiation failures an error is returned.
-func socks5NegotiateAuth(rw *bufio.ReadWriter) (method byte, err error) {
+// Only Username/Password is acceptable if authRequired.
+func socks5NegotiateAuth(rw *bufio.ReadWriter, authRequired bool) (method byte, err error) {
 	// Validate the version.
 	if err = socksReadByteVerify(rw.Reader, "version", socks5Version); err != nil {
 		err = newTemporaryNetError("socks5NegotiateAuth: %s", err.Error())
@@ -337,7 +383,7 @@ func socks5NegotiateAuth(rw *bufio.ReadWriter) (method byte, err error) {
 		case socksAuthNoneRequired:
 			// Pick Username/Password over None if the client happens to
 			// send both.
-			if method == socksAuthNoAcceptableMethods {
+			if method == socksAuthNoAcceptableMethods && !authRequired {
 				method = m
 			}
 
@@ -364,13 +410,13 @@ func socks5NegotiateAuth(rw *bufio.ReadWriter) (method byte, err error) {
 
 // socks5Authenticate authenticates the client via the chosen authentication
 // mechanism.
-func socks5Authenticate(rw *bufio.ReadWriter, method byte, req *SocksRequest) (err error) {
+func socks5Authenticate(rw *bufio.ReadWriter, method byte, req *SocksRequest, auth Authenticator) (err error) {
 	switch method {
 	case socksAuthNoneRequired:
 		// Straight into reading the connect.
 
 	case socksAuthUsernamePassword:
-		if err = socks5AuthRFC1929(rw, req); err != nil {
+		if err = socks5AuthRFC1929(rw, req, auth); err != nil {
 			return
 		}
 
@@ -393,8 +439,8 @@ func socks5Authenticate(rw *bufio.ReadWriter, method byte, req *SocksRequest) (e
 // socks5AuthRFC1929 authenticates the client via RFC 1929 username/password
 // auth.  As a design decision any valid username/password is accepted as this
 // field is primarily used as an out-of-band argument passing mechanism for
-// pluggable transports.
-func socks5AuthRFC1929(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
+// pluggable transports, unless auth is not nil to verify the credentials.
+func socks5AuthRFC1929(rw *bufio.ReadWriter, req *SocksRequest, auth Authenticator) (err error) {
 	sendErrResp := func() {
 		// Swallow the write/flush error here, we are going to close the
 		// connection and the original failure is more useful.
@@ -449,12 +495,20 @@ func socks5AuthRFC1929(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 		req.Password = string(passwd)
 	}
 
-	// Mash the username/password together and parse it as a pluggable
-	// transport argument string.
-	if req.Args, err = parseClientParameters(req.Username + req.Password); err != nil {
-		sendErrResp()
-		err = newTemporaryNetError("socks5AuthRFC1929: failed to parse client parameters: %s", err)
-		return
+	if nil != auth {
+		if !auth(req.Username, req.Password) {
+			sendErrResp()
+			err = newTemporaryNetError("socks5AuthRFC1929: invalid username/password for user %q", req.Username)
+			return
+		}
+	} else {
+		// Mash the username/password together and parse it as a pluggable
+		// transport argument string.
+		if req.Args, err = parseClientParameters(req.Username + req.Password); err != nil {
+			sendErrResp()
+			err = newTemporaryNetError("socks5AuthRFC1929: failed to parse client parameters: %s", err)
+			return
+		}
 	}
 
 	// Write success response
@@ -467,7 +521,8 @@ func socks5AuthRFC1929(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 }
 
 // socks5ReadCommand reads a SOCKS5 client command and parses out the relevant
-// fields into a SocksRequest.  Only CMD_CONNECT is supported.
+// fields into a SocksRequest.  Only CMD_CONNECT and CMD_UDP_ASSOCIATE are
+// supported.
 func socks5ReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 	sendErrResp := func(reason byte) {
 		// Swallow errors that occur when writing/flushing the response,
@@ -482,9 +537,13 @@ func socks5ReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 		err = newTemporaryNetError("socks5ReadCommand: %s", err)
 		return
 	}
-	if err = socksReadByteVerify(rw.Reader, "command", socksCmdConnect); err != nil {
+	if req.Command, err = socksReadByte(rw.Reader); err != nil {
+		err = newTemporaryNetError("socks5ReadCommand: Failed to read command: %s", err)
+		return
+	}
+	if req.Command != socksCmdConnect && req.Command != socksCmdUDP {
 		sendErrResp(SocksRepCommandNotSupported)
-		err = newTemporaryNetError("socks5ReadCommand: %s", err)
+		err = newTemporaryNetError("socks5ReadCommand: SOCKS message field command was 0x%02x", req.Command)
 		return
 	}
 	if err = socksReadByteVerify(rw.Reader, "reserved", socksReserved); err != nil {
@@ -527,6 +586,7 @@ func socks5ReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 			return
 		}
 		host = string(addr)
+		req.Domain = true
 
 	case socksAtypeV6:
 		var rawAddr []byte
@@ -562,15 +622,26 @@ func socks5ReadCommand(rw *bufio.ReadWriter, req *SocksRequest) (err error) {
 // Send a SOCKS5 response with the given code. BND.ADDR/BND.PORT is always the
 // IPv4 address/port "0.0.0.0:0".
 func sendSocks5Response(w io.Writer, code byte) error {
-	resp := make([]byte, 4+4+2)
-	resp[0] = socks5Version
-	resp[1] = code
-	resp[2] = socksReserved
-	resp[3] = socksAtypeV4
-
 	// BND.ADDR/BND.PORT should be the address and port that the outgoing
 	// connection is bound to on the proxy, but Tor does not use this
 	// information, so all zeroes are sent.
+	return sendSocks5ResponseWithAddr(w, code, net.IPv4zero, 0)
+}
+
+// Send a SOCKS5 response with the given code and BND.ADDR/BND.PORT.
+func sendSocks5ResponseWithAddr(w io.Writer, code byte, ip net.IP, port int) error {
+	resp := make([]byte, 4, 4+net.IPv6len+2)
+	resp[0] = socks5Version
+	resp[1] = code
+	resp[2] = socksReserved
+	if ip4 := ip.To4(); ip4 != nil {
+		resp[3] = socksAtypeV4
+		resp = append(resp, ip4...)
+	} else {
+		resp[3] = socksAtypeV6
+		resp = append(resp, ip.To16()...)
+	}
+	resp = append(resp, byte(port>>8), byte(port))
 
 	if _, err := w.Write(resp[:]); err != nil {
 		err = newTemporaryNetError("sendSocks5Response: Failed write response: %s", err)
@@ -735,6 +806,7 @@ func readSocks4aConnect(r *bufio.Reader) (req SocksRequest, err error) {
 			return
 		}
 		host = string(hostBytes[:len(hostBytes)-1])
+		req.Domain = true
 	} else {
 		host = net.IPv4(rawHostIP[0], rawHostIP[1], rawHostIP[2], rawHostIP[3]).String()
 	}
@@ -0,0 +1,91 @@
+package socks
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+var ErrInvalidUDPDatagram = errors.New("Invalid socks5 udp datagram")
+var ErrUDPFragment = errors.New("Socks5 udp fragment not supported")
+
+// ParseUDPDatagram parses a SOCKS5 UDP request header:
+//
+//	+----+------+------+----------+----------+----------+
+//	|RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+//	+----+------+------+----------+----------+----------+
+//
+// and returns the destination as a "host:port" string and the payload.
+func ParseUDPDatagram(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, ErrInvalidUDPDatagram
+	}
+	if b[2] != 0 {
+		return "", nil, ErrUDPFragment
+	}
+	var host string
+	pos := 4
+	switch b[3] {
+	case socksAtypeV4:
+		if len(b) < pos+net.IPv4len+2 {
+			return "", nil, ErrInvalidUDPDatagram
+		}
+		host = net.IP(b[pos : pos+net.IPv4len]).String()
+		pos += net.IPv4len
+	case socksAtypeV6:
+		if len(b) < pos+net.IPv6len+2 {
+			return "", nil, ErrInvalidUDPDatagram
+		}
+		host = net.IP(b[pos : pos+net.IPv6len]).String()
+		pos += net.IPv6len
+	case socksAtypeDomainName:
+		if len(b) < pos+1 {
+			return "", nil, ErrInvalidUDPDatagram
+		}
+		alen := int(b[pos])
+		pos++
+		if alen == 0 || len(b) < pos+alen+2 {
+			return "", nil, ErrInvalidUDPDatagram
+		}
+		host = string(b[pos : pos+alen])
+		pos += alen
+	default:
+		return "", nil, ErrInvalidUDPDatagram
+	}
+	port := int(b[pos])<<8 | int(b[pos+1])
+	pos += 2
+	return net.JoinHostPort(host, strconv.Itoa(port)), b[pos:], nil
+}
+
+// BuildUDPDatagram builds a SOCKS5 UDP reply datagram for the given
+// "host:port" source address and payload.
+func BuildUDPDatagram(addr string, data []byte) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if nil != err {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if nil != err {
+		return nil, err
+	}
+	b := make([]byte, 0, 4+1+len(host)+2+len(data))
+	b = append(b, 0, 0, 0)
+	if ip := net.ParseIP(host); nil != ip {
+		if ip4 := ip.To4(); nil != ip4 {
+			b = append(b, socksAtypeV4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socksAtypeV6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, ErrInvalidUDPDatagram
+		}
+		b = append(b, socksAtypeDomainName, byte(len(host)))
+		b = append(b, host...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	b = append(b, data...)
+	return b, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (s *boltStore) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if nil == b {
+			return ErrNotFound
+		}
+		v := b.Get([]byte(key))
+		if nil == v {
+			return ErrNotFound
+		}
+		//value is only valid in the transaction
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) Put(bucket, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if nil != err {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (s *boltStore) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if nil == b {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) ForEach(bucket string, fn func(key string, value []byte) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if nil == b {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !fn(string(k), append([]byte(nil), v...)) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func NewBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if nil != err {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func init() {
+	registerStoreType("bolt", func(u *url.URL) (Store, error) {
+		return NewBoltStore(filePath(u))
+	})
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"net/url"
+	"sync"
+)
+
+type memoryStore struct {
+	buckets map[string]map[string][]byte
+	mutex   sync.Mutex
+}
+
+func (m *memoryStore) Get(bucket, key string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, exist := m.buckets[bucket]
+	if !exist {
+		return nil, ErrNotFound
+	}
+	v, exist := b[key]
+	if !exist {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (m *memoryStore) Put(bucket, key string, value []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, exist := m.buckets[bucket]
+	if !exist {
+		b = make(map[string][]byte)
+		m.buckets[bucket] = b
+	}
+	b[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *memoryStore) Delete(bucket, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if b, exist := m.buckets[bucket]; exist {
+		delete(b, key)
+	}
+	return nil
+}
+
+func (m *memoryStore) ForEach(bucket string, fn func(key string, value []byte) bool) error {
+	m.mutex.Lock()
+	b := make(map[string][]byte, len(m.buckets[bucket]))
+	for k, v := range m.buckets[bucket] {
+		b[k] = v
+	}
+	m.mutex.Unlock()
+	for k, v := range b {
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func init() {
+	registerStoreType("memory", func(u *url.URL) (Store, error) {
+		return NewMemoryStore(), nil
+	})
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"net/url"
+
+	"github.com/go-redis/redis"
+)
+
+const redisKeyPrefix = "gsnova:"
+
+// every bucket is stored as a redis hash
+type redisStore struct {
+	client *redis.Client
+}
+
+func (s *redisStore) Get(bucket, key string) ([]byte, error) {
+	v, err := s.client.HGet(redisKeyPrefix+bucket, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (s *redisStore) Put(bucket, key string, value []byte) error {
+	return s.client.HSet(redisKeyPrefix+bucket, key, value).Err()
+}
+
+func (s *redisStore) Delete(bucket, key string) error {
+	return s.client.HDel(redisKeyPrefix+bucket, key).Err()
+}
+
+func (s *redisStore) ForEach(bucket string, fn func(key string, value []byte) bool) error {
+	vals, err := s.client.HGetAll(redisKeyPrefix + bucket).Result()
+	if nil != err {
+		return err
+	}
+	for k, v := range vals {
+		if !fn(k, []byte(v)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+func NewRedisStore(redisURL string) (Store, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if nil != err {
+		return nil, err
+	}
+	client := redis.NewClient(opt)
+	if err = client.Ping().Err(); nil != err {
+		client.Close()
+		return nil, err
+	}
+	return &redisStore{client: client}, nil
+}
+
+func init() {
+	registerStoreType("redis", func(u *url.URL) (Store, error) {
+		return NewRedisStore(u.String())
+	})
+}
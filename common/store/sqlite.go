@@ -0,0 +1,75 @@
+package store
+
+import (
+	"database/sql"
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteStore) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow("SELECT v FROM kv WHERE b = ? AND k = ?", bucket, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *sqliteStore) Put(bucket, key string, value []byte) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO kv(b, k, v) VALUES(?, ?, ?)", bucket, key, value)
+	return err
+}
+
+func (s *sqliteStore) Delete(bucket, key string) error {
+	_, err := s.db.Exec("DELETE FROM kv WHERE b = ? AND k = ?", bucket, key)
+	return err
+}
+
+func (s *sqliteStore) ForEach(bucket string, fn func(key string, value []byte) bool) error {
+	rows, err := s.db.Query("SELECT k, v FROM kv WHERE b = ?", bucket)
+	if nil != err {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		var v []byte
+		if err = rows.Scan(&k, &v); nil != err {
+			return err
+		}
+		if !fn(k, v) {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func NewSQLiteStore(path string) (Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if nil != err {
+		return nil, err
+	}
+	//sqlite do NOT support concurrent writers
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS kv(b TEXT NOT NULL, k TEXT NOT NULL, v BLOB, PRIMARY KEY(b, k))")
+	if nil != err {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func init() {
+	registerStoreType("sqlite", func(u *url.URL) (Store, error) {
+		return NewSQLiteStore(filePath(u))
+	})
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var ErrNotFound = errors.New("Key not found")
+
+// Store is a simple bucketed key/value interface used to persist state
+// (quotas, bans, tickets, stats) across restarts.
+type Store interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	ForEach(bucket string, fn func(key string, value []byte) bool) error
+	Close() error
+}
+
+type creator func(u *url.URL) (Store, error)
+
+var storeTypeTable = make(map[string]creator)
+
+func registerStoreType(scheme string, c creator) {
+	storeTypeTable[scheme] = c
+}
+
+// Open creates a store by url, eg:
+//
+//	memory://
+//	bolt:///var/lib/gsnova/state.db
+//	sqlite:///var/lib/gsnova/state.sqlite
+//	redis://:password@127.0.0.1:6379/0
+func Open(storeURL string) (Store, error) {
+	if len(storeURL) == 0 {
+		return NewMemoryStore(), nil
+	}
+	u, err := url.Parse(storeURL)
+	if nil != err {
+		return nil, err
+	}
+	c, exist := storeTypeTable[strings.ToLower(u.Scheme)]
+	if !exist {
+		return nil, fmt.Errorf("No store registed for scheme:%s", u.Scheme)
+	}
+	return c(u)
+}
+
+func filePath(u *url.URL) string {
+	if len(u.Host) > 0 {
+		return u.Host + u.Path
+	}
+	return u.Path
+}
+
+var defaultStore Store = NewMemoryStore()
+var defaultStoreMutex sync.Mutex
+
+// Default returns the process wide store, it's an in-memory store unless
+// Init/SetDefault is called.
+func Default() Store {
+	defaultStoreMutex.Lock()
+	defer defaultStoreMutex.Unlock()
+	return defaultStore
+}
+
+func SetDefault(s Store) {
+	defaultStoreMutex.Lock()
+	defer defaultStoreMutex.Unlock()
+	if nil != defaultStore && s != defaultStore {
+		defaultStore.Close()
+	}
+	defaultStore = s
+}
+
+func Init(storeURL string) error {
+	s, err := Open(storeURL)
+	if nil != err {
+		return err
+	}
+	SetDefault(s)
+	return nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// testStoreContract check the behaviour every backend must provide
+func testStoreContract(t *testing.T, s Store) {
+	if _, err := s.Get("b", "missing"); err != ErrNotFound {
+		t.Fatalf("missing key should be ErrNotFound, but got %v", err)
+	}
+	for _, kv := range []struct{ bucket, key, value string }{
+		{"b", "k1", "v1"},
+		{"b", "k2", "v2"},
+		{"other", "k1", "o1"},
+		{"b", "k1", "v1-updated"},
+	} {
+		if err := s.Put(kv.bucket, kv.key, []byte(kv.value)); nil != err {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct{ bucket, key, value string }{
+		{"b", "k1", "v1-updated"},
+		{"b", "k2", "v2"},
+		{"other", "k1", "o1"},
+	} {
+		if v, err := s.Get(c.bucket, c.key); nil != err || string(v) != c.value {
+			t.Errorf("get %s/%s got %q %v, expected %q", c.bucket, c.key, v, err, c.value)
+		}
+	}
+	var keys []string
+	if err := s.ForEach("b", func(key string, value []byte) bool {
+		keys = append(keys, key+"="+string(value))
+		return true
+	}); nil != err {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "k1=v1-updated" || keys[1] != "k2=v2" {
+		t.Errorf("unexpected keys of bucket:%v", keys)
+	}
+	visited := 0
+	s.ForEach("b", func(key string, value []byte) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("iteration should stop once fn return false, visited:%d", visited)
+	}
+	if err := s.Delete("b", "k1"); nil != err {
+		t.Fatal(err)
+	}
+	if err := s.Delete("missing", "k1"); nil != err {
+		t.Errorf("delete of missing bucket should be ignored, but got %v", err)
+	}
+	if _, err := s.Get("b", "k1"); err != ErrNotFound {
+		t.Errorf("deleted key should be ErrNotFound, but got %v", err)
+	}
+	if v, err := s.Get("other", "k1"); nil != err || string(v) != "o1" {
+		t.Errorf("keys of other buckets should be kept, got %q %v", v, err)
+	}
+}
+
+func TestStoreBackends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsnova-store")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	urls := []string{
+		"",
+		"memory://",
+		"bolt://" + filepath.Join(dir, "state.db"),
+		"sqlite://" + filepath.Join(dir, "state.sqlite"),
+	}
+	//redis backend is only tested with a server like 'redis://127.0.0.1:6379/15'
+	if u := os.Getenv("GSNOVA_TEST_REDIS"); len(u) > 0 {
+		urls = append(urls, u)
+	}
+	for _, u := range urls {
+		s, err := Open(u)
+		if nil != err {
+			t.Fatalf("open %q failed:%v", u, err)
+		}
+		t.Run(u, func(t *testing.T) {
+			testStoreContract(t, s)
+		})
+		s.Close()
+	}
+}
+
+func TestStorePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsnova-store")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, u := range []string{"bolt://" + filepath.Join(dir, "state.db"), "sqlite://" + filepath.Join(dir, "state.sqlite")} {
+		s, err := Open(u)
+		if nil != err {
+			t.Fatal(err)
+		}
+		s.Put("quota", "alice", []byte("100"))
+		s.Close()
+		if s, err = Open(u); nil != err {
+			t.Fatal(err)
+		}
+		if v, err := s.Get("quota", "alice"); nil != err || string(v) != "100" {
+			t.Errorf("%s should keep values after reopen, got %q %v", u, v, err)
+		}
+		s.Close()
+	}
+}
+
+func TestOpenUnknownScheme(t *testing.T) {
+	if _, err := Open("etcd://127.0.0.1:2379"); nil == err {
+		t.Fatalf("unknown scheme should fail")
+	}
+}
@@ -0,0 +1,175 @@
+package supervisor
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+const (
+	workerEnv    = "GSNOVA_SUPERVISED_WORKER"
+	listenersEnv = "GSNOVA_INHERITED_LISTENERS"
+
+	minRestartBackoff = 1 * time.Second
+	maxRestartBackoff = 60 * time.Second
+	//a worker alive longer than this resets the backoff
+	stableRunPeriod = 60 * time.Second
+)
+
+var inheritedListeners map[string]*os.File
+
+// IsWorker return true if current process is launched by a supervisor.
+func IsWorker() bool {
+	return len(os.Getenv(workerEnv)) > 0
+}
+
+// parseListenerFds parse the listeners passed by supervisor like 'addr=fd,addr=fd'
+func parseListenerFds(desc string) map[string]int {
+	fds := make(map[string]int)
+	for _, item := range strings.Split(desc, ",") {
+		idx := strings.LastIndex(item, "=")
+		if idx <= 0 {
+			continue
+		}
+		fd, err := strconv.Atoi(item[idx+1:])
+		if nil != err {
+			continue
+		}
+		fds[item[0:idx]] = fd
+	}
+	return fds
+}
+
+func init() {
+	if !IsWorker() {
+		return
+	}
+	inheritedListeners = make(map[string]*os.File)
+	for addr, fd := range parseListenerFds(os.Getenv(listenersEnv)) {
+		inheritedListeners[addr] = os.NewFile(uintptr(fd), "listener:"+addr)
+	}
+}
+
+// Listen return the listener inherited from supervisor if exist, otherwise create a new one.
+func Listen(network, addr string) (net.Listener, error) {
+	if f, exist := inheritedListeners[addr]; exist && strings.HasPrefix(network, "tcp") {
+		lp, err := net.FileListener(f)
+		if nil == err {
+			logger.Info("Use inherited listener on address:%s", addr)
+			return lp, nil
+		}
+		logger.Error("[ERROR]Failed to use inherited listener on %s for reason:%v", addr, err)
+	}
+	return net.Listen(network, addr)
+}
+
+func ListenTCP(addr string) (*net.TCPListener, error) {
+	lp, err := Listen("tcp", addr)
+	if nil != err {
+		return nil, err
+	}
+	tcpLp, ok := lp.(*net.TCPListener)
+	if !ok {
+		lp.Close()
+		return nil, fmt.Errorf("Not tcp listener on address:%s", addr)
+	}
+	return tcpLp, nil
+}
+
+// nextRestartBackoff return the delay before restarting a worker exited after running 'ran', and the
+// backoff for the next restart
+func nextRestartBackoff(backoff time.Duration, ran time.Duration) (time.Duration, time.Duration) {
+	if ran > stableRunPeriod {
+		backoff = minRestartBackoff
+	}
+	next := backoff * 2
+	if next > maxRestartBackoff {
+		next = maxRestartBackoff
+	}
+	return backoff, next
+}
+
+// Run start current executable as worker, and restart it with exponential backoff when it exits abnormally.
+// The tcp listeners of given addresses are created once and passed to every worker.
+func Run(listenAddrs []string) error {
+	path, err := os.Executable()
+	if nil != err {
+		return err
+	}
+	var files []*os.File
+	var listenerDesc []string
+	for _, addr := range listenAddrs {
+		lp, err := net.Listen("tcp", addr)
+		if nil != err {
+			logger.Error("[ERROR]Supervisor failed to listen on %s for reason:%v", addr, err)
+			continue
+		}
+		f, err := lp.(*net.TCPListener).File()
+		lp.Close()
+		if nil != err {
+			return err
+		}
+		files = append(files, f)
+		listenerDesc = append(listenerDesc, fmt.Sprintf("%s=%d", addr, 2+len(files)))
+	}
+	if !supportInheritListeners() {
+		files = nil
+		listenerDesc = nil
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	//config reload signal is forwarded to the worker
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	backoff := minRestartBackoff
+	for {
+		cmd := exec.Command(path, os.Args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.ExtraFiles = files
+		cmd.Env = append(os.Environ(), workerEnv+"=1", listenersEnv+"="+strings.Join(listenerDesc, ","))
+		start := time.Now()
+		if err := cmd.Start(); nil != err {
+			return err
+		}
+		logger.Notice("Supervisor started worker:%d", cmd.Process.Pid)
+		exitCh := make(chan error, 1)
+		go func() {
+			exitCh <- cmd.Wait()
+		}()
+	WAIT:
+		select {
+		case sig := <-hupCh:
+			cmd.Process.Signal(sig)
+			goto WAIT
+		case sig := <-sigCh:
+			logger.Notice("Supervisor recv signal:%v, stop worker:%d", sig, cmd.Process.Pid)
+			cmd.Process.Signal(sig)
+			<-exitCh
+			return nil
+		case err = <-exitCh:
+		}
+		if nil == err {
+			logger.Notice("Worker exit normally.")
+			return nil
+		}
+		var wait time.Duration
+		wait, backoff = nextRestartBackoff(backoff, time.Now().Sub(start))
+		logger.Error("[ERROR]Worker exit with reason:%v, restart after %v", err, wait)
+		select {
+		case <-time.After(wait):
+		case <-sigCh:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package supervisor
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseListenerFds(t *testing.T) {
+	for _, c := range []struct {
+		desc     string
+		expected map[string]int
+	}{
+		{"", map[string]int{}},
+		{"127.0.0.1:48100=3", map[string]int{"127.0.0.1:48100": 3}},
+		{":443=3,[::1]:8080=4", map[string]int{":443": 3, "[::1]:8080": 4}},
+		{"=3,:80=x,:81,:82=5", map[string]int{":82": 5}},
+	} {
+		if fds := parseListenerFds(c.desc); !reflect.DeepEqual(fds, c.expected) {
+			t.Errorf("parse %q got %v, expected %v", c.desc, fds, c.expected)
+		}
+	}
+}
+
+func TestNextRestartBackoff(t *testing.T) {
+	for _, c := range []struct {
+		backoff, ran time.Duration
+		wait, next   time.Duration
+	}{
+		{time.Second, time.Second, time.Second, 2 * time.Second},
+		{8 * time.Second, time.Second, 8 * time.Second, 16 * time.Second},
+		{40 * time.Second, time.Second, 40 * time.Second, maxRestartBackoff},
+		{maxRestartBackoff, time.Second, maxRestartBackoff, maxRestartBackoff},
+		//worker ran stably restarts quickly
+		{maxRestartBackoff, 2 * stableRunPeriod, minRestartBackoff, 2 * minRestartBackoff},
+	} {
+		wait, next := nextRestartBackoff(c.backoff, c.ran)
+		if wait != c.wait || next != c.next {
+			t.Errorf("backoff %v after running %v got %v/%v, expected %v/%v", c.backoff, c.ran, wait, next, c.wait, c.next)
+		}
+	}
+}
+
+func TestListenInherited(t *testing.T) {
+	if !supportInheritListeners() {
+		t.Skip("listeners are not inherited on this platform")
+	}
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	addr := lp.Addr().String()
+	f, err := lp.(*net.TCPListener).File()
+	lp.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+	inheritedListeners = map[string]*os.File{addr: f}
+	defer func() {
+		inheritedListeners = nil
+	}()
+	//the socket is still listening since the fd is duplicated
+	inherited, err := ListenTCP(addr)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	go func() {
+		if c, err := inherited.Accept(); nil == err {
+			c.Write([]byte("ok"))
+			c.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", addr)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 2)
+	if _, err = conn.Read(b); nil != err || string(b) != "ok" {
+		t.Errorf("inherited listener not served, got %q, %v", b, err)
+	}
+}
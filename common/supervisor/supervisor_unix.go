@@ -0,0 +1,7 @@
+// +build !windows
+
+package supervisor
+
+func supportInheritListeners() bool {
+	return true
+}
@@ -0,0 +1,8 @@
+// +build windows
+
+package supervisor
+
+//exec.Cmd.ExtraFiles is not supported on windows
+func supportInheritListeners() bool {
+	return false
+}
@@ -0,0 +1,338 @@
+package local
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// AccelerateConfig split large range supported plain http downloads across multiple streams
+type AccelerateConfig struct {
+	Enable bool
+	Host   []string
+	URL    []string
+	//url path suffixes like '.iso', empty matches all
+	Suffix []string
+	//downloads smaller than 'MinSize' are not splitted, default 4M
+	MinSize   string
+	ChunkSize string
+	Streams   int
+	//channels used round robin by download streams, default the channel selected by PAC
+	Channels []string
+}
+
+const (
+	defaultAccelerateMinSize   = 4 * 1024 * 1024
+	defaultAccelerateChunkSize = 1024 * 1024
+	defaultAccelerateStreams   = 4
+)
+
+var errRangeNotSupported = errors.New("range request not supported")
+
+func (acc *AccelerateConfig) match(req *http.Request) bool {
+	if !acc.Enable || req.Method != "GET" || len(req.Header.Get("Range")) > 0 {
+		return false
+	}
+	host := req.Host
+	if strings.Contains(host, ":") {
+		host, _, _ = net.SplitHostPort(host)
+	}
+	if !MatchPatterns(host, acc.Host) || !MatchPatterns(req.URL.String(), acc.URL) {
+		return false
+	}
+	if len(acc.Suffix) == 0 {
+		return true
+	}
+	path := strings.ToLower(req.URL.Path)
+	for _, suffix := range acc.Suffix {
+		if strings.HasSuffix(path, strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (acc *AccelerateConfig) sizes() (minSize, chunkSize int64, streams int) {
+	minSize, chunkSize, streams = defaultAccelerateMinSize, defaultAccelerateChunkSize, acc.Streams
+	if v, err := helper.ToBytes(acc.MinSize); len(acc.MinSize) > 0 && nil == err {
+		minSize = int64(v)
+	}
+	if v, err := helper.ToBytes(acc.ChunkSize); len(acc.ChunkSize) > 0 && nil == err && v > 0 {
+		chunkSize = int64(v)
+	}
+	if streams <= 0 {
+		streams = defaultAccelerateStreams
+	}
+	return
+}
+
+type rangeDownloader struct {
+	req      *http.Request
+	addr     string
+	channels []string
+	next     uint32
+}
+
+func (d *rangeDownloader) channel() string {
+	n := atomic.AddUint32(&d.next, 1)
+	return d.channels[int(n)%len(d.channels)]
+}
+
+// fetch issue the range request on a new stream, the caller must close the returned body
+func (d *rangeDownloader) fetch(start, end int64) (*http.Response, error) {
+	name := d.channel()
+	stream, conf, err := channel.GetMuxStreamByChannel(name)
+	if nil != err {
+		return nil, err
+	}
+	opt := mux.StreamOptions{
+		DialTimeout: conf.RemoteDialMSTimeout,
+		Hops:        conf.Hops,
+	}
+	if err = stream.Connect("tcp", d.addr, opt); nil != err {
+		stream.Close()
+		return nil, err
+	}
+	reader, writer := mux.GetCompressStreamReaderWriter(stream, conf.Compressor)
+	rreq := new(http.Request)
+	*rreq = *d.req
+	rreq.Header = make(http.Header)
+	for k, v := range d.req.Header {
+		rreq.Header[k] = v
+	}
+	rreq.Header.Del("Proxy-Connection")
+	rreq.Header.Del("Proxy-Authorization")
+	rreq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	rreq.Close = true
+	if err = rreq.Write(writer); nil != err {
+		stream.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(reader), rreq)
+	if nil != err {
+		stream.Close()
+		return nil, err
+	}
+	resp.Body = &streamBody{resp.Body, stream}
+	return resp, nil
+}
+
+func (d *rangeDownloader) fetchChunk(start, end int64) ([]byte, error) {
+	var err error
+	//retry once, may be via another channel
+	for i := 0; i < 2; i++ {
+		var resp *http.Response
+		resp, err = d.fetch(start, end)
+		if nil != err {
+			continue
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			err = errRangeNotSupported
+			continue
+		}
+		buf := make([]byte, end-start+1)
+		_, err = io.ReadFull(resp.Body, buf)
+		resp.Body.Close()
+		if nil == err {
+			return buf, nil
+		}
+	}
+	return nil, err
+}
+
+type streamBody struct {
+	io.ReadCloser
+	stream mux.MuxStream
+}
+
+func (b *streamBody) Close() error {
+	b.ReadCloser.Close()
+	return b.stream.Close()
+}
+
+type joinedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *joinedBody) Close() error {
+	for _, c := range b.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// parseContentRange return the total size in 'Content-Range: bytes 0-1023/4096'
+func parseContentRange(v string) int64 {
+	idx := strings.LastIndex(v, "/")
+	if idx < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(v[idx+1:], 10, 64)
+	if nil != err {
+		return -1
+	}
+	return total
+}
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// chunkedBody read chunks downloaded by parallel streams in order
+type chunkedBody struct {
+	first   []byte
+	results []chan chunkResult
+	idx     int
+	current []byte
+	closed  chan struct{}
+	once    sync.Once
+	tokens  chan bool
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	for len(b.current) == 0 {
+		if nil != b.first {
+			b.current, b.first = b.first, nil
+			continue
+		}
+		if b.idx >= len(b.results) {
+			return 0, io.EOF
+		}
+		res := <-b.results[b.idx]
+		b.idx++
+		//let one more chunk be downloaded
+		<-b.tokens
+		if nil != res.err {
+			return 0, res.err
+		}
+		b.current = res.data
+	}
+	n := copy(p, b.current)
+	b.current = b.current[n:]
+	return n, nil
+}
+
+func (b *chunkedBody) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
+
+// tryAccelerateDownload serve the request by parallel range requests if it's a large download, return false if it's not handled
+func tryAccelerateDownload(req *http.Request, addr string, proxyChannelName string, acc *AccelerateConfig, w io.Writer) (bool, error) {
+	minSize, chunkSize, streams := acc.sizes()
+	d := &rangeDownloader{req: req, addr: addr, channels: acc.Channels}
+	if len(d.channels) == 0 {
+		d.channels = []string{proxyChannelName}
+	}
+	resp, err := d.fetch(0, chunkSize-1)
+	if nil != err {
+		logger.Debug("Failed to probe range download for %s with reason:%v", req.URL, err)
+		return false, nil
+	}
+	total := int64(-1)
+	if resp.StatusCode == http.StatusPartialContent {
+		total = parseContentRange(resp.Header.Get("Content-Range"))
+	}
+	if total < minSize || total <= chunkSize {
+		//not worth to split, just relay the probe response
+		if resp.StatusCode == http.StatusPartialContent && total > 0 {
+			if total > chunkSize {
+				//the probe has the first chunk only, the rest is fetched by one range request
+				rest, err := d.fetch(chunkSize, total-1)
+				if nil == err && rest.StatusCode != http.StatusPartialContent {
+					rest.Body.Close()
+					err = errRangeNotSupported
+				}
+				if nil != err {
+					//nothing written yet, let the original request go through
+					resp.Body.Close()
+					logger.Debug("Failed to fetch rest of %s with reason:%v", req.URL, err)
+					return false, nil
+				}
+				resp.Body = &joinedBody{io.MultiReader(resp.Body, rest.Body), []io.Closer{resp.Body, rest.Body}}
+			}
+			resp.StatusCode = http.StatusOK
+			resp.Status = "200 OK"
+			resp.Header.Del("Content-Range")
+			resp.ContentLength = total
+		}
+		//Write would close the body
+		resp.Close = resp.ContentLength < 0
+		if err = resp.Write(w); nil == err && resp.Close {
+			//body is delimited by connection close
+			err = io.EOF
+		}
+		return true, err
+	}
+	first := make([]byte, chunkSize)
+	_, err = io.ReadFull(resp.Body, first)
+	resp.Body.Close()
+	if nil != err {
+		return true, err
+	}
+
+	count := int((total - chunkSize + chunkSize - 1) / chunkSize)
+	body := &chunkedBody{
+		first:   first,
+		results: make([]chan chunkResult, count),
+		closed:  make(chan struct{}),
+		tokens:  make(chan bool, streams*2),
+	}
+	for i := range body.results {
+		body.results[i] = make(chan chunkResult, 1)
+	}
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < count; i++ {
+			//limit the buffered chunks not written to client
+			select {
+			case body.tokens <- true:
+			case <-body.closed:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-body.closed:
+				return
+			}
+		}
+	}()
+	for i := 0; i < streams; i++ {
+		go func() {
+			for idx := range jobs {
+				start := int64(idx+1) * chunkSize
+				end := start + chunkSize - 1
+				if end >= total {
+					end = total - 1
+				}
+				data, err := d.fetchChunk(start, end)
+				body.results[idx] <- chunkResult{data, err}
+			}
+		}()
+	}
+	defer body.Close()
+	logger.Notice("Accelerate download %s with %d bytes by %d streams.", req.URL, total, streams)
+	resp.StatusCode = http.StatusOK
+	resp.Status = "200 OK"
+	resp.Header.Del("Content-Range")
+	resp.ContentLength = total
+	resp.Close = false
+	resp.Body = body
+	return true, resp.Write(w)
+}
@@ -0,0 +1,139 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+)
+
+func TestAccelerateMatch(t *testing.T) {
+	acc := &AccelerateConfig{Enable: true, Host: []string{"*.example.com"}, Suffix: []string{".ISO", ".zip"}}
+	tests := []struct {
+		method string
+		url    string
+		rng    string
+		match  bool
+	}{
+		{"GET", "http://dl.example.com/a.iso", "", true},
+		{"GET", "http://dl.example.com:8080/b.ZIP", "", true},
+		{"GET", "http://dl.example.com/c.txt", "", false},
+		{"GET", "http://dl.other.com/a.iso", "", false},
+		{"POST", "http://dl.example.com/a.iso", "", false},
+		{"GET", "http://dl.example.com/a.iso", "bytes=0-100", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		if len(tt.rng) > 0 {
+			req.Header.Set("Range", tt.rng)
+		}
+		if m := acc.match(req); m != tt.match {
+			t.Errorf("%s %s range:%q expect match:%v, but got %v", tt.method, tt.url, tt.rng, tt.match, m)
+		}
+	}
+	acc.Enable = false
+	if acc.match(httptest.NewRequest("GET", "http://dl.example.com/a.iso", nil)) {
+		t.Errorf("disabled accelerator should match nothing")
+	}
+}
+
+func TestAccelerateSizes(t *testing.T) {
+	tests := []struct {
+		acc                AccelerateConfig
+		minSize, chunkSize int64
+		streams            int
+	}{
+		{AccelerateConfig{}, defaultAccelerateMinSize, defaultAccelerateChunkSize, defaultAccelerateStreams},
+		{AccelerateConfig{MinSize: "1M", ChunkSize: "256K", Streams: 8}, 1024 * 1024, 256 * 1024, 8},
+		{AccelerateConfig{MinSize: "0", ChunkSize: "0", Streams: -1}, defaultAccelerateMinSize, defaultAccelerateChunkSize, defaultAccelerateStreams},
+		{AccelerateConfig{MinSize: "bad", ChunkSize: "bad"}, defaultAccelerateMinSize, defaultAccelerateChunkSize, defaultAccelerateStreams},
+	}
+	for _, tt := range tests {
+		minSize, chunkSize, streams := tt.acc.sizes()
+		if minSize != tt.minSize || chunkSize != tt.chunkSize || streams != tt.streams {
+			t.Errorf("%+v expect sizes %d/%d/%d, but got %d/%d/%d", tt.acc, tt.minSize, tt.chunkSize, tt.streams, minSize, chunkSize, streams)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := map[string]int64{
+		"bytes 0-1023/4096": 4096,
+		"bytes 0-0/1":       1,
+		"bytes 0-1023/*":    -1,
+		"":                  -1,
+	}
+	for v, total := range tests {
+		if n := parseContentRange(v); n != total {
+			t.Errorf("%q expect total %d, but got %d", v, total, n)
+		}
+	}
+}
+
+func TestTryAccelerateDownload(t *testing.T) {
+	conf := channel.ProxyChannelConfig{
+		Name:               channel.DirectChannelName,
+		Enable:             true,
+		ConnsPerServer:     1,
+		LocalDialMSTimeout: 5000,
+		ServerList:         []string{"direct://0.0.0.0:0"},
+	}
+	conf.Adjust()
+	if !channel.NewProxyChannel(&conf).Init(true) {
+		t.Fatal("failed to init direct channel")
+	}
+	defer channel.StopLocalChannels()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 640)
+	sizes := map[string]int{"/large": len(content), "/medium": 3000, "/small": 500}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/norange" {
+			w.Write(content)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content[:sizes[r.URL.Path]]))
+	}))
+	defer origin.Close()
+	addr := strings.TrimPrefix(origin.URL, "http://")
+	acc := &AccelerateConfig{Enable: true, MinSize: "4K", ChunkSize: "1K", Streams: 3}
+	tests := []struct {
+		path string
+		size int
+	}{
+		//splitted to 10 chunks
+		{"/large", len(content)},
+		//below min size, the rest after the probe chunk is fetched by one request
+		{"/medium", 3000},
+		//within the probe chunk
+		{"/small", 500},
+		//relayed as is
+		{"/norange", len(content)},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", origin.URL+tt.path, nil)
+		var buf bytes.Buffer
+		handled, err := tryAccelerateDownload(req, addr, channel.DirectChannelName, acc, &buf)
+		if !handled {
+			t.Errorf("%s expect handled, but got err:%v", tt.path, err)
+			continue
+		}
+		res, err := http.ReadResponse(bufio.NewReader(&buf), req)
+		if nil != err {
+			t.Errorf("%s read response failed:%v", tt.path, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK || len(res.Header.Get("Content-Range")) > 0 {
+			t.Errorf("%s expect plain 200 response, but got %d %v", tt.path, res.StatusCode, res.Header)
+		}
+		if !bytes.Equal(body, content[:tt.size]) {
+			t.Errorf("%s expect %d bytes content, but got %d bytes", tt.path, tt.size, len(body))
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package local
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"net/http"
 	//_ "net/http/pprof"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/yinqiwen/gotoolkit/iotools"
@@ -17,10 +20,29 @@ import (
 	"github.com/yinqiwen/gsnova/common/channel"
 	"github.com/yinqiwen/gsnova/common/helper"
 	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
 	"github.com/yinqiwen/gsnova/common/netx"
 )
 
 var httpDumpLog *iotools.RotateFile
+var adminServerStarted int32
+
+// adminAuth reject requests without the 'Admin.Token', the api is disabled if no token configured
+func adminAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expected := GConf.Admin.Token
+		if len(expected) == 0 {
+			http.Error(w, "no 'Token' configured in 'Admin'", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
 
 func getConfigList(w http.ResponseWriter, r *http.Request) {
 	var confs []string
@@ -41,6 +63,7 @@ func statCallback(w http.ResponseWriter, r *http.Request) {
 	//fmt.Fprintf(w, "NumSession: %d\n", getProxySessionSize())
 	ots.Handle("stat", w)
 	fmt.Fprintf(w, "RunningProxyStreamNum: %d\n", runningProxyStreamCount)
+	mux.DumpCompressStats(w)
 	channel.DumpLoaclChannelStat(w)
 }
 func stackdumpCallback(w http.ResponseWriter, req *http.Request) {
@@ -69,6 +92,10 @@ func startAdminServer() {
 	if len(GConf.Admin.Listen) == 0 {
 		return
 	}
+	if !atomic.CompareAndSwapInt32(&adminServerStarted, 0, 1) {
+		//already started before config reload
+		return
+	}
 	if len(GConf.Admin.ConfigDir) == 0 {
 		logger.Error("[WARN]The ConfigDir's Dir is empty, use current dir instead")
 		GConf.Admin.ConfigDir = "./"
@@ -110,6 +137,21 @@ func startAdminServer() {
 	mux.HandleFunc("/gc", gcCallback)
 	mux.HandleFunc("/memdump", memdumpCallback)
 	mux.HandleFunc("/httpdump", httpDumpCallback)
+	mux.HandleFunc("/dashboard", dashboardCallback)
+	mux.HandleFunc("/api/dashboard", dashboardStatCallback)
+	mux.HandleFunc("/api/pacmode", adminAuth(pacModeCallback))
+	mux.HandleFunc("/api/reload", adminAuth(reloadCallback))
+	mux.HandleFunc("/api/reload/hot", adminAuth(hotReloadCallback))
+	mux.HandleFunc("/api/dnscache", dnsCacheCallback)
+	mux.HandleFunc("/api/dnscache/flush", adminAuth(dnsCacheFlushCallback))
+	mux.HandleFunc("/api/route/explain", adminAuth(routeExplainCallback))
+	mux.HandleFunc("/api/route/flush", adminAuth(routeFlushCallback))
+	mux.HandleFunc("/api/rules", ruleDBsCallback)
+	mux.HandleFunc("/api/rules/reload", adminAuth(ruleDBsReloadCallback))
+	mux.HandleFunc("/api/quota", quotaCallback)
+	mux.HandleFunc("/api/history", historyCallback)
+	mux.HandleFunc("/api/speedtest", speedTestCallback)
+	mux.HandleFunc("/api/leaks", leakAuditCallback)
 	err := http.ListenAndServe(GConf.Admin.Listen, mux)
 	if nil != err {
 		logger.Error("Failed to start config store server:%v", err)
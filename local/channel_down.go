@@ -0,0 +1,68 @@
+package local
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+const (
+	channelDownReject = "reject"
+	channelDownPause  = "pause"
+)
+
+func (cfg *ProxyConfig) pauseWhenDown() bool {
+	return strings.EqualFold(cfg.WhenChannelDown, channelDownPause)
+}
+
+// rejectByChannelDown return true if connections to the host should fail fast since its proxy channel is down
+func (cfg *ProxyConfig) rejectByChannelDown(protocol, host, port string) bool {
+	if !strings.EqualFold(cfg.WhenChannelDown, channelDownReject) {
+		return false
+	}
+	name := cfg.getProxyChannelByHost(protocol, host, port)
+	return len(name) > 0 && name != channel.DirectChannelName && channel.IsChannelDown(name)
+}
+
+// pauseOnChannelDown close the listener while all proxy channels are down, the accept loop listen again once recovered
+func pauseOnChannelDown(proxyIdx int, paused *int32) {
+	for proxyServerRunning {
+		time.Sleep(time.Second)
+		if atomic.LoadInt32(paused) == 0 && channel.AllProxyChannelsDown() {
+			atomic.StoreInt32(paused, 1)
+			logger.Notice("Pause listening on %s since all proxy channels are down", GConf.Proxy[proxyIdx].Local)
+			if lp := getRunningServer(proxyIdx); nil != lp {
+				lp.Close()
+			}
+		}
+	}
+}
+
+// resumeListen wait any proxy channel recovered & listen again, nil if the server stopped
+func resumeListen(proxyIdx int, paused *int32) *net.TCPListener {
+	proxyConf := &GConf.Proxy[proxyIdx]
+	for proxyServerRunning {
+		if channel.AllProxyChannelsDown() {
+			time.Sleep(time.Second)
+			continue
+		}
+		lp, err := listenLocalProxy(proxyConf)
+		if nil != err {
+			logger.Error("[ERROR]Failed to resume listening on %s for reason:%v", proxyConf.Local, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if !setRunningServer(proxyIdx, lp) {
+			lp.Close()
+			return nil
+		}
+		logger.Notice("Resume listening on %s since proxy channels recovered", proxyConf.Local)
+		atomic.StoreInt32(paused, 0)
+		return lp
+	}
+	return nil
+}
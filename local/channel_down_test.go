@@ -0,0 +1,87 @@
+package local
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// unreachableLocalChannel fail to create any session
+type unreachableLocalChannel struct{}
+
+func (*unreachableLocalChannel) CreateMuxSession(server string, conf *channel.ProxyChannelConfig) (mux.MuxSession, error) {
+	return nil, errors.New("unreachable")
+}
+func (*unreachableLocalChannel) Features() channel.FeatureSet {
+	return channel.FeatureSet{}
+}
+
+// initDownChannel register a lazy channel named 'remoteDown' & mark it down by a failed stream
+func initDownChannel(t *testing.T) {
+	channel.RegisterLocalChannelType("unreachable", &unreachableLocalChannel{})
+	conf := channel.ProxyChannelConfig{Name: "remoteDown", Enable: true, Startup: channel.StartupLazy, ServerList: []string{"unreachable://127.0.0.1:1"}}
+	conf.Adjust()
+	if !channel.NewProxyChannel(&conf).Init(true) {
+		t.Fatal("failed to init channel")
+	}
+	if _, _, err := channel.GetMuxStreamByChannel("remoteDown"); nil == err {
+		t.Fatal("expect stream failed")
+	}
+}
+
+func TestRejectByChannelDown(t *testing.T) {
+	initDownChannel(t)
+	defer channel.StopLocalChannels()
+	defer routeCache.flush()
+	tests := []struct {
+		when   string
+		remote string
+		reject bool
+	}{
+		{"", "remoteDown", false},
+		{"pause", "remoteDown", false},
+		{"reject", "remoteDown", true},
+		{"Reject", "remoteDown", true},
+		{"reject", "direct", false},
+		{"reject", "remoteUnknown", false},
+	}
+	for _, tt := range tests {
+		routeCache.flush()
+		cfg := &ProxyConfig{Local: ":48100", WhenChannelDown: tt.when, PAC: []PACConfig{{Remote: tt.remote}}}
+		if reject := cfg.rejectByChannelDown("tcp", "www.example.com", "443"); reject != tt.reject {
+			t.Errorf("%q to %s: expect reject %v, but got %v", tt.when, tt.remote, tt.reject, reject)
+		}
+		if pause := cfg.pauseWhenDown(); pause != (tt.when == "pause") {
+			t.Errorf("%q: unexpected pause %v", tt.when, pause)
+		}
+	}
+	if !channel.AllProxyChannelsDown() {
+		t.Errorf("expect all proxy channels down")
+	}
+}
+
+func TestServeProxyConnRejectByChannelDown(t *testing.T) {
+	initDownChannel(t)
+	defer channel.StopLocalChannels()
+	defer hotConf.Store(currentHotConf())
+	defer routeCache.flush()
+	proxy := ProxyConfig{Local: ":48100", WhenChannelDown: "reject", PAC: []PACConfig{{Remote: "remoteDown"}}}
+	publishHotConf(&LocalConfig{Proxy: []ProxyConfig{proxy}})
+	routeCache.flush()
+	client, proxySide := net.Pipe()
+	defer client.Close()
+	go serveProxyConn(proxySide, "", "", &proxy)
+	go client.Write([]byte("GET http://www.example.com/ HTTP/1.1\r\nHost: www.example.com\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expect 503 since the channel is down, but got %d", res.StatusCode)
+	}
+}
@@ -1,10 +1,14 @@
 package local
 
 import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
 	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 
 	"github.com/yinqiwen/gsnova/common/channel"
 	"github.com/yinqiwen/gsnova/common/dns"
@@ -21,6 +25,64 @@ const (
 	IsCNIPRule       = "IsCNIP"
 )
 
+const (
+	//protocol of udp flows to port 443, matched by 'udp' rules too
+	QUICProtocol = "quic"
+	//drop udp datagrams & close tcp connections matched by the rule, e.g. block QUIC to force browsers using TCP
+	RejectRemote = "Reject"
+)
+
+func udpProtocol(port int) string {
+	switch port {
+	case 53:
+		return "dns"
+	case 443:
+		return QUICProtocol
+	}
+	return "udp"
+}
+
+func isRejectRemote(name string) bool {
+	return strings.EqualFold(name, RejectRemote)
+}
+
+// PAC mode override the PAC rules at runtime
+const (
+	PACModeRule   = "pac"
+	PACModeGlobal = "global"
+	PACModeDirect = "direct"
+)
+
+var pacMode atomic.Value
+
+func getPACMode() string {
+	if v := pacMode.Load(); nil != v {
+		return v.(string)
+	}
+	return PACModeRule
+}
+
+func SetPACMode(mode string) error {
+	switch mode {
+	case PACModeRule, PACModeGlobal, PACModeDirect:
+		pacMode.Store(mode)
+		routeCache.flush()
+		logger.Notice("Switch PAC mode to %s", mode)
+		return nil
+	}
+	return fmt.Errorf("Invalid PAC mode:%s", mode)
+}
+
+// globalProxyChannel is the first enabled non direct channel used in global mode
+func globalProxyChannel() string {
+	for _, conf := range GConf.Channel {
+		if conf.Enable && conf.Name != channel.DirectChannelName {
+			return conf.Name
+		}
+	}
+	return ""
+}
+
 func matchHostnames(pattern, host string) bool {
 	host = strings.TrimSuffix(host, ".")
 	pattern = strings.TrimSuffix(pattern, ".")
@@ -54,6 +116,25 @@ type PACConfig struct {
 	Rule     []string
 	Protocol []string
 	Remote   string
+	//remote dial timeout in milliseconds for matched requests, override the channel setting
+	DialTimeout int
+	//SO_MARK & DSCP set on sockets of matched direct requests, for tc/nftables QoS on router(linux only)
+	SocketMark int
+	DSCP       int
+
+	hostMatcher *helper.HostMatcher
+}
+
+// matchHost match by precompiled 'Host' patterns, which support the syntax of proxy limit like
+// '.example.com', 'regex:<expr>', CIDRs & 'ipset:<name>' referencing 'IPSets' of 'ProxyLimit'
+func (pac *PACConfig) matchHost(host string, db *ruleDatabases) bool {
+	if len(pac.Host) == 0 {
+		return true
+	}
+	if nil == pac.hostMatcher {
+		return MatchPatterns(host, pac.Host)
+	}
+	return pac.hostMatcher.MatchIn(host, db.ipSets)
 }
 
 func (pac *PACConfig) ruleInHosts(req *http.Request) bool {
@@ -65,66 +146,69 @@ func (pac *PACConfig) matchProtocol(protocol string) bool {
 		return true
 	}
 	for _, p := range pac.Protocol {
-		if p == "*" || strings.EqualFold(p, protocol) {
+		if p == "*" || strings.EqualFold(p, protocol) || (protocol == QUICProtocol && strings.EqualFold(p, "udp")) {
 			return true
 		}
 	}
 	return false
 }
 
-func (pac *PACConfig) matchRules(ip string, req *http.Request) bool {
+func (pac *PACConfig) matchRules(ip string, req *http.Request, db *ruleDatabases) bool {
 	if len(pac.Rule) == 0 {
 		return true
 	}
 
-	ok := true
-
 	for _, rule := range pac.Rule {
-		not := false
-		if strings.HasPrefix(rule, "!") {
-			not = true
-			rule = rule[1:]
-		}
-		if strings.EqualFold(rule, InHostsRule) {
-			if nil == req {
-				ok = false
-			} else {
-				ok = pac.ruleInHosts(req)
-			}
-		} else if strings.EqualFold(rule, BlockedByGFWRule) {
-			gfwList := getGFWList()
-			if nil != gfwList && nil != req {
-				ok = gfwList.IsBlockedByGFW(req)
-				if !ok {
-					logger.Debug("#### %s is NOT BlockedByGFW", req.Host)
-				}
-			} else {
-				ok = true
-				logger.Debug("NIL GFWList object or request")
-			}
-		} else if strings.EqualFold(rule, IsCNIPRule) {
-			if len(ip) == 0 || nil == dns.CNIPSet {
-				logger.Debug("NIL CNIP content  or IP/Domain")
-				ok = false
-			} else {
-				var err error
-				if net.ParseIP(ip) == nil {
-					ip, err = dns.DnsGetDoaminIP(ip)
-				}
-				if nil == err {
-					ok = dns.CNIPSet.IsInCountry(net.ParseIP(ip), "CN")
-				}
-				logger.Debug("ip:%s is CNIP:%v", ip, ok)
-			}
+		if !pac.matchRule(rule, ip, req, db) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pac *PACConfig) matchRule(rule string, ip string, req *http.Request, db *ruleDatabases) bool {
+	ok := true
+	not := false
+	if strings.HasPrefix(rule, "!") {
+		not = true
+		rule = rule[1:]
+	}
+	if strings.EqualFold(rule, InHostsRule) {
+		if nil == req {
+			ok = false
 		} else {
-			logger.Error("###Invalid rule:%s", rule)
+			ok = pac.ruleInHosts(req)
 		}
-		if not {
-			ok = ok != true
+	} else if strings.EqualFold(rule, BlockedByGFWRule) {
+		gfwList := db.gfwList
+		if nil != gfwList && nil != req {
+			ok = gfwList.IsBlockedByGFW(req)
+			if !ok {
+				logger.Debug("#### %s is NOT BlockedByGFW", req.Host)
+			}
+		} else {
+			ok = true
+			logger.Debug("NIL GFWList object or request")
 		}
-		if !ok {
-			break
+	} else if strings.EqualFold(rule, IsCNIPRule) {
+		if len(ip) == 0 || nil == db.cnIPSet {
+			logger.Debug("NIL CNIP content  or IP/Domain")
+			ok = false
+		} else {
+			var err error
+			if net.ParseIP(ip) == nil {
+				ip, err = dns.DnsGetDoaminIP(ip)
+			}
+			if nil == err {
+				ok = db.cnIPSet.IsInCountry(net.ParseIP(ip), "CN")
+			}
+			logger.Debug("ip:%s is CNIP:%v", ip, ok)
 		}
+	} else {
+		logger.Error("###Invalid rule:%s", rule)
+	}
+	if not {
+		ok = ok != true
 	}
 	return ok
 }
@@ -147,26 +231,52 @@ func MatchPatterns(str string, rules []string) bool {
 	return false
 }
 
-func (pac *PACConfig) Match(protocol string, ip string, req *http.Request) bool {
-	ret := pac.matchProtocol(protocol)
-	if !ret {
-		return false
+// Match evaluate the PAC entry, 'db' is the version of rule databases shared by the whole routing decision
+func (pac *PACConfig) Match(protocol string, ip string, req *http.Request, db *ruleDatabases) bool {
+	matched, _ := pac.evaluate(protocol, ip, req, db, false)
+	return matched
+}
+
+// evaluate the PAC entry, the reason of the result(the first failed condition) is only built if 'explain'
+func (pac *PACConfig) evaluate(protocol string, ip string, req *http.Request, db *ruleDatabases, explain bool) (bool, string) {
+	if !pac.matchProtocol(protocol) {
+		return false, explainf(explain, "protocol %s not in %v", protocol, pac.Protocol)
 	}
-	ret = pac.matchRules(ip, req)
-	if !ret {
-		return false
+	for _, rule := range pac.Rule {
+		if !pac.matchRule(rule, ip, req, db) {
+			return false, explainf(explain, "rule %s not satisfied", rule)
+		}
 	}
 	if nil == req {
 		if len(pac.Host) > 0 || len(pac.Method) > 0 || len(pac.URL) > 0 {
-			return false
+			return false, explainf(explain, "host/method/url patterns require a request")
 		}
-		return true
+		return true, explainf(explain, "matched without request")
 	}
 	host := req.Host
 	if len(pac.Host) > 0 && strings.Contains(host, ":") {
 		host, _, _ = net.SplitHostPort(host)
 	}
-	return MatchPatterns(host, pac.Host) && MatchPatterns(req.Method, pac.Method) && MatchPatterns(req.URL.String(), pac.URL)
+	if !pac.matchHost(host, db) {
+		return false, explainf(explain, "host %s not match %v", host, pac.Host)
+	}
+	if !MatchPatterns(req.Method, pac.Method) {
+		return false, explainf(explain, "method %s not match %v", req.Method, pac.Method)
+	}
+	if !MatchPatterns(req.URL.String(), pac.URL) {
+		return false, explainf(explain, "url %s not match %v", req.URL.String(), pac.URL)
+	}
+	if len(pac.Rule) > 0 {
+		return true, explainf(explain, "matched with rules %v", pac.Rule)
+	}
+	return true, explainf(explain, "matched")
+}
+
+func explainf(explain bool, format string, args ...interface{}) string {
+	if !explain {
+		return ""
+	}
+	return fmt.Sprintf(format, args...)
 }
 
 type HTTPDumpConfig struct {
@@ -187,40 +297,208 @@ func (dump *HTTPDumpConfig) MatchDomain(host string) bool {
 }
 
 type ProxyConfig struct {
-	Local    string
-	Forward  string
-	MITM     bool //Man-in-the-middle
-	HTTPDump HTTPDumpConfig
-	PAC      []PACConfig
+	Local     string
+	Forward   string
+	MITM      bool //Man-in-the-middle
+	MITMHTTP2 bool //negotiate h2 with clients and origins in MITM mode
+	HTTPDump  HTTPDumpConfig
+	PAC       []PACConfig
+	//rules evaluated in order instead of 'PAC' if not empty, like 'DOMAIN-SUFFIX,google.com,remoteA',
+	//'DOMAIN-KEYWORD,facebook,remoteA', 'IP-CIDR,10.0.0.0/8,DIRECT,no-resolve', 'DST-PORT,25,REJECT',
+	//'GEOIP,CN,DIRECT' & 'MATCH,<target>', a target is 'DIRECT', 'REJECT', a proxy channel or a name of 'Chains'
+	Rules     []string
+	ConnLimit ConnLimitConfig
+
+	Accelerate AccelerateConfig
+
+	//listen as iptables/nft TPROXY target(linux only), 'TProxyMark' is the fwmark routed to local, default 1
+	TProxy     bool
+	TProxyMark int
+
+	//username -> password required for socks5 & http proxy clients if not empty
+	Auth map[string]string
+	//when proxy channels are down, 'reject' fail connections routed to them fast(503 for http, failure reply for socks),
+	//'pause' stop listening until any channel recovered, default keep accepting
+	WhenChannelDown string
+	//who resolves socks targets, '' replace socks5 ip targets by sniffed domains, 'client' dial socks5 ip targets as is
+	//with sniffed domains only routing, 'local' resolve socks5h domains by local dns too, socks5h domains are resolved by remote otherwise
+	SocksResolve string
+
+	rules []*routeRule
 }
 
-func (cfg *ProxyConfig) getProxyChannelByHost(proto string, host string) string {
+// compile precompile host matchers of PAC entries & routing rules, rule targets must be in chains or channels
+func (cfg *ProxyConfig) compile(chains map[string]ChainConfig, channels []channel.ProxyChannelConfig) error {
+	if !validSocksResolve(cfg.SocksResolve) {
+		return fmt.Errorf("invalid SocksResolve:%s", cfg.SocksResolve)
+	}
+	for j := range cfg.PAC {
+		pac := &cfg.PAC[j]
+		pac.hostMatcher = helper.NewHostMatcher(pac.Host)
+	}
+	var err error
+	cfg.rules, err = compileRouteRules(cfg.Rules, chains, channels)
+	return err
+}
+
+func (cfg *ProxyConfig) authRequired() bool {
+	return len(cfg.Auth) > 0
+}
+
+func (cfg *ProxyConfig) authenticate(user, passwd string) bool {
+	expected, exist := cfg.Auth[user]
+	return exist && subtle.ConstantTimeCompare([]byte(expected), []byte(passwd)) == 1
+}
+
+// authenticateHTTP verify the 'Proxy-Authorization' basic credentials of the request
+func (cfg *ProxyConfig) authenticateHTTP(req *http.Request) bool {
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(prefix):]))
+	if nil != err {
+		return false
+	}
+	idx := strings.Index(string(b), ":")
+	return idx > 0 && cfg.authenticate(string(b[:idx]), string(b[idx+1:]))
+}
+
+func (cfg *ProxyConfig) getProxyChannelByHost(proto string, host string, port string) string {
+	name, _ := cfg.getRouteByHost(proto, host, port)
+	return name
+}
+
+// getRouteByHost return the proxy channel & extra hops of the destination, both are cached from one evaluation
+func (cfg *ProxyConfig) getRouteByHost(proto string, host string, port string) (string, []string) {
+	key := routeCacheKey(cfg, proto, host, port)
+	if name, hops, ok := routeCache.get(key); ok {
+		return name, hops
+	}
 	creq, _ := http.NewRequest("Connect", "https://"+host, nil)
-	return cfg.findProxyChannelByRequest(proto, host, creq)
+	name, hops := cfg.evaluateRoute(proto, host, port, creq, nil)
+	if len(name) > 0 {
+		routeCache.put(key, name, hops)
+	} else {
+		logger.Error("No proxy channel found.")
+	}
+	return name, hops
 }
 
-func (cfg *ProxyConfig) findProxyChannelByRequest(proto string, ip string, req *http.Request) string {
-	var channelName string
-	if len(ip) > 0 && helper.IsPrivateIP(ip) {
-		//channel = "direct"
-		return channel.DirectChannelName
+func (cfg *ProxyConfig) getDialTimeoutByHost(proto string, host string) int {
+	overrided := false
+	for _, pac := range cfg.PAC {
+		if pac.DialTimeout > 0 {
+			overrided = true
+			break
+		}
 	}
+	if !overrided {
+		return 0
+	}
+	creq, _ := http.NewRequest("Connect", "https://"+host, nil)
+	if pac := cfg.findPACByRequest(proto, host, creq); nil != pac {
+		return pac.DialTimeout
+	}
+	return 0
+}
+
+func (cfg *ProxyConfig) getSocketMarkByHost(proto string, host string) (int, int) {
+	marked := false
 	for _, pac := range cfg.PAC {
-		if pac.Match(proto, ip, req) {
-			channelName = pac.Remote
+		if pac.SocketMark > 0 || pac.DSCP > 0 {
+			marked = true
 			break
 		}
 	}
+	if !marked {
+		return 0, 0
+	}
+	creq, _ := http.NewRequest("Connect", "https://"+host, nil)
+	if pac := cfg.findPACByRequest(proto, host, creq); nil != pac {
+		return pac.SocketMark, pac.DSCP
+	}
+	return 0, 0
+}
+
+func (cfg *ProxyConfig) findPACByRequest(proto string, ip string, req *http.Request) *PACConfig {
+	db := currentRuleDBs()
+	for i := range cfg.PAC {
+		if cfg.PAC[i].Match(proto, ip, req, db) {
+			return &cfg.PAC[i]
+		}
+	}
+	return nil
+}
+
+func (cfg *ProxyConfig) findProxyChannelByRequest(proto string, ip string, port string, req *http.Request) string {
+	channelName, _ := cfg.evaluateRoute(proto, ip, port, req, nil)
 	if len(channelName) == 0 {
 		logger.Error("No proxy channel found.")
 	}
 	return channelName
 }
 
+// evaluateRoute is the routing decision shared by proxying & the explain api, each evaluation step is
+// recorded into 'ex' if not nil
+func (cfg *ProxyConfig) evaluateRoute(proto string, ip string, port string, req *http.Request, ex *RouteExplain) (string, []string) {
+	explain := nil != ex
+	decide := func(channelName string, hops []string, format string, args ...interface{}) (string, []string) {
+		if explain {
+			ex.Reason = fmt.Sprintf(format, args...)
+		}
+		return channelName, hops
+	}
+	//routing rules decide private destinations too, like 'IP-CIDR,192.168.0.0/16,remoteA'
+	private := len(ip) > 0 && helper.IsPrivateIP(ip)
+	mode := getPACMode()
+	if private && (len(cfg.rules) == 0 || mode != PACModeRule) {
+		return decide(channel.DirectChannelName, nil, "private ip is always direct")
+	}
+	switch mode {
+	case PACModeDirect:
+		return decide(channel.DirectChannelName, nil, "pac mode is direct")
+	case PACModeGlobal:
+		if name := globalProxyChannel(); len(name) > 0 {
+			return decide(name, nil, "pac mode is global")
+		}
+	}
+	db := currentRuleDBs()
+	if explain {
+		ex.RuleVersion = db.version
+	}
+	if len(cfg.rules) > 0 {
+		t := newRouteTarget(ip, port)
+		for i, r := range cfg.rules {
+			matched := r.match(t, db)
+			if explain {
+				ex.Steps = append(ex.Steps, RouteExplainStep{Index: i, Remote: r.target, Matched: matched, Reason: r.raw})
+			}
+			if matched {
+				return decide(r.channel, r.hops, "Rules[%d] %s", i, r.raw)
+			}
+		}
+		return decide("", nil, "no rule matched")
+	}
+	for i := range cfg.PAC {
+		matched, reason := cfg.PAC[i].evaluate(proto, ip, req, db, explain)
+		if explain {
+			ex.Steps = append(ex.Steps, RouteExplainStep{Index: i, Remote: cfg.PAC[i].Remote, Matched: matched, Reason: reason})
+		}
+		if matched {
+			return decide(cfg.PAC[i].Remote, nil, "PAC[%d] %s", i, reason)
+		}
+	}
+	return decide("", nil, "no PAC entry matched")
+}
+
 type AdminConfig struct {
 	Listen        string
 	BroadcastAddr string
 	ConfigDir     string
+	//required by state changing api, requests must carry it as 'Authorization: Bearer <Token>'
+	Token string
 }
 
 type UDPGWConfig struct {
@@ -252,6 +530,47 @@ type GFWListConfig struct {
 	RefershPeriodMiniutes int
 }
 
+// TUNConfig create a tun device whose tcp/udp flows are proxied like transparent connections, routes to
+// the tun device (excluding proxy servers) should be configured by user.
+type TUNConfig struct {
+	Enable  bool
+	Name    string
+	Addr    string
+	Gateway string
+	Mask    string
+	//dns servers of the device, windows only
+	DNS []string
+	//'Local' of the proxy whose PAC rules route tun flows, default the first one
+	Proxy string
+	//the proxy's own sockets get SO_MARK(linux only) & bind the outgoing interface like 'eth0', so that they bypass the tun device
+	Mark          int
+	BindInterface string
+}
+
+func (t *TUNConfig) proxyConfig() *ProxyConfig {
+	for i := range GConf.Proxy {
+		if len(t.Proxy) == 0 || GConf.Proxy[i].Local == t.Proxy {
+			return &GConf.Proxy[i]
+		}
+	}
+	return nil
+}
+
+func (t *TUNConfig) init() {
+	if len(t.Name) == 0 {
+		t.Name = "tun0"
+	}
+	if len(t.Addr) == 0 {
+		t.Addr = "10.255.0.2"
+	}
+	if len(t.Gateway) == 0 {
+		t.Gateway = "10.255.0.1"
+	}
+	if len(t.Mask) == 0 {
+		t.Mask = "255.255.255.0"
+	}
+}
+
 type LocalConfig struct {
 	Log             []string
 	Cipher          channel.CipherConfig
@@ -264,25 +583,71 @@ type LocalConfig struct {
 	Admin           AdminConfig
 	GFWList         GFWListConfig
 	TransparentMark int
+	TUN             TUNConfig
+	RouteCache      RouteCacheConfig
+	TunnelDNS       TunnelDNSConfig
+	PortForward     []string
+	RuleUpdate      RuleUpdateConfig
+	History         HistoryConfig
+	LeakAudit       LeakAuditConfig
+	Chains          map[string]ChainConfig
 	Proxy           []ProxyConfig
 	Channel         []channel.ProxyChannelConfig
+	//default 'Startup' of channels, 'eager' or 'lazy'
+	ChannelStartup string
 }
 
 func (cfg *LocalConfig) init() error {
+	cfg.TUN.init()
+	for i := range cfg.Proxy {
+		if err := cfg.Proxy[i].compile(cfg.Chains, cfg.Channel); nil != err {
+			return fmt.Errorf("proxy:%s has %v", cfg.Proxy[i].Local, err)
+		}
+	}
 	haveDirect := false
-	for i := range GConf.Channel {
-		if GConf.Channel[i].Name == channel.DirectChannelName && GConf.Channel[i].Enable {
+	for i := range cfg.Channel {
+		if cfg.Channel[i].Name == channel.DirectChannelName && cfg.Channel[i].Enable {
 			haveDirect = true
-			GConf.Channel[i].ServerList = []string{"direct://0.0.0.0:0"}
-			GConf.Channel[i].ConnsPerServer = 1
+			cfg.Channel[i].ServerList = []string{"direct://0.0.0.0:0"}
+			cfg.Channel[i].ConnsPerServer = 1
+		}
+		if len(cfg.Channel[i].Cipher.Key) == 0 {
+			//keep the cipher method selected for this channel
+			method := cfg.Channel[i].Cipher.Method
+			cfg.Channel[i].Cipher = cfg.Cipher
+			if len(method) > 0 {
+				cfg.Channel[i].Cipher.Method = method
+			}
+		}
+		if len(cfg.Channel[i].HTTP.UserAgent) == 0 {
+			cfg.Channel[i].HTTP.UserAgent = cfg.UserAgent
 		}
-		if len(GConf.Channel[i].Cipher.Key) == 0 {
-			GConf.Channel[i].Cipher = GConf.Cipher
+		if len(cfg.Channel[i].Startup) == 0 {
+			cfg.Channel[i].Startup = cfg.ChannelStartup
 		}
-		if len(GConf.Channel[i].HTTP.UserAgent) == 0 {
-			GConf.Channel[i].HTTP.UserAgent = GConf.UserAgent
+		if !channel.ValidStartup(cfg.Channel[i].Startup) {
+			return fmt.Errorf("channel:%s has invalid Startup:%s", cfg.Channel[i].Name, cfg.Channel[i].Startup)
+		}
+		if !channel.ValidUTLS(cfg.Channel[i].UTLS) {
+			return fmt.Errorf("channel:%s has invalid UTLS:%s", cfg.Channel[i].Name, cfg.Channel[i].UTLS)
+		}
+		if cfg.Channel[i].ECH.Enable && len(cfg.Channel[i].UTLS) > 0 {
+			return fmt.Errorf("channel:%s can not enable ECH with UTLS", cfg.Channel[i].Name)
+		}
+		if err := cfg.Channel[i].CheckKCPServers(); nil != err {
+			return fmt.Errorf("channel:%s has invalid kcp server:%v", cfg.Channel[i].Name, err)
+		}
+		for _, step := range cfg.Channel[i].Canary.Escalate {
+			if !channel.ValidEscalation(step) {
+				return fmt.Errorf("channel:%s has invalid canary escalation:%s", cfg.Channel[i].Name, step)
+			}
+		}
+		cfg.Channel[i].Adjust()
+		if cfg.Channel[i].Enable {
+			if err := cfg.Channel[i].CheckUserKey(); nil != err {
+				return err
+			}
 		}
-		GConf.Channel[i].Adjust()
 	}
 
 	if !haveDirect {
@@ -292,7 +657,10 @@ func (cfg *LocalConfig) init() error {
 		directProxyChannel[0].ConnsPerServer = 1
 		directProxyChannel[0].LocalDialMSTimeout = 5000
 		directProxyChannel[0].ServerList = []string{"direct://0.0.0.0:0"}
-		GConf.Channel = append(directProxyChannel, GConf.Channel...)
+		cfg.Channel = append(directProxyChannel, cfg.Channel...)
 	}
-	return nil
+	if err := cfg.TunnelDNS.init(cfg.Channel); nil != err {
+		return err
+	}
+	return cfg.initPortForwards()
 }
@@ -0,0 +1,103 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+)
+
+func TestGetDialTimeoutByHost(t *testing.T) {
+	cfg := &ProxyConfig{PAC: []PACConfig{
+		{Host: []string{"*.slow.test"}, Remote: "remoteA", DialTimeout: 30000},
+		{Host: []string{"*.fast.test"}, Remote: "remoteA"},
+		{Remote: "remoteB", DialTimeout: 5000},
+	}}
+	tests := []struct {
+		host    string
+		timeout int
+	}{
+		{"www.slow.test", 30000},
+		//matched rule without override defer to the channel
+		{"www.fast.test", 0},
+		{"other.test", 5000},
+	}
+	for _, tt := range tests {
+		if timeout := cfg.getDialTimeoutByHost("https", tt.host); timeout != tt.timeout {
+			t.Errorf("expect dial timeout %d of %s, but got %d", tt.timeout, tt.host, timeout)
+		}
+	}
+	noOverride := &ProxyConfig{PAC: []PACConfig{{Remote: "remoteA"}}}
+	if timeout := noOverride.getDialTimeoutByHost("https", "www.slow.test"); timeout != 0 {
+		t.Errorf("expect no dial timeout without override, but got %d", timeout)
+	}
+}
+
+func TestGetSocketMarkByHost(t *testing.T) {
+	cfg := &ProxyConfig{PAC: []PACConfig{
+		{Host: []string{"*.video.test"}, Remote: "direct", SocketMark: 100, DSCP: 8},
+		{Host: []string{"*.voip.test"}, Remote: "direct", DSCP: 46},
+		{Host: []string{"*.plain.test"}, Remote: "direct"},
+		{Remote: "remoteA", SocketMark: 200},
+	}}
+	tests := []struct {
+		host string
+		mark int
+		dscp int
+	}{
+		{"www.video.test", 100, 8},
+		{"sip.voip.test", 0, 46},
+		//first matched rule wins even without marks
+		{"www.plain.test", 0, 0},
+		{"other.test", 200, 0},
+	}
+	for _, tt := range tests {
+		if mark, dscp := cfg.getSocketMarkByHost("https", tt.host); mark != tt.mark || dscp != tt.dscp {
+			t.Errorf("expect mark %d & dscp %d of %s, but got %d & %d", tt.mark, tt.dscp, tt.host, mark, dscp)
+		}
+	}
+	noMark := &ProxyConfig{PAC: []PACConfig{{Remote: "direct"}}}
+	if mark, dscp := noMark.getSocketMarkByHost("https", "www.video.test"); mark != 0 || dscp != 0 {
+		t.Errorf("expect no mark without rules setting it, but got %d & %d", mark, dscp)
+	}
+}
+
+func TestUDPProtocolMatch(t *testing.T) {
+	tests := []struct {
+		port     int
+		protocol []string
+		matched  bool
+	}{
+		{53, []string{"dns"}, true},
+		{53, []string{"udp"}, false},
+		{443, []string{"quic"}, true},
+		{443, []string{"QUIC"}, true},
+		//quic flows are udp flows too
+		{443, []string{"udp"}, true},
+		{443, []string{"tcp"}, false},
+		{8443, []string{"quic"}, false},
+		{8443, []string{"udp"}, true},
+		{8443, []string{"*"}, true},
+		{8443, nil, true},
+	}
+	for _, tt := range tests {
+		pac := &PACConfig{Protocol: tt.protocol}
+		if matched := pac.matchProtocol(udpProtocol(tt.port)); matched != tt.matched {
+			t.Errorf("expect protocol %v matched %v by udp port %d, but got %v", tt.protocol, tt.matched, tt.port, matched)
+		}
+	}
+	for name, reject := range map[string]bool{"Reject": true, "reject": true, "direct": false, "Rejected": false} {
+		if isRejectRemote(name) != reject {
+			t.Errorf("expect %s reject:%v", name, reject)
+		}
+	}
+}
+
+func TestSampleConfigSchema(t *testing.T) {
+	data, err := helper.ReadWithoutComment("../client.json", "//")
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, e := range helper.ValidateJSON(helper.JSONSchema(LocalConfig{}, "GSnova client config"), data) {
+		t.Errorf("client.json: %s", e)
+	}
+}
@@ -0,0 +1,97 @@
+package local
+
+import (
+	"net"
+	"sync"
+
+	"github.com/juju/ratelimit"
+)
+
+type ConnLimitConfig struct {
+	//max concurrent connections from one source ip, 0 means unlimited
+	MaxConnsPerIP int
+	//max accepted connections per second from one source ip, 0 means unlimited
+	MaxAcceptRatePerIP int
+}
+
+type sourceIPState struct {
+	conns  int
+	bucket *ratelimit.Bucket
+}
+
+// idle state carries nothing, a refilled bucket is the same as a new one
+func (s *sourceIPState) idle() bool {
+	return s.conns <= 0 && (nil == s.bucket || s.bucket.Available() >= s.bucket.Capacity())
+}
+
+type connLimiter struct {
+	conf    ConnLimitConfig
+	sources map[string]*sourceIPState
+	mutex   sync.Mutex
+}
+
+func newConnLimiter(conf ConnLimitConfig) *connLimiter {
+	l := &connLimiter{
+		conf:    conf,
+		sources: make(map[string]*sourceIPState),
+	}
+	return l
+}
+
+func (l *connLimiter) enabled() bool {
+	return l.conf.MaxConnsPerIP > 0 || l.conf.MaxAcceptRatePerIP > 0
+}
+
+func connSourceIP(conn net.Conn) string {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	return host
+}
+
+func (l *connLimiter) acquire(ip string) bool {
+	if !l.enabled() {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	s, exist := l.sources[ip]
+	if !exist {
+		//sources rejected by rate never release, sweep them once many sources seen
+		if len(l.sources) >= 1024 {
+			for k, v := range l.sources {
+				if v.idle() {
+					delete(l.sources, k)
+				}
+			}
+		}
+		s = &sourceIPState{}
+		if l.conf.MaxAcceptRatePerIP > 0 {
+			s.bucket = ratelimit.NewBucketWithRate(float64(l.conf.MaxAcceptRatePerIP), int64(l.conf.MaxAcceptRatePerIP))
+		}
+		l.sources[ip] = s
+	}
+	if l.conf.MaxConnsPerIP > 0 && s.conns >= l.conf.MaxConnsPerIP {
+		return false
+	}
+	if nil != s.bucket && s.bucket.TakeAvailable(1) == 0 {
+		return false
+	}
+	s.conns++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	if !l.enabled() {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if s, exist := l.sources[ip]; exist {
+		s.conns--
+		if s.idle() {
+			delete(l.sources, ip)
+		}
+	}
+}
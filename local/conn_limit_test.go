@@ -0,0 +1,87 @@
+package local
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnLimiterMaxConns(t *testing.T) {
+	l := newConnLimiter(ConnLimitConfig{MaxConnsPerIP: 2})
+	for i, c := range []struct {
+		acquire  bool
+		ip       string
+		expected bool
+	}{
+		{true, "10.0.0.1", true},
+		{true, "10.0.0.1", true},
+		{true, "10.0.0.1", false},
+		//limited per source ip
+		{true, "10.0.0.2", true},
+		{false, "10.0.0.1", true},
+		{true, "10.0.0.1", true},
+		{true, "10.0.0.1", false},
+	} {
+		if !c.acquire {
+			l.release(c.ip)
+			continue
+		}
+		if v := l.acquire(c.ip); v != c.expected {
+			t.Errorf("step %d: acquire %s=%v, expected %v", i, c.ip, v, c.expected)
+		}
+	}
+	l.release("10.0.0.1")
+	l.release("10.0.0.1")
+	l.release("10.0.0.2")
+	if len(l.sources) != 0 {
+		t.Errorf("idle sources not dropped:%v", l.sources)
+	}
+}
+
+func TestConnLimiterAcceptRate(t *testing.T) {
+	l := newConnLimiter(ConnLimitConfig{MaxAcceptRatePerIP: 3})
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if l.acquire("10.0.0.1") {
+			accepted++
+			l.release("10.0.0.1")
+		}
+	}
+	if accepted != 3 {
+		t.Errorf("%d connections accepted in a burst, expected 3", accepted)
+	}
+	if !l.acquire("10.0.0.2") {
+		t.Errorf("other source ip should not be throttled")
+	}
+	//throttled sources are kept until their bucket refilled
+	if _, exist := l.sources["10.0.0.1"]; !exist {
+		t.Errorf("throttled source dropped before its bucket refilled")
+	}
+}
+
+func TestConnLimiterDisabled(t *testing.T) {
+	l := newConnLimiter(ConnLimitConfig{})
+	for i := 0; i < 100; i++ {
+		if !l.acquire("10.0.0.1") {
+			t.Fatalf("connection rejected by disabled limiter")
+		}
+	}
+	if len(l.sources) != 0 {
+		t.Errorf("disabled limiter should keep no state")
+	}
+}
+
+func TestConnSourceIP(t *testing.T) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer lp.Close()
+	conn, err := net.Dial("tcp", lp.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := connSourceIP(conn); ip != "127.0.0.1" {
+		t.Errorf("source ip of %v got %s", conn.RemoteAddr(), ip)
+	}
+}
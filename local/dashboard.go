@@ -0,0 +1,414 @@
+package local
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// bytes of finished proxy streams
+var finishedUpBytes, finishedDownBytes int64
+
+type countReader struct {
+	io.Reader
+	n *int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		atomic.AddInt64(r.n, int64(n))
+	}
+	return n, err
+}
+
+type countWriter struct {
+	io.Writer
+	n *int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if n > 0 {
+		atomic.AddInt64(w.n, int64(n))
+	}
+	return n, err
+}
+
+func (ctx *proxyStreamContext) finish() {
+	activeStreams.Delete(ctx)
+	atomic.AddInt64(&finishedUpBytes, atomic.LoadInt64(&ctx.upBytes))
+	atomic.AddInt64(&finishedDownBytes, atomic.LoadInt64(&ctx.downBytes))
+	ctx.recordHistory()
+}
+
+type connectionStat struct {
+	Client    string
+	Target    string
+	Protocol  string
+	Channel   string
+	UpBytes   int64
+	DownBytes int64
+	AgeSecs   int64
+}
+
+type dashboardStat struct {
+	Version     string
+	PACMode     string
+	UpBytes     int64
+	DownBytes   int64
+	Channels    []channel.ChannelStat
+	Connections []connectionStat
+	Checksum    channel.StreamChecksumStats
+	Compress    mux.CompressStats
+}
+
+func dashboardStatCallback(w http.ResponseWriter, r *http.Request) {
+	stat := dashboardStat{
+		Version:   channel.Version,
+		PACMode:   getPACMode(),
+		UpBytes:   atomic.LoadInt64(&finishedUpBytes),
+		DownBytes: atomic.LoadInt64(&finishedDownBytes),
+		Channels:  channel.LocalChannelStats(),
+		Checksum:  channel.GetStreamChecksumStats(),
+		Compress:  mux.GetCompressStats(),
+	}
+	now := time.Now()
+	activeStreams.Range(func(key, value interface{}) bool {
+		ctx := key.(*proxyStreamContext)
+		if ctx.start.IsZero() {
+			return true
+		}
+		c := connectionStat{
+			Client:    ctx.client,
+			Target:    ctx.target,
+			Protocol:  ctx.protocol,
+			Channel:   ctx.channel,
+			UpBytes:   atomic.LoadInt64(&ctx.upBytes),
+			DownBytes: atomic.LoadInt64(&ctx.downBytes),
+			AgeSecs:   int64(now.Sub(ctx.start) / time.Second),
+		}
+		stat.UpBytes += c.UpBytes
+		stat.DownBytes += c.DownBytes
+		stat.Connections = append(stat.Connections, c)
+		return true
+	})
+	sort.Slice(stat.Connections, func(i, j int) bool {
+		return stat.Connections[i].AgeSecs < stat.Connections[j].AgeSecs
+	})
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(&stat)
+	w.Write(js)
+}
+
+func pacModeCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := SetPACMode(r.URL.Query().Get("mode")); nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func reloadCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := Reload(); nil != err {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func hotReloadCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := HotReload(); nil != err {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func dnsCacheCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(dns.GetCacheStats())
+	w.Write(js)
+}
+
+func dnsCacheFlushCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	logger.Notice("Flushed %d dns cache records", dns.FlushCache())
+	w.WriteHeader(200)
+}
+
+// routeExplainCallback show how '?host=' is routed, '&proto=' default tcp, '&proxy=' is the 'Local' of proxy default the first one
+func routeExplainCallback(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	if len(host) == 0 {
+		http.Error(w, "'host' required", http.StatusBadRequest)
+		return
+	}
+	port := r.URL.Query().Get("port")
+	if h, p, err := net.SplitHostPort(host); nil == err {
+		host, port = h, p
+	}
+	proto := r.URL.Query().Get("proto")
+	if len(proto) == 0 {
+		proto = "tcp"
+	}
+	proxy := currentHotConf().proxyByLocal(r.URL.Query().Get("proxy"))
+	if nil == proxy {
+		http.Error(w, "No proxy found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.MarshalIndent(proxy.explainRoute(proto, host, port), "", "  ")
+	w.Write(js)
+}
+
+func routeFlushCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	logger.Notice("Flushed %d route cache records", routeCache.flush())
+	w.WriteHeader(200)
+}
+
+func ruleDBsCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(currentRuleDBs().info())
+	w.Write(js)
+}
+
+// ruleDBsReloadCallback reload gfwlist, CN ip set, ip sets & GeoIP database without restarting local servers
+func ruleDBsReloadCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(reloadRuleDBs())
+	w.Write(js)
+}
+
+func dashboardCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, strings.Replace(dashboardHTML, "${Version}", channel.Version, -1))
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8"/>
+<title>GSnova ${Version}</title>
+<style>
+body { font-family: sans-serif; margin: 20px; color: #333; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 20px; font-size: 13px; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+th { background: #f4f4f4; }
+.down { color: #c00; }
+.up { color: #080; }
+button { margin-right: 6px; }
+button.active { font-weight: bold; }
+</style>
+</head>
+<body>
+<h2>GSnova ${Version}</h2>
+<div>
+  PAC mode:
+  <button id="mode-pac" onclick="setMode('pac')">PAC</button>
+  <button id="mode-global" onclick="setMode('global')">Global</button>
+  <button id="mode-direct" onclick="setMode('direct')">Direct</button>
+  <button onclick="reloadConf()">Reload config</button>
+  <button onclick="speedTest()">Speed test</button>
+  <button onclick="leakAudit(1)">Leak audit</button>
+  <span id="msg"></span>
+</div>
+<h3>Bandwidth</h3>
+<canvas id="bw" width="800" height="160" style="border:1px solid #ddd"></canvas>
+<div>Up: <span id="uprate"></span>/s &nbsp; Down: <span id="downrate"></span>/s</div>
+<div>Compression: <span id="compress"></span></div>
+<h3>Channels</h3>
+<table id="channels"></table>
+<h3>Leak audit <span id="leaktime"></span></h3>
+<table id="leaks"></table>
+<h3>Connections (<span id="conncount">0</span>)</h3>
+<table id="conns"></table>
+<h3>History</h3>
+<div>
+  Domain <input id="h-domain" size="16"/>
+  From <input id="h-from" type="datetime-local"/>
+  To <input id="h-to" type="datetime-local"/>
+  Rule <input id="h-rule" size="12"/>
+  Channel <input id="h-channel" size="8"/>
+  <select id="h-sort"><option value="time">Latest</option><option value="bytes">Most bytes</option></select>
+  <button onclick="searchHistory()">Search</button>
+  <span id="h-msg"></span>
+</div>
+<table id="history"></table>
+<script>
+var samples = [], last = null, maxPoints = 120;
+function fmt(n) {
+  var u = ['B','KB','MB','GB','TB'], i = 0;
+  while (n >= 1024 && i < u.length - 1) { n /= 1024; i++; }
+  return n.toFixed(i ? 1 : 0) + u[i];
+}
+function esc(s) {
+  return String(s).replace(/[&<>"]/g, function(c) { return {'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;'}[c]; });
+}
+function post(url) {
+  var x = new XMLHttpRequest();
+  x.open('POST', url);
+  x.setRequestHeader('Authorization', 'Bearer ' + (localStorage.getItem('gsnovaAdminToken') || ''));
+  x.onload = function() {
+    if (x.status == 401) {
+      var token = prompt('Admin token');
+      if (token) { localStorage.setItem('gsnovaAdminToken', token); post(url); }
+      return;
+    }
+    document.getElementById('msg').textContent = x.status == 200 ? 'OK' : x.responseText;
+  };
+  x.send();
+}
+function setMode(m) { post('/api/pacmode?mode=' + m); }
+function reloadConf() { document.getElementById('msg').textContent = 'Reloading...'; post('/api/reload'); }
+function speedTest() {
+  var msg = document.getElementById('msg');
+  msg.textContent = 'Testing...';
+  var x = new XMLHttpRequest();
+  x.open('GET', '/api/speedtest');
+  x.onload = function() {
+    if (x.status != 200) { msg.textContent = x.responseText; return; }
+    var r = JSON.parse(x.responseText);
+    msg.textContent = r.Channel + ' download ' + fmt(r.DownloadRate) + '/s, upload ' + fmt(r.UploadRate) + '/s';
+  };
+  x.send();
+}
+function leakAudit(run) {
+  var x = new XMLHttpRequest();
+  x.open('GET', '/api/leaks' + (run ? '?run=1' : ''));
+  if (run) { document.getElementById('leaktime').textContent = 'auditing...'; }
+  x.onload = function() {
+    if (x.status != 200) { document.getElementById('leaktime').textContent = x.responseText; return; }
+    var r = JSON.parse(x.responseText);
+    var rows = '<tr><th>Check</th><th>Status</th><th>Detail</th><th>Hint</th></tr>';
+    (r.Findings || []).forEach(function(f) {
+      rows += '<tr><td>' + esc(f.Check) + '</td><td class="' + (f.Status == 'leak' ? 'down' : (f.Status == 'ok' ? 'up' : '')) + '">' +
+        esc(f.Status) + '</td><td>' + esc(f.Detail) + '</td><td>' + esc(f.Hint || '') + '</td></tr>';
+    });
+    document.getElementById('leaks').innerHTML = rows;
+    document.getElementById('leaktime').textContent = '(' + r.Leaks + ' leaks at ' + new Date(r.Time * 1000).toLocaleTimeString() + ')';
+  };
+  x.send();
+}
+function draw() {
+  var c = document.getElementById('bw'), g = c.getContext('2d');
+  g.clearRect(0, 0, c.width, c.height);
+  var max = 1024;
+  samples.forEach(function(p) { max = Math.max(max, p[0], p[1]); });
+  [[0, '#080'], [1, '#c00']].forEach(function(s) {
+    g.strokeStyle = s[1];
+    g.beginPath();
+    samples.forEach(function(p, i) {
+      var x = c.width * i / (maxPoints - 1), y = c.height - c.height * p[s[0]] / max;
+      if (i == 0) { g.moveTo(x, y); } else { g.lineTo(x, y); }
+    });
+    g.stroke();
+  });
+  g.fillStyle = '#666';
+  g.fillText(fmt(max) + '/s', 4, 12);
+}
+function render(s) {
+  var now = Date.now();
+  if (last) {
+    var secs = (now - last.time) / 1000;
+    var up = Math.max(0, (s.UpBytes - last.up) / secs), down = Math.max(0, (s.DownBytes - last.down) / secs);
+    samples.push([up, down]);
+    if (samples.length > maxPoints) { samples.shift(); }
+    document.getElementById('uprate').textContent = fmt(up);
+    document.getElementById('downrate').textContent = fmt(down);
+    draw();
+  }
+  last = {time: now, up: s.UpBytes, down: s.DownBytes};
+  var cs = s.Compress || {};
+  document.getElementById('compress').textContent = fmt(cs.SavedBytes || 0) + ' saved of ' + fmt(cs.RawBytes || 0) + ' in ' +
+    (cs.Streams || 0) + ' streams, ' + (cs.Disabled || 0) + ' stopped compressing, ' + (cs.CPUMillis || 0) + 'ms cpu';
+  ['pac', 'global', 'direct'].forEach(function(m) {
+    document.getElementById('mode-' + m).className = s.PACMode == m ? 'active' : '';
+  });
+  var rows = '<tr><th>Channel</th><th>Server</th><th>Session</th><th>State</th><th>Streams</th><th>RTT</th><th>Fails</th><th>Quota</th></tr>';
+  (s.Channels || []).forEach(function(ch) {
+    (ch.Sessions || []).forEach(function(ss) {
+      rows += '<tr><td>' + esc(ch.Name) + '</td><td>' + esc(ss.Server) + '</td><td>' + esc(ss.SessionID) + '</td><td class="' +
+        (ss.Connected ? 'up">connected' : 'down">disconnected') + (ss.P2P ? ' (p2p)' : '') + '</td><td>' + ss.Streams +
+        '</td><td>' + ss.RTTMillis + 'ms</td><td>' + ss.Fails + '</td><td>' +
+        (ss.Quota ? fmt(ss.Quota.Remaining) + ' left, reset ' + new Date(ss.Quota.ResetTime * 1000).toLocaleDateString() : '-') + '</td></tr>';
+    });
+  });
+  document.getElementById('channels').innerHTML = rows;
+  var conns = s.Connections || [];
+  document.getElementById('conncount').textContent = conns.length;
+  rows = '<tr><th>Client</th><th>Target</th><th>Protocol</th><th>Route</th><th>Up</th><th>Down</th><th>Age</th></tr>';
+  conns.forEach(function(c) {
+    rows += '<tr><td>' + esc(c.Client) + '</td><td>' + esc(c.Target) + '</td><td>' + esc(c.Protocol) + '</td><td>' + esc(c.Channel) +
+      '</td><td>' + fmt(c.UpBytes) + '</td><td>' + fmt(c.DownBytes) + '</td><td>' + c.AgeSecs + 's</td></tr>';
+  });
+  document.getElementById('conns').innerHTML = rows;
+}
+function poll() {
+  var x = new XMLHttpRequest();
+  x.open('GET', '/api/dashboard');
+  x.onload = function() { if (x.status == 200) { render(JSON.parse(x.responseText)); } };
+  x.send();
+}
+function searchHistory() {
+  var q = [];
+  ['domain', 'from', 'to', 'rule', 'channel', 'sort'].forEach(function(k) {
+    var v = document.getElementById('h-' + k).value;
+    if (v) { q.push(k + '=' + encodeURIComponent(v)); }
+  });
+  var x = new XMLHttpRequest();
+  x.open('GET', '/api/history?' + q.join('&'));
+  x.onload = function() {
+    if (x.status != 200) { document.getElementById('h-msg').textContent = x.responseText; return; }
+    var recs = JSON.parse(x.responseText), up = 0, down = 0;
+    var rows = '<tr><th>Time</th><th>Client</th><th>Host</th><th>Protocol</th><th>Route</th><th>Rule</th><th>Up</th><th>Down</th><th>Duration</th></tr>';
+    recs.forEach(function(r) {
+      up += r.UpBytes; down += r.DownBytes;
+      rows += '<tr><td>' + new Date(r.Time * 1000).toLocaleString() + '</td><td>' + esc(r.Client) + '</td><td>' + esc(r.Host) + ':' + esc(r.Port) +
+        '</td><td>' + esc(r.Protocol) + '</td><td>' + esc(r.Channel) + '</td><td>' + esc(r.Rule) + '</td><td>' + fmt(r.UpBytes) +
+        '</td><td>' + fmt(r.DownBytes) + '</td><td>' + (r.DurationMS / 1000).toFixed(1) + 's</td></tr>';
+    });
+    document.getElementById('history').innerHTML = rows;
+    document.getElementById('h-msg').textContent = recs.length + ' records, up ' + fmt(up) + ', down ' + fmt(down);
+  };
+  x.send();
+}
+poll();
+setInterval(poll, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,129 @@
+package local
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdminAuth(t *testing.T) {
+	saved := GConf.Admin.Token
+	defer func() { GConf.Admin.Token = saved }()
+	h := adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		token  string
+		auth   string
+		status int
+	}{
+		{"", "", http.StatusForbidden},
+		{"", "Bearer ", http.StatusForbidden},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		GConf.Admin.Token = tt.token
+		req := httptest.NewRequest("POST", "/api/reload", nil)
+		if len(tt.auth) > 0 {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != tt.status {
+			t.Errorf("token %q with authorization %q expect status %d, but got %d", tt.token, tt.auth, tt.status, w.Code)
+		}
+	}
+}
+
+func TestPACModeCallback(t *testing.T) {
+	defer SetPACMode(PACModeRule)
+	tests := []struct {
+		method string
+		mode   string
+		status int
+		expect string
+	}{
+		{"GET", PACModeGlobal, http.StatusMethodNotAllowed, PACModeRule},
+		{"POST", "proxy", http.StatusBadRequest, PACModeRule},
+		{"POST", PACModeGlobal, http.StatusOK, PACModeGlobal},
+		{"POST", PACModeDirect, http.StatusOK, PACModeDirect},
+		{"POST", PACModeRule, http.StatusOK, PACModeRule},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		pacModeCallback(w, httptest.NewRequest(tt.method, "/api/pacmode?mode="+tt.mode, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s mode %s expect status %d, but got %d", tt.method, tt.mode, tt.status, w.Code)
+		}
+		if mode := getPACMode(); mode != tt.expect {
+			t.Errorf("%s mode %s expect pac mode %s, but got %s", tt.method, tt.mode, tt.expect, mode)
+		}
+	}
+}
+
+func TestDashboardStatCallback(t *testing.T) {
+	now := time.Now()
+	older := &proxyStreamContext{client: "c1", target: "a.test:443", channel: "remote", start: now.Add(-time.Minute), upBytes: 10, downBytes: 20}
+	newer := &proxyStreamContext{client: "c2", target: "b.test:80", channel: "direct", start: now, upBytes: 1, downBytes: 2}
+	pending := &proxyStreamContext{}
+	for _, ctx := range []*proxyStreamContext{older, newer, pending} {
+		activeStreams.Store(ctx, true)
+		defer activeStreams.Delete(ctx)
+	}
+	finishedUp, finishedDown := atomic.LoadInt64(&finishedUpBytes), atomic.LoadInt64(&finishedDownBytes)
+
+	w := httptest.NewRecorder()
+	dashboardStatCallback(w, httptest.NewRequest("GET", "/api/dashboard", nil))
+	var stat dashboardStat
+	if err := json.Unmarshal(w.Body.Bytes(), &stat); nil != err {
+		t.Fatal(err)
+	}
+	//streams not started yet are not listed
+	if len(stat.Connections) != 2 {
+		t.Fatalf("expect 2 connections, but got %v", stat.Connections)
+	}
+	if stat.Connections[0].Target != "b.test:80" || stat.Connections[1].Target != "a.test:443" || stat.Connections[1].AgeSecs < 59 {
+		t.Errorf("expect the newest connection first, but got %v", stat.Connections)
+	}
+	if stat.UpBytes != finishedUp+11 || stat.DownBytes != finishedDown+22 {
+		t.Errorf("expect bytes of finished & active streams, but got %d/%d", stat.UpBytes, stat.DownBytes)
+	}
+	if stat.PACMode != getPACMode() {
+		t.Errorf("expect pac mode %s, but got %s", getPACMode(), stat.PACMode)
+	}
+}
+
+func TestReloadKeepRunningConfigIfInvalid(t *testing.T) {
+	savedOptions, savedListen := runningOptions, GConf.Admin.Listen
+	defer func() {
+		runningOptions = savedOptions
+		GConf.Admin.Listen = savedListen
+	}()
+	GConf.Admin.Listen = "127.0.0.1:7788"
+
+	runningOptions.Config = ""
+	if err := Reload(); nil == err {
+		t.Errorf("expect error without config file")
+	}
+	dir, err := ioutil.TempDir("", "reload")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runningOptions.Config = filepath.Join(dir, "client.json")
+	ioutil.WriteFile(runningOptions.Config, []byte(`{"Admin":{"Listen":`), 0644)
+	if err = Reload(); nil == err {
+		t.Errorf("expect error for invalid config")
+	}
+	if GConf.Admin.Listen != "127.0.0.1:7788" {
+		t.Errorf("expect running config kept, but got admin listen %q", GConf.Admin.Listen)
+	}
+}
@@ -0,0 +1,228 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+var errInvalidDNSResponse = errors.New("Invalid dns response")
+
+// TunnelDNSConfig of dns queries proxied through channels, a duplicate query is sent to the hedge
+// resolver/channel if the first one has not answered after 'HedgeMSDelay', or failed
+type TunnelDNSConfig struct {
+	//0 means default 150, negative disables hedged & retried queries
+	HedgeMSDelay int
+	//channel of hedged queries, default the channel of the first query
+	HedgeChannel string
+	//resolver of hedged queries like '8.8.4.4:53', default the second 'LocalDNS.TrustedDNS' or the first query's resolver
+	HedgeServer string
+	//hedged & retried queries are limited to 'RetryBudgetRatio' of all queries(0 means default 0.1),
+	//besides 'MinRetriesPerSecond'(0 means default 5) retries allowed every second
+	RetryBudgetRatio    float64
+	MinRetriesPerSecond int
+}
+
+func (conf *TunnelDNSConfig) hedgeDelay() time.Duration {
+	if conf.HedgeMSDelay < 0 {
+		return 0
+	}
+	if 0 == conf.HedgeMSDelay {
+		return 150 * time.Millisecond
+	}
+	return time.Duration(conf.HedgeMSDelay) * time.Millisecond
+}
+
+func (conf *TunnelDNSConfig) init(channels []channel.ProxyChannelConfig) error {
+	if len(conf.HedgeChannel) > 0 && !hasChannel(channels, conf.HedgeChannel) {
+		return fmt.Errorf("TunnelDNS has no channel:%s", conf.HedgeChannel)
+	}
+	if len(conf.HedgeServer) > 0 {
+		conf.HedgeServer = trustedDNSAddr(conf.HedgeServer)
+	}
+	ratio, min := conf.RetryBudgetRatio, conf.MinRetriesPerSecond
+	if ratio <= 0 {
+		ratio = 0.1
+	}
+	if min <= 0 {
+		min = 5
+	}
+	dnsRetryBudget.reset(ratio, min)
+	return nil
+}
+
+// retryBudget is a token bucket filled by queries, so that hedged & retried queries never
+// multiply the load of a channel that is already lossy
+type retryBudget struct {
+	mutex  sync.Mutex
+	ratio  float64
+	min    int
+	tokens float64
+	second int64
+	used   int
+}
+
+// at most 100 retries could be saved up for bursts
+const maxRetryBudgetTokens = 100
+
+var dnsRetryBudget = &retryBudget{ratio: 0.1, min: 5}
+
+func (b *retryBudget) reset(ratio float64, min int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.ratio, b.min = ratio, min
+	b.tokens, b.used = 0, 0
+}
+
+func (b *retryBudget) deposit() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > maxRetryBudgetTokens {
+		b.tokens = maxRetryBudgetTokens
+	}
+}
+
+func (b *retryBudget) withdraw(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if sec := now.Unix(); sec != b.second {
+		b.second, b.used = sec, 0
+	}
+	if b.used < b.min {
+		b.used++
+		return true
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// hedgeQuery run 'primary' & run 'hedge' if 'primary' has not returned after 'delay' or failed, the first
+// successful response is returned, 'hedge' is only run with the budget
+func hedgeQuery(delay time.Duration, budget *retryBudget, primary, hedge func() ([]byte, error)) ([]byte, error) {
+	type result struct {
+		res []byte
+		err error
+	}
+	//buffered for the slower query which returns after this
+	results := make(chan result, 2)
+	run := func(f func() ([]byte, error)) {
+		res, err := f()
+		results <- result{res, err}
+	}
+	budget.deposit()
+	go run(primary)
+	pending := 1
+	hedged := delay <= 0
+	var timer <-chan time.Time
+	if !hedged {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+	startHedge := func() {
+		hedged = true
+		timer = nil
+		if budget.withdraw(time.Now()) {
+			pending++
+			go run(hedge)
+		}
+	}
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer:
+			startHedge()
+		case r := <-results:
+			pending--
+			if nil == r.err {
+				return r.res, nil
+			}
+			lastErr = r.err
+			if !hedged {
+				startHedge()
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// exchangeDNSByChannel send a raw dns query to server through the channel & wait for the response
+func exchangeDNSByChannel(channelName string, server string, query []byte) ([]byte, error) {
+	stream, conf, err := channel.GetMuxStreamByChannel(channelName)
+	if nil != err {
+		return nil, err
+	}
+	defer stream.Close()
+	opt := mux.StreamOptions{
+		DialTimeout: conf.RemoteDialMSTimeout,
+		ReadTimeout: conf.RemoteDNSReadMSTimeout,
+	}
+	if err = stream.Connect("udp", server, opt); nil != err {
+		return nil, err
+	}
+	streamReader, streamWriter := mux.GetCompressStreamReaderWriter(stream, conf.Compressor)
+	if closer, ok := streamReader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if _, err = streamWriter.Write(query); nil != err {
+		return nil, err
+	}
+	stream.SetReadDeadline(time.Now().Add(time.Duration(conf.RemoteDNSReadMSTimeout) * time.Millisecond))
+	b := make([]byte, 8192)
+	n, err := streamReader.Read(b)
+	if n < 12 || b[0] != query[0] || b[1] != query[1] {
+		if nil == err {
+			err = errInvalidDNSResponse
+		}
+		return nil, err
+	}
+	return b[0:n], nil
+}
+
+// tunnelDNSQuery proxy a raw dns query to server through the channel with a hedged query
+func tunnelDNSQuery(channelName string, server string, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, errInvalidDNSResponse
+	}
+	conf := &GConf.TunnelDNS
+	hedgeChannel, hedgeServer := channelName, server
+	if len(conf.HedgeChannel) > 0 {
+		hedgeChannel = conf.HedgeChannel
+	}
+	if len(conf.HedgeServer) > 0 {
+		hedgeServer = conf.HedgeServer
+	} else if trusted := GConf.LocalDNS.TrustedDNS; len(trusted) > 1 && server == trustedDNSAddr(trusted[0]) {
+		hedgeServer = trustedDNSAddr(trusted[1])
+	}
+	res, err := hedgeQuery(conf.hedgeDelay(), dnsRetryBudget, func() ([]byte, error) {
+		return exchangeDNSByChannel(channelName, server, query)
+	}, func() ([]byte, error) {
+		logger.Debug("Hedge dns query to %s by channel:%s", hedgeServer, hedgeChannel)
+		return exchangeDNSByChannel(hedgeChannel, hedgeServer, query)
+	})
+	if nil != err {
+		logger.Error("[ERROR]Failed to query dns %s by channel:%s with reason:%v", server, channelName, err)
+		return nil, err
+	}
+	return dns.ClampResponseTTL(res), nil
+}
+
+// trustedDNSAddr return the address of a dns server which may have no port
+func trustedDNSAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); nil != err {
+		return net.JoinHostPort(server, "53")
+	}
+	return server
+}
@@ -0,0 +1,69 @@
+package local
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	b := &retryBudget{ratio: 0.5, min: 1}
+	now := time.Unix(1000, 0)
+	if !b.withdraw(now) {
+		t.Fatalf("min retries per second should be allowed")
+	}
+	if b.withdraw(now) {
+		t.Fatalf("no budget left")
+	}
+	b.deposit()
+	b.deposit()
+	if !b.withdraw(now) || b.withdraw(now) {
+		t.Fatalf("2 queries should save up 1 retry")
+	}
+	if !b.withdraw(now.Add(time.Second)) {
+		t.Fatalf("min retries should be allowed in next second")
+	}
+	for i := 0; i < 1000; i++ {
+		b.deposit()
+	}
+	if b.tokens != maxRetryBudgetTokens {
+		t.Fatalf("budget should be capped at %d, but got %v", maxRetryBudgetTokens, b.tokens)
+	}
+}
+
+func TestHedgeQuery(t *testing.T) {
+	answer := func(res string, delay time.Duration, err error) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			time.Sleep(delay)
+			if nil != err {
+				return nil, err
+			}
+			return []byte(res), nil
+		}
+	}
+	errLost := errors.New("lost")
+	for _, c := range []struct {
+		name           string
+		primary, hedge func() ([]byte, error)
+		budget         *retryBudget
+		res            string
+		err            error
+	}{
+		{"fast primary", answer("a", 0, nil), answer("b", 0, nil), &retryBudget{min: 1}, "a", nil},
+		{"slow primary", answer("a", time.Second, nil), answer("b", 0, nil), &retryBudget{min: 1}, "b", nil},
+		{"failed primary", answer("", 0, errLost), answer("b", 0, nil), &retryBudget{min: 1}, "b", nil},
+		{"failed hedge", answer("a", 200*time.Millisecond, nil), answer("", 0, errLost), &retryBudget{min: 1}, "a", nil},
+		{"no budget", answer("a", 200*time.Millisecond, nil), answer("b", 0, nil), &retryBudget{}, "a", nil},
+		{"no budget to retry", answer("", 0, errLost), answer("b", 0, nil), &retryBudget{}, "", errLost},
+		{"all failed", answer("", 0, errLost), answer("", 0, errLost), &retryBudget{min: 1}, "", errLost},
+	} {
+		res, err := hedgeQuery(50*time.Millisecond, c.budget, c.primary, c.hedge)
+		if string(res) != c.res || err != c.err {
+			t.Errorf("%s: got %q %v, expect %q %v", c.name, res, err, c.res, c.err)
+		}
+	}
+	//negative delay in config disables hedging
+	if res, _ := hedgeQuery(0, &retryBudget{min: 1}, answer("", 0, errLost), answer("b", 0, nil)); nil != res {
+		t.Errorf("hedge query should not be sent without delay, but got %q", res)
+	}
+}
@@ -0,0 +1,186 @@
+package local
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/supervisor"
+)
+
+// portForward is a static forward rule, local rules listen on this side & dial the target by the channel,
+// remote rules listen on the server & dial the target from this side by reverse tunnel
+type portForward struct {
+	rule    string
+	remote  bool
+	listen  string
+	target  string
+	channel string
+}
+
+func parsePortForward(rule string) (*portForward, error) {
+	f := &portForward{rule: rule}
+	s := strings.TrimSpace(rule)
+	switch {
+	case strings.HasPrefix(s, "L:") || strings.HasPrefix(s, "l:"):
+	case strings.HasPrefix(s, "R:") || strings.HasPrefix(s, "r:"):
+		f.remote = true
+	default:
+		return nil, fmt.Errorf("invalid port forward:%s, should start with 'L:' or 'R:'", rule)
+	}
+	s = s[2:]
+	if fields := strings.Fields(s); len(fields) == 3 && strings.EqualFold(fields[1], "via") {
+		s, f.channel = fields[0], fields[2]
+	} else if len(fields) != 1 {
+		return nil, fmt.Errorf("invalid port forward:%s", rule)
+	}
+	idx := strings.Index(s, "->")
+	if idx <= 0 {
+		return nil, fmt.Errorf("invalid port forward:%s, no '->' found", rule)
+	}
+	f.listen, f.target = s[:idx], s[idx+2:]
+	for _, addr := range []string{f.listen, f.target} {
+		if _, _, err := net.SplitHostPort(addr); nil != err {
+			return nil, fmt.Errorf("invalid address:%s in port forward:%s", addr, rule)
+		}
+	}
+	if len(f.channel) == 0 {
+		f.channel = globalProxyChannel()
+	}
+	return f, nil
+}
+
+var portForwards []*portForward
+var runningForwards []net.Listener
+
+// hasReverseTunnel avoid duplicate tunnels since config may be initialized more than once
+func hasReverseTunnel(tunnels []channel.ReverseTunnelConfig, t channel.ReverseTunnelConfig) bool {
+	for _, v := range tunnels {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// initPortForwards parse forward rules, remote rules are appended to the reverse tunnels of the channel
+func (cfg *LocalConfig) initPortForwards() error {
+	var forwards []*portForward
+	for _, rule := range cfg.PortForward {
+		f, err := parsePortForward(rule)
+		if nil != err {
+			return err
+		}
+		found := false
+		for i := range cfg.Channel {
+			conf := &cfg.Channel[i]
+			if conf.Name != f.channel || !conf.Enable {
+				continue
+			}
+			found = true
+			reverse := channel.ReverseTunnelConfig{Remote: f.listen, Local: f.target}
+			if f.remote && !hasReverseTunnel(conf.Reverse, reverse) {
+				conf.Reverse = append(conf.Reverse, reverse)
+			}
+		}
+		if !found || f.channel == channel.DirectChannelName {
+			return fmt.Errorf("no enabled proxy channel:%s for port forward:%s", f.channel, rule)
+		}
+		if !f.remote {
+			forwards = append(forwards, f)
+		}
+	}
+	portForwards = forwards
+	return nil
+}
+
+func (f *portForward) serve(conn net.Conn) {
+	defer conn.Close()
+	host, _, _ := net.SplitHostPort(f.target)
+	stream, conf, err := channel.GetMuxStreamByChannelForHost(f.channel, host)
+	if nil != err || nil == stream {
+		logger.Error("[ERROR]Failed to open stream for port forward:%s with reason:%v", f.rule, err)
+		return
+	}
+	defer stream.Close()
+	maxIdleTime := streamIdleTime()
+	opt := mux.StreamOptions{
+		DialTimeout: conf.RemoteDialMSTimeout,
+		Hops:        conf.Hops,
+		ReadTimeout: int(maxIdleTime.Seconds()),
+	}
+	if err = stream.Connect("tcp", f.target, opt); nil != err {
+		logger.Error("[ERROR]Failed to connect %s for port forward:%s with reason:%v", f.target, f.rule, err)
+		return
+	}
+	streamReader, streamWriter := mux.GetCompressStreamReaderWriter(stream, conf.Compressor)
+
+	streamCtx := &proxyStreamContext{}
+	streamCtx.stream = stream
+	streamCtx.c = conn
+	streamCtx.client = conn.RemoteAddr().String()
+	streamCtx.target = f.target
+	streamCtx.channel = f.channel
+	streamCtx.protocol = "forward"
+	streamCtx.rule = "PortForward " + f.rule
+	streamCtx.start = time.Now()
+	activeStreams.Store(streamCtx, true)
+	defer streamCtx.finish()
+
+	go func() {
+		buf := channel.GetStreamBuffer()
+		io.CopyBuffer(conn, &countReader{streamReader, &streamCtx.downBytes}, *buf)
+		channel.PutStreamBuffer(buf)
+		conn.Close()
+	}()
+	buf := channel.GetStreamBuffer()
+	defer channel.PutStreamBuffer(buf)
+	countedWriter := &countWriter{streamWriter, &streamCtx.upBytes}
+	for {
+		conn.SetReadDeadline(time.Now().Add(maxIdleTime))
+		_, cerr := io.CopyBuffer(countedWriter, conn, *buf)
+		if isTimeoutErr(cerr) && time.Now().Sub(stream.LatestIOTime()) < maxIdleTime {
+			continue
+		}
+		break
+	}
+	if close, ok := streamWriter.(io.Closer); ok {
+		close.Close()
+	}
+}
+
+func startPortForwards() {
+	for _, f := range portForwards {
+		lp, err := supervisor.ListenTCP(f.listen)
+		if nil != err {
+			logger.Error("[ERROR]Failed to listen %s for port forward:%s with reason:%v", f.listen, f.rule, err)
+			continue
+		}
+		logger.Notice("Port forward %s->%s via %s started.", f.listen, f.target, f.channel)
+		runningForwards = append(runningForwards, lp)
+		go func(f *portForward) {
+			for {
+				conn, err := lp.Accept()
+				if nil != err {
+					if proxyServerRunning {
+						logger.Error("[ERROR]Port forward:%s stopped with reason:%v", f.rule, err)
+					}
+					return
+				}
+				go f.serve(conn)
+			}
+		}(f)
+	}
+}
+
+func stopPortForwards() {
+	for _, lp := range runningForwards {
+		lp.Close()
+	}
+	runningForwards = nil
+}
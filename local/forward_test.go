@@ -0,0 +1,106 @@
+package local
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+)
+
+func TestParsePortForward(t *testing.T) {
+	defer func(channels []channel.ProxyChannelConfig) { GConf.Channel = channels }(GConf.Channel)
+	GConf.Channel = []channel.ProxyChannelConfig{{Name: "direct", Enable: true}, {Name: "remoteA", Enable: true}}
+	tests := []struct {
+		rule    string
+		remote  bool
+		listen  string
+		target  string
+		channel string
+		err     bool
+	}{
+		{"L:127.0.0.1:2222->10.0.0.2:22", false, "127.0.0.1:2222", "10.0.0.2:22", "remoteA", false},
+		{" l::3306->db.internal:3306 via remoteB", false, ":3306", "db.internal:3306", "remoteB", false},
+		{"R:0.0.0.0:8080->127.0.0.1:80 VIA remoteA", true, "0.0.0.0:8080", "127.0.0.1:80", "remoteA", false},
+		{"r::8080->[::1]:80", true, ":8080", "[::1]:80", "remoteA", false},
+		{"X:127.0.0.1:2222->10.0.0.2:22", false, "", "", "", true},
+		{"L:127.0.0.1:2222=>10.0.0.2:22", false, "", "", "", true},
+		{"L:->10.0.0.2:22", false, "", "", "", true},
+		{"L:2222->10.0.0.2:22", false, "", "", "", true},
+		{"L:127.0.0.1:2222->10.0.0.2", false, "", "", "", true},
+		{"L:127.0.0.1:2222->10.0.0.2:22 by remoteA", false, "", "", "", true},
+	}
+	for _, tt := range tests {
+		f, err := parsePortForward(tt.rule)
+		if tt.err {
+			if nil == err {
+				t.Errorf("%q: expect error", tt.rule)
+			}
+			continue
+		}
+		if nil != err {
+			t.Errorf("%q: unexpected error:%v", tt.rule, err)
+			continue
+		}
+		if f.remote != tt.remote || f.listen != tt.listen || f.target != tt.target || f.channel != tt.channel || f.rule != tt.rule {
+			t.Errorf("%q: unexpected forward %+v", tt.rule, f)
+		}
+	}
+}
+
+func TestInitPortForwards(t *testing.T) {
+	defer func() { portForwards = nil }()
+	cfg := &LocalConfig{
+		Channel: []channel.ProxyChannelConfig{{Name: "direct", Enable: true}, {Name: "remoteA", Enable: true}, {Name: "remoteB"}},
+		PortForward: []string{
+			"L:127.0.0.1:2222->10.0.0.2:22 via remoteA",
+			"R::8080->127.0.0.1:80 via remoteA",
+		},
+	}
+	//init twice as config reloads
+	for i := 0; i < 2; i++ {
+		if err := cfg.initPortForwards(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if len(portForwards) != 1 || portForwards[0].listen != "127.0.0.1:2222" {
+		t.Errorf("expect only the local forward served, but got %v", portForwards)
+	}
+	reverse := cfg.Channel[1].Reverse
+	if len(reverse) != 1 || reverse[0] != (channel.ReverseTunnelConfig{Remote: ":8080", Local: "127.0.0.1:80"}) {
+		t.Errorf("expect one reverse tunnel of the remote forward, but got %v", reverse)
+	}
+
+	for _, rule := range []string{
+		"L:127.0.0.1:2222->10.0.0.2:22 via remoteB",
+		"L:127.0.0.1:2222->10.0.0.2:22 via remoteC",
+		"L:127.0.0.1:2222->10.0.0.2:22 via direct",
+		"L:127.0.0.1:2222",
+	} {
+		invalid := &LocalConfig{Channel: cfg.Channel, PortForward: []string{rule}}
+		if err := invalid.initPortForwards(); nil == err {
+			t.Errorf("%q: expect error", rule)
+		}
+		//running forwards are kept on failure
+		if len(portForwards) != 1 {
+			t.Errorf("%q: expect forwards kept, but got %v", rule, portForwards)
+		}
+	}
+}
+
+func TestStreamIdleTime(t *testing.T) {
+	defer func(timeout int) { GConf.Mux.StreamIdleTimeout = timeout }(GConf.Mux.StreamIdleTimeout)
+	tests := []struct {
+		timeout int
+		idle    time.Duration
+	}{
+		{0, 10 * time.Second},
+		{30, 30 * time.Second},
+		{-1, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		GConf.Mux.StreamIdleTimeout = tt.timeout
+		if idle := streamIdleTime(); idle != tt.idle {
+			t.Errorf("expect idle time %v of %d, but got %v", tt.idle, tt.timeout, idle)
+		}
+	}
+}
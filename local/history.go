@@ -0,0 +1,342 @@
+package local
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// HistoryConfig of the connection history kept in sqlite, searched by the dashboard
+type HistoryConfig struct {
+	//sqlite file like 'history.db', relative to the home dir, empty disables
+	Path string
+	//oldest records are deleted beyond the count, default 100000
+	MaxRecords int
+}
+
+// HistoryRecord is a finished proxy stream
+type HistoryRecord struct {
+	//unix seconds the stream started
+	Time       int64
+	Client     string
+	Host       string
+	Port       string
+	Protocol   string
+	Channel    string
+	Rule       string
+	UpBytes    int64
+	DownBytes  int64
+	DurationMS int64
+
+	proxy *ProxyConfig
+}
+
+// HistoryQuery filter records, empty fields match all
+type HistoryQuery struct {
+	//matches the domain & its sub domains
+	Domain  string
+	Channel string
+	//sub string of the matched rule
+	Rule string
+	//unix seconds
+	From int64
+	To   int64
+	//'time' or 'bytes', default 'time'
+	Sort  string
+	Limit int
+}
+
+const historyBatchSize = 256
+
+type connectionHistory struct {
+	db         *sql.DB
+	maxRecords int
+	records    chan *HistoryRecord
+	done       chan struct{}
+}
+
+// *connectionHistory, nil if disabled
+var connHistory atomic.Value
+
+func currentHistory() *connectionHistory {
+	h, _ := connHistory.Load().(*connectionHistory)
+	return h
+}
+
+func openHistory(cfg HistoryConfig) (*connectionHistory, error) {
+	path := cfg.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(proxyHome, path)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if nil != err {
+		return nil, err
+	}
+	//sqlite do NOT support concurrent writers
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS history(id INTEGER PRIMARY KEY AUTOINCREMENT, time INTEGER NOT NULL, client TEXT,
+			host TEXT, port TEXT, protocol TEXT, channel TEXT, rule TEXT, up INTEGER, down INTEGER, duration INTEGER)`,
+		"CREATE INDEX IF NOT EXISTS history_time ON history(time)",
+		"CREATE INDEX IF NOT EXISTS history_host ON history(host)",
+	} {
+		if _, err = db.Exec(stmt); nil != err {
+			db.Close()
+			return nil, err
+		}
+	}
+	h := &connectionHistory{
+		db:         db,
+		maxRecords: cfg.MaxRecords,
+		records:    make(chan *HistoryRecord, 4096),
+		done:       make(chan struct{}),
+	}
+	if h.maxRecords <= 0 {
+		h.maxRecords = 100000
+	}
+	go h.run()
+	return h, nil
+}
+
+// startHistory open the history of the config, the previous one is closed
+func startHistory() {
+	var h *connectionHistory
+	if len(GConf.History.Path) > 0 {
+		var err error
+		if h, err = openHistory(GConf.History); nil != err {
+			logger.Error("[ERROR]Failed to open connection history:%s with reason:%v", GConf.History.Path, err)
+		}
+	}
+	if old := currentHistory(); nil != old {
+		close(old.done)
+	}
+	connHistory.Store(h)
+}
+
+// add the record without blocking the stream, dropped if the writer is behind
+func (h *connectionHistory) add(r *HistoryRecord) {
+	select {
+	case h.records <- r:
+	default:
+		logger.Debug("Connection history is full, drop record of %s", r.Host)
+	}
+}
+
+// run write records in batches, routing rules are evaluated here off the proxying path
+func (h *connectionHistory) run() {
+	defer h.db.Close()
+	batch := make([]*HistoryRecord, 0, historyBatchSize)
+	written := 0
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := h.insert(batch); nil != err {
+			logger.Error("[ERROR]Failed to write connection history with reason:%v", err)
+		}
+		written += len(batch)
+		batch = batch[:0]
+		//prune once the table may grow 10% beyond the limit
+		if written >= h.maxRecords/10 {
+			written = 0
+			h.prune()
+		}
+	}
+	for {
+		select {
+		case r := <-h.records:
+			if len(r.Rule) == 0 && nil != r.proxy {
+				r.Rule = r.proxy.routeReason(r.Protocol, r.Host, r.Port)
+			}
+			batch = append(batch, r)
+			if len(batch) >= historyBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-h.done:
+			flush()
+			return
+		}
+	}
+}
+
+func (h *connectionHistory) insert(batch []*HistoryRecord) error {
+	tx, err := h.db.Begin()
+	if nil != err {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO history(time, client, host, port, protocol, channel, rule, up, down, duration) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if nil != err {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range batch {
+		if _, err = stmt.Exec(r.Time, r.Client, r.Host, r.Port, r.Protocol, r.Channel, r.Rule, r.UpBytes, r.DownBytes, r.DurationMS); nil != err {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (h *connectionHistory) prune() {
+	_, err := h.db.Exec("DELETE FROM history WHERE id <= (SELECT MAX(id) FROM history) - ?", h.maxRecords)
+	if nil != err {
+		logger.Error("[ERROR]Failed to prune connection history with reason:%v", err)
+	}
+}
+
+// buildHistoryQuery return the sql & args of the query
+func buildHistoryQuery(q *HistoryQuery) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if len(q.Domain) > 0 {
+		domain := strings.ToLower(strings.TrimPrefix(q.Domain, "."))
+		conds = append(conds, "(host = ? OR host LIKE ?)")
+		args = append(args, domain, "%."+domain)
+	}
+	if len(q.Channel) > 0 {
+		conds = append(conds, "channel = ?")
+		args = append(args, q.Channel)
+	}
+	if len(q.Rule) > 0 {
+		conds = append(conds, "rule LIKE ?")
+		args = append(args, "%"+q.Rule+"%")
+	}
+	if q.From > 0 {
+		conds = append(conds, "time >= ?")
+		args = append(args, q.From)
+	}
+	if q.To > 0 {
+		conds = append(conds, "time < ?")
+		args = append(args, q.To)
+	}
+	query := "SELECT time, client, host, port, protocol, channel, rule, up, down, duration FROM history"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if q.Sort == "bytes" {
+		query += " ORDER BY up + down DESC"
+	} else {
+		query += " ORDER BY id DESC"
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	query += " LIMIT ?"
+	args = append(args, limit)
+	return query, args
+}
+
+func (h *connectionHistory) search(q *HistoryQuery) ([]HistoryRecord, error) {
+	query, args := buildHistoryQuery(q)
+	rows, err := h.db.Query(query, args...)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []HistoryRecord{}
+	for rows.Next() {
+		var r HistoryRecord
+		if err = rows.Scan(&r.Time, &r.Client, &r.Host, &r.Port, &r.Protocol, &r.Channel, &r.Rule, &r.UpBytes, &r.DownBytes, &r.DurationMS); nil != err {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// recordHistory add the finished stream to the history if enabled
+func (ctx *proxyStreamContext) recordHistory() {
+	h := currentHistory()
+	if nil == h || ctx.start.IsZero() {
+		return
+	}
+	r := &HistoryRecord{
+		Time:       ctx.start.Unix(),
+		Client:     ctx.client,
+		Host:       ctx.target,
+		Protocol:   ctx.protocol,
+		Channel:    ctx.channel,
+		Rule:       ctx.rule,
+		UpBytes:    atomic.LoadInt64(&ctx.upBytes),
+		DownBytes:  atomic.LoadInt64(&ctx.downBytes),
+		DurationMS: int64(time.Now().Sub(ctx.start) / time.Millisecond),
+		proxy:      ctx.proxy,
+	}
+	if host, port, err := net.SplitHostPort(ctx.target); nil == err {
+		r.Host, r.Port = host, port
+	}
+	if len(ctx.routeHost) > 0 {
+		//the destination may be replaced by SNI hosts after routing
+		r.Host = ctx.routeHost
+	}
+	r.Host = strings.ToLower(r.Host)
+	h.add(r)
+}
+
+// parseHistoryTime accept unix seconds, RFC3339 & local time like '2006-01-02T15:04' or '2006-01-02'
+func parseHistoryTime(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); nil == err {
+		return secs, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); nil == err {
+		return t.Unix(), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04", s, time.Local)
+	if nil != err {
+		t, err = time.ParseInLocation("2006-01-02", s, time.Local)
+	}
+	return t.Unix(), err
+}
+
+// historyCallback search the history by '?domain=&channel=&rule=&from=&to=&sort=&limit='
+func historyCallback(w http.ResponseWriter, r *http.Request) {
+	h := currentHistory()
+	if nil == h {
+		http.Error(w, "Connection history is disabled", http.StatusNotFound)
+		return
+	}
+	params := r.URL.Query()
+	q := &HistoryQuery{
+		Domain:  params.Get("domain"),
+		Channel: params.Get("channel"),
+		Rule:    params.Get("rule"),
+		Sort:    params.Get("sort"),
+	}
+	var err error
+	if q.From, err = parseHistoryTime(params.Get("from")); nil != err {
+		http.Error(w, "invalid 'from':"+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseHistoryTime(params.Get("to")); nil != err {
+		http.Error(w, "invalid 'to':"+err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Limit, _ = strconv.Atoi(params.Get("limit"))
+	records, err := h.search(q)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(records)
+	w.Write(js)
+}
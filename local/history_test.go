@@ -0,0 +1,41 @@
+package local
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildHistoryQuery(t *testing.T) {
+	query, args := buildHistoryQuery(&HistoryQuery{Domain: ".Google.com", Channel: "remoteA", From: 100, Sort: "bytes"})
+	expected := "SELECT time, client, host, port, protocol, channel, rule, up, down, duration FROM history" +
+		" WHERE (host = ? OR host LIKE ?) AND channel = ? AND time >= ? ORDER BY up + down DESC LIMIT ?"
+	if query != expected {
+		t.Errorf("unexpected query:%s", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"google.com", "%.google.com", "remoteA", int64(100), 100}) {
+		t.Errorf("unexpected args:%v", args)
+	}
+	if query, args = buildHistoryQuery(&HistoryQuery{Limit: 5}); query != "SELECT time, client, host, port, protocol, channel, rule, up, down, duration FROM history ORDER BY id DESC LIMIT ?" ||
+		!reflect.DeepEqual(args, []interface{}{5}) {
+		t.Errorf("unexpected query:%s with args:%v", query, args)
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	local := time.Date(2026, 10, 13, 22, 30, 0, 0, time.Local).Unix()
+	for s, expected := range map[string]int64{
+		"":                     0,
+		"1700000000":           1700000000,
+		"2023-11-14T22:13:20Z": 1700000000,
+		"2026-10-13T22:30":     local,
+		"2026-10-13":           local - (22*3600 + 30*60),
+	} {
+		if v, err := parseHistoryTime(s); nil != err || v != expected {
+			t.Errorf("parse %s expect %d, but got %d with err:%v", s, expected, v, err)
+		}
+	}
+	if _, err := parseHistoryTime("last night"); nil == err {
+		t.Errorf("expect error for invalid time")
+	}
+}
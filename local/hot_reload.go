@@ -0,0 +1,119 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// hotConfig is the part of GConf swapped by HotReload & rule updates, it's never modified once published,
+// chains are resolved into routing rules of proxies
+type hotConfig struct {
+	proxies    []*ProxyConfig
+	sni        SNIConfig
+	proxyLimit channel.ProxyLimitConfig
+}
+
+var hotConf atomic.Value
+
+// serialize Reload, HotReload & rule bundle updates, which may come from SIGHUP, admin api & rule update loop
+var reloadMutex sync.Mutex
+
+func currentHotConf() *hotConfig {
+	if c, ok := hotConf.Load().(*hotConfig); ok {
+		return c
+	}
+	return &hotConfig{}
+}
+
+// publishHotConf publish copies of the hot reloadable part of the config
+func publishHotConf(cfg *LocalConfig) {
+	c := &hotConfig{sni: cfg.SNI, proxyLimit: cfg.ProxyLimit}
+	for i := range cfg.Proxy {
+		proxy := cfg.Proxy[i]
+		c.proxies = append(c.proxies, &proxy)
+	}
+	hotConf.Store(c)
+}
+
+// proxy return the current config of GConf.Proxy[idx]
+func (c *hotConfig) proxy(idx int) *ProxyConfig {
+	if idx < len(c.proxies) {
+		return c.proxies[idx]
+	}
+	return &GConf.Proxy[idx]
+}
+
+// proxyByLocal return the current config of the proxy listening on 'local', the first one if 'local' is empty
+func (c *hotConfig) proxyByLocal(local string) *ProxyConfig {
+	for _, proxy := range c.proxies {
+		if len(local) == 0 || proxy.Local == local {
+			return proxy
+		}
+	}
+	return nil
+}
+
+// withProxies publish a copy of current config with proxies updated by f, the caller must hold reloadMutex
+func (c *hotConfig) withProxies(f func(proxy *ProxyConfig)) *hotConfig {
+	next := *c
+	next.proxies = make([]*ProxyConfig, 0, len(c.proxies))
+	for _, proxy := range c.proxies {
+		p := *proxy
+		f(&p)
+		next.proxies = append(next.proxies, &p)
+	}
+	hotConf.Store(&next)
+	return &next
+}
+
+// HotReload re-read the config file & swap routing rules of local proxies(matched by 'Local'),
+// proxy limit & SNI redirects, channels & listeners are kept, use Reload to apply other changes
+func HotReload() error {
+	if len(runningOptions.Config) == 0 {
+		return errors.New("No config file to reload")
+	}
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	confdata, err := helper.ReadWithoutComment(runningOptions.Config, "//")
+	if nil != err {
+		return err
+	}
+	var conf LocalConfig
+	if err = json.Unmarshal(confdata, &conf); nil != err {
+		return err
+	}
+	//channels are kept, so rule targets are validated against the running ones
+	for i := range conf.Proxy {
+		if err = conf.Proxy[i].compile(conf.Chains, GConf.Channel); nil != err {
+			return fmt.Errorf("proxy:%s has %v", conf.Proxy[i].Local, err)
+		}
+	}
+	current := *currentHotConf()
+	current.sni, current.proxyLimit = conf.SNI, conf.ProxyLimit
+	next := current.withProxies(func(proxy *ProxyConfig) {
+		for _, newProxy := range conf.Proxy {
+			if newProxy.Local != proxy.Local {
+				continue
+			}
+			proxy.PAC = newProxy.PAC
+			proxy.Rules, proxy.rules = newProxy.Rules, newProxy.rules
+			proxy.Auth = newProxy.Auth
+			proxy.HTTPDump = newProxy.HTTPDump
+			proxy.WhenChannelDown = newProxy.WhenChannelDown
+			proxy.SocksResolve = newProxy.SocksResolve
+			break
+		}
+	})
+	swapIPSets(next.proxyLimit.IPSets)
+	channel.SetDefaultProxyLimitConfig(next.proxyLimit)
+	routeCache.flush()
+	logger.Notice("Hot reload routing rules, proxy limit & SNI from config:%s", runningOptions.Config)
+	return nil
+}
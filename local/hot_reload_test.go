@@ -0,0 +1,99 @@
+package local
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+)
+
+func TestHotReload(t *testing.T) {
+	defer func(options ProxyOptions) { runningOptions = options }(runningOptions)
+	defer hotConf.Store(currentHotConf())
+	defer restoreRuleDBs(currentRuleDBs())
+	defer channel.SetDefaultProxyLimitConfig(channel.ProxyLimitConfig{})
+	defer routeCache.flush()
+	dir, _ := ioutil.TempDir("", "hotreload")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "client.json")
+
+	runningOptions.Config = ""
+	if err := HotReload(); nil == err {
+		t.Errorf("expect error without config file")
+	}
+	runningOptions.Config = file
+	publishHotConf(&LocalConfig{
+		Proxy: []ProxyConfig{
+			{Local: ":48100", PAC: []PACConfig{{Remote: "remoteA"}}},
+			{Local: ":48101", PAC: []PACConfig{{Remote: "remoteA"}}, Auth: map[string]string{"u": "p"}},
+		},
+		SNI: SNIConfig{Redirect: map[string]string{"a.com": "b.com"}},
+	})
+	prev := currentHotConf()
+	tests := []struct {
+		name    string
+		content string
+		err     bool
+		remote  string
+	}{
+		{"missing", "", true, "remoteA"},
+		{"invalid json", `{"Proxy":[`, true, "remoteA"},
+		{"invalid proxy", `{"Proxy":[{"Local":":48100","SocksResolve":"invalid"}]}`, true, "remoteA"},
+		{"valid", `{
+			//comments are allowed as the config loaded at startup
+			"Proxy":[{"Local":":48100","PAC":[{"Remote":"remoteB"}],"WhenChannelDown":"reject"},{"Local":":48200","PAC":[{"Remote":"remoteC"}]}],
+			"ProxyLimit":{"IPSets":{"office":["10.0.0.0/8"]}}
+		}`, false, "remoteB"},
+	}
+	for _, tt := range tests {
+		os.Remove(file)
+		if len(tt.content) > 0 {
+			ioutil.WriteFile(file, []byte(tt.content), 0644)
+		}
+		routeCache.put("test", "remoteA", nil)
+		err := HotReload()
+		if (nil != err) != tt.err {
+			t.Errorf("%s: expect error %v, but got %v", tt.name, tt.err, err)
+		}
+		if remote := currentHotConf().proxyByLocal(":48100").PAC[0].Remote; remote != tt.remote {
+			t.Errorf("%s: expect routed to %s, but got %s", tt.name, tt.remote, remote)
+		}
+		if _, _, cached := routeCache.get("test"); cached != tt.err {
+			t.Errorf("%s: expect route cache flushed %v", tt.name, !tt.err)
+		}
+	}
+	c := currentHotConf()
+	if p := c.proxyByLocal(":48100"); p.WhenChannelDown != "reject" {
+		t.Errorf("expect rules of proxy :48100 swapped, but got %+v", p)
+	}
+	//proxies not in the new config are kept, new proxies need Reload
+	if p := c.proxyByLocal(":48101"); nil == p || p.PAC[0].Remote != "remoteA" || p.Auth["u"] != "p" {
+		t.Errorf("expect proxy :48101 kept as is, but got %+v", p)
+	}
+	if nil != c.proxyByLocal(":48200") || len(c.proxies) != 2 {
+		t.Errorf("expect listeners kept, but got %d proxies", len(c.proxies))
+	}
+	if len(c.sni.Redirect) != 0 || len(c.proxyLimit.IPSets) != 1 || len(currentRuleDBs().ipSets) != 1 {
+		t.Errorf("expect SNI & proxy limit swapped, but got %+v", c)
+	}
+	//published configs are never modified
+	if prev.proxyByLocal(":48100").PAC[0].Remote != "remoteA" || len(prev.sni.Redirect) != 1 {
+		t.Errorf("expect previous config untouched")
+	}
+
+	w := httptest.NewRecorder()
+	hotReloadCallback(w, httptest.NewRequest("GET", "/api/hotreload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect %d of GET, but got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	os.Remove(file)
+	w = httptest.NewRecorder()
+	hotReloadCallback(w, httptest.NewRequest("POST", "/api/hotreload", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expect %d of failed reload, but got %d", http.StatusInternalServerError, w.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+var errEarlyResponse = errors.New("final response received before request body")
+
+// max wait time of the 100 response before sending the body anyway, like curl
+const expectContinueTimeout = 1 * time.Second
+
+func parseStatusCode(p []byte) int {
+	if len(p) < 12 || !strings.HasPrefix(string(p[:7]), "HTTP/1.") {
+		return 0
+	}
+	code, _ := strconv.Atoi(string(p[9:12]))
+	return code
+}
+
+// continueWatcher watch the status of the first response relayed to client after a request expecting 100-continue
+type continueWatcher struct {
+	io.Writer
+	waiting int32
+	status  chan int
+}
+
+func newContinueWatcher(w io.Writer) *continueWatcher {
+	return &continueWatcher{Writer: w, status: make(chan int, 1)}
+}
+
+func (w *continueWatcher) arm() {
+	select {
+	case <-w.status:
+	default:
+	}
+	atomic.StoreInt32(&w.waiting, 1)
+}
+
+func (w *continueWatcher) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&w.waiting) == 1 {
+		//interim responses like '103 Early Hints' are relayed while waiting
+		if code := parseStatusCode(p); code <= 100 || code >= 200 {
+			if atomic.CompareAndSwapInt32(&w.waiting, 1, 0) {
+				w.status <- code
+			}
+		}
+	}
+	return w.Writer.Write(p)
+}
+
+// requestWriter buffer small writes of the request, it's a io.ByteWriter so that http.Request.Write use it directly
+type requestWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (rw *requestWriter) Write(p []byte) (int, error) {
+	rw.buf = append(rw.buf, p...)
+	if len(rw.buf) >= 4096 {
+		return len(p), rw.Flush()
+	}
+	return len(p), nil
+}
+
+func (rw *requestWriter) WriteByte(c byte) error {
+	rw.buf = append(rw.buf, c)
+	return nil
+}
+
+func (rw *requestWriter) Flush() error {
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	_, err := rw.w.Write(rw.buf)
+	rw.buf = rw.buf[:0]
+	return err
+}
+
+// expectBody flush buffered headers before reading the body from client, which may wait the 100 response
+type expectBody struct {
+	io.ReadCloser
+	w       *requestWriter
+	watcher *continueWatcher
+	early   bool
+}
+
+func (b *expectBody) Read(p []byte) (int, error) {
+	if err := b.w.Flush(); nil != err {
+		return 0, err
+	}
+	if nil != b.watcher {
+		watcher := b.watcher
+		b.watcher = nil
+		select {
+		case code := <-watcher.status:
+			if code >= 200 {
+				b.early = true
+				return 0, errEarlyResponse
+			}
+		case <-time.After(expectContinueTimeout):
+			atomic.StoreInt32(&watcher.waiting, 0)
+		}
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// Close do not drain the body after an early response, the client may never send it
+func (b *expectBody) Close() error {
+	if b.early {
+		return nil
+	}
+	return b.ReadCloser.Close()
+}
+
+// writeProxyRequest write the request headers before the body, a body expecting 100-continue is sent after the
+// 100 response relayed, errEarlyResponse is returned if the origin answered with a final status instead
+func writeProxyRequest(req *http.Request, w io.Writer, watcher *continueWatcher) error {
+	bw := &requestWriter{w: w}
+	var body *expectBody
+	if nil != req.Body && req.Body != http.NoBody {
+		body = &expectBody{ReadCloser: req.Body, w: bw}
+		if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+			watcher.arm()
+			body.watcher = watcher
+		}
+		req.Body = body
+		//trailers of chunked body are forwarded even if not declared by 'Trailer' header
+		if nil == req.Trailer && len(req.TransferEncoding) > 0 {
+			req.Trailer = make(http.Header)
+		}
+	}
+	if err := req.Write(bw); nil != err {
+		//body read errors are wrapped by http.Request.Write
+		if nil != body && body.early {
+			return errEarlyResponse
+		}
+		return err
+	}
+	return bw.Flush()
+}
+
+// waitResponseRelayed wait the response relayed to client until the stream is idle for a while
+func waitResponseRelayed(stream mux.MuxStream, closeCh chan int, maxIdleTime time.Duration) {
+	deadline := time.Now().Add(maxIdleTime)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for time.Now().Before(deadline) {
+		select {
+		case <-closeCh:
+			return
+		case <-ticker.C:
+			if time.Now().Sub(stream.LatestIOTime()) > time.Second {
+				return
+			}
+		}
+	}
+}
+
+// retryableConnectError return true if the server failed to reach the destination by network,
+// destinations refused or denied by ACL fail on other servers too
+func retryableConnectError(err error) bool {
+	connErr, ok := err.(*mux.ConnectError)
+	if !ok {
+		return false
+	}
+	switch connErr.Code {
+	case mux.DialErrTimeout, mux.DialErrUnreachable, mux.DialErrOther:
+		return true
+	}
+	return false
+}
+
+// writeConnectErrorPage answer the http request failed to connect by the error class
+func writeConnectErrorPage(w io.Writer, err error) {
+	status := http.StatusBadGateway
+	switch channel.ConnectErrorCode(err) {
+	case mux.DialErrTimeout:
+		status = http.StatusGatewayTimeout
+	case mux.DialErrNotAllowed:
+		status = http.StatusForbidden
+	}
+	body := fmt.Sprintf("gsnova: %v\n", err)
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), len(body), body)
+}
@@ -0,0 +1,176 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// notifyWriter signal once anything written upstream
+type notifyWriter struct {
+	bytes.Buffer
+	written chan bool
+}
+
+func (w *notifyWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	select {
+	case w.written <- true:
+	default:
+	}
+	return n, err
+}
+
+// expectRequest return a request whose body is available only after 'send' is closed
+func expectRequest(t *testing.T, send chan bool) *http.Request {
+	head := "PUT /upload HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"
+	body := readerFunc(func(p []byte) (int, error) {
+		<-send
+		return copy(p, "hello"), io.EOF
+	})
+	req, err := http.ReadRequest(bufio.NewReader(io.MultiReader(strings.NewReader(head), body)))
+	if nil != err {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestWriteProxyRequestContinue(t *testing.T) {
+	send := make(chan bool)
+	req := expectRequest(t, send)
+	upstream := &notifyWriter{written: make(chan bool, 1)}
+	watcher := newContinueWatcher(ioutil.Discard)
+	done := make(chan error, 1)
+	go func() { done <- writeProxyRequest(req, upstream, watcher) }()
+	select {
+	case <-upstream.written:
+	case <-time.After(time.Second):
+		t.Fatal("headers are not flushed before the body")
+	}
+	watcher.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+	close(send)
+	if err := <-done; nil != err {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(upstream.String(), "\r\n\r\nhello") {
+		t.Fatalf("unexpected request:%q", upstream.String())
+	}
+}
+
+func TestWriteProxyRequestEarlyResponse(t *testing.T) {
+	req := expectRequest(t, make(chan bool))
+	upstream := &notifyWriter{written: make(chan bool, 1)}
+	watcher := newContinueWatcher(ioutil.Discard)
+	done := make(chan error, 1)
+	go func() { done <- writeProxyRequest(req, upstream, watcher) }()
+	<-upstream.written
+	watcher.Write([]byte("HTTP/1.1 417 Expectation Failed\r\nContent-Length: 0\r\n\r\n"))
+	select {
+	case err := <-done:
+		if err != errEarlyResponse {
+			t.Fatalf("expect early response, but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request body is still waited after early response")
+	}
+	if strings.Contains(upstream.String(), "hello") {
+		t.Fatal("body sent after early response")
+	}
+}
+
+func TestWriteProxyRequestContinueTimeout(t *testing.T) {
+	send := make(chan bool)
+	close(send)
+	req := expectRequest(t, send)
+	var upstream bytes.Buffer
+	start := time.Now()
+	if err := writeProxyRequest(req, &upstream, newContinueWatcher(ioutil.Discard)); nil != err {
+		t.Fatal(err)
+	}
+	if time.Now().Sub(start) < expectContinueTimeout {
+		t.Fatal("body sent without waiting the 100 response")
+	}
+	if !strings.HasSuffix(upstream.String(), "hello") {
+		t.Fatalf("unexpected request:%q", upstream.String())
+	}
+}
+
+func TestWriteProxyRequestTrailer(t *testing.T) {
+	cases := []string{
+		"POST /x HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n",
+		//undeclared trailer
+		"POST /x HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n",
+	}
+	for _, c := range cases {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(c)))
+		if nil != err {
+			t.Fatal(err)
+		}
+		var upstream bytes.Buffer
+		if err := writeProxyRequest(req, &upstream, newContinueWatcher(ioutil.Discard)); nil != err {
+			t.Fatal(err)
+		}
+		forwarded, err := http.ReadRequest(bufio.NewReader(&upstream))
+		if nil != err {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(forwarded.Body)
+		if string(body) != "hello" || forwarded.Trailer.Get("X-Sum") != "1" {
+			t.Fatalf("body:%q trailer:%v", body, forwarded.Trailer)
+		}
+	}
+}
+
+func TestParseStatusCode(t *testing.T) {
+	cases := map[string]int{
+		"HTTP/1.1 100 Continue\r\n": 100,
+		"HTTP/1.0 200 OK\r\n":       200,
+		"HTTP/1.1 103 Early Hints":  103,
+		"garbage":                   0,
+		"HTTP/2 200":                0,
+	}
+	for s, code := range cases {
+		if parseStatusCode([]byte(s)) != code {
+			t.Errorf("parseStatusCode(%q) should be %d", s, code)
+		}
+	}
+}
+
+func TestWriteConnectErrorPage(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		status    int
+		retryable bool
+	}{
+		{&mux.ConnectError{Code: mux.DialErrTimeout, Reason: "i/o timeout"}, http.StatusGatewayTimeout, true},
+		{&mux.ConnectError{Code: mux.DialErrNotAllowed, Reason: "not allowed by ACL"}, http.StatusForbidden, false},
+		{&mux.ConnectError{Code: mux.DialErrRefused, Reason: "connection refused"}, http.StatusBadGateway, false},
+		{&mux.ConnectError{Code: mux.DialErrUnreachable, Reason: "no route to host"}, http.StatusBadGateway, true},
+		{io.EOF, http.StatusBadGateway, false},
+	} {
+		var buf bytes.Buffer
+		writeConnectErrorPage(&buf, c.err)
+		res, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+		if nil != err {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != c.status || !strings.Contains(string(body), c.err.Error()) {
+			t.Errorf("%v answered by %d %q, expect %d", c.err, res.StatusCode, body, c.status)
+		}
+		if retryableConnectError(c.err) != c.retryable {
+			t.Errorf("%v should be retryable: %v", c.err, c.retryable)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/netx"
+)
+
+const (
+	defaultSTUNServer = "stun.l.google.com:19302"
+	defaultIPv6Probe  = "[2001:4860:4860::8888]:53"
+	leakProbeTimeout  = 3 * time.Second
+
+	LeakStatusOK      = "ok"
+	LeakStatusLeak    = "leak"
+	LeakStatusWarn    = "warn"
+	LeakStatusSkipped = "skipped"
+)
+
+// LeakAuditConfig of checks on dns, udp(WebRTC like) & ipv6 traffic not going through the proxy
+type LeakAuditConfig struct {
+	//audit every seconds in background & log leaks, 0 means only on demand by '/api/leaks?run=1'
+	IntervalSecs int
+	//server of the udp leak test, default 'stun.l.google.com:19302'
+	STUNServer string
+	//address routed for the ipv6 leak test, default '[2001:4860:4860::8888]:53'
+	IPv6Probe string
+}
+
+// LeakFinding is the result of one check, 'Hint' tells how to fix leaks & warnings
+type LeakFinding struct {
+	Check  string
+	Status string
+	Detail string
+	Hint   string `json:",omitempty"`
+}
+
+type LeakAuditReport struct {
+	Time     int64
+	Leaks    int
+	Findings []LeakFinding
+}
+
+var lastLeakReport atomic.Value
+var leakAuditMutex sync.Mutex
+var leakAuditStarted int32
+
+// routeLocalIP return the local ip the system routes datagrams to the address by, nothing is sent
+func routeLocalIP(network, addr string) (net.IP, error) {
+	c, err := net.Dial(network, addr)
+	if nil != err {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// routedByTUN return true if flows to the address enter the tun device
+func routedByTUN(network, addr string) bool {
+	if !GConf.TUN.Enable {
+		return false
+	}
+	ip, err := routeLocalIP(network, addr)
+	return nil == err && ip.Equal(net.ParseIP(GConf.TUN.Addr))
+}
+
+// tunRoute return the channel routing the tun flow by rules of the tun proxy
+func tunRoute(proto string, ip string, port string) string {
+	proxy := currentHotConf().proxyByLocal(GConf.TUN.Proxy)
+	if nil == proxy {
+		return ""
+	}
+	return proxy.getProxyChannelByHost(proto, ip, port)
+}
+
+func systemNameservers() ([]string, error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("system resolvers are not readable on %s", runtime.GOOS)
+	}
+	cfg, err := mdns.ClientConfigFromFile("/etc/resolv.conf")
+	if nil != err {
+		return nil, err
+	}
+	return cfg.Servers, nil
+}
+
+// auditDNS check the system resolvers are the local dns listener, or queries to them are proxied by tun
+func auditDNS() LeakFinding {
+	f := LeakFinding{Check: "dns", Status: LeakStatusOK}
+	servers, err := systemNameservers()
+	if nil != err {
+		f.Status, f.Detail = LeakStatusSkipped, err.Error()
+		return f
+	}
+	listenHost, _, _ := net.SplitHostPort(GConf.LocalDNS.Listen)
+	//local resolvers query upstreams in plain text without secure servers
+	plainUpstream := len(GConf.LocalDNS.SecureDNS) == 0
+	var details []string
+	warn := func(detail string, hint string) {
+		details = append(details, detail)
+		if f.Status != LeakStatusLeak {
+			//hints of leaks come first
+			f.Status, f.Hint = LeakStatusWarn, hint
+		}
+	}
+	for _, server := range servers {
+		addr := net.JoinHostPort(server, "53")
+		switch {
+		case len(listenHost) > 0 && (server == listenHost || (net.ParseIP(server).IsLoopback() && net.ParseIP(listenHost).IsUnspecified())):
+			if plainUpstream {
+				warn(server+" is the local dns listener, which queries 'TrustedDNS' in plain text", "configure 'SecureDNS' of 'LocalDNS'")
+			} else {
+				details = append(details, server+" is the local dns listener")
+			}
+		case routedByTUN("udp", addr):
+			route := tunRoute("dns", server, "53")
+			if (route == channel.DirectChannelName || dns.FakeIPEnabled()) && plainUpstream {
+				//answered by local resolvers instead of the server
+				warn(server+" is answered by 'LocalDNS' resolvers of tun flows in plain text", "configure 'SecureDNS' of 'LocalDNS', or route 'dns' protocol through a proxy channel")
+			} else {
+				details = append(details, server+" is proxied by tun via "+route)
+			}
+		case net.ParseIP(server).IsLoopback():
+			//like systemd-resolved, the upstream is unknown
+			warn(server+" is a local resolver other than gsnova", "forward the local resolver to 'LocalDNS' 'Listen', or set it as the system resolver")
+		default:
+			f.Status = LeakStatusLeak
+			details = append(details, server+" is queried directly, names of visited sites are exposed to it")
+			f.Hint = "set 'LocalDNS' 'Listen' as the system resolver, or route it through tun, or let apps resolve by the proxy(socks5h)"
+		}
+	}
+	f.Detail = strings.Join(details, "; ")
+	return f
+}
+
+func stunServerAddr(conf *LeakAuditConfig) (*net.UDPAddr, error) {
+	server := conf.STUNServer
+	if len(server) == 0 {
+		server = defaultSTUNServer
+	}
+	return net.ResolveUDPAddr("udp4", server)
+}
+
+// auditUDP compare the public ip of udp flows by the default route with the one by the proxy's own sockets,
+// which are the same if WebRTC could expose the real ip
+func auditUDP(conf *LeakAuditConfig) LeakFinding {
+	f := LeakFinding{Check: "webrtc", Status: LeakStatusOK}
+	server, err := stunServerAddr(conf)
+	if nil != err {
+		f.Status, f.Detail = LeakStatusSkipped, err.Error()
+		return f
+	}
+	port := strconv.Itoa(server.Port)
+	if GConf.TUN.Enable {
+		if !routedByTUN("udp4", server.String()) {
+			f.Status, f.Detail = LeakStatusLeak, "udp to "+server.String()+" does not enter the tun device"
+			f.Hint = "route the default traffic to the tun device"
+			return f
+		}
+		if route := tunRoute(udpProtocol(server.Port), server.IP.String(), port); route == channel.DirectChannelName {
+			f.Status, f.Detail = LeakStatusLeak, "udp to "+server.String()+" is sent directly by rules of tun flows"
+			f.Hint = "route 'udp' protocol through a proxy channel, or reject it"
+			return f
+		}
+		if !tunSocketOverridden {
+			//the proxy's own sockets enter tun too, public ips are not comparable
+			f.Detail = "udp flows enter the tun device & are proxied"
+			return f
+		}
+	}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if nil != err {
+		f.Status, f.Detail = LeakStatusSkipped, err.Error()
+		return f
+	}
+	defer conn.Close()
+	exposed, err := stunMappedIP(conn, server, leakProbeTimeout)
+	if nil != err {
+		f.Detail = "udp to stun server blocked:" + err.Error()
+		return f
+	}
+	var publicIP net.IP
+	if direct, err := netx.ListenUDP("udp4", &net.UDPAddr{}); nil == err {
+		publicIP, _ = stunMappedIP(direct, server, leakProbeTimeout)
+		direct.Close()
+	}
+	switch {
+	case nil == publicIP:
+		f.Status, f.Detail = LeakStatusWarn, "udp exits by "+exposed.String()+", the real public ip is unknown to compare"
+	case publicIP.Equal(exposed):
+		f.Status, f.Detail = LeakStatusLeak, "udp exits by the real public ip "+exposed.String()+", WebRTC could expose it"
+		f.Hint = "enable 'TUN' to proxy udp, or disable non-proxied udp of WebRTC in browsers"
+	default:
+		f.Detail = "udp exits by " + exposed.String() + " instead of the real public ip " + publicIP.String()
+	}
+	return f
+}
+
+func hasGlobalIPv6() (net.IP, bool) {
+	addrs, err := net.InterfaceAddrs()
+	if nil != err {
+		return nil, false
+	}
+	_, ula, _ := net.ParseCIDR("fc00::/7")
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || nil != ipnet.IP.To4() || !ipnet.IP.IsGlobalUnicast() || ula.Contains(ipnet.IP) {
+			continue
+		}
+		return ipnet.IP, true
+	}
+	return nil, false
+}
+
+// auditIPv6 check ipv6 flows bypassing the tun device, which only proxies ipv4
+func auditIPv6(conf *LeakAuditConfig) LeakFinding {
+	f := LeakFinding{Check: "ipv6", Status: LeakStatusOK}
+	ip, ok := hasGlobalIPv6()
+	if !ok {
+		f.Detail = "no global ipv6 address"
+		return f
+	}
+	probe := conf.IPv6Probe
+	if len(probe) == 0 {
+		probe = defaultIPv6Probe
+	}
+	local, err := routeLocalIP("udp6", probe)
+	if nil != err {
+		f.Detail = "no ipv6 route by " + ip.String()
+		return f
+	}
+	if GConf.TUN.Enable {
+		f.Status, f.Detail = LeakStatusLeak, "ipv6 flows bypass the ipv4 tun device by "+local.String()
+		f.Hint = "disable ipv6 of the system or block it by firewall while tun is enabled"
+		return f
+	}
+	c, err := net.DialTimeout("tcp6", probe, leakProbeTimeout)
+	if nil != err {
+		f.Detail = "ipv6 route by " + local.String() + " is not reachable"
+		return f
+	}
+	c.Close()
+	f.Status, f.Detail = LeakStatusWarn, "ipv6 is reachable directly by "+local.String()+", apps not using the proxy expose it"
+	f.Hint = "use tun with ipv6 disabled, or make sure apps use the proxy"
+	return f
+}
+
+// runLeakAudit run all checks, audits are serialized
+func runLeakAudit() *LeakAuditReport {
+	leakAuditMutex.Lock()
+	defer leakAuditMutex.Unlock()
+	conf := GConf.LeakAudit
+	report := &LeakAuditReport{Time: time.Now().Unix()}
+	report.Findings = []LeakFinding{auditDNS(), auditUDP(&conf), auditIPv6(&conf)}
+	for _, f := range report.Findings {
+		if f.Status == LeakStatusLeak {
+			report.Leaks++
+			logger.Notice("Leak audit found %s leak:%s", f.Check, f.Detail)
+		}
+	}
+	lastLeakReport.Store(report)
+	return report
+}
+
+// startLeakAudit run audits in background by the interval of current config
+func startLeakAudit() {
+	if !atomic.CompareAndSwapInt32(&leakAuditStarted, 0, 1) {
+		return
+	}
+	go func() {
+		for {
+			interval := time.Duration(GConf.LeakAudit.IntervalSecs) * time.Second
+			if interval <= 0 {
+				time.Sleep(time.Minute)
+				continue
+			}
+			runLeakAudit()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// leakAuditCallback return the last audit report, '?run=1' audits right now
+func leakAuditCallback(w http.ResponseWriter, r *http.Request) {
+	report, _ := lastLeakReport.Load().(*LeakAuditReport)
+	if nil == report || r.URL.Query().Get("run") == "1" {
+		report = runLeakAudit()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(report)
+	w.Write(js)
+}
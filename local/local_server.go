@@ -19,6 +19,7 @@ import (
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/mux"
 	"github.com/yinqiwen/gsnova/common/socks"
+	"github.com/yinqiwen/gsnova/common/supervisor"
 	"github.com/yinqiwen/pmux"
 )
 
@@ -39,10 +40,47 @@ func isTimeoutErr(err error) bool {
 type proxyStreamContext struct {
 	stream mux.MuxStream
 	c      io.ReadWriteCloser
+
+	client    string
+	target    string
+	channel   string
+	protocol  string
+	start     time.Time
+	upBytes   int64
+	downBytes int64
+
+	//the rule recorded into history, evaluated by the route of 'proxy' if empty
+	rule      string
+	proxy     *ProxyConfig
+	routeHost string
+}
+
+// streamIdleTime is the max idle time of proxy streams, negative config means one day
+func streamIdleTime() time.Duration {
+	if GConf.Mux.StreamIdleTimeout < 0 {
+		return 24 * 3600 * time.Second
+	}
+	maxIdleTime := time.Duration(GConf.Mux.StreamIdleTimeout) * time.Second
+	if maxIdleTime == 0 {
+		maxIdleTime = 10 * time.Second
+	}
+	return maxIdleTime
+}
+
+// readEarlyData take the bytes already sent & buffered from client, never wait for more
+func readEarlyData(bufconn *helper.BufConn) []byte {
+	n := bufconn.BR.Buffered()
+	if n == 0 {
+		return nil
+	}
+	data := make([]byte, n)
+	bufconn.BR.Read(data)
+	return data
 }
 
 func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyConfig) {
-	var proxyChannelName string
+	var proxyChannelName, routeHost string
+	var routeHops []string
 	protocol := "tcp"
 	localConn := conn
 	atomic.AddInt64(&runningProxyStreamCount, 1)
@@ -55,6 +93,8 @@ func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyCo
 	}
 
 	isSocksProxy := false
+	//host of socks request & whether the client left it to proxy to resolve
+	socksHost, socksDomain := "", false
 	isHttpsProxy := false
 	isHttp11Proto := false
 	mitmEnabled := false
@@ -63,24 +103,38 @@ func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyCo
 
 	var bufconn *helper.BufConn
 
+	var mitm *mitmConn
 	buildMITMConn := func() error {
-		tlsCfg, err := helper.TLSConfig(remoteHost)
+		var err error
+		mitm, err = newMITMConn(bufconn, remoteHost, proxy.mitmProtocols(remoteHost))
 		if nil != err {
 			logger.Error("Failed to get MITM TLS config for %s  with reason:%v", remoteHost, err)
 			return err
 		}
-		tlsConn := tls.Server(bufconn, tlsCfg)
-		localConn = tlsConn
+		localConn = mitm.local
 		bufconn = helper.NewBufConn(localConn, nil)
 		mitmEnabled = true
 		return nil
 	}
 
 	if !isTransparentProxy {
-		socksConn, sbufconn, err := socks.NewSocksConn(conn)
+		var auth socks.Authenticator
+		if proxy.authRequired() {
+			auth = proxy.authenticate
+		}
+		socksConn, sbufconn, err := socks.NewSocksConnWithAuth(conn, auth)
 		if nil == err {
 			isSocksProxy = true
-			logger.Debug("Local proxy recv %s proxy conn to %s", socksConn.Version(), socksConn.Req.Target)
+			logger.Debug("Local proxy recv %s proxy conn to %s", socksConn.Scheme(), socksConn.Req.Target)
+			if host, port, _ := net.SplitHostPort(socksConn.Req.Target); proxy.rejectByChannelDown(protocol, host, port) {
+				logger.Notice("Reject socks connection to %s since its proxy channel is down", socksConn.Req.Target)
+				socksConn.Reject()
+				return
+			}
+			if socksConn.IsUDPAssociate() {
+				handleSocksUDPAssociate(socksConn, proxy)
+				return
+			}
 			socksConn.Grant(&net.TCPAddr{
 				IP: net.ParseIP("0.0.0.0"), Port: 0})
 			localConn = socksConn
@@ -95,6 +149,7 @@ func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyCo
 				logger.Error("Invalid socks target addresss:%s with reason %v", socksConn.Req.Target, err)
 				return
 			}
+			socksHost, socksDomain = remoteHost, socksConn.Req.Domain
 		} else { //not socks proxy
 			if nil == sbufconn {
 				localConn.Close()
@@ -106,6 +161,13 @@ func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyCo
 		}
 	}
 
+	if host, ok := dns.FakeIPHost(remoteHost); ok {
+		logger.Debug("Map fake ip %s to %s", remoteHost, host)
+		remoteHost = host
+		//fake ips are never dialed, the domain is resolved as socks5h
+		socksHost, socksDomain = host, true
+	}
+
 	if nil == bufconn {
 		//bufconn = bufio.NewReader(localConn)
 		bufconn = helper.NewBufConn(conn, nil)
@@ -126,15 +188,19 @@ func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyCo
 			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
 		}
 
-		if nil != dns.CNIPSet {
+		if cnipset := dns.GetCNIPSet(); nil != cnipset {
 			remoteIP := net.ParseIP(remoteHost)
-			logger.Debug("Recv proxy request to IP:%v CNIP:%v", remoteIP, dns.CNIPSet.IsInCountry(remoteIP, "CN"))
+			logger.Debug("Recv proxy request to IP:%v CNIP:%v", remoteIP, cnipset.IsInCountry(remoteIP, "CN"))
 		}
 		sni, err := helper.PeekTLSServerName(bufconn)
+		if nil == err && !helper.IsDomainName(sni) {
+			logger.Debug("Ignore invalid SNI:%q for IP:%s:%s", sni, remoteHost, remotePort)
+			err = helper.ErrNoSNI
+		}
 		if nil != err {
 			//logger.Debug("##Failed to sniff SNI with error:%v", err)
 		} else {
-			if redirect, ok := GConf.SNI.redirect(sni); ok {
+			if redirect, ok := currentHotConf().sni.redirect(sni); ok {
 				sni = redirect
 			}
 			logger.Debug("Sniffed SNI:%s:%s for IP:%s:%s", sni, remotePort, remoteHost, remotePort)
@@ -158,8 +224,9 @@ func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyCo
 		localConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
 		headChunk, err := bufconn.Peek(7)
 		if len(headChunk) != 7 {
+			//servers speak first in protocols like ssh & smtp
 			if err != io.EOF {
-				logger.Error("Peek:%s %d %v to %s:%s", string(headChunk), len(headChunk), err, remoteHost, remotePort)
+				logger.Debug("Peek:%s %d %v to %s:%s", string(headChunk), len(headChunk), err, remoteHost, remotePort)
 			}
 			goto START
 		}
@@ -197,8 +264,24 @@ func serveProxyConn(conn net.Conn, remoteHost, remotePort string, proxy *ProxyCo
 				logger.Error("Read first request failed from proxy connection for reason:%v", err)
 				return
 			}
+			if !isSocksProxy && !isTransparentProxy && proxy.authRequired() && !proxy.authenticateHTTP(initialHTTPReq) {
+				logger.Notice("Reject unauthorized http proxy request from %v", conn.RemoteAddr())
+				io.WriteString(localConn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"gsnova\"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+				return
+			}
 			//log.Printf("Host:%s %v", initialHTTPReq.Host, initialHTTPReq.URL)
-			if strings.Contains(initialHTTPReq.Host, ":") {
+			if isSocksProxy || isTransparentProxy {
+				//the destination port is known, only the host name is taken from 'Host' header
+				host := initialHTTPReq.Host
+				if h, _, err := net.SplitHostPort(host); nil == err {
+					host = h
+				}
+				if helper.IsDomainName(host) {
+					logger.Debug("Sniffed Host:%s for IP:%s:%s", host, remoteHost, remotePort)
+					remoteHost = host
+				}
+				protocol = "http"
+			} else if strings.Contains(initialHTTPReq.Host, ":") {
 				remoteHost, remotePort, _ = net.SplitHostPort(initialHTTPReq.Host)
 			} else {
 				remoteHost = initialHTTPReq.Host
@@ -235,49 +318,103 @@ START:
 		logger.Error("Can NOT resolve remote host or port %s:%s %v", remoteHost, remotePort, initialHTTPReq)
 		return
 	}
-	proxyChannelName = proxy.getProxyChannelByHost(protocol, remoteHost)
+	proxyChannelName, routeHops = proxy.getRouteByHost(protocol, remoteHost, remotePort)
+	routeHost = remoteHost
 
 	if len(proxyChannelName) == 0 {
 		logger.Error("[ERROR]No proxy found for %s:%s", protocol, remoteHost)
 		return
 	}
-	stream, conf, err := channel.GetMuxStreamByChannel(proxyChannelName)
+	if isRejectRemote(proxyChannelName) {
+		logger.Notice("Reject %s:%s by rule", remoteHost, remotePort)
+		return
+	}
+	if proxy.rejectByChannelDown(protocol, remoteHost, remotePort) {
+		logger.Notice("Reject %s:%s since proxy channel:%s is down", remoteHost, remotePort, proxyChannelName)
+		if nil != initialHTTPReq {
+			io.WriteString(localConn, "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		}
+		return
+	}
+	if proxyChannelName == channel.DirectChannelName && nil == net.ParseIP(remoteHost) && GConf.LocalDNS.RebindingProtection {
+		if _, err := dns.DnsGetDoaminIP(remoteHost); err == dns.ErrDNSRebinding {
+			logger.Error("[ERROR]Reject direct proxy to %s:%s for dns rebinding", remoteHost, remotePort)
+			return
+		}
+	}
+	stream, conf, err := channel.GetMuxStreamByChannelForHost(proxyChannelName, remoteHost)
 	if nil != err || nil == stream {
 		logger.Error("Failed to open stream for reason:%v by proxy:%s", err, proxyChannelName)
 		return
 	}
 	defer stream.Close()
 
-	var maxIdleTime time.Duration
-	if GConf.Mux.StreamIdleTimeout < 0 {
-		maxIdleTime = 24 * 3600 * time.Second
-	} else {
-		maxIdleTime = time.Duration(GConf.Mux.StreamIdleTimeout) * time.Second
-		if maxIdleTime == 0 {
-			maxIdleTime = 10 * time.Second
-		}
-	}
+	maxIdleTime := streamIdleTime()
 
-	ssid := stream.StreamID()
+	ssid := fmt.Sprintf("%s:%d", mux.GetStreamSessionID(stream), stream.StreamID())
 	opt := mux.StreamOptions{
 		DialTimeout: conf.RemoteDialMSTimeout,
 		Hops:        conf.Hops,
 		ReadTimeout: int(maxIdleTime.Seconds()),
 	}
+	if len(routeHops) > 0 {
+		opt.Hops = append(append([]string{}, conf.Hops...), routeHops...)
+	}
+	if ruleDialTimeout := proxy.getDialTimeoutByHost(protocol, remoteHost); ruleDialTimeout > 0 {
+		opt.DialTimeout = ruleDialTimeout
+	}
+	if proxyChannelName == channel.DirectChannelName {
+		opt.SocketMark, opt.DSCP = proxy.getSocketMarkByHost(protocol, remoteHost)
+	}
 
 	if remotePort == "443" && nil == net.ParseIP(remoteHost) {
 		remoteSNI := conf.GetRemoteSNI(remoteHost)
 		if len(remoteSNI) > 0 {
 			sniHost := hosts.GetHost(remoteSNI)
-			logger.Notice("Proxy stream[%d] select remote SNI host %s for proxy to %s:%s", ssid, sniHost, remoteHost, remotePort)
+			logger.Notice("Proxy stream[%s] select remote SNI host %s for proxy to %s:%s", ssid, sniHost, remoteHost, remotePort)
 			remoteHost = sniHost
 		}
 	}
+	connectHost := remoteHost
+	if isSocksProxy {
+		connectHost, err = proxy.socksConnectHost(socksHost, socksDomain, remoteHost, proxyChannelName)
+		if nil != err {
+			logger.Error("[ERROR]Failed to resolve socks target %s with reason:%v", remoteHost, err)
+			return
+		}
+		if connectHost != remoteHost {
+			logger.Debug("Proxy stream[%s] connect %s for socks target routed as %s", ssid, connectHost, remoteHost)
+		}
+	}
 
-	logger.Notice("Proxy stream[%d] select %s for proxy to %s:%s", ssid, proxyChannelName, remoteHost, remotePort)
-	err = stream.Connect("tcp", net.JoinHostPort(remoteHost, remotePort), opt)
+	//raw stream only, early data is not seen by MITM, http dump or checksum
+	if conf.EarlyData && channel.SupportEarlyData(conf, stream) && !mitmEnabled && !conf.StreamChecksum &&
+		!proxy.HTTPDump.MatchDomain(remoteHost) && (isSocksProxy || isHttpsProxy || isTransparentProxy) && nil == initialHTTPReq {
+		opt.EarlyData = readEarlyData(bufconn)
+	}
+	//plain http requests wait the result, so that failures are answered by error pages
+	opt.WaitResponse = nil != initialHTTPReq && !mitmEnabled && channel.SupportConnectResponse(conf, stream)
+	logger.Notice("Proxy stream[%s] select %s for proxy to %s:%s", ssid, proxyChannelName, remoteHost, remotePort)
+	err = stream.Connect("tcp", net.JoinHostPort(connectHost, remotePort), opt)
+	if retryableConnectError(err) {
+		//the server failed to reach the destination, try another session of the channel once
+		next, _, nerr := channel.GetMuxStreamByChannelForHost(proxyChannelName, remoteHost)
+		if nil == nerr && mux.GetStreamSessionID(next) != mux.GetStreamSessionID(stream) {
+			logger.Notice("Proxy stream[%s] retry %s:%s by another session for reason:%v", ssid, remoteHost, remotePort, err)
+			stream = next
+			defer stream.Close()
+			opt.WaitResponse = channel.SupportConnectResponse(conf, stream)
+			ssid = fmt.Sprintf("%s:%d", mux.GetStreamSessionID(stream), stream.StreamID())
+			err = stream.Connect("tcp", net.JoinHostPort(connectHost, remotePort), opt)
+		} else if nil != next {
+			next.Close()
+		}
+	}
 	if nil != err {
 		logger.Error("Connect failed from proxy connection for reason:%v", err)
+		if nil != initialHTTPReq {
+			writeConnectErrorPage(localConn, err)
+		}
 		return
 	}
 
@@ -290,13 +427,23 @@ START:
 		streamConn := &mux.MuxStreamConn{
 			MuxStream: stream,
 		}
-		tlcClientCfg := &tls.Config{
-			InsecureSkipVerify: true,
+		tlsClient, err := mitm.handshake(streamConn)
+		if nil != err {
+			logger.Error("Failed to MITM handshake for %s:%s with reason:%v", remoteHost, remotePort, err)
+			return
 		}
-		tlsClient := tls.Client(streamConn, tlcClientCfg)
+		logger.Debug("MITM stream[%s] to %s:%s negotiated protocol:%s", ssid, remoteHost, remotePort, mitm.negotiatedProtocol())
 		streamReader, streamWriter = mux.GetCompressStreamReaderWriter(tlsClient, conf.Compressor)
 	} else {
 		streamReader, streamWriter = mux.GetCompressStreamReaderWriter(stream, conf.Compressor)
+		if conf.StreamChecksum {
+			downSum, upSum := mux.NewChecksumReader(streamReader), mux.NewChecksumWriter(streamWriter)
+			streamReader, streamWriter = downSum, upSum
+			target := net.JoinHostPort(remoteHost, remotePort)
+			defer func(stream mux.MuxStream) {
+				channel.RecordStreamChecksum(stream, target, upSum.Sum(), downSum.Sum())
+			}(stream)
+		}
 	}
 
 	if proxy.HTTPDump.MatchDomain(remoteHost) {
@@ -317,33 +464,40 @@ START:
 	streamCtx := &proxyStreamContext{}
 	streamCtx.stream = stream
 	streamCtx.c = localConn
+	streamCtx.client = conn.RemoteAddr().String()
+	streamCtx.target = net.JoinHostPort(remoteHost, remotePort)
+	streamCtx.channel = proxyChannelName
+	streamCtx.protocol = protocol
+	streamCtx.proxy = proxy
+	streamCtx.routeHost = routeHost
+	streamCtx.start = time.Now()
 	activeStreams.Store(streamCtx, true)
+	defer streamCtx.finish()
+
+	raw := (isSocksProxy || isHttpsProxy || isTransparentProxy) && nil == initialHTTPReq
+	//in kernel relay needs raw bytes on both sides without compression, dump or hops
+	if lc, rc, ok := spliceConns(bufconn, stream); ok && raw && len(opt.Hops) == 0 &&
+		streamReader == io.Reader(stream) && streamWriter == io.Writer(stream) {
+		logger.Debug("Proxy stream[%s] splice %s to %s:%s", ssid, streamCtx.client, remoteHost, remotePort)
+		relaySplice(lc, rc, bufconn, streamCtx, maxIdleTime)
+		return
+	}
+	countedWriter := &countWriter{streamWriter, &streamCtx.upBytes}
 
 	closeCh := make(chan int, 1)
+	respWriter := newContinueWatcher(localConn)
 	go func() {
-		buf := make([]byte, 128*1024)
-		io.CopyBuffer(localConn, streamReader, buf)
+		buf := channel.GetStreamBuffer()
+		io.CopyBuffer(respWriter, &countReader{streamReader, &streamCtx.downBytes}, *buf)
+		channel.PutStreamBuffer(buf)
 		localConn.Close()
 		closeCh <- 1
 	}()
 
 	//start task to check stream timeout(if the stream has no read&write action more than 10s)
 
-	if (isSocksProxy || isHttpsProxy || isTransparentProxy) && nil == initialHTTPReq {
-		buf := make([]byte, 128*1024)
-		for {
-			localConn.SetReadDeadline(time.Now().Add(maxIdleTime))
-			_, cerr := io.CopyBuffer(streamWriter, bufconn, buf)
-			if isTimeoutErr(cerr) && time.Now().Sub(stream.LatestIOTime()) < maxIdleTime {
-				continue
-			}
-			//logger.Error("###%s %v after %v", remoteHost, cerr, time.Now().Sub(stream.LatestIOTime()))
-			break
-		}
-
-		if close, ok := streamWriter.(io.Closer); ok {
-			close.Close()
-		}
+	if raw {
+		relayRaw(localConn, bufconn, countedWriter, streamWriter, stream, maxIdleTime)
 	} else {
 		proxyReq := initialHTTPReq
 		initialHTTPReq = nil
@@ -351,11 +505,37 @@ START:
 			if nil != proxyReq {
 				proxyReq.Header.Del("Proxy-Connection")
 				proxyReq.Header.Del("Proxy-Authorization")
-				err = proxyReq.Write(streamWriter)
+				handled := false
+				upgrade := helper.IsUpgradeRequest(proxyReq)
+				if !upgrade && proxy.Accelerate.match(proxyReq) {
+					addr := net.JoinHostPort(remoteHost, remotePort)
+					handled, err = tryAccelerateDownload(proxyReq, addr, proxyChannelName, &proxy.Accelerate, &countWriter{localConn, &streamCtx.downBytes})
+					if nil != err {
+						if err != io.EOF {
+							logger.Error("Failed to accelerate download %s for reason:%v", proxyReq.URL, err)
+						}
+						return
+					}
+				}
+				if !handled {
+					err = writeProxyRequest(proxyReq, countedWriter, respWriter)
+				}
+				if err == errEarlyResponse {
+					//the client may still send the body, which can not be told from next request
+					logger.Notice("Close proxy connection to %s:%s after early response to %s", remoteHost, remotePort, proxyReq.URL)
+					waitResponseRelayed(stream, closeCh, maxIdleTime)
+					return
+				}
 				if nil != err {
 					logger.Error("Failed to write http request for reason:%v", err)
 					return
 				}
+				//the 101 response is relayed by the reading task, later bytes are not http any more
+				if upgrade {
+					logger.Debug("Proxy stream[%s] switch to raw relay for upgrade:%s", ssid, proxyReq.Header.Get("Upgrade"))
+					relayRaw(localConn, bufconn, countedWriter, streamWriter, stream, maxIdleTime)
+					break
+				}
 			}
 			prevReq := proxyReq
 			for {
@@ -386,64 +566,138 @@ START:
 	activeStreams.Delete(streamCtx)
 }
 
+// relayRaw copy local data to the stream until EOF or both sides idle, the stream writer is closed at last
+func relayRaw(localConn net.Conn, src io.Reader, dst io.Writer, streamWriter io.Writer, stream mux.MuxStream, maxIdleTime time.Duration) {
+	buf := channel.GetStreamBuffer()
+	defer channel.PutStreamBuffer(buf)
+	for {
+		localConn.SetReadDeadline(time.Now().Add(maxIdleTime))
+		_, cerr := io.CopyBuffer(dst, src, *buf)
+		if isTimeoutErr(cerr) && time.Now().Sub(stream.LatestIOTime()) < maxIdleTime {
+			continue
+		}
+		break
+	}
+	if close, ok := streamWriter.(io.Closer); ok {
+		close.Close()
+	}
+}
+
+// isProxyListenAddr return true if the connection is accepted on the listen address, not the tproxy-ed destination
+func isProxyListenAddr(laddr *net.TCPAddr, listenPort int) bool {
+	if laddr.Port != listenPort {
+		return false
+	}
+	_, exist := helper.GetLocalIPSet()[laddr.IP.String()]
+	return exist || laddr.IP.IsLoopback()
+}
+
+func listenLocalProxy(proxyConf *ProxyConfig) (*net.TCPListener, error) {
+	if proxyConf.TProxy {
+		return listenTProxyTCP(proxyConf.Local)
+	}
+	return supervisor.ListenTCP(proxyConf.Local)
+}
+
 func startLocalProxyServer(proxyIdx int) (*net.TCPListener, error) {
 	proxyConf := &GConf.Proxy[proxyIdx]
 	if supportTransparentProxy() {
 		go startTransparentUDProxy(proxyConf.Local, proxyConf)
 	}
-	tcpaddr, err := net.ResolveTCPAddr("tcp", proxyConf.Local)
+	listenAddr, err := net.ResolveTCPAddr("tcp", proxyConf.Local)
 	if nil != err {
 		logger.Fatal("[ERROR]Local server address:%s error:%v", proxyConf.Local, err)
 		return nil, err
 	}
-	var lp *net.TCPListener
-	lp, err = net.ListenTCP("tcp", tcpaddr)
+	listenPort := listenAddr.Port
+	lp, err := listenLocalProxy(proxyConf)
 	if nil != err {
 		logger.Fatal("Can NOT listen on address:%s", proxyConf.Local)
 		return nil, err
 	}
 	logger.Info("Listen on address %s", proxyConf.Local)
-	runningServers[proxyIdx] = lp
+	setRunningServer(proxyIdx, lp)
+	limiter := newConnLimiter(proxyConf.ConnLimit)
+	var paused int32
+	if proxyConf.pauseWhenDown() {
+		go pauseOnChannelDown(proxyIdx, &paused)
+	}
 	go func() {
 		for proxyServerRunning {
 			var conn net.Conn
 			conn, err = lp.AcceptTCP()
 			if nil != err {
-				continue
-			}
-			isTransparent := false
-			if supportTransparentProxy() {
-				tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
-				if ok {
-					_, exist := helper.GetLocalIPSet()[tcpAddr.IP.String()]
-					if !exist {
-						isTransparent = true
+				if atomic.LoadInt32(&paused) == 1 {
+					if next := resumeListen(proxyIdx, &paused); nil != next {
+						lp = next
 					}
 				}
+				continue
+			}
+			sourceIP := connSourceIP(conn)
+			if !limiter.acquire(sourceIP) {
+				logger.Notice("Reject connection from %s on %s by connection limit", sourceIP, proxyConf.Local)
+				conn.Close()
+				continue
 			}
+			//transparent connections are detected by the destination instead of the source, since local generated
+			//traffic redirected by OUTPUT chain rules comes from a local address too
 			var originalHost, originalPort string
-			if isTransparent {
-				newConn, remoteIP, remotePort, err := getOrinalTCPRemoteAddr(conn)
-				if nil != err {
-					logger.Error("Failed to get original address for transparent proxy:%v", err)
-					continue
+			if supportTransparentProxy() {
+				laddr, _ := conn.LocalAddr().(*net.TCPAddr)
+				if proxyConf.TProxy {
+					//tproxy connections are accepted with original destination as local address
+					if nil != laddr && !isProxyListenAddr(laddr, listenPort) {
+						originalHost = laddr.IP.String()
+						originalPort = fmt.Sprintf("%d", laddr.Port)
+					}
+				} else if _, remoteIP, remotePort, err := getOrinalTCPRemoteAddr(conn); nil == err {
+					//clients connected to the proxy directly are not redirected
+					if nil == laddr || !laddr.IP.Equal(remoteIP) || laddr.Port != int(remotePort) {
+						originalHost = remoteIP.String()
+						originalPort = fmt.Sprintf("%d", remotePort)
+					}
 				}
-				conn = newConn
-				originalHost = remoteIP.String()
-				originalPort = fmt.Sprintf("%d", remotePort)
 			}
-			go serveProxyConn(conn, originalHost, originalPort, &GConf.Proxy[proxyIdx])
+			go func(conn net.Conn, sourceIP string, originalHost, originalPort string) {
+				serveProxyConn(conn, originalHost, originalPort, currentHotConf().proxy(proxyIdx))
+				limiter.release(sourceIP)
+			}(conn, sourceIP, originalHost, originalPort)
 		}
 		lp.Close()
 	}()
 	return lp, nil
 }
 
+// listeners of GConf.Proxy, replaced while paused & resumed by channel down
 var runningServers []*net.TCPListener
+var runningServersMutex sync.Mutex
+
+func getRunningServer(proxyIdx int) *net.TCPListener {
+	runningServersMutex.Lock()
+	defer runningServersMutex.Unlock()
+	if proxyIdx < len(runningServers) {
+		return runningServers[proxyIdx]
+	}
+	return nil
+}
+
+// setRunningServer return false if the servers stopped, the listener should be closed then
+func setRunningServer(proxyIdx int, lp *net.TCPListener) bool {
+	runningServersMutex.Lock()
+	defer runningServersMutex.Unlock()
+	if !proxyServerRunning || proxyIdx >= len(runningServers) {
+		return false
+	}
+	runningServers[proxyIdx] = lp
+	return true
+}
 
 func startLocalServers() error {
+	runningServersMutex.Lock()
 	proxyServerRunning = true
 	runningServers = make([]*net.TCPListener, len(GConf.Proxy))
+	runningServersMutex.Unlock()
 	for i := range GConf.Proxy {
 		startLocalProxyServer(i)
 	}
@@ -452,12 +706,14 @@ func startLocalServers() error {
 }
 
 func stopLocalServers() {
+	runningServersMutex.Lock()
 	proxyServerRunning = false
 	for _, l := range runningServers {
 		if nil != l {
 			l.Close()
 		}
 	}
+	runningServersMutex.Unlock()
 	//closeAllProxySession()
 	closeAllUDPSession()
 	activeStreams.Range(func(key, value interface{}) bool {
@@ -0,0 +1,133 @@
+package local
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	_ "github.com/yinqiwen/gsnova/common/channel/direct"
+)
+
+// startUpgradeOrigin accept one upgrade request, answer 101 & reply 'pong' for the raw 'ping'
+func startUpgradeOrigin(t *testing.T) (net.Listener, chan error) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		c, err := lp.Accept()
+		if nil != err {
+			result <- err
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if nil != err {
+			result <- err
+			return
+		}
+		if req.Header.Get("Upgrade") != "websocket" || len(req.Header.Get("Proxy-Connection")) > 0 {
+			result <- fmt.Errorf("unexpected upgrade request headers:%v", req.Header)
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		b := make([]byte, 4)
+		if _, err = io.ReadFull(br, b); nil != err || string(b) != "ping" {
+			result <- fmt.Errorf("origin recv %q, %v", b, err)
+			return
+		}
+		_, err = io.WriteString(c, "pong")
+		result <- err
+	}()
+	return lp, result
+}
+
+func TestServeProxyConnUpgradeRelayRaw(t *testing.T) {
+	conf := channel.ProxyChannelConfig{
+		Name:               channel.DirectChannelName,
+		Enable:             true,
+		ConnsPerServer:     1,
+		LocalDialMSTimeout: 5000,
+		ServerList:         []string{"direct://0.0.0.0:0"},
+	}
+	conf.Adjust()
+	if !channel.NewProxyChannel(&conf).Init(true) {
+		t.Fatal("failed to init direct channel")
+	}
+	defer channel.StopLocalChannels()
+
+	origin, result := startUpgradeOrigin(t)
+	defer origin.Close()
+	client, proxySide := net.Pipe()
+	defer client.Close()
+	served := make(chan bool)
+	go func() {
+		serveProxyConn(proxySide, "", "", &ProxyConfig{Local: "127.0.0.1:0"})
+		close(served)
+	}()
+
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	addr := origin.Addr().String()
+	//the raw bytes right after the request headers must be relayed after the upgrade too
+	go fmt.Fprintf(client, "GET http://%s/ws HTTP/1.1\r\nHost: %s\r\nProxy-Connection: keep-alive\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n\r\nping", addr, addr)
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expect 101 response, but got %d", res.StatusCode)
+	}
+	b := make([]byte, 4)
+	if _, err = io.ReadFull(br, b); nil != err || string(b) != "pong" {
+		t.Fatalf("client recv %q, %v", b, err)
+	}
+	if err = <-result; nil != err {
+		t.Fatal(err)
+	}
+	client.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy connection not closed after client closed")
+	}
+}
+
+func TestReadEarlyData(t *testing.T) {
+	tests := []struct {
+		sent     string
+		buffered bool
+		early    string
+	}{
+		{"", false, ""},
+		//never wait for bytes not arrived yet
+		{"client hello", false, ""},
+		//bytes peeked by protocol detection are taken as is
+		{"client hello", true, "client hello"},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		bufconn := helper.NewBufConn(server, nil)
+		if len(tt.sent) > 0 {
+			go client.Write([]byte(tt.sent))
+			if tt.buffered {
+				bufconn.Peek(len(tt.sent))
+			}
+		}
+		if early := readEarlyData(bufconn); string(early) != tt.early {
+			t.Errorf("sent %q: expect early data %q, but got %q", tt.sent, tt.early, early)
+		}
+		client.Close()
+		server.Close()
+	}
+}
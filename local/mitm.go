@@ -0,0 +1,91 @@
+package local
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+)
+
+var errMITMUpstreamNotReady = errors.New("MITM upstream not connected")
+
+// mitmConn negotiate ALPN with the local client and the origin server in one step,
+// so that both sides speak the same protocol(h2 or http/1.1) over the relayed bytes.
+type mitmConn struct {
+	local    *tls.Conn
+	cfg      *tls.Config
+	protos   []string
+	host     string
+	raw      net.Conn
+	upstream *tls.Conn
+}
+
+func newMITMConn(conn net.Conn, remoteHost string, protos []string) (*mitmConn, error) {
+	cfg, err := helper.TLSConfig(remoteHost)
+	if nil != err {
+		return nil, err
+	}
+	return newMITMConnByConfig(conn, cfg, remoteHost, protos), nil
+}
+
+// newMITMConnByConfig serve the local client with the certificates of 'cfg'
+func newMITMConnByConfig(conn net.Conn, cfg *tls.Config, remoteHost string, protos []string) *mitmConn {
+	m := &mitmConn{cfg: cfg, protos: protos, host: remoteHost}
+	serverCfg := cfg.Clone()
+	serverCfg.GetConfigForClient = m.configForClient
+	m.local = tls.Server(conn, serverCfg)
+	return m
+}
+
+func (m *mitmConn) configForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if nil == m.raw {
+		return nil, errMITMUpstreamNotReady
+	}
+	var offers []string
+	for _, p := range hello.SupportedProtos {
+		for _, allowed := range m.protos {
+			if p == allowed {
+				offers = append(offers, p)
+			}
+		}
+	}
+	serverName := hello.ServerName
+	if len(serverName) == 0 {
+		serverName = m.host
+	}
+	m.upstream = tls.Client(m.raw, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+		NextProtos:         offers,
+	})
+	if err := m.upstream.Handshake(); nil != err {
+		return nil, err
+	}
+	cfg := m.cfg.Clone()
+	if proto := m.upstream.ConnectionState().NegotiatedProtocol; len(proto) > 0 {
+		cfg.NextProtos = []string{proto}
+	}
+	return cfg, nil
+}
+
+// handshake finish the local handshake which drives the upstream handshake with client's ALPN offers
+func (m *mitmConn) handshake(upstream net.Conn) (*tls.Conn, error) {
+	m.raw = upstream
+	if err := m.local.Handshake(); nil != err {
+		return nil, err
+	}
+	return m.upstream, nil
+}
+
+func (m *mitmConn) negotiatedProtocol() string {
+	return m.local.ConnectionState().NegotiatedProtocol
+}
+
+func (cfg *ProxyConfig) mitmProtocols(host string) []string {
+	//http dump only understands http/1.1
+	if cfg.MITMHTTP2 && !cfg.HTTPDump.MatchDomain(host) {
+		return []string{"h2", "http/1.1"}
+	}
+	return []string{"http/1.1"}
+}
@@ -0,0 +1,92 @@
+package local
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMITMProtocols(t *testing.T) {
+	tests := []struct {
+		http2  bool
+		dump   []string
+		host   string
+		protos []string
+	}{
+		{false, nil, "a.test", []string{"http/1.1"}},
+		{true, nil, "a.test", []string{"h2", "http/1.1"}},
+		{true, []string{"*.dump.test"}, "a.dump.test", []string{"http/1.1"}},
+		{true, []string{"*.dump.test"}, "a.test", []string{"h2", "http/1.1"}},
+	}
+	for _, tt := range tests {
+		cfg := &ProxyConfig{MITMHTTP2: tt.http2, HTTPDump: HTTPDumpConfig{Domain: tt.dump}}
+		if protos := cfg.mitmProtocols(tt.host); len(protos) != len(tt.protos) || protos[0] != tt.protos[0] {
+			t.Errorf("h2:%v dump:%v host:%s expect protocols %v, but got %v", tt.http2, tt.dump, tt.host, tt.protos, protos)
+		}
+	}
+}
+
+func TestMITMConnNegotiateALPN(t *testing.T) {
+	cert := testCertificate(t)
+	tests := []struct {
+		client []string
+		mitm   []string
+		origin []string
+		proto  string
+	}{
+		{[]string{"h2", "http/1.1"}, []string{"h2", "http/1.1"}, []string{"h2", "http/1.1"}, "h2"},
+		{[]string{"h2", "http/1.1"}, []string{"http/1.1"}, []string{"h2", "http/1.1"}, "http/1.1"},
+		{[]string{"http/1.1"}, []string{"h2", "http/1.1"}, []string{"h2", "http/1.1"}, "http/1.1"},
+		{[]string{"h2", "http/1.1"}, []string{"h2", "http/1.1"}, []string{"http/1.1"}, "http/1.1"},
+		{nil, []string{"h2", "http/1.1"}, []string{"h2"}, ""},
+	}
+	for _, tt := range tests {
+		originConn, upstreamConn := net.Pipe()
+		origin := tls.Server(originConn, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: tt.origin})
+		originDone := make(chan error, 1)
+		go func() {
+			originDone <- origin.Handshake()
+		}()
+		clientConn, localConn := net.Pipe()
+		client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, ServerName: "a.test", NextProtos: tt.client})
+		go client.Handshake()
+
+		m := newMITMConnByConfig(localConn, &tls.Config{Certificates: []tls.Certificate{cert}}, "a.test", tt.mitm)
+		upstream, err := m.handshake(upstreamConn)
+		if nil != err {
+			t.Errorf("client %v mitm %v origin %v handshake failed:%v", tt.client, tt.mitm, tt.origin, err)
+		} else {
+			//both sides speak the same protocol
+			if p := m.negotiatedProtocol(); p != tt.proto || upstream.ConnectionState().NegotiatedProtocol != tt.proto {
+				t.Errorf("client %v mitm %v origin %v expect protocol %q, but got local %q upstream %q", tt.client, tt.mitm, tt.origin,
+					tt.proto, p, upstream.ConnectionState().NegotiatedProtocol)
+			}
+			if err = <-originDone; nil != err {
+				t.Errorf("origin handshake failed:%v", err)
+			} else if sn := origin.ConnectionState().ServerName; sn != "a.test" {
+				t.Errorf("expect client's server name sent to origin, but got %q", sn)
+			}
+		}
+		//close the pipes first, close notify of tls conns would block on unread pipes
+		localConn.Close()
+		upstreamConn.Close()
+		clientConn.Close()
+		originConn.Close()
+	}
+}
@@ -3,9 +3,9 @@ package local
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
-	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -19,7 +19,6 @@ import (
 
 var proxyHome string
 
-var localGFWList atomic.Value
 var fetchGFWListRunning bool
 
 func init() {
@@ -29,6 +28,9 @@ func init() {
 var clientConfName = "client.json"
 var hostsConfName = "hosts.json"
 
+// options of the running proxy, used to reload config
+var runningOptions ProxyOptions
+
 func loadClientConf(conf string) error {
 	confdata, err := helper.ReadWithoutComment(conf, "//")
 	if nil != err {
@@ -39,9 +41,26 @@ func loadClientConf(conf string) error {
 	if nil != err {
 		logger.Error("Failed to unmarshal json:%s to config for reason:%v", string(confdata), err)
 	}
+	routeCache.flush()
 	return GConf.init()
 }
 
+// parseClientConf read & validate the config file without touching the running config
+func parseClientConf(conf string) (*LocalConfig, error) {
+	confdata, err := helper.ReadWithoutComment(conf, "//")
+	if nil != err {
+		return nil, err
+	}
+	cfg := &LocalConfig{}
+	if err = json.Unmarshal(confdata, cfg); nil != err {
+		return nil, err
+	}
+	if err = cfg.init(); nil != err {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 func loadHostsConf(conf string) error {
 	err := hosts.Init(conf)
 	if nil != err {
@@ -72,40 +91,43 @@ type ProxyOptions struct {
 	WatchConf bool
 }
 
-func getGFWList() *gfwlist.GFWList {
-	v := localGFWList.Load()
-	if nil != v {
-		return v.(*gfwlist.GFWList)
-	}
-	return nil
-}
-
-func loadGFWList(hc *http.Client) error {
+func fetchGFWList(hc *http.Client) (*gfwlist.GFWList, error) {
 	resp, err := hc.Get(GConf.GFWList.URL)
 	if nil != err {
 		logger.Error("Failed to fetch GFWList")
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		logger.Error("Failed to fetch GFWList with res:%v", resp)
-		return err
+		return nil, fmt.Errorf("fetch GFWList with status:%d", resp.StatusCode)
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if nil != err {
 		logger.Error("Failed to read GFWList with err:%v", err)
-		return err
+		return nil, err
 	}
 	gfw, err := gfwlist.NewFromString(string(body), true)
 	if nil != err {
 		logger.Error("Invalid GFWList content:%v", err)
-		return err
+		return nil, err
 	}
 	for _, rule := range GConf.GFWList.UserRule {
 		gfw.Add(rule)
 	}
 	logger.Info("GFWList sync success.")
-	localGFWList.Store(gfw)
+	return gfw, nil
+}
+
+func loadGFWList(hc *http.Client) error {
+	gfw, err := fetchGFWList(hc)
+	if nil != err {
+		return err
+	}
+	swapRuleDBs(func(db *ruleDatabases) {
+		db.gfwList = gfw
+		db.gfwListTime = time.Now()
+	})
 	return nil
 }
 
@@ -133,16 +155,42 @@ func initGFWList() {
 	}
 }
 
+// loadFingerprintProfile load the randomized protocol parameters of this installation, generated on first start
+func loadFingerprintProfile() {
+	file := proxyHome + "/fingerprint.json"
+	p, err := channel.LoadFingerprintProfile(file)
+	if nil != err {
+		logger.Error("[ERROR]Failed to load fingerprint profile:%s with reason:%v", file, err)
+	}
+	if nil != p {
+		channel.SetFingerprintProfile(p)
+	}
+}
+
 func StartProxy() error {
 	GConf.init()
+	publishHotConf(&GConf)
 	logger.InitLogger(GConf.Log)
 	channel.SetDefaultMuxConfig(GConf.Mux)
+	loadFingerprintProfile()
+	startHistory()
 
 	if GConf.TransparentMark > 0 {
 		enableTransparentSocketMark(GConf.TransparentMark)
 	}
+	cnipset, err := dns.LoadCountryIPSet(GConf.LocalDNS.CNIPSet, "CN")
+	if nil != err {
+		logger.Error("Failed to load IP range file:%s with reason:%v", GConf.LocalDNS.CNIPSet, err)
+	}
+	swapRuleDBs(func(db *ruleDatabases) {
+		if nil != cnipset {
+			db.cnIPSet = cnipset
+		}
+	})
 	dns.Init(&GConf.LocalDNS)
 	go initGFWList()
+	loadRuleBundleVersion()
+	channel.SetRuleBundleHandler(onRuleBundle)
 
 	logger.Notice("Allowed proxy channel with schema:%v", channel.AllowedSchema())
 	singalCh := make(chan bool, len(GConf.Channel))
@@ -163,7 +211,7 @@ func StartProxy() error {
 		<-singalCh
 	}
 
-	err := helper.CreateRootCA(proxyHome + "/MITM")
+	err = helper.CreateRootCA(proxyHome + "/MITM")
 	if nil != err {
 		logger.Notice("Create MITM Root CA:%v", err)
 	}
@@ -172,6 +220,9 @@ func StartProxy() error {
 
 	go startAdminServer()
 	startLocalServers()
+	startTUN()
+	startPortForwards()
+	startLeakAudit()
 	return nil
 }
 
@@ -179,6 +230,7 @@ func Start(options ProxyOptions) error {
 	clientConf := options.Config
 	hostsConf := options.Hosts
 	proxyHome = options.Home
+	runningOptions = options
 
 	if options.WatchConf {
 		confWatcher, err := fsnotify.NewWatcher()
@@ -203,14 +255,42 @@ func Start(options ProxyOptions) error {
 		}
 	}
 	GConf.LocalDNS.CNIPSet = options.CNIP
+	swapIPSets(GConf.ProxyLimit.IPSets)
 	channel.SetDefaultProxyLimitConfig(GConf.ProxyLimit)
 	loadHostsConf(hostsConf)
 	return StartProxy()
 }
 
+// Reload restart local servers and channels with the reloaded config files, the running config is kept
+// if the new one is invalid
+func Reload() error {
+	if len(runningOptions.Config) == 0 {
+		return errors.New("No config file to reload")
+	}
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	cfg, err := parseClientConf(runningOptions.Config)
+	if nil != err {
+		logger.Error("[ERROR]Failed to reload config:%s with reason:%v", runningOptions.Config, err)
+		return err
+	}
+	Stop()
+	GConf = *cfg
+	routeCache.flush()
+	GConf.LocalDNS.CNIPSet = runningOptions.CNIP
+	swapIPSets(GConf.ProxyLimit.IPSets)
+	channel.SetDefaultProxyLimitConfig(GConf.ProxyLimit)
+	loadHostsConf(runningOptions.Hosts)
+	logger.Notice("Reload config:%s", runningOptions.Config)
+	return StartProxy()
+}
+
 func Stop() error {
+	stopTUN()
+	stopPortForwards()
 	stopLocalServers()
 	channel.StopLocalChannels()
+	dns.ResetSplitDNS()
 	hosts.Clear()
 	return nil
 }
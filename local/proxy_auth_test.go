@@ -0,0 +1,130 @@
+package local
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/socks"
+)
+
+func TestProxyAuthenticate(t *testing.T) {
+	cfg := &ProxyConfig{Auth: map[string]string{"user": "passwd", "empty": ""}}
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		//user:passwd
+		{"Basic dXNlcjpwYXNzd2Q=", true},
+		{"basic dXNlcjpwYXNzd2Q=", true},
+		//user:wrong
+		{"Basic dXNlcjp3cm9uZw==", false},
+		//other:passwd
+		{"Basic b3RoZXI6cGFzc3dk", false},
+		//empty:
+		{"Basic ZW1wdHk6", true},
+		//:passwd
+		{"Basic OnBhc3N3ZA==", false},
+		//userpasswd without colon
+		{"Basic dXNlcnBhc3N3ZA==", false},
+		{"Basic !!!", false},
+		{"Bearer dXNlcjpwYXNzd2Q=", false},
+		{"Basic ", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		if len(tt.header) > 0 {
+			req.Header.Set("Proxy-Authorization", tt.header)
+		}
+		if ok := cfg.authenticateHTTP(req); ok != tt.ok {
+			t.Errorf("expect %q authenticated:%v, but got %v", tt.header, tt.ok, ok)
+		}
+	}
+	if !cfg.authRequired() || (&ProxyConfig{}).authRequired() {
+		t.Errorf("auth should be required only with users")
+	}
+}
+
+func TestSocksAuthHandshake(t *testing.T) {
+	cfg := &ProxyConfig{Auth: map[string]string{"user": "passwd"}}
+	userPass := func(user, passwd string) []byte {
+		b := []byte{1, byte(len(user))}
+		b = append(b, user...)
+		b = append(b, byte(len(passwd)))
+		return append(b, passwd...)
+	}
+	connect := []byte{5, 1, 0, 1, 127, 0, 0, 1, 0, 80}
+	tests := []struct {
+		name     string
+		greeting []byte
+		auth     []byte
+		reply    []byte
+		ok       bool
+	}{
+		{"valid", []byte{5, 1, 2}, userPass("user", "passwd"), []byte{5, 2, 1, 0}, true},
+		{"prefer password", []byte{5, 2, 0, 2}, userPass("user", "passwd"), []byte{5, 2, 1, 0}, true},
+		{"wrong password", []byte{5, 1, 2}, userPass("user", "wrong"), []byte{5, 2, 1, 1}, false},
+		{"unknown user", []byte{5, 1, 2}, userPass("other", "passwd"), []byte{5, 2, 1, 1}, false},
+		{"no auth method", []byte{5, 1, 0}, nil, []byte{5, 0xff}, false},
+		//socks4 could not send password
+		{"socks4", []byte{4, 1, 0, 80, 127, 0, 0, 1, 0}, nil, []byte{0, 0x5b}, false},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		done := make(chan *socks.SocksConn, 1)
+		go func() {
+			conn, _, err := socks.NewSocksConnWithAuth(server, cfg.authenticate)
+			if nil != err {
+				conn = nil
+			}
+			done <- conn
+		}()
+		go func() {
+			client.Write(tt.greeting)
+			if len(tt.auth) > 0 {
+				client.Write(tt.auth)
+			}
+			if tt.ok {
+				client.Write(connect)
+			}
+		}()
+		reply := make([]byte, len(tt.reply))
+		if _, err := io.ReadFull(client, reply); nil != err || string(reply) != string(tt.reply) {
+			t.Errorf("%s: expect reply %v, but got %v %v", tt.name, tt.reply, reply, err)
+		}
+		if !tt.ok {
+			//drop the rest of the rejection
+			client.Close()
+		}
+		conn := <-done
+		if (nil != conn) != tt.ok {
+			t.Errorf("%s: expect handshake ok:%v", tt.name, tt.ok)
+		}
+		if nil != conn && (conn.Req.Target != "127.0.0.1:80" || conn.Req.Username != "user") {
+			t.Errorf("%s: unexpected request %+v", tt.name, conn.Req)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestHTTPProxyAuthRequired(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serveProxyConn(server, "", "", &ProxyConfig{Auth: map[string]string{"user": "passwd"}})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.WriteString(client, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nProxy-Authorization: Basic dXNlcjp3cm9uZw==\r\n\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusProxyAuthRequired || !strings.HasPrefix(res.Header.Get("Proxy-Authenticate"), "Basic") {
+		t.Errorf("expect 407 with basic challenge, but got %d %v", res.StatusCode, res.Header)
+	}
+}
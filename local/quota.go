@@ -0,0 +1,95 @@
+package local
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// QuotaStat is the quota status of a server pushed on its sessions
+type QuotaStat struct {
+	Channel string
+	Server  string
+	Quota   *mux.QuotaStatus
+}
+
+// quotaStats return the latest quota status of each quota limited server
+func quotaStats() []QuotaStat {
+	var stats []QuotaStat
+	for _, ch := range channel.LocalChannelStats() {
+		var latest map[string]*mux.QuotaStatus
+		for _, ss := range ch.Sessions {
+			if nil == ss.Quota {
+				continue
+			}
+			if nil == latest {
+				latest = make(map[string]*mux.QuotaStatus)
+			}
+			//sessions of the same server may be pushed at different time
+			if old, exist := latest[ss.Server]; !exist || ss.Quota.Used > old.Used {
+				latest[ss.Server] = ss.Quota
+			}
+		}
+		for _, ss := range ch.Sessions {
+			if st, exist := latest[ss.Server]; exist {
+				stats = append(stats, QuotaStat{Channel: ch.Name, Server: ss.Server, Quota: st})
+				delete(latest, ss.Server)
+			}
+		}
+	}
+	return stats
+}
+
+func quotaCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(quotaStats())
+	w.Write(js)
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
+
+// QuotaReport query the quota status from the admin api of a running client
+func QuotaReport(adminAddr string) (string, error) {
+	hc := &http.Client{Timeout: 5 * time.Second}
+	res, err := hc.Get("http://" + adminAddr + "/api/quota")
+	if nil != err {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if nil != err {
+		return "", err
+	}
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("admin api response status:%d", res.StatusCode)
+	}
+	var stats []QuotaStat
+	if err = json.Unmarshal(body, &stats); nil != err {
+		return "", err
+	}
+	if len(stats) == 0 {
+		return "No quota limited server.\n", nil
+	}
+	var buf bytes.Buffer
+	for _, st := range stats {
+		fmt.Fprintf(&buf, "%s %s used:%s remaining:%s limit:%s reset:%s\n", st.Channel, st.Server,
+			formatBytes(st.Quota.Used), formatBytes(st.Quota.Remaining), formatBytes(st.Quota.Limit),
+			time.Unix(st.Quota.ResetTime, 0).Format("2006-01-02 15:04"))
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,143 @@
+package local
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RouteCacheConfig of the cache of PAC decisions per destination, TTL is in seconds
+type RouteCacheConfig struct {
+	//0 means default 60, negative disables the cache
+	TTL int
+	//0 means default 10000
+	MaxSize int
+}
+
+type routeCacheItem struct {
+	channel string
+	hops    []string
+	expire  time.Time
+}
+
+type routeDecisionCache struct {
+	items map[string]routeCacheItem
+	mutex sync.Mutex
+}
+
+var routeCache = &routeDecisionCache{items: make(map[string]routeCacheItem)}
+
+func (conf *RouteCacheConfig) ttl() time.Duration {
+	if conf.TTL < 0 {
+		return 0
+	}
+	if 0 == conf.TTL {
+		return 60 * time.Second
+	}
+	return time.Duration(conf.TTL) * time.Second
+}
+
+func (conf *RouteCacheConfig) maxSize() int {
+	if conf.MaxSize <= 0 {
+		return 10000
+	}
+	return conf.MaxSize
+}
+
+// the cache is flushed on config reload & pac mode switching, the version of rule databases in key
+// keeps decisions made before a database swap from being cached after the flush
+func routeCacheKey(cfg *ProxyConfig, proto string, host string, port string) string {
+	//only routing rules look at ports
+	if len(cfg.rules) == 0 {
+		port = ""
+	}
+	return fmt.Sprintf("%d|%s|%s|%s|%s", currentRuleDBs().version, cfg.Local, proto, host, port)
+}
+
+func (c *routeDecisionCache) get(key string) (string, []string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	item, exist := c.items[key]
+	if !exist {
+		return "", nil, false
+	}
+	if item.expire.Before(time.Now()) {
+		delete(c.items, key)
+		return "", nil, false
+	}
+	return item.channel, item.hops, true
+}
+
+func (c *routeDecisionCache) put(key string, channelName string, hops []string) {
+	ttl := GConf.RouteCache.ttl()
+	if 0 == ttl {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if len(c.items) >= GConf.RouteCache.maxSize() {
+		for k, v := range c.items {
+			if v.expire.Before(now) {
+				delete(c.items, k)
+			}
+		}
+		if len(c.items) >= GConf.RouteCache.maxSize() {
+			c.items = make(map[string]routeCacheItem)
+		}
+	}
+	c.items[key] = routeCacheItem{channel: channelName, hops: hops, expire: now.Add(ttl)}
+}
+
+func (c *routeDecisionCache) flush() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := len(c.items)
+	c.items = make(map[string]routeCacheItem)
+	return n
+}
+
+// RouteExplainStep is the evaluation result of one PAC entry
+type RouteExplainStep struct {
+	Index   int
+	Remote  string
+	Matched bool
+	Reason  string
+}
+
+// RouteExplain describe how the proxy channel is selected for a destination
+type RouteExplain struct {
+	Proxy         string
+	Protocol      string
+	Host          string
+	PACMode       string
+	Channel       string
+	Hops          []string `json:",omitempty"`
+	Reason        string
+	CachedChannel string `json:",omitempty"`
+	RuleVersion   uint64
+	Steps         []RouteExplainStep
+}
+
+// explainRoute evaluate the routing decision for the host like getProxyChannelByHost, without cache
+func (cfg *ProxyConfig) explainRoute(proto string, host string, port string) *RouteExplain {
+	ex := &RouteExplain{
+		Proxy:    cfg.Local,
+		Protocol: proto,
+		Host:     host,
+		PACMode:  getPACMode(),
+	}
+	ex.CachedChannel, _, _ = routeCache.get(routeCacheKey(cfg, proto, host, port))
+	creq, _ := http.NewRequest("Connect", "https://"+host, nil)
+	ex.Channel, ex.Hops = cfg.evaluateRoute(proto, host, port, creq, ex)
+	return ex
+}
+
+// routeReason return the rule deciding the route of the host, evaluated again without cache
+func (cfg *ProxyConfig) routeReason(proto string, host string, port string) string {
+	ex := &RouteExplain{}
+	creq, _ := http.NewRequest("Connect", "https://"+host, nil)
+	cfg.evaluateRoute(proto, host, port, creq, ex)
+	return ex.Reason
+}
@@ -0,0 +1,172 @@
+package local
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteCacheConfig(t *testing.T) {
+	tests := []struct {
+		conf    RouteCacheConfig
+		ttl     time.Duration
+		maxSize int
+	}{
+		{RouteCacheConfig{}, 60 * time.Second, 10000},
+		{RouteCacheConfig{TTL: 5, MaxSize: 100}, 5 * time.Second, 100},
+		{RouteCacheConfig{TTL: -1, MaxSize: -1}, 0, 10000},
+	}
+	for _, tt := range tests {
+		if ttl, size := tt.conf.ttl(), tt.conf.maxSize(); ttl != tt.ttl || size != tt.maxSize {
+			t.Errorf("expect ttl %v & max size %d of %+v, but got %v & %d", tt.ttl, tt.maxSize, tt.conf, ttl, size)
+		}
+	}
+}
+
+func TestRouteDecisionCache(t *testing.T) {
+	defer func(conf RouteCacheConfig) { GConf.RouteCache = conf }(GConf.RouteCache)
+	GConf.RouteCache = RouteCacheConfig{MaxSize: 2}
+	c := &routeDecisionCache{items: make(map[string]routeCacheItem)}
+	c.put("a", "remoteA", []string{"hop1"})
+	if name, hops, ok := c.get("a"); !ok || name != "remoteA" || len(hops) != 1 {
+		t.Errorf("expect cached remoteA with hops, but got %s %v %v", name, hops, ok)
+	}
+	if _, _, ok := c.get("b"); ok {
+		t.Errorf("unexpected cached decision of b")
+	}
+	//expired decisions are removed
+	c.items["a"] = routeCacheItem{channel: "remoteA", expire: time.Now().Add(-time.Second)}
+	if _, _, ok := c.get("a"); ok || len(c.items) != 0 {
+		t.Errorf("expect expired decision removed")
+	}
+	//a full cache is reset if nothing expired
+	c.put("a", "remoteA", nil)
+	c.put("b", "remoteB", nil)
+	c.put("c", "remoteC", nil)
+	if len(c.items) != 1 {
+		t.Errorf("expect cache reset once full, but got %d items", len(c.items))
+	}
+	if n := c.flush(); n != 1 || len(c.items) != 0 {
+		t.Errorf("expect 1 decision flushed, but got %d", n)
+	}
+	GConf.RouteCache = RouteCacheConfig{TTL: -1}
+	c.put("a", "remoteA", nil)
+	if len(c.items) != 0 {
+		t.Errorf("expect nothing cached with negative ttl")
+	}
+}
+
+func TestGetRouteByHostCached(t *testing.T) {
+	defer routeCache.flush()
+	routeCache.flush()
+	cfg := &ProxyConfig{Local: ":48100", PAC: []PACConfig{
+		{Host: []string{"*.example.com"}, Remote: "remoteA"},
+		{Remote: "direct"},
+	}}
+	if name := cfg.getProxyChannelByHost("tcp", "www.example.com", "443"); name != "remoteA" {
+		t.Fatalf("expect remoteA, but got %s", name)
+	}
+	//decisions are served from cache until flushed
+	cfg.PAC[0].Remote = "remoteB"
+	if name := cfg.getProxyChannelByHost("tcp", "www.example.com", "443"); name != "remoteA" {
+		t.Errorf("expect cached remoteA, but got %s", name)
+	}
+	//ports are not part of the key without routing rules
+	if name := cfg.getProxyChannelByHost("tcp", "www.example.com", "80"); name != "remoteA" {
+		t.Errorf("expect cached remoteA for other port, but got %s", name)
+	}
+	if name := cfg.getProxyChannelByHost("udp", "www.example.com", "443"); name != "remoteB" {
+		t.Errorf("expect remoteB for other protocol, but got %s", name)
+	}
+	routeCache.flush()
+	if name := cfg.getProxyChannelByHost("tcp", "www.example.com", "443"); name != "remoteB" {
+		t.Errorf("expect remoteB after flush, but got %s", name)
+	}
+}
+
+func TestExplainRoute(t *testing.T) {
+	defer routeCache.flush()
+	routeCache.flush()
+	cfg := &ProxyConfig{Local: ":48100", PAC: []PACConfig{
+		{Protocol: []string{"udp"}, Remote: "remoteUDP"},
+		{Host: []string{"*.example.com"}, Remote: "remoteA"},
+		{Remote: "direct"},
+	}}
+	tests := []struct {
+		host    string
+		channel string
+		steps   []bool
+		reason  string
+	}{
+		{"www.example.com", "remoteA", []bool{false, true}, "PAC[1]"},
+		{"www.other.com", "direct", []bool{false, false, true}, "PAC[2]"},
+		{"192.168.1.1", "direct", nil, "private ip is always direct"},
+	}
+	for _, tt := range tests {
+		ex := cfg.explainRoute("tcp", tt.host, "443")
+		if ex.Channel != tt.channel || !strings.HasPrefix(ex.Reason, tt.reason) || ex.Proxy != ":48100" || ex.Host != tt.host {
+			t.Errorf("%s: expect %s by %q, but got %+v", tt.host, tt.channel, tt.reason, ex)
+		}
+		if len(ex.Steps) != len(tt.steps) {
+			t.Errorf("%s: expect %d steps, but got %+v", tt.host, len(tt.steps), ex.Steps)
+			continue
+		}
+		for i, matched := range tt.steps {
+			if ex.Steps[i].Index != i || ex.Steps[i].Matched != matched || ex.Steps[i].Remote != cfg.PAC[i].Remote {
+				t.Errorf("%s: unexpected step %d:%+v", tt.host, i, ex.Steps[i])
+			}
+		}
+		if len(ex.CachedChannel) > 0 {
+			t.Errorf("%s: explain should not be cached, but got %s", tt.host, ex.CachedChannel)
+		}
+	}
+	cfg.getProxyChannelByHost("tcp", "www.example.com", "443")
+	if ex := cfg.explainRoute("tcp", "www.example.com", "443"); ex.CachedChannel != "remoteA" {
+		t.Errorf("expect cached channel reported, but got %q", ex.CachedChannel)
+	}
+	if reason := cfg.routeReason("udp", "www.example.com", "443"); !strings.HasPrefix(reason, "PAC[0]") {
+		t.Errorf("expect udp routed by PAC[0], but got %q", reason)
+	}
+}
+
+func TestRouteExplainCallback(t *testing.T) {
+	defer routeCache.flush()
+	defer hotConf.Store(currentHotConf())
+	publishHotConf(&LocalConfig{Proxy: []ProxyConfig{
+		{Local: ":48100", PAC: []PACConfig{{Remote: "remoteA"}}},
+		{Local: ":48101", PAC: []PACConfig{{Remote: "remoteB"}}},
+	}})
+	tests := []struct {
+		query   string
+		status  int
+		channel string
+	}{
+		{"", http.StatusBadRequest, ""},
+		{"host=www.example.com:443", 200, "remoteA"},
+		{"host=www.example.com&proxy=:48101", 200, "remoteB"},
+		{"host=www.example.com&proxy=:48102", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		routeExplainCallback(w, httptest.NewRequest("GET", "/api/route/explain?"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%q: expect status %d, but got %d", tt.query, tt.status, w.Code)
+			continue
+		}
+		if tt.status != 200 {
+			continue
+		}
+		var ex RouteExplain
+		if err := json.Unmarshal(w.Body.Bytes(), &ex); nil != err || ex.Channel != tt.channel || ex.Host != "www.example.com" || ex.Protocol != "tcp" {
+			t.Errorf("%q: expect %s, but got %+v %v", tt.query, tt.channel, ex, err)
+		}
+	}
+	w := httptest.NewRecorder()
+	routeFlushCallback(w, httptest.NewRequest("GET", "/api/route/flush", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect flush by POST only, but got %d", w.Code)
+	}
+}
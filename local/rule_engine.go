@@ -0,0 +1,223 @@
+package local
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+)
+
+// rule types of 'Rules' in proxy config, like 'DOMAIN-SUFFIX,google.com,remoteA'
+const (
+	RuleDomain        = "DOMAIN"
+	RuleDomainSuffix  = "DOMAIN-SUFFIX"
+	RuleDomainKeyword = "DOMAIN-KEYWORD"
+	RuleIPCIDR        = "IP-CIDR"
+	RuleDstPort       = "DST-PORT"
+	RuleGeoIP         = "GEOIP"
+	RuleMatch         = "MATCH"
+
+	RuleTargetDirect = "DIRECT"
+	RuleTargetReject = "REJECT"
+)
+
+// ChainConfig is a multi-hop route, streams go through 'Channel' then each of 'Hops' in order
+type ChainConfig struct {
+	Channel string
+	//next hop server urls like 'wss://hop.example.com', appended to the hops of the channel
+	Hops []string
+}
+
+type routeRule struct {
+	raw       string
+	kind      string
+	value     string
+	target    string
+	network   *net.IPNet
+	portFrom  int
+	portTo    int
+	noResolve bool
+	//proxy channel & extra hops resolved from target at compile time
+	channel string
+	hops    []string
+}
+
+// parseRouteRule parse '<TYPE>,<VALUE>,<TARGET>[,no-resolve]' or 'MATCH,<TARGET>'
+func parseRouteRule(s string) (*routeRule, error) {
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	r := &routeRule{raw: s, kind: strings.ToUpper(parts[0])}
+	if r.kind == RuleMatch {
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid rule:%s", s)
+		}
+		r.target = parts[1]
+		return r, nil
+	}
+	if len(parts) < 3 || len(parts) > 4 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return nil, fmt.Errorf("invalid rule:%s", s)
+	}
+	r.value, r.target = parts[1], parts[2]
+	if len(parts) == 4 {
+		if !strings.EqualFold(parts[3], "no-resolve") {
+			return nil, fmt.Errorf("invalid rule option:%s", parts[3])
+		}
+		r.noResolve = true
+	}
+	switch r.kind {
+	case RuleDomain, RuleDomainSuffix, RuleDomainKeyword:
+		r.value = strings.Trim(strings.ToLower(r.value), ".")
+	case RuleIPCIDR, "IP-CIDR6":
+		r.kind = RuleIPCIDR
+		_, network, err := net.ParseCIDR(r.value)
+		if nil != err {
+			return nil, err
+		}
+		r.network = network
+	case RuleDstPort:
+		from, to := r.value, r.value
+		if idx := strings.Index(r.value, "-"); idx > 0 {
+			from, to = r.value[:idx], r.value[idx+1:]
+		}
+		var err error
+		if r.portFrom, err = strconv.Atoi(from); nil != err {
+			return nil, fmt.Errorf("invalid port:%s", r.value)
+		}
+		if r.portTo, err = strconv.Atoi(to); nil != err || r.portTo < r.portFrom {
+			return nil, fmt.Errorf("invalid port:%s", r.value)
+		}
+	case RuleGeoIP:
+		r.value = strings.ToUpper(r.value)
+	default:
+		return nil, fmt.Errorf("invalid rule type:%s", parts[0])
+	}
+	return r, nil
+}
+
+// compileRouteRules parse rules & resolve their targets by the chains & channels of the same config
+func compileRouteRules(rules []string, chains map[string]ChainConfig, channels []channel.ProxyChannelConfig) ([]*routeRule, error) {
+	var compiled []*routeRule
+	for _, s := range rules {
+		r, err := parseRouteRule(s)
+		if nil == err {
+			r.channel, r.hops, err = resolveRuleTarget(r.target, chains, channels)
+		}
+		if nil != err {
+			return nil, fmt.Errorf("invalid routing rule:%s with reason:%v", s, err)
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// routeTarget is the destination evaluated by rules, the domain is resolved at most once when needed
+type routeTarget struct {
+	host     string
+	port     int
+	ip       net.IP
+	resolved bool
+}
+
+func (t *routeTarget) resolveIP() net.IP {
+	if !t.resolved {
+		t.resolved = true
+		if t.ip = net.ParseIP(t.host); nil == t.ip {
+			if ip, err := dns.DnsGetDoaminIP(t.host); nil == err {
+				t.ip = net.ParseIP(ip)
+			}
+		}
+	}
+	return t.ip
+}
+
+func (t *routeTarget) targetIP(noResolve bool) net.IP {
+	if noResolve {
+		return net.ParseIP(t.host)
+	}
+	return t.resolveIP()
+}
+
+func (r *routeRule) match(t *routeTarget, db *ruleDatabases) bool {
+	switch r.kind {
+	case RuleDomain:
+		return t.host == r.value
+	case RuleDomainSuffix:
+		return t.host == r.value || strings.HasSuffix(t.host, "."+r.value)
+	case RuleDomainKeyword:
+		return strings.Contains(t.host, r.value)
+	case RuleIPCIDR:
+		ip := t.targetIP(r.noResolve)
+		return nil != ip && r.network.Contains(ip)
+	case RuleDstPort:
+		return t.port >= r.portFrom && t.port <= r.portTo
+	case RuleGeoIP:
+		ip := t.targetIP(r.noResolve)
+		if nil == ip {
+			return false
+		}
+		//'ProxyLimit.GeoIPDB' is used if loaded, CN falls back to the CNIP set without it
+		if country := channel.GeoIPCountry(ip); len(country) > 0 {
+			return country == r.value
+		}
+		return r.value == "CN" && nil != db.cnIPSet && db.cnIPSet.IsInCountry(ip, "CN")
+	case RuleMatch:
+		return true
+	}
+	return false
+}
+
+func newRouteTarget(host string, port string) *routeTarget {
+	t := &routeTarget{host: strings.Trim(strings.ToLower(host), "[]")}
+	t.port, _ = strconv.Atoi(port)
+	return t
+}
+
+// matchRouteRule return the first rule matched the destination, nil if none
+func (cfg *ProxyConfig) matchRouteRule(host string, port string, db *ruleDatabases) *routeRule {
+	t := newRouteTarget(host, port)
+	for _, r := range cfg.rules {
+		if r.match(t, db) {
+			return r
+		}
+	}
+	return nil
+}
+
+func hasChannel(channels []channel.ProxyChannelConfig, name string) bool {
+	if name == channel.DirectChannelName {
+		//added by default if not configured
+		return true
+	}
+	for i := range channels {
+		if channels[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRuleTarget return the proxy channel & extra hops of the rule target, which is 'DIRECT', 'REJECT',
+// a name of 'Chains' or a proxy channel name
+func resolveRuleTarget(target string, chains map[string]ChainConfig, channels []channel.ProxyChannelConfig) (string, []string, error) {
+	switch strings.ToUpper(target) {
+	case RuleTargetDirect:
+		return channel.DirectChannelName, nil, nil
+	case RuleTargetReject:
+		return RejectRemote, nil, nil
+	}
+	if chain, exist := chains[target]; exist {
+		if !hasChannel(channels, chain.Channel) {
+			return "", nil, fmt.Errorf("no channel:%s of chain:%s", chain.Channel, target)
+		}
+		return chain.Channel, chain.Hops, nil
+	}
+	if !hasChannel(channels, target) {
+		return "", nil, fmt.Errorf("no channel or chain:%s", target)
+	}
+	return target, nil, nil
+}
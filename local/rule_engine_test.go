@@ -0,0 +1,157 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+)
+
+var testRuleChannels = []channel.ProxyChannelConfig{{Name: "remoteA"}, {Name: "remoteB"}}
+
+func testRuleProxy(t *testing.T, rules ...string) *ProxyConfig {
+	cfg := &ProxyConfig{Local: "127.0.0.1:48100", Rules: rules}
+	chains := map[string]ChainConfig{"viaB": {Channel: "remoteB", Hops: []string{"wss://hop.example.com"}}}
+	if err := cfg.compile(chains, testRuleChannels); nil != err {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestParseRouteRuleInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"MATCH",
+		"MATCH,remoteA,x",
+		"DOMAIN,google.com",
+		"DST-PORT,443-80,remoteA",
+		"DST-PORT,x,remoteA",
+		"IP-CIDR,10.0.0.300/8,remoteA",
+		"IP-CIDR,10.0.0.0/8,remoteA,resolve",
+		"UNKNOWN,x,remoteA",
+	} {
+		if _, err := parseRouteRule(rule); nil == err {
+			t.Errorf("rule %s should be invalid", rule)
+		}
+	}
+}
+
+func TestCompileRouteRulesValidateTargets(t *testing.T) {
+	chains := map[string]ChainConfig{"viaB": {Channel: "remoteB"}, "viaC": {Channel: "remoteC"}}
+	for _, c := range []struct {
+		rule  string
+		valid bool
+	}{
+		{"MATCH,DIRECT", true},
+		{"MATCH,reject", true},
+		{"MATCH,remoteA", true},
+		{"MATCH,viaB", true},
+		{"MATCH,remoteC", false},
+		{"MATCH,viaC", false},
+		{"DOMAIN,,remoteA", false},
+	} {
+		if _, err := compileRouteRules([]string{"DOMAIN,a.com,remoteA", c.rule}, chains, testRuleChannels); (nil == err) != c.valid {
+			t.Errorf("rule %s: expect valid %v, but got %v", c.rule, c.valid, err)
+		}
+	}
+}
+
+func TestRouteRulesMatch(t *testing.T) {
+	cfg := testRuleProxy(t,
+		"DOMAIN,exact.example.com,remoteA",
+		"DOMAIN-SUFFIX,google.com,viaB",
+		"DOMAIN-KEYWORD,tube,remoteB",
+		"DST-PORT,6000-6010,REJECT",
+		"DST-PORT,25,REJECT",
+		"IP-CIDR,192.168.1.0/24,remoteB,no-resolve",
+		"IP-CIDR,10.0.0.0/8,remoteA",
+		"MATCH,DIRECT")
+	for _, c := range []struct {
+		host, port, channel string
+		hops                []string
+	}{
+		{"exact.example.com", "443", "remoteA", nil},
+		{"google.com", "443", "remoteB", []string{"wss://hop.example.com"}},
+		{"www.google.com", "80", "remoteB", []string{"wss://hop.example.com"}},
+		{"youtube.com", "443", "remoteB", nil},
+		{"8.8.8.8", "6000", RejectRemote, nil},
+		{"8.8.8.8", "6010", RejectRemote, nil},
+		{"8.8.8.8", "6011", channel.DirectChannelName, nil},
+		{"8.8.8.8", "25", RejectRemote, nil},
+		//private destinations are routed by rules too
+		{"192.168.1.7", "22", "remoteB", nil},
+		{"10.1.2.3", "22", "remoteA", nil},
+		{"[::1]", "22", channel.DirectChannelName, nil},
+		{"9.9.9.9", "443", channel.DirectChannelName, nil},
+	} {
+		name, hops := cfg.evaluateRoute("tcp", newRouteTarget(c.host, c.port).host, c.port, nil, nil)
+		if name != c.channel || !reflect.DeepEqual(hops, c.hops) {
+			t.Errorf("%s:%s routed to %s %v, expect %s %v", c.host, c.port, name, hops, c.channel, c.hops)
+		}
+	}
+}
+
+func TestRouteRuleNoResolve(t *testing.T) {
+	noResolve, _ := parseRouteRule("IP-CIDR,93.184.0.0/16,remoteA,no-resolve")
+	//resolved targets are used as is without looking up dns
+	resolved := &routeTarget{host: "example.com", ip: []byte{93, 184, 216, 34}, resolved: true}
+	if noResolve.match(resolved, currentRuleDBs()) {
+		t.Errorf("no-resolve rule should not match a domain")
+	}
+	if !noResolve.match(newRouteTarget("93.184.216.34", "80"), currentRuleDBs()) {
+		t.Errorf("no-resolve rule should match an ip")
+	}
+	rule, _ := parseRouteRule("IP-CIDR,93.184.0.0/16,remoteA")
+	if !rule.match(resolved, currentRuleDBs()) {
+		t.Errorf("rule should match the resolved ip of a domain")
+	}
+}
+
+func TestRouteRuleGeoIPFallbackCNIPSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cnipset.txt")
+	ioutil.WriteFile(file, []byte("1.0.1.0/24\n"), 0660)
+	cnIPSet, err := dns.LoadCountryIPSet(file, "CN")
+	if nil != err {
+		t.Fatal(err)
+	}
+	db := &ruleDatabases{cnIPSet: cnIPSet}
+	cn, _ := parseRouteRule("GEOIP,cn,DIRECT,no-resolve")
+	us, _ := parseRouteRule("GEOIP,US,remoteA,no-resolve")
+	//without GeoIP database, only CN is decided by the CN ip set
+	for _, c := range []struct {
+		rule    *routeRule
+		db      *ruleDatabases
+		ip      string
+		matched bool
+	}{
+		{cn, db, "1.0.1.1", true},
+		{cn, db, "1.0.2.1", false},
+		{cn, &ruleDatabases{}, "1.0.1.1", false},
+		{us, db, "1.0.1.1", false},
+		{us, db, "8.8.8.8", false},
+		{cn, db, "example.com", false},
+	} {
+		if c.rule.match(newRouteTarget(c.ip, "443"), c.db) != c.matched {
+			t.Errorf("%s should match %s: %v", c.rule.raw, c.ip, c.matched)
+		}
+	}
+}
+
+func TestRouteRulesMatchFallThrough(t *testing.T) {
+	cfg := testRuleProxy(t, "DOMAIN-SUFFIX,google.com,remoteA")
+	if name, _ := cfg.evaluateRoute("tcp", "example.com", "443", nil, nil); len(name) > 0 {
+		t.Errorf("no rule matched, but routed to %s", name)
+	}
+	cfg = testRuleProxy(t, "DOMAIN-SUFFIX,google.com,remoteA", "MATCH,remoteB")
+	if name, _ := cfg.evaluateRoute("tcp", "example.com", "443", nil, nil); name != "remoteB" {
+		t.Errorf("MATCH should route to remoteB, but got %s", name)
+	}
+}
@@ -0,0 +1,126 @@
+package local
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gotoolkit/gfwlist"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/hosts"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"golang.org/x/crypto/ed25519"
+)
+
+// RuleUpdateConfig accept rule bundles pushed by servers
+type RuleUpdateConfig struct {
+	//base64 ed25519 public key of the operator, bundles are ignored if empty
+	PublicKey string
+	//'Local' addresses of proxies whose PAC rules are replaced by bundles, empty means all
+	Proxies []string
+}
+
+func (conf *RuleUpdateConfig) matchProxy(local string) bool {
+	if len(conf.Proxies) == 0 {
+		return true
+	}
+	for _, p := range conf.Proxies {
+		if p == local {
+			return true
+		}
+	}
+	return false
+}
+
+var ruleBundleVersion int64
+
+func ruleBundleVersionFile() string {
+	return proxyHome + "/rule_bundle.version"
+}
+
+// loadRuleBundleVersion restore the version of the last applied bundle, so that older bundles are not replayed after restart
+func loadRuleBundleVersion() {
+	data, err := ioutil.ReadFile(ruleBundleVersionFile())
+	if nil != err {
+		return
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if nil != err {
+		logger.Error("[ERROR]Invalid rule bundle version file:%s with reason:%v", ruleBundleVersionFile(), err)
+		return
+	}
+	if v > atomic.LoadInt64(&ruleBundleVersion) {
+		atomic.StoreInt64(&ruleBundleVersion, v)
+	}
+}
+
+func verifyRuleBundle(b *mux.RuleBundle) bool {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(GConf.RuleUpdate.PublicKey))
+	if nil != err || len(key) != ed25519.PublicKeySize {
+		logger.Error("[ERROR]Invalid rule update public key:%s", GConf.RuleUpdate.PublicKey)
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(key), b.SignedData(), b.Signature)
+}
+
+// onRuleBundle apply the verified bundle newer than current one, sessions to several servers may push the same bundle,
+// the version is bumped only if the whole bundle is valid & applied
+func onRuleBundle(b *mux.RuleBundle) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	if len(GConf.RuleUpdate.PublicKey) == 0 || b.Version <= atomic.LoadInt64(&ruleBundleVersion) {
+		return
+	}
+	if !verifyRuleBundle(b) {
+		logger.Error("[ERROR]Drop rule bundle of version:%d with invalid signature", b.Version)
+		return
+	}
+	var pacs []PACConfig
+	if len(b.PAC) > 0 {
+		if err := json.Unmarshal(b.PAC, &pacs); nil != err {
+			logger.Error("[ERROR]Drop rule bundle of version:%d with invalid PAC rules:%v", b.Version, err)
+			return
+		}
+		for i := range pacs {
+			pacs[i].hostMatcher = helper.NewHostMatcher(pacs[i].Host)
+		}
+	}
+	var gfw *gfwlist.GFWList
+	if len(b.GFWList) > 0 {
+		var err error
+		if gfw, err = gfwlist.NewFromString(b.GFWList, true); nil != err {
+			logger.Error("[ERROR]Drop rule bundle of version:%d with invalid GFWList:%v", b.Version, err)
+			return
+		}
+		for _, rule := range GConf.GFWList.UserRule {
+			gfw.Add(rule)
+		}
+	}
+	if nil != gfw {
+		swapRuleDBs(func(db *ruleDatabases) {
+			db.gfwList = gfw
+			db.gfwListTime = time.Now()
+		})
+	}
+	if nil != b.Hosts {
+		hosts.SetMappings(b.Hosts)
+	}
+	if nil != pacs {
+		currentHotConf().withProxies(func(proxy *ProxyConfig) {
+			if GConf.RuleUpdate.matchProxy(proxy.Local) {
+				proxy.PAC = pacs
+			}
+		})
+	}
+	routeCache.flush()
+	atomic.StoreInt64(&ruleBundleVersion, b.Version)
+	if err := ioutil.WriteFile(ruleBundleVersionFile(), []byte(strconv.FormatInt(b.Version, 10)), 0660); nil != err {
+		logger.Error("[ERROR]Failed to save rule bundle version for reason:%v", err)
+	}
+	logger.Notice("Applied rule bundle of version:%d", b.Version)
+}
@@ -0,0 +1,104 @@
+package local
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/mux"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestRuleUpdateMatchProxy(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		local   string
+		match   bool
+	}{
+		{nil, ":48100", true},
+		{[]string{":48100"}, ":48100", true},
+		{[]string{":48100"}, ":48101", false},
+	}
+	for _, tt := range tests {
+		conf := &RuleUpdateConfig{Proxies: tt.proxies}
+		if match := conf.matchProxy(tt.local); match != tt.match {
+			t.Errorf("expect %s matched %v by %v, but got %v", tt.local, tt.match, tt.proxies, match)
+		}
+	}
+}
+
+func TestOnRuleBundle(t *testing.T) {
+	defer func(home string, conf RuleUpdateConfig) { proxyHome, GConf.RuleUpdate = home, conf }(proxyHome, GConf.RuleUpdate)
+	defer atomic.StoreInt64(&ruleBundleVersion, atomic.LoadInt64(&ruleBundleVersion))
+	defer hotConf.Store(currentHotConf())
+	defer restoreRuleDBs(currentRuleDBs())
+	proxyHome, _ = ioutil.TempDir("", "ruleupdate")
+	defer os.RemoveAll(proxyHome)
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	_, other, _ := ed25519.GenerateKey(nil)
+	GConf.RuleUpdate = RuleUpdateConfig{PublicKey: base64.StdEncoding.EncodeToString(pub), Proxies: []string{":48100"}}
+	atomic.StoreInt64(&ruleBundleVersion, 0)
+	publishHotConf(&LocalConfig{Proxy: []ProxyConfig{
+		{Local: ":48100", PAC: []PACConfig{{Remote: "remoteA"}}},
+		{Local: ":48101", PAC: []PACConfig{{Remote: "remoteA"}}},
+	}})
+	bundle := func(version int64, pac string, gfw string, key ed25519.PrivateKey) *mux.RuleBundle {
+		b := &mux.RuleBundle{Version: version, PAC: []byte(pac), GFWList: gfw}
+		b.Signature = ed25519.Sign(key, b.SignedData())
+		return b
+	}
+	tests := []struct {
+		name    string
+		bundle  *mux.RuleBundle
+		version int64
+		remote  string
+	}{
+		{"invalid signature", bundle(10, `[{"Remote":"remoteB"}]`, "", other), 0, "remoteA"},
+		{"invalid pac", bundle(10, `[{"Remote":`, "", priv), 0, "remoteA"},
+		{"valid", bundle(10, `[{"Host":["*.example.com"],"Remote":"remoteB"}]`, "W0F1dG9Qcm94eV0K", priv), 10, "remoteB"},
+		{"replayed", bundle(10, `[{"Remote":"remoteC"}]`, "", priv), 10, "remoteB"},
+		{"older", bundle(9, `[{"Remote":"remoteC"}]`, "", priv), 10, "remoteB"},
+		{"newer", bundle(11, `[{"Remote":"remoteC"}]`, "", priv), 11, "remoteC"},
+	}
+	gfwList := currentRuleDBs().gfwList
+	for _, tt := range tests {
+		onRuleBundle(tt.bundle)
+		if v := atomic.LoadInt64(&ruleBundleVersion); v != tt.version {
+			t.Errorf("%s: expect version %d, but got %d", tt.name, tt.version, v)
+		}
+		if remote := currentHotConf().proxyByLocal(":48100").PAC[0].Remote; remote != tt.remote {
+			t.Errorf("%s: expect routed to %s, but got %s", tt.name, tt.remote, remote)
+		}
+		//proxies not in 'Proxies' keep their rules
+		if remote := currentHotConf().proxyByLocal(":48101").PAC[0].Remote; remote != "remoteA" {
+			t.Errorf("%s: expect proxy :48101 kept, but got %s", tt.name, remote)
+		}
+	}
+	if currentRuleDBs().gfwList == gfwList {
+		t.Errorf("expect gfwlist swapped by bundle")
+	}
+	if nil == currentHotConf().proxyByLocal(":48100").PAC[0].hostMatcher {
+		t.Errorf("expect host matcher of bundled rules compiled")
+	}
+
+	//the version floor survives restart
+	atomic.StoreInt64(&ruleBundleVersion, 0)
+	loadRuleBundleVersion()
+	if v := atomic.LoadInt64(&ruleBundleVersion); v != 11 {
+		t.Errorf("expect version 11 restored, but got %d", v)
+	}
+	ioutil.WriteFile(filepath.Join(proxyHome, "rule_bundle.version"), []byte("invalid"), 0660)
+	loadRuleBundleVersion()
+	if v := atomic.LoadInt64(&ruleBundleVersion); v != 11 {
+		t.Errorf("expect version kept with invalid version file, but got %d", v)
+	}
+	//bundles are ignored without public key
+	GConf.RuleUpdate.PublicKey = ""
+	onRuleBundle(bundle(12, `[{"Remote":"remoteD"}]`, "", priv))
+	if v := atomic.LoadInt64(&ruleBundleVersion); v != 11 {
+		t.Errorf("expect bundle ignored without public key, but got version %d", v)
+	}
+}
@@ -0,0 +1,144 @@
+package local
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gotoolkit/gfwlist"
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// ruleDatabases is an immutable version of databases referenced by PAC rules, a routing decision
+// loads it once so all rules are evaluated against the same version while databases are swapped.
+type ruleDatabases struct {
+	version     uint64
+	updateTime  time.Time
+	gfwList     *gfwlist.GFWList
+	gfwListTime time.Time
+	cnIPSet     *dns.CountryIPSet
+	ipSets      helper.IPSets
+}
+
+// RuleDatabasesInfo is reported by admin api
+type RuleDatabasesInfo struct {
+	Version     uint64
+	UpdateTime  string
+	GFWList     bool
+	GFWListTime string `json:",omitempty"`
+	CNIPRanges  int
+	IPSets      int
+	GeoIPTime   string   `json:",omitempty"`
+	Errors      []string `json:",omitempty"`
+}
+
+var ruleDBs atomic.Value
+var ruleDBsMutex sync.Mutex
+
+func currentRuleDBs() *ruleDatabases {
+	if db, ok := ruleDBs.Load().(*ruleDatabases); ok {
+		return db
+	}
+	return &ruleDatabases{}
+}
+
+// swapRuleDBs publish a new version updated from a copy of the current one, cached routes are dropped.
+// The CN ip set of resolvers & ip sets of proxy limit are published in the same critical section.
+func swapRuleDBs(update func(db *ruleDatabases)) *ruleDatabases {
+	ruleDBsMutex.Lock()
+	db := *currentRuleDBs()
+	update(&db)
+	db.version++
+	db.updateTime = time.Now()
+	dns.SetCNIPSet(db.cnIPSet)
+	helper.StoreIPSets(db.ipSets)
+	ruleDBs.Store(&db)
+	ruleDBsMutex.Unlock()
+	routeCache.flush()
+	logger.Notice("Rule databases updated to version:%d", db.version)
+	return &db
+}
+
+func (db *ruleDatabases) info() *RuleDatabasesInfo {
+	info := &RuleDatabasesInfo{
+		Version: db.version,
+		GFWList: nil != db.gfwList,
+	}
+	if !db.updateTime.IsZero() {
+		info.UpdateTime = db.updateTime.Format(time.RFC3339)
+	}
+	if !db.gfwListTime.IsZero() {
+		info.GFWListTime = db.gfwListTime.Format(time.RFC3339)
+	}
+	if nil != db.cnIPSet {
+		info.CNIPRanges = db.cnIPSet.Len()
+	}
+	info.IPSets = len(db.ipSets)
+	if t := channel.GeoIPDBTime(); !t.IsZero() {
+		info.GeoIPTime = t.Format(time.RFC3339)
+	}
+	return info
+}
+
+// swapIPSets load 'IPSets' of proxy limit into a new version of rule databases
+func swapIPSets(sets map[string][]string) error {
+	table, err := helper.LoadIPSets(sets, currentRuleDBs().ipSets)
+	swapRuleDBs(func(db *ruleDatabases) {
+		db.ipSets = table
+	})
+	return err
+}
+
+// reloadRuleDBs load all databases again & swap them in one version, databases failed to load are kept.
+// Connections are not interrupted, only new routing decisions use the new version.
+func reloadRuleDBs() *RuleDatabasesInfo {
+	var errs []string
+	var gfw *gfwlist.GFWList
+	if len(GConf.GFWList.URL) > 0 {
+		hc, err := channel.NewHTTPClient(&channel.ProxyChannelConfig{Proxy: GConf.GFWList.Proxy}, "http")
+		if nil == err {
+			gfw, err = fetchGFWList(hc)
+		}
+		if nil != err {
+			errs = append(errs, fmt.Sprintf("gfwlist:%v", err))
+		}
+	}
+	var cnipset *dns.CountryIPSet
+	if len(GConf.LocalDNS.CNIPSet) > 0 {
+		var err error
+		cnipset, err = dns.LoadCountryIPSet(GConf.LocalDNS.CNIPSet, "CN")
+		if nil != err {
+			errs = append(errs, fmt.Sprintf("cnipset:%v", err))
+		}
+	}
+	//named ip sets are swapped as a whole table, sets failed to load are kept
+	ipSets, err := helper.LoadIPSets(currentHotConf().proxyLimit.IPSets, currentRuleDBs().ipSets)
+	if nil != err {
+		errs = append(errs, fmt.Sprintf("ipsets:%v", err))
+	}
+	//the mapped GeoIP database is replaced in place, lookups hold its read lock
+	if err = channel.ReloadGeoIPDB(); nil != err {
+		errs = append(errs, fmt.Sprintf("geoip:%v", err))
+	}
+	db := swapRuleDBs(func(db *ruleDatabases) {
+		if nil != gfw {
+			db.gfwList = gfw
+			db.gfwListTime = time.Now()
+		}
+		if nil != cnipset {
+			db.cnIPSet = cnipset
+		}
+		db.ipSets = ipSets
+	})
+	info := db.info()
+	info.Errors = errs
+	if len(errs) > 0 {
+		logger.Error("[ERROR]Failed to reload rule databases:%s", strings.Join(errs, ","))
+	}
+	return info
+}
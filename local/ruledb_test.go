@@ -0,0 +1,133 @@
+package local
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/helper"
+)
+
+func restoreRuleDBs(db *ruleDatabases) {
+	ruleDBs.Store(db)
+	dns.SetCNIPSet(db.cnIPSet)
+	helper.StoreIPSets(db.ipSets)
+}
+
+func TestSwapRuleDBs(t *testing.T) {
+	prev := currentRuleDBs()
+	defer restoreRuleDBs(prev)
+	dir, _ := ioutil.TempDir("", "ruledb")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cnipset.txt")
+	ioutil.WriteFile(file, []byte("1.0.1.0/24\n1.0.2.0/23\n"), 0644)
+	cnipset, err := dns.LoadCountryIPSet(file, "CN")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	cfg := &ProxyConfig{Local: ":48100"}
+	key := routeCacheKey(cfg, "tcp", "www.example.com", "443")
+	routeCache.put(key, "remoteA", nil)
+	db := swapRuleDBs(func(db *ruleDatabases) {
+		db.cnIPSet = cnipset
+	})
+	if db != currentRuleDBs() || db.version != prev.version+1 || db.updateTime.IsZero() {
+		t.Errorf("expect version %d published, but got %d", prev.version+1, db.version)
+	}
+	//the old version is never modified by swaps
+	if prev.cnIPSet == cnipset {
+		t.Errorf("expect previous version untouched")
+	}
+	if dns.GetCNIPSet() != cnipset {
+		t.Errorf("expect CN ip set of resolvers swapped")
+	}
+	if _, _, exist := routeCache.get(key); exist {
+		t.Errorf("expect cached routes dropped by swap")
+	}
+	if routeCacheKey(cfg, "tcp", "www.example.com", "443") == key {
+		t.Errorf("expect route cache key changed with the version")
+	}
+	info := db.info()
+	if info.Version != db.version || info.CNIPRanges != 2 || info.GFWList || len(info.UpdateTime) == 0 || len(info.GFWListTime) > 0 {
+		t.Errorf("unexpected rule databases info:%+v", info)
+	}
+}
+
+func TestSwapIPSets(t *testing.T) {
+	defer restoreRuleDBs(currentRuleDBs())
+	tests := []struct {
+		sets map[string][]string
+		err  bool
+		ip   string
+		in   bool
+	}{
+		{map[string][]string{"office": {"10.0.0.0/8"}}, false, "10.1.2.3", true},
+		{map[string][]string{"office": {"192.168.0.0/16"}}, false, "10.1.2.3", false},
+		//sets failed to load are kept from the current version
+		{map[string][]string{"office": {"@/nonexist/office.txt"}}, true, "192.168.1.1", true},
+	}
+	for _, tt := range tests {
+		err := swapIPSets(tt.sets)
+		if (nil != err) != tt.err {
+			t.Errorf("%v: expect error %v, but got %v", tt.sets, tt.err, err)
+		}
+		in := currentRuleDBs().ipSets.Contains("office", net.ParseIP(tt.ip))
+		if in != tt.in || helper.InIPSet("office", net.ParseIP(tt.ip)) != tt.in {
+			t.Errorf("%v: expect %s in set %v, but got %v", tt.sets, tt.ip, tt.in, in)
+		}
+	}
+}
+
+func TestRuleDBsReloadCallback(t *testing.T) {
+	defer restoreRuleDBs(currentRuleDBs())
+	defer func(url, cnipset string) {
+		GConf.GFWList.URL = url
+		GConf.LocalDNS.CNIPSet = cnipset
+	}(GConf.GFWList.URL, GConf.LocalDNS.CNIPSet)
+	GConf.GFWList.URL = ""
+	GConf.LocalDNS.CNIPSet = ""
+	w := httptest.NewRecorder()
+	ruleDBsReloadCallback(w, httptest.NewRequest("GET", "/api/rules/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect %d of GET, but got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	tests := []struct {
+		cnipset string
+		errs    int
+	}{
+		{"", 0},
+		{"/nonexist/cnipset.txt", 1},
+	}
+	for _, tt := range tests {
+		GConf.LocalDNS.CNIPSet = tt.cnipset
+		version := currentRuleDBs().version
+		w = httptest.NewRecorder()
+		ruleDBsReloadCallback(w, httptest.NewRequest("POST", "/api/rules/reload", nil))
+		var info RuleDatabasesInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &info); nil != err {
+			t.Fatal(err)
+		}
+		if info.Version != version+1 || len(info.Errors) != tt.errs {
+			t.Errorf("%q: expect version %d with %d errors, but got %+v", tt.cnipset, version+1, tt.errs, info)
+		}
+		if tt.errs > 0 && !strings.HasPrefix(info.Errors[0], "cnipset:") {
+			t.Errorf("%q: unexpected errors %v", tt.cnipset, info.Errors)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	ruleDBsCallback(w, httptest.NewRequest("GET", "/api/rules", nil))
+	var info RuleDatabasesInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); nil != err || info.Version != currentRuleDBs().version {
+		t.Errorf("expect current version %d, but got %+v %v", currentRuleDBs().version, info, err)
+	}
+}
@@ -0,0 +1,42 @@
+package local
+
+import (
+	"net"
+	"strings"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+)
+
+const (
+	//socks5 ip targets are replaced by sniffed domains, socks5h domains are resolved by remote
+	socksResolveSniff = ""
+	//socks5 ip targets are dialed as resolved by clients, socks5h domains are resolved by remote
+	socksResolveClient = "client"
+	//like 'client', but socks5h domains are resolved by local dns before sent to remote
+	socksResolveLocal = "local"
+)
+
+func validSocksResolve(s string) bool {
+	switch strings.ToLower(s) {
+	case socksResolveSniff, socksResolveClient, socksResolveLocal:
+		return true
+	}
+	return false
+}
+
+// socksConnectHost return the host connected by remote for a socks target, the routed host is the target
+// or the domain sniffed from the traffic, which only picks the route unless in default sniff mode
+func (cfg *ProxyConfig) socksConnectHost(target string, domain bool, routed string, proxyChannel string) (string, error) {
+	mode := strings.ToLower(cfg.SocksResolve)
+	if mode == socksResolveSniff {
+		return routed, nil
+	}
+	if !domain {
+		return target, nil
+	}
+	if mode == socksResolveLocal && proxyChannel != channel.DirectChannelName && nil == net.ParseIP(routed) {
+		return dns.DnsGetDoaminIP(routed)
+	}
+	return routed, nil
+}
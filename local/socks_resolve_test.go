@@ -0,0 +1,31 @@
+package local
+
+import "testing"
+
+func TestSocksConnectHost(t *testing.T) {
+	cases := []struct {
+		mode    string
+		target  string
+		domain  bool
+		routed  string
+		connect string
+	}{
+		//socks5 ip target with sniffed SNI
+		{socksResolveSniff, "1.2.3.4", false, "www.example.com", "www.example.com"},
+		{socksResolveClient, "1.2.3.4", false, "www.example.com", "1.2.3.4"},
+		{socksResolveLocal, "1.2.3.4", false, "www.example.com", "1.2.3.4"},
+		//socks5h domain target
+		{socksResolveSniff, "www.example.com", true, "www.example.com", "www.example.com"},
+		{socksResolveClient, "www.example.com", true, "www.example.com", "www.example.com"},
+	}
+	for _, c := range cases {
+		cfg := &ProxyConfig{SocksResolve: c.mode}
+		host, err := cfg.socksConnectHost(c.target, c.domain, c.routed, "remote")
+		if nil != err || host != c.connect {
+			t.Fatalf("mode %q target %s expected %s, but got %s %v", c.mode, c.target, c.connect, host, err)
+		}
+	}
+	if validSocksResolve("remote") {
+		t.Fatalf("invalid mode accepted")
+	}
+}
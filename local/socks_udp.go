@@ -0,0 +1,236 @@
+package local
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/netx"
+	"github.com/yinqiwen/gsnova/common/socks"
+)
+
+type udpAssociateStream struct {
+	stream mux.MuxStream
+	writer io.Writer
+}
+
+type socksUDPAssociation struct {
+	socksConn  *socks.SocksConn
+	udpConn    *net.UDPConn
+	proxy      *ProxyConfig
+	clientAddr *net.UDPAddr
+
+	streams    map[string]*udpAssociateStream
+	directConn net.PacketConn
+	mutex      sync.Mutex
+	closed     bool
+}
+
+func (u *socksUDPAssociation) writeBack(from string, data []byte) error {
+	u.mutex.Lock()
+	clientAddr := u.clientAddr
+	u.mutex.Unlock()
+	if nil == clientAddr {
+		return nil
+	}
+	b, err := socks.BuildUDPDatagram(from, data)
+	if nil != err {
+		return err
+	}
+	_, err = u.udpConn.WriteToUDP(b, clientAddr)
+	return err
+}
+
+func (u *socksUDPAssociation) getDirectConn() (net.PacketConn, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if nil != u.directConn {
+		return u.directConn, nil
+	}
+	c, err := netx.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if nil != err {
+		return nil, err
+	}
+	u.directConn = c
+	go func() {
+		b := make([]byte, 65536)
+		for {
+			n, addr, err := c.ReadFrom(b)
+			if nil != err {
+				return
+			}
+			u.writeBack(addr.String(), b[0:n])
+		}
+	}()
+	return c, nil
+}
+
+// getStreamWriter return the udp associate stream of the channel, the stream is opened out of the lock,
+// only the first one is kept if opened concurrently
+func (u *socksUDPAssociation) getStreamWriter(proxyChannelName string) (io.Writer, error) {
+	u.mutex.Lock()
+	s, exist := u.streams[proxyChannelName]
+	u.mutex.Unlock()
+	if exist {
+		return s.writer, nil
+	}
+	stream, conf, err := channel.GetMuxStreamByChannel(proxyChannelName)
+	if nil != err || nil == stream {
+		return nil, err
+	}
+	opt := mux.StreamOptions{
+		DialTimeout: conf.RemoteDialMSTimeout,
+		ReadTimeout: conf.RemoteUDPReadMSTimeout,
+		Hops:        conf.Hops,
+	}
+	err = stream.Connect(mux.UDPAssociateNetwork, "", opt)
+	if nil != err {
+		stream.Close()
+		return nil, err
+	}
+	streamReader, streamWriter := mux.GetCompressStreamReaderWriter(stream, conf.Compressor)
+	s = &udpAssociateStream{stream: stream, writer: streamWriter}
+	u.mutex.Lock()
+	if u.closed {
+		u.mutex.Unlock()
+		stream.Close()
+		return nil, io.ErrClosedPipe
+	}
+	if current, exist := u.streams[proxyChannelName]; exist {
+		u.mutex.Unlock()
+		stream.Close()
+		return current.writer, nil
+	}
+	u.streams[proxyChannelName] = s
+	u.mutex.Unlock()
+	go func() {
+		for {
+			dgram, err := mux.ReadUDPDatagram(streamReader)
+			if nil != err {
+				break
+			}
+			u.writeBack(dgram.Addr, dgram.Data)
+		}
+		stream.Close()
+		u.mutex.Lock()
+		if u.streams[proxyChannelName] == s {
+			delete(u.streams, proxyChannelName)
+		}
+		u.mutex.Unlock()
+	}()
+	return streamWriter, nil
+}
+
+func (u *socksUDPAssociation) relay(target string, data []byte) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if nil != err {
+		return err
+	}
+	port, _ := strconv.Atoi(portStr)
+	proxyChannelName := u.proxy.getProxyChannelByHost(udpProtocol(port), host, portStr)
+	if len(proxyChannelName) == 0 {
+		logger.Error("[ERROR]No proxy found for udp to %s", target)
+		return nil
+	}
+	if isRejectRemote(proxyChannelName) {
+		logger.Debug("Drop udp packet to %s by reject rule", target)
+		return nil
+	}
+	if proxyChannelName == channel.DirectChannelName {
+		c, err := u.getDirectConn()
+		if nil != err {
+			return err
+		}
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if nil != err {
+			return err
+		}
+		_, err = c.WriteTo(data, addr)
+		return err
+	}
+	w, err := u.getStreamWriter(proxyChannelName)
+	if nil != err {
+		logger.Error("[ERROR]Failed to create udp associate stream by proxy:%s for reason:%v", proxyChannelName, err)
+		return err
+	}
+	return mux.WriteMessage(w, &mux.UDPDatagram{Addr: target, Data: data})
+}
+
+func (u *socksUDPAssociation) close() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.closed {
+		return
+	}
+	u.closed = true
+	u.udpConn.Close()
+	if nil != u.directConn {
+		u.directConn.Close()
+	}
+	for _, s := range u.streams {
+		s.stream.Close()
+	}
+}
+
+func (u *socksUDPAssociation) serve() {
+	tcpAddr, _ := u.socksConn.RemoteAddr().(*net.TCPAddr)
+	b := make([]byte, 65536)
+	for {
+		n, addr, err := u.udpConn.ReadFromUDP(b)
+		if nil != err {
+			break
+		}
+		//only accept datagrams from the client which created the association
+		if nil != tcpAddr && !tcpAddr.IP.Equal(addr.IP) {
+			continue
+		}
+		u.mutex.Lock()
+		u.clientAddr = addr
+		u.mutex.Unlock()
+		target, data, err := socks.ParseUDPDatagram(b[0:n])
+		if nil != err {
+			logger.Debug("Drop invalid socks5 udp datagram from %v for reason:%v", addr, err)
+			continue
+		}
+		err = u.relay(target, append([]byte(nil), data...))
+		if nil != err {
+			logger.Debug("Failed to relay udp datagram to %s for reason:%v", target, err)
+		}
+	}
+}
+
+func handleSocksUDPAssociate(socksConn *socks.SocksConn, proxy *ProxyConfig) {
+	defer socksConn.Close()
+	var ip net.IP
+	if tcpAddr, ok := socksConn.LocalAddr().(*net.TCPAddr); ok {
+		ip = tcpAddr.IP
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: 0})
+	if nil != err {
+		logger.Error("[ERROR]Failed to listen udp for socks5 udp associate:%v", err)
+		socksConn.Reject()
+		return
+	}
+	u := &socksUDPAssociation{
+		socksConn: socksConn,
+		udpConn:   udpConn,
+		proxy:     proxy,
+		streams:   make(map[string]*udpAssociateStream),
+	}
+	defer u.close()
+	if err = socksConn.GrantUDP(udpConn.LocalAddr().(*net.UDPAddr)); nil != err {
+		return
+	}
+	logger.Debug("Start socks5 udp associate for %v on %v", socksConn.RemoteAddr(), udpConn.LocalAddr())
+	go u.serve()
+	//the association terminates when the tcp connection closed
+	var zero time.Time
+	socksConn.SetReadDeadline(zero)
+	io.Copy(ioutil.Discard, socksConn)
+}
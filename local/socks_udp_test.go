@@ -0,0 +1,106 @@
+package local
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/socks"
+)
+
+// startSocksUDPAssociate serve one socks5 udp associate request & return the udp relay address
+func startSocksUDPAssociate(t *testing.T) (net.Conn, *net.UDPAddr, chan bool) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		conn, err := lp.Accept()
+		lp.Close()
+		if nil != err {
+			return
+		}
+		socksConn, _, err := socks.NewSocksConn(conn)
+		if nil != err || !socksConn.IsUDPAssociate() {
+			conn.Close()
+			return
+		}
+		handleSocksUDPAssociate(socksConn, &ProxyConfig{})
+	}()
+	c, err := net.Dial("tcp", lp.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.Write([]byte{5, 1, 0})
+	b := make([]byte, 10)
+	if _, err = io.ReadFull(c, b[:2]); nil != err || b[1] != 0 {
+		t.Fatalf("socks5 auth negotiate failed %v %v", b[:2], err)
+	}
+	c.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0})
+	if _, err = io.ReadFull(c, b); nil != err || b[1] != 0 || b[3] != 1 {
+		t.Fatalf("socks5 udp associate failed %v %v", b, err)
+	}
+	relay := &net.UDPAddr{IP: net.IP(b[4:8]), Port: int(b[8])<<8 | int(b[9])}
+	return c, relay, done
+}
+
+func TestSocksUDPAssociateRoundTrip(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		b := make([]byte, 65536)
+		for {
+			n, addr, err := echo.ReadFromUDP(b)
+			if nil != err {
+				return
+			}
+			echo.WriteToUDP(b[:n], addr)
+		}
+	}()
+
+	c, relay, done := startSocksUDPAssociate(t)
+	defer c.Close()
+	u, err := net.DialUDP("udp", nil, relay)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	target := echo.LocalAddr().String()
+	b := make([]byte, 65536)
+	for _, payload := range []string{"ping", "pong pong"} {
+		dgram, _ := socks.BuildUDPDatagram(target, []byte(payload))
+		u.Write(dgram)
+		u.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := u.Read(b)
+		if nil != err {
+			t.Fatal(err)
+		}
+		from, data, err := socks.ParseUDPDatagram(b[:n])
+		if nil != err || from != target || string(data) != payload {
+			t.Fatalf("recv %q from %s, expected %q from %s, %v", data, from, payload, target, err)
+		}
+	}
+	//invalid datagrams are dropped without breaking the association
+	u.Write([]byte{0, 0, 1})
+	dgram, _ := socks.BuildUDPDatagram(target, []byte("again"))
+	u.Write(dgram)
+	if n, err := u.Read(b); nil != err {
+		t.Fatal(err)
+	} else if _, data, _ := socks.ParseUDPDatagram(b[:n]); string(data) != "again" {
+		t.Fatalf("recv %q after invalid datagram", data)
+	}
+
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("udp association not terminated after the tcp connection closed")
+	}
+}
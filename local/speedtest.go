@@ -0,0 +1,79 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+const defaultSpeedTestSize = "10M"
+
+// defaultSpeedTestChannel return the first enabled channel with servers
+func defaultSpeedTestChannel() string {
+	for _, conf := range GConf.Channel {
+		if conf.Enable && conf.Name != channel.DirectChannelName {
+			return conf.Name
+		}
+	}
+	return ""
+}
+
+// speedTestCallback test bandwidth to the server of '?channel='(default the first enabled one) by '&size='(default 10M)
+func speedTestCallback(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("channel")
+	if len(name) == 0 {
+		name = defaultSpeedTestChannel()
+	}
+	size := r.URL.Query().Get("size")
+	if len(size) == 0 {
+		size = defaultSpeedTestSize
+	}
+	n, err := helper.ToBytes(size)
+	if nil != err || n == 0 {
+		http.Error(w, "invalid 'size':"+size, http.StatusBadRequest)
+		return
+	}
+	res, err := channel.SpeedTest(name, int64(n))
+	if nil != err {
+		logger.Error("[ERROR]Failed to test speed of channel:%s with reason:%v", name, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(res)
+	w.Write(js)
+}
+
+// SpeedTestReport run the speed test by the admin api of a running client
+func SpeedTestReport(adminAddr string, channelName string, size string) (string, error) {
+	params := url.Values{}
+	params.Set("channel", channelName)
+	params.Set("size", size)
+	//server cut each direction in a minute
+	hc := &http.Client{Timeout: 150 * time.Second}
+	res, err := hc.Get("http://" + adminAddr + "/api/speedtest?" + params.Encode())
+	if nil != err {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if nil != err {
+		return "", err
+	}
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("admin api response status:%d %s", res.StatusCode, body)
+	}
+	var st channel.SpeedTestResult
+	if err = json.Unmarshal(body, &st); nil != err {
+		return "", err
+	}
+	return fmt.Sprintf("%s session:%s download:%s in %s/s upload:%s in %s/s\n", st.Channel, st.Session,
+		formatBytes(st.DownloadBytes), formatBytes(st.DownloadRate), formatBytes(st.UploadBytes), formatBytes(st.UploadRate)), nil
+}
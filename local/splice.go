@@ -0,0 +1,83 @@
+package local
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/mux"
+)
+
+// bytes moved by one splice, so that counters of the dashboard advance during long transfers
+const spliceChunk = 4 * 1024 * 1024
+
+// tcpStream is implemented by streams relaying to a raw tcp conn, like streams of direct channels
+type tcpStream interface {
+	TCPConn() *net.TCPConn
+}
+
+// spliceConns return both tcp conns if bytes between the local conn & the stream could be moved in kernel
+func spliceConns(bufconn *helper.BufConn, stream mux.MuxStream) (*net.TCPConn, *net.TCPConn, bool) {
+	if !spliceSupported {
+		return nil, nil, false
+	}
+	local, ok := bufconn.Conn.(*net.TCPConn)
+	if !ok {
+		return nil, nil, false
+	}
+	s, ok := stream.(tcpStream)
+	if !ok {
+		return nil, nil, false
+	}
+	remote := s.TCPConn()
+	return local, remote, nil != remote
+}
+
+// spliceCopy move bytes from src to dst until EOF or both directions idle, 'active' is the latest
+// io time of both in unix nano
+func spliceCopy(dst, src *net.TCPConn, n *int64, active *int64, maxIdleTime time.Duration) error {
+	for {
+		src.SetReadDeadline(time.Now().Add(maxIdleTime))
+		//ReadFrom of tcp conns splice from tcp conns & their limited readers on linux
+		copied, err := dst.ReadFrom(&io.LimitedReader{R: src, N: spliceChunk})
+		if copied > 0 {
+			atomic.AddInt64(n, copied)
+			atomic.StoreInt64(active, time.Now().UnixNano())
+		}
+		if nil == err {
+			if copied < spliceChunk {
+				return nil
+			}
+			continue
+		}
+		if isTimeoutErr(err) && time.Now().Sub(time.Unix(0, atomic.LoadInt64(active))) < maxIdleTime {
+			continue
+		}
+		return err
+	}
+}
+
+// relaySplice relay raw bytes between the local conn & the remote conn in kernel, bytes already buffered by
+// sniffing are written first
+func relaySplice(localConn, remoteConn *net.TCPConn, bufconn *helper.BufConn, ctx *proxyStreamContext, maxIdleTime time.Duration) {
+	if n := bufconn.BR.Buffered(); n > 0 {
+		b, _ := bufconn.BR.Peek(n)
+		if _, err := remoteConn.Write(b); nil != err {
+			return
+		}
+		bufconn.BR.Discard(n)
+		atomic.AddInt64(&ctx.upBytes, int64(n))
+	}
+	active := time.Now().UnixNano()
+	closeCh := make(chan int, 1)
+	go func() {
+		spliceCopy(localConn, remoteConn, &ctx.downBytes, &active, maxIdleTime)
+		localConn.Close()
+		closeCh <- 1
+	}()
+	spliceCopy(remoteConn, localConn, &ctx.upBytes, &active, maxIdleTime)
+	remoteConn.Close()
+	<-closeCh
+}
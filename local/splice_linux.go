@@ -0,0 +1,6 @@
+// +build linux
+
+package local
+
+// tcp conns splice in kernel on linux, elsewhere ReadFrom falls back to copying in user space
+const spliceSupported = true
@@ -0,0 +1,90 @@
+// +build linux
+
+package local
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tcpPair return the accepted conn & the dialed peer
+func tcpPair(b *testing.B) (*net.TCPConn, *net.TCPConn) {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		b.Fatal(err)
+	}
+	defer lp.Close()
+	peer, err := net.Dial("tcp", lp.Addr().String())
+	if nil != err {
+		b.Fatal(err)
+	}
+	c, err := lp.Accept()
+	if nil != err {
+		b.Fatal(err)
+	}
+	return c.(*net.TCPConn), peer.(*net.TCPConn)
+}
+
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchmarkRelay relay 1M per op paced at 1Gbps, the cpu time of the process per GB is logged since
+// the wall time is bound by the pace
+func benchmarkRelay(b *testing.B, relay func(dst, src *net.TCPConn)) {
+	src, srcPeer := tcpPair(b)
+	dst, dstPeer := tcpPair(b)
+	defer srcPeer.Close()
+	defer dstPeer.Close()
+	go func() {
+		relay(dst, src)
+		dst.Close()
+	}()
+	const opSize = 1024 * 1024
+	//1Gbps
+	opTime := time.Duration(opSize * 8)
+	b.SetBytes(opSize)
+	b.ResetTimer()
+	cpu := cpuTime()
+	go func() {
+		buf := make([]byte, opSize)
+		start := time.Now()
+		for i := 0; i < b.N; i++ {
+			if _, err := srcPeer.Write(buf); nil != err {
+				break
+			}
+			if d := start.Add(time.Duration(i+1) * opTime).Sub(time.Now()); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		srcPeer.CloseWrite()
+	}()
+	n, _ := io.CopyBuffer(ioutil.Discard, dstPeer, make([]byte, 128*1024))
+	b.StopTimer()
+	if n != int64(b.N)*opSize {
+		b.Fatalf("expect %d bytes relayed, but got %d", int64(b.N)*opSize, n)
+	}
+	b.Logf("cpu %v per GB for %d ops", (cpuTime()-cpu)*1024/time.Duration(b.N), b.N)
+}
+
+func BenchmarkRelayCopy(b *testing.B) {
+	benchmarkRelay(b, func(dst, src *net.TCPConn) {
+		var n int64
+		//counted like relays of mux streams, which hides ReadFrom of the tcp conn
+		io.CopyBuffer(dst, &countReader{src, &n}, make([]byte, 128*1024))
+	})
+}
+
+func BenchmarkRelaySplice(b *testing.B) {
+	benchmarkRelay(b, func(dst, src *net.TCPConn) {
+		var n int64
+		active := time.Now().UnixNano()
+		spliceCopy(dst, src, &n, &active, 10*time.Second)
+	})
+}
@@ -0,0 +1,5 @@
+// +build !linux
+
+package local
+
+const spliceSupported = false
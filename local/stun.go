@@ -0,0 +1,105 @@
+package local
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// minimal STUN(RFC 5389) binding request, used to see the public address udp flows exit by like WebRTC does
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunHeaderLen       = 20
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXORMappedAddress = 0x0020
+)
+
+var errInvalidSTUNResponse = errors.New("invalid stun response")
+
+func newSTUNRequest() ([]byte, []byte) {
+	b := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(b[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(b[4:8], stunMagicCookie)
+	rand.Read(b[8:20])
+	return b, b[8:20]
+}
+
+// parseSTUNResponse return the mapped ip of the binding response to the transaction
+func parseSTUNResponse(b []byte, txid []byte) (net.IP, error) {
+	if len(b) < stunHeaderLen || binary.BigEndian.Uint16(b[0:2]) != stunBindingResponse ||
+		binary.BigEndian.Uint32(b[4:8]) != stunMagicCookie || string(b[8:20]) != string(txid) {
+		return nil, errInvalidSTUNResponse
+	}
+	n := int(binary.BigEndian.Uint16(b[2:4]))
+	if stunHeaderLen+n > len(b) {
+		return nil, errInvalidSTUNResponse
+	}
+	var mapped net.IP
+	attrs := b[stunHeaderLen : stunHeaderLen+n]
+	for len(attrs) >= 4 {
+		typ, l := binary.BigEndian.Uint16(attrs[0:2]), int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+l > len(attrs) {
+			return nil, errInvalidSTUNResponse
+		}
+		v := attrs[4 : 4+l]
+		//attrs are padded to 4 bytes
+		padded := (l + 3) &^ 3
+		if 4+padded > len(attrs) {
+			padded = len(attrs) - 4
+		}
+		attrs = attrs[4+padded:]
+		if len(v) < 8 || (typ != stunAttrXORMappedAddress && typ != stunAttrMappedAddress) {
+			continue
+		}
+		ipLen := net.IPv4len
+		if v[1] == 2 {
+			ipLen = net.IPv6len
+		}
+		if len(v) < 4+ipLen {
+			continue
+		}
+		ip := make(net.IP, ipLen)
+		copy(ip, v[4:4+ipLen])
+		if typ == stunAttrXORMappedAddress {
+			//xored by the magic cookie & transaction id
+			for i := range ip {
+				ip[i] ^= b[4+i]
+			}
+			return ip, nil
+		}
+		mapped = ip
+	}
+	if nil == mapped {
+		return nil, errInvalidSTUNResponse
+	}
+	return mapped, nil
+}
+
+// stunMappedIP send binding requests to the server by the conn, retried every half timeout
+func stunMappedIP(conn net.PacketConn, server *net.UDPAddr, timeout time.Duration) (net.IP, error) {
+	req, txid := newSTUNRequest()
+	deadline := time.Now().Add(timeout)
+	b := make([]byte, 1500)
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteTo(req, server); nil != err {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout / 2))
+		for {
+			n, _, err := conn.ReadFrom(b)
+			if nil != err {
+				break
+			}
+			if ip, err := parseSTUNResponse(b[:n], txid); nil == err {
+				return ip, nil
+			}
+		}
+	}
+	return nil, errors.New("no stun response from " + server.String())
+}
@@ -0,0 +1,76 @@
+package local
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// stunResponse build a binding response with the XOR-MAPPED-ADDRESS of the ip after an unknown attr
+func stunResponse(req []byte, ip net.IP, port int) []byte {
+	b := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(b[0:2], stunBindingResponse)
+	copy(b[4:20], req[4:20])
+	//SOFTWARE attr padded to 4 bytes
+	b = append(b, 0x80, 0x22, 0, 5, 'g', 's', 'n', 'v', 'a', 0, 0, 0)
+	family, raw := byte(1), ip.To4()
+	if nil == raw {
+		family, raw = 2, ip.To16()
+	}
+	v := []byte{0, family, 0, 0}
+	binary.BigEndian.PutUint16(v[2:4], uint16(port)^uint16(stunMagicCookie>>16))
+	for i := range raw {
+		v = append(v, raw[i]^b[4+i])
+	}
+	attr := make([]byte, 4)
+	binary.BigEndian.PutUint16(attr[0:2], stunAttrXORMappedAddress)
+	binary.BigEndian.PutUint16(attr[2:4], uint16(len(v)))
+	b = append(b, append(attr, v...)...)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-stunHeaderLen))
+	return b
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	for _, s := range []string{"203.0.113.7", "2001:db8::7"} {
+		req, txid := newSTUNRequest()
+		ip, err := parseSTUNResponse(stunResponse(req, net.ParseIP(s), 4000), txid)
+		if nil != err || !ip.Equal(net.ParseIP(s)) {
+			t.Fatalf("expect %s, got %v %v", s, ip, err)
+		}
+		other, _ := newSTUNRequest()
+		if _, err = parseSTUNResponse(stunResponse(req, net.ParseIP(s), 4000), other[8:20]); nil == err {
+			t.Fatalf("response of other transaction accepted")
+		}
+	}
+}
+
+func TestSTUNMappedIP(t *testing.T) {
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		b := make([]byte, 1500)
+		for i := 0; ; i++ {
+			n, addr, err := server.ReadFrom(b)
+			if nil != err {
+				return
+			}
+			//lose the first request
+			if i > 0 {
+				server.WriteTo(stunResponse(b[:n], net.ParseIP("198.51.100.1"), addr.(*net.UDPAddr).Port), addr)
+			}
+		}
+	}()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ip, err := stunMappedIP(conn, server.LocalAddr().(*net.UDPAddr), 2*time.Second)
+	if nil != err || !ip.Equal(net.ParseIP("198.51.100.1")) {
+		t.Fatalf("unexpected mapped ip:%v %v", ip, err)
+	}
+}
@@ -0,0 +1,127 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// reserved destinations which should never be redirected to the tproxy listener
+var tproxyBypassCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+}
+
+const tproxyRouteTable = 100
+
+func tproxyPort(proxyConf *ProxyConfig) (int, error) {
+	_, port, err := net.SplitHostPort(proxyConf.Local)
+	if nil != err {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+func tproxyMark(proxyConf *ProxyConfig) int {
+	if proxyConf.TProxyMark > 0 {
+		return proxyConf.TProxyMark
+	}
+	return 1
+}
+
+func iptablesTProxyRules(buf *bytes.Buffer, proxyConf *ProxyConfig, port int) {
+	mark := tproxyMark(proxyConf)
+	chain := fmt.Sprintf("GSNOVA_%d", port)
+	fmt.Fprintf(buf, "ip rule add fwmark %d table %d\n", mark, tproxyRouteTable)
+	fmt.Fprintf(buf, "ip route add local 0.0.0.0/0 dev lo table %d\n", tproxyRouteTable)
+	fmt.Fprintf(buf, "iptables -t mangle -N %s\n", chain)
+	for _, cidr := range tproxyBypassCIDRs {
+		fmt.Fprintf(buf, "iptables -t mangle -A %s -d %s -j RETURN\n", chain, cidr)
+	}
+	for _, proto := range []string{"tcp", "udp"} {
+		fmt.Fprintf(buf, "iptables -t mangle -A %s -p %s -j TPROXY --on-port %d --tproxy-mark %d\n", chain, proto, port, mark)
+	}
+	fmt.Fprintf(buf, "iptables -t mangle -A PREROUTING -j %s\n", chain)
+	if GConf.TransparentMark > 0 {
+		//reroute local generated traffic except the proxy's own connections marked by 'TransparentMark'
+		output := chain + "_OUTPUT"
+		fmt.Fprintf(buf, "iptables -t mangle -N %s\n", output)
+		fmt.Fprintf(buf, "iptables -t mangle -A %s -m mark --mark %d -j RETURN\n", output, GConf.TransparentMark)
+		for _, cidr := range tproxyBypassCIDRs {
+			fmt.Fprintf(buf, "iptables -t mangle -A %s -d %s -j RETURN\n", output, cidr)
+		}
+		for _, proto := range []string{"tcp", "udp"} {
+			fmt.Fprintf(buf, "iptables -t mangle -A %s -p %s -j MARK --set-mark %d\n", output, proto, mark)
+		}
+		fmt.Fprintf(buf, "iptables -t mangle -A OUTPUT -j %s\n", output)
+	}
+}
+
+func nftTProxyRules(buf *bytes.Buffer, proxyConf *ProxyConfig, port int) {
+	mark := tproxyMark(proxyConf)
+	table := fmt.Sprintf("gsnova_%d", port)
+	fmt.Fprintf(buf, "ip rule add fwmark %d table %d\n", mark, tproxyRouteTable)
+	fmt.Fprintf(buf, "ip route add local 0.0.0.0/0 dev lo table %d\n", tproxyRouteTable)
+	fmt.Fprintf(buf, "nft -f - <<'EOF'\n")
+	fmt.Fprintf(buf, "table ip %s {\n", table)
+	fmt.Fprintf(buf, "\tset bypass {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\telements = { ")
+	for i, cidr := range tproxyBypassCIDRs {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(cidr)
+	}
+	buf.WriteString(" }\n\t}\n")
+	fmt.Fprintf(buf, "\tchain prerouting {\n\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+	fmt.Fprintf(buf, "\t\tip daddr @bypass return\n")
+	fmt.Fprintf(buf, "\t\tmeta l4proto { tcp, udp } tproxy to :%d meta mark set %d accept\n", port, mark)
+	buf.WriteString("\t}\n")
+	if GConf.TransparentMark > 0 {
+		fmt.Fprintf(buf, "\tchain output {\n\t\ttype route hook output priority mangle; policy accept;\n")
+		fmt.Fprintf(buf, "\t\tmeta mark %d return\n", GConf.TransparentMark)
+		fmt.Fprintf(buf, "\t\tip daddr @bypass return\n")
+		fmt.Fprintf(buf, "\t\tmeta l4proto { tcp, udp } meta mark set %d\n", mark)
+		buf.WriteString("\t}\n")
+	}
+	buf.WriteString("}\nEOF\n")
+}
+
+// TProxyRules generate the policy routing & 'iptables' or 'nft' rules redirecting traffic to proxies
+// with 'TProxy' enabled in the client config.
+func TProxyRules(conf string, format string) (string, error) {
+	if err := loadClientConf(conf); nil != err {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for i := range GConf.Proxy {
+		proxyConf := &GConf.Proxy[i]
+		if !proxyConf.TProxy {
+			continue
+		}
+		port, err := tproxyPort(proxyConf)
+		if nil != err {
+			return "", fmt.Errorf("invalid proxy listen:%s for tproxy", proxyConf.Local)
+		}
+		fmt.Fprintf(&buf, "# tproxy rules for %s\n", proxyConf.Local)
+		switch format {
+		case "iptables":
+			iptablesTProxyRules(&buf, proxyConf, port)
+		case "nft":
+			nftTProxyRules(&buf, proxyConf, port)
+		default:
+			return "", fmt.Errorf("unknown tproxy rules format:%s, 'iptables' or 'nft' expected", format)
+		}
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("no proxy with 'TProxy' enabled in %s", conf)
+	}
+	return buf.String(), nil
+}
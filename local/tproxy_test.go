@@ -0,0 +1,103 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTProxyPortAndMark(t *testing.T) {
+	tests := []struct {
+		local string
+		mark  int
+		port  int
+		fwmk  int
+		err   bool
+	}{
+		{":48100", 0, 48100, 1, false},
+		{"0.0.0.0:12345", 7, 12345, 7, false},
+		{"48100", 0, 0, 1, true},
+		{":http", 0, 0, 1, true},
+	}
+	for _, tt := range tests {
+		conf := &ProxyConfig{Local: tt.local, TProxyMark: tt.mark}
+		port, err := tproxyPort(conf)
+		if (nil != err) != tt.err || port != tt.port {
+			t.Errorf("expect port %d(error:%v) of %s, but got %d %v", tt.port, tt.err, tt.local, port, err)
+		}
+		if mark := tproxyMark(conf); mark != tt.fwmk {
+			t.Errorf("expect mark %d of %d, but got %d", tt.fwmk, tt.mark, mark)
+		}
+	}
+}
+
+func TestTProxyRules(t *testing.T) {
+	defer func(conf LocalConfig) { GConf = conf }(GConf)
+	dir, err := ioutil.TempDir("", "tproxy")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name     string
+		conf     string
+		format   string
+		contains []string
+		excludes []string
+		err      bool
+	}{
+		{"iptables", `{"Proxy":[{"Local":":48100","TProxy":true},{"Local":":48101"}]}`, "iptables", []string{
+			"# tproxy rules for :48100",
+			"ip rule add fwmark 1 table 100",
+			"iptables -t mangle -A GSNOVA_48100 -d 192.168.0.0/16 -j RETURN",
+			"iptables -t mangle -A GSNOVA_48100 -p udp -j TPROXY --on-port 48100 --tproxy-mark 1",
+			"iptables -t mangle -A PREROUTING -j GSNOVA_48100",
+		}, []string{":48101", "OUTPUT"}, false},
+		{"iptables output", `{"TransparentMark":255,"Proxy":[{"Local":":48100","TProxy":true,"TProxyMark":2}]}`, "iptables", []string{
+			"iptables -t mangle -A GSNOVA_48100 -p tcp -j TPROXY --on-port 48100 --tproxy-mark 2",
+			"iptables -t mangle -A GSNOVA_48100_OUTPUT -m mark --mark 255 -j RETURN",
+			"iptables -t mangle -A GSNOVA_48100_OUTPUT -p tcp -j MARK --set-mark 2",
+			"iptables -t mangle -A OUTPUT -j GSNOVA_48100_OUTPUT",
+		}, nil, false},
+		{"nft", `{"Proxy":[{"Local":":48100","TProxy":true}]}`, "nft", []string{
+			"table ip gsnova_48100 {",
+			"elements = { 0.0.0.0/8, 10.0.0.0/8,",
+			"meta l4proto { tcp, udp } tproxy to :48100 meta mark set 1 accept",
+			"}\nEOF\n",
+		}, []string{"chain output"}, false},
+		{"nft output", `{"TransparentMark":255,"Proxy":[{"Local":":48100","TProxy":true}]}`, "nft", []string{
+			"chain output {",
+			"meta mark 255 return",
+		}, nil, false},
+		{"unknown format", `{"Proxy":[{"Local":":48100","TProxy":true}]}`, "pf", nil, nil, true},
+		{"no tproxy", `{"Proxy":[{"Local":":48100"}]}`, "iptables", nil, nil, true},
+		{"invalid listen", `{"Proxy":[{"Local":"48100","TProxy":true}]}`, "iptables", nil, nil, true},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(dir, strings.Replace(tt.name, " ", "_", -1)+".json")
+		ioutil.WriteFile(file, []byte(tt.conf), 0644)
+		rules, err := TProxyRules(file, tt.format)
+		if tt.err {
+			if nil == err {
+				t.Errorf("%s: expect error", tt.name)
+			}
+			continue
+		}
+		if nil != err {
+			t.Errorf("%s: unexpected error:%v", tt.name, err)
+			continue
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(rules, s) {
+				t.Errorf("%s: expect %q in rules:\n%s", tt.name, s, rules)
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(rules, s) {
+				t.Errorf("%s: unexpected %q in rules:\n%s", tt.name, s, rules)
+			}
+		}
+	}
+}
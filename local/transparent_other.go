@@ -21,6 +21,10 @@ func enableTransparentSocketMark(v int) {
 	logger.Error("'enableTransparentSocketMark' Not supported in current system")
 }
 
+func listenTProxyTCP(addr string) (*net.TCPListener, error) {
+	return nil, fmt.Errorf("'TProxy' Not supported in current system")
+}
+
 func supportTransparentProxy() bool {
 	return false
 }
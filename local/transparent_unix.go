@@ -3,6 +3,7 @@
 package local
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
 	"github.com/yinqiwen/gsnova/common/logger"
 	"github.com/yinqiwen/gsnova/common/mux"
 	"github.com/yinqiwen/gsnova/common/netx"
@@ -24,44 +26,45 @@ const (
 	IPV6_RECVORIGDSTADDR = 74
 )
 
+// getOrinalTCPRemoteAddr read the original destination of a REDIRECT-ed connection, the connection is not touched
+// if failed, so that it could still be served as a normal proxy connection
 func getOrinalTCPRemoteAddr(conn net.Conn) (net.Conn, net.IP, uint16, error) {
 	tcpConn, ok := conn.(*net.TCPConn)
 	if !ok {
 		return nil, nil, 0, fmt.Errorf("Invalid connection with type:%T", conn)
 	}
-
-	clientConnFile, err := tcpConn.File()
-	if err != nil {
+	rawConn, err := tcpConn.SyscallConn()
+	if nil != err {
 		return nil, nil, 0, err
-	} else {
-		tcpConn.Close()
 	}
-	fd := int(clientConnFile.Fd())
 	var port uint16
 	var ip net.IP
-	//the trick way to get orginal ip/port by syscall
-	ipv6Addr, err := syscall.GetsockoptIPv6MTUInfo(fd, syscall.IPPROTO_IPV6, IP6T_SO_ORIGINAL_DST)
-	if err != nil {
-		ipv4Addr, err := syscall.GetsockoptIPv6Mreq(fd, syscall.IPPROTO_IP, SO_ORIGINAL_DST)
-		if nil != err {
-			clientConnFile.Close()
-			return nil, nil, 0, err
+	var sockErr error
+	err = rawConn.Control(func(s uintptr) {
+		fd := int(s)
+		//the trick way to get orginal ip/port by syscall
+		ipv6Addr, err := syscall.GetsockoptIPv6MTUInfo(fd, syscall.IPPROTO_IPV6, IP6T_SO_ORIGINAL_DST)
+		if err != nil {
+			ipv4Addr, err := syscall.GetsockoptIPv6Mreq(fd, syscall.IPPROTO_IP, SO_ORIGINAL_DST)
+			if nil != err {
+				sockErr = err
+				return
+			}
+			port = uint16(ipv4Addr.Multiaddr[2])<<8 + uint16(ipv4Addr.Multiaddr[3])
+			ip = net.IPv4(ipv4Addr.Multiaddr[4], ipv4Addr.Multiaddr[5], ipv4Addr.Multiaddr[6], ipv4Addr.Multiaddr[7])
+		} else {
+			port = ipv6Addr.Addr.Port
+			ip = make(net.IP, net.IPv6len)
+			copy(ip, ipv6Addr.Addr.Addr[:])
 		}
-		port = uint16(ipv4Addr.Multiaddr[2])<<8 + uint16(ipv4Addr.Multiaddr[3])
-		ip = net.IPv4(ipv4Addr.Multiaddr[4], ipv4Addr.Multiaddr[5], ipv4Addr.Multiaddr[6], ipv4Addr.Multiaddr[7])
-	} else {
-		port = ipv6Addr.Addr.Port
-		ip = make(net.IP, net.IPv6len)
-		copy(ip, ipv6Addr.Addr.Addr[:])
+	})
+	if nil == err {
+		err = sockErr
 	}
-
-	newConn, err := net.FileConn(clientConnFile)
-	if err != nil {
-		clientConnFile.Close()
+	if nil != err {
 		return nil, nil, 0, err
 	}
-
-	return newConn, ip, port, nil
+	return conn, ip, port, nil
 }
 
 type tudpSession struct {
@@ -98,14 +101,33 @@ func (t *tudpSession) handle(p []byte) {
 		if t.remotePort == "53" {
 			protocol = "dns"
 			isDNS = true
+		} else if t.remotePort == "443" {
+			protocol = QUICProtocol
 		}
-		proxyChannelName := t.conf.getProxyChannelByHost(protocol, t.remoteIP.String())
+		remoteHost, _ := dns.FakeIPHost(t.remoteIP.String())
+		proxyChannelName := t.conf.getProxyChannelByHost(protocol, remoteHost, t.remotePort)
 		if len(proxyChannelName) == 0 {
 			logger.Error("[ERROR]No proxy found for %s:%s", protocol, t.remoteIP.String())
 			t.close(nil)
 			return
 		}
+		if isRejectRemote(proxyChannelName) {
+			logger.Debug("Drop udp packet to %s:%s by reject rule", remoteHost, t.remotePort)
+			t.close(nil)
+			return
+		}
 		logger.Debug("Select %s to proxy udp packet to %s:%s", proxyChannelName, t.remoteIP.String(), t.remotePort)
+		if isDNS && proxyChannelName != channel.DirectChannelName {
+			//answered asynchronously, the recv loop is not blocked by hedged queries
+			t.close(nil)
+			go func() {
+				res, err := tunnelDNSQuery(proxyChannelName, net.JoinHostPort(t.remoteIP.String(), t.remotePort), p)
+				if nil == err {
+					writeBackUDPData(res, t.local, t.remote)
+				}
+			}()
+			return
+		}
 		stream, conf, err := channel.GetMuxStreamByChannel(proxyChannelName)
 		var readTimeout int
 		if nil == err {
@@ -119,7 +141,10 @@ func (t *tudpSession) handle(p []byte) {
 				DialTimeout: conf.RemoteDialMSTimeout,
 				ReadTimeout: readTimeout,
 			}
-			err = stream.Connect("udp", net.JoinHostPort(t.remoteIP.String(), t.remotePort), opt)
+			if proxyChannelName == channel.DirectChannelName {
+				opt.SocketMark, opt.DSCP = t.conf.getSocketMarkByHost(protocol, t.remoteIP.String())
+			}
+			err = stream.Connect("udp", net.JoinHostPort(remoteHost, t.remotePort), opt)
 		}
 		if nil != err || nil == stream {
 			logger.Error("Failed to open stream for reason:%v by proxy:%s", err, proxyChannelName)
@@ -253,7 +278,7 @@ func startTransparentUDProxy(addr string, proxy *ProxyConfig) {
 			logger.Error("Recv msg error:%v", err)
 			continue
 		}
-		u := getTUDPSession(proxy, local, remote)
+		u := getTUDPSession(currentHotConf().proxyByLocal(proxy.Local), local, remote)
 		u.handle(data)
 	}
 }
@@ -322,3 +347,30 @@ func enableTransparentSocketMark(v int) {
 func supportTransparentProxy() bool {
 	return true
 }
+
+const IPV6_TRANSPARENT = 75
+
+// listenTProxyTCP listen with IP_TRANSPARENT to accept connections redirected by TPROXY
+func listenTProxyTCP(addr string) (*net.TCPListener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				if network == "tcp6" {
+					serr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, IPV6_TRANSPARENT, 1)
+				} else {
+					serr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+				}
+			})
+			if nil != err {
+				return err
+			}
+			return serr
+		},
+	}
+	lp, err := lc.Listen(context.Background(), "tcp", addr)
+	if nil != err {
+		return nil, err
+	}
+	return lp.(*net.TCPListener), nil
+}
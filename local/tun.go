@@ -0,0 +1,219 @@
+// +build linux,!android darwin windows
+
+package local
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+	"github.com/eycorsican/go-tun2socks/tun"
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
+	"github.com/yinqiwen/gsnova/common/netx"
+)
+
+var tunDevice io.ReadWriteCloser
+var tunStack core.LWIPStack
+var tunSocketOverridden bool
+
+type tunTCPHandler struct {
+	//'Local' of the proxy, whose current config routes flows
+	local string
+}
+
+// Handle serve tun tcp flow as transparent proxy connection
+func (h *tunTCPHandler) Handle(conn net.Conn, target *net.TCPAddr) error {
+	go serveProxyConn(conn, target.IP.String(), strconv.Itoa(target.Port), currentHotConf().proxyByLocal(h.local))
+	return nil
+}
+
+type tunUDPSession struct {
+	key      string
+	conn     core.UDPConn
+	target   *net.UDPAddr
+	stream   mux.MuxStream
+	writer   io.Writer
+	localDNS bool
+	reject   bool
+	//channel of dns queries proxied with hedged queries
+	dnsChannel string
+}
+
+type tunUDPHandler struct {
+	local    string
+	sessions sync.Map
+}
+
+func (h *tunUDPHandler) Connect(conn core.UDPConn, target *net.UDPAddr) error {
+	return nil
+}
+
+func (h *tunUDPHandler) closeSession(s *tunUDPSession, err error) {
+	if nil != s.stream {
+		s.stream.Close()
+	}
+	s.conn.Close()
+	h.sessions.Delete(s.key)
+	if nil != err {
+		logger.Debug("Close tun udp session:%s for reason:%v", s.key, err)
+	}
+}
+
+func (h *tunUDPHandler) newSession(key string, conn core.UDPConn, target *net.UDPAddr) (*tunUDPSession, error) {
+	s := &tunUDPSession{key: key, conn: conn, target: target}
+	remoteHost, _ := dns.FakeIPHost(target.IP.String())
+	proxyChannelName := currentHotConf().proxyByLocal(h.local).getProxyChannelByHost(udpProtocol(target.Port), remoteHost, strconv.Itoa(target.Port))
+	if len(proxyChannelName) == 0 {
+		return nil, channel.ErrNotSupportedOperation
+	}
+	if isRejectRemote(proxyChannelName) {
+		s.reject = true
+		return s, nil
+	}
+	if target.Port == 53 && (proxyChannelName == channel.DirectChannelName || dns.FakeIPEnabled()) {
+		s.localDNS = true
+		return s, nil
+	}
+	if target.Port == 53 {
+		s.dnsChannel = proxyChannelName
+		return s, nil
+	}
+	stream, conf, err := channel.GetMuxStreamByChannelForHost(proxyChannelName, remoteHost)
+	if nil != err {
+		return nil, err
+	}
+	readTimeout := conf.RemoteUDPReadMSTimeout
+	opt := mux.StreamOptions{
+		DialTimeout: conf.RemoteDialMSTimeout,
+		ReadTimeout: readTimeout,
+	}
+	if err = stream.Connect("udp", net.JoinHostPort(remoteHost, strconv.Itoa(target.Port)), opt); nil != err {
+		stream.Close()
+		return nil, err
+	}
+	streamReader, streamWriter := mux.GetCompressStreamReaderWriter(stream, conf.Compressor)
+	s.stream, s.writer = stream, streamWriter
+	go func() {
+		b := make([]byte, 8192)
+		var uerr error
+		for {
+			stream.SetReadDeadline(time.Now().Add(time.Duration(readTimeout) * time.Millisecond))
+			n, err := streamReader.Read(b)
+			if n > 0 {
+				_, err = conn.WriteFrom(b[0:n], target)
+			}
+			uerr = err
+			if nil != err {
+				break
+			}
+		}
+		h.closeSession(s, uerr)
+		if close, ok := streamReader.(io.Closer); ok {
+			close.Close()
+		}
+	}()
+	return s, nil
+}
+
+func (h *tunUDPHandler) ReceiveTo(conn core.UDPConn, data []byte, addr *net.UDPAddr) error {
+	key := conn.LocalAddr().String() + "->" + addr.String()
+	var s *tunUDPSession
+	if v, exist := h.sessions.Load(key); exist {
+		s = v.(*tunUDPSession)
+	} else {
+		var err error
+		s, err = h.newSession(key, conn, addr)
+		if nil != err {
+			logger.Error("[ERROR]Failed to proxy tun udp to %v with reason:%v", addr, err)
+			conn.Close()
+			return err
+		}
+		if s.reject {
+			//blackhole datagrams, the flow would be expired by lwip
+			return nil
+		}
+		h.sessions.Store(key, s)
+	}
+	if s.localDNS {
+		res, err := dns.QueryRaw(data)
+		if nil == err {
+			_, err = conn.WriteFrom(dns.FilterRebindingResponse(res), addr)
+		}
+		h.closeSession(s, err)
+		return err
+	}
+	if len(s.dnsChannel) > 0 {
+		//lwip may reuse the buffer of data
+		query := append([]byte(nil), data...)
+		go func() {
+			res, err := tunnelDNSQuery(s.dnsChannel, net.JoinHostPort(s.target.IP.String(), "53"), query)
+			if nil == err {
+				_, err = conn.WriteFrom(res, addr)
+			}
+			h.closeSession(s, err)
+		}()
+		return nil
+	}
+	_, err := s.writer.Write(data)
+	return err
+}
+
+func startTUN() {
+	conf := &GConf.TUN
+	if !conf.Enable {
+		return
+	}
+	proxy := conf.proxyConfig()
+	if nil == proxy {
+		logger.Error("[ERROR]No proxy config found for tun device")
+		return
+	}
+	if conf.Mark > 0 || len(conf.BindInterface) > 0 {
+		//keep the proxy's own connections out of the tun device
+		control, err := tunSocketControl(conf)
+		if nil != err {
+			logger.Error("[ERROR]Failed to bypass tun device:%s with reason:%v", conf.Name, err)
+			return
+		}
+		netx.OverrideControl(control)
+		tunSocketOverridden = true
+	}
+	dev, err := tun.OpenTunDevice(conf.Name, conf.Addr, conf.Gateway, conf.Mask, conf.DNS, false)
+	if nil != err {
+		logger.Error("[ERROR]Failed to open tun device:%s with reason:%v", conf.Name, err)
+		return
+	}
+	stack := core.NewLWIPStack()
+	core.RegisterTCPConnHandler(&tunTCPHandler{local: proxy.Local})
+	core.RegisterUDPConnHandler(&tunUDPHandler{local: proxy.Local})
+	core.RegisterOutputFn(dev.Write)
+	tunDevice, tunStack = dev, stack
+	logger.Notice("Tun device:%s started with address:%s gateway:%s", conf.Name, conf.Addr, conf.Gateway)
+	go func() {
+		_, err := io.CopyBuffer(stack, dev, make([]byte, 1500))
+		if nil != err && proxyServerRunning {
+			logger.Error("[ERROR]Tun device:%s stopped with reason:%v", conf.Name, err)
+		}
+	}()
+}
+
+func stopTUN() {
+	if tunSocketOverridden {
+		netx.Reset()
+		tunSocketOverridden = false
+	}
+	if nil != tunDevice {
+		tunDevice.Close()
+		tunDevice = nil
+	}
+	if nil != tunStack {
+		tunStack.Close()
+		tunStack = nil
+	}
+}
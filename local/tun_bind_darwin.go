@@ -0,0 +1,44 @@
+// +build darwin
+
+package local
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+const ipv6BoundIF = 125
+
+// tunSocketControl bind the proxy's own sockets to the outgoing interface by IP_BOUND_IF
+func tunSocketControl(conf *TUNConfig) (func(network, address string, c syscall.RawConn) error, error) {
+	if conf.Mark > 0 {
+		logger.Error("[WARN]SO_MARK is not supported on darwin, use 'BindInterface' instead")
+	}
+	index := 0
+	if len(conf.BindInterface) > 0 {
+		iface, err := net.InterfaceByName(conf.BindInterface)
+		if nil != err {
+			return nil, err
+		}
+		index = iface.Index
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if index == 0 {
+			return nil
+		}
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if network == "tcp6" || network == "udp6" {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6BoundIF, index)
+			} else {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_BOUND_IF, index)
+			}
+		})
+		if nil != err {
+			return err
+		}
+		return serr
+	}, nil
+}
@@ -0,0 +1,30 @@
+// +build linux,!android
+
+package local
+
+import (
+	"syscall"
+)
+
+const soMark = 0x24
+
+// tunSocketControl set SO_MARK & SO_BINDTODEVICE on the proxy's own sockets
+func tunSocketControl(conf *TUNConfig) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if conf.Mark > 0 {
+				if serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soMark, conf.Mark); nil != serr {
+					return
+				}
+			}
+			if len(conf.BindInterface) > 0 {
+				serr = syscall.BindToDevice(int(fd), conf.BindInterface)
+			}
+		})
+		if nil != err {
+			return err
+		}
+		return serr
+	}, nil
+}
@@ -0,0 +1,46 @@
+// +build windows
+
+package local
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+const ipUnicastIF = 31
+
+// tunSocketControl bind the proxy's own sockets to the outgoing interface by IP_UNICAST_IF
+func tunSocketControl(conf *TUNConfig) (func(network, address string, c syscall.RawConn) error, error) {
+	if conf.Mark > 0 {
+		logger.Error("[WARN]SO_MARK is not supported on windows, use 'BindInterface' instead")
+	}
+	index := 0
+	if len(conf.BindInterface) > 0 {
+		iface, err := net.InterfaceByName(conf.BindInterface)
+		if nil != err {
+			return nil, err
+		}
+		index = iface.Index
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if index == 0 {
+			return nil
+		}
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if network == "tcp6" || network == "udp6" {
+				serr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, ipUnicastIF, index)
+			} else {
+				//the ipv4 interface index is in network byte order
+				be := int(uint32(index>>24)&0xff | uint32(index>>8)&0xff00 | uint32(index<<8)&0xff0000 | uint32(index<<24)&0xff000000)
+				serr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, ipUnicastIF, be)
+			}
+		})
+		if nil != err {
+			return err
+		}
+		return serr
+	}, nil
+}
@@ -0,0 +1,18 @@
+// +build android !linux,!darwin,!windows
+
+package local
+
+import (
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+var tunSocketOverridden bool
+
+func startTUN() {
+	if GConf.TUN.Enable {
+		logger.Error("'TUN' Not supported in current system")
+	}
+}
+
+func stopTUN() {
+}
@@ -0,0 +1,159 @@
+// +build linux,!android darwin windows
+
+package local
+
+import (
+	"net"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+	"github.com/yinqiwen/gsnova/common/dns"
+)
+
+func TestTUNConfigInit(t *testing.T) {
+	conf := &TUNConfig{}
+	conf.init()
+	if conf.Name != "tun0" || conf.Addr != "10.255.0.2" || conf.Gateway != "10.255.0.1" || conf.Mask != "255.255.255.0" {
+		t.Errorf("unexpected default tun config:%+v", conf)
+	}
+	conf = &TUNConfig{Name: "utun5", Addr: "10.0.0.2", Gateway: "10.0.0.1", Mask: "255.255.0.0"}
+	conf.init()
+	if conf.Name != "utun5" || conf.Addr != "10.0.0.2" || conf.Gateway != "10.0.0.1" || conf.Mask != "255.255.0.0" {
+		t.Errorf("configured values should be kept, but got %+v", conf)
+	}
+
+	defer func(proxy []ProxyConfig) { GConf.Proxy = proxy }(GConf.Proxy)
+	GConf.Proxy = []ProxyConfig{{Local: ":48100"}, {Local: ":48101"}}
+	tests := []struct {
+		proxy string
+		local string
+	}{
+		{"", ":48100"},
+		{":48101", ":48101"},
+		{":48102", ""},
+	}
+	for _, tt := range tests {
+		p := (&TUNConfig{Proxy: tt.proxy}).proxyConfig()
+		if (nil == p && len(tt.local) > 0) || (nil != p && p.Local != tt.local) {
+			t.Errorf("expect proxy %q of tun proxy %q, but got %v", tt.local, tt.proxy, p)
+		}
+	}
+}
+
+// testUDPConn record datagrams written back to the tun device
+type testUDPConn struct {
+	written [][]byte
+	closed  bool
+}
+
+func (c *testUDPConn) LocalAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(10, 255, 0, 2), Port: 40000}
+}
+func (c *testUDPConn) ReceiveTo(data []byte, addr *net.UDPAddr) error {
+	return nil
+}
+func (c *testUDPConn) WriteFrom(data []byte, addr *net.UDPAddr) (int, error) {
+	c.written = append(c.written, append([]byte(nil), data...))
+	return len(data), nil
+}
+func (c *testUDPConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTunUDPSessionRoute(t *testing.T) {
+	defer hotConf.Store(currentHotConf())
+	defer routeCache.flush()
+	tests := []struct {
+		name       string
+		pac        []PACConfig
+		port       int
+		localDNS   bool
+		dnsChannel string
+		reject     bool
+		err        bool
+	}{
+		{"direct dns", []PACConfig{{Protocol: []string{"dns"}, Remote: "direct"}}, 53, true, "", false, false},
+		{"remote dns", []PACConfig{{Protocol: []string{"dns"}, Remote: "remoteA"}}, 53, false, "remoteA", false, false},
+		{"reject quic", []PACConfig{{Protocol: []string{"quic"}, Remote: "Reject"}}, 443, false, "", true, false},
+		{"reject udp", []PACConfig{{Protocol: []string{"udp"}, Remote: "reject"}}, 443, false, "", true, false},
+		{"quic only", []PACConfig{{Protocol: []string{"quic"}, Remote: "Reject"}}, 5000, false, "", false, true},
+		{"no proxy", []PACConfig{{Protocol: []string{"tcp"}, Remote: "remoteA"}}, 5000, false, "", false, true},
+		{"no channel", []PACConfig{{Remote: "remoteA"}}, 5000, false, "", false, true},
+	}
+	for _, tt := range tests {
+		publishHotConf(&LocalConfig{Proxy: []ProxyConfig{{Local: ":48100", PAC: tt.pac}}})
+		routeCache.flush()
+		h := &tunUDPHandler{local: ":48100"}
+		s, err := h.newSession("test", &testUDPConn{}, &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: tt.port})
+		if tt.err {
+			if nil == err {
+				t.Errorf("%s: expect error", tt.name)
+			}
+			continue
+		}
+		if nil != err {
+			t.Errorf("%s: unexpected error:%v", tt.name, err)
+			continue
+		}
+		if s.localDNS != tt.localDNS || s.dnsChannel != tt.dnsChannel || s.reject != tt.reject {
+			t.Errorf("%s: expect local dns %v & dns channel %q & reject %v, but got %v & %q & %v", tt.name, tt.localDNS, tt.dnsChannel, tt.reject, s.localDNS, s.dnsChannel, s.reject)
+		}
+	}
+}
+
+func TestTunUDPReject(t *testing.T) {
+	defer hotConf.Store(currentHotConf())
+	defer routeCache.flush()
+	publishHotConf(&LocalConfig{Proxy: []ProxyConfig{{Local: ":48100", PAC: []PACConfig{{Protocol: []string{"quic"}, Remote: "Reject"}}}}})
+	routeCache.flush()
+	h := &tunUDPHandler{local: ":48100"}
+	conn := &testUDPConn{}
+	target := &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 443}
+	for i := 0; i < 2; i++ {
+		if err := h.ReceiveTo(conn, []byte("quic initial"), target); nil != err {
+			t.Fatal(err)
+		}
+	}
+	//datagrams are dropped without closing the flow or keeping a session
+	if len(conn.written) != 0 || conn.closed {
+		t.Errorf("expect datagrams dropped silently, but got %d written & closed:%v", len(conn.written), conn.closed)
+	}
+	if _, exist := h.sessions.Load(conn.LocalAddr().String() + "->" + target.String()); exist {
+		t.Errorf("rejected flow should not be kept as session")
+	}
+}
+
+func TestTunUDPLocalDNS(t *testing.T) {
+	defer hotConf.Store(currentHotConf())
+	publishHotConf(&LocalConfig{Proxy: []ProxyConfig{{Local: ":48100", PAC: []PACConfig{{Remote: "remoteA"}}}}})
+	localDNS := dns.LocalDNS
+	defer func() {
+		dns.Init(&dns.LocalDNSConfig{})
+		dns.LocalDNS = localDNS
+	}()
+	dns.Init(&dns.LocalDNSConfig{FakeIP: dns.FakeIPConfig{Enable: true}})
+	h := &tunUDPHandler{local: ":48100"}
+	conn := &testUDPConn{}
+	target := &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}
+	//queries are answered by the fake ip pool even if routed to remote
+	req := new(mdns.Msg)
+	req.SetQuestion("www.example.com.", mdns.TypeA)
+	query, _ := req.Pack()
+	if err := h.ReceiveTo(conn, query, target); nil != err {
+		t.Fatal(err)
+	}
+	if len(conn.written) != 1 || !conn.closed {
+		t.Fatalf("expect one answer & the flow closed, but got %d answers", len(conn.written))
+	}
+	res := new(mdns.Msg)
+	if err := res.Unpack(conn.written[0]); nil != err || len(res.Answer) != 1 {
+		t.Fatalf("invalid answer %v %v", res, err)
+	}
+	if ip := res.Answer[0].(*mdns.A).A; !dns.IsFakeIP(ip) {
+		t.Errorf("expect fake ip answer, but got %s", ip)
+	}
+	if _, exist := h.sessions.Load(conn.LocalAddr().String() + "->" + target.String()); exist {
+		t.Errorf("dns session should be removed once answered")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
@@ -153,11 +154,11 @@ func (u *udpSession) handlePacket(proxy *ProxyConfig, packet *udpgwPacket) error
 
 	remoteAddr := packet.address()
 	if packet.addr.port == 53 {
-		selectProxy := proxy.findProxyChannelByRequest("dns", packet.addr.ip.String(), nil)
+		selectProxy := proxy.findProxyChannelByRequest("dns", packet.addr.ip.String(), "53", nil)
 		if selectProxy == channel.DirectChannelName {
-			res, err := dns.LocalDNS.QueryRaw(packet.content)
+			res, err := dns.QueryRaw(packet.content)
 			if nil == err {
-				err = u.Write(res)
+				err = u.Write(dns.FilterRebindingResponse(res))
 			}
 			if nil != err {
 				logger.Error("[ERROR]Failed to query dns with reason:%v", err)
@@ -165,13 +166,32 @@ func (u *udpSession) handlePacket(proxy *ProxyConfig, packet *udpgwPacket) error
 			u.close()
 			return err
 		}
-		u.proxyChannelName = selectProxy
+		if isRejectRemote(selectProxy) {
+			logger.Debug("Drop dns query to %s by reject rule", remoteAddr)
+			u.close()
+			return nil
+		}
 		if len(GConf.LocalDNS.TrustedDNS) > 0 {
-			remoteAddr = GConf.LocalDNS.TrustedDNS[0]
+			remoteAddr = trustedDNSAddr(GConf.LocalDNS.TrustedDNS[0])
+		}
+		res, err := tunnelDNSQuery(selectProxy, remoteAddr, packet.content)
+		if nil == err {
+			err = u.Write(res)
 		}
+		u.close()
+		return err
 	}
 	if len(u.proxyChannelName) == 0 {
-		u.proxyChannelName = proxy.findProxyChannelByRequest("udp", packet.addr.ip.String(), nil)
+		if host, ok := dns.FakeIPHost(packet.addr.ip.String()); ok {
+			remoteAddr = net.JoinHostPort(host, strconv.Itoa(int(packet.addr.port)))
+			u.proxyChannelName = proxy.getProxyChannelByHost(udpProtocol(int(packet.addr.port)), host, strconv.Itoa(int(packet.addr.port)))
+		} else {
+			u.proxyChannelName = proxy.findProxyChannelByRequest(udpProtocol(int(packet.addr.port)), packet.addr.ip.String(), strconv.Itoa(int(packet.addr.port)), nil)
+		}
+	}
+	if isRejectRemote(u.proxyChannelName) {
+		logger.Debug("Drop udp packet to %s by reject rule", remoteAddr)
+		return nil
 	}
 	if len(u.proxyChannelName) == 0 {
 		logger.Error("[ERROR]No proxy found for udp to %s", packet.addr.ip.String())
@@ -185,9 +205,6 @@ func (u *udpSession) handlePacket(proxy *ProxyConfig, packet *udpgwPacket) error
 	}
 	stream, conf, err := channel.GetMuxStreamByChannel(u.proxyChannelName)
 	readTimeoutMS := conf.RemoteUDPReadMSTimeout
-	if packet.addr.port == 53 {
-		readTimeoutMS = conf.RemoteDNSReadMSTimeout
-	}
 	if nil != stream {
 		opt := mux.StreamOptions{
 			DialTimeout: conf.RemoteDialMSTimeout,
@@ -5,19 +5,42 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/yinqiwen/gotoolkit/ots"
 	"github.com/yinqiwen/gsnova/common/channel"
 	_ "github.com/yinqiwen/gsnova/common/channel/common"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/dnspub"
 	"github.com/yinqiwen/gsnova/common/helper"
 	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/supervisor"
 	"github.com/yinqiwen/gsnova/local"
 	"github.com/yinqiwen/gsnova/remote"
 )
 
+// watchReloadSignal hot reload the config file on SIGHUP, sessions are kept
+func watchReloadSignal(client bool) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		var err error
+		if client {
+			err = local.HotReload()
+		} else {
+			err = remote.ReloadConf()
+		}
+		if nil != err {
+			logger.Error("[ERROR]Failed to reload config for reason:%v", err)
+		}
+	}
+}
+
 func printASCIILogo() {
 
 	logo := `
@@ -36,6 +59,52 @@ func printASCIILogo() {
 	fmt.Println(logo)
 }
 
+// supervisedListenAddrs collect tcp listen addresses which would be kept by supervisor across worker restarts
+func supervisedListenAddrs(runAsClient bool, confile string, cmd bool, listens []string) []string {
+	var addrs []string
+	if runAsClient && cmd {
+		return listens
+	}
+	var conf struct {
+		Proxy []struct {
+			Local string
+		}
+		Server []struct {
+			Listen string
+		}
+	}
+	if !cmd {
+		data, err := helper.ReadWithoutComment(confile, "//")
+		if nil == err {
+			err = json.Unmarshal(data, &conf)
+		}
+		if nil != err {
+			logger.Error("Failed to load config:%s for supervisor with reason:%v", confile, err)
+			return nil
+		}
+	}
+	if runAsClient {
+		for _, p := range conf.Proxy {
+			addrs = append(addrs, p.Local)
+		}
+		return addrs
+	}
+	for _, s := range conf.Server {
+		listens = append(listens, s.Listen)
+	}
+	for _, lis := range listens {
+		u, err := url.Parse(lis)
+		if nil != err {
+			continue
+		}
+		switch u.Scheme {
+		case "tcp", "tls", "http2", "grpc":
+			addrs = append(addrs, u.Host)
+		}
+	}
+	return addrs
+}
+
 func main() {
 	// if err := agent.Listen(agent.Options{}); err != nil {
 	// 	log.Fatal(err)
@@ -52,6 +121,10 @@ func main() {
 	isClient := flag.Bool("client", false, "Launch gsnova as client.")
 	isServer := flag.Bool("server", false, "Launch gsnova as server.")
 	pid := flag.String("pid", ".gsnova.pid", "PID file")
+	dnspubConf := flag.String("dnspub", "", "Publish server addresses to DNS provider by the config file.")
+	supervise := flag.Bool("supervise", false, "Run worker under a supervisor process which restarts it on crash.")
+	printSchema := flag.Bool("schema", false, "Print JSON schema of client or server config.")
+	validate := flag.Bool("validate", false, "Validate the config file against the schema.")
 	conf := flag.String("conf", "", "Config file of gsnova.")
 	key := flag.String("key", "809240d3a021449f6e67aa73221d42df942a308a", "Cipher key for transmission between local&remote.")
 	log := flag.String("log", "color,gsnova.log", "Log file setting")
@@ -77,8 +150,15 @@ func main() {
 	flag.Var(&hops, "remote", "Next remote proxy hop server to connect for client, eg:wss://xxx.paas.com")
 	flag.Var(&forwards, "forward", "Forward connection to specified address")
 	p2spRoomID := flag.String("p2sp", "", "P2SP Room Id")
+	p2pWebRTC := flag.Bool("p2sp.webrtc", false, "Try direct WebRTC connection with P2SP peer")
 	servable := flag.Bool("servable", false, "Client as a proxy server for peer p2sp client")
 	proxy := flag.String("proxy", "", "Proxy setting to connect remote server.")
+	tproxyRules := flag.String("tproxy_rules", "", "Print 'iptables' or 'nft' rules for TProxy enabled proxies in client config.")
+	ruleKeygen := flag.Bool("rule_keygen", false, "Generate the key pair for signing rule bundles distributed by servers.")
+	quota := flag.String("quota", "", "Print traffic quota status of servers by the admin address of running client, eg:127.0.0.1:7788")
+	speedTest := flag.String("test", "", "Test bandwidth to the server of a channel by the admin address of running client, eg:127.0.0.1:7788")
+	speedTestChannel := flag.String("test.channel", "", "Channel to test bandwidth, default the first enabled one")
+	speedTestSize := flag.String("test.size", "10M", "Bytes to download & upload in bandwidth test")
 
 	//client or server listen
 	var listens channel.HopServers
@@ -95,7 +175,12 @@ func main() {
 		return
 	}
 
-	printASCIILogo()
+	if len(*dnspubConf) > 0 {
+		if err := dnspub.Run(*dnspubConf); nil != err {
+			logger.Error("Failed to publish server address:%v", err)
+		}
+		return
+	}
 
 	confile := *conf
 	runAsClient := false
@@ -108,6 +193,97 @@ func main() {
 		return
 	}
 
+	if *printSchema || *validate {
+		var schema map[string]interface{}
+		if runAsClient {
+			schema = helper.JSONSchema(local.LocalConfig{}, "GSnova client config")
+		} else {
+			schema = helper.JSONSchema(remote.ServerConfig{}, "GSnova server config")
+		}
+		if *printSchema {
+			js, _ := json.MarshalIndent(schema, "", "  ")
+			fmt.Println(string(js))
+			return
+		}
+		if len(confile) == 0 {
+			if runAsClient {
+				confile = "./client.json"
+			} else {
+				confile = "./server.json"
+			}
+		}
+		data, err := helper.ReadWithoutComment(confile, "//")
+		if nil != err {
+			fmt.Printf("Failed to read config:%s with reason:%v\n", confile, err)
+			os.Exit(1)
+		}
+		if errs := helper.ValidateJSON(schema, data); len(errs) > 0 {
+			for _, e := range errs {
+				fmt.Println(e)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("Config:%s is valid.\n", confile)
+		return
+	}
+
+	if *ruleKeygen {
+		priv, pub, err := channel.GenRuleSigningKey()
+		if nil != err {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("SigningKey(server):%s\nPublicKey(client):%s\n", priv, pub)
+		return
+	}
+	if len(*quota) > 0 {
+		report, err := local.QuotaReport(*quota)
+		if nil != err {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		return
+	}
+	if len(*speedTest) > 0 {
+		report, err := local.SpeedTestReport(*speedTest, *speedTestChannel, *speedTestSize)
+		if nil != err {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		return
+	}
+	if len(*tproxyRules) > 0 {
+		if len(confile) == 0 {
+			confile = "./client.json"
+		}
+		rules, err := local.TProxyRules(confile, *tproxyRules)
+		if nil != err {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(rules)
+		return
+	}
+
+	printASCIILogo()
+
+	if *supervise && !supervisor.IsWorker() {
+		if len(confile) == 0 {
+			if runAsClient {
+				confile = "./client.json"
+			} else {
+				confile = "./server.json"
+			}
+		}
+		addrs := supervisedListenAddrs(runAsClient, confile, *cmd, listens)
+		if err := supervisor.Run(addrs); nil != err {
+			logger.Error("Supervisor exit with reason:%v", err)
+		}
+		return
+	}
+
 	if len(*otsListen) > 0 {
 		err := ots.StartTroubleShootingServer(*otsListen)
 		if nil != err {
@@ -168,6 +344,7 @@ func main() {
 				ch.ServerList = []string{hops[0]}
 				ch.Hops = hops[1:]
 				ch.P2SPRoom = *p2spRoomID
+				ch.P2PWebRTC = *p2pWebRTC
 				ch.Proxy = *proxy
 				local.GConf.Channel = []channel.ProxyChannelConfig{ch}
 			}
@@ -204,6 +381,7 @@ func main() {
 				confile = "./server.json"
 			}
 			if _, err := os.Stat(confile); nil == err {
+				remote.ConfigFile = confile
 				logger.Info("Load server conf from file:%s", confile)
 				data, err := helper.ReadWithoutComment(confile, "//")
 
@@ -267,12 +445,33 @@ func main() {
 			remote.ServerConf.Cipher.Key = cipherKey
 			logger.Notice("Server cipher key overide by env:GSNOVA_CIPHER_KEY")
 		}
-		channel.DefaultServerRateLimit = remote.ServerConf.RateLimit
+		channel.SetServerRateLimit(remote.ServerConf.RateLimit)
+		channel.SetClientVersionLimit(remote.ServerConf.ClientVersion)
+		channel.SetDialRetryConfig(remote.ServerConf.DialRetry)
+		channel.SetTargetKeepAliveConfig(remote.ServerConf.TargetKeepAlive)
+		channel.SetSessionLimitConfig(remote.ServerConf.SessionLimit)
+		channel.SetAuthBanConfig(remote.ServerConf.AuthBan)
+		if err := channel.SetAuditLogConfig(remote.ServerConf.AuditLog); nil != err {
+			logger.Error("[ERROR]Failed to open audit log:%v", err)
+		}
+		if err := channel.SetSpeedTestConfig(remote.ServerConf.SpeedTest); nil != err {
+			logger.Error("[ERROR]%v", err)
+		}
+		if err := channel.SetAuthConfigs(remote.ServerConf.Users, remote.ServerConf.AuthProvider); nil != err {
+			logger.Error("[ERROR]%v", err)
+			os.Exit(1)
+		}
+		helper.SetIPSets(remote.ServerConf.ProxyLimit.IPSets)
+		channel.SetDefaultProxyLimitConfig(remote.ServerConf.ProxyLimit)
 		channel.SetDefaultMuxConfig(remote.ServerConf.Mux)
+		dns.InitCache(remote.ServerConf.DNSCache)
 		remote.ServerConf.Cipher.AllowUsers(remote.ServerConf.Cipher.User)
 		channel.DefaultServerCipher = remote.ServerConf.Cipher
 
 		logger.InitLogger(remote.ServerConf.Log)
+		if err := channel.InitTracing(&remote.ServerConf.Tracing); nil != err {
+			logger.Error("[ERROR]Failed to init tracing with reason:%v", err)
+		}
 
 		logger.Info("Load server conf success.")
 		confdata, _ := json.MarshalIndent(&remote.ServerConf, "", "    ")
@@ -283,6 +482,15 @@ func main() {
 	if len(*pid) > 0 {
 		ioutil.WriteFile(*pid, []byte(fmt.Sprintf("%d", os.Getpid())), os.ModePerm)
 	}
-	ch := make(chan int)
-	<-ch
+	go watchReloadSignal(runAsClient)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	logger.Notice("Recv signal:%v, shutdown now.", sig)
+	if runAsClient {
+		//remove system dns rules, routes & tun device created by client
+		local.Stop()
+	}
+	//flush pending spans before exit
+	channel.ShutdownTracing()
 }
@@ -0,0 +1,213 @@
+package remote
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+type AdminConfig struct {
+	Listen string
+	//required, requests must carry it as 'Authorization: Bearer <Token>'
+	Token string
+}
+
+func adminAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(ServerConf.Admin.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	js, _ := json.Marshal(v)
+	w.Write(js)
+}
+
+func adminSessionsCallback(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, channel.ListSessions(r.URL.Query().Get("user")))
+}
+
+func adminKickCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	user := r.URL.Query().Get("user")
+	closed := 0
+	if len(id) > 0 {
+		if channel.CloseSession(id) {
+			closed = 1
+		}
+	} else if len(user) > 0 {
+		closed = channel.CloseUserSessions(user)
+	} else {
+		http.Error(w, "'id' or 'user' required", http.StatusBadRequest)
+		return
+	}
+	logger.Notice("Admin closed %d sessions by id:%s user:%s", closed, id, user)
+	writeJSON(w, map[string]int{"Closed": closed})
+}
+
+func adminBansCallback(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, channel.ListAuthBans())
+}
+
+// adminUnbanCallback lift the ban of '?ip='
+func adminUnbanCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	if !channel.UnbanIP(ip) {
+		http.Error(w, "not banned ip:"+ip, http.StatusNotFound)
+		return
+	}
+	logger.Notice("Admin unbanned ip:%s", ip)
+	w.WriteHeader(200)
+}
+
+func adminRateLimitCallback(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, channel.DumpRateLimitBuckets())
+}
+
+func adminDNSCacheCallback(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, dns.GetCacheStats())
+}
+
+func adminDNSCacheFlushCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	flushed := dns.FlushCache()
+	logger.Notice("Admin flushed %d dns cache records", flushed)
+	writeJSON(w, map[string]int{"Flushed": flushed})
+}
+
+func adminQuotaCallback(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+	st := channel.GetQuotaStatus(user)
+	if nil == st {
+		http.Error(w, "no quota configured for user:"+user, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, st)
+}
+
+// adminQuotaTopUpCallback add '&bytes=' like '10G' to the quota of '?user=' in current period
+func adminQuotaTopUpCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	user := r.URL.Query().Get("user")
+	bytes, err := helper.ToBytes(r.URL.Query().Get("bytes"))
+	if nil != err || len(user) == 0 {
+		http.Error(w, "'user' & valid 'bytes' required", http.StatusBadRequest)
+		return
+	}
+	st, err := channel.TopUpQuota(user, int64(bytes))
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Notice("Admin topped up %d bytes quota for user:%s", bytes, user)
+	writeJSON(w, st)
+}
+
+func streamFilter(r *http.Request) (channel.StreamFilter, error) {
+	q := r.URL.Query()
+	f := channel.StreamFilter{Session: q.Get("session"), User: q.Get("user"), Addr: q.Get("addr")}
+	if age := q.Get("min_age"); len(age) > 0 {
+		secs, err := strconv.ParseInt(age, 10, 64)
+		if nil != err {
+			return f, err
+		}
+		f.MinAgeSecs = secs
+	}
+	return f, nil
+}
+
+func adminStreamsCallback(w http.ResponseWriter, r *http.Request) {
+	f, err := streamFilter(r)
+	if nil != err {
+		http.Error(w, "invalid 'min_age'", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, channel.ListStreams(f))
+}
+
+// adminStreamsCloseCallback terminate streams by '?session=&user=&addr=&min_age=' without closing their sessions
+func adminStreamsCloseCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := streamFilter(r)
+	if nil != err {
+		http.Error(w, "invalid 'min_age'", http.StatusBadRequest)
+		return
+	}
+	if len(f.Session) == 0 && len(f.User) == 0 && len(f.Addr) == 0 && f.MinAgeSecs <= 0 {
+		http.Error(w, "'session', 'user', 'addr' or 'min_age' required", http.StatusBadRequest)
+		return
+	}
+	closed := channel.CloseStreams(f)
+	logger.Notice("Admin closed %d streams by session:%s user:%s addr:%s min_age:%d", closed, f.Session, f.User, f.Addr, f.MinAgeSecs)
+	writeJSON(w, map[string]int{"Closed": closed})
+}
+
+func adminReloadCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := ReloadConf(); nil != err {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func startAdminServer() {
+	if len(ServerConf.Admin.Listen) == 0 {
+		return
+	}
+	if len(ServerConf.Admin.Token) == 0 {
+		logger.Error("[ERROR]Admin server disabled since no 'Token' configured.")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", adminAuth(adminSessionsCallback))
+	mux.HandleFunc("/sessions/kick", adminAuth(adminKickCallback))
+	mux.HandleFunc("/ratelimit", adminAuth(adminRateLimitCallback))
+	mux.HandleFunc("/bans", adminAuth(adminBansCallback))
+	mux.HandleFunc("/bans/unban", adminAuth(adminUnbanCallback))
+	mux.HandleFunc("/dns/cache", adminAuth(adminDNSCacheCallback))
+	mux.HandleFunc("/dns/cache/flush", adminAuth(adminDNSCacheFlushCallback))
+	mux.HandleFunc("/streams", adminAuth(adminStreamsCallback))
+	mux.HandleFunc("/streams/close", adminAuth(adminStreamsCloseCallback))
+	mux.HandleFunc("/reload", adminAuth(adminReloadCallback))
+	mux.HandleFunc("/quota", adminAuth(adminQuotaCallback))
+	mux.HandleFunc("/quota/topup", adminAuth(adminQuotaTopUpCallback))
+	logger.Info("Listen on admin address:%s", ServerConf.Admin.Listen)
+	err := http.ListenAndServe(ServerConf.Admin.Listen, mux)
+	if nil != err {
+		logger.Error("Failed to start admin server:%v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	saved := ServerConf.Admin.Token
+	defer func() { ServerConf.Admin.Token = saved }()
+	ServerConf.Admin.Token = "secret"
+	h := adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusNoContent},
+		{"Bearer secret2", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/sessions", nil)
+		if len(tt.auth) > 0 {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != tt.status {
+			t.Errorf("authorization %q expect status %d, but got %d", tt.auth, tt.status, w.Code)
+		}
+	}
+}
+
+func TestAdminKickCallback(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		status int
+		body   string
+	}{
+		{"GET", "/sessions/kick?id=x", http.StatusMethodNotAllowed, ""},
+		{"POST", "/sessions/kick", http.StatusBadRequest, ""},
+		{"POST", "/sessions/kick?id=admin-test-none", http.StatusOK, `{"Closed":0}`},
+		{"POST", "/sessions/kick?user=admin-test-none", http.StatusOK, `{"Closed":0}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		adminKickCallback(w, httptest.NewRequest(tt.method, tt.url, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s expect status %d, but got %d", tt.method, tt.url, tt.status, w.Code)
+			continue
+		}
+		if len(tt.body) > 0 && w.Body.String() != tt.body {
+			t.Errorf("%s %s expect body %s, but got %s", tt.method, tt.url, tt.body, w.Body.String())
+		}
+	}
+}
+
+func TestAdminStreamsCallback(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		close  bool
+		status int
+		body   string
+	}{
+		{"GET", "/streams?min_age=x", false, http.StatusBadRequest, ""},
+		{"GET", "/streams?user=admin-test-none", false, http.StatusOK, "null"},
+		{"GET", "/streams/close?user=admin-test-none", true, http.StatusMethodNotAllowed, ""},
+		{"POST", "/streams/close", true, http.StatusBadRequest, ""},
+		{"POST", "/streams/close?min_age=0", true, http.StatusBadRequest, ""},
+		{"POST", "/streams/close?min_age=-1s", true, http.StatusBadRequest, ""},
+		{"POST", "/streams/close?addr=admin-test-none.com", true, http.StatusOK, `{"Closed":0}`},
+		{"POST", "/streams/close?session=admin-test-none&min_age=60", true, http.StatusOK, `{"Closed":0}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if tt.close {
+			adminStreamsCloseCallback(w, httptest.NewRequest(tt.method, tt.url, nil))
+		} else {
+			adminStreamsCallback(w, httptest.NewRequest(tt.method, tt.url, nil))
+		}
+		if w.Code != tt.status {
+			t.Errorf("%s %s expect status %d, but got %d", tt.method, tt.url, tt.status, w.Code)
+			continue
+		}
+		if len(tt.body) > 0 && w.Body.String() != tt.body {
+			t.Errorf("%s %s expect body %s, but got %s", tt.method, tt.url, tt.body, w.Body.String())
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package remote
 
 import (
+	"github.com/yinqiwen/gsnova/common/certs"
 	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/dns"
 )
 
 type ServerListenConfig struct {
@@ -9,6 +11,9 @@ type ServerListenConfig struct {
 	Cert     string
 	Key      string
 	KCParams channel.KCPConfig
+	TLS      channel.TLSPolicyConfig
+	//use the cert issued by 'ACME' of server instead of Cert/Key
+	ACME bool
 }
 
 type ServerConfig struct {
@@ -17,7 +22,39 @@ type ServerConfig struct {
 	ProxyLimit channel.ProxyLimitConfig
 	Mux        channel.MuxConfig
 	Log        []string
+	Store      string
 	Server     []ServerListenConfig
+
+	//reject clients older than the limit
+	ClientVersion channel.ClientVersionLimitConfig
+	Users         []channel.UserConfig
+	Admin         AdminConfig
+	Tracing       channel.TracingConfig
+	DNSCache      dns.CacheConfig
+	//retry destinations failed to dial, failures are reported to clients on control streams
+	DialRetry channel.DialRetryConfig
+	//tcp keepalive probes on connections to destinations, streams of dead connections are reset promptly
+	TargetKeepAlive channel.TargetKeepAliveConfig
+	//limits of streams per session & sessions per user/source ip, sessions over limits are rejected by auth codes
+	SessionLimit channel.SessionLimitConfig
+	//ban source ips failed to auth too many times, bans are listed by admin api '/bans'
+	AuthBan channel.AuthBanConfig
+	//verify users by LDAP/RADIUS/OAuth token introspection instead of the static user list, results are cached
+	AuthProvider channel.AuthProviderConfig
+	//json lines of every proxied stream with user, source, destination, bytes, duration & close reason
+	AuditLog channel.AuditLogConfig
+	//public page of uptime & aggregate throughput on http listeners
+	StatusPage StatusPageConfig
+	//cert issued by DNS-01 challenges for listeners enabled 'ACME', renewed without restarting listeners
+	ACME certs.ACMEConfig
+	//speed test streams of clients, capped by size & cooldown per user
+	SpeedTest channel.SpeedTestConfig
+	//listen address routing http requests by 'Host' to reverse tunnels registered by hostname
+	ReverseHTTP string
+	//public hostnames/ips of the server, clients reaching reverse tunnels through them are relayed over the mux directly
+	ReverseHairpin []string
+	//signed rule files pushed to clients
+	RuleDistribution channel.RuleDistributionConfig
 }
 
 var ServerConf ServerConfig
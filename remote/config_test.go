@@ -0,0 +1,17 @@
+package remote
+
+import (
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/helper"
+)
+
+func TestSampleConfigSchema(t *testing.T) {
+	data, err := helper.ReadWithoutComment("../server.json", "//")
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, e := range helper.ValidateJSON(helper.JSONSchema(ServerConfig{}, "GSnova server config"), data) {
+		t.Errorf("server.json: %s", e)
+	}
+}
@@ -4,28 +4,73 @@ import (
 	"crypto/tls"
 	"net/url"
 
+	"github.com/yinqiwen/gsnova/common/certs"
+	"github.com/yinqiwen/gsnova/common/channel"
 	"github.com/yinqiwen/gsnova/common/helper"
 	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/store"
 
+	"github.com/yinqiwen/gsnova/common/channel/dnstunnel"
+	"github.com/yinqiwen/gsnova/common/channel/grpc"
 	"github.com/yinqiwen/gsnova/common/channel/http2"
+	"github.com/yinqiwen/gsnova/common/channel/icmp"
 	"github.com/yinqiwen/gsnova/common/channel/kcp"
 	"github.com/yinqiwen/gsnova/common/channel/quic"
 	"github.com/yinqiwen/gsnova/common/channel/tcp"
+	"github.com/yinqiwen/gsnova/common/channel/trojan"
 )
 
-func generateTLSConfig(cert, key string) (*tls.Config, error) {
-	if len(cert) > 0 {
-		tlscfg := &tls.Config{}
+// issue & renew the cert of 'ACME' for listeners enabled 'ACME'
+var acmeManager *certs.Manager
+
+func generateTLSConfig(lis *ServerListenConfig) (*tls.Config, error) {
+	tlscfg := &tls.Config{}
+	if lis.ACME && nil != acmeManager {
+		tlscfg.GetCertificate = acmeManager.GetCertificate
+	} else if len(lis.Cert) > 0 {
 		tlscfg.Certificates = make([]tls.Certificate, 1)
 		var err error
-		tlscfg.Certificates[0], err = tls.LoadX509KeyPair(cert, key)
-		return tlscfg, err
+		tlscfg.Certificates[0], err = tls.LoadX509KeyPair(lis.Cert, lis.Key)
+		if nil != err {
+			return nil, err
+		}
+	} else {
+		tlscfg = helper.GenerateTLSConfig()
 	}
-	return helper.GenerateTLSConfig(), nil
+	useTicketKeys(tlscfg)
+	return tlscfg, lis.TLS.Apply(tlscfg)
+}
+
+func startACME() {
+	if len(ServerConf.ACME.Domains) == 0 {
+		return
+	}
+	m, err := certs.NewManager(ServerConf.ACME)
+	if nil != err {
+		logger.Error("[ERROR]Failed to init acme for reason:%v", err)
+		return
+	}
+	acmeManager = m
+	acmeManager.Start()
 }
 
 func StartRemoteProxy() {
+	if err := store.Init(ServerConf.Store); nil != err {
+		logger.Error("Failed to init store:%s with reason:%v, use memory store instead.", ServerConf.Store, err)
+	}
+	channel.LoadAuthBans()
+	channel.LoadTrafficStats()
+	go startAdminServer()
+	startACME()
+	channel.SetReverseHairpinHosts(ServerConf.ReverseHairpin)
+	if len(ServerConf.ReverseHTTP) > 0 {
+		go channel.StartReverseHTTPServer(ServerConf.ReverseHTTP)
+	}
+	if err := channel.StartRuleDistribution(ServerConf.RuleDistribution); nil != err {
+		logger.Error("[ERROR]Failed to start rule distribution for reason:%v", err)
+	}
 	for _, lis := range ServerConf.Server {
+		lis := lis
 		u, err := url.Parse(lis.Listen)
 		if nil != err {
 			logger.Error("Invalid listen url:%s for reason:%v", lis.Listen, err)
@@ -35,9 +80,9 @@ func StartRemoteProxy() {
 		switch scheme {
 		case "quic":
 			{
-				tlscfg, err := generateTLSConfig(lis.Cert, lis.Key)
+				tlscfg, err := generateTLSConfig(&lis)
 				if nil != err {
-					logger.Error("Failed to create TLS config by cert/key: %s/%s", lis.Cert, lis.Key)
+					logger.Error("Failed to create TLS config by cert/key: %s/%s with reason:%v", lis.Cert, lis.Key, err)
 				} else {
 					go func() {
 						quic.StartQuicProxyServer(u.Host, tlscfg)
@@ -46,9 +91,14 @@ func StartRemoteProxy() {
 			}
 		case "kcp":
 			{
-				go func() {
-					kcp.StartKCPProxyServer(u.Host, &lis.KCParams)
-				}()
+				params, err := lis.KCParams.WithURL(u)
+				if nil != err {
+					logger.Error("[ERROR]Invalid kcp listen %s with reason:%v", lis.Listen, err)
+				} else {
+					go func() {
+						kcp.StartKCPProxyServer(u.Host, &params)
+					}()
+				}
 			}
 		case "tcp":
 			{
@@ -56,40 +106,87 @@ func StartRemoteProxy() {
 					tcp.StartTcpProxyServer(u.Host)
 				}()
 			}
+		case "dns":
+			{
+				zone := u.Query().Get("zone")
+				if len(zone) == 0 {
+					logger.Error("[ERROR]No 'zone' of dns tunnel listen %s", lis.Listen)
+				} else {
+					go func() {
+						dnstunnel.StartDNSTunnelServer(u.Host, zone)
+					}()
+				}
+			}
+		case "icmp":
+			{
+				go func() {
+					icmp.StartICMPTunnelServer(u.Hostname())
+				}()
+			}
 		case "tls":
 			{
-				tlscfg, err := generateTLSConfig(lis.Cert, lis.Key)
+				tlscfg, err := generateTLSConfig(&lis)
 				if nil != err {
-					logger.Error("Failed to create TLS config by cert/key: %s/%s", lis.Cert, lis.Key)
+					logger.Error("Failed to create TLS config by cert/key: %s/%s with reason:%v", lis.Cert, lis.Key, err)
 				} else {
 					go func() {
 						tcp.StartTLSProxyServer(u.Host, tlscfg)
 					}()
 				}
 			}
+		case "trojan":
+			{
+				tlscfg, err := generateTLSConfig(&lis)
+				if nil != err {
+					logger.Error("Failed to create TLS config by cert/key: %s/%s with reason:%v", lis.Cert, lis.Key, err)
+				} else {
+					go func() {
+						trojan.StartTrojanProxyServer(u.Host, u.Query().Get("fallback"), tlscfg)
+					}()
+				}
+			}
 		case "http":
 			{
 				go func() {
-					startHTTPProxyServer(u.Host, "", "")
+					startHTTPProxyServer(u.Host, nil)
 				}()
 			}
 		case "https":
 			{
+				//served as plain http without cert as before
+				var tlscfg *tls.Config
+				if len(lis.Cert) > 0 || lis.ACME {
+					if tlscfg, err = generateTLSConfig(&lis); nil != err {
+						logger.Error("Failed to create TLS config by cert/key: %s/%s with reason:%v", lis.Cert, lis.Key, err)
+						continue
+					}
+				}
 				go func() {
-					startHTTPProxyServer(u.Host, lis.Cert, lis.Key)
+					startHTTPProxyServer(u.Host, tlscfg)
 				}()
 			}
 		case "http2":
 			{
-				tlscfg, err := generateTLSConfig(lis.Cert, lis.Key)
+				tlscfg, err := generateTLSConfig(&lis)
 				if nil != err {
-					logger.Error("Failed to create TLS config by cert/key: %s/%s", lis.Cert, lis.Key)
+					logger.Error("Failed to create TLS config by cert/key: %s/%s with reason:%v", lis.Cert, lis.Key, err)
 				} else {
 					go func() {
 						http2.StartHTTTP2ProxyServer(u.Host, tlscfg)
 					}()
 				}
 			}
+		case "grpc":
+			{
+				tlscfg, err := generateTLSConfig(&lis)
+				if nil != err {
+					logger.Error("Failed to create TLS config by cert/key: %s/%s with reason:%v", lis.Cert, lis.Key, err)
+				} else {
+					go func() {
+						grpc.StartGRPCProxyServer(u.Host, u.Path, tlscfg)
+					}()
+				}
+			}
 		default:
 			logger.Error("Invalid listen scheme in listen url:%s", lis.Listen)
 		}
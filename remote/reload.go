@@ -0,0 +1,66 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// ConfigFile is the server config file loaded at startup
+var ConfigFile string
+
+// serialize reloads by SIGHUP & admin api
+var reloadMutex sync.Mutex
+
+// ReloadConf re-read the config file & swap users, ACLs, proxy/rate limits and client version limit,
+// listeners, ciphers & mux settings need restart, live sessions & streams are kept
+func ReloadConf() error {
+	if len(ConfigFile) == 0 {
+		return errors.New("No config file to reload")
+	}
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	data, err := helper.ReadWithoutComment(ConfigFile, "//")
+	if nil != err {
+		return err
+	}
+	var conf ServerConfig
+	if err = json.Unmarshal(data, &conf); nil != err {
+		return err
+	}
+	//users with their ACLs & the auth provider are swapped only if all of them are valid
+	if err = channel.SetAuthConfigs(conf.Users, conf.AuthProvider); nil != err {
+		return err
+	}
+	ServerConf.RateLimit = conf.RateLimit
+	ServerConf.ProxyLimit = conf.ProxyLimit
+	ServerConf.ClientVersion = conf.ClientVersion
+	ServerConf.DialRetry = conf.DialRetry
+	ServerConf.TargetKeepAlive = conf.TargetKeepAlive
+	ServerConf.SessionLimit = conf.SessionLimit
+	ServerConf.AuthBan = conf.AuthBan
+	ServerConf.AuditLog = conf.AuditLog
+	ServerConf.SpeedTest = conf.SpeedTest
+	ServerConf.Users = conf.Users
+	ServerConf.AuthProvider = conf.AuthProvider
+	helper.SetIPSets(ServerConf.ProxyLimit.IPSets)
+	channel.SetDefaultProxyLimitConfig(ServerConf.ProxyLimit)
+	channel.SetServerRateLimit(ServerConf.RateLimit)
+	channel.SetClientVersionLimit(ServerConf.ClientVersion)
+	channel.SetDialRetryConfig(ServerConf.DialRetry)
+	channel.SetTargetKeepAliveConfig(ServerConf.TargetKeepAlive)
+	channel.SetSessionLimitConfig(ServerConf.SessionLimit)
+	channel.SetAuthBanConfig(ServerConf.AuthBan)
+	if err := channel.SetAuditLogConfig(ServerConf.AuditLog); nil != err {
+		logger.Error("[ERROR]Failed to open audit log:%v", err)
+	}
+	if err := channel.SetSpeedTestConfig(ServerConf.SpeedTest); nil != err {
+		logger.Error("[ERROR]%v", err)
+	}
+	logger.Notice("Reload users, auth provider, proxy limit, rate limit, client version limit, dial retry, target keepalive, session limit, auth ban, audit log & speed test from config:%s", ConfigFile)
+	return nil
+}
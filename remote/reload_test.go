@@ -0,0 +1,81 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+)
+
+func TestReloadConf(t *testing.T) {
+	defer func(file string, conf ServerConfig) {
+		ConfigFile, ServerConf = file, conf
+		channel.SetAuthConfigs(nil, channel.AuthProviderConfig{})
+		channel.SetClientVersionLimit(channel.ClientVersionLimitConfig{})
+	}(ConfigFile, ServerConf)
+	dir, _ := ioutil.TempDir("", "reload")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "server.json")
+	h := sha256.Sum224([]byte("secret"))
+	trojan := hex.EncodeToString(h[:])
+
+	ConfigFile = ""
+	if err := ReloadConf(); nil == err {
+		t.Errorf("expect error without config file")
+	}
+	ConfigFile = file
+	ServerConf.Admin.Listen = ":48300"
+	tests := []struct {
+		name    string
+		content string
+		err     bool
+		user    string
+		version string
+	}{
+		{"missing", "", true, "", ""},
+		{"invalid json", `{"Users":[`, true, "", ""},
+		{"invalid user", `{"Users":[{"Name":"u1","TrojanPassword":"secret","SessionPolicy":"invalid"}],"ClientVersion":{"MinVersion":"1.0.0"}}`, true, "", ""},
+		{"valid", `{
+			//comments are allowed as the config loaded at startup
+			"Users":[{"Name":"u1","TrojanPassword":"secret"}],"ClientVersion":{"MinVersion":"1.0.0"},"Admin":{"Listen":":48301"}
+		}`, false, "u1", "1.0.0"},
+	}
+	for _, tt := range tests {
+		os.Remove(file)
+		if len(tt.content) > 0 {
+			ioutil.WriteFile(file, []byte(tt.content), 0644)
+		}
+		err := ReloadConf()
+		if (nil != err) != tt.err {
+			t.Errorf("%s: expect error %v, but got %v", tt.name, tt.err, err)
+		}
+		if user, _ := channel.TrojanUser(trojan); user != tt.user {
+			t.Errorf("%s: expect trojan user %q, but got %q", tt.name, tt.user, user)
+		}
+		if ServerConf.ClientVersion.MinVersion != tt.version {
+			t.Errorf("%s: expect min version %q, but got %q", tt.name, tt.version, ServerConf.ClientVersion.MinVersion)
+		}
+	}
+	//settings need restart are kept
+	if ServerConf.Admin.Listen != ":48300" || len(ServerConf.Users) != 1 {
+		t.Errorf("expect admin listen kept & users swapped, but got %+v", ServerConf)
+	}
+
+	w := httptest.NewRecorder()
+	adminReloadCallback(w, httptest.NewRequest("GET", "/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect %d of GET, but got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	os.Remove(file)
+	w = httptest.NewRecorder()
+	adminReloadCallback(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expect %d of failed reload, but got %d", http.StatusInternalServerError, w.Code)
+	}
+}
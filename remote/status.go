@@ -0,0 +1,126 @@
+package remote
+
+import (
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/channel"
+	"github.com/yinqiwen/gsnova/common/helper"
+	"github.com/yinqiwen/gsnova/common/logger"
+)
+
+// StatusPageConfig of the unauthenticated status page served by http listeners, only aggregate stats are shown
+type StatusPageConfig struct {
+	//path like '/status', empty disables, '<Path>.json' serves the same stats in json
+	Path string
+	//shown as the page title, default 'GSnova Server'
+	Title string
+}
+
+// PublicStatus is the content of the status page
+type PublicStatus struct {
+	Title      string
+	Version    string
+	UptimeSecs int64
+	Sessions   int
+	Streams    int
+	//bytes since server started
+	RecvBytes int64
+	SentBytes int64
+	//bytes including previous runs of the server
+	TotalRecvBytes int64
+	TotalSentBytes int64
+	//bytes per second in the latest sampling period
+	RecvRate int64
+	SentRate int64
+}
+
+var serverStartTime = time.Now()
+
+// sample traffic periodically, so that requests to the page cost nothing but a lookup
+const statusSamplePeriod = 10 * time.Second
+
+type statusSampler struct {
+	mutex  sync.Mutex
+	status PublicStatus
+}
+
+var publicStatus statusSampler
+var statusSamplerOnce sync.Once
+
+func (s *statusSampler) run() {
+	last := channel.GetTrafficStats()
+	for range time.Tick(statusSamplePeriod) {
+		st := channel.GetTrafficStats()
+		s.mutex.Lock()
+		s.status.Sessions, s.status.Streams = st.Sessions, st.Streams
+		s.status.RecvBytes, s.status.SentBytes = st.RecvBytes, st.SentBytes
+		s.status.TotalRecvBytes, s.status.TotalSentBytes = st.TotalRecvBytes, st.TotalSentBytes
+		s.status.RecvRate = (st.RecvBytes - last.RecvBytes) / int64(statusSamplePeriod/time.Second)
+		s.status.SentRate = (st.SentBytes - last.SentBytes) / int64(statusSamplePeriod/time.Second)
+		s.mutex.Unlock()
+		last = st
+	}
+}
+
+func (s *statusSampler) get() PublicStatus {
+	s.mutex.Lock()
+	st := s.status
+	s.mutex.Unlock()
+	st.Title = ServerConf.StatusPage.Title
+	if len(st.Title) == 0 {
+		st.Title = "GSnova Server"
+	}
+	st.Version = channel.Version
+	st.UptimeSecs = int64(time.Now().Sub(serverStartTime) / time.Second)
+	return st
+}
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"bytes": func(n int64) string { return helper.ByteSize(uint64(n)) },
+	"uptime": func(secs int64) string {
+		return (time.Duration(secs) * time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"/><meta http-equiv="refresh" content="30"/><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><td>Version</td><td>{{.Version}}</td></tr>
+<tr><td>Uptime</td><td>{{uptime .UptimeSecs}}</td></tr>
+<tr><td>Sessions</td><td>{{.Sessions}}</td></tr>
+<tr><td>Streams</td><td>{{.Streams}}</td></tr>
+<tr><td>Throughput</td><td>up {{bytes .RecvRate}}/s, down {{bytes .SentRate}}/s</td></tr>
+<tr><td>Traffic</td><td>up {{bytes .RecvBytes}}, down {{bytes .SentBytes}}</td></tr>
+<tr><td>Total traffic</td><td>up {{bytes .TotalRecvBytes}}, down {{bytes .TotalSentBytes}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+func statusPageCallback(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPageTemplate.Execute(w, publicStatus.get()); nil != err {
+		logger.Error("[ERROR]Failed to render status page:%v", err)
+	}
+}
+
+func statusJSONCallback(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, publicStatus.get())
+}
+
+// registerStatusPage serve the status page on the http listener if configured
+func registerStatusPage(mux *http.ServeMux) {
+	path := ServerConf.StatusPage.Path
+	if len(path) == 0 {
+		return
+	}
+	statusSamplerOnce.Do(func() {
+		go publicStatus.run()
+	})
+	mux.HandleFunc(path, statusPageCallback)
+	mux.HandleFunc(path+".json", statusJSONCallback)
+}
@@ -0,0 +1,106 @@
+package remote
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/store"
+)
+
+// session ticket keys are shared by all tls listeners & persisted in the store, so that clients resume
+// tls sessions(and send quic 0-RTT data) across restarts
+const ticketStoreBucket = "tls_ticket"
+const ticketKeysKey = "keys"
+
+// the newest key encrypts tickets, older ones only decrypt tickets issued before rotations
+const ticketKeyRotatePeriod = 24 * time.Hour
+const maxTicketKeys = 7
+
+type ticketKey struct {
+	Key     []byte
+	Created int64
+}
+
+// ticketKeyHolder encrypt & decrypt tickets for configs of listeners, which may be cloned by transports
+var ticketKeyHolder = &tls.Config{}
+var ticketKeys []ticketKey
+var ticketKeysMutex sync.Mutex
+var ticketKeysOnce sync.Once
+
+func loadTicketKeys() {
+	b, err := store.Default().Get(ticketStoreBucket, ticketKeysKey)
+	if nil == err {
+		err = json.Unmarshal(b, &ticketKeys)
+	}
+	if nil != err && err != store.ErrNotFound {
+		logger.Error("[ERROR]Failed to load tls ticket keys with reason:%v", err)
+	}
+	var valid []ticketKey
+	for _, k := range ticketKeys {
+		if len(k.Key) == 32 {
+			valid = append(valid, k)
+		}
+	}
+	ticketKeys = valid
+}
+
+// applyTicketKeys set the keys on the holder, ticketKeysMutex must be held
+func applyTicketKeys() {
+	if len(ticketKeys) == 0 {
+		return
+	}
+	keys := make([][32]byte, len(ticketKeys))
+	for i := range ticketKeys {
+		copy(keys[i][:], ticketKeys[i].Key)
+	}
+	ticketKeyHolder.SetSessionTicketKeys(keys)
+}
+
+// rotateTicketKeys add a new key once the newest one is older than the rotate period
+func rotateTicketKeys(now time.Time) {
+	ticketKeysMutex.Lock()
+	defer ticketKeysMutex.Unlock()
+	if len(ticketKeys) > 0 && now.Sub(time.Unix(ticketKeys[0].Created, 0)) < ticketKeyRotatePeriod {
+		return
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); nil != err {
+		logger.Error("[ERROR]Failed to generate tls ticket key with reason:%v", err)
+		return
+	}
+	ticketKeys = append([]ticketKey{{Key: key, Created: now.Unix()}}, ticketKeys...)
+	if len(ticketKeys) > maxTicketKeys {
+		ticketKeys = ticketKeys[:maxTicketKeys]
+	}
+	applyTicketKeys()
+	b, _ := json.Marshal(ticketKeys)
+	if err := store.Default().Put(ticketStoreBucket, ticketKeysKey, b); nil != err {
+		logger.Error("[ERROR]Failed to save tls ticket keys with reason:%v", err)
+	}
+}
+
+// useTicketKeys let the config issue & accept tickets by the shared keys
+func useTicketKeys(cfg *tls.Config) {
+	ticketKeysOnce.Do(func() {
+		ticketKeysMutex.Lock()
+		loadTicketKeys()
+		applyTicketKeys()
+		ticketKeysMutex.Unlock()
+		rotateTicketKeys(time.Now())
+		go func() {
+			for now := range time.Tick(time.Hour) {
+				rotateTicketKeys(now)
+			}
+		}()
+	})
+	cfg.WrapSession = func(cs tls.ConnectionState, ss *tls.SessionState) ([]byte, error) {
+		return ticketKeyHolder.EncryptTicket(cs, ss)
+	}
+	cfg.UnwrapSession = func(identity []byte, cs tls.ConnectionState) (*tls.SessionState, error) {
+		return ticketKeyHolder.DecryptTicket(identity, cs)
+	}
+}
@@ -1,6 +1,7 @@
 package remote
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	httpChannel "github.com/yinqiwen/gsnova/common/channel/http"
 	"github.com/yinqiwen/gsnova/common/channel/websocket"
 	"github.com/yinqiwen/gsnova/common/logger"
+	"github.com/yinqiwen/gsnova/common/mux"
 )
 
 // hello world, the web server
@@ -22,6 +24,7 @@ func statCallback(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(200)
 	fmt.Fprintf(w, "Version:    %s\n", channel.Version)
 	ots.Handle("stat", w)
+	mux.DumpCompressStats(w)
 }
 
 func stackdumpCallback(w http.ResponseWriter, req *http.Request) {
@@ -29,7 +32,7 @@ func stackdumpCallback(w http.ResponseWriter, req *http.Request) {
 	ots.Handle("stackdump", w)
 }
 
-func startHTTPProxyServer(listenAddr string, cert, key string) {
+func startHTTPProxyServer(listenAddr string, tlscfg *tls.Config) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", indexCallback)
 	mux.HandleFunc("/stat", statCallback)
@@ -38,13 +41,15 @@ func startHTTPProxyServer(listenAddr string, cert, key string) {
 	mux.HandleFunc("/http/pull", httpChannel.HTTPInvoke)
 	mux.HandleFunc("/http/push", httpChannel.HTTPInvoke)
 	mux.HandleFunc("/http/test", httpChannel.HttpTest)
+	registerStatusPage(mux)
 
 	logger.Info("Listen on HTTP address:%s", listenAddr)
 	var err error
-	if len(cert) == 0 {
+	if nil == tlscfg {
 		err = http.ListenAndServe(listenAddr, mux)
 	} else {
-		err = http.ListenAndServeTLS(listenAddr, cert, key, mux)
+		server := &http.Server{Addr: listenAddr, Handler: mux, TLSConfig: tlscfg}
+		err = server.ListenAndServeTLS("", "")
 	}
 
 	if nil != err {